@@ -0,0 +1,356 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package audit records the usage of keys in a tamper-evident log.
+//
+// Each entry contains the hash of its predecessor so that removed or altered
+// entries break the chain. The head of the chain is periodically sealed by
+// a signature of a hardware key so that the chain can not be rewritten as a whole.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"cunicu.li/hawkes/metrics"
+	"cunicu.li/hawkes/timesync"
+)
+
+var (
+	ErrTampered = errors.New("audit log has been tampered with")
+	ErrClosed   = errors.New("audit log is closed")
+)
+
+// DefaultSealEvery is the number of entries after which the chain is sealed.
+const DefaultSealEvery = 100
+
+// OperationSeal is the operation of entries which seal the chain.
+const OperationSeal = "seal"
+
+// Entry is a single record of the audit log.
+type Entry struct {
+	// Seq is the position of the entry in the log starting at 1.
+	Seq uint64 `json:"seq"`
+
+	Time      time.Time       `json:"time"`
+	Operation string          `json:"op"`
+	Key       string          `json:"key,omitempty"`
+	Caller    string          `json:"caller,omitempty"`
+	Outcome   metrics.Outcome `json:"outcome,omitempty"`
+	Error     string          `json:"error,omitempty"`
+
+	// Sealed is the sequence number of the entry whose hash is signed by a seal entry.
+	Sealed uint64 `json:"sealed,omitempty"`
+
+	// Signature is the signature of a seal entry over the hash of the sealed entry.
+	Signature []byte `json:"signature,omitempty"`
+
+	// Prev is the hash of the preceding entry.
+	Prev []byte `json:"prev"`
+
+	// Hash is the SHA-256 digest over the JSON encoding of the entry without its hash.
+	Hash []byte `json:"hash"`
+}
+
+func (e *Entry) digest() ([]byte, error) {
+	f := *e
+	f.Hash = nil
+
+	b, err := json.Marshal(&f)
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.Sum256(b)
+
+	return h[:], nil
+}
+
+// Log appends entries to an audit log.
+type Log struct {
+	// Sealer signs the head of the chain, e.g. a signer of a hardware key.
+	// The chain is not sealed if nil.
+	// It must not be changed after the first record (see SetSealer).
+	Sealer crypto.Signer
+
+	// SealEvery is the number of entries after which the chain is sealed.
+	SealEvery int
+
+	// Caller identifies the process using the keys.
+	Caller string
+
+	// Clock is the time source. Defaults to timesync.System.
+	Clock timesync.Clock
+
+	mu       sync.Mutex
+	w        io.Writer
+	closer   io.Closer
+	seq      uint64
+	head     []byte
+	unsealed int
+	sealing  bool
+}
+
+// New creates a log which writes a new chain of entries as JSON lines to w.
+func New(w io.Writer) *Log {
+	return &Log{
+		SealEvery: DefaultSealEvery,
+		Caller:    DefaultCaller(),
+		w:         w,
+		head:      make([]byte, sha256.Size),
+	}
+}
+
+// Open opens or creates an append-only log file and continues its chain.
+// The chain of existing entries is checked but their seals are not verified.
+// Entries after the last seal, e.g. of a process which has been killed, are sealed
+// together with the next entries.
+func Open(path string) (*Log, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	l := New(f)
+	l.closer = f
+
+	last, err := scan(f, func(e *Entry) error {
+		if e.Operation == OperationSeal {
+			// Entries recorded while signing remain unsealed
+			l.unsealed = int(e.Seq - e.Sealed - 1) //nolint:gosec
+		} else {
+			l.unsealed++
+		}
+
+		return nil
+	})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if last != nil {
+		l.seq = last.Seq
+		l.head = last.Hash
+	}
+
+	return l, nil
+}
+
+// SetSealer sets the signer which seals the head of the chain.
+func (l *Log) SetSealer(s crypto.Signer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.Sealer = s
+}
+
+// DefaultCaller identifies the current process by its executable, PID and user.
+func DefaultCaller() string {
+	return fmt.Sprintf("%s[%d] uid=%d", filepath.Base(os.Args[0]), os.Getpid(), os.Getuid())
+}
+
+// Record appends an entry for an operation using a key.
+// The chain is sealed if SealEvery entries have been recorded since the last seal.
+func (l *Log) Record(operation, key string, opErr error) error {
+	e := &Entry{
+		Operation: operation,
+		Key:       key,
+		Caller:    l.Caller,
+		Outcome:   metrics.OutcomeOf(opErr),
+	}
+
+	if opErr != nil {
+		e.Error = opErr.Error()
+	}
+
+	l.mu.Lock()
+
+	if err := l.append(e); err != nil {
+		l.mu.Unlock()
+		return err
+	}
+
+	l.unsealed++
+
+	// Signatures of the sealer may be recorded themselves
+	seal := l.Sealer != nil && l.SealEvery > 0 && l.unsealed >= l.SealEvery && !l.sealing
+
+	l.mu.Unlock()
+
+	if seal {
+		return l.Seal()
+	}
+
+	return nil
+}
+
+// Seal signs the hash of the last entry and appends the signature as seal entry.
+func (l *Log) Seal() error {
+	l.mu.Lock()
+
+	if l.Sealer == nil || l.sealing || l.unsealed == 0 {
+		l.mu.Unlock()
+		return nil
+	}
+
+	l.sealing = true
+	sealer, seq, head := l.Sealer, l.seq, l.head
+
+	l.mu.Unlock()
+
+	sig, err := sign(sealer, head)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sealing = false
+
+	if err != nil {
+		return fmt.Errorf("failed to seal audit log: %w", err)
+	}
+
+	if err := l.append(&Entry{
+		Operation: OperationSeal,
+		Caller:    l.Caller,
+		Sealed:    seq,
+		Signature: sig,
+	}); err != nil {
+		return err
+	}
+
+	// Entries recorded while signing remain unsealed
+	l.unsealed = int(l.seq - seq - 1) //nolint:gosec
+
+	return nil
+}
+
+// Close seals pending entries and closes the log file if it has been opened by Open.
+// Closing a closed log has no effect.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	closed := l.w == nil
+	l.mu.Unlock()
+
+	if closed {
+		return nil
+	}
+
+	if err := l.Seal(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.w == nil {
+		return nil
+	}
+
+	l.w = nil
+
+	if l.closer != nil {
+		return l.closer.Close()
+	}
+
+	return nil
+}
+
+func (l *Log) append(e *Entry) (err error) {
+	if l.w == nil {
+		return ErrClosed
+	}
+
+	e.Seq = l.seq + 1
+	e.Time = timesync.Now(l.Clock).UTC()
+	e.Prev = l.head
+
+	if e.Hash, err = e.digest(); err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	if _, err := l.w.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+
+	l.seq = e.Seq
+	l.head = e.Hash
+
+	return nil
+}
+
+func sign(signer crypto.Signer, digest []byte) ([]byte, error) {
+	// Ed25519 signs the message itself
+	if _, ok := signer.Public().(ed25519.PublicKey); ok {
+		return signer.Sign(rand.Reader, digest, crypto.Hash(0))
+	}
+
+	return signer.Sign(rand.Reader, digest, crypto.SHA256)
+}
+
+// scan decodes the entries of a log, checks their chain and returns the last entry.
+func scan(r io.Reader, cb func(*Entry) error) (last *Entry, err error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	head := make([]byte, sha256.Size)
+
+	for s.Scan() {
+		e := &Entry{}
+		if err := json.Unmarshal(s.Bytes(), e); err != nil {
+			return nil, fmt.Errorf("%w: malformed entry after %d: %w", ErrTampered, seqOf(last), err)
+		}
+
+		digest, err := e.digest()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case e.Seq != seqOf(last)+1:
+			return nil, fmt.Errorf("%w: entry %d follows %d", ErrTampered, e.Seq, seqOf(last))
+		case !bytes.Equal(e.Prev, head):
+			return nil, fmt.Errorf("%w: entry %d does not link to its predecessor", ErrTampered, e.Seq)
+		case !bytes.Equal(e.Hash, digest):
+			return nil, fmt.Errorf("%w: entry %d has been modified", ErrTampered, e.Seq)
+		}
+
+		if err := cb(e); err != nil {
+			return nil, err
+		}
+
+		head = e.Hash
+		last = e
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	return last, nil
+}
+
+func seqOf(e *Entry) uint64 {
+	if e == nil {
+		return 0
+	}
+
+	return e.Seq
+}
@@ -0,0 +1,171 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package audit_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/audit"
+)
+
+func TestLog(t *testing.T) {
+	require := require.New(t)
+
+	sk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	buf := &bytes.Buffer{}
+
+	l := audit.New(buf)
+	l.Sealer = sk
+	l.SealEvery = 3
+	l.Caller = "test"
+
+	for i := range 7 {
+		var opErr error
+		if i == 4 {
+			opErr = errors.New("touch timeout") //nolint:err113
+		}
+
+		require.NoError(l.Record("sign", "ykoath:abc", opErr))
+	}
+
+	// Seals after the third and sixth record and on close
+	require.NoError(l.Close())
+	require.ErrorIs(l.Record("sign", "ykoath:abc", nil), audit.ErrClosed)
+
+	log := buf.String()
+
+	res, err := audit.Verify(strings.NewReader(log), sk.Public())
+	require.NoError(err)
+	require.Equal(uint64(10), res.Entries)
+	require.Equal(uint64(9), res.Sealed)
+
+	lines := strings.SplitAfter(log, "\n")
+	require.Contains(lines[5], `"outcome":"error","error":"touch timeout"`)
+
+	// Modified entries
+	tampered := strings.Replace(log, `"outcome":"error"`, `"outcome":"success"`, 1)
+	_, err = audit.Verify(strings.NewReader(tampered), sk.Public())
+	require.ErrorIs(err, audit.ErrTampered)
+
+	// Removed entries
+	_, err = audit.Verify(strings.NewReader(strings.Join(append(lines[:1:1], lines[2:]...), "")), sk.Public())
+	require.ErrorIs(err, audit.ErrTampered)
+
+	// Seals by another key
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(err)
+
+	_, err = audit.Verify(strings.NewReader(log), other)
+	require.ErrorIs(err, audit.ErrTampered)
+
+	// Truncated logs remain valid up to the last seal
+	res, err = audit.Verify(strings.NewReader(strings.Join(lines[:8], "")), sk.Public())
+	require.NoError(err)
+	require.Equal(uint64(8), res.Entries)
+	require.Equal(uint64(7), res.Sealed)
+
+	// Logs truncated before the first seal or rewritten without seals are not valid
+	res, err = audit.Verify(strings.NewReader(strings.Join(lines[:3], "")), sk.Public())
+	require.ErrorIs(err, audit.ErrNotSealed)
+	require.Equal(uint64(3), res.Entries)
+}
+
+func TestUnsealed(t *testing.T) {
+	require := require.New(t)
+
+	sk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	buf := &bytes.Buffer{}
+
+	l := audit.New(buf)
+
+	require.NoError(l.Record("dh", "File:abc", nil))
+	require.NoError(l.Close())
+	require.NoError(l.Close())
+
+	_, err = audit.Verify(bytes.NewReader(buf.Bytes()), sk.Public())
+	require.ErrorIs(err, audit.ErrNotSealed)
+
+	_, err = audit.Verify(strings.NewReader(""), sk.Public())
+	require.ErrorIs(err, audit.ErrNotSealed)
+}
+
+func TestOpen(t *testing.T) {
+	require := require.New(t)
+
+	_, sk, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(err)
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	for range 2 {
+		l, err := audit.Open(path)
+		require.NoError(err)
+
+		l.Sealer = sk
+
+		require.NoError(l.Record("hmac", "File:abc", nil))
+		require.NoError(l.Close())
+	}
+
+	f, err := os.Open(path)
+	require.NoError(err)
+
+	defer f.Close()
+
+	res, err := audit.Verify(f, sk.Public())
+	require.NoError(err)
+	require.Equal(uint64(4), res.Entries)
+	require.Equal(uint64(3), res.Sealed)
+}
+
+func TestOpenUnsealed(t *testing.T) {
+	require := require.New(t)
+
+	_, sk, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(err)
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	// The process is killed before closing the log
+	l, err := audit.Open(path)
+	require.NoError(err)
+
+	l.Sealer = sk
+
+	require.NoError(l.Record("sign", "File:abc", nil))
+	require.NoError(l.Record("sign", "File:abc", nil))
+
+	// The entries of the killed process are sealed by the next one
+	l, err = audit.Open(path)
+	require.NoError(err)
+
+	l.Sealer = sk
+
+	require.NoError(l.Close())
+
+	f, err := os.Open(path)
+	require.NoError(err)
+
+	defer f.Close()
+
+	res, err := audit.Verify(f, sk.Public())
+	require.NoError(err)
+	require.Equal(uint64(3), res.Entries)
+	require.Equal(uint64(2), res.Sealed)
+}
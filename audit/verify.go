@@ -0,0 +1,98 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package audit
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrUnsupportedKey = errors.New("unsupported public key")
+	ErrNotSealed      = errors.New("audit log has no valid seal")
+)
+
+// Result summarizes a verified log.
+type Result struct {
+	// Entries is the number of entries in the log including seals.
+	Entries uint64
+
+	// Sealed is the sequence number of the last entry covered by a valid seal.
+	// Entries after it are chained but may have been truncated unnoticed.
+	Sealed uint64
+}
+
+// Verify checks the chain of all entries of a log as well as the signatures
+// of its seals by the public key of the sealer.
+// A chain alone can be rewritten as a whole. Logs without any seal are therefore
+// not considered valid and ErrNotSealed is returned together with the result.
+func Verify(r io.Reader, pub crypto.PublicKey) (*Result, error) {
+	res := &Result{}
+	hashes := map[uint64][]byte{}
+
+	if _, err := scan(r, func(e *Entry) error {
+		res.Entries = e.Seq
+
+		if e.Operation == OperationSeal {
+			sealed, ok := hashes[e.Sealed]
+			if !ok {
+				return fmt.Errorf("%w: seal %d references unknown entry %d", ErrTampered, e.Seq, e.Sealed)
+			}
+
+			if err := verifySignature(pub, sealed, e.Signature); err != nil {
+				return fmt.Errorf("%w: seal %d: %w", ErrTampered, e.Seq, err)
+			}
+
+			res.Sealed = e.Sealed
+
+			// Hashes of sealed entries are not needed anymore
+			for seq := range hashes {
+				if seq <= e.Sealed {
+					delete(hashes, seq)
+				}
+			}
+		}
+
+		hashes[e.Seq] = e.Hash
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if res.Sealed == 0 {
+		return res, ErrNotSealed
+	}
+
+	return res, nil
+}
+
+func verifySignature(pub crypto.PublicKey, digest, sig []byte) error {
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, digest, sig) {
+			return errors.New("invalid signature") //nolint:err113
+		}
+
+		return nil
+
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest, sig)
+
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, digest, sig) {
+			return errors.New("invalid signature") //nolint:err113
+		}
+
+		return nil
+
+	default:
+		return fmt.Errorf("%w: %T", ErrUnsupportedKey, pub)
+	}
+}
@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package broker shares exclusive PC/SC connections between processes.
+//
+// A single broker process owns the connections to the smart cards and
+// serializes the operations of its clients. This avoids sharing violations
+// when multiple processes such as a daemon and the CLI access the same card.
+package broker
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	ErrInvalidCard    = errors.New("invalid card index")
+	ErrAlreadyLocked  = errors.New("card is already locked by this client")
+	ErrNotLocked      = errors.New("card is not locked by this client")
+	ErrUnknownRequest = errors.New("unknown request")
+)
+
+type op int
+
+const (
+	opList op = iota
+	opLock
+	opUnlock
+	opTransmit
+	opHealth
+)
+
+type request struct {
+	Op      op
+	Card    int
+	Data    []byte
+	Timeout time.Duration
+}
+
+type response struct {
+	Data    []byte
+	Readers []string
+	Health  []CardHealth
+	Err     string
+}
+
+// CardHealth is the result of the health check of a card of the broker.
+type CardHealth struct {
+	Reader string `json:"reader"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Healthy returns true if the card passed its health check.
+func (h CardHealth) Healthy() bool {
+	return h.Error == ""
+}
+
+// DefaultPath returns the default path of the broker socket.
+func DefaultPath() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+
+	return filepath.Join(dir, "hawkes", "broker.sock")
+}
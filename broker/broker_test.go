@@ -0,0 +1,456 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package broker_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cunicu.li/go-iso7816"
+	"cunicu.li/go-iso7816/encoding/tlv"
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/broker"
+	"cunicu.li/hawkes/internal/queue"
+)
+
+// echoCard responds to each APDU with the APDU itself.
+type echoCard struct {
+	mu  sync.Mutex
+	log [][]byte
+}
+
+func (c *echoCard) Transmit(cmd []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.log = append(c.log, cmd)
+
+	return append(cmd, 0x90, 0x00), nil
+}
+
+func (c *echoCard) BeginTransaction() error     { return nil }
+func (c *echoCard) EndTransaction() error       { return nil }
+func (c *echoCard) Close() error                { return nil }
+func (c *echoCard) Base() iso7816.PCSCCard      { return c }
+func (c *echoCard) Reader() string              { return "Echo Reader" }
+func (c *echoCard) Metadata() map[string]string { return nil }
+
+func startBroker(t *testing.T, card iso7816.PCSCCard) string {
+	return startServer(t, broker.NewServer([]iso7816.PCSCCard{card}), true)
+}
+
+func startServer(t *testing.T, srv *broker.Server, wait bool) string {
+	path := filepath.Join(t.TempDir(), "broker.sock")
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error)
+
+	go func() {
+		done <- srv.ListenAndServe(ctx, path)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		require.NoError(t, <-done)
+	})
+
+	if !wait {
+		require.Eventually(t, func() bool {
+			_, err := os.Stat(path)
+			return err == nil
+		}, time.Second, 10*time.Millisecond)
+
+		return path
+	}
+
+	require.Eventually(t, func() bool {
+		cards, err := broker.OpenCards(path, nil)
+		for _, card := range cards {
+			card.Close()
+		}
+
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	return path
+}
+
+func TestTransmit(t *testing.T) {
+	require := require.New(t)
+
+	path := startBroker(t, &echoCard{})
+
+	cards, err := broker.OpenCards(path, nil)
+	require.NoError(err)
+	require.Len(cards, 1)
+
+	defer cards[0].Close()
+
+	require.Equal("Echo Reader", cards[0].(iso7816.ReaderCard).Reader()) //nolint:forcetypeassert
+
+	resp, err := cards[0].Transmit([]byte{0x01, 0x02})
+	require.NoError(err)
+	require.Equal([]byte{0x01, 0x02, 0x90, 0x00}, resp)
+}
+
+func TestHealth(t *testing.T) {
+	require := require.New(t)
+
+	var absent atomic.Bool
+
+	srv := broker.NewServer([]iso7816.PCSCCard{&echoCard{}})
+	srv.Ping = func(iso7816.PCSCCard) error {
+		if absent.Load() {
+			return errors.New("card has been removed") //nolint:err113
+		}
+
+		return nil
+	}
+
+	path := startServer(t, srv, true)
+
+	health, err := broker.Health(path)
+	require.NoError(err)
+	require.Equal([]broker.CardHealth{{Reader: "Echo Reader"}}, health)
+	require.True(health[0].Healthy())
+
+	// Health checks are not blocked by clients holding a card
+	cards, err := broker.OpenCards(path, nil)
+	require.NoError(err)
+
+	c := queue.NewCard(cards[0], 0)
+	defer c.Close()
+
+	err = c.Do(context.Background(), func(context.Context) error {
+		absent.Store(true)
+
+		health, err = broker.Health(path)
+		return err
+	})
+	require.NoError(err)
+	require.False(health[0].Healthy())
+	require.Equal("card has been removed", health[0].Error)
+}
+
+func TestSerialize(t *testing.T) {
+	require := require.New(t)
+
+	ec := &echoCard{}
+	path := startBroker(t, ec)
+
+	cardsA, err := broker.OpenCards(path, nil)
+	require.NoError(err)
+
+	cardsB, err := broker.OpenCards(path, nil)
+	require.NoError(err)
+
+	a := queue.NewCard(cardsA[0], 0)
+	b := queue.NewCard(cardsB[0], 0)
+
+	defer a.Close()
+	defer b.Close()
+
+	locked := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+
+	go func() {
+		done <- a.Do(context.Background(), func(context.Context) error {
+			if _, err := a.Transmit([]byte{0x01}); err != nil {
+				return err
+			}
+
+			close(locked)
+			<-release
+
+			_, err := a.Transmit([]byte{0x02})
+			return err
+		})
+	}()
+
+	<-locked
+
+	// B must wait until A has completed its operation
+	go func() {
+		_, err := b.Transmit([]byte{0x03})
+		done <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	require.NoError(<-done)
+	require.NoError(<-done)
+
+	require.Equal([][]byte{{0x01}, {0x02}, {0x03}}, ec.log)
+}
+
+func TestReleaseOnDisconnect(t *testing.T) {
+	require := require.New(t)
+
+	path := startBroker(t, &echoCard{})
+
+	cardsA, err := broker.OpenCards(path, nil)
+	require.NoError(err)
+
+	a := cardsA[0].(*broker.Card) //nolint:forcetypeassert
+	require.NoError(a.Lock(context.Background()))
+	require.NoError(a.Close())
+
+	cardsB, err := broker.OpenCards(path, nil)
+	require.NoError(err)
+
+	b := cardsB[0].(*broker.Card) //nolint:forcetypeassert
+	defer b.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	require.NoError(b.Lock(ctx))
+	require.NoError(b.Unlock())
+}
+
+func TestAuthorize(t *testing.T) {
+	require := require.New(t)
+
+	srv := broker.NewServer([]iso7816.PCSCCard{&echoCard{}})
+	srv.Authorize = broker.AllowCallers(broker.Caller{
+		UIDs: []int{os.Getuid()},
+	})
+
+	path := startServer(t, srv, true)
+
+	cards, err := broker.OpenCards(path, nil)
+	require.NoError(err)
+	require.Len(cards, 1)
+	require.NoError(cards[0].Close())
+
+	srv = broker.NewServer([]iso7816.PCSCCard{&echoCard{}})
+	srv.Authorize = broker.AllowCallers(broker.Caller{
+		Executables: []string{"/usr/bin/ssh"},
+	})
+
+	path = startServer(t, srv, false)
+
+	_, err = broker.OpenCards(path, nil)
+	require.ErrorContains(err, broker.ErrCallerNotAllowed.Error())
+}
+
+func TestPeerOf(t *testing.T) {
+	require := require.New(t)
+
+	l, err := net.Listen("unix", filepath.Join(t.TempDir(), "peer.sock"))
+	require.NoError(err)
+
+	defer l.Close()
+
+	c, err := net.Dial("unix", l.Addr().String())
+	require.NoError(err)
+
+	defer c.Close()
+
+	sc, err := l.Accept()
+	require.NoError(err)
+
+	defer sc.Close()
+
+	p, err := broker.PeerOf(sc)
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("Peer credentials are not supported on this platform")
+	}
+
+	require.NoError(err)
+	require.Equal(os.Getpid(), p.PID)
+	require.Equal(os.Getuid(), p.UID)
+
+	exe, err := os.Executable()
+	require.NoError(err)
+	require.Equal(exe, p.Executable)
+
+	require.NoError(broker.AllowCallers(broker.Caller{Executables: []string{filepath.Dir(exe) + "/*"}})(p))
+	require.NoError(broker.AllowCallers(broker.Caller{})(p))
+	require.ErrorIs(broker.AllowCallers()(p), broker.ErrCallerNotAllowed)
+	require.ErrorIs(broker.AllowCallers(broker.Caller{UIDs: []int{os.Getuid() + 1}})(p), broker.ErrCallerNotAllowed)
+}
+
+// oathCard emulates the YKOATH applet.
+type oathCard struct {
+	echoCard
+
+	secrets  map[string][]byte
+	types    map[string]byte
+	selected bool
+
+	// Number of calculations by credential name
+	calculated map[string]int
+}
+
+func (c *oathCard) Transmit(cmd []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var data []byte
+	if len(cmd) > 5 {
+		data = cmd[5 : 5+int(cmd[4])]
+	}
+
+	ok := []byte{0x90, 0x00}
+
+	switch {
+	case cmd[1] == 0xA4 && cmd[2] == 0x04:
+		c.selected = bytes.Equal(data, iso7816.AidYubicoOATH)
+		return ok, nil
+
+	case !c.selected:
+		return []byte{0x6d, 0x00}, nil
+
+	case cmd[1] == 0xA1:
+		tvs := []tlv.TagValue{}
+		for name, typ := range c.types {
+			tvs = append(tvs, tlv.New(0x72, append([]byte{typ}, name...)))
+		}
+
+		resp, err := tlv.EncodeSimple(tvs...)
+		return append(resp, ok...), err
+
+	case cmd[1] == 0xA2:
+		tvs, err := tlv.DecodeSimple(data)
+		if err != nil {
+			return nil, err
+		}
+
+		name, _, _ := tvs.Get(0x71)
+		challenge, _, _ := tvs.Get(0x74)
+
+		c.calculated[string(name)]++
+
+		mac := hmac.New(sha256.New, c.secrets[string(name)])
+		mac.Write(challenge)
+
+		resp, err := tlv.EncodeSimple(tlv.New(0x75, append([]byte{6}, mac.Sum(nil)...)))
+		return append(resp, ok...), err
+
+	default:
+		return ok, nil
+	}
+}
+
+func calculateCommand(t *testing.T, ins iso7816.Instruction, name, challenge string) []byte {
+	tvs := []tlv.TagValue{}
+	if name != "" {
+		tvs = append(tvs, tlv.New(0x71, []byte(name)))
+	}
+
+	data, err := tlv.EncodeSimple(append(tvs, tlv.New(0x74, []byte(challenge)))...)
+	require.NoError(t, err)
+
+	cmd, err := (&iso7816.CAPDU{Ins: ins, Data: data}).Bytes()
+	require.NoError(t, err)
+
+	return cmd
+}
+
+func TestUsage(t *testing.T) {
+	require := require.New(t)
+
+	card := &oathCard{
+		secrets: map[string][]byte{
+			"key":   []byte("secret"),
+			"other": []byte("other secret"),
+			"hotp":  []byte("hotp secret"),
+		},
+		types: map[string]byte{
+			"key":   0x22,
+			"other": 0x22,
+			"hotp":  0x12,
+		},
+		calculated: map[string]int{},
+	}
+
+	var (
+		uses []*broker.Usage
+		deny bool
+	)
+
+	srv := broker.NewServer([]iso7816.PCSCCard{card})
+	srv.Usage = func(u *broker.Usage) error {
+		uses = append(uses, u)
+
+		if deny {
+			return errors.New("denied") //nolint:err113
+		}
+
+		return nil
+	}
+
+	path := startServer(t, srv, true)
+
+	cards, err := broker.OpenCards(path, nil)
+	require.NoError(err)
+	require.Len(cards, 1)
+
+	defer cards[0].Close()
+
+	id := func(name string) []byte {
+		mac := hmac.New(sha256.New, card.secrets[name])
+		mac.Write([]byte("hawkes/v1"))
+		return mac.Sum(nil)
+	}
+
+	calculate := func(name, challenge string) error {
+		_, err := cards[0].Transmit(calculateCommand(t, 0xA2, name, challenge))
+		return err
+	}
+
+	sel, err := (&iso7816.CAPDU{Ins: iso7816.InsSelect, P1: 0x04, Data: iso7816.AidYubicoOATH}).Bytes()
+	require.NoError(err)
+
+	_, err = cards[0].Transmit(sel)
+	require.NoError(err)
+
+	// Identifications are no uses
+	require.NoError(calculate("key", "hawkes/v1"))
+	require.Empty(uses)
+
+	require.NoError(calculate("key", "challenge"))
+	require.Len(uses, 1)
+	require.Equal(broker.OperationHMAC, uses[0].Operation)
+	require.Equal("Echo Reader", uses[0].Reader)
+	require.Equal(id("key"), uses[0].Key)
+	require.Equal(2, card.calculated["key"])
+
+	// Keys which have not been identified by the client are identified by the broker
+	require.NoError(calculate("other", "challenge"))
+	require.Len(uses, 2)
+	require.Equal(id("other"), uses[1].Key)
+	require.Equal(2, card.calculated["other"])
+
+	// Other credentials are not used for identification
+	require.NoError(calculate("hotp", "challenge"))
+	require.Len(uses, 2)
+	require.Equal(1, card.calculated["hotp"])
+
+	deny = true
+
+	require.ErrorContains(calculate("key", "challenge"), "denied")
+	require.Equal(2, card.calculated["key"])
+
+	_, err = cards[0].Transmit(calculateCommand(t, 0xA4, "", "challenge"))
+	require.ErrorContains(err, "denied")
+	require.Nil(uses[len(uses)-1].Key)
+
+	_, err = cards[0].Transmit(calculateCommand(t, 0xA4, "", "hawkes/v1"))
+	require.NoError(err)
+}
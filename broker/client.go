@@ -0,0 +1,205 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package broker
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"cunicu.li/go-iso7816"
+	"cunicu.li/go-iso7816/filter"
+)
+
+type conn struct {
+	net.Conn
+
+	mu  sync.Mutex
+	enc *gob.Encoder
+	dec *gob.Decoder
+}
+
+func dial(path string) (*conn, error) {
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &conn{
+		Conn: c,
+		enc:  gob.NewEncoder(c),
+		dec:  gob.NewDecoder(c),
+	}, nil
+}
+
+func (c *conn) call(req request) (*response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enc.Encode(req); err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	resp := &response{}
+	if err := c.dec.Decode(resp); err != nil {
+		return nil, fmt.Errorf("failed to receive response: %w", err)
+	}
+
+	if resp.Err != "" {
+		return nil, errors.New(resp.Err) //nolint:err113
+	}
+
+	return resp, nil
+}
+
+// OpenCards opens all cards of the broker listening at path which match the filter.
+func OpenCards(path string, flt filter.Filter) (cards []iso7816.PCSCCard, err error) {
+	c, err := dial(path)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	resp, err := c.call(request{Op: opList})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, reader := range resp.Readers {
+		card, err := openCard(path, i, reader)
+		if err != nil {
+			return nil, err
+		}
+
+		if flt != nil {
+			if ok, err := flt(card); err != nil || !ok {
+				card.Close()
+
+				if err != nil {
+					return nil, err
+				}
+
+				continue
+			}
+		}
+
+		cards = append(cards, card)
+	}
+
+	return cards, nil
+}
+
+// Health returns the results of the health checks of all cards of the broker listening at path.
+func Health(path string) ([]CardHealth, error) {
+	c, err := dial(path)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	resp, err := c.call(request{Op: opHealth})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Health, nil
+}
+
+var (
+	_ iso7816.PCSCCard   = (*Card)(nil)
+	_ iso7816.ReaderCard = (*Card)(nil)
+)
+
+// Card is a card which is shared via the broker.
+//
+// Transactions are not forwarded to the broker as they might span the
+// whole lifetime of a card. Instead, operations which consist of multiple
+// APDUs must be wrapped by Lock and Unlock. This is done automatically
+// for cards wrapped by queue.NewCard.
+type Card struct {
+	conn *conn
+
+	index  int
+	reader string
+}
+
+func openCard(path string, index int, reader string) (*Card, error) {
+	c, err := dial(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Card{
+		conn:   c,
+		index:  index,
+		reader: reader,
+	}, nil
+}
+
+// Reader returns the name of the reader of the card.
+func (c *Card) Reader() string {
+	return c.reader
+}
+
+func (c *Card) Transmit(cmd []byte) ([]byte, error) {
+	resp, err := c.conn.call(request{
+		Op:   opTransmit,
+		Card: c.index,
+		Data: cmd,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Data, nil
+}
+
+func (c *Card) BeginTransaction() error {
+	return nil
+}
+
+func (c *Card) EndTransaction() error {
+	return nil
+}
+
+func (c *Card) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Card) Base() iso7816.PCSCCard {
+	return c
+}
+
+// Lock acquires exclusive access to the card until Unlock is called.
+// Other clients of the broker are blocked in the meantime.
+func (c *Card) Lock(ctx context.Context) error {
+	var timeout time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		if timeout = time.Until(deadline); timeout <= 0 {
+			return context.DeadlineExceeded
+		}
+	}
+
+	_, err := c.conn.call(request{
+		Op:      opLock,
+		Card:    c.index,
+		Timeout: timeout,
+	})
+
+	return err
+}
+
+// Unlock releases exclusive access to the card.
+func (c *Card) Unlock() error {
+	_, err := c.conn.call(request{
+		Op:   opUnlock,
+		Card: c.index,
+	})
+
+	return err
+}
@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"slices"
+)
+
+var ErrCallerNotAllowed = errors.New("caller is not allowed to use the broker")
+
+// Peer identifies the process of a client by the credentials of its socket.
+type Peer struct {
+	PID int
+	UID int
+	GID int
+
+	// Executable is the path of the executable of the process if it can be determined.
+	Executable string
+}
+
+// AuthorizeFunc decides whether a client may use the broker.
+type AuthorizeFunc func(p *Peer) error
+
+// Caller describes allowed clients. Empty fields match any client.
+type Caller struct {
+	// UIDs are the allowed user IDs.
+	UIDs []int
+
+	// Executables are glob patterns of the allowed executables (see filepath.Match).
+	Executables []string
+}
+
+func (c *Caller) match(p *Peer) bool {
+	if len(c.UIDs) > 0 && !slices.Contains(c.UIDs, p.UID) {
+		return false
+	}
+
+	if len(c.Executables) > 0 && !slices.ContainsFunc(c.Executables, func(pattern string) bool {
+		ok, err := filepath.Match(pattern, p.Executable)
+		return err == nil && ok && p.Executable != ""
+	}) {
+		return false
+	}
+
+	return true
+}
+
+// AllowCallers returns an AuthorizeFunc which accepts clients matching one of the callers.
+func AllowCallers(callers ...Caller) AuthorizeFunc {
+	return func(p *Peer) error {
+		for _, c := range callers {
+			if c.match(p) {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("%w: pid=%d uid=%d exe=%s", ErrCallerNotAllowed, p.PID, p.UID, p.Executable)
+	}
+}
+
+// PeerOf returns the credentials of the process at the other end of a Unix socket.
+func PeerOf(conn net.Conn) (*Peer, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, fmt.Errorf("%w: not a Unix socket", errors.ErrUnsupported)
+	}
+
+	rc, err := uc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var p *Peer
+	var perr error
+
+	if err := rc.Control(func(fd uintptr) {
+		p, perr = peerOf(int(fd)) //nolint:gosec
+	}); err != nil {
+		return nil, err
+	}
+
+	return p, perr
+}
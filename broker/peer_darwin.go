@@ -0,0 +1,40 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package broker
+
+import (
+	"bytes"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+func peerOf(fd int) (*Peer, error) {
+	cred, err := unix.GetsockoptXucred(fd, unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get peer credentials: %w", err)
+	}
+
+	pid, err := unix.GetsockoptInt(fd, unix.SOL_LOCAL, unix.LOCAL_PEERPID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get peer PID: %w", err)
+	}
+
+	p := &Peer{
+		PID: pid,
+		UID: int(cred.Uid),
+	}
+
+	if cred.Ngroups > 0 {
+		p.GID = int(cred.Groups[0])
+	}
+
+	// The arguments start with argc followed by the path of the executable
+	if args, err := unix.SysctlRaw("kern.procargs2", pid); err == nil && len(args) > 4 {
+		exe, _, _ := bytes.Cut(args[4:], []byte{0})
+		p.Executable = string(exe)
+	}
+
+	return p, nil
+}
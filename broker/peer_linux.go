@@ -0,0 +1,29 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package broker
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func peerOf(fd int) (*Peer, error) {
+	cred, err := unix.GetsockoptUcred(fd, unix.SOL_SOCKET, unix.SO_PEERCRED)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get peer credentials: %w", err)
+	}
+
+	p := &Peer{
+		PID: int(cred.Pid),
+		UID: int(cred.Uid),
+		GID: int(cred.Gid),
+	}
+
+	// Processes of other users may not be inspectable
+	p.Executable, _ = os.Readlink(fmt.Sprintf("/proc/%d/exe", cred.Pid))
+
+	return p, nil
+}
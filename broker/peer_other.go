@@ -0,0 +1,15 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux && !darwin
+
+package broker
+
+import (
+	"errors"
+	"fmt"
+)
+
+func peerOf(int) (*Peer, error) {
+	return nil, fmt.Errorf("%w: peer credentials", errors.ErrUnsupported)
+}
@@ -0,0 +1,337 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package broker
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"cunicu.li/go-iso7816"
+)
+
+type sharedCard struct {
+	iso7816.PCSCCard
+
+	reader string
+	lock   chan struct{}
+
+	// The selected applet and the IDs of YKOATH keys by their
+	// credential names are only accessed while holding the lock.
+	oath  bool
+	names map[string][]byte
+}
+
+// Server serializes the operations of its clients on a set of cards.
+type Server struct {
+	// Authorize decides based on the credentials of their socket
+	// whether clients may use the cards (see AllowCallers).
+	// All clients are accepted if nil.
+	Authorize AuthorizeFunc
+
+	// Ping checks whether a card is present and responsive for health requests.
+	// It must not interfere with the operations of clients, e.g. by selecting applets.
+	// Cards are reported as healthy if nil.
+	Ping func(card iso7816.PCSCCard) error
+
+	// Usage decides whether clients may perform the operations of keys
+	// which the server recognizes in their commands (see UsageFunc).
+	// As they are enforced by the broker, usage policies can not be bypassed
+	// by clients which send their commands to the cards directly.
+	// All commands are relayed if nil.
+	Usage UsageFunc
+
+	cards []*sharedCard
+}
+
+// NewServer creates a new broker for the given cards.
+// The server takes ownership of the cards.
+func NewServer(cards []iso7816.PCSCCard) *Server {
+	s := &Server{}
+
+	for i, card := range cards {
+		reader := strconv.Itoa(i)
+		if rc, ok := card.(iso7816.ReaderCard); ok {
+			reader = rc.Reader()
+		}
+
+		s.cards = append(s.cards, &sharedCard{
+			PCSCCard: card,
+			reader:   reader,
+			lock:     make(chan struct{}, 1),
+			names:    map[string][]byte{},
+		})
+	}
+
+	return s
+}
+
+// ListenAndServe listens on the Unix socket at path and serves clients until ctx is done.
+func (s *Server) ListenAndServe(ctx context.Context, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create socket directory: %w", err)
+	}
+
+	// Remove stale socket of a previous broker
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	if err := os.Chmod(path, 0o600); err != nil {
+		l.Close()
+		return fmt.Errorf("failed to restrict socket permissions: %w", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	if err := s.Serve(l); err != nil && ctx.Err() == nil {
+		return err
+	}
+
+	return nil
+}
+
+// Serve accepts client connections on l.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		go s.handle(conn)
+	}
+}
+
+// Close closes all cards.
+func (s *Server) Close() error {
+	for _, card := range s.cards {
+		if err := card.Close(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) handle(conn net.Conn) {
+	if s.Authorize != nil {
+		if err := s.authorize(conn); err != nil {
+			slog.Warn("Rejected broker client", slog.Any("error", err))
+
+			// Answer the first request of the client with the error
+			_ = gob.NewEncoder(conn).Encode(&response{Err: err.Error()})
+			conn.Close()
+
+			return
+		}
+	}
+
+	c := &serverConn{
+		Server: s,
+		closed: make(chan struct{}),
+	}
+
+	if s.Usage != nil {
+		if p, err := PeerOf(conn); err == nil {
+			c.peer = p
+		}
+	}
+
+	// Requests are decoded by a separate goroutine so that
+	// pending locks are abandoned once the client disconnects.
+	reqs := make(chan request)
+	go func() {
+		defer close(reqs)
+		defer close(c.closed)
+
+		dec := gob.NewDecoder(conn)
+		for {
+			var req request
+			if err := dec.Decode(&req); err != nil {
+				if !errors.Is(err, io.EOF) {
+					slog.Debug("Failed to decode broker request", slog.Any("error", err))
+				}
+
+				return
+			}
+
+			reqs <- req
+		}
+	}()
+
+	defer func() {
+		c.release()
+
+		// Unblock the decoder
+		conn.Close()
+		for range reqs { //nolint:revive
+		}
+	}()
+
+	enc := gob.NewEncoder(conn)
+	for req := range reqs {
+		resp := &response{}
+
+		if err := c.handleRequest(req, resp); err != nil {
+			resp.Err = err.Error()
+		}
+
+		if err := enc.Encode(resp); err != nil {
+			slog.Debug("Failed to encode broker response", slog.Any("error", err))
+			return
+		}
+	}
+}
+
+func (s *Server) authorize(conn net.Conn) error {
+	p, err := PeerOf(conn)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCallerNotAllowed, err)
+	}
+
+	return s.Authorize(p)
+}
+
+type serverConn struct {
+	*Server
+
+	held   *sharedCard
+	peer   *Peer
+	closed chan struct{}
+}
+
+func (c *serverConn) handleRequest(req request, resp *response) error {
+	switch req.Op {
+	case opList:
+		for _, card := range c.cards {
+			resp.Readers = append(resp.Readers, card.reader)
+		}
+
+		return nil
+
+	case opHealth:
+		resp.Health = c.health()
+
+		return nil
+	}
+
+	if req.Card < 0 || req.Card >= len(c.cards) {
+		return ErrInvalidCard
+	}
+
+	card := c.cards[req.Card]
+
+	switch req.Op {
+	case opLock:
+		if c.held != nil {
+			return ErrAlreadyLocked
+		}
+
+		if err := c.acquire(card, req.Timeout); err != nil {
+			return err
+		}
+
+		c.held = card
+
+	case opUnlock:
+		if c.held != card {
+			return ErrNotLocked
+		}
+
+		c.release()
+
+	case opTransmit:
+		// Single APDUs of clients which do not hold the
+		// lock must not interleave with other operations.
+		if c.held != card {
+			if err := c.acquire(card, req.Timeout); err != nil {
+				return err
+			}
+
+			defer func() { <-card.lock }()
+		}
+
+		transmit := card.Transmit
+		if c.Usage != nil {
+			transmit = func(cmd []byte) ([]byte, error) {
+				return c.transmitChecked(card, cmd)
+			}
+		}
+
+		data, err := transmit(req.Data)
+		if err != nil {
+			return err
+		}
+
+		resp.Data = data
+
+	default:
+		return ErrUnknownRequest
+	}
+
+	return nil
+}
+
+// health checks all cards without acquiring them
+// so that long operations of other clients do not delay it.
+func (s *Server) health() (health []CardHealth) {
+	for _, card := range s.cards {
+		h := CardHealth{
+			Reader: card.reader,
+		}
+
+		if s.Ping != nil {
+			if err := s.Ping(card.PCSCCard); err != nil {
+				h.Error = err.Error()
+			}
+		}
+
+		health = append(health, h)
+	}
+
+	return health
+}
+
+func (c *serverConn) acquire(card *sharedCard, timeout time.Duration) error {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		expired = timer.C
+	}
+
+	select {
+	case card.lock <- struct{}{}:
+		return nil
+	case <-expired:
+		return context.DeadlineExceeded
+	case <-c.closed:
+		return net.ErrClosed
+	}
+}
+
+func (c *serverConn) release() {
+	if c.held != nil {
+		<-c.held.lock
+		c.held = nil
+	}
+}
@@ -0,0 +1,293 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package broker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"cunicu.li/go-iso7816"
+	"cunicu.li/go-iso7816/encoding/tlv"
+)
+
+var ErrMalformedCommand = errors.New("malformed command")
+
+// OperationHMAC is the operation of YKOATH keys (see provider.OperationHMAC).
+const OperationHMAC = "hmac"
+
+const (
+	oathInsPut           iso7816.Instruction = 0x01
+	oathInsDelete        iso7816.Instruction = 0x02
+	oathInsReset         iso7816.Instruction = 0x04
+	oathInsList          iso7816.Instruction = 0xA1
+	oathInsCalculate     iso7816.Instruction = 0xA2
+	oathInsCalculateAll  iso7816.Instruction = 0xA4
+	oathInsSendRemaining iso7816.Instruction = 0xA5
+
+	oathTagName      tlv.Tag = 0x71
+	oathTagNameList  tlv.Tag = 0x72
+	oathTagChallenge tlv.Tag = 0x74
+	oathTagResponse  tlv.Tag = 0x75
+
+	// oathTypeKey is the type and algorithm of the
+	// credentials of hawkes keys (TOTP with HMAC-SHA256).
+	oathTypeKey = 0x20 | 0x02
+)
+
+// oathIDChallenge is the challenge by which hawkes identifies
+// its YKOATH keys. It must match the one of the YKOATH provider.
+var oathIDChallenge = []byte("hawkes/v1") //nolint:gochecknoglobals
+
+// Usage is an operation of a key which the server recognized
+// in the commands of a client.
+type Usage struct {
+	// Peer describes the client. It is nil if its credentials are unknown.
+	Peer *Peer
+
+	// Reader is the name of the reader of the card.
+	Reader string
+
+	// Operation is the kind of the operation (see OperationHMAC).
+	Operation string
+
+	// Key is the ID of the key.
+	// It is nil if the operation uses all keys of the card at once.
+	Key []byte
+}
+
+// UsageFunc decides whether a client may perform an operation of a key.
+// The command is refused if it returns an error.
+type UsageFunc func(u *Usage) error
+
+// transmitChecked relays a command to a card after checking the
+// operations of keys which the command performs.
+// Currently, only HMAC calculations of the YKOATH applet are recognized.
+// The card must be acquired by the client.
+func (c *serverConn) transmitChecked(card *sharedCard, cmd []byte) ([]byte, error) {
+	if len(cmd) < iso7816.LenHeader {
+		return nil, ErrMalformedCommand
+	}
+
+	ins, p1 := iso7816.Instruction(cmd[1]), cmd[2]
+
+	data, ok := commandData(cmd)
+	if !ok && card.oath {
+		return nil, ErrMalformedCommand
+	}
+
+	switch {
+	case ins == iso7816.InsSelect && p1 == 0x04:
+		resp, err := card.Transmit(cmd)
+		if err == nil && succeeded(resp) {
+			card.oath = bytes.HasPrefix(data, iso7816.AidYubicoOATH)
+		}
+
+		return resp, err
+
+	case !card.oath:
+		return card.Transmit(cmd)
+
+	case ins == oathInsPut, ins == oathInsDelete, ins == oathInsReset:
+		// Names might refer to other keys afterwards
+		clear(card.names)
+
+		return card.Transmit(cmd)
+
+	case ins == oathInsCalculate:
+		return c.calculate(card, cmd, data)
+
+	case ins == oathInsCalculateAll:
+		tvs, err := tlv.DecodeSimple(data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrMalformedCommand, err)
+		}
+
+		// The identification reveals only the IDs of all keys
+		if challenge, _, _ := tvs.Get(oathTagChallenge); !bytes.Equal(challenge, oathIDChallenge) {
+			if err := c.use(card, nil); err != nil {
+				return nil, err
+			}
+		}
+
+		return card.Transmit(cmd)
+
+	default:
+		return card.Transmit(cmd)
+	}
+}
+
+// calculate relays a CALCULATE command of the YKOATH applet.
+func (c *serverConn) calculate(card *sharedCard, cmd, data []byte) ([]byte, error) {
+	tvs, err := tlv.DecodeSimple(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedCommand, err)
+	}
+
+	name, _, _ := tvs.Get(oathTagName)
+	challenge, _, _ := tvs.Get(oathTagChallenge)
+
+	if bytes.Equal(challenge, oathIDChallenge) {
+		resp, err := card.Transmit(cmd)
+		if err == nil && succeeded(resp) {
+			if id, ok := calculateResponse(resp[:len(resp)-iso7816.LenResponseTrailer]); ok {
+				card.names[string(name)] = id
+			}
+		}
+
+		return resp, err
+	}
+
+	id, ok := card.names[string(name)]
+	if !ok {
+		var resp []byte
+		if id, resp, err = card.identify(name); err != nil {
+			return nil, err
+		} else if resp != nil {
+			// Forward failures like a missing authentication to the client
+			return resp, nil
+		}
+
+		card.names[string(name)] = id
+	}
+
+	// Other credentials than keys are not subject to usage policies
+	if id != nil {
+		if err := c.use(card, id); err != nil {
+			return nil, err
+		}
+	}
+
+	return card.Transmit(cmd)
+}
+
+func (c *serverConn) use(card *sharedCard, id []byte) error {
+	return c.Usage(&Usage{
+		Peer:      c.peer,
+		Reader:    card.reader,
+		Operation: OperationHMAC,
+		Key:       id,
+	})
+}
+
+// identify determines the ID of a key of the YKOATH applet like its provider.
+// The ID is nil if the credential is no key so that HOTP credentials
+// are never calculated as this would advance their counters.
+// Failures of the card are returned as response for the client.
+func (card *sharedCard) identify(name []byte) (id, resp []byte, err error) {
+	ic := iso7816.NewCard(card.PCSCCard)
+	ic.InsGetRemaining = oathInsSendRemaining
+
+	list, err := ic.Send(&iso7816.CAPDU{
+		Ins: oathInsList,
+	})
+	if err != nil {
+		resp, err := failure(err)
+		return nil, resp, err
+	}
+
+	tvs, err := tlv.DecodeSimple(list)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrMalformedCommand, err)
+	}
+
+	isKey := false
+	for _, tv := range tvs {
+		if tv.Tag == oathTagNameList && len(tv.Value) > 0 && bytes.Equal(tv.Value[1:], name) {
+			isKey = tv.Value[0] == oathTypeKey
+		}
+	}
+
+	if !isKey {
+		return nil, nil, nil
+	}
+
+	data, err := tlv.EncodeSimple(
+		tlv.New(oathTagName, name),
+		tlv.New(oathTagChallenge, oathIDChallenge),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	code, err := ic.Send(&iso7816.CAPDU{
+		Ins:  oathInsCalculate,
+		Data: data,
+	})
+	if err != nil {
+		resp, err := failure(err)
+		return nil, resp, err
+	}
+
+	id, ok := calculateResponse(code)
+	if !ok {
+		return nil, nil, ErrMalformedCommand
+	}
+
+	return id, nil, nil
+}
+
+// failure converts an error status of the card into a response.
+func failure(err error) ([]byte, error) {
+	var code iso7816.Code
+	if errors.As(err, &code) {
+		return code[:], nil
+	}
+
+	return nil, err
+}
+
+// calculateResponse returns the HMAC from the data of a CALCULATE response.
+func calculateResponse(data []byte) ([]byte, bool) {
+	tvs, err := tlv.DecodeSimple(data)
+	if err != nil {
+		return nil, false
+	}
+
+	// The first byte contains the number of digits
+	if v, _, ok := tvs.Get(oathTagResponse); ok && len(v) > 1 {
+		return v[1:], true
+	}
+
+	return nil, false
+}
+
+func succeeded(resp []byte) bool {
+	r, err := iso7816.ParseRAPDU(resp)
+	return err == nil && r.SW1 == 0x90 && r.SW2 == 0x00
+}
+
+// commandData returns the data of a command APDU.
+func commandData(cmd []byte) ([]byte, bool) {
+	body := cmd[iso7816.LenHeader:]
+
+	switch {
+	case len(body) <= 1: // No data, optionally Le
+		return nil, true
+
+	case body[0] != 0: // Short Lc
+		lc := int(body[0])
+		if len(body) < 1+lc || len(body) > 2+lc {
+			return nil, false
+		}
+
+		return body[1 : 1+lc], true
+
+	case len(body) == 3: // Extended Le only
+		return nil, true
+
+	default: // Extended Lc
+		if len(body) < 3 {
+			return nil, false
+		}
+
+		lc := int(binary.BigEndian.Uint16(body[1:3]))
+		if lc == 0 || len(body) < 3+lc || len(body) > 5+lc {
+			return nil, false
+		}
+
+		return body[3 : 3+lc], true
+	}
+}
@@ -0,0 +1,311 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package bundle packages the signature of an artifact together with the
+// certificate or attestation chain of its signer and the signing time
+// into a single file which can be verified offline.
+//
+// Verification only depends on the standard library so that recipients of
+// signed artifacts do not need the device stack of hawkes.
+package bundle
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"cunicu.li/hawkes/stream"
+)
+
+var (
+	ErrInvalidBundle   = errors.New("invalid bundle")
+	ErrDigestMismatch  = errors.New("artifact does not match the bundle")
+	ErrNoTrustAnchor   = errors.New("neither roots nor a public key are given")
+	ErrUntrustedSigner = errors.New("signer is not trusted")
+)
+
+// MediaType identifies the version of the bundle format.
+const MediaType = "application/vnd.hawkes.bundle.v1+json"
+
+// Bundle is a signature of an artifact with everything required to verify it.
+type Bundle struct {
+	MediaType string `json:"mediaType"`
+
+	// Digest is the digest of the artifact.
+	Digest Digest `json:"digest"`
+
+	// Time is the signing time as claimed by the signer. It is covered by the signature.
+	Time time.Time `json:"time"`
+
+	// Certificates is the certificate or attestation chain of the signing key,
+	// starting with the certificate of the key itself. It is empty if the
+	// signer is only identified by its public key.
+	Certificates [][]byte `json:"certificates,omitempty"`
+
+	// PublicKey is the DER-encoded SubjectPublicKeyInfo of the signing key.
+	PublicKey []byte `json:"publicKey"`
+
+	// Signature is created over the digest and time (see Bundle.message).
+	Signature []byte `json:"signature"`
+}
+
+// Digest is the digest of an artifact.
+type Digest struct {
+	Algorithm string `json:"algorithm"`
+	Value     []byte `json:"value"`
+}
+
+// SignOptions are the options for signing an artifact.
+type SignOptions struct {
+	// Certificates is the chain of the signing key, starting with the certificate of the key.
+	// For keys on hardware tokens, this is usually the attestation chain.
+	Certificates []*x509.Certificate
+
+	// Time is the signing time. Defaults to the current time.
+	Time time.Time
+
+	// Hash is used for the digest of the artifact. Defaults to SHA-256.
+	Hash crypto.Hash
+}
+
+// VerifyOptions are the options for verifying a bundle.
+// Either Roots or PublicKey must be given.
+type VerifyOptions struct {
+	// Roots are the trust anchors of the certificate chain, e.g. the attestation root of the token vendor.
+	Roots *x509.CertPool
+
+	// CurrentTime is the time at which the chain is verified. Defaults to the current time.
+	// The signing time of the bundle is claimed by the signer and hence not used.
+	CurrentTime time.Time
+
+	// KeyUsages are the accepted extended key usages. Defaults to any usage
+	// as attestation certificates usually do not specify one.
+	KeyUsages []x509.ExtKeyUsage
+
+	// PublicKey is the trusted key of the signer if no certificates are used.
+	PublicKey crypto.PublicKey
+}
+
+// Verified is the result of a successful verification.
+type Verified struct {
+	// PublicKey is the key which created the signature.
+	PublicKey crypto.PublicKey
+
+	// Chains are the verified certificate chains if Roots were given.
+	Chains [][]*x509.Certificate
+
+	// Time is the signing time.
+	Time time.Time
+}
+
+//nolint:gochecknoglobals
+var hashes = map[string]crypto.Hash{
+	"sha256": crypto.SHA256,
+	"sha384": crypto.SHA384,
+	"sha512": crypto.SHA512,
+}
+
+// Sign hashes the artifact read from r and creates a bundle with the signature.
+// The signer is usually a hardware key (see provider.PrivateKeySigner).
+func Sign(signer crypto.Signer, r io.Reader, opts *SignOptions) (*Bundle, error) {
+	if opts == nil {
+		opts = &SignOptions{}
+	}
+
+	hash := opts.Hash
+	if hash == 0 {
+		hash = crypto.SHA256
+	}
+
+	alg := ""
+	for name, h := range hashes {
+		if h == hash {
+			alg = name
+		}
+	}
+
+	if alg == "" {
+		return nil, fmt.Errorf("%w: unsupported hash %s", ErrInvalidBundle, hash)
+	}
+
+	digest, err := stream.Digest(r, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode public key: %w", err)
+	}
+
+	t := opts.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	b := &Bundle{
+		MediaType: MediaType,
+		Digest: Digest{
+			Algorithm: alg,
+			Value:     digest,
+		},
+		Time:      t.UTC().Truncate(time.Second),
+		PublicKey: pub,
+	}
+
+	for _, cert := range opts.Certificates {
+		b.Certificates = append(b.Certificates, cert.Raw)
+	}
+
+	if b.Signature, err = stream.Sign(signer, bytes.NewReader(b.message()), nil); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Parse decodes a bundle.
+func Parse(data []byte) (*Bundle, error) {
+	b := &Bundle{}
+	if err := json.Unmarshal(data, b); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidBundle, err)
+	}
+
+	if b.MediaType != MediaType {
+		return nil, fmt.Errorf("%w: unsupported media type %q", ErrInvalidBundle, b.MediaType)
+	}
+
+	return b, nil
+}
+
+// Marshal encodes the bundle.
+func (b *Bundle) Marshal() ([]byte, error) {
+	return json.MarshalIndent(b, "", "  ")
+}
+
+// Verify checks that the bundle is a valid signature of the artifact read from r
+// by a trusted signer. It does not require any hardware.
+func Verify(bundle []byte, r io.Reader, opts VerifyOptions) (*Verified, error) {
+	b, err := Parse(bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Verify(r, opts)
+}
+
+// Verify checks that the bundle is a valid signature of the artifact read from r
+// by a trusted signer.
+func (b *Bundle) Verify(r io.Reader, opts VerifyOptions) (*Verified, error) {
+	hash, ok := hashes[b.Digest.Algorithm]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported digest algorithm %q", ErrInvalidBundle, b.Digest.Algorithm)
+	} else if len(b.Digest.Value) != hash.Size() {
+		return nil, fmt.Errorf("%w: invalid digest length %d", ErrInvalidBundle, len(b.Digest.Value))
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(b.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid public key: %w", ErrInvalidBundle, err)
+	}
+
+	v := &Verified{
+		PublicKey: pub,
+		Time:      b.Time,
+	}
+
+	switch {
+	case opts.Roots != nil:
+		if v.Chains, err = b.verifyChain(pub, opts); err != nil {
+			return nil, err
+		}
+
+	case opts.PublicKey != nil:
+		trusted, ok := opts.PublicKey.(interface{ Equal(x crypto.PublicKey) bool })
+		if !ok || !trusted.Equal(pub) {
+			return nil, fmt.Errorf("%w: public key does not match", ErrUntrustedSigner)
+		}
+
+	default:
+		return nil, ErrNoTrustAnchor
+	}
+
+	if err := stream.Verify(pub, bytes.NewReader(b.message()), b.Signature, nil); err != nil {
+		return nil, err
+	}
+
+	digest, err := stream.Digest(r, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(digest, b.Digest.Value) {
+		return nil, ErrDigestMismatch
+	}
+
+	return v, nil
+}
+
+// verifyChain checks that the first certificate belongs to the signing key
+// and chains up to one of the roots at the verification time.
+func (b *Bundle) verifyChain(pub crypto.PublicKey, opts VerifyOptions) ([][]*x509.Certificate, error) {
+	if len(b.Certificates) == 0 {
+		return nil, fmt.Errorf("%w: bundle has no certificates", ErrUntrustedSigner)
+	}
+
+	certs := []*x509.Certificate{}
+	for _, der := range b.Certificates {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid certificate: %w", ErrInvalidBundle, err)
+		}
+
+		certs = append(certs, cert)
+	}
+
+	leaf, ok := certs[0].PublicKey.(interface{ Equal(x crypto.PublicKey) bool })
+	if !ok || !leaf.Equal(pub) {
+		return nil, fmt.Errorf("%w: certificate does not match the public key", ErrInvalidBundle)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	usages := opts.KeyUsages
+	if len(usages) == 0 {
+		usages = []x509.ExtKeyUsage{x509.ExtKeyUsageAny}
+	}
+
+	now := opts.CurrentTime
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	chains, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         opts.Roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     usages,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUntrustedSigner, err)
+	}
+
+	return chains, nil
+}
+
+// message returns the signed message which binds the digest to the signing time.
+func (b *Bundle) message() []byte {
+	msg := []byte("hawkes bundle v1\x00" + b.Digest.Algorithm + "\x00")
+	msg = append(msg, b.Digest.Value...)
+
+	return binary.BigEndian.AppendUint64(msg, uint64(b.Time.Unix())) //nolint:gosec
+}
@@ -0,0 +1,136 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package bundle_test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/bundle"
+)
+
+func newCertificate(t *testing.T, pub crypto.PublicKey, parent *x509.Certificate, signer crypto.Signer, notAfter time.Time) *x509.Certificate {
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "hawkes test"},
+		NotBefore:             time.Unix(1600000000, 0),
+		NotAfter:              notAfter,
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+
+	if parent == nil {
+		parent = tmpl
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, signer)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return cert
+}
+
+func TestBundle(t *testing.T) {
+	require := require.New(t)
+
+	rootKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	root := newCertificate(t, rootKey.Public(), nil, rootKey, time.Unix(2000000000, 0))
+
+	signed := time.Unix(1700000000, 0)
+	leaf := newCertificate(t, key.Public(), root, rootKey, time.Unix(2000000000, 0))
+
+	artifact := "firmware image"
+
+	b, err := bundle.Sign(key, strings.NewReader(artifact), &bundle.SignOptions{
+		Certificates: []*x509.Certificate{leaf},
+		Time:         signed,
+	})
+	require.NoError(err)
+
+	data, err := b.Marshal()
+	require.NoError(err)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+
+	v, err := bundle.Verify(data, strings.NewReader(artifact), bundle.VerifyOptions{Roots: roots})
+	require.NoError(err)
+	require.True(signed.Equal(v.Time))
+	require.True(key.PublicKey.Equal(v.PublicKey))
+	require.Len(v.Chains, 1)
+
+	_, err = bundle.Verify(data, strings.NewReader(artifact), bundle.VerifyOptions{PublicKey: key.Public()})
+	require.NoError(err)
+
+	// The chain is verified at the current time rather than the signing time claimed by the signer
+	_, err = bundle.Verify(data, strings.NewReader(artifact), bundle.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: time.Unix(2100000000, 0),
+	})
+	require.ErrorIs(err, bundle.ErrUntrustedSigner)
+
+	expired := newCertificate(t, key.Public(), root, rootKey, signed.Add(time.Hour))
+
+	backdated, err := bundle.Sign(key, strings.NewReader(artifact), &bundle.SignOptions{
+		Certificates: []*x509.Certificate{expired},
+		Time:         signed,
+	})
+	require.NoError(err)
+
+	_, err = backdated.Verify(strings.NewReader(artifact), bundle.VerifyOptions{Roots: roots})
+	require.ErrorIs(err, bundle.ErrUntrustedSigner)
+
+	// Other artifacts, signers and signing times are rejected
+	_, err = bundle.Verify(data, strings.NewReader("other"), bundle.VerifyOptions{Roots: roots})
+	require.ErrorIs(err, bundle.ErrDigestMismatch)
+
+	_, err = bundle.Verify(data, strings.NewReader(artifact), bundle.VerifyOptions{Roots: x509.NewCertPool()})
+	require.ErrorIs(err, bundle.ErrUntrustedSigner)
+
+	_, err = bundle.Verify(data, strings.NewReader(artifact), bundle.VerifyOptions{PublicKey: rootKey.Public()})
+	require.ErrorIs(err, bundle.ErrUntrustedSigner)
+
+	_, err = bundle.Verify(data, strings.NewReader(artifact), bundle.VerifyOptions{})
+	require.ErrorIs(err, bundle.ErrNoTrustAnchor)
+
+	tampered, err := bundle.Parse(data)
+	require.NoError(err)
+
+	tampered.Time = signed.Add(-time.Hour)
+
+	_, err = tampered.Verify(strings.NewReader(artifact), bundle.VerifyOptions{PublicKey: key.Public()})
+	require.Error(err)
+
+	// The chain must belong to the signing key
+	other, err := bundle.Sign(rootKey, strings.NewReader(artifact), &bundle.SignOptions{
+		Certificates: []*x509.Certificate{leaf},
+		Time:         signed,
+	})
+	require.NoError(err)
+
+	_, err = other.Verify(strings.NewReader(artifact), bundle.VerifyOptions{Roots: roots})
+	require.ErrorIs(err, bundle.ErrInvalidBundle)
+
+	_, err = bundle.Verify(bytes.ReplaceAll(data, []byte(bundle.MediaType), []byte("v2")), strings.NewReader(artifact), bundle.VerifyOptions{Roots: roots})
+	require.ErrorIs(err, bundle.ErrInvalidBundle)
+}
@@ -0,0 +1,286 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package ca implements a small X.509 certificate authority
+// whose signing key is held by a provider.
+package ca
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"cunicu.li/hawkes/provider"
+	"cunicu.li/hawkes/timesync"
+)
+
+var (
+	ErrUnsupportedKey = errors.New("unsupported key")
+	ErrInvalidCSR     = errors.New("invalid certificate signing request")
+	ErrNotCA          = errors.New("certificate is not a CA certificate")
+	ErrKeyMismatch    = errors.New("certificate does not match signing key")
+)
+
+const (
+	// DefaultValidity is the validity of issued certificates if not specified by the template.
+	DefaultValidity = 90 * 24 * time.Hour
+
+	// DefaultCRLValidity is the time until the next update of generated CRLs.
+	DefaultCRLValidity = 7 * 24 * time.Hour
+
+	// backdate accounts for clock skew between the CA and relying parties.
+	backdate = 5 * time.Minute
+)
+
+// CA issues certificates and revocation lists.
+type CA struct {
+	Certificate *x509.Certificate
+	Signer      crypto.Signer
+	Store       Store
+
+	// Validity of issued certificates if the template does not specify NotAfter.
+	Validity time.Duration
+
+	// Clock is the time source. Defaults to timesync.System.
+	Clock timesync.Clock
+}
+
+// New creates a new CA for an existing CA certificate and its signing key.
+// If store is nil, serials and revocations are only kept in memory.
+func New(cert *x509.Certificate, signer crypto.Signer, store Store) (*CA, error) {
+	if !cert.IsCA || (cert.KeyUsage != 0 && cert.KeyUsage&x509.KeyUsageCertSign == 0) {
+		return nil, ErrNotCA
+	}
+
+	if pub, ok := cert.PublicKey.(interface{ Equal(x crypto.PublicKey) bool }); !ok || !pub.Equal(signer.Public()) {
+		return nil, ErrKeyMismatch
+	}
+
+	if store == nil {
+		store = NewMemoryStore()
+	}
+
+	return &CA{
+		Certificate: cert,
+		Signer:      signer,
+		Store:       store,
+		Validity:    DefaultValidity,
+	}, nil
+}
+
+// NewProvider creates a new CA for a CA certificate whose key is held by a provider.
+func NewProvider(cert *x509.Certificate, key provider.PrivateKey, store Store) (*CA, error) {
+	signer, err := Signer(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(cert, signer, store)
+}
+
+// Signer returns the signer of a provider key.
+func Signer(key provider.PrivateKey) (crypto.Signer, error) {
+	sk, ok := key.(provider.PrivateKeySigner)
+	if !ok {
+		return nil, fmt.Errorf("%w: key does not support signing", ErrUnsupportedKey)
+	}
+
+	return sk.Signer()
+}
+
+// SelfSigned creates a self-signed root CA certificate for the signing key.
+func SelfSigned(signer crypto.Signer, subject pkix.Name, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	ski, err := subjectKeyID(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               subject,
+		NotBefore:             now.Add(-backdate),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		SubjectKeyId:          ski,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, signer.Public(), signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+
+	return x509.ParseCertificate(der)
+}
+
+// Issue signs a certificate for the public key based on a template.
+// The serial number, issuer and authority key ID of the template are overwritten.
+// Missing validity periods are filled in and clamped to the validity of the CA certificate.
+func (c *CA) Issue(tmpl *x509.Certificate, pub crypto.PublicKey) (*x509.Certificate, error) {
+	var err error
+
+	t := *tmpl
+
+	if t.SerialNumber, err = c.serial(); err != nil {
+		return nil, err
+	}
+
+	now := timesync.Now(c.Clock)
+	if t.NotBefore.IsZero() {
+		t.NotBefore = now.Add(-backdate)
+	}
+
+	if t.NotAfter.IsZero() {
+		t.NotAfter = now.Add(c.Validity)
+	}
+
+	if t.NotAfter.After(c.Certificate.NotAfter) {
+		t.NotAfter = c.Certificate.NotAfter
+	}
+
+	if t.SubjectKeyId == nil {
+		if t.SubjectKeyId, err = subjectKeyID(pub); err != nil {
+			return nil, err
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &t, c.Certificate, pub, c.Signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+
+	return x509.ParseCertificate(der)
+}
+
+// IssueCSR signs a certificate for a certificate signing request.
+// The subject, SANs and extensions are taken from the CSR unless the template
+// sets them. The template may be nil.
+func (c *CA) IssueCSR(csr *x509.CertificateRequest, tmpl *x509.Certificate) (*x509.Certificate, error) {
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCSR, err)
+	}
+
+	var t x509.Certificate
+	if tmpl != nil {
+		t = *tmpl
+	}
+
+	if t.Subject.String() == "" {
+		t.Subject = csr.Subject
+	}
+
+	if t.DNSNames == nil && t.IPAddresses == nil && t.EmailAddresses == nil && t.URIs == nil {
+		t.DNSNames = csr.DNSNames
+		t.IPAddresses = csr.IPAddresses
+		t.EmailAddresses = csr.EmailAddresses
+		t.URIs = csr.URIs
+	}
+
+	if t.KeyUsage == 0 {
+		t.KeyUsage = x509.KeyUsageDigitalSignature
+	}
+
+	// Never allow a CSR to request a CA certificate
+	t.IsCA = false
+	t.BasicConstraintsValid = true
+
+	return c.Issue(&t, csr.PublicKey)
+}
+
+// Revoke marks a certificate as revoked so that it is included in subsequent CRLs.
+func (c *CA) Revoke(serial *big.Int, reason int) error {
+	return c.Store.Revoke(x509.RevocationListEntry{
+		SerialNumber:   serial,
+		RevocationTime: timesync.Now(c.Clock),
+		ReasonCode:     reason,
+	})
+}
+
+// CRL generates a DER-encoded certificate revocation list of all revoked certificates.
+// If validity is zero, DefaultCRLValidity is used.
+func (c *CA) CRL(validity time.Duration) ([]byte, error) {
+	if validity == 0 {
+		validity = DefaultCRLValidity
+	}
+
+	revoked, err := c.Store.Revoked()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load revocations: %w", err)
+	}
+
+	number, err := c.Store.NextCRLNumber()
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate CRL number: %w", err)
+	}
+
+	now := timesync.Now(c.Clock)
+	tmpl := &x509.RevocationList{
+		Number:                    number,
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(validity),
+		RevokedCertificateEntries: revoked,
+	}
+
+	crl, err := x509.CreateRevocationList(rand.Reader, tmpl, c.Certificate, c.Signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CRL: %w", err)
+	}
+
+	return crl, nil
+}
+
+// subjectKeyID computes a key identifier as described by RFC 5280 Section 4.2.1.2 (1).
+func subjectKeyID(pub crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsupportedKey, err)
+	}
+
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+
+	if _, err := asn1.Unmarshal(der, &spki); err != nil {
+		return nil, err
+	}
+
+	id := sha1.Sum(spki.PublicKey.Bytes) //nolint:gosec
+	return id[:], nil
+}
+
+// serial combines the sequence number allocated by the store with 64 random bits.
+// The sequence number guarantees uniqueness while the random part keeps
+// serials unpredictable as recommended by the CA/Browser Forum.
+func (c *CA) serial() (*big.Int, error) {
+	seq, err := c.Store.NextSerial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate serial: %w", err)
+	}
+
+	rnd, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).Or(new(big.Int).Lsh(seq, 64), rnd), nil
+}
+
+func randomSerial() (*big.Int, error) {
+	// RFC 5280 limits serials to 20 octets; we use 128 random bits
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
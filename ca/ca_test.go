@@ -0,0 +1,128 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package ca_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/ca"
+)
+
+func newCA(t *testing.T, store ca.Store) *ca.CA {
+	require := require.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	cert, err := ca.SelfSigned(key, pkix.Name{CommonName: "hawkes Root CA"}, 365*24*time.Hour)
+	require.NoError(err)
+	require.True(cert.IsCA)
+
+	c, err := ca.New(cert, key, store)
+	require.NoError(err)
+
+	return c
+}
+
+func TestIssueCSR(t *testing.T) {
+	require := require.New(t)
+
+	c := newCA(t, nil)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "node1"},
+		DNSNames: []string{"node1.example.com"},
+	}, key)
+	require.NoError(err)
+
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	require.NoError(err)
+
+	cert, err := c.IssueCSR(csr, &x509.Certificate{
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	require.NoError(err)
+	require.Equal("node1", cert.Subject.CommonName)
+	require.Equal([]string{"node1.example.com"}, cert.DNSNames)
+	require.False(cert.IsCA)
+	require.Equal(c.Certificate.SubjectKeyId, cert.AuthorityKeyId)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(c.Certificate)
+
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:   roots,
+		DNSName: "node1.example.com",
+	})
+	require.NoError(err)
+
+	// A CSR with a broken signature must be rejected
+	csr.Signature[len(csr.Signature)-1] ^= 0xff
+	_, err = c.IssueCSR(csr, nil)
+	require.ErrorIs(err, ca.ErrInvalidCSR)
+}
+
+func TestRevokeCRL(t *testing.T) {
+	require := require.New(t)
+
+	store := ca.NewFileStore(filepath.Join(t.TempDir(), "state.json"))
+	c := newCA(t, store)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	cert1, err := c.Issue(&x509.Certificate{Subject: pkix.Name{CommonName: "a"}}, key.Public())
+	require.NoError(err)
+
+	cert2, err := c.Issue(&x509.Certificate{Subject: pkix.Name{CommonName: "b"}}, key.Public())
+	require.NoError(err)
+	require.NotEqual(cert1.SerialNumber, cert2.SerialNumber)
+
+	require.NoError(c.Revoke(cert1.SerialNumber, 1))
+	require.ErrorIs(c.Revoke(cert1.SerialNumber, 1), ca.ErrAlreadyRevoked)
+
+	// The state must survive re-opening the store
+	c.Store = ca.NewFileStore(store.Path())
+
+	der, err := c.CRL(0)
+	require.NoError(err)
+
+	crl, err := x509.ParseRevocationList(der)
+	require.NoError(err)
+	require.NoError(crl.CheckSignatureFrom(c.Certificate))
+	require.Len(crl.RevokedCertificateEntries, 1)
+	require.Equal(cert1.SerialNumber, crl.RevokedCertificateEntries[0].SerialNumber)
+	require.EqualValues(1, crl.Number.Int64())
+
+	der, err = c.CRL(0)
+	require.NoError(err)
+
+	crl, err = x509.ParseRevocationList(der)
+	require.NoError(err)
+	require.EqualValues(2, crl.Number.Int64())
+}
+
+func TestNewMismatch(t *testing.T) {
+	require := require.New(t)
+
+	c := newCA(t, nil)
+
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	_, err = ca.New(c.Certificate, other, nil)
+	require.ErrorIs(err, ca.ErrKeyMismatch)
+}
@@ -0,0 +1,229 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package ca
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"cunicu.li/hawkes/internal/atomicfile"
+)
+
+var ErrAlreadyRevoked = errors.New("certificate is already revoked")
+
+// Store persists the state of a CA.
+type Store interface {
+	// NextSerial allocates a new unique certificate serial number.
+	NextSerial() (*big.Int, error)
+
+	// NextCRLNumber allocates a new monotonically increasing CRL number.
+	NextCRLNumber() (*big.Int, error)
+
+	// Revoke records the revocation of a certificate.
+	Revoke(entry x509.RevocationListEntry) error
+
+	// Revoked returns all revoked certificates.
+	Revoked() ([]x509.RevocationListEntry, error)
+}
+
+type revocation struct {
+	Serial *big.Int  `json:"serial"`
+	Time   time.Time `json:"time"`
+	Reason int       `json:"reason,omitempty"`
+}
+
+type state struct {
+	Serial    *big.Int     `json:"serial"`
+	CRLNumber *big.Int     `json:"crl_number"`
+	Revoked   []revocation `json:"revoked,omitempty"`
+}
+
+func (s *state) nextSerial() *big.Int {
+	if s.Serial == nil {
+		s.Serial = new(big.Int)
+	}
+
+	s.Serial.Add(s.Serial, big.NewInt(1))
+
+	return new(big.Int).Set(s.Serial)
+}
+
+func (s *state) nextCRLNumber() *big.Int {
+	if s.CRLNumber == nil {
+		s.CRLNumber = new(big.Int)
+	}
+
+	s.CRLNumber.Add(s.CRLNumber, big.NewInt(1))
+
+	return new(big.Int).Set(s.CRLNumber)
+}
+
+func (s *state) revoke(e x509.RevocationListEntry) error {
+	for _, r := range s.Revoked {
+		if r.Serial.Cmp(e.SerialNumber) == 0 {
+			return ErrAlreadyRevoked
+		}
+	}
+
+	s.Revoked = append(s.Revoked, revocation{
+		Serial: e.SerialNumber,
+		Time:   e.RevocationTime.UTC(),
+		Reason: e.ReasonCode,
+	})
+
+	return nil
+}
+
+func (s *state) revoked() []x509.RevocationListEntry {
+	entries := make([]x509.RevocationListEntry, 0, len(s.Revoked))
+	for _, r := range s.Revoked {
+		entries = append(entries, x509.RevocationListEntry{
+			SerialNumber:   r.Serial,
+			RevocationTime: r.Time,
+			ReasonCode:     r.Reason,
+		})
+	}
+
+	return entries
+}
+
+// MemoryStore keeps the state of a CA in memory.
+type MemoryStore struct {
+	mu    sync.Mutex
+	state state
+}
+
+// NewMemoryStore creates a new in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (s *MemoryStore) NextSerial() (*big.Int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.state.nextSerial(), nil
+}
+
+func (s *MemoryStore) NextCRLNumber() (*big.Int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.state.nextCRLNumber(), nil
+}
+
+func (s *MemoryStore) Revoke(e x509.RevocationListEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.state.revoke(e)
+}
+
+func (s *MemoryStore) Revoked() ([]x509.RevocationListEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.state.revoked(), nil
+}
+
+// FileStore keeps the state of a CA in a JSON file.
+// Every modification is written atomically before it is returned to the caller
+// so that serial numbers are never reused after a crash.
+type FileStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileStore creates a new store backed by the file at path.
+// The file is created on the first modification.
+func NewFileStore(path string) *FileStore {
+	return &FileStore{
+		path: path,
+	}
+}
+
+func (s *FileStore) NextSerial() (serial *big.Int, err error) {
+	err = s.update(func(st *state) error {
+		serial = st.nextSerial()
+		return nil
+	})
+
+	return serial, err
+}
+
+func (s *FileStore) NextCRLNumber() (number *big.Int, err error) {
+	err = s.update(func(st *state) error {
+		number = st.nextCRLNumber()
+		return nil
+	})
+
+	return number, err
+}
+
+func (s *FileStore) Revoke(e x509.RevocationListEntry) error {
+	return s.update(func(st *state) error {
+		return st.revoke(e)
+	})
+}
+
+func (s *FileStore) Revoked() ([]x509.RevocationListEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return st.revoked(), nil
+}
+
+func (s *FileStore) load() (*state, error) {
+	st := &state{}
+
+	buf, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return st, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read state: %w", err)
+	}
+
+	if err := json.Unmarshal(buf, st); err != nil {
+		return nil, fmt.Errorf("failed to parse state: %w", err)
+	}
+
+	return st, nil
+}
+
+func (s *FileStore) update(fn func(st *state) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(st); err != nil {
+		return err
+	}
+
+	if err := atomicfile.WriteJSON(s.path, st); err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+
+	return nil
+}
+
+// Path returns the path of the state file.
+func (s *FileStore) Path() string {
+	return s.path
+}
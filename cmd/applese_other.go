@@ -0,0 +1,12 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !darwin
+
+package main
+
+// secureEnclave runs the commands for keys in the Apple Secure Enclave
+// which is only available on macOS.
+func secureEnclave([]string) bool {
+	return false
+}
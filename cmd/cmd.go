@@ -1,123 +1,1509 @@
 // SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
 // SPDX-License-Identifier: Apache-2.0
 
-//go:build darwin
-
 package main
 
 import (
-	"encoding/base64"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
 	"log/slog"
+	"maps"
 	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"cunicu.li/go-iso7816"
+	"cunicu.li/go-iso7816/drivers/pcsc"
+	"cunicu.li/go-iso7816/filter"
+	"github.com/ebfe/scard"
 
-	se "cunicu.li/hawkes/ecdh/applese"
-	"cunicu.li/hawkes/ecdh/sw"
+	"cunicu.li/hawkes/audit"
+	"cunicu.li/hawkes/broker"
+	"cunicu.li/hawkes/bundle"
+	"cunicu.li/hawkes/config"
+	"cunicu.li/hawkes/device"
+	"cunicu.li/hawkes/envelope"
+	"cunicu.li/hawkes/event"
+	"cunicu.li/hawkes/fingerprint"
+	"cunicu.li/hawkes/inventory"
+	"cunicu.li/hawkes/jose"
+	"cunicu.li/hawkes/kdf"
+	"cunicu.li/hawkes/oath"
+	"cunicu.li/hawkes/peer"
+	"cunicu.li/hawkes/piv"
+	"cunicu.li/hawkes/provider"
+	"cunicu.li/hawkes/ssh"
+	"cunicu.li/hawkes/timesync"
+	"cunicu.li/hawkes/yubikey"
 )
 
 func main() {
 	if len(os.Args) < 2 {
-		slog.Error("Usage: hawkes (list|remove|genkey)")
+		slog.Error("Usage: hawkes (list|remove|genkey|broker|ssh-keygen|import-oath|export-oath|list-oath|piv-import|attest|audit|doctor|devices|health|key|keystore|pin|peers|bundle|events)")
 		os.Exit(-1)
 	}
 
 	switch os.Args[1] {
-	case "genkey":
-		label := "label"
+	case "broker":
+		path := broker.DefaultPath()
 		if len(os.Args) >= 3 {
-			label = os.Args[2]
+			path = os.Args[2]
+		}
+
+		sc, err := scard.EstablishContext()
+		if err != nil {
+			slog.Error("Failed to establish scard context", slog.Any("error", err))
+			os.Exit(-1)
 		}
 
-		sk, err := se.GenerateKey(label)
+		cards, err := pcsc.OpenCards(sc, 0, filter.Any, false)
 		if err != nil {
-			slog.Error("Failed to generate key", slog.Any("error", err))
+			slog.Error("Failed to open cards", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer cancel()
+
+		srv := broker.NewServer(cards)
+		defer srv.Close()
+
+		// Querying the status of a card does not interfere with the operations of clients
+		srv.Ping = func(card iso7816.PCSCCard) error {
+			if pc, ok := card.Base().(*pcsc.Card); ok {
+				_, err := pc.Status()
+				return err
+			}
+
+			return nil
+		}
+
+		// Restrict the clients if configured
+		if cfgPath, err := config.DefaultPath(); err == nil {
+			if cfg, err := config.Load(cfgPath); err == nil {
+				srv.Authorize = cfg.BrokerAuthorizer()
+
+				if srv.Usage, err = cfg.BrokerUsage(); err != nil {
+					slog.Error("Failed to load usage policies", slog.Any("error", err))
+					os.Exit(-1)
+				}
+			} else if !errors.Is(err, os.ErrNotExist) {
+				slog.Error("Failed to load configuration", slog.Any("error", err))
+				os.Exit(-1)
+			}
+		}
+
+		slog.Info("Card broker listening", slog.String("path", path), slog.Int("cards", len(cards)))
+
+		if err := srv.ListenAndServe(ctx, path); err != nil {
+			slog.Error("Failed to serve", slog.Any("error", err))
 			os.Exit(-1)
 		}
 
-		fmt.Println(sk.Label())
+	case "ssh-keygen":
+		path, err := config.DefaultPath()
+		if err != nil {
+			slog.Error("Failed to find configuration", slog.Any("error", err))
+			os.Exit(255)
+		}
+
+		cfg, err := config.Load(path)
+		if err != nil {
+			slog.Error("Failed to load configuration", slog.Any("error", err))
+			os.Exit(255)
+		}
+
+		p, err := cfg.NewProvider()
+		if err != nil {
+			slog.Error("Failed to open providers", slog.Any("error", err))
+			os.Exit(255)
+		}
+		defer p.Close()
+
+		kg := &ssh.Keygen{
+			Signer: ssh.ProviderSigner(p),
+			Stdin:  os.Stdin,
+			Stdout: os.Stdout,
+			Stderr: os.Stderr,
+		}
+
+		// ssh-keygen exits with 255 on failure which git expects
+		if err := kg.Run(os.Args[2:]); err != nil {
+			slog.Error("Failed to run", slog.Any("error", err))
+			p.Close()
+			os.Exit(255) //nolint:gocritic
+		}
+
+	case "import-oath":
+		fs := flag.NewFlagSet("import-oath", flag.ExitOnError)
+		format := fs.String("format", "", "backup format (aegis, andotp, freeotp+, uris), detected if empty")
+		overwrite := fs.Bool("overwrite", false, "replace existing credentials")
+		touch := fs.Bool("touch", false, "require a touch of the token for each code")
+		dryRun := fs.Bool("dry-run", false, "only report which credentials would be stored")
+		_ = fs.Parse(os.Args[2:])
+
+		if fs.NArg() != 1 {
+			slog.Error("Usage: hawkes import-oath [-format format] [-overwrite] [-touch] [-dry-run] [backup]")
+			os.Exit(-1)
+		}
 
-	case "diffie-helman", "dh":
-		if len(os.Args) < 4 {
-			slog.Error("Usage: hawkes dh [label] [public-key]")
+		data, err := os.ReadFile(fs.Arg(0))
+		if err != nil {
+			slog.Error("Failed to read backup", slog.Any("error", err))
 			os.Exit(-1)
 		}
 
-		labelBytes, err := base64.StdEncoding.DecodeString(os.Args[2])
+		// Encrypted backups are decrypted with the password from the environment
+		var password []byte
+		if pw, ok := os.LookupEnv("HAWKES_BACKUP_PASSWORD"); ok {
+			password = []byte(pw)
+		}
+
+		creds, err := oath.Import(data, password, oath.Format(*format))
 		if err != nil {
-			slog.Error("Failed to decode", slog.Any("error", err))
+			slog.Error("Failed to import backup", slog.Any("error", err))
 			os.Exit(-1)
 		}
 
-		label := se.KeyLabel(labelBytes)
+		for _, cred := range creds {
+			cred.Touch = *touch
+		}
 
-		sk, err := se.PrivateKeyByLabel(label)
+		path, err := config.DefaultPath()
 		if err != nil {
-			slog.Error("Failed to get private key", slog.Any("error", err))
+			slog.Error("Failed to find configuration", slog.Any("error", err))
 			os.Exit(-1)
 		}
 
-		pkBytes, err := base64.StdEncoding.DecodeString(os.Args[3])
+		cfg, err := config.Load(path)
 		if err != nil {
-			slog.Error("Failed to decode", slog.Any("error", err))
+			slog.Error("Failed to load configuration", slog.Any("error", err))
 			os.Exit(-1)
 		}
 
-		pk, err := sw.P256.ParsePublicKey(pkBytes)
+		mpCfg, err := cfg.MultiProviderConfig()
 		if err != nil {
-			slog.Error("Failed to load public key", slog.Any("error", err))
+			slog.Error("Failed to load configuration", slog.Any("error", err))
 			os.Exit(-1)
 		}
 
-		ss, err := sk.DH(pk)
+		var plan *provider.Plan
+		if *dryRun {
+			plan = &provider.Plan{}
+			mpCfg.DryRun = plan
+		}
+
+		p, err := provider.NewProvider(mpCfg)
 		if err != nil {
-			slog.Error("Failed to calc shared secret", slog.Any("error", err))
+			slog.Error("Failed to open providers", slog.Any("error", err))
 			os.Exit(-1)
 		}
+		defer p.Close()
 
-		fmt.Println(base64.StdEncoding.EncodeToString(ss))
+		op, err := p.OATHProvider()
+		if err != nil {
+			slog.Error("Failed to find OATH provider", slog.Any("error", err))
+			os.Exit(-1)
+		}
 
-	case "remove", "rm":
-		var err error
-		var hash []byte
+		n, err := provider.ImportCredentials(op, creds, *overwrite)
 
-		if len(os.Args) < 3 {
-			slog.Error("Usage: hawkes remove [label]")
+		if plan != nil {
+			for _, action := range plan.Actions() {
+				fmt.Println(action)
+			}
+
+			slog.Info("Dry run: credentials would be imported", slog.Int("imported", n), slog.Int("total", len(creds)))
+		} else {
+			slog.Info("Imported credentials", slog.Int("imported", n), slog.Int("total", len(creds)))
+		}
+
+		if err != nil {
+			slog.Error("Failed to import some credentials", slog.Any("error", err))
+			p.Close()
+			os.Exit(-1) //nolint:gocritic
+		}
+
+	case "export-oath":
+		fs := flag.NewFlagSet("export-oath", flag.ExitOnError)
+		keys := fs.Bool("keys", false, "also export secrets which are private keys for DH and signing")
+		_ = fs.Parse(os.Args[2:])
+
+		format := "uris"
+		if fs.NArg() >= 1 {
+			format = fs.Arg(0)
+		}
+
+		path, err := config.DefaultPath()
+		if err != nil {
+			slog.Error("Failed to find configuration", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		cfg, err := config.Load(path)
+		if err != nil {
+			slog.Error("Failed to load configuration", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		p, err := cfg.NewProvider()
+		if err != nil {
+			slog.Error("Failed to open providers", slog.Any("error", err))
+			os.Exit(-1)
+		}
+		defer p.Close()
+
+		creds, err := provider.ExportCredentials(p, &provider.ExportOptions{
+			Keys: *keys,
+		})
+		if err != nil {
+			slog.Error("Failed to export credentials", slog.Any("error", err))
+			p.Close()
+			os.Exit(-1) //nolint:gocritic
+		}
+
+		switch format {
+		case "uris":
+			err = oath.ExportURIs(os.Stdout, creds)
+		case "pass":
+			err = oath.ExportPass(os.Stdout, creds)
+		case "keepassxc":
+			err = oath.ExportKeePassXC(os.Stdout, creds)
+		default:
+			slog.Error("Usage: hawkes export-oath [-keys] (uris|pass|keepassxc)")
+			p.Close()
+			os.Exit(-1)
+		}
+
+		if err != nil {
+			slog.Error("Failed to write credentials", slog.Any("error", err))
+			p.Close()
+			os.Exit(-1)
+		}
+
+	case "list-oath":
+		path, err := config.DefaultPath()
+		if err != nil {
+			slog.Error("Failed to find configuration", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		cfg, err := config.Load(path)
+		if err != nil {
+			slog.Error("Failed to load configuration", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		p, err := cfg.NewProvider()
+		if err != nil {
+			slog.Error("Failed to open providers", slog.Any("error", err))
+			os.Exit(-1)
+		}
+		defer p.Close()
+
+		op, err := p.OATHProvider()
+		if err != nil {
+			slog.Error("Failed to find OATH provider", slog.Any("error", err))
+			p.Close()
+			os.Exit(-1) //nolint:gocritic
+		}
+
+		ol, ok := op.(provider.OATHLister)
+		if !ok {
+			slog.Error("OATH provider can not list its credentials")
+			p.Close()
+			os.Exit(-1)
+		}
+
+		creds, err := ol.Credentials()
+		if err != nil {
+			slog.Error("Failed to list credentials", slog.Any("error", err))
+			p.Close()
+			os.Exit(-1)
+		}
+
+		for _, cred := range creds {
+			fmt.Println(cred)
+		}
+
+	case "piv-import":
+		fs := flag.NewFlagSet("piv-import", flag.ExitOnError)
+		slotName := fs.String("slot", "9a", "PIV slot")
+		pinPolicyName := fs.String("pin-policy", "default", "PIN policy (default, never, once, always)")
+		touchPolicyName := fs.String("touch-policy", "default", "touch policy (default, never, always, cached)")
+		dryRun := fs.Bool("dry-run", false, "validate the key, card and management key without importing")
+		_ = fs.Parse(os.Args[2:])
+
+		if fs.NArg() != 1 {
+			slog.Error("Usage: hawkes piv-import [-slot slot] [-pin-policy policy] [-touch-policy policy] [-dry-run] [key.pem]")
+			os.Exit(-1)
+		}
+
+		slot, err := piv.ParseSlot(*slotName)
+		if err != nil {
+			slog.Error("Failed to parse slot", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		var policies piv.Policies
+		if policies.PIN, err = piv.ParsePINPolicy(*pinPolicyName); err != nil {
+			slog.Error("Failed to parse PIN policy", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		if policies.Touch, err = piv.ParseTouchPolicy(*touchPolicyName); err != nil {
+			slog.Error("Failed to parse touch policy", slog.Any("error", err))
 			os.Exit(-1)
 		}
 
-		if hash, err = base64.StdEncoding.DecodeString(os.Args[2]); err != nil {
-			slog.Error("Failed to decode key label", slog.Any("error", err))
+		data, err := os.ReadFile(fs.Arg(0))
+		if err != nil {
+			slog.Error("Failed to read key", slog.Any("error", err))
 			os.Exit(-1)
 		}
 
-		if ok, err := se.RemoveKey(se.KeyLabel(hash)); err != nil {
-			slog.Error("Failed to remove key", slog.Any("error", err))
+		key, err := piv.ParsePrivateKey(data)
+		if err != nil {
+			slog.Error("Failed to parse key", slog.Any("error", err))
 			os.Exit(-1)
-		} else if !ok {
-			slog.Warn("No matching key found")
 		}
 
-	case "list", "ls":
-		var err error
-		var hash []byte
+		if _, err := piv.CheckImport(key, policies); err != nil {
+			slog.Error("Failed to validate key", slog.Any("error", err))
+			os.Exit(-1)
+		}
 
-		if len(os.Args) > 2 {
-			if hash, err = base64.StdEncoding.DecodeString(os.Args[2]); err != nil {
-				slog.Error("Failed to decode key label", slog.Any("error", err))
+		// The management key is read from the environment to keep it out of the process list
+		mgmtKey := piv.DefaultManagementKey
+		if hexKey, ok := os.LookupEnv("HAWKES_PIV_MANAGEMENT_KEY"); ok {
+			if mgmtKey, err = hex.DecodeString(hexKey); err != nil {
+				slog.Error("Failed to decode management key", slog.Any("error", err))
 				os.Exit(-1)
 			}
 		}
 
-		keys, err := se.Keys(hash)
+		sc, err := scard.EstablishContext()
 		if err != nil {
-			slog.Error("Failed to enumerate keys", slog.Any("error", err))
+			slog.Error("Failed to establish scard context", slog.Any("error", err))
 			os.Exit(-1)
 		}
 
-		for _, key := range keys {
-			pkStr := base64.StdEncoding.EncodeToString(key.Public().Bytes())
-			log.Println(key.Label(), pkStr)
+		cards, err := pcsc.OpenCards(sc, 1, filter.HasApplet(iso7816.AidPIV), true)
+		if err != nil || len(cards) == 0 {
+			slog.Error("Failed to find card with PIV applet", slog.Any("error", err))
+			os.Exit(-1)
 		}
-	}
+
+		var plan *provider.Plan
+		if *dryRun {
+			plan = &provider.Plan{}
+		}
+
+		err = importPIVKey(cards[0], mgmtKey, slot, key, policies, plan)
+		cards[0].Close()
+
+		if err != nil {
+			slog.Error("Failed to import key", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		if plan != nil {
+			for _, action := range plan.Actions() {
+				fmt.Println(action)
+			}
+		} else {
+			slog.Info("Imported key", slog.String("slot", slot.String()))
+		}
+
+	case "doctor":
+		fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+		asJSON := fs.Bool("json", false, "print the diagnosis as JSON for bug reports")
+		_ = fs.Parse(os.Args[2:])
+
+		d := device.Diagnose()
+
+		if *asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+
+			if err := enc.Encode(d); err != nil {
+				slog.Error("Failed to write diagnosis", slog.Any("error", err))
+				os.Exit(-1)
+			}
+
+			return
+		}
+
+		fmt.Printf("PC/SC support: %t\n", d.PCSC)
+		fmt.Printf("PC/SC service: %t\n", d.Service)
+
+		if d.Error != "" {
+			fmt.Printf("PC/SC error:   %s\n", d.Error)
+		}
+
+		for _, r := range d.Readers {
+			fmt.Printf("\nReader: %s\n", r.Name)
+
+			switch {
+			case r.Error != "":
+				fmt.Printf("  Error: %s\n", r.Error)
+			case !r.Card:
+				fmt.Println("  No card present")
+			default:
+				fmt.Printf("  ATR: %s\n", r.ATR)
+
+				if r.USB != "" {
+					fmt.Printf("  USB: %s\n", r.USB)
+				}
+
+				for _, name := range slices.Sorted(maps.Keys(r.Applets)) {
+					fmt.Printf("  %s: %t\n", name, r.Applets[name])
+				}
+			}
+		}
+
+		if len(d.Hints) > 0 {
+			fmt.Println("\nHints:")
+
+			for _, h := range d.Hints {
+				fmt.Printf("  - %s\n", h)
+			}
+		}
+
+	case "devices":
+		fs := flag.NewFlagSet("devices", flag.ExitOnError)
+		timeout := fs.Duration("timeout", device.DefaultProbeTimeout, "time budget for probing a single device")
+		_ = fs.Parse(os.Args[2:])
+
+		devs, err := device.Devices(context.Background(), &device.DiscoverOptions{
+			Timeout: *timeout,
+		})
+		if err != nil {
+			slog.Warn("Failed to discover some devices", slog.Any("error", err))
+		}
+
+		for _, d := range devs {
+			line := fmt.Sprintf("%-5s %s", d.Transport, d.Name)
+
+			if d.USB != nil {
+				line += " usb=" + d.USB.String()
+			}
+
+			if len(d.ATR) > 0 {
+				line += " atr=" + hex.EncodeToString(d.ATR)
+			}
+
+			if d.Error != nil {
+				line += " error=" + d.Error.Error()
+			}
+
+			fmt.Println(line)
+		}
+
+	case "yubikey":
+		fs := flag.NewFlagSet("yubikey", flag.ExitOnError)
+		requireNames := fs.String("require", "", "comma-separated applications which must be enabled (e.g. piv,oath)")
+		forbidNames := fs.String("forbid", "", "comma-separated applications which must be disabled (e.g. otp)")
+		ifaces := fs.String("interfaces", "usb,nfc", "comma-separated interfaces to which the policy applies")
+		enforce := fs.Bool("enforce", false, "enable and disable applications to satisfy the policy")
+		_ = fs.Parse(os.Args[2:])
+
+		p := &yubikey.Policy{
+			Require: map[yubikey.Interface]yubikey.Capability{},
+			Forbid:  map[yubikey.Interface]yubikey.Capability{},
+		}
+
+		requireCaps, err := yubikey.ParseCapability(*requireNames)
+		if err != nil {
+			slog.Error("Failed to parse required applications", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		forbidCaps, err := yubikey.ParseCapability(*forbidNames)
+		if err != nil {
+			slog.Error("Failed to parse forbidden applications", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		for _, name := range strings.Split(*ifaces, ",") {
+			iface, err := yubikey.ParseInterface(name)
+			if err != nil {
+				slog.Error("Failed to parse interface", slog.Any("error", err))
+				os.Exit(-1)
+			}
+
+			p.Require[iface] = requireCaps
+			p.Forbid[iface] = forbidCaps
+		}
+
+		// The lock code is read from the environment to keep it out of the process list
+		var lockCode []byte
+		if hexCode, ok := os.LookupEnv("HAWKES_YUBIKEY_LOCK_CODE"); ok {
+			if lockCode, err = hex.DecodeString(hexCode); err != nil {
+				slog.Error("Failed to decode lock code", slog.Any("error", err))
+				os.Exit(-1)
+			}
+		}
+
+		sc, err := scard.EstablishContext()
+		if err != nil {
+			slog.Error("Failed to establish scard context", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		cards, err := pcsc.OpenCards(sc, 0, filter.HasApplet(iso7816.AidYubicoManagement), true)
+		if err != nil || len(cards) == 0 {
+			slog.Error("Failed to find YubiKey", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		violations := 0
+
+		for _, card := range cards {
+			info, err := yubikeyInfo(card, p, *enforce, lockCode)
+			card.Close()
+
+			if err != nil {
+				slog.Error("Failed to access YubiKey", slog.Any("error", err))
+				os.Exit(-1)
+			}
+
+			fmt.Printf("%d %s %s usb=%s nfc=%s\n", info.Serial, info.FormFactor, info.Firmware,
+				info.Enabled[yubikey.InterfaceUSB], info.Enabled[yubikey.InterfaceNFC])
+
+			if err := p.Check(info); err != nil {
+				slog.Error("YubiKey violates policy", slog.Uint64("serial", uint64(info.Serial)), slog.Any("error", err))
+				violations++
+			}
+		}
+
+		if violations > 0 {
+			os.Exit(1)
+		}
+
+	case "clock":
+		fs := flag.NewFlagSet("clock", flag.ExitOnError)
+		source := fs.String("source", "ntp://pool.ntp.org", "trusted time source")
+		timestep := fs.Duration("timestep", 30*time.Second, "TOTP time step for which the offset is checked")
+		save := fs.Bool("save", false, "persist the offset to correct TOTP calculations")
+		_ = fs.Parse(os.Args[2:])
+
+		src, err := timesync.ParseSource(*source)
+		if err != nil {
+			slog.Error("Failed to parse time source", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		sample, err := timesync.Estimate(context.Background(), src, timesync.DefaultSamples)
+		if err != nil {
+			slog.Error("Failed to estimate clock offset", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		fmt.Printf("offset=%s rtt=%s source=%s\n", sample.Offset.Round(time.Millisecond), sample.RTT.Round(time.Millisecond), sample.Source)
+
+		if err := timesync.Check(sample.Offset, *timestep); err != nil {
+			slog.Warn("TOTP codes calculated with the local clock are likely rejected", slog.Any("error", err))
+		}
+
+		if *save {
+			path, err := timesync.DefaultPath()
+			if err != nil {
+				slog.Error("Failed to find offset file", slog.Any("error", err))
+				os.Exit(-1)
+			}
+
+			hostname, err := os.Hostname()
+			if err != nil {
+				slog.Error("Failed to get hostname", slog.Any("error", err))
+				os.Exit(-1)
+			}
+
+			if err := timesync.NewFileStore(path).Put(hostname, sample); err != nil {
+				slog.Error("Failed to save offset", slog.Any("error", err))
+				os.Exit(-1)
+			}
+		}
+
+	case "health":
+		fs := flag.NewFlagSet("health", flag.ExitOnError)
+		timeout := fs.Duration("timeout", 5*time.Second, "time budget for checking all providers")
+		_ = fs.Parse(os.Args[2:])
+
+		path, err := config.DefaultPath()
+		if err != nil {
+			slog.Error("Failed to find configuration", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		cfg, err := config.Load(path)
+		if err != nil {
+			slog.Error("Failed to load configuration", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		p, err := cfg.NewProvider()
+		if err != nil {
+			slog.Error("Failed to open providers", slog.Any("error", err))
+			os.Exit(-1)
+		}
+		defer p.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+
+		report := struct {
+			Providers []provider.Health   `json:"providers"`
+			Broker    []broker.CardHealth `json:"broker,omitempty"`
+		}{
+			Providers: p.Healthy(ctx),
+		}
+
+		healthy := true
+		for _, h := range report.Providers {
+			healthy = healthy && h.Healthy
+		}
+
+		// The broker is optional
+		if report.Broker, err = broker.Health(broker.DefaultPath()); err == nil {
+			for _, h := range report.Broker {
+				healthy = healthy && h.Healthy()
+			}
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+
+		if err := enc.Encode(report); err != nil {
+			slog.Error("Failed to encode health report", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		if !healthy {
+			p.Close()
+			os.Exit(1) //nolint:gocritic
+		}
+
+	case "key":
+		fs := flag.NewFlagSet("key migrate", flag.ExitOnError)
+		escrowKey := fs.String("escrow-key", "", "URI of the provider key which opens the escrow envelopes")
+		dryRun := fs.Bool("dry-run", false, "only report which keys would be created")
+		allowLoss := fs.Bool("allow-loss", false, "migrate keys even if the destination does not support all of their operations")
+
+		opts := &provider.MigrateOptions{}
+		fs.Func("escrow", "file with an escrow or wrapped key envelope, may be repeated", func(fn string) error {
+			data, err := os.ReadFile(fn)
+			if err != nil {
+				return err
+			}
+
+			env, err := envelope.Parse(data)
+			if err != nil {
+				return err
+			}
+
+			opts.Escrow = append(opts.Escrow, env)
+
+			return nil
+		})
+
+		if len(os.Args) < 3 || os.Args[2] != "migrate" {
+			slog.Error("Usage: hawkes key migrate [-escrow file] [-escrow-key uri] [-allow-loss] [-dry-run] [src-uri] [dst-provider]")
+			os.Exit(-1)
+		}
+
+		_ = fs.Parse(os.Args[3:])
+
+		if fs.NArg() != 2 {
+			slog.Error("Usage: hawkes key migrate [-escrow file] [-escrow-key uri] [-allow-loss] [-dry-run] [src-uri] [dst-provider]")
+			os.Exit(-1)
+		}
+
+		path, err := config.DefaultPath()
+		if err != nil {
+			slog.Error("Failed to find configuration", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		cfg, err := config.Load(path)
+		if err != nil {
+			slog.Error("Failed to load configuration", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		mpCfg, err := cfg.MultiProviderConfig()
+		if err != nil {
+			slog.Error("Failed to load configuration", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		var plan *provider.Plan
+		if *dryRun {
+			plan = &provider.Plan{}
+			mpCfg.DryRun = plan
+		}
+
+		p, err := provider.NewProvider(mpCfg)
+		if err != nil {
+			slog.Error("Failed to open providers", slog.Any("error", err))
+			os.Exit(-1)
+		}
+		defer p.Close()
+
+		// Key-encryption keys are read from the environment to keep them out of the process list
+		if *escrowKey != "" {
+			key, err := p.OpenKeyURI(*escrowKey)
+			if err != nil {
+				slog.Error("Failed to open escrow key", slog.Any("error", err))
+				os.Exit(-1)
+			}
+			defer key.Close()
+
+			opts.EscrowKey = key
+		} else if hexKey, ok := os.LookupEnv("HAWKES_ESCROW_KEY"); ok {
+			if opts.EscrowKey, err = hex.DecodeString(hexKey); err != nil {
+				slog.Error("Failed to decode escrow key", slog.Any("error", err))
+				os.Exit(-1)
+			}
+		}
+
+		opts.AllowLoss = *allowLoss
+
+		migrations, err := p.Migrate(fs.Arg(0), fs.Arg(1), opts)
+		if err != nil {
+			slog.Error("Failed to migrate keys", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		for _, m := range migrations {
+			if m.Err() != nil {
+				continue
+			}
+
+			if len(m.Lost) > 0 {
+				slog.Warn("Migrated key lost operations", slog.String("key", m.Source.String()), slog.Any("lost", m.Lost))
+			}
+
+			if m.IDChanged() {
+				slog.Warn("Migrated key has a new ID, references to it must be updated",
+					slog.String("key", m.Source.String()),
+					slog.String("new", m.Destination.String()))
+			}
+		}
+
+		if plan != nil {
+			for _, action := range plan.Actions() {
+				fmt.Println(action)
+			}
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+
+		if err := enc.Encode(migrations); err != nil {
+			slog.Error("Failed to encode migration report", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		for _, m := range migrations {
+			if m.Err() != nil {
+				slog.Error("Failed to migrate key", slog.String("key", m.Source.String()), slog.Any("error", m.Err()))
+			}
+		}
+
+		if slices.ContainsFunc(migrations, func(m provider.Migration) bool {
+			return m.Err() != nil
+		}) {
+			p.Close()
+			os.Exit(1) //nolint:gocritic
+		}
+
+	case "keystore":
+		fs := flag.NewFlagSet("keystore protect", flag.ExitOnError)
+		timeCost := fs.Uint("time", kdf.DefaultTime, "number of Argon2id passes")
+		memory := fs.Uint("memory", kdf.DefaultMemory, "Argon2id memory in KiB")
+		threads := fs.Uint("threads", kdf.DefaultThreads, "Argon2id parallelism")
+		calibrate := fs.Duration("calibrate", 0, "choose the number of passes so that unlocking takes this long on this host")
+
+		if len(os.Args) < 3 || os.Args[2] != "protect" {
+			slog.Error("Usage: hawkes keystore protect [-time passes] [-memory KiB] [-threads threads] [-calibrate duration]")
+			os.Exit(-1)
+		}
+
+		_ = fs.Parse(os.Args[3:])
+
+		var (
+			params *kdf.Argon2id
+			err    error
+		)
+
+		if *calibrate > 0 {
+			if params, err = kdf.Calibrate(*calibrate, uint32(*memory), uint8(*threads)); err != nil { //nolint:gosec
+				slog.Error("Failed to calibrate key derivation", slog.Any("error", err))
+				os.Exit(-1)
+			}
+		} else {
+			params = &kdf.Argon2id{
+				Time:    uint32(*timeCost), //nolint:gosec
+				Memory:  uint32(*memory),   //nolint:gosec
+				Threads: uint8(*threads),   //nolint:gosec
+			}
+
+			if err := params.GenerateSalt(); err != nil {
+				slog.Error("Failed to generate salt", slog.Any("error", err))
+				os.Exit(-1)
+			}
+		}
+
+		if err := params.Validate(); err != nil {
+			slog.Error("Invalid key derivation parameters", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		path, err := config.DefaultPath()
+		if err != nil {
+			slog.Error("Failed to find configuration", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		cfg, err := config.Load(path)
+		if err != nil {
+			slog.Error("Failed to load configuration", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		mpCfg, err := cfg.MultiProviderConfig()
+		if err != nil {
+			slog.Error("Failed to load configuration", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		p, err := provider.NewProvider(mpCfg)
+		if err != nil {
+			slog.Error("Failed to open providers", slog.Any("error", err))
+			os.Exit(-1)
+		}
+		defer p.Close()
+
+		fileProvider, err := p.Provider("File")
+		if err != nil {
+			slog.Error("Failed to find provider", slog.Any("error", err))
+			p.Close()
+			os.Exit(-1) //nolint:gocritic
+		}
+
+		fp, ok := fileProvider.(provider.PassphraseProtector)
+		if !ok {
+			slog.Error("File provider does not support passphrases")
+			p.Close()
+			os.Exit(-1)
+		}
+
+		// The passphrase is read from the environment to keep it out of the process list.
+		// An empty passphrase removes the encryption.
+		if err := fp.Protect([]byte(os.Getenv("HAWKES_KEYSTORE_PASSPHRASE")), params); err != nil {
+			slog.Error("Failed to protect keystore", slog.Any("error", err))
+			p.Close()
+			os.Exit(-1)
+		}
+
+		slog.Info("Protected keystore", slog.String("params", params.String()))
+
+	case "pin":
+		if len(os.Args) < 4 || os.Args[2] != "reset" {
+			slog.Error("Usage: hawkes pin reset [provider]")
+			os.Exit(-1)
+		}
+
+		path, err := config.DefaultPath()
+		if err != nil {
+			slog.Error("Failed to find configuration", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		cfg, err := config.Load(path)
+		if err != nil {
+			slog.Error("Failed to load configuration", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		mgr, err := cfg.PINManager()
+		if err != nil {
+			slog.Error("Failed to load configuration", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		if err := mgr.Reset(os.Args[3]); err != nil {
+			slog.Error("Failed to reset failed PIN attempts", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+	case "peers":
+		fs := flag.NewFlagSet("peers", flag.ExitOnError)
+		expires := fs.Duration("expires", 0, "lifetime of a pinned key (default: never expires)")
+		reason := fs.String("reason", "", "reason of a revocation")
+
+		if len(os.Args) < 3 {
+			slog.Error("Usage: hawkes peers (list|pin|revoke|forget) [-expires duration] [-reason reason] [name] [fingerprint]")
+			os.Exit(-1)
+		}
+
+		_ = fs.Parse(os.Args[3:])
+
+		path, err := peer.DefaultPath()
+		if err != nil {
+			slog.Error("Failed to find peer store", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		store := peer.NewStore(path)
+
+		switch cmd := os.Args[2]; {
+		case cmd == "list":
+			peers, err := store.Peers()
+			if err != nil {
+				slog.Error("Failed to list peers", slog.Any("error", err))
+				os.Exit(-1)
+			}
+
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+
+			if err := enc.Encode(peers); err != nil {
+				slog.Error("Failed to encode peers", slog.Any("error", err))
+				os.Exit(-1)
+			}
+
+		case cmd == "forget" && fs.NArg() == 1:
+			if err := store.Forget(fs.Arg(0)); err != nil {
+				slog.Error("Failed to forget peer", slog.Any("error", err))
+				os.Exit(-1)
+			}
+
+		case (cmd == "pin" || cmd == "revoke") && fs.NArg() == 2:
+			fp, err := fingerprint.Parse(fs.Arg(1))
+			if err != nil {
+				slog.Error("Failed to parse fingerprint", slog.Any("error", err))
+				os.Exit(-1)
+			}
+
+			if cmd == "pin" {
+				var until time.Time
+				if *expires > 0 {
+					until = time.Now().Add(*expires)
+				}
+
+				err = store.Pin(fs.Arg(0), fp, until)
+			} else {
+				err = store.Revoke(fs.Arg(0), fp, *reason)
+			}
+
+			if err != nil {
+				slog.Error("Failed to update peer", slog.Any("error", err))
+				os.Exit(-1)
+			}
+
+		default:
+			slog.Error("Usage: hawkes peers (list|pin|revoke|forget) [-expires duration] [-reason reason] [name] [fingerprint]")
+			os.Exit(-1)
+		}
+
+	case "bundle":
+		fs := flag.NewFlagSet("bundle", flag.ExitOnError)
+		keyURI := fs.String("key", "", "URI of the signing key")
+		certs := fs.String("certs", "", "PEM file with the certificate or attestation chain of the signing key")
+		roots := fs.String("roots", "", "PEM file with the trusted root certificates")
+		pubKey := fs.String("pubkey", "", "PEM file with the trusted public key of the signer")
+
+		if len(os.Args) < 3 {
+			slog.Error("Usage: hawkes bundle (sign|verify) [-key uri] [-certs file] [-roots file] [-pubkey file] [bundle] [artifact]")
+			os.Exit(-1)
+		}
+
+		_ = fs.Parse(os.Args[3:])
+
+		readCerts := func(fn string) ([]*x509.Certificate, error) {
+			data, err := os.ReadFile(fn)
+			if err != nil {
+				return nil, err
+			}
+
+			var certs []*x509.Certificate
+			for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+				cert, err := x509.ParseCertificate(block.Bytes)
+				if err != nil {
+					return nil, err
+				}
+
+				certs = append(certs, cert)
+			}
+
+			return certs, nil
+		}
+
+		switch {
+		case os.Args[2] == "sign" && fs.NArg() == 1 && *keyURI != "":
+			opts := &bundle.SignOptions{}
+			if *certs != "" {
+				var err error
+				if opts.Certificates, err = readCerts(*certs); err != nil {
+					slog.Error("Failed to read certificates", slog.Any("error", err))
+					os.Exit(-1)
+				}
+			}
+
+			f, err := os.Open(fs.Arg(0))
+			if err != nil {
+				slog.Error("Failed to open artifact", slog.Any("error", err))
+				os.Exit(-1)
+			}
+			defer f.Close()
+
+			path, err := config.DefaultPath()
+			if err != nil {
+				slog.Error("Failed to find configuration", slog.Any("error", err))
+				os.Exit(-1)
+			}
+
+			cfg, err := config.Load(path)
+			if err != nil {
+				slog.Error("Failed to load configuration", slog.Any("error", err))
+				os.Exit(-1)
+			}
+
+			mpCfg, err := cfg.MultiProviderConfig()
+			if err != nil {
+				slog.Error("Failed to load configuration", slog.Any("error", err))
+				os.Exit(-1)
+			}
+
+			p, err := provider.NewProvider(mpCfg)
+			if err != nil {
+				slog.Error("Failed to open providers", slog.Any("error", err))
+				os.Exit(-1)
+			}
+			defer p.Close()
+
+			key, err := p.OpenKeyURI(*keyURI)
+			if err != nil {
+				slog.Error("Failed to open key", slog.Any("error", err))
+				p.Close()
+				os.Exit(-1) //nolint:gocritic
+			}
+			defer key.Close()
+
+			sk, ok := key.(provider.PrivateKeySigner)
+			if !ok {
+				slog.Error("Key does not support signing")
+				p.Close()
+				os.Exit(-1)
+			}
+
+			signer, err := sk.Signer()
+			if err != nil {
+				slog.Error("Failed to get signer", slog.Any("error", err))
+				p.Close()
+				os.Exit(-1)
+			}
+
+			b, err := bundle.Sign(signer, f, opts)
+			if err != nil {
+				slog.Error("Failed to sign artifact", slog.Any("error", err))
+				p.Close()
+				os.Exit(-1)
+			}
+
+			data, err := b.Marshal()
+			if err != nil {
+				slog.Error("Failed to encode bundle", slog.Any("error", err))
+				p.Close()
+				os.Exit(-1)
+			}
+
+			fmt.Println(string(data))
+
+		case os.Args[2] == "verify" && fs.NArg() == 2:
+			opts := bundle.VerifyOptions{}
+
+			if *roots != "" {
+				certs, err := readCerts(*roots)
+				if err != nil {
+					slog.Error("Failed to read roots", slog.Any("error", err))
+					os.Exit(-1)
+				}
+
+				opts.Roots = x509.NewCertPool()
+				for _, cert := range certs {
+					opts.Roots.AddCert(cert)
+				}
+			}
+
+			if *pubKey != "" {
+				data, err := os.ReadFile(*pubKey)
+				if err != nil {
+					slog.Error("Failed to read public key", slog.Any("error", err))
+					os.Exit(-1)
+				}
+
+				block, _ := pem.Decode(data)
+				if block == nil {
+					slog.Error("Failed to decode public key")
+					os.Exit(-1)
+				}
+
+				if opts.PublicKey, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+					slog.Error("Failed to parse public key", slog.Any("error", err))
+					os.Exit(-1)
+				}
+			}
+
+			data, err := os.ReadFile(fs.Arg(0))
+			if err != nil {
+				slog.Error("Failed to read bundle", slog.Any("error", err))
+				os.Exit(-1)
+			}
+
+			f, err := os.Open(fs.Arg(1))
+			if err != nil {
+				slog.Error("Failed to open artifact", slog.Any("error", err))
+				os.Exit(-1)
+			}
+			defer f.Close()
+
+			v, err := bundle.Verify(data, f, opts)
+			if err != nil {
+				slog.Error("Failed to verify artifact", slog.Any("error", err))
+				f.Close()
+				os.Exit(1) //nolint:gocritic
+			}
+
+			slog.Info("Verified artifact", slog.Time("signed", v.Time))
+
+		default:
+			slog.Error("Usage: hawkes bundle (sign|verify) [-key uri] [-certs file] [-roots file] [-pubkey file] [bundle] [artifact]")
+			os.Exit(-1)
+		}
+
+	case "events":
+		fs := flag.NewFlagSet("events", flag.ExitOnError)
+		interval := fs.Duration("interval", device.DefaultWatchInterval, "period between two discoveries of devices")
+		_ = fs.Parse(os.Args[2:])
+
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer cancel()
+
+		bus := event.NewBus()
+		sub := bus.Subscribe(0)
+
+		path, err := config.DefaultPath()
+		if err != nil {
+			slog.Error("Failed to find configuration", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		cfg, err := config.Load(path)
+		if err != nil {
+			slog.Error("Failed to load configuration", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		mpCfg, err := cfg.MultiProviderConfig()
+		if err != nil {
+			slog.Error("Failed to load configuration", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		mgr, err := cfg.PINManager()
+		if err != nil {
+			slog.Error("Failed to load configuration", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		mgr.Events = bus
+
+		mpCfg.Unlock = mgr.Unlock
+		mpCfg.Events = bus
+
+		p, err := provider.NewProvider(mpCfg)
+		if err != nil {
+			slog.Error("Failed to open providers", slog.Any("error", err))
+			os.Exit(-1)
+		}
+		defer p.Close()
+
+		go device.Watch(ctx, bus, *interval, nil)
+
+		enc := json.NewEncoder(os.Stdout)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case e := <-sub.C():
+				if err := enc.Encode(e); err != nil {
+					slog.Error("Failed to encode event", slog.Any("error", err))
+					p.Close()
+					os.Exit(-1) //nolint:gocritic
+				}
+			}
+		}
+
+	case "audit":
+		fs := flag.NewFlagSet("audit verify", flag.ExitOnError)
+		pubKey := fs.String("pubkey", "", "PEM file with the public key of the sealer (default: key of the configured sealer)")
+
+		if len(os.Args) < 3 || os.Args[2] != "verify" {
+			slog.Error("Usage: hawkes audit verify [-pubkey file] [log]")
+			os.Exit(-1)
+		}
+
+		_ = fs.Parse(os.Args[3:])
+
+		path, err := config.DefaultPath()
+		if err != nil {
+			slog.Error("Failed to find configuration", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		cfg, err := config.Load(path)
+		if err != nil {
+			slog.Error("Failed to load configuration", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		logPath := fs.Arg(0)
+		if logPath == "" {
+			if cfg.Audit == nil {
+				slog.Error("No audit log configured")
+				os.Exit(-1)
+			}
+
+			logPath = cfg.Audit.Path
+		}
+
+		var pub crypto.PublicKey
+
+		if *pubKey != "" {
+			data, err := os.ReadFile(*pubKey)
+			if err != nil {
+				slog.Error("Failed to read public key", slog.Any("error", err))
+				os.Exit(-1)
+			}
+
+			block, _ := pem.Decode(data)
+			if block == nil {
+				slog.Error("Failed to decode public key")
+				os.Exit(-1)
+			}
+
+			if pub, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+				slog.Error("Failed to parse public key", slog.Any("error", err))
+				os.Exit(-1)
+			}
+		} else {
+			if cfg.Audit == nil {
+				slog.Error("No audit sealer configured")
+				os.Exit(-1)
+			}
+
+			uri, err := cfg.Audit.SealerURI(cfg)
+			if err != nil {
+				slog.Error("Failed to load configuration", slog.Any("error", err))
+				os.Exit(-1)
+			}
+
+			// The log must not be appended while it is verified
+			cfg.Audit = nil
+
+			p, err := cfg.NewProvider()
+			if err != nil {
+				slog.Error("Failed to open providers", slog.Any("error", err))
+				os.Exit(-1)
+			}
+
+			key, err := p.OpenKeyURI(uri)
+			if err != nil {
+				slog.Error("Failed to open audit sealer", slog.Any("error", err))
+				p.Close()
+				os.Exit(-1)
+			}
+
+			sk, ok := key.(provider.PrivateKeySigner)
+			if !ok {
+				slog.Error("Audit sealer does not support signing")
+				p.Close()
+				os.Exit(-1)
+			}
+
+			signer, err := sk.Signer()
+			if err != nil {
+				slog.Error("Failed to get signer", slog.Any("error", err))
+				p.Close()
+				os.Exit(-1)
+			}
+
+			pub = signer.Public()
+
+			key.Close()
+			p.Close()
+		}
+
+		f, err := os.Open(logPath)
+		if err != nil {
+			slog.Error("Failed to open audit log", slog.Any("error", err))
+			os.Exit(-1)
+		}
+		defer f.Close()
+
+		res, err := audit.Verify(f, pub)
+		if err != nil {
+			slog.Error("Failed to verify audit log", slog.Any("error", err))
+			f.Close()
+			os.Exit(-1) //nolint:gocritic
+		}
+
+		slog.Info("Audit log is valid",
+			slog.Uint64("entries", res.Entries),
+			slog.Uint64("sealed", res.Sealed))
+
+	case "attest":
+		fs := flag.NewFlagSet("attest report", flag.ExitOnError)
+		keyID := fs.String("key", "", "ID of the provider key signing the report, unsigned if empty")
+
+		if len(os.Args) < 3 || os.Args[2] != "report" {
+			slog.Error("Usage: hawkes attest report [-key id]")
+			os.Exit(-1)
+		}
+
+		_ = fs.Parse(os.Args[3:])
+
+		sc, err := scard.EstablishContext()
+		if err != nil {
+			slog.Error("Failed to establish scard context", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		cards, err := pcsc.OpenCards(sc, 0, filter.Any, true)
+		if err != nil {
+			slog.Error("Failed to open cards", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		report := inventory.Collect(cards)
+
+		for _, card := range cards {
+			card.Close()
+		}
+
+		if *keyID == "" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+
+			if err := enc.Encode(report); err != nil {
+				slog.Error("Failed to write report", slog.Any("error", err))
+				os.Exit(-1)
+			}
+
+			return
+		}
+
+		var id provider.KeyID
+		if err := id.UnmarshalText([]byte(*keyID)); err != nil {
+			slog.Error("Failed to decode key ID", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		path, err := config.DefaultPath()
+		if err != nil {
+			slog.Error("Failed to find configuration", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		cfg, err := config.Load(path)
+		if err != nil {
+			slog.Error("Failed to load configuration", slog.Any("error", err))
+			os.Exit(-1)
+		}
+
+		p, err := cfg.NewProvider()
+		if err != nil {
+			slog.Error("Failed to open providers", slog.Any("error", err))
+			os.Exit(-1)
+		}
+		defer p.Close()
+
+		key, err := p.OpenKey(id)
+		if err != nil {
+			slog.Error("Failed to open key", slog.Any("error", err))
+			p.Close()
+			os.Exit(-1) //nolint:gocritic
+		}
+
+		sk, err := jose.NewProviderSigningKey(key)
+		if err != nil {
+			slog.Error("Failed to create signing key", slog.Any("error", err))
+			p.Close()
+			os.Exit(-1)
+		}
+
+		token, err := report.Sign(sk)
+		if err != nil {
+			slog.Error("Failed to sign report", slog.Any("error", err))
+			p.Close()
+			os.Exit(-1)
+		}
+
+		fmt.Println(token)
+
+	default:
+		if !secureEnclave(os.Args[1:]) {
+			slog.Error("Unknown command", slog.String("command", os.Args[1]))
+			os.Exit(-1)
+		}
+	}
+}
+
+// yubikeyInfo reads the device information of a YubiKey and optionally enforces the policy.
+func yubikeyInfo(card iso7816.PCSCCard, p *yubikey.Policy, enforce bool, lockCode []byte) (*yubikey.DeviceInfo, error) {
+	c, err := yubikey.NewCard(card)
+	if err != nil {
+		return nil, err
+	}
+
+	if enforce {
+		return p.Enforce(c, lockCode)
+	}
+
+	return c.DeviceInfo()
+}
+
+// importPIVKey imports a key after authenticating with the management key.
+// A dry run records the import in the plan after the authentication.
+func importPIVKey(sc iso7816.PCSCCard, mgmtKey []byte, slot piv.Slot, key crypto.PrivateKey, policies piv.Policies, plan *provider.Plan) error {
+	card, err := piv.NewCard(sc)
+	if err != nil {
+		return err
+	}
+
+	if err := card.Authenticate(mgmtKey); err != nil {
+		return err
+	}
+
+	var importer provider.PIVImporter = card
+	if plan != nil {
+		importer = provider.DryRunPIV("piv", plan)
+	}
+
+	return importer.ImportKey(slot, key, policies)
 }
@@ -0,0 +1,241 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package cms implements the Cryptographic Message Syntax (RFC 5652) for
+// signing and encrypting messages with keys held by providers such as PIV cards.
+//
+// Only the subset which is required for S/MIME and detached code signatures is
+// supported: SignedData with ECDSA and RSA signers as well as EnvelopedData
+// with RSA key transport and ECDH key agreement recipients.
+// Indefinite-length BER encodings are not supported.
+package cms
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/cryptobyte"
+	cbasn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+var (
+	ErrMalformed            = errors.New("malformed message")
+	ErrUnsupportedContent   = errors.New("unsupported content type")
+	ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+	ErrUnsupportedKey       = errors.New("unsupported key")
+	ErrInvalidSignature     = errors.New("invalid signature")
+	ErrNoSigner             = errors.New("signer certificate not found")
+	ErrNoRecipient          = errors.New("no matching recipient")
+	ErrDecrypt              = errors.New("failed to decrypt")
+)
+
+//nolint:gochecknoglobals
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+
+	oidAttrContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttrMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttrSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+
+	oidRSAEncryption   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidRSAESOAEP       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 7}
+	oidMGF1            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8}
+	oidSHA256WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidSHA384WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}
+	oidSHA512WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}
+	oidECPublicKey     = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSAWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidECDSAWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+	oidSHA1            = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+
+	oidAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidAES128Wrap = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 5}
+	oidAES192Wrap = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 25}
+	oidAES256Wrap = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 45}
+
+	oidECDHSHA1KDF   = asn1.ObjectIdentifier{1, 3, 133, 16, 840, 63, 0, 2}
+	oidECDHSHA256KDF = asn1.ObjectIdentifier{1, 3, 132, 1, 11, 1}
+	oidECDHSHA384KDF = asn1.ObjectIdentifier{1, 3, 132, 1, 11, 2}
+	oidECDHSHA512KDF = asn1.ObjectIdentifier{1, 3, 132, 1, 11, 3}
+
+	// The cofactor variants are equivalent for the NIST curves with a cofactor of one
+	oidECDHCofactorSHA1KDF   = asn1.ObjectIdentifier{1, 3, 133, 16, 840, 63, 0, 3}
+	oidECDHCofactorSHA256KDF = asn1.ObjectIdentifier{1, 3, 132, 1, 14, 1}
+	oidECDHCofactorSHA384KDF = asn1.ObjectIdentifier{1, 3, 132, 1, 14, 2}
+	oidECDHCofactorSHA512KDF = asn1.ObjectIdentifier{1, 3, 132, 1, 14, 3}
+)
+
+func hashOID(h crypto.Hash) (asn1.ObjectIdentifier, error) {
+	switch h {
+	case crypto.SHA256:
+		return oidSHA256, nil
+	case crypto.SHA384:
+		return oidSHA384, nil
+	case crypto.SHA512:
+		return oidSHA512, nil
+	default:
+		return nil, fmt.Errorf("%w: hash %s", ErrUnsupportedAlgorithm, h)
+	}
+}
+
+func hashByOID(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case oid.Equal(oidSHA1):
+		return crypto.SHA1, nil
+	case oid.Equal(oidSHA256):
+		return crypto.SHA256, nil
+	case oid.Equal(oidSHA384):
+		return crypto.SHA384, nil
+	case oid.Equal(oidSHA512):
+		return crypto.SHA512, nil
+	default:
+		return 0, fmt.Errorf("%w: digest %s", ErrUnsupportedAlgorithm, oid)
+	}
+}
+
+// algorithmIdentifier is a parsed AlgorithmIdentifier whose parameters are kept raw.
+type algorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters cryptobyte.String // Including tag and length, empty if absent
+}
+
+func readAlgorithmIdentifier(s *cryptobyte.String, out *algorithmIdentifier) bool {
+	var seq cryptobyte.String
+	if !s.ReadASN1(&seq, cbasn1.SEQUENCE) || !seq.ReadASN1ObjectIdentifier(&out.Algorithm) {
+		return false
+	}
+
+	if !seq.Empty() {
+		var tag cbasn1.Tag
+		if !seq.ReadAnyASN1Element(&out.Parameters, &tag) {
+			return false
+		}
+	}
+
+	return seq.Empty()
+}
+
+func addAlgorithmIdentifier(b *cryptobyte.Builder, oid asn1.ObjectIdentifier, params func(b *cryptobyte.Builder)) {
+	b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1ObjectIdentifier(oid)
+
+		if params != nil {
+			params(b)
+		}
+	})
+}
+
+// issuerAndSerial identifies a certificate by its issuer and serial number.
+type issuerAndSerial struct {
+	Issuer []byte // DER-encoded Name
+	Serial *big.Int
+}
+
+func newIssuerAndSerial(cert *x509.Certificate) issuerAndSerial {
+	return issuerAndSerial{
+		Issuer: cert.RawIssuer,
+		Serial: cert.SerialNumber,
+	}
+}
+
+func (i issuerAndSerial) add(b *cryptobyte.Builder) {
+	b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddBytes(i.Issuer)
+		b.AddASN1BigInt(i.Serial)
+	})
+}
+
+func readIssuerAndSerial(s *cryptobyte.String, out *issuerAndSerial) bool {
+	var seq, name cryptobyte.String
+
+	out.Serial = new(big.Int)
+
+	if !s.ReadASN1(&seq, cbasn1.SEQUENCE) ||
+		!seq.ReadASN1Element(&name, cbasn1.SEQUENCE) ||
+		!seq.ReadASN1Integer(out.Serial) ||
+		!seq.Empty() {
+		return false
+	}
+
+	out.Issuer = name
+
+	return true
+}
+
+func (i issuerAndSerial) matches(cert *x509.Certificate) bool {
+	return bytes.Equal(i.Issuer, cert.RawIssuer) && i.Serial.Cmp(cert.SerialNumber) == 0
+}
+
+// contentInfo wraps content of the given type into a ContentInfo structure.
+func contentInfo(contentType asn1.ObjectIdentifier, content func(b *cryptobyte.Builder)) ([]byte, error) {
+	b := cryptobyte.NewBuilder(nil)
+	b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1ObjectIdentifier(contentType)
+		b.AddASN1(cbasn1.Tag(0).Constructed().ContextSpecific(), content)
+	})
+
+	return b.Bytes()
+}
+
+// parseContentInfo returns the content of a ContentInfo structure with the expected type.
+func parseContentInfo(der []byte, expected asn1.ObjectIdentifier) (cryptobyte.String, error) {
+	var (
+		input       = cryptobyte.String(der)
+		seq, inner  cryptobyte.String
+		contentType asn1.ObjectIdentifier
+	)
+
+	if !input.ReadASN1(&seq, cbasn1.SEQUENCE) ||
+		!seq.ReadASN1ObjectIdentifier(&contentType) ||
+		!seq.ReadASN1(&inner, cbasn1.Tag(0).Constructed().ContextSpecific()) {
+		return nil, ErrMalformed
+	}
+
+	if !contentType.Equal(expected) {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedContent, contentType)
+	}
+
+	return inner, nil
+}
+
+// readOctetString reads an OCTET STRING which might use the constructed encoding
+// with the given tag as it is produced by some encoders for large content.
+func readOctetString(s *cryptobyte.String, out *[]byte, tag cbasn1.Tag) bool {
+	if s.PeekASN1Tag(tag) {
+		return s.ReadASN1Bytes(out, tag)
+	}
+
+	var segments cryptobyte.String
+	if !s.ReadASN1(&segments, tag.Constructed()) {
+		return false
+	}
+
+	var buf []byte
+
+	for !segments.Empty() {
+		var segment []byte
+		if !segments.ReadASN1Bytes(&segment, cbasn1.OCTET_STRING) {
+			return false
+		}
+
+		buf = append(buf, segment...)
+	}
+
+	*out = buf
+
+	return true
+}
@@ -0,0 +1,167 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package cms_test
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/ca"
+	"cunicu.li/hawkes/cms"
+	"cunicu.li/hawkes/ecdh/sw"
+)
+
+func newPKI(t *testing.T) (*ca.CA, *x509.CertPool) {
+	require := require.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	cert, err := ca.SelfSigned(key, pkix.Name{CommonName: "hawkes Root CA"}, 24*time.Hour)
+	require.NoError(err)
+
+	c, err := ca.New(cert, key, nil)
+	require.NoError(err)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	return c, roots
+}
+
+func issue(t *testing.T, c *ca.CA, name string, pub crypto.PublicKey) *x509.Certificate {
+	cert, err := c.Issue(&x509.Certificate{
+		Subject:        pkix.Name{CommonName: name},
+		EmailAddresses: []string{name + "@example.com"},
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}, pub)
+	require.NoError(t, err)
+
+	return cert
+}
+
+func TestSignVerify(t *testing.T) {
+	c, roots := newPKI(t)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	content := []byte("Hello hawkes")
+
+	for _, key := range []crypto.Signer{ecKey, rsaKey} {
+		for _, detached := range []bool{false, true} {
+			require := require.New(t)
+
+			cert := issue(t, c, "alice", key.Public())
+
+			der, err := cms.Sign(content, cert, key, &cms.SignOptions{
+				Detached: detached,
+				Hash:     crypto.SHA384,
+			})
+			require.NoError(err)
+
+			opts := cms.VerifyOptions{
+				X509: x509.VerifyOptions{
+					Roots:     roots,
+					KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+				},
+			}
+
+			if detached {
+				_, err = cms.Verify(der, opts)
+				require.ErrorIs(err, cms.ErrMalformed)
+
+				opts.Content = content
+			}
+
+			msg, err := cms.Verify(der, opts)
+			require.NoError(err)
+			require.Equal(content, msg.Content)
+			require.Len(msg.Signers, 1)
+			require.Equal(cert.Raw, msg.Signers[0].Raw)
+			require.WithinDuration(time.Now(), msg.SigningTime, time.Minute)
+
+			// Tampered content must be rejected
+			if detached {
+				opts.Content = []byte("Hello world")
+
+				_, err = cms.Verify(der, opts)
+				require.ErrorIs(err, cms.ErrInvalidSignature)
+			}
+		}
+	}
+}
+
+func TestVerifyUntrusted(t *testing.T) {
+	require := require.New(t)
+
+	c, _ := newPKI(t)
+	_, otherRoots := newPKI(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	der, err := cms.Sign([]byte("test"), issue(t, c, "mallory", key.Public()), key, nil)
+	require.NoError(err)
+
+	_, err = cms.Verify(der, cms.VerifyOptions{
+		X509: x509.VerifyOptions{
+			Roots: otherRoots,
+		},
+	})
+	require.Error(err)
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	require := require.New(t)
+
+	c, _ := newPKI(t)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(err)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	ecdhKey, err := ecKey.ECDH()
+	require.NoError(err)
+
+	rsaCert := issue(t, c, "bob", rsaKey.Public())
+	ecCert := issue(t, c, "carol", ecKey.Public())
+
+	content := []byte("Attack at dawn")
+
+	der, err := cms.Encrypt(content, []*x509.Certificate{rsaCert, ecCert})
+	require.NoError(err)
+
+	plaintext, err := cms.Decrypt(der, rsaCert, rsaKey)
+	require.NoError(err)
+	require.Equal(content, plaintext)
+
+	plaintext, err = cms.Decrypt(der, ecCert, &sw.PrivateKey{PrivateKey: ecdhKey})
+	require.NoError(err)
+	require.Equal(content, plaintext)
+
+	// A certificate which is not among the recipients
+	otherKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	require.NoError(err)
+
+	otherCert := issue(t, c, "dave", ecKey.Public())
+
+	_, err = cms.Decrypt(der, otherCert, &sw.PrivateKey{PrivateKey: otherKey})
+	require.ErrorIs(err, cms.ErrNoRecipient)
+}
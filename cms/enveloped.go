@@ -0,0 +1,596 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package cms
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+
+	"golang.org/x/crypto/cryptobyte"
+	cbasn1 "golang.org/x/crypto/cryptobyte/asn1"
+
+	ecdhx "cunicu.li/hawkes/ecdh"
+	"cunicu.li/hawkes/internal/keywrap"
+)
+
+//nolint:gochecknoglobals
+var (
+	tagKeyAgreeRecipient = cbasn1.Tag(1).Constructed().ContextSpecific()
+	tagOriginator        = cbasn1.Tag(0).Constructed().ContextSpecific()
+	tagOriginatorKey     = cbasn1.Tag(1).Constructed().ContextSpecific()
+	tagUKM               = cbasn1.Tag(1).Constructed().ContextSpecific()
+	tagSKI               = cbasn1.Tag(0).ContextSpecific()
+	tagRecipientKeyID    = cbasn1.Tag(0).Constructed().ContextSpecific()
+	tagEncryptedContent  = cbasn1.Tag(0).ContextSpecific()
+)
+
+// Encrypt creates an EnvelopedData message of the content for the given recipients.
+//
+// The content is encrypted with AES-256-CBC. The content-encryption key is
+// transported to RSA recipients with PKCS #1 v1.5 as it is supported by PIV cards,
+// and agreed with EC recipients via ephemeral-static ECDH (RFC 5753).
+func Encrypt(content []byte, recipients []*x509.Certificate) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, ErrNoRecipient
+	}
+
+	cek := make([]byte, 32)
+	iv := make([]byte, aes.BlockSize)
+
+	if _, err := rand.Read(cek); err != nil {
+		return nil, err
+	}
+
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext := pad(content, aes.BlockSize)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+
+	version := int64(0)
+	recipientInfos := make([]func(b *cryptobyte.Builder), 0, len(recipients))
+
+	for _, cert := range recipients {
+		var ri func(b *cryptobyte.Builder)
+
+		switch pub := cert.PublicKey.(type) {
+		case *rsa.PublicKey:
+			ri, err = keyTransRecipient(cert, pub, cek)
+
+		case *ecdsa.PublicKey:
+			ri, err = keyAgreeRecipient(cert, pub, cek)
+			version = 2
+
+		default:
+			err = fmt.Errorf("%w: %T", ErrUnsupportedKey, cert.PublicKey)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		recipientInfos = append(recipientInfos, ri)
+	}
+
+	return contentInfo(oidEnvelopedData, func(b *cryptobyte.Builder) {
+		b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			b.AddASN1Int64(version)
+
+			b.AddASN1(cbasn1.SET, func(b *cryptobyte.Builder) {
+				for _, ri := range recipientInfos {
+					ri(b)
+				}
+			})
+
+			// encryptedContentInfo
+			b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				b.AddASN1ObjectIdentifier(oidData)
+				addAlgorithmIdentifier(b, oidAES256CBC, func(b *cryptobyte.Builder) {
+					b.AddASN1OctetString(iv)
+				})
+				b.AddASN1(tagEncryptedContent, func(b *cryptobyte.Builder) {
+					b.AddBytes(ciphertext)
+				})
+			})
+		})
+	})
+}
+
+func keyTransRecipient(cert *x509.Certificate, pub *rsa.PublicKey, cek []byte) (func(b *cryptobyte.Builder), error) {
+	encryptedKey, err := rsa.EncryptPKCS1v15(rand.Reader, pub, cek)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt key: %w", err)
+	}
+
+	return func(b *cryptobyte.Builder) {
+		b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			b.AddASN1Int64(0) // version
+			newIssuerAndSerial(cert).add(b)
+			addAlgorithmIdentifier(b, oidRSAEncryption, func(b *cryptobyte.Builder) { b.AddASN1NULL() })
+			b.AddASN1OctetString(encryptedKey)
+		})
+	}, nil
+}
+
+func keyAgreeRecipient(cert *x509.Certificate, pub *ecdsa.PublicKey, cek []byte) (func(b *cryptobyte.Builder), error) {
+	ecdhPub, err := pub.ECDH()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsupportedKey, err)
+	}
+
+	kdfOID, h := oidECDHSHA256KDF, crypto.SHA256
+	if ecdhPub.Curve() != ecdh.P256() {
+		kdfOID, h = oidECDHSHA384KDF, crypto.SHA384
+	}
+
+	ephemeral, err := ecdhPub.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := ephemeral.ECDH(ecdhPub)
+	if err != nil {
+		return nil, err
+	}
+
+	kek, err := deriveKEK(h, secret, oidAES256Wrap, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedKey, err := keywrap.Wrap(kek, cek)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(b *cryptobyte.Builder) {
+		b.AddASN1(tagKeyAgreeRecipient, func(b *cryptobyte.Builder) {
+			b.AddASN1Int64(3) // version
+
+			b.AddASN1(tagOriginator, func(b *cryptobyte.Builder) {
+				b.AddASN1(tagOriginatorKey, func(b *cryptobyte.Builder) {
+					addAlgorithmIdentifier(b, oidECPublicKey, nil)
+					b.AddASN1BitString(ephemeral.PublicKey().Bytes())
+				})
+			})
+
+			addAlgorithmIdentifier(b, kdfOID, func(b *cryptobyte.Builder) {
+				addAlgorithmIdentifier(b, oidAES256Wrap, nil)
+			})
+
+			b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+					newIssuerAndSerial(cert).add(b)
+					b.AddASN1OctetString(encryptedKey)
+				})
+			})
+		})
+	}, nil
+}
+
+// deriveKEK derives the key-encryption key from the ECDH shared secret
+// using the ECC-CMS-SharedInfo of RFC 5753 Section 7.2.
+func deriveKEK(h crypto.Hash, secret []byte, wrapOID asn1.ObjectIdentifier, ukm []byte) ([]byte, error) {
+	keyLen, err := wrapKeyLen(wrapOID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !h.Available() {
+		return nil, fmt.Errorf("%w: hash %s", ErrUnsupportedAlgorithm, h)
+	}
+
+	suppPubInfo := binary.BigEndian.AppendUint32(nil, uint32(keyLen*8)) //nolint:gosec
+
+	b := cryptobyte.NewBuilder(nil)
+	b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		addAlgorithmIdentifier(b, wrapOID, nil)
+
+		if ukm != nil {
+			b.AddASN1(cbasn1.Tag(0).Constructed().ContextSpecific(), func(b *cryptobyte.Builder) {
+				b.AddASN1OctetString(ukm)
+			})
+		}
+
+		b.AddASN1(cbasn1.Tag(2).Constructed().ContextSpecific(), func(b *cryptobyte.Builder) {
+			b.AddASN1OctetString(suppPubInfo)
+		})
+	})
+
+	sharedInfo, err := b.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	return x963KDF(h.New, secret, sharedInfo, keyLen), nil
+}
+
+func wrapKeyLen(oid asn1.ObjectIdentifier) (int, error) {
+	switch {
+	case oid.Equal(oidAES128Wrap):
+		return 16, nil
+	case oid.Equal(oidAES192Wrap):
+		return 24, nil
+	case oid.Equal(oidAES256Wrap):
+		return 32, nil
+	}
+
+	return 0, fmt.Errorf("%w: key wrap %s", ErrUnsupportedAlgorithm, oid)
+}
+
+// Decrypt decrypts an EnvelopedData message for the recipient certificate.
+//
+// The key must either be a crypto.Decrypter for RSA recipients
+// or an ecdh.PrivateKey like those returned by providers for EC recipients.
+func Decrypt(der []byte, cert *x509.Certificate, key crypto.PrivateKey) ([]byte, error) {
+	inner, err := parseContentInfo(der, oidEnvelopedData)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		ed, recipientInfos, eci cryptobyte.String
+		version                 int64
+		contentType             asn1.ObjectIdentifier
+		contentAlg              algorithmIdentifier
+		ciphertext              []byte
+	)
+
+	if !inner.ReadASN1(&ed, cbasn1.SEQUENCE) ||
+		!ed.ReadASN1Integer(&version) ||
+		!ed.SkipOptionalASN1(cbasn1.Tag(0).Constructed().ContextSpecific()) ||
+		!ed.ReadASN1(&recipientInfos, cbasn1.SET) ||
+		!ed.ReadASN1(&eci, cbasn1.SEQUENCE) ||
+		!eci.ReadASN1ObjectIdentifier(&contentType) ||
+		!readAlgorithmIdentifier(&eci, &contentAlg) ||
+		!readOctetString(&eci, &ciphertext, tagEncryptedContent) {
+		return nil, ErrMalformed
+	}
+
+	var cek []byte
+
+	for cek == nil && !recipientInfos.Empty() {
+		var (
+			ri  cryptobyte.String
+			tag cbasn1.Tag
+		)
+
+		if !recipientInfos.ReadAnyASN1(&ri, &tag) {
+			return nil, ErrMalformed
+		}
+
+		switch tag {
+		case cbasn1.SEQUENCE:
+			cek, err = decryptKeyTrans(ri, cert, key)
+		case tagKeyAgreeRecipient:
+			cek, err = decryptKeyAgree(ri, cert, key)
+		default:
+			continue // Unsupported recipient type
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cek == nil {
+		return nil, ErrNoRecipient
+	}
+
+	return decryptContent(contentAlg, cek, ciphertext)
+}
+
+func decryptKeyTrans(ri cryptobyte.String, cert *x509.Certificate, key crypto.PrivateKey) ([]byte, error) {
+	var (
+		version      int64
+		rid          issuerAndSerial
+		ski          []byte
+		alg          algorithmIdentifier
+		encryptedKey []byte
+	)
+
+	if !ri.ReadASN1Integer(&version) {
+		return nil, ErrMalformed
+	}
+
+	if ri.PeekASN1Tag(tagSKI) {
+		if !ri.ReadASN1Bytes(&ski, tagSKI) {
+			return nil, ErrMalformed
+		}
+	} else if !readIssuerAndSerial(&ri, &rid) {
+		return nil, ErrMalformed
+	}
+
+	if !readAlgorithmIdentifier(&ri, &alg) ||
+		!ri.ReadASN1Bytes(&encryptedKey, cbasn1.OCTET_STRING) {
+		return nil, ErrMalformed
+	}
+
+	if ski != nil && !bytes.Equal(ski, cert.SubjectKeyId) || ski == nil && !rid.matches(cert) {
+		return nil, nil //nolint:nilnil
+	}
+
+	dec, ok := key.(crypto.Decrypter)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T is not a decrypter", ErrUnsupportedKey, key)
+	}
+
+	var opts crypto.DecrypterOpts
+
+	switch {
+	case alg.Algorithm.Equal(oidRSAEncryption):
+		opts = &rsa.PKCS1v15DecryptOptions{}
+
+	case alg.Algorithm.Equal(oidRSAESOAEP):
+		h, err := parseOAEPParams(alg.Parameters)
+		if err != nil {
+			return nil, err
+		}
+
+		opts = &rsa.OAEPOptions{Hash: h, MGFHash: h}
+
+	default:
+		return nil, fmt.Errorf("%w: key transport %s", ErrUnsupportedAlgorithm, alg.Algorithm)
+	}
+
+	cek, err := dec.Decrypt(rand.Reader, encryptedKey, opts)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecrypt, err)
+	}
+
+	return cek, nil
+}
+
+// parseOAEPParams returns the hash of RSAES-OAEP-params.
+// Only parameters with the same hash for OAEP and MGF1 are supported.
+func parseOAEPParams(params cryptobyte.String) (crypto.Hash, error) {
+	h, mgfHash := crypto.SHA1, crypto.SHA1
+
+	var seq cryptobyte.String
+	if len(params) > 0 && !params.ReadASN1(&seq, cbasn1.SEQUENCE) {
+		return 0, ErrMalformed
+	}
+
+	var (
+		hashAlg, mgfAlg, mgfHashAlg algorithmIdentifier
+		explicit                    cryptobyte.String
+		present                     bool
+		err                         error
+	)
+
+	if !seq.ReadOptionalASN1(&explicit, &present, cbasn1.Tag(0).Constructed().ContextSpecific()) {
+		return 0, ErrMalformed
+	} else if present {
+		if !readAlgorithmIdentifier(&explicit, &hashAlg) {
+			return 0, ErrMalformed
+		}
+
+		if h, err = hashByOID(hashAlg.Algorithm); err != nil {
+			return 0, err
+		}
+	}
+
+	if !seq.ReadOptionalASN1(&explicit, &present, cbasn1.Tag(1).Constructed().ContextSpecific()) {
+		return 0, ErrMalformed
+	} else if present {
+		if !readAlgorithmIdentifier(&explicit, &mgfAlg) || !mgfAlg.Algorithm.Equal(oidMGF1) ||
+			!readAlgorithmIdentifier(&mgfAlg.Parameters, &mgfHashAlg) {
+			return 0, ErrMalformed
+		}
+
+		if mgfHash, err = hashByOID(mgfHashAlg.Algorithm); err != nil {
+			return 0, err
+		}
+	}
+
+	if h != mgfHash {
+		return 0, fmt.Errorf("%w: OAEP with different MGF1 hash", ErrUnsupportedAlgorithm)
+	}
+
+	return h, nil
+}
+
+func decryptKeyAgree(ri cryptobyte.String, cert *x509.Certificate, key crypto.PrivateKey) ([]byte, error) {
+	var (
+		version                int64
+		originator, origKey    cryptobyte.String
+		origAlg                algorithmIdentifier
+		ukmExplicit            cryptobyte.String
+		hasUKM                 bool
+		ukm                    []byte
+		kdfAlg, wrapAlg        algorithmIdentifier
+		recipientEncryptedKeys cryptobyte.String
+		ephemeralBytes         []byte
+	)
+
+	if !ri.ReadASN1Integer(&version) ||
+		!ri.ReadASN1(&originator, tagOriginator) {
+		return nil, ErrMalformed
+	}
+
+	// Only ephemeral originator keys are supported
+	if !originator.ReadASN1(&origKey, tagOriginatorKey) ||
+		!readAlgorithmIdentifier(&origKey, &origAlg) ||
+		!origKey.ReadASN1BitStringAsBytes(&ephemeralBytes) {
+		return nil, fmt.Errorf("%w: unsupported originator", ErrMalformed)
+	}
+
+	if !ri.ReadOptionalASN1(&ukmExplicit, &hasUKM, tagUKM) ||
+		hasUKM && !ukmExplicit.ReadASN1Bytes(&ukm, cbasn1.OCTET_STRING) ||
+		!readAlgorithmIdentifier(&ri, &kdfAlg) ||
+		!readAlgorithmIdentifier(&kdfAlg.Parameters, &wrapAlg) ||
+		!ri.ReadASN1(&recipientEncryptedKeys, cbasn1.SEQUENCE) {
+		return nil, ErrMalformed
+	}
+
+	var encryptedKey []byte
+
+	for encryptedKey == nil && !recipientEncryptedKeys.Empty() {
+		var (
+			rek, rkid cryptobyte.String
+			rid       issuerAndSerial
+			ski       []byte
+			ek        []byte
+			match     bool
+		)
+
+		if !recipientEncryptedKeys.ReadASN1(&rek, cbasn1.SEQUENCE) {
+			return nil, ErrMalformed
+		}
+
+		if rek.PeekASN1Tag(tagRecipientKeyID) {
+			if !rek.ReadASN1(&rkid, tagRecipientKeyID) || !rkid.ReadASN1Bytes(&ski, cbasn1.OCTET_STRING) {
+				return nil, ErrMalformed
+			}
+
+			match = bytes.Equal(ski, cert.SubjectKeyId)
+		} else {
+			if !readIssuerAndSerial(&rek, &rid) {
+				return nil, ErrMalformed
+			}
+
+			match = rid.matches(cert)
+		}
+
+		if !rek.ReadASN1Bytes(&ek, cbasn1.OCTET_STRING) {
+			return nil, ErrMalformed
+		}
+
+		if match {
+			encryptedKey = ek
+		}
+	}
+
+	if encryptedKey == nil {
+		return nil, nil //nolint:nilnil
+	}
+
+	var h crypto.Hash
+
+	// OpenSSL uses the SHA-1 KDF by default
+	switch {
+	case kdfAlg.Algorithm.Equal(oidECDHSHA1KDF), kdfAlg.Algorithm.Equal(oidECDHCofactorSHA1KDF):
+		h = crypto.SHA1
+	case kdfAlg.Algorithm.Equal(oidECDHSHA256KDF), kdfAlg.Algorithm.Equal(oidECDHCofactorSHA256KDF):
+		h = crypto.SHA256
+	case kdfAlg.Algorithm.Equal(oidECDHSHA384KDF), kdfAlg.Algorithm.Equal(oidECDHCofactorSHA384KDF):
+		h = crypto.SHA384
+	case kdfAlg.Algorithm.Equal(oidECDHSHA512KDF), kdfAlg.Algorithm.Equal(oidECDHCofactorSHA512KDF):
+		h = crypto.SHA512
+	default:
+		return nil, fmt.Errorf("%w: key agreement %s", ErrUnsupportedAlgorithm, kdfAlg.Algorithm)
+	}
+
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, cert.PublicKey)
+	}
+
+	ecdhPub, err := pub.ECDH()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsupportedKey, err)
+	}
+
+	ephemeral, err := ecdhPub.Curve().NewPublicKey(ephemeralBytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid originator key: %w", ErrMalformed, err)
+	}
+
+	sk, ok := key.(ecdhx.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T does not support ECDH", ErrUnsupportedKey, key)
+	}
+
+	secret, err := sk.DH(&ecdhx.PublicKey{PublicKey: ephemeral})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecrypt, err)
+	}
+
+	kek, err := deriveKEK(h, secret, wrapAlg.Algorithm, ukm)
+	if err != nil {
+		return nil, err
+	}
+
+	cek, err := keywrap.Unwrap(kek, encryptedKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecrypt, err)
+	}
+
+	return cek, nil
+}
+
+func decryptContent(alg algorithmIdentifier, cek, ciphertext []byte) ([]byte, error) {
+	var keyLen int
+
+	switch {
+	case alg.Algorithm.Equal(oidAES128CBC):
+		keyLen = 16
+	case alg.Algorithm.Equal(oidAES192CBC):
+		keyLen = 24
+	case alg.Algorithm.Equal(oidAES256CBC):
+		keyLen = 32
+	default:
+		return nil, fmt.Errorf("%w: content encryption %s", ErrUnsupportedAlgorithm, alg.Algorithm)
+	}
+
+	var iv []byte
+	if !alg.Parameters.ReadASN1Bytes(&iv, cbasn1.OCTET_STRING) || len(iv) != aes.BlockSize {
+		return nil, ErrMalformed
+	}
+
+	if len(cek) != keyLen {
+		return nil, fmt.Errorf("%w: invalid key length", ErrDecrypt)
+	}
+
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("%w: invalid content length", ErrDecrypt)
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+
+	return unpad(plaintext, aes.BlockSize)
+}
+
+// pad returns a copy of data with PKCS #7 padding.
+func pad(data []byte, blockSize int) []byte {
+	n := blockSize - len(data)%blockSize
+	return append(bytes.Clone(data), bytes.Repeat([]byte{byte(n)}, n)...)
+}
+
+func unpad(data []byte, blockSize int) ([]byte, error) {
+	n := int(data[len(data)-1])
+	if n == 0 || n > blockSize || n > len(data) {
+		return nil, fmt.Errorf("%w: invalid padding", ErrDecrypt)
+	}
+
+	for _, b := range data[len(data)-n:] {
+		if int(b) != n {
+			return nil, fmt.Errorf("%w: invalid padding", ErrDecrypt)
+		}
+	}
+
+	return data[:len(data)-n], nil
+}
@@ -0,0 +1,29 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package cms
+
+import (
+	"encoding/binary"
+	"hash"
+)
+
+// x963KDF derives key material with the ANSI X9.63 key derivation function.
+func x963KDF(h func() hash.Hash, secret, sharedInfo []byte, length int) []byte {
+	var (
+		out     []byte
+		counter [4]byte
+	)
+
+	for i := uint32(1); len(out) < length; i++ {
+		binary.BigEndian.PutUint32(counter[:], i)
+
+		hh := h()
+		hh.Write(secret)
+		hh.Write(counter[:])
+		hh.Write(sharedInfo)
+		out = hh.Sum(out)
+	}
+
+	return out[:length]
+}
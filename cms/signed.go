@@ -0,0 +1,524 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package cms
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"slices"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	cbasn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// SignOptions configure the creation of SignedData.
+type SignOptions struct {
+	// Detached omits the content from the message, e.g. for detached code signatures.
+	Detached bool
+
+	// Hash is the digest algorithm. It defaults to SHA-256.
+	Hash crypto.Hash
+
+	// Certificates are additional certificates like intermediates which are included in the message.
+	Certificates []*x509.Certificate
+
+	// SigningTime defaults to the current time.
+	SigningTime time.Time
+}
+
+// Sign creates a SignedData message of the content signed by a single signer.
+// The signer certificate is included in the message.
+func Sign(content []byte, cert *x509.Certificate, signer crypto.Signer, opts *SignOptions) ([]byte, error) {
+	if opts == nil {
+		opts = &SignOptions{}
+	}
+
+	h := opts.Hash
+	if h == 0 {
+		h = crypto.SHA256
+	}
+
+	signingTime := opts.SigningTime
+	if signingTime.IsZero() {
+		signingTime = time.Now()
+	}
+
+	digestOID, err := hashOID(h)
+	if err != nil {
+		return nil, err
+	}
+
+	sigOID, sigParams, err := signatureAlgorithm(signer.Public(), h)
+	if err != nil {
+		return nil, err
+	}
+
+	hh := h.New()
+	hh.Write(content)
+	digest := hh.Sum(nil)
+
+	signedAttrs, err := marshalAttributes([]attribute{
+		{oidAttrContentType, func(b *cryptobyte.Builder) { b.AddASN1ObjectIdentifier(oidData) }},
+		{oidAttrSigningTime, func(b *cryptobyte.Builder) { addTime(b, signingTime) }},
+		{oidAttrMessageDigest, func(b *cryptobyte.Builder) { b.AddASN1OctetString(digest) }},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The signature is calculated over the DER encoding of the attributes as SET OF
+	hh = h.New()
+	hh.Write(signedAttrs)
+
+	sig, err := signer.Sign(rand.Reader, hh.Sum(nil), h)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+
+	certs := append([]*x509.Certificate{cert}, opts.Certificates...)
+
+	return contentInfo(oidSignedData, func(b *cryptobyte.Builder) {
+		b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			b.AddASN1Int64(1) // version
+
+			b.AddASN1(cbasn1.SET, func(b *cryptobyte.Builder) {
+				addAlgorithmIdentifier(b, digestOID, nil)
+			})
+
+			// encapContentInfo
+			b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				b.AddASN1ObjectIdentifier(oidData)
+
+				if !opts.Detached {
+					b.AddASN1(cbasn1.Tag(0).Constructed().ContextSpecific(), func(b *cryptobyte.Builder) {
+						b.AddASN1OctetString(content)
+					})
+				}
+			})
+
+			b.AddASN1(cbasn1.Tag(0).Constructed().ContextSpecific(), func(b *cryptobyte.Builder) {
+				for _, c := range certs {
+					b.AddBytes(c.Raw)
+				}
+			})
+
+			b.AddASN1(cbasn1.SET, func(b *cryptobyte.Builder) {
+				b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+					b.AddASN1Int64(1) // version
+					newIssuerAndSerial(cert).add(b)
+					addAlgorithmIdentifier(b, digestOID, nil)
+
+					// signedAttrs [0] IMPLICIT replaces the SET tag
+					b.AddASN1(cbasn1.Tag(0).Constructed().ContextSpecific(), func(b *cryptobyte.Builder) {
+						var attrs cryptobyte.String
+						input := cryptobyte.String(signedAttrs)
+						input.ReadASN1(&attrs, cbasn1.SET)
+						b.AddBytes(attrs)
+					})
+
+					addAlgorithmIdentifier(b, sigOID, sigParams)
+					b.AddASN1OctetString(sig)
+				})
+			})
+		})
+	})
+}
+
+func signatureAlgorithm(pub crypto.PublicKey, h crypto.Hash) (asn1.ObjectIdentifier, func(b *cryptobyte.Builder), error) {
+	switch pub.(type) {
+	case *ecdsa.PublicKey:
+		switch h {
+		case crypto.SHA256:
+			return oidECDSAWithSHA256, nil, nil
+		case crypto.SHA384:
+			return oidECDSAWithSHA384, nil, nil
+		case crypto.SHA512:
+			return oidECDSAWithSHA512, nil, nil
+		}
+
+	case *rsa.PublicKey:
+		// Like OpenSSL, we use rsaEncryption with the digest algorithm of the SignerInfo
+		return oidRSAEncryption, func(b *cryptobyte.Builder) { b.AddASN1NULL() }, nil
+
+	default:
+		return nil, nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, pub)
+	}
+
+	return nil, nil, fmt.Errorf("%w: hash %s", ErrUnsupportedAlgorithm, h)
+}
+
+// x509SignatureAlgorithm maps the algorithms of a SignerInfo to the corresponding X.509 algorithm.
+func x509SignatureAlgorithm(digest crypto.Hash, sig asn1.ObjectIdentifier) (x509.SignatureAlgorithm, error) {
+	switch {
+	case sig.Equal(oidECDSAWithSHA256):
+		return x509.ECDSAWithSHA256, nil
+	case sig.Equal(oidECDSAWithSHA384):
+		return x509.ECDSAWithSHA384, nil
+	case sig.Equal(oidECDSAWithSHA512):
+		return x509.ECDSAWithSHA512, nil
+	case sig.Equal(oidSHA256WithRSA):
+		return x509.SHA256WithRSA, nil
+	case sig.Equal(oidSHA384WithRSA):
+		return x509.SHA384WithRSA, nil
+	case sig.Equal(oidSHA512WithRSA):
+		return x509.SHA512WithRSA, nil
+	case sig.Equal(oidRSAEncryption):
+		switch digest {
+		case crypto.SHA256:
+			return x509.SHA256WithRSA, nil
+		case crypto.SHA384:
+			return x509.SHA384WithRSA, nil
+		case crypto.SHA512:
+			return x509.SHA512WithRSA, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: signature %s with %s", ErrUnsupportedAlgorithm, sig, digest)
+}
+
+type attribute struct {
+	Type  asn1.ObjectIdentifier
+	Value func(b *cryptobyte.Builder)
+}
+
+// marshalAttributes encodes attributes as DER SET OF Attribute with sorted elements.
+func marshalAttributes(attrs []attribute) ([]byte, error) {
+	encoded := make([][]byte, 0, len(attrs))
+
+	for _, attr := range attrs {
+		b := cryptobyte.NewBuilder(nil)
+		b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			b.AddASN1ObjectIdentifier(attr.Type)
+			b.AddASN1(cbasn1.SET, attr.Value)
+		})
+
+		enc, err := b.Bytes()
+		if err != nil {
+			return nil, err
+		}
+
+		encoded = append(encoded, enc)
+	}
+
+	slices.SortFunc(encoded, bytes.Compare)
+
+	b := cryptobyte.NewBuilder(nil)
+	b.AddASN1(cbasn1.SET, func(b *cryptobyte.Builder) {
+		for _, enc := range encoded {
+			b.AddBytes(enc)
+		}
+	})
+
+	return b.Bytes()
+}
+
+// addTime encodes a time as UTCTime or GeneralizedTime as required by RFC 5652 Section 11.3.
+func addTime(b *cryptobyte.Builder, t time.Time) {
+	t = t.UTC().Truncate(time.Second)
+
+	if t.Year() >= 1950 && t.Year() < 2050 {
+		b.AddASN1UTCTime(t)
+	} else {
+		b.AddASN1GeneralizedTime(t)
+	}
+}
+
+// VerifyOptions configure the verification of SignedData.
+type VerifyOptions struct {
+	// Content is the signed content of detached signatures.
+	Content []byte
+
+	// X509 are the options for verifying the certificate chain of the signers.
+	// The system roots are used if X509.Roots is nil.
+	// Certificates included in the message are added as intermediates.
+	X509 x509.VerifyOptions
+}
+
+// SignedMessage is a verified SignedData message.
+type SignedMessage struct {
+	Content     []byte
+	Signers     []*x509.Certificate
+	SigningTime time.Time
+}
+
+type signerInfo struct {
+	sid          issuerAndSerial
+	ski          []byte
+	digest       algorithmIdentifier
+	signedAttrs  []byte // Re-tagged as SET
+	signatureAlg algorithmIdentifier
+	signature    []byte
+}
+
+// Verify checks all signatures of a SignedData message and the certificate chains of its signers.
+func Verify(der []byte, opts VerifyOptions) (*SignedMessage, error) {
+	inner, err := parseContentInfo(der, oidSignedData)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		sd, digestAlgs, encap, signerInfos cryptobyte.String
+		certsRaw                           cryptobyte.String
+		hasCerts                           bool
+		version                            int64
+		eContentType                       asn1.ObjectIdentifier
+		content                            []byte
+	)
+
+	if !inner.ReadASN1(&sd, cbasn1.SEQUENCE) ||
+		!sd.ReadASN1Integer(&version) ||
+		!sd.ReadASN1(&digestAlgs, cbasn1.SET) ||
+		!sd.ReadASN1(&encap, cbasn1.SEQUENCE) ||
+		!encap.ReadASN1ObjectIdentifier(&eContentType) ||
+		!sd.ReadOptionalASN1(&certsRaw, &hasCerts, cbasn1.Tag(0).Constructed().ContextSpecific()) ||
+		!sd.SkipOptionalASN1(cbasn1.Tag(1).Constructed().ContextSpecific()) ||
+		!sd.ReadASN1(&signerInfos, cbasn1.SET) {
+		return nil, ErrMalformed
+	}
+
+	if !encap.Empty() {
+		var eContent cryptobyte.String
+		if !encap.ReadASN1(&eContent, cbasn1.Tag(0).Constructed().ContextSpecific()) ||
+			!readOctetString(&eContent, &content, cbasn1.OCTET_STRING) {
+			return nil, ErrMalformed
+		}
+	} else {
+		content = opts.Content
+	}
+
+	if content == nil {
+		return nil, fmt.Errorf("%w: missing content of detached signature", ErrMalformed)
+	}
+
+	var certs []*x509.Certificate
+
+	for !certsRaw.Empty() {
+		var (
+			certDER cryptobyte.String
+			tag     cbasn1.Tag
+		)
+
+		if !certsRaw.ReadAnyASN1Element(&certDER, &tag) {
+			return nil, ErrMalformed
+		}
+
+		// Skip other certificate formats
+		if tag != cbasn1.SEQUENCE {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(certDER)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrMalformed, err)
+		}
+
+		certs = append(certs, cert)
+	}
+
+	msg := &SignedMessage{
+		Content: content,
+	}
+
+	for !signerInfos.Empty() {
+		si, err := readSignerInfo(&signerInfos)
+		if err != nil {
+			return nil, err
+		}
+
+		cert := si.findCertificate(certs)
+		if cert == nil {
+			return nil, ErrNoSigner
+		}
+
+		signingTime, err := si.verify(cert, eContentType, content)
+		if err != nil {
+			return nil, err
+		}
+
+		if !signingTime.IsZero() {
+			msg.SigningTime = signingTime
+		}
+
+		x509Opts := opts.X509
+		x509Opts.Intermediates = x509.NewCertPool()
+
+		if opts.X509.Intermediates != nil {
+			x509Opts.Intermediates = opts.X509.Intermediates.Clone()
+		}
+
+		for _, c := range certs {
+			x509Opts.Intermediates.AddCert(c)
+		}
+
+		if x509Opts.KeyUsages == nil {
+			x509Opts.KeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageAny}
+		}
+
+		if _, err := cert.Verify(x509Opts); err != nil {
+			return nil, fmt.Errorf("failed to verify signer certificate: %w", err)
+		}
+
+		msg.Signers = append(msg.Signers, cert)
+	}
+
+	if len(msg.Signers) == 0 {
+		return nil, ErrNoSigner
+	}
+
+	return msg, nil
+}
+
+func readSignerInfo(s *cryptobyte.String) (*signerInfo, error) {
+	var (
+		seq        cryptobyte.String
+		version    int64
+		si         = &signerInfo{}
+		attrs      cryptobyte.String
+		hasAttrs   bool
+		skiTag     = cbasn1.Tag(0).ContextSpecific()
+		attrsTag   = cbasn1.Tag(0).Constructed().ContextSpecific()
+		unsignedTg = cbasn1.Tag(1).Constructed().ContextSpecific()
+	)
+
+	if !s.ReadASN1(&seq, cbasn1.SEQUENCE) || !seq.ReadASN1Integer(&version) {
+		return nil, ErrMalformed
+	}
+
+	if seq.PeekASN1Tag(skiTag) {
+		if !seq.ReadASN1Bytes(&si.ski, skiTag) {
+			return nil, ErrMalformed
+		}
+	} else if !readIssuerAndSerial(&seq, &si.sid) {
+		return nil, ErrMalformed
+	}
+
+	if !readAlgorithmIdentifier(&seq, &si.digest) ||
+		!seq.ReadOptionalASN1(&attrs, &hasAttrs, attrsTag) ||
+		!readAlgorithmIdentifier(&seq, &si.signatureAlg) ||
+		!seq.ReadASN1Bytes(&si.signature, cbasn1.OCTET_STRING) ||
+		!seq.SkipOptionalASN1(unsignedTg) ||
+		!seq.Empty() {
+		return nil, ErrMalformed
+	}
+
+	if hasAttrs {
+		b := cryptobyte.NewBuilder(nil)
+		b.AddASN1(cbasn1.SET, func(b *cryptobyte.Builder) {
+			b.AddBytes(attrs)
+		})
+
+		var err error
+		if si.signedAttrs, err = b.Bytes(); err != nil {
+			return nil, err
+		}
+	}
+
+	return si, nil
+}
+
+func (si *signerInfo) findCertificate(certs []*x509.Certificate) *x509.Certificate {
+	for _, cert := range certs {
+		if si.ski != nil {
+			if bytes.Equal(si.ski, cert.SubjectKeyId) {
+				return cert
+			}
+		} else if si.sid.matches(cert) {
+			return cert
+		}
+	}
+
+	return nil
+}
+
+func (si *signerInfo) verify(cert *x509.Certificate, contentType asn1.ObjectIdentifier, content []byte) (signingTime time.Time, err error) {
+	h, err := hashByOID(si.digest.Algorithm)
+	if err != nil {
+		return signingTime, err
+	} else if h == crypto.SHA1 {
+		return signingTime, fmt.Errorf("%w: SHA-1 signatures are not accepted", ErrUnsupportedAlgorithm)
+	}
+
+	alg, err := x509SignatureAlgorithm(h, si.signatureAlg.Algorithm)
+	if err != nil {
+		return signingTime, err
+	}
+
+	signed := content
+
+	if si.signedAttrs != nil {
+		hh := h.New()
+		hh.Write(content)
+		digest := hh.Sum(nil)
+
+		attrs := cryptobyte.String(si.signedAttrs)
+		if !attrs.ReadASN1(&attrs, cbasn1.SET) {
+			return signingTime, ErrMalformed
+		}
+
+		var hasDigest, hasContentType bool
+
+		for !attrs.Empty() {
+			var (
+				attr, values cryptobyte.String
+				typ          asn1.ObjectIdentifier
+			)
+
+			if !attrs.ReadASN1(&attr, cbasn1.SEQUENCE) ||
+				!attr.ReadASN1ObjectIdentifier(&typ) ||
+				!attr.ReadASN1(&values, cbasn1.SET) {
+				return signingTime, ErrMalformed
+			}
+
+			switch {
+			case typ.Equal(oidAttrMessageDigest):
+				var md []byte
+				if !values.ReadASN1Bytes(&md, cbasn1.OCTET_STRING) {
+					return signingTime, ErrMalformed
+				}
+
+				if !bytes.Equal(md, digest) {
+					return signingTime, fmt.Errorf("%w: message digest mismatch", ErrInvalidSignature)
+				}
+
+				hasDigest = true
+
+			case typ.Equal(oidAttrContentType):
+				var ct asn1.ObjectIdentifier
+				if !values.ReadASN1ObjectIdentifier(&ct) || !ct.Equal(contentType) {
+					return signingTime, fmt.Errorf("%w: content type mismatch", ErrInvalidSignature)
+				}
+
+				hasContentType = true
+
+			case typ.Equal(oidAttrSigningTime):
+				switch {
+				case values.PeekASN1Tag(cbasn1.UTCTime):
+					values.ReadASN1UTCTime(&signingTime)
+				case values.PeekASN1Tag(cbasn1.GeneralizedTime):
+					values.ReadASN1GeneralizedTime(&signingTime)
+				}
+			}
+		}
+
+		if !hasDigest || !hasContentType {
+			return signingTime, fmt.Errorf("%w: missing mandatory signed attributes", ErrMalformed)
+		}
+
+		signed = si.signedAttrs
+	}
+
+	if err := cert.CheckSignature(alg, signed, si.signature); err != nil {
+		return signingTime, fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+
+	return signingTime, nil
+}
@@ -0,0 +1,906 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package config implements a declarative configuration file for providers and keys.
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"time"
+
+	"cunicu.li/go-iso7816"
+	"cunicu.li/go-iso7816/filter"
+	"gopkg.in/yaml.v3"
+
+	"cunicu.li/hawkes/audit"
+	"cunicu.li/hawkes/broker"
+	"cunicu.li/hawkes/device"
+	"cunicu.li/hawkes/expr"
+	"cunicu.li/hawkes/handshake"
+	"cunicu.li/hawkes/keychain"
+	"cunicu.li/hawkes/pin"
+	"cunicu.li/hawkes/piv"
+	"cunicu.li/hawkes/provider"
+	"cunicu.li/hawkes/timesync"
+)
+
+var (
+	ErrParse           = errors.New("failed to parse configuration")
+	ErrUnknownProvider = errors.New("unknown provider")
+	ErrUnknownKey      = errors.New("unknown key")
+	ErrMissingPIN      = errors.New("no PIN source configured")
+	ErrNoRotation      = errors.New("no rotation interval configured")
+)
+
+// Config is the top-level configuration shared by daemons and the CLI.
+type Config struct {
+	Devices   Devices    `yaml:"devices"`
+	Providers []Provider `yaml:"providers"`
+	Keys      []Key      `yaml:"keys"`
+
+	// OperationTimeout limits the time an operation waits for a busy card.
+	OperationTimeout time.Duration `yaml:"operation_timeout"`
+
+	// Broker is the socket path of the card broker (see broker.DefaultPath).
+	Broker string `yaml:"broker"`
+
+	// IdleTimeout disconnects cards which have not been used for the given period.
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+
+	// KeepAlive pings idle cards periodically to keep their applets selected.
+	KeepAlive time.Duration `yaml:"keep_alive"`
+
+	// UnwrapPolicy restricts key unwrapping to attested hardware.
+	UnwrapPolicy *UnwrapPolicy `yaml:"unwrap_policy"`
+
+	// AttestationRoots is a PEM file with the certificates against which attestations
+	// are verified, e.g. the Yubico PIV root CA and its intermediates.
+	AttestationRoots string `yaml:"attestation_roots"`
+
+	// BrokerCallers restricts the clients of the card broker.
+	// All clients of the user are accepted if empty.
+	BrokerCallers []Caller `yaml:"broker_callers"`
+
+	// TimeSync corrects the clock used for TOTP calculations.
+	TimeSync *TimeSync `yaml:"time_sync"`
+
+	// PINAttempts is the file persisting failed PIN attempts (see pin.DefaultPath).
+	PINAttempts string `yaml:"pin_attempts"`
+
+	// Audit records the usage of keys in a tamper-evident log.
+	Audit *Audit `yaml:"audit"`
+}
+
+// Audit configures the audit log of key usage.
+type Audit struct {
+	// Path is the file of the audit log.
+	Path string `yaml:"path"`
+
+	// Sealer is the name of a configured signing key which seals the log.
+	// A log without seals is not tamper-evident and therefore refused.
+	Sealer string `yaml:"sealer"`
+
+	// SealEvery is the number of entries after which the log is sealed (see audit.DefaultSealEvery).
+	SealEvery int `yaml:"seal_every"`
+}
+
+// SealerURI returns the URI of the key sealing the log.
+func (a *Audit) SealerURI(c *Config) (string, error) {
+	k, err := c.Key(a.Sealer)
+	if err != nil {
+		return "", fmt.Errorf("%w: audit sealer: %w", ErrParse, err)
+	}
+
+	if k.URI != "" {
+		return k.URI, nil
+	}
+
+	return provider.KeyRef{Provider: k.Provider, ID: k.ID}.String(), nil
+}
+
+// Devices selects the smart cards and TPMs which are used by providers.
+type Devices struct {
+	// Readers is a list of regular expressions matched against the reader names.
+	// All readers are used if empty.
+	Readers []string `yaml:"readers"`
+
+	// ATRs is a list of hex patterns matched against the answer-to-reset of cards.
+	// "xx" matches any byte.
+	ATRs []string `yaml:"atrs"`
+
+	// USB is a list of "vendor:product" IDs of USB readers.
+	USB []string `yaml:"usb"`
+
+	// Applets is a list of hex-encoded AIDs of which one must be selectable.
+	Applets []string `yaml:"applets"`
+
+	// TPMs is a list of TPM device paths.
+	// All TPMs are discovered automatically if empty.
+	TPMs []string `yaml:"tpms"`
+}
+
+// Matcher returns a matcher for the selected cards.
+// Each configured criterion must match at least one of its values.
+// It returns nil if no criteria are configured.
+func (d *Devices) Matcher() (device.Matcher, error) {
+	all := []device.Matcher{}
+
+	oneOf := func(values []string, parse func(string) (device.Matcher, error)) error {
+		if len(values) == 0 {
+			return nil
+		}
+
+		ms := []device.Matcher{}
+		for _, v := range values {
+			m, err := parse(v)
+			if err != nil {
+				return err
+			}
+
+			ms = append(ms, m)
+		}
+
+		all = append(all, device.Or(ms...))
+
+		return nil
+	}
+
+	if err := errors.Join(
+		oneOf(d.Readers, device.ReaderName),
+		oneOf(d.ATRs, device.ATR),
+		oneOf(d.USB, device.ParseUSB),
+		oneOf(d.Applets, func(s string) (device.Matcher, error) {
+			aid, err := hex.DecodeString(s)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", device.ErrInvalidPattern, err)
+			}
+
+			return device.HasApplet(aid), nil
+		}),
+	); err != nil {
+		return nil, err
+	}
+
+	if len(all) == 0 {
+		return nil, nil //nolint:nilnil
+	}
+
+	return device.And(all...), nil
+}
+
+// Provider enables a registered provider.
+type Provider struct {
+	Type string     `yaml:"type"`
+	PIN  *PINSource `yaml:"pin"`
+
+	// Devices overrides the default selection of cards for card-based providers.
+	Devices *Devices `yaml:"devices"`
+}
+
+// Key references a key of a provider.
+type Key struct {
+	Name     string         `yaml:"name"`
+	Provider string         `yaml:"provider"`
+	ID       provider.KeyID `yaml:"id"`
+	Protocol string         `yaml:"protocol"`
+	Rotation *Rotation      `yaml:"rotation"`
+	Policy   *UsagePolicy   `yaml:"policy"`
+
+	// URI references the key by an ordered list of providers and IDs
+	// for failover between them (see provider.ParseKeyURI).
+	// If set, Provider and ID are ignored and the key is identified by its first reference.
+	URI string `yaml:"uri"`
+}
+
+// LogicalID returns the ID identifying the key.
+func (k *Key) LogicalID() provider.KeyID {
+	if k.URI != "" {
+		if refs, err := provider.ParseKeyURI(k.URI); err == nil {
+			return refs[0].ID
+		}
+	}
+
+	return k.ID
+}
+
+// usagePolicy returns the usage policy of the key.
+func (k *Key) usagePolicy(anchors piv.VerifyOptions) (up *provider.UsagePolicy, err error) {
+	up = &provider.UsagePolicy{
+		MaxPerMinute: k.Policy.MaxPerMinute,
+		Confirm:      k.Policy.Confirm,
+		Anchors:      anchors,
+	}
+
+	if k.Policy.Expression != "" {
+		if up.Expression, err = expr.Compile(k.Policy.Expression); err != nil {
+			return nil, fmt.Errorf("%w: invalid expression in policy of key %s: %w", ErrParse, k.Name, err)
+		}
+	}
+
+	return up, nil
+}
+
+// UsagePolicy limits the use of a key.
+type UsagePolicy struct {
+	// MaxPerMinute limits the number of operations within any minute.
+	MaxPerMinute int `yaml:"max_per_minute"`
+
+	// Confirm lists the operations ("sign", "hmac" or "dh") which require an interactive confirmation.
+	Confirm []string `yaml:"confirm"`
+
+	// Expression must evaluate to true for an operation to be allowed
+	// (see provider.UsagePolicy.Expression).
+	Expression string `yaml:"expression"`
+}
+
+// Caller describes clients which are allowed to use the card broker.
+type Caller struct {
+	UIDs []int `yaml:"uids"`
+
+	// Executables are glob patterns of the executables of the clients.
+	Executables []string `yaml:"executables"`
+}
+
+// PINSource describes where the PIN of a provider is read from.
+// Exactly one of the fields should be set.
+type PINSource struct {
+	Value string `yaml:"value"`
+	Env   string `yaml:"env"`
+	File  string `yaml:"file"`
+
+	// Keychain is the account under which the PIN is stored in the
+	// secret store of the operating system (see keychain.DefaultService).
+	Keychain string `yaml:"keychain"`
+}
+
+// PIN returns the PIN from the configured source.
+func (s *PINSource) PIN() ([]byte, error) {
+	switch {
+	case s.Value != "":
+		return []byte(s.Value), nil
+
+	case s.Env != "":
+		pin, ok := os.LookupEnv(s.Env)
+		if !ok {
+			return nil, fmt.Errorf("%w: environment variable %s is not set", ErrMissingPIN, s.Env)
+		}
+
+		return []byte(pin), nil
+
+	case s.File != "":
+		pin, err := os.ReadFile(s.File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PIN file: %w", err)
+		}
+
+		return bytes.TrimRight(pin, "\r\n"), nil
+
+	case s.Keychain != "":
+		return keychain.Get(keychain.DefaultService, s.Keychain)
+	}
+
+	return nil, ErrMissingPIN
+}
+
+// UnwrapPolicy lists the constraints on the attestation of keys which unwrap key material.
+// Keys whose attestation can not be verified against the attestation roots are refused.
+type UnwrapPolicy struct {
+	// Models is a list of regular expressions of which one must match the attested model.
+	Models []string `yaml:"models"`
+
+	// MinFirmware is the lowest accepted firmware version, e.g. "5.7".
+	MinFirmware string `yaml:"min_firmware"`
+
+	// TouchPolicy is the weakest accepted touch policy ("never", "once" or "always").
+	TouchPolicy string `yaml:"touch_policy"`
+}
+
+// AttestationPolicy returns the policy for provider.RequireAttestation.
+func (u *UnwrapPolicy) AttestationPolicy() (*provider.AttestationPolicy, error) {
+	p := &provider.AttestationPolicy{}
+
+	for _, m := range u.Models {
+		re, err := regexp.Compile(m)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid model pattern: %w", ErrParse, err)
+		}
+
+		p.Models = append(p.Models, re)
+	}
+
+	if u.MinFirmware != "" {
+		v, err := iso7816.ParseVersion(u.MinFirmware)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid firmware version: %w", ErrParse, err)
+		}
+
+		p.MinFirmware = &v
+	}
+
+	switch tp := provider.Policy(u.TouchPolicy); tp {
+	case "", provider.PolicyNever, provider.PolicyOnce, provider.PolicyAlways:
+		p.TouchPolicy = tp
+	default:
+		return nil, fmt.Errorf("%w: invalid touch policy %q", ErrParse, u.TouchPolicy)
+	}
+
+	return p, nil
+}
+
+// AttestationAnchors loads the trust anchors for verifying attestations.
+// Self-signed certificates are used as roots, all others as intermediates.
+func (c *Config) AttestationAnchors() (opts piv.VerifyOptions, err error) {
+	if c.AttestationRoots == "" {
+		return opts, nil
+	}
+
+	data, err := os.ReadFile(c.AttestationRoots)
+	if err != nil {
+		return opts, fmt.Errorf("failed to read attestation roots: %w", err)
+	}
+
+	opts.Roots = x509.NewCertPool()
+	opts.Intermediates = x509.NewCertPool()
+
+	roots := 0
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			break
+		} else if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return opts, fmt.Errorf("%w: invalid attestation root: %w", ErrParse, err)
+		}
+
+		if bytes.Equal(cert.RawIssuer, cert.RawSubject) &&
+			cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil {
+			opts.Roots.AddCert(cert)
+			roots++
+		} else {
+			opts.Intermediates.AddCert(cert)
+		}
+	}
+
+	if roots == 0 {
+		return opts, fmt.Errorf("%w: no self-signed certificate in attestation roots", ErrParse)
+	}
+
+	return opts, nil
+}
+
+// Rotation is a policy for the periodic rotation of a key.
+type Rotation struct {
+	Interval time.Duration `yaml:"interval"`
+}
+
+// Due returns true if a key which has been rotated last in the given epoch of
+// its rotation counter (see Config.RotationCounter) is due for rotation again.
+// As the counter is guarded against jumps of the clock, rotations are neither
+// repeated after the clock has been set back nor triggered by a bogus time.
+func (r *Rotation) Due(counter *timesync.Epochs, last uint64) (bool, error) {
+	if r == nil || r.Interval <= 0 {
+		return false, nil
+	}
+
+	epoch, err := counter.Current()
+	if err != nil {
+		return false, err
+	}
+
+	return epoch > last, nil
+}
+
+// TimeSync estimates the offset of the local clock against a trusted time source.
+type TimeSync struct {
+	// Source is the trusted time source like "ntp://pool.ntp.org" (see timesync.ParseSource).
+	// Without a source, only previously estimated offsets are applied.
+	Source string `yaml:"source"`
+
+	// Offsets is the file persisting the estimated offsets (see timesync.DefaultPath).
+	Offsets string `yaml:"offsets"`
+
+	// Epochs is the file persisting the epoch counters (see timesync.DefaultEpochPath).
+	Epochs string `yaml:"epochs"`
+
+	// Device identifies this device in the offsets file and defaults to the hostname.
+	Device string `yaml:"device"`
+
+	// MaxAge is the age after which the offset is estimated again.
+	// Defaults to DefaultTimeSyncMaxAge.
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// DefaultTimeSyncMaxAge is the default age after which the clock offset is estimated again.
+const DefaultTimeSyncMaxAge = 24 * time.Hour
+
+// Clock returns the local clock corrected by the persisted offset of this device.
+// The offset is estimated again if it is missing or older than MaxAge.
+// If the estimation fails, the last known offset is used and the error is returned
+// together with the clock for diagnostics.
+// The local clock is returned without a configuration.
+func (t *TimeSync) Clock(ctx context.Context) (timesync.Clock, error) {
+	if t == nil {
+		return timesync.System, nil
+	}
+
+	path := t.Offsets
+	if path == "" {
+		var err error
+		if path, err = timesync.DefaultPath(); err != nil {
+			return timesync.System, err
+		}
+	}
+
+	device := t.Device
+	if device == "" {
+		var err error
+		if device, err = os.Hostname(); err != nil {
+			return timesync.System, fmt.Errorf("failed to get hostname: %w", err)
+		}
+	}
+
+	maxAge := t.MaxAge
+	if maxAge <= 0 {
+		maxAge = DefaultTimeSyncMaxAge
+	}
+
+	store := timesync.NewFileStore(path)
+
+	sample, err := store.Offset(device)
+	if err != nil {
+		return timesync.System, err
+	}
+
+	if t.Source == "" || (sample != nil && time.Since(sample.Measured) < maxAge) {
+		return sample, nil
+	}
+
+	src, err := timesync.ParseSource(t.Source)
+	if err != nil {
+		return sample, err
+	}
+
+	fresh, err := timesync.Estimate(ctx, src, timesync.DefaultSamples)
+	if err != nil {
+		return sample, err
+	}
+
+	return fresh, store.Put(device, fresh)
+}
+
+// EpochCounter returns a counter of epochs of the given period which uses the clock
+// returned by Clock and persists its last epoch in the epoch file.
+// Errors of the clock are returned together with the counter as by Clock.
+func (t *TimeSync) EpochCounter(ctx context.Context, name string, period time.Duration) (*timesync.Epochs, error) {
+	path := ""
+	if t != nil {
+		path = t.Epochs
+	}
+
+	if path == "" {
+		var err error
+		if path, err = timesync.DefaultEpochPath(); err != nil {
+			return nil, err
+		}
+	}
+
+	clock, err := t.Clock(ctx)
+
+	return timesync.NewEpochs(name, period, clock, timesync.NewEpochFileStore(path)), err
+}
+
+// RotationCounter returns the counter of the rotation intervals of a key.
+// It is an epoch counter of the time synchronization (see TimeSync.EpochCounter).
+// Errors of the clock are returned together with the counter as by TimeSync.Clock.
+func (c *Config) RotationCounter(ctx context.Context, key *Key) (*timesync.Epochs, error) {
+	if key.Rotation == nil || key.Rotation.Interval < time.Second {
+		return nil, fmt.Errorf("%w: %s", ErrNoRotation, key.Name)
+	}
+
+	return c.TimeSync.EpochCounter(ctx, "rotation/"+key.Name, key.Rotation.Interval)
+}
+
+// DefaultPath returns the default location of the configuration file.
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find user config directory: %w", err)
+	}
+
+	return filepath.Join(dir, "hawkes", "config.yaml"), nil
+}
+
+// Load reads and validates the configuration file at path.
+func Load(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config: %w", err)
+	}
+	defer f.Close()
+
+	return Decode(f)
+}
+
+// Decode reads and validates a configuration.
+func Decode(r io.Reader) (*Config, error) {
+	cfg := &Config{}
+
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: %w", ErrParse, err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Validate checks the configuration for consistency.
+func (c *Config) Validate() error {
+	registered := provider.Registered()
+
+	for _, p := range c.Providers {
+		if !slices.Contains(registered, p.Type) {
+			return fmt.Errorf("%w: %s", ErrUnknownProvider, p.Type)
+		}
+	}
+
+	if _, err := c.Devices.Matcher(); err != nil {
+		return fmt.Errorf("%w: invalid devices: %w", ErrParse, err)
+	}
+
+	for _, p := range c.Providers {
+		if p.Devices == nil {
+			continue
+		}
+
+		if _, err := p.Devices.Matcher(); err != nil {
+			return fmt.Errorf("%w: invalid devices of %s provider: %w", ErrParse, p.Type, err)
+		}
+	}
+
+	if c.UnwrapPolicy != nil {
+		if _, err := c.UnwrapPolicy.AttestationPolicy(); err != nil {
+			return err
+		}
+
+		if c.AttestationRoots == "" {
+			return fmt.Errorf("%w: unwrap policy requires attestation roots", ErrParse)
+		}
+	}
+
+	if _, err := c.AttestationAnchors(); err != nil {
+		return err
+	}
+
+	if c.Audit != nil {
+		if c.Audit.Path == "" {
+			return fmt.Errorf("%w: audit log requires a path", ErrParse)
+		}
+
+		if c.Audit.Sealer == "" {
+			return fmt.Errorf("%w: audit log requires a sealer", ErrParse)
+		}
+
+		if _, err := c.Audit.SealerURI(c); err != nil {
+			return err
+		}
+	}
+
+	if c.TimeSync != nil && c.TimeSync.Source != "" {
+		if _, err := timesync.ParseSource(c.TimeSync.Source); err != nil {
+			return fmt.Errorf("%w: %w", ErrParse, err)
+		}
+	}
+
+	for _, k := range c.Keys {
+		if k.Name == "" {
+			return fmt.Errorf("%w: key without name", ErrParse)
+		}
+
+		if k.Provider != "" && !slices.Contains(registered, k.Provider) {
+			return fmt.Errorf("%w: %s", ErrUnknownProvider, k.Provider)
+		}
+
+		if k.URI != "" {
+			refs, err := provider.ParseKeyURI(k.URI)
+			if err != nil {
+				return fmt.Errorf("invalid URI of key %s: %w", k.Name, err)
+			}
+
+			for _, ref := range refs {
+				if !slices.Contains(registered, ref.Provider) {
+					return fmt.Errorf("%w: %s", ErrUnknownProvider, ref.Provider)
+				}
+			}
+		}
+
+		if k.Protocol != "" {
+			if _, err := handshake.ParseProtocol(k.Protocol); err != nil {
+				return fmt.Errorf("invalid protocol of key %s: %w", k.Name, err)
+			}
+		}
+
+		if k.Policy != nil {
+			for _, op := range k.Policy.Confirm {
+				switch op {
+				case provider.OperationSign, provider.OperationHMAC, provider.OperationDH:
+				default:
+					return fmt.Errorf("%w: invalid operation %q in policy of key %s", ErrParse, op, k.Name)
+				}
+			}
+
+			if k.Policy.Expression != "" {
+				if _, err := expr.Compile(k.Policy.Expression); err != nil {
+					return fmt.Errorf("%w: invalid expression in policy of key %s: %w", ErrParse, k.Name, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Key returns the key with the given name.
+func (c *Config) Key(name string) (*Key, error) {
+	for i := range c.Keys {
+		if c.Keys[i].Name == name {
+			return &c.Keys[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnknownKey, name)
+}
+
+// PIN returns the PIN for a provider from its configured source.
+// It implements provider.PINFunc.
+func (c *Config) PIN(name string) ([]byte, error) {
+	for _, p := range c.Providers {
+		if p.Type == name && p.PIN != nil {
+			return p.PIN.PIN()
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrMissingPIN, name)
+}
+
+// PINManager returns a manager which persists failed PIN attempts in the configured file.
+func (c *Config) PINManager() (*pin.Manager, error) {
+	m := pin.NewManager()
+
+	if m.Path = c.PINAttempts; m.Path == "" {
+		var err error
+		if m.Path, err = pin.DefaultPath(); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// MultiProviderConfig returns the configuration for a provider.MultiProvider.
+// The audit log is opened if configured and closed together with the provider.
+func (c *Config) MultiProviderConfig() (cfg provider.MultiProviderConfig, err error) {
+	mgr, err := c.PINManager()
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg = provider.MultiProviderConfig{
+		OperationTimeout: c.OperationTimeout,
+		FilterCards:      filter.Any,
+		FilterTPMs:       func(string) bool { return true },
+		PIN:              c.PIN,
+		Unlock:           mgr.Unlock,
+		Broker:           c.Broker,
+		IdleTimeout:      c.IdleTimeout,
+		KeepAlive:        c.KeepAlive,
+		ProviderDevices:  map[string]device.Matcher{},
+	}
+
+	if cfg.Broker == "" {
+		cfg.Broker = broker.DefaultPath()
+	}
+
+	if cfg.Devices, err = c.Devices.Matcher(); err != nil {
+		return cfg, err
+	}
+
+	if len(c.Devices.TPMs) > 0 {
+		cfg.TPMPaths = c.Devices.TPMs
+	}
+
+	anchors, err := c.AttestationAnchors()
+	if err != nil {
+		return cfg, err
+	}
+
+	if c.UnwrapPolicy != nil {
+		if cfg.UnwrapPolicy, err = c.UnwrapPolicy.AttestationPolicy(); err != nil {
+			return cfg, err
+		}
+
+		cfg.UnwrapPolicy.Anchors = anchors
+	}
+
+	for _, k := range c.Keys {
+		if k.Policy == nil {
+			continue
+		}
+
+		if cfg.UsagePolicies == nil {
+			cfg.UsagePolicies = map[string]*provider.UsagePolicy{}
+		}
+
+		up, err := k.usagePolicy(anchors)
+		if err != nil {
+			return cfg, err
+		}
+
+		cfg.UsagePolicies[k.LogicalID().String()] = up
+	}
+
+	if len(c.Providers) > 0 {
+		cfg.Providers = []string{}
+		for _, p := range c.Providers {
+			cfg.Providers = append(cfg.Providers, p.Type)
+
+			if p.Devices != nil {
+				if cfg.ProviderDevices[p.Type], err = p.Devices.Matcher(); err != nil {
+					return cfg, err
+				}
+			}
+		}
+	}
+
+	// The audit log is opened last so that errors above do not leak it
+	if c.Audit != nil {
+		if cfg.AuditSealer, err = c.Audit.SealerURI(c); err != nil {
+			return cfg, err
+		}
+
+		log, err := audit.Open(c.Audit.Path)
+		if err != nil {
+			return cfg, err
+		}
+
+		if c.Audit.SealEvery > 0 {
+			log.SealEvery = c.Audit.SealEvery
+		}
+
+		cfg.Audit = log
+	}
+
+	return cfg, nil
+}
+
+// BrokerUsage returns the enforcement of the usage policies of keys by the card broker.
+// The broker has no confirmation prompt and can not attest keys. Hence, it refuses
+// operations which require a confirmation and considers all keys not attested.
+// It returns nil if no key has a usage policy.
+func (c *Config) BrokerUsage() (broker.UsageFunc, error) {
+	type keyPolicy struct {
+		id     provider.KeyID
+		policy *provider.UsagePolicy
+	}
+
+	policies := []keyPolicy{}
+
+	for _, k := range c.Keys {
+		if k.Policy == nil {
+			continue
+		}
+
+		up, err := k.usagePolicy(piv.VerifyOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		// The broker sees the IDs of all backends of a key
+		ids := []provider.KeyID{k.ID}
+		if k.URI != "" {
+			refs, err := provider.ParseKeyURI(k.URI)
+			if err != nil {
+				return nil, err
+			}
+
+			ids = nil
+			for _, ref := range refs {
+				ids = append(ids, ref.ID)
+			}
+		}
+
+		for _, id := range ids {
+			policies = append(policies, keyPolicy{id, up})
+		}
+	}
+
+	if len(policies) == 0 {
+		return nil, nil
+	}
+
+	return func(u *broker.Usage) error {
+		checked := map[*provider.UsagePolicy]bool{}
+
+		for _, kp := range policies {
+			// Operations of all keys at once are checked against all policies
+			if u.Key != nil && !bytes.Equal(u.Key, kp.id) || checked[kp.policy] {
+				continue
+			}
+
+			checked[kp.policy] = true
+
+			if err := kp.policy.Allow(u.Operation, kp.id, nil); err != nil {
+				return fmt.Errorf("key %s: %w", kp.id, err)
+			}
+		}
+
+		return nil
+	}, nil
+}
+
+// BrokerAuthorizer returns the authorization of card broker clients.
+// It returns nil if all clients are accepted.
+func (c *Config) BrokerAuthorizer() broker.AuthorizeFunc {
+	if len(c.BrokerCallers) == 0 {
+		return nil
+	}
+
+	callers := []broker.Caller{}
+	for _, bc := range c.BrokerCallers {
+		callers = append(callers, broker.Caller{
+			UIDs:        bc.UIDs,
+			Executables: bc.Executables,
+		})
+	}
+
+	return broker.AllowCallers(callers...)
+}
+
+// NewProvider materializes the configured providers.
+func (c *Config) NewProvider() (*provider.MultiProvider, error) {
+	cfg, err := c.MultiProviderConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return provider.NewProvider(cfg)
+}
+
+// OpenKey opens the configured key with the given name.
+func (c *Config) OpenKey(p provider.Provider, name string) (provider.PrivateKey, error) {
+	k, err := c.Key(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if k.URI != "" {
+		mp, ok := p.(*provider.MultiProvider)
+		if !ok {
+			return nil, fmt.Errorf("%w: key URIs require a multi-provider", errors.ErrUnsupported)
+		}
+
+		return mp.OpenKeyURI(k.URI)
+	}
+
+	return p.OpenKey(k.ID)
+}
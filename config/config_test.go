@@ -0,0 +1,378 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package config_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/audit"
+	"cunicu.li/hawkes/broker"
+	"cunicu.li/hawkes/config"
+	"cunicu.li/hawkes/expr"
+	"cunicu.li/hawkes/provider"
+	"cunicu.li/hawkes/timesync"
+)
+
+const testConfig = `
+devices:
+  readers:
+  - "^Yubico YubiKey"
+
+providers:
+- type: YKOATH
+  pin:
+    env: HAWKES_TEST_PIN
+- type: File
+
+keys:
+- name: wg0
+  provider: File
+  id: etYgGvxbpwSJH67Z/5Lb0KorJn4kIsUj6jEdwD+Eyhs=
+  protocol: WireGuard
+  rotation:
+    interval: 720h
+  policy:
+    max_per_minute: 10
+    confirm:
+    - sign
+    expression: operation != "dh" || time.hour < 18
+
+- name: signing
+  uri: YKOATH:AQI=,YKOATH:AwQ=,File:BQY=
+
+broker_callers:
+- executables:
+  - /usr/bin/ssh
+
+keep_alive: 10s
+
+unwrap_policy:
+  models:
+  - "^Yubico YubiKey"
+  min_firmware: "5.7"
+  touch_policy: always
+`
+
+// writeRoots writes a self-signed certificate as attestation root.
+func writeRoots(t *testing.T) string {
+	require := require.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test PIV Root CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(err)
+
+	path := filepath.Join(t.TempDir(), "roots.pem")
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	require.NoError(err)
+
+	return path
+}
+
+func TestDecode(t *testing.T) {
+	require := require.New(t)
+
+	cfg, err := config.Decode(strings.NewReader(testConfig + "\nattestation_roots: " + writeRoots(t) + "\n"))
+	require.NoError(err)
+
+	require.Len(cfg.Providers, 2)
+	require.Equal([]string{"^Yubico YubiKey"}, cfg.Devices.Readers)
+
+	key, err := cfg.Key("wg0")
+	require.NoError(err)
+	require.Len(key.ID, 32)
+	require.Equal(720*time.Hour, key.Rotation.Interval)
+
+	signing, err := cfg.Key("signing")
+	require.NoError(err)
+	require.Equal(provider.KeyID{1, 2}, signing.LogicalID())
+
+	_, err = cfg.Key("wg1")
+	require.ErrorIs(err, config.ErrUnknownKey)
+
+	mpCfg, err := cfg.MultiProviderConfig()
+	require.NoError(err)
+	require.NotNil(mpCfg.Devices)
+	require.Equal([]string{"YKOATH", "File"}, mpCfg.Providers)
+	require.Equal(10*time.Second, mpCfg.KeepAlive)
+	require.NotNil(mpCfg.UnwrapPolicy)
+	require.Len(mpCfg.UnwrapPolicy.Models, 1)
+	require.Equal(5, mpCfg.UnwrapPolicy.MinFirmware.Major)
+	require.Equal(provider.PolicyAlways, mpCfg.UnwrapPolicy.TouchPolicy)
+	require.NotNil(mpCfg.UnwrapPolicy.Anchors.Roots)
+
+	up := mpCfg.UsagePolicies[key.ID.String()]
+	require.NotNil(up)
+	require.Equal(10, up.MaxPerMinute)
+	require.Equal([]string{provider.OperationSign}, up.Confirm)
+	require.True(up.Expression.Uses("time"))
+
+	authorize := cfg.BrokerAuthorizer()
+	require.NotNil(authorize)
+	require.NoError(authorize(&broker.Peer{Executable: "/usr/bin/ssh"}))
+	require.ErrorIs(authorize(&broker.Peer{Executable: "/tmp/evil"}), broker.ErrCallerNotAllowed)
+
+	t.Setenv("HAWKES_TEST_PIN", "123456")
+
+	pin, err := mpCfg.PIN("YKOATH")
+	require.NoError(err)
+	require.Equal([]byte("123456"), pin)
+
+	_, err = mpCfg.PIN("File")
+	require.ErrorIs(err, config.ErrMissingPIN)
+}
+
+func TestBrokerUsage(t *testing.T) {
+	require := require.New(t)
+
+	cfg, err := config.Decode(strings.NewReader(`
+keys:
+- name: limited
+  uri: YKOATH:AQI=,YKOATH:AwQ=
+  policy:
+    max_per_minute: 1
+- name: confirmed
+  provider: YKOATH
+  id: BQY=
+  policy:
+    confirm: [hmac]
+- name: unrestricted
+  provider: YKOATH
+  id: Bwg=
+`))
+	require.NoError(err)
+
+	usage, err := cfg.BrokerUsage()
+	require.NoError(err)
+	require.NotNil(usage)
+
+	hmac := func(id []byte) error {
+		return usage(&broker.Usage{Operation: provider.OperationHMAC, Key: id})
+	}
+
+	// Backends of a key share its policy
+	require.NoError(hmac([]byte{3, 4}))
+	require.ErrorIs(hmac([]byte{1, 2}), provider.ErrRateLimited)
+
+	// The broker has no confirmation prompt
+	require.ErrorIs(hmac([]byte{5, 6}), provider.ErrNotConfirmed)
+
+	require.NoError(hmac([]byte{7, 8}))
+	require.NoError(hmac([]byte{9}))
+
+	// Operations of all keys are subject to all policies
+	require.Error(hmac(nil))
+
+	cfg, err = config.Decode(strings.NewReader("keys:\n- name: a\n  provider: YKOATH\n  id: AQI=\n"))
+	require.NoError(err)
+
+	usage, err = cfg.BrokerUsage()
+	require.NoError(err)
+	require.Nil(usage)
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	require := require.New(t)
+
+	_, err := config.Decode(strings.NewReader("providers:\n- type: Unknown\n"))
+	require.ErrorIs(err, config.ErrUnknownProvider)
+
+	_, err = config.Decode(strings.NewReader("keys:\n- name: a\n  protocol: Foo\n"))
+	require.Error(err)
+
+	_, err = config.Decode(strings.NewReader("unknown_field: 1\n"))
+	require.ErrorIs(err, config.ErrParse)
+
+	_, err = config.Decode(strings.NewReader("unwrap_policy:\n  touch_policy: sometimes\n"))
+	require.ErrorIs(err, config.ErrParse)
+
+	// Attestations can not be verified without roots
+	_, err = config.Decode(strings.NewReader("unwrap_policy:\n  touch_policy: always\n"))
+	require.ErrorIs(err, config.ErrParse)
+
+	_, err = config.Decode(strings.NewReader("keys:\n- name: a\n  uri: Unknown:AQI=\n"))
+	require.ErrorIs(err, config.ErrUnknownProvider)
+
+	_, err = config.Decode(strings.NewReader("keys:\n- name: a\n  policy:\n    confirm: [decrypt]\n"))
+	require.ErrorIs(err, config.ErrParse)
+
+	_, err = config.Decode(strings.NewReader("keys:\n- name: a\n  policy:\n    expression: operation ==\n"))
+	require.ErrorIs(err, config.ErrParse)
+	require.ErrorIs(err, expr.ErrSyntax)
+
+	_, err = config.Decode(strings.NewReader("time_sync:\n  source: pool.ntp.org\n"))
+	require.ErrorIs(err, config.ErrParse)
+}
+
+func TestAudit(t *testing.T) {
+	require := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	cfg, err := config.Decode(strings.NewReader(`
+keys:
+- name: sealer
+  provider: YKOATH
+  id: AQI=
+audit:
+  path: ` + path + `
+  sealer: sealer
+  seal_every: 5
+`))
+	require.NoError(err)
+
+	mpCfg, err := cfg.MultiProviderConfig()
+	require.NoError(err)
+	require.Equal("YKOATH:AQI=", mpCfg.AuditSealer)
+
+	log, ok := mpCfg.Audit.(*audit.Log)
+	require.True(ok)
+	require.Equal(5, log.SealEvery)
+	require.NoError(log.Close())
+	require.FileExists(path)
+
+	// Logs without seals are not tamper-evident
+	_, err = config.Decode(strings.NewReader("audit:\n  path: " + path + "\n"))
+	require.ErrorIs(err, config.ErrParse)
+
+	_, err = config.Decode(strings.NewReader("audit:\n  path: " + path + "\n  sealer: unknown\n"))
+	require.ErrorIs(err, config.ErrUnknownKey)
+}
+
+func TestPINFile(t *testing.T) {
+	require := require.New(t)
+
+	fn := filepath.Join(t.TempDir(), "pin")
+	require.NoError(os.WriteFile(fn, []byte("654321\n"), 0o600))
+
+	pin, err := (&config.PINSource{File: fn}).PIN()
+	require.NoError(err)
+	require.Equal([]byte("654321"), pin)
+}
+
+func TestRotationDue(t *testing.T) {
+	require := require.New(t)
+
+	cfg := &config.Config{
+		TimeSync: &config.TimeSync{
+			Offsets: filepath.Join(t.TempDir(), "clock.json"),
+			Epochs:  filepath.Join(t.TempDir(), "epochs.json"),
+		},
+	}
+
+	key := &config.Key{
+		Name:     "wg0",
+		Rotation: &config.Rotation{Interval: time.Hour},
+	}
+
+	counter, err := cfg.RotationCounter(context.Background(), key)
+	require.NoError(err)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	counter.Clock = timesync.ClockFunc(func() time.Time { return now })
+
+	rotated, err := counter.Current()
+	require.NoError(err)
+
+	now = now.Add(30 * time.Minute)
+
+	due, err := key.Rotation.Due(counter, rotated)
+	require.NoError(err)
+	require.False(due)
+
+	now = now.Add(30 * time.Minute)
+
+	due, err = key.Rotation.Due(counter, rotated)
+	require.NoError(err)
+	require.True(due)
+
+	rotated, err = counter.Current()
+	require.NoError(err)
+
+	// Setting the clock back does not make the key due again
+	now = now.Add(-2 * time.Hour)
+
+	due, err = key.Rotation.Due(counter, rotated)
+	require.NoError(err)
+	require.False(due)
+
+	due, err = (*config.Rotation)(nil).Due(counter, 0)
+	require.NoError(err)
+	require.False(due)
+
+	_, err = cfg.RotationCounter(context.Background(), &config.Key{Name: "other"})
+	require.ErrorIs(err, config.ErrNoRotation)
+}
+
+func TestTimeSync(t *testing.T) {
+	require := require.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Date", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	}))
+
+	ts := &config.TimeSync{
+		Source:  srv.URL,
+		Offsets: filepath.Join(t.TempDir(), "clock.json"),
+		Device:  "test",
+	}
+
+	clock, err := ts.Clock(context.Background())
+	require.NoError(err)
+	require.WithinDuration(time.Now().Add(time.Hour), clock.Now(), 2*time.Second)
+
+	// Epoch counters use the corrected clock
+	ts.Epochs = filepath.Join(t.TempDir(), "epochs.json")
+
+	epochs, err := ts.EpochCounter(context.Background(), "test", time.Minute)
+	require.NoError(err)
+
+	epoch, err := epochs.Current()
+	require.NoError(err)
+	require.InDelta(epochs.At(time.Now().Add(time.Hour)), epoch, 1)
+
+	// The persisted offset is used while it is fresh
+	srv.Close()
+
+	clock, err = ts.Clock(context.Background())
+	require.NoError(err)
+	require.WithinDuration(time.Now().Add(time.Hour), clock.Now(), 2*time.Second)
+
+	// The last known offset is used if the source is unavailable
+	ts.MaxAge = time.Nanosecond
+
+	clock, err = ts.Clock(context.Background())
+	require.Error(err)
+	require.WithinDuration(time.Now().Add(time.Hour), clock.Now(), 2*time.Second)
+
+	clock, err = (*config.TimeSync)(nil).Clock(context.Background())
+	require.NoError(err)
+	require.WithinDuration(time.Now(), clock.Now(), time.Second)
+}
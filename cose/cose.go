@@ -0,0 +1,283 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package cose implements CBOR Object Signing and Encryption (RFC 9052)
+// COSE_Sign1 messages and COSE_Key encoding for keys of providers.
+package cose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+
+	"cunicu.li/hawkes/internal/cbor"
+	"cunicu.li/hawkes/internal/ecdsasig"
+	"cunicu.li/hawkes/provider"
+)
+
+var (
+	ErrUnsupportedKey       = errors.New("unsupported key")
+	ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+	ErrInvalidKey           = errors.New("invalid key")
+	ErrInvalidMessage       = errors.New("invalid message")
+	ErrInvalidSignature     = errors.New("invalid signature")
+)
+
+// Algorithm is a COSE signature algorithm identifier as registered by RFC 9053.
+type Algorithm int64
+
+const (
+	ES256 Algorithm = -7
+	EdDSA Algorithm = -8
+	ES384 Algorithm = -35
+	RS256 Algorithm = -257
+)
+
+// TagSign1 is the CBOR tag of a COSE_Sign1 message.
+const TagSign1 = 18
+
+// Header labels as registered by RFC 9052.
+const (
+	headerAlg int64 = 1
+	headerKid int64 = 4
+)
+
+func (a Algorithm) hash() crypto.Hash {
+	switch a {
+	case ES256, RS256:
+		return crypto.SHA256
+	case ES384:
+		return crypto.SHA384
+	default:
+		return 0
+	}
+}
+
+// AlgorithmOf returns the signature algorithm matching a public key.
+func AlgorithmOf(pub crypto.PublicKey) (Algorithm, error) {
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			return ES256, nil
+		case elliptic.P384():
+			return ES384, nil
+		}
+
+	case *rsa.PublicKey:
+		return RS256, nil
+
+	case ed25519.PublicKey:
+		return EdDSA, nil
+	}
+
+	return 0, fmt.Errorf("%w: %T", ErrUnsupportedKey, pub)
+}
+
+// Signer creates COSE_Sign1 messages.
+type Signer struct {
+	Signer    crypto.Signer
+	Algorithm Algorithm
+	KeyID     []byte
+}
+
+// NewSigner creates a signer for the given key.
+func NewSigner(signer crypto.Signer) (*Signer, error) {
+	alg, err := AlgorithmOf(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Signer{
+		Signer:    signer,
+		Algorithm: alg,
+	}, nil
+}
+
+// NewProviderSigner creates a signer for a key of a provider.
+func NewProviderSigner(key provider.PrivateKey) (*Signer, error) {
+	sk, ok := key.(provider.PrivateKeySigner)
+	if !ok {
+		return nil, fmt.Errorf("%w: key does not support signing", ErrUnsupportedKey)
+	}
+
+	signer, err := sk.Signer()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSigner(signer)
+}
+
+// PublicKey returns the COSE_Key encoding of the public key of the signer.
+func (s *Signer) PublicKey() ([]byte, error) {
+	return MarshalKey(s.Signer.Public(), s.KeyID)
+}
+
+// Sign1 creates a tagged COSE_Sign1 message.
+// The external additional authenticated data is optional.
+func (s *Signer) Sign1(payload, externalAAD []byte) ([]byte, error) {
+	hdr := map[any]any{
+		headerAlg: int64(s.Algorithm),
+	}
+
+	if s.KeyID != nil {
+		hdr[headerKid] = s.KeyID
+	}
+
+	protected, err := cbor.Marshal(hdr)
+	if err != nil {
+		return nil, err
+	}
+
+	tbs, err := sigStructure(protected, externalAAD, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := s.sign(tbs)
+	if err != nil {
+		return nil, err
+	}
+
+	return cbor.Marshal(cbor.Tag{
+		Number: TagSign1,
+		Content: []any{
+			protected,
+			map[any]any{},
+			payload,
+			sig,
+		},
+	})
+}
+
+func (s *Signer) sign(tbs []byte) ([]byte, error) {
+	h := s.Algorithm.hash()
+
+	digest := tbs
+	if h != 0 {
+		hh := h.New()
+		hh.Write(tbs)
+		digest = hh.Sum(nil)
+	}
+
+	sig, err := s.Signer.Sign(rand.Reader, digest, h)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+
+	// COSE uses the concatenation of R and S instead of ASN.1 for ECDSA
+	if pub, ok := s.Signer.Public().(*ecdsa.PublicKey); ok {
+		return ecdsasig.ToRaw(sig, ecdsasig.Size(pub))
+	}
+
+	return sig, nil
+}
+
+// Verify1 checks the signature of a tagged or untagged COSE_Sign1 message and returns its key ID and payload.
+// Messages with detached payloads are not supported.
+func Verify1(msg []byte, pub crypto.PublicKey, externalAAD []byte) (kid, payload []byte, err error) {
+	v, rest, err := cbor.Unmarshal(msg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+	} else if len(rest) > 0 {
+		return nil, nil, fmt.Errorf("%w: trailing data", ErrInvalidMessage)
+	}
+
+	if tag, ok := v.(cbor.Tag); ok {
+		if tag.Number != TagSign1 {
+			return nil, nil, fmt.Errorf("%w: unexpected tag %d", ErrInvalidMessage, tag.Number)
+		}
+
+		v = tag.Content
+	}
+
+	parts, ok := v.([]any)
+	if !ok || len(parts) != 4 {
+		return nil, nil, ErrInvalidMessage
+	}
+
+	protected, ok1 := parts[0].([]byte)
+	unprotected, ok2 := parts[1].(map[any]any)
+	payload, ok3 := parts[2].([]byte)
+	sig, ok4 := parts[3].([]byte)
+	if !ok1 || !ok2 || !ok3 || !ok4 {
+		return nil, nil, ErrInvalidMessage
+	}
+
+	hv, rest, err := cbor.Unmarshal(protected)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+	} else if len(rest) > 0 {
+		return nil, nil, fmt.Errorf("%w: trailing data in protected header", ErrInvalidMessage)
+	}
+
+	hdr, ok := hv.(map[any]any)
+	if !ok {
+		return nil, nil, ErrInvalidMessage
+	}
+
+	// Prevent algorithm confusion by deriving the algorithm from the key
+	alg, err := AlgorithmOf(pub)
+	if err != nil {
+		return nil, nil, err
+	} else if a, _ := hdr[headerAlg].(int64); Algorithm(a) != alg {
+		return nil, nil, fmt.Errorf("%w: %d", ErrUnsupportedAlgorithm, a)
+	}
+
+	tbs, err := sigStructure(protected, externalAAD, payload)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !verify(alg, pub, tbs, sig) {
+		return nil, nil, ErrInvalidSignature
+	}
+
+	kid, ok = hdr[headerKid].([]byte)
+	if !ok {
+		kid, _ = unprotected[headerKid].([]byte)
+	}
+
+	return kid, payload, nil
+}
+
+func sigStructure(protected, externalAAD, payload []byte) ([]byte, error) {
+	if externalAAD == nil {
+		externalAAD = []byte{}
+	}
+
+	return cbor.Marshal([]any{
+		"Signature1",
+		protected,
+		externalAAD,
+		payload,
+	})
+}
+
+func verify(alg Algorithm, pub crypto.PublicKey, tbs, sig []byte) bool {
+	var digest []byte
+	if h := alg.hash(); h != 0 {
+		hh := h.New()
+		hh.Write(tbs)
+		digest = hh.Sum(nil)
+	}
+
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsasig.Verify(pub, digest, sig)
+
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(pub, alg.hash(), digest, sig) == nil
+
+	case ed25519.PublicKey:
+		return ed25519.Verify(pub, tbs, sig)
+	}
+
+	return false
+}
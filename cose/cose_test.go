@@ -0,0 +1,94 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package cose_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/cose"
+)
+
+func TestSign1Verify1(t *testing.T) {
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	for alg, signer := range map[cose.Algorithm]crypto.Signer{
+		cose.ES256: p256,
+		cose.ES384: p384,
+		cose.RS256: rsaKey,
+		cose.EdDSA: edKey,
+	} {
+		t.Run(fmt.Sprint(alg), func(t *testing.T) {
+			require := require.New(t)
+
+			s, err := cose.NewSigner(signer)
+			require.NoError(err)
+			require.Equal(alg, s.Algorithm)
+
+			s.KeyID = []byte("hawkes")
+			aad := []byte("context")
+
+			msg, err := s.Sign1([]byte("payload"), aad)
+			require.NoError(err)
+			require.Equal(byte(0xd2), msg[0], "expected COSE_Sign1 tag")
+
+			kid, payload, err := cose.Verify1(msg, signer.Public(), aad)
+			require.NoError(err)
+			require.Equal([]byte("hawkes"), kid)
+			require.Equal([]byte("payload"), payload)
+
+			_, _, err = cose.Verify1(msg, signer.Public(), []byte("other"))
+			require.ErrorIs(err, cose.ErrInvalidSignature)
+
+			// The public key must survive a COSE_Key round-trip
+			keyBytes, err := s.PublicKey()
+			require.NoError(err)
+
+			pub, kid, err := cose.UnmarshalKey(keyBytes)
+			require.NoError(err)
+			require.Equal([]byte("hawkes"), kid)
+			require.True(signer.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(pub)) //nolint:forcetypeassert
+
+			_, _, err = cose.Verify1(msg, pub, aad)
+			require.NoError(err)
+		})
+	}
+}
+
+func TestVerify1AlgorithmMismatch(t *testing.T) {
+	require := require.New(t)
+
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(err)
+
+	s, err := cose.NewSigner(p256)
+	require.NoError(err)
+
+	msg, err := s.Sign1([]byte("payload"), nil)
+	require.NoError(err)
+
+	_, _, err = cose.Verify1(msg, edKey.Public(), nil)
+	require.ErrorIs(err, cose.ErrUnsupportedAlgorithm)
+}
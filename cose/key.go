@@ -0,0 +1,164 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package cose
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"fmt"
+	"math/big"
+
+	"cunicu.li/hawkes/internal/cbor"
+)
+
+// Key types, curves and parameter labels as registered by RFC 9053.
+const (
+	ktyOKP int64 = 1
+	ktyEC2 int64 = 2
+	ktyRSA int64 = 3
+
+	crvP256    int64 = 1
+	crvP384    int64 = 2
+	crvEd25519 int64 = 6
+
+	labelKty int64 = 1
+	labelKid int64 = 2
+	labelAlg int64 = 3
+
+	labelCrv int64 = -1
+	labelX   int64 = -2
+	labelY   int64 = -3
+	labelN   int64 = -1
+	labelE   int64 = -2
+)
+
+// MarshalKey encodes a public key as a COSE_Key.
+func MarshalKey(pub crypto.PublicKey, kid []byte) ([]byte, error) {
+	alg, err := AlgorithmOf(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	m := map[any]any{
+		labelAlg: int64(alg),
+	}
+
+	if kid != nil {
+		m[labelKid] = kid
+	}
+
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		n := (pub.Curve.Params().BitSize + 7) / 8
+		crv := crvP256
+		if pub.Curve == elliptic.P384() {
+			crv = crvP384
+		}
+
+		m[labelKty] = ktyEC2
+		m[labelCrv] = crv
+		m[labelX] = pub.X.FillBytes(make([]byte, n))
+		m[labelY] = pub.Y.FillBytes(make([]byte, n))
+
+	case ed25519.PublicKey:
+		m[labelKty] = ktyOKP
+		m[labelCrv] = crvEd25519
+		m[labelX] = []byte(pub)
+
+	case *rsa.PublicKey:
+		m[labelKty] = ktyRSA
+		m[labelN] = pub.N.Bytes()
+		m[labelE] = big.NewInt(int64(pub.E)).Bytes()
+	}
+
+	return cbor.Marshal(m)
+}
+
+// UnmarshalKey decodes a COSE_Key and returns the public key and its key ID.
+func UnmarshalKey(b []byte) (pub crypto.PublicKey, kid []byte, err error) {
+	v, rest, err := cbor.Unmarshal(b)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidKey, err)
+	} else if len(rest) > 0 {
+		return nil, nil, fmt.Errorf("%w: trailing data", ErrInvalidKey)
+	}
+
+	m, ok := v.(map[any]any)
+	if !ok {
+		return nil, nil, ErrInvalidKey
+	}
+
+	kid, _ = m[labelKid].([]byte)
+
+	kty, _ := m[labelKty].(int64)
+	switch kty {
+	case ktyEC2:
+		crv, _ := m[labelCrv].(int64)
+		x, _ := m[labelX].([]byte)
+		y, _ := m[labelY].([]byte)
+
+		var (
+			curve elliptic.Curve
+			ec    ecdh.Curve
+		)
+
+		switch crv {
+		case crvP256:
+			curve, ec = elliptic.P256(), ecdh.P256()
+		case crvP384:
+			curve, ec = elliptic.P384(), ecdh.P384()
+		default:
+			return nil, nil, fmt.Errorf("%w: unsupported curve %d", ErrInvalidKey, crv)
+		}
+
+		n := (curve.Params().BitSize + 7) / 8
+		if len(x) != n || len(y) != n {
+			return nil, nil, fmt.Errorf("%w: invalid coordinate length", ErrInvalidKey)
+		}
+
+		// Validate the point by parsing its uncompressed encoding
+		enc := append(append([]byte{4}, x...), y...)
+		if _, err := ec.NewPublicKey(enc); err != nil {
+			return nil, nil, fmt.Errorf("%w: %w", ErrInvalidKey, err)
+		}
+
+		pub = &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+
+	case ktyOKP:
+		crv, _ := m[labelCrv].(int64)
+		x, _ := m[labelX].([]byte)
+
+		if crv != crvEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, nil, fmt.Errorf("%w: unsupported curve %d", ErrInvalidKey, crv)
+		}
+
+		pub = ed25519.PublicKey(x)
+
+	case ktyRSA:
+		n, _ := m[labelN].([]byte)
+		e, _ := m[labelE].([]byte)
+
+		if len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return nil, nil, fmt.Errorf("%w: invalid RSA parameters", ErrInvalidKey)
+		}
+
+		pub = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+
+	default:
+		return nil, nil, fmt.Errorf("%w: unsupported key type %d", ErrInvalidKey, kty)
+	}
+
+	return pub, kid, nil
+}
@@ -0,0 +1,171 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package ctap implements transports for the Client to Authenticator Protocol (CTAP2)
+// of FIDO2 authenticators.
+//
+// Authenticators are reached either via USB HID (CTAPHID) or via NFC
+// in which case CTAP2 messages are framed in ISO 7816-4 APDUs
+// and sent through the same PC/SC card handles used by the other applets.
+package ctap
+
+import (
+	"errors"
+	"fmt"
+
+	"cunicu.li/hawkes/internal/cbor"
+)
+
+var ErrInvalidResponse = errors.New("invalid response")
+
+// Command is a CTAP2 authenticator command.
+type Command byte
+
+const (
+	CmdMakeCredential   Command = 0x01
+	CmdGetAssertion     Command = 0x02
+	CmdGetInfo          Command = 0x04
+	CmdClientPIN        Command = 0x06
+	CmdReset            Command = 0x07
+	CmdGetNextAssertion Command = 0x08
+	CmdSelection        Command = 0x0b
+)
+
+// Status is a CTAP2 status code returned by an authenticator.
+type Status byte
+
+const (
+	StatusOK                Status = 0x00
+	StatusInvalidCommand    Status = 0x01
+	StatusInvalidParameter  Status = 0x02
+	StatusInvalidLength     Status = 0x03
+	StatusInvalidCBOR       Status = 0x12
+	StatusMissingParameter  Status = 0x14
+	StatusUnsupportedOption Status = 0x2b
+	StatusOperationDenied   Status = 0x27
+	StatusNoCredentials     Status = 0x2e
+	StatusUserActionTimeout Status = 0x2f
+	StatusPINInvalid        Status = 0x31
+	StatusPINBlocked        Status = 0x32
+	StatusPINAuthInvalid    Status = 0x33
+	StatusPINNotSet         Status = 0x35
+	StatusPINRequired       Status = 0x36
+	StatusActionTimeout     Status = 0x3a
+	StatusUPRequired        Status = 0x3b
+)
+
+//nolint:gochecknoglobals
+var statusNames = map[Status]string{
+	StatusInvalidCommand:    "invalid command",
+	StatusInvalidParameter:  "invalid parameter",
+	StatusInvalidLength:     "invalid length",
+	StatusInvalidCBOR:       "invalid CBOR",
+	StatusMissingParameter:  "missing parameter",
+	StatusUnsupportedOption: "unsupported option",
+	StatusOperationDenied:   "operation denied",
+	StatusNoCredentials:     "no credentials",
+	StatusUserActionTimeout: "user action timeout",
+	StatusPINInvalid:        "PIN invalid",
+	StatusPINBlocked:        "PIN blocked",
+	StatusPINAuthInvalid:    "PIN auth invalid",
+	StatusPINNotSet:         "PIN not set",
+	StatusPINRequired:       "PIN required",
+	StatusActionTimeout:     "action timeout",
+	StatusUPRequired:        "user presence required",
+}
+
+func (s Status) Error() string {
+	if n, ok := statusNames[s]; ok {
+		return "ctap: " + n
+	}
+
+	return fmt.Sprintf("ctap: status 0x%02x", byte(s))
+}
+
+// Transport exchanges CTAP2 messages with an authenticator.
+type Transport interface {
+	// Transact sends a command with its CBOR-encoded parameters
+	// and returns the CBOR-encoded response on success.
+	Transact(cmd Command, req []byte) ([]byte, error)
+
+	Close() error
+}
+
+// response splits a CTAP2 response into its status and CBOR-encoded data.
+func response(b []byte) ([]byte, error) {
+	if len(b) < 1 {
+		return nil, fmt.Errorf("%w: empty response", ErrInvalidResponse)
+	}
+
+	if s := Status(b[0]); s != StatusOK {
+		return nil, s
+	}
+
+	return b[1:], nil
+}
+
+// Info is the response of the authenticatorGetInfo command.
+type Info struct {
+	Versions   []string
+	Extensions []string
+	AAGUID     []byte
+	Options    map[string]bool
+}
+
+// HasExtension checks whether the authenticator supports an extension like "hmac-secret".
+func (i *Info) HasExtension(ext string) bool {
+	for _, e := range i.Extensions {
+		if e == ext {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetInfo queries the versions, extensions and options supported by an authenticator.
+func GetInfo(t Transport) (*Info, error) {
+	resp, err := t.Transact(CmdGetInfo, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	v, _, err := cbor.Unmarshal(resp)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+	}
+
+	m, ok := v.(map[any]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: expected map", ErrInvalidResponse)
+	}
+
+	info := &Info{
+		Options: map[string]bool{},
+	}
+
+	info.Versions = stringList(m[int64(1)])
+	info.Extensions = stringList(m[int64(2)])
+	info.AAGUID, _ = m[int64(3)].([]byte)
+
+	if opts, ok := m[int64(4)].(map[any]any); ok {
+		for k, v := range opts {
+			if k, ok := k.(string); ok {
+				info.Options[k], _ = v.(bool)
+			}
+		}
+	}
+
+	return info, nil
+}
+
+func stringList(v any) (s []string) {
+	l, _ := v.([]any)
+	for _, e := range l {
+		if e, ok := e.(string); ok {
+			s = append(s, e)
+		}
+	}
+
+	return s
+}
@@ -0,0 +1,193 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package ctap_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"cunicu.li/go-iso7816"
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/ctap"
+	"cunicu.li/hawkes/internal/cbor"
+)
+
+// hidAuthenticator emulates the CTAPHID layer of an authenticator
+// which echos CTAP2 requests after a keep-alive message.
+type hidAuthenticator struct {
+	cid     uint32
+	msg     []byte
+	cmd     byte
+	size    int
+	reports [][]byte
+}
+
+func (a *hidAuthenticator) Write(p []byte) (int, error) {
+	if p[4]&0x80 != 0 {
+		a.cmd = p[4]
+		a.size = int(binary.BigEndian.Uint16(p[5:]))
+		a.msg = append([]byte{}, p[7:]...)
+	} else {
+		a.msg = append(a.msg, p[5:]...)
+	}
+
+	if len(a.msg) < a.size {
+		return len(p), nil
+	}
+
+	msg := a.msg[:a.size]
+
+	switch a.cmd {
+	case 0x86: // INIT
+		resp := append(msg, 0, 0, 0, 0, 2, 5, 4, 3, 0x05) //nolint:gocritic
+		binary.BigEndian.PutUint32(resp[8:], a.cid)
+		a.queue(0xffffffff, 0x86, resp)
+
+	case 0x90: // CBOR
+		a.queue(a.cid, 0xbb, []byte{2})
+		a.queue(a.cid+1, 0x90, []byte{0})
+
+		if msg[0] == byte(ctap.CmdGetInfo) {
+			info, _ := cbor.Marshal(map[any]any{
+				int64(1): []any{"FIDO_2_0", "FIDO_2_1"},
+				int64(2): []any{"credProtect", "hmac-secret"},
+				int64(3): bytes.Repeat([]byte{0xaa}, 16),
+				int64(4): map[any]any{"rk": true, "clientPin": false},
+			})
+			a.queue(a.cid, 0x90, append([]byte{0}, info...))
+		} else {
+			a.queue(a.cid, 0x90, append([]byte{0}, msg[1:]...))
+		}
+
+	default:
+		a.queue(a.cid, 0xbf, []byte{0x01})
+	}
+
+	return len(p), nil
+}
+
+func (a *hidAuthenticator) queue(cid uint32, cmd byte, data []byte) {
+	pkt := make([]byte, ctap.HIDReportSize)
+	binary.BigEndian.PutUint32(pkt, cid)
+	pkt[4] = cmd
+	binary.BigEndian.PutUint16(pkt[5:], uint16(len(data))) //nolint:gosec
+	data = data[copy(pkt[7:], data):]
+	a.reports = append(a.reports, pkt)
+
+	for seq := byte(0); len(data) > 0; seq++ {
+		pkt := make([]byte, ctap.HIDReportSize)
+		binary.BigEndian.PutUint32(pkt, cid)
+		pkt[4] = seq
+		data = data[copy(pkt[5:], data):]
+		a.reports = append(a.reports, pkt)
+	}
+}
+
+func (a *hidAuthenticator) Read(p []byte) (int, error) {
+	n := copy(p, a.reports[0])
+	a.reports = a.reports[1:]
+
+	return n, nil
+}
+
+func (a *hidAuthenticator) Close() error {
+	return nil
+}
+
+func TestHID(t *testing.T) {
+	require := require.New(t)
+
+	h, err := ctap.NewHID(&hidAuthenticator{cid: 0x01020304})
+	require.NoError(err)
+	require.Equal([3]byte{5, 4, 3}, h.Version)
+	require.Equal(ctap.HIDCapWink|ctap.HIDCapCBOR, h.Capabilities)
+
+	var status []byte
+	h.KeepAlive = func(s byte) { status = append(status, s) }
+
+	req := bytes.Repeat([]byte{0x42}, 1000)
+
+	resp, err := h.Transact(ctap.CmdGetAssertion, req)
+	require.NoError(err)
+	require.Equal(req, resp)
+	require.Equal([]byte{2}, status)
+
+	info, err := ctap.GetInfo(h)
+	require.NoError(err)
+	require.Equal([]string{"FIDO_2_0", "FIDO_2_1"}, info.Versions)
+	require.True(info.HasExtension("hmac-secret"))
+	require.True(info.Options["rk"])
+	require.Len(info.AAGUID, 16)
+
+	_, err = h.Ping([]byte("ping"))
+	require.ErrorIs(err, ctap.HIDError(0x01))
+}
+
+// nfcCard emulates the NFC framing of CTAP2 messages of an authenticator
+// which echos requests after a status update.
+type nfcCard struct {
+	msg     []byte
+	resp    []byte
+	updates int
+}
+
+func (c *nfcCard) Transmit(apdu []byte) ([]byte, error) {
+	var data []byte
+	if len(apdu) > 5 {
+		data = apdu[5 : 5+int(apdu[4])]
+	}
+
+	switch {
+	case apdu[1] == byte(iso7816.InsSelect):
+		return append([]byte("FIDO_2_0"), 0x90, 0x00), nil
+
+	case apdu[0] == 0x90 && apdu[1] == 0x10:
+		c.msg = append(c.msg, data...)
+		return []byte{0x90, 0x00}, nil
+
+	case apdu[0] == 0x80 && apdu[1] == 0x10:
+		c.msg = append(c.msg, data...)
+		c.resp = append([]byte{0}, c.msg[1:]...)
+		c.msg = nil
+		c.updates = 2
+
+		return []byte{0x01, 0x91, 0x00}, nil
+
+	case apdu[0] == 0x80 && apdu[1] == 0x11 && c.updates > 1:
+		c.updates--
+		return []byte{0x02, 0x91, 0x00}, nil
+	}
+
+	// NFCCTAP_GETRESPONSE or GET RESPONSE
+	n := min(len(c.resp), 256)
+	resp := bytes.Clone(c.resp[:n])
+	c.resp = c.resp[n:]
+
+	if len(c.resp) > 0 {
+		return append(resp, 0x61, byte(min(len(c.resp), 256))), nil //nolint:gosec
+	}
+
+	return append(resp, 0x90, 0x00), nil
+}
+
+func (c *nfcCard) BeginTransaction() error { return nil }
+func (c *nfcCard) EndTransaction() error   { return nil }
+func (c *nfcCard) Close() error            { return nil }
+func (c *nfcCard) Base() iso7816.PCSCCard  { return c }
+
+func TestNFC(t *testing.T) {
+	require := require.New(t)
+
+	n, err := ctap.NewNFC(iso7816.NewCard(&nfcCard{}))
+	require.NoError(err)
+	require.Equal("FIDO_2_0", n.Version)
+
+	req := bytes.Repeat([]byte{0x42}, 1000)
+
+	resp, err := n.Transact(ctap.CmdGetAssertion, req)
+	require.NoError(err)
+	require.Equal(req, resp)
+}
@@ -0,0 +1,268 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package ctap
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// See: https://fidoalliance.org/specs/fido-v2.1-ps-20210615/fido-client-to-authenticator-protocol-v2.1-ps-20210615.html#usb
+
+var ErrChannelBusy = errors.New("channel busy")
+
+// HIDReportSize is the size of CTAPHID packets.
+const HIDReportSize = 64
+
+const (
+	hidPing      byte = 0x81
+	hidInit      byte = 0x86
+	hidWink      byte = 0x88
+	hidCBOR      byte = 0x90
+	hidCancel    byte = 0x91
+	hidKeepAlive byte = 0xbb
+	hidError     byte = 0xbf
+
+	hidBroadcast uint32 = 0xffffffff
+
+	hidInitSize = HIDReportSize - 7
+	hidContSize = HIDReportSize - 5
+)
+
+// HIDError is an error code of the CTAPHID layer.
+type HIDError byte
+
+func (e HIDError) Error() string {
+	switch e {
+	case 0x01:
+		return "ctaphid: invalid command"
+	case 0x03:
+		return "ctaphid: invalid length"
+	case 0x04:
+		return "ctaphid: invalid sequence"
+	case 0x05:
+		return "ctaphid: message timeout"
+	case 0x06:
+		return "ctaphid: channel busy"
+	case 0x0b:
+		return "ctaphid: invalid channel"
+	}
+
+	return fmt.Sprintf("ctaphid: error 0x%02x", byte(e))
+}
+
+func (e HIDError) Is(target error) bool {
+	return e == 0x06 && target == ErrChannelBusy
+}
+
+// HIDCapabilities are the capability flags reported by CTAPHID_INIT.
+type HIDCapabilities byte
+
+const (
+	HIDCapWink HIDCapabilities = 0x01
+	HIDCapCBOR HIDCapabilities = 0x04
+	HIDCapNMsg HIDCapabilities = 0x08
+)
+
+// HID is a CTAPHID transport over a HID device which
+// reads and writes reports of HIDReportSize bytes.
+type HID struct {
+	dev io.ReadWriteCloser
+	cid uint32
+
+	// KeepAlive is invoked for keep-alive messages
+	// with their status while the authenticator processes a request,
+	// e.g. to prompt the user for touching the authenticator.
+	KeepAlive func(status byte)
+
+	Version      [3]byte
+	Capabilities HIDCapabilities
+}
+
+// NewHID allocates a CTAPHID channel on the device.
+func NewHID(dev io.ReadWriteCloser) (*HID, error) {
+	h := &HID{
+		dev: dev,
+		cid: hidBroadcast,
+	}
+
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	resp, err := h.transact(hidInit, nonce)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize channel: %w", err)
+	}
+
+	if len(resp) < 17 || !bytes.Equal(resp[:8], nonce) {
+		return nil, fmt.Errorf("%w: invalid init response", ErrInvalidResponse)
+	}
+
+	h.cid = binary.BigEndian.Uint32(resp[8:])
+	h.Version = [3]byte(resp[13:16])
+	h.Capabilities = HIDCapabilities(resp[16])
+
+	return h, nil
+}
+
+// Transact sends a CTAP2 command via CTAPHID_CBOR.
+func (h *HID) Transact(cmd Command, req []byte) ([]byte, error) {
+	if h.Capabilities&HIDCapCBOR == 0 {
+		return nil, fmt.Errorf("%w: device does not support CTAP2", errors.ErrUnsupported)
+	}
+
+	resp, err := h.transact(hidCBOR, append([]byte{byte(cmd)}, req...))
+	if err != nil {
+		return nil, err
+	}
+
+	return response(resp)
+}
+
+// Ping echos data through the authenticator.
+func (h *HID) Ping(data []byte) ([]byte, error) {
+	return h.transact(hidPing, data)
+}
+
+// Wink lets the authenticator blink to identify it.
+func (h *HID) Wink() error {
+	if h.Capabilities&HIDCapWink == 0 {
+		return fmt.Errorf("%w: device does not support wink", errors.ErrUnsupported)
+	}
+
+	_, err := h.transact(hidWink, nil)
+
+	return err
+}
+
+// Cancel aborts a pending request.
+func (h *HID) Cancel() error {
+	return h.write(hidCancel, nil)
+}
+
+func (h *HID) Close() error {
+	return h.dev.Close()
+}
+
+func (h *HID) transact(cmd byte, data []byte) ([]byte, error) {
+	if err := h.write(cmd, data); err != nil {
+		return nil, err
+	}
+
+	for {
+		rcmd, resp, err := h.read()
+		if err != nil {
+			return nil, err
+		}
+
+		switch rcmd {
+		case cmd:
+			return resp, nil
+
+		case hidKeepAlive:
+			if h.KeepAlive != nil && len(resp) > 0 {
+				h.KeepAlive(resp[0])
+			}
+
+		case hidError:
+			if len(resp) < 1 {
+				return nil, fmt.Errorf("%w: empty error", ErrInvalidResponse)
+			}
+
+			return nil, HIDError(resp[0])
+
+		default:
+			return nil, fmt.Errorf("%w: unexpected command 0x%02x", ErrInvalidResponse, rcmd)
+		}
+	}
+}
+
+// write fragments a message into an initialization packet and continuation packets.
+func (h *HID) write(cmd byte, data []byte) error {
+	if len(data) > hidInitSize+0x80*hidContSize {
+		return fmt.Errorf("%w: message too large", HIDError(0x03))
+	}
+
+	pkt := make([]byte, HIDReportSize)
+	binary.BigEndian.PutUint32(pkt, h.cid)
+	pkt[4] = cmd
+	binary.BigEndian.PutUint16(pkt[5:], uint16(len(data))) //nolint:gosec
+
+	n := copy(pkt[7:], data)
+	data = data[n:]
+
+	if _, err := h.dev.Write(pkt); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+
+	for seq := byte(0); len(data) > 0; seq++ {
+		clear(pkt[4:])
+		pkt[4] = seq
+
+		n := copy(pkt[5:], data)
+		data = data[n:]
+
+		if _, err := h.dev.Write(pkt); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// read reassembles a message of our channel.
+// Packets of other channels are skipped.
+func (h *HID) read() (cmd byte, data []byte, err error) {
+	pkt := make([]byte, HIDReportSize)
+
+	for {
+		if err := h.readReport(pkt); err != nil {
+			return 0, nil, err
+		}
+
+		if binary.BigEndian.Uint32(pkt) == h.cid && pkt[4]&0x80 != 0 {
+			break
+		}
+	}
+
+	cmd = pkt[4]
+	size := int(binary.BigEndian.Uint16(pkt[5:]))
+	data = append(data, pkt[7:7+min(size, hidInitSize)]...)
+
+	for seq := byte(0); len(data) < size; {
+		if err := h.readReport(pkt); err != nil {
+			return 0, nil, err
+		}
+
+		if binary.BigEndian.Uint32(pkt) != h.cid {
+			continue
+		}
+
+		if pkt[4] != seq {
+			return 0, nil, HIDError(0x04)
+		}
+
+		data = append(data, pkt[5:5+min(size-len(data), hidContSize)]...)
+		seq++
+	}
+
+	return cmd, data, nil
+}
+
+func (h *HID) readReport(pkt []byte) error {
+	n, err := h.dev.Read(pkt)
+	if err != nil {
+		return fmt.Errorf("failed to read report: %w", err)
+	} else if n < HIDReportSize {
+		return fmt.Errorf("%w: short report", ErrInvalidResponse)
+	}
+
+	return nil
+}
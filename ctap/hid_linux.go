@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package ctap
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+//nolint:gochecknoglobals
+var (
+	sysfsHIDRaw = "/sys/class/hidraw"
+	devDir      = "/dev"
+)
+
+// usagePageFIDO is the HID report descriptor item declaring the FIDO usage page 0xF1D0.
+//
+//nolint:gochecknoglobals
+var usagePageFIDO = []byte{0x06, 0xd0, 0xf1}
+
+// HIDDevices returns the paths of hidraw devices of FIDO authenticators.
+func HIDDevices() (paths []string, err error) {
+	devs, err := os.ReadDir(sysfsHIDRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dev := range devs {
+		desc, err := os.ReadFile(filepath.Join(sysfsHIDRaw, dev.Name(), "device", "report_descriptor"))
+		if err != nil || !bytes.Contains(desc, usagePageFIDO) {
+			continue
+		}
+
+		paths = append(paths, filepath.Join(devDir, dev.Name()))
+	}
+
+	return paths, nil
+}
+
+// OpenHID opens a hidraw device and allocates a CTAPHID channel.
+func OpenHID(path string) (*HID, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open device: %w", err)
+	}
+
+	h, err := NewHID(&hidraw{f})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// hidraw prefixes written reports with the report number
+// as required by the hidraw driver.
+type hidraw struct {
+	*os.File
+}
+
+func (d *hidraw) Write(p []byte) (int, error) {
+	n, err := d.File.Write(append([]byte{0}, p...))
+	return max(n-1, 0), err
+}
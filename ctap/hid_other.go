@@ -0,0 +1,20 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux
+
+package ctap
+
+import "errors"
+
+// HIDDevices returns the paths of HID devices of FIDO authenticators.
+// Enumeration is currently only supported on Linux.
+func HIDDevices() ([]string, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// OpenHID opens a HID device and allocates a CTAPHID channel.
+// Other platforms can use NewHID with their own HID device implementation.
+func OpenHID(string) (*HID, error) {
+	return nil, errors.ErrUnsupported
+}
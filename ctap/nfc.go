@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package ctap
+
+import (
+	"errors"
+	"fmt"
+
+	"cunicu.li/go-iso7816"
+)
+
+// See: https://fidoalliance.org/specs/fido-v2.1-ps-20210615/fido-client-to-authenticator-protocol-v2.1-ps-20210615.html#nfc
+
+const (
+	nfcClaProprietary byte = 0x80
+	nfcClaChaining    byte = 0x10
+
+	nfcInsMsg         iso7816.Instruction = 0x10
+	nfcInsGetResponse iso7816.Instruction = 0x11
+)
+
+// codeStatusUpdate is returned while the authenticator is still processing a request.
+//
+//nolint:gochecknoglobals
+var codeStatusUpdate = iso7816.Code{0x91, 0x00}
+
+// NFC is a CTAP2 transport which frames messages in APDUs as used by authenticators
+// connected via NFC or via the CCID interface of PC/SC readers.
+type NFC struct {
+	card *iso7816.Card
+
+	// Version is the response of the applet selection, e.g. "FIDO_2_0".
+	Version string
+}
+
+// NewNFC selects the FIDO applet on the card.
+func NewNFC(card *iso7816.Card) (*NFC, error) {
+	resp, err := card.Select(iso7816.AidFIDO)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select applet: %w", err)
+	}
+
+	return &NFC{
+		card:    card,
+		Version: string(resp),
+	}, nil
+}
+
+// Transact sends a CTAP2 command via NFCCTAP_MSG.
+// Commands exceeding a short APDU are sent using command chaining.
+func (n *NFC) Transact(cmd Command, req []byte) ([]byte, error) {
+	data := append([]byte{byte(cmd)}, req...)
+
+	for len(data) > iso7816.MaxLenCommandDataStandard {
+		if _, err := n.card.Send(&iso7816.CAPDU{
+			Cla:  nfcClaProprietary | nfcClaChaining,
+			Ins:  nfcInsMsg,
+			Data: data[:iso7816.MaxLenCommandDataStandard],
+		}); err != nil {
+			return nil, fmt.Errorf("failed to send command: %w", err)
+		}
+
+		data = data[iso7816.MaxLenCommandDataStandard:]
+	}
+
+	// P1 0x80 signals support for NFCCTAP_GETRESPONSE
+	// so that the authenticator can reply with status updates
+	resp, err := n.card.Send(&iso7816.CAPDU{
+		Cla:  nfcClaProprietary,
+		Ins:  nfcInsMsg,
+		P1:   0x80,
+		Data: data,
+		Ne:   iso7816.MaxLenResponseDataStandard,
+	})
+
+	for errors.Is(err, codeStatusUpdate) {
+		resp, err = n.card.Send(&iso7816.CAPDU{
+			Cla: nfcClaProprietary,
+			Ins: nfcInsGetResponse,
+			Ne:  iso7816.MaxLenResponseDataStandard,
+		})
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to send command: %w", err)
+	}
+
+	return response(resp)
+}
+
+// Close closes the underlying card.
+func (n *NFC) Close() error {
+	return n.card.Close()
+}
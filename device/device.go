@@ -0,0 +1,59 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package device implements matchers for the discovery of smart cards and tokens.
+package device
+
+import (
+	"errors"
+	"fmt"
+
+	"cunicu.li/go-iso7816"
+)
+
+var ErrInvalidPattern = errors.New("invalid pattern")
+
+// USBID identifies the model of a USB device.
+type USBID struct {
+	Vendor  uint16
+	Product uint16
+}
+
+func (id USBID) String() string {
+	return fmt.Sprintf("%04x:%04x", id.Vendor, id.Product)
+}
+
+// Info describes a card and the reader it is inserted into.
+type Info struct {
+	// Reader is the name of the PC/SC reader.
+	Reader string
+
+	// ATR is the answer-to-reset of the card.
+	ATR []byte
+
+	// USB is the vendor and product ID of the reader if it is connected via USB.
+	USB *USBID
+
+	card iso7816.PCSCCard
+}
+
+// Card returns the card for probing its applets.
+func (i *Info) Card() iso7816.PCSCCard {
+	return i.card
+}
+
+// Inspect gathers information about a card.
+// Unavailable information is left empty.
+func Inspect(card iso7816.PCSCCard) *Info {
+	info := &Info{
+		card: card,
+	}
+
+	if rc, ok := card.(iso7816.ReaderCard); ok {
+		info.Reader = rc.Reader()
+	}
+
+	inspectPCSC(info, card)
+
+	return info
+}
@@ -0,0 +1,24 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build (!cgo && !windows) || nopcsc
+
+package device
+
+import (
+	"errors"
+
+	"cunicu.li/go-iso7816"
+)
+
+// inspectPCSC is a no-op as this build has no PC/SC support.
+func inspectPCSC(*Info, iso7816.PCSCCard) {}
+
+// pcscReaders finds no readers as this build has no PC/SC support.
+func pcscReaders() ([]string, error) {
+	return nil, nil
+}
+
+func probePCSC(string) (*Device, error) {
+	return nil, errors.ErrUnsupported
+}
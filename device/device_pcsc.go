@@ -0,0 +1,98 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build (cgo || windows) && !nopcsc
+
+package device
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"cunicu.li/go-iso7816"
+	"cunicu.li/go-iso7816/drivers/pcsc"
+	"github.com/ebfe/scard"
+)
+
+// inspectPCSC queries the reader of a PC/SC card for its status and USB IDs.
+func inspectPCSC(info *Info, card iso7816.PCSCCard) {
+	pc, ok := card.Base().(*pcsc.Card)
+	if !ok {
+		return
+	}
+
+	if sts, err := pc.Status(); err == nil {
+		info.Reader = sts.Reader
+		info.ATR = sts.Atr
+	}
+
+	// https://ludovicrousseau.blogspot.com/2020/04/scardattrchannelid-and-usb-devices.html
+	if data, err := pc.GetAttrib(scard.AttrChannelId); err == nil && len(data) == 4 {
+		ch := binary.NativeEndian.Uint32(data)
+		if ch>>16 == 0x20 {
+			bus := int(ch>>8) & 0xff
+			addr := int(ch) & 0xff
+
+			if id, err := usbID(bus, addr); err == nil {
+				info.USB = &id
+			}
+		}
+	}
+}
+
+// pcscReaders lists the readers of the PC/SC service.
+// A missing service is treated like a system without readers.
+func pcscReaders() ([]string, error) {
+	ctx, err := scard.EstablishContext()
+	if isNoService(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer ctx.Release() //nolint:errcheck
+
+	readers, err := ctx.ListReaders()
+	if errors.Is(err, scard.ErrNoReadersAvailable) || isNoService(err) {
+		return nil, nil
+	}
+
+	return readers, err
+}
+
+// probePCSC inspects the card in a reader.
+// Each probe uses its own context as calls on a shared context
+// are serialized by pcsc-lite and would block on a wedged reader.
+func probePCSC(reader string) (*Device, error) {
+	d := &Device{
+		Transport: TransportPCSC,
+		Name:      reader,
+	}
+
+	ctx, err := scard.EstablishContext()
+	if err != nil {
+		return d, err
+	}
+	defer ctx.Release() //nolint:errcheck
+
+	card, err := pcsc.NewCard(ctx, reader, true)
+	if errors.Is(err, scard.ErrNoSmartcard) || errors.Is(err, scard.ErrRemovedCard) {
+		return d, nil
+	} else if err != nil {
+		return d, err
+	}
+
+	info := Inspect(card)
+	d.ATR = info.ATR
+	d.USB = info.USB
+
+	// Other processes might use the card as well
+	if pc, ok := card.Base().(*pcsc.Card); ok {
+		return d, pc.Disconnect(scard.LeaveCard)
+	}
+
+	return d, card.Close()
+}
+
+func isNoService(err error) bool {
+	return errors.Is(err, scard.ErrNoService) || errors.Is(err, scard.ErrServiceStopped)
+}
@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package device
+
+import (
+	"cunicu.li/go-iso7816"
+)
+
+// Diagnosis is a snapshot of the smart card subsystem for troubleshooting.
+type Diagnosis struct {
+	// PCSC is false if this build has no PC/SC support.
+	PCSC bool `json:"pcsc"`
+
+	// Service is true if the PC/SC service (pcscd on Linux) is reachable.
+	Service bool `json:"service"`
+
+	// Error is the error of the PC/SC service.
+	Error string `json:"error,omitempty"`
+
+	Readers []*ReaderState `json:"readers"`
+
+	// Hints are suggestions for fixing common misconfigurations.
+	Hints []string `json:"hints,omitempty"`
+}
+
+// ReaderState describes a reader and the card inserted into it.
+type ReaderState struct {
+	Name string `json:"name"`
+
+	// Card is true if a card is present in the reader.
+	Card bool   `json:"card"`
+	ATR  string `json:"atr,omitempty"`
+	USB  string `json:"usb,omitempty"`
+
+	// Applets maps the names of probed applets to whether they could be selected.
+	Applets map[string]bool `json:"applets,omitempty"`
+
+	// Error is the error which occurred while connecting to the card.
+	Error string `json:"error,omitempty"`
+}
+
+// probedApplets are the applets used by hawkes providers and tools.
+//
+//nolint:gochecknoglobals
+var probedApplets = []struct {
+	name string
+	aid  []byte
+}{
+	{"OpenPGP", iso7816.AidOpenPGP},
+	{"PIV", iso7816.AidPIV},
+	{"YKOATH", iso7816.AidYubicoOATH},
+	{"FIDO", iso7816.AidFIDO},
+}
+
+// Diagnose lists the readers, cards and their applets and adds
+// hints for common reasons why no suitable reader is found.
+func Diagnose() *Diagnosis {
+	d := &Diagnosis{
+		Readers: []*ReaderState{},
+	}
+
+	diagnosePCSC(d)
+
+	d.Hints = append(d.Hints, systemHints(d)...)
+	d.Hints = append(d.Hints, hints(d)...)
+
+	return d
+}
+
+func hints(d *Diagnosis) (hs []string) {
+	switch {
+	case !d.PCSC:
+		return []string{"This build has no PC/SC support. Rebuild with cgo enabled or access cards via the card broker."}
+
+	case !d.Service:
+		return nil
+
+	case len(d.Readers) == 0:
+		return []string{"No readers found. Check that the token is plugged in and its CCID interface is enabled."}
+	}
+
+	cards, applets := 0, 0
+	for _, r := range d.Readers {
+		if r.Card {
+			cards++
+		}
+
+		for _, ok := range r.Applets {
+			if ok {
+				applets++
+			}
+		}
+	}
+
+	switch {
+	case cards == 0:
+		hs = append(hs, "No card is present in any reader.")
+	case applets == 0:
+		hs = append(hs, "None of the cards provides an applet supported by hawkes.")
+	}
+
+	return hs
+}
@@ -0,0 +1,69 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package device
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// pcscdSocket is the default socket of pcsc-lite.
+const pcscdSocket = "/run/pcscd/pcscd.comm"
+
+// tokenVendors are USB vendor IDs of common security tokens.
+//
+//nolint:gochecknoglobals
+var tokenVendors = []int{
+	0x1050, // Yubico
+	0x20a0, // Nitrokey
+	0x096e, // Feitian
+}
+
+func systemHints(d *Diagnosis) (hs []string) {
+	if d.PCSC && !d.Service {
+		socket := pcscdSocket
+		if s, ok := os.LookupEnv("PCSCLITE_CSOCK_NAME"); ok {
+			socket = s
+		}
+
+		if _, err := os.Stat(socket); errors.Is(err, fs.ErrNotExist) {
+			hs = append(hs, "pcscd is not running. Install pcsc-lite and start it with 'systemctl enable --now pcscd.socket'.")
+		} else if conn, err := net.Dial("unix", socket); errors.Is(err, fs.ErrPermission) {
+			hs = append(hs, "Access to the pcscd socket "+socket+" is denied. Check its permissions.")
+		} else {
+			if err == nil {
+				conn.Close()
+			}
+
+			hs = append(hs, "pcscd refused the connection. Check the polkit rules of pcsc-lite for your user and the log of pcscd.")
+		}
+	}
+
+	if d.Service && len(d.Readers) == 0 && tokenConnected() {
+		hs = append(hs, "A security token is connected via USB but pcscd lists no reader. "+
+			"Check that the CCID driver is installed and pcscd may access the device nodes in /dev/bus/usb.")
+	}
+
+	return hs
+}
+
+// tokenConnected returns true if a USB device of a known token vendor is connected.
+func tokenConnected() bool {
+	devs, err := os.ReadDir(sysfsUSBDevices)
+	if err != nil {
+		return false
+	}
+
+	for _, dev := range devs {
+		if slices.Contains(tokenVendors, readInt(filepath.Join(sysfsUSBDevices, dev.Name()), "idVendor", 16)) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,9 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build (!cgo && !windows) || nopcsc
+
+package device
+
+// diagnosePCSC leaves the diagnosis empty as this build has no PC/SC support.
+func diagnosePCSC(*Diagnosis) {}
@@ -0,0 +1,14 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux && !windows
+
+package device
+
+func systemHints(d *Diagnosis) []string {
+	if d.PCSC && !d.Service {
+		return []string{"The smart card service is not available. Check that it is enabled and running."}
+	}
+
+	return nil
+}
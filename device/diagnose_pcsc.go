@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build (cgo || windows) && !nopcsc
+
+package device
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"sync"
+
+	"cunicu.li/go-iso7816/drivers/pcsc"
+	"cunicu.li/go-iso7816/filter"
+	"github.com/ebfe/scard"
+)
+
+func diagnosePCSC(d *Diagnosis) {
+	d.PCSC = true
+
+	ctx, err := scard.EstablishContext()
+	if err != nil {
+		d.Error = err.Error()
+		return
+	}
+	defer ctx.Release() //nolint:errcheck
+
+	d.Service = true
+
+	readers, err := ctx.ListReaders()
+	if err != nil && !errors.Is(err, scard.ErrNoReadersAvailable) {
+		d.Error = err.Error()
+		return
+	}
+
+	slices.Sort(readers)
+
+	// Readers are diagnosed in parallel so that a wedged reader does not hold up the others
+	d.Readers = make([]*ReaderState, len(readers))
+
+	var wg sync.WaitGroup
+	for i, reader := range readers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			r, err := within(context.Background(), DefaultProbeTimeout, func() (*ReaderState, error) {
+				return diagnoseReader(reader), nil
+			})
+			if err != nil {
+				r = &ReaderState{
+					Name:  reader,
+					Error: err.Error(),
+				}
+			}
+
+			d.Readers[i] = r
+		}()
+	}
+
+	wg.Wait()
+}
+
+func diagnoseReader(reader string) *ReaderState {
+	r := &ReaderState{
+		Name: reader,
+	}
+
+	ctx, err := scard.EstablishContext()
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	defer ctx.Release() //nolint:errcheck
+
+	card, err := pcsc.NewCard(ctx, reader, true)
+	if err != nil {
+		if !errors.Is(err, scard.ErrNoSmartcard) && !errors.Is(err, scard.ErrRemovedCard) {
+			r.Error = err.Error()
+		}
+
+		return r
+	}
+	defer card.Close()
+
+	info := Inspect(card)
+
+	r.Card = true
+	r.ATR = hex.EncodeToString(info.ATR)
+	r.Applets = map[string]bool{}
+
+	if info.USB != nil {
+		r.USB = info.USB.String()
+	}
+
+	for _, a := range probedApplets {
+		ok, err := filter.HasApplet(a.aid)(card)
+		r.Applets[a.name] = ok && err == nil
+	}
+
+	return r
+}
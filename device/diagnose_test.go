@@ -0,0 +1,35 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package device_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/device"
+)
+
+func TestDiagnose(t *testing.T) {
+	require := require.New(t)
+
+	d := device.Diagnose()
+	require.NotNil(d.Readers)
+
+	// Unusable setups must always come with a hint
+	usable := false
+	for _, r := range d.Readers {
+		for _, ok := range r.Applets {
+			usable = usable || ok
+		}
+	}
+
+	if !usable {
+		require.NotEmpty(d.Hints)
+	}
+
+	_, err := json.Marshal(d)
+	require.NoError(err)
+}
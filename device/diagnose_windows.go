@@ -0,0 +1,25 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package device
+
+import "strings"
+
+// errSharingViolation is the code of SCARD_E_SHARING_VIOLATION as contained in error messages.
+const errSharingViolation = "8010000b"
+
+func systemHints(d *Diagnosis) (hs []string) {
+	if d.PCSC && !d.Service {
+		hs = append(hs, "The Smart Card service (SCardSvr) is not running. "+
+			"Windows only starts it while a reader is attached. Plug in the token and check that the service is not disabled.")
+	}
+
+	for _, r := range d.Readers {
+		if strings.Contains(r.Error, errSharingViolation) {
+			hs = append(hs, "The card in reader '"+r.Name+"' is held exclusively by another application. "+
+				"Close other smart card tools and try again.")
+		}
+	}
+
+	return hs
+}
@@ -0,0 +1,226 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package device
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"cunicu.li/hawkes/ctap"
+)
+
+var ErrProbeTimeout = errors.New("device did not respond within its time budget")
+
+// DefaultProbeTimeout is the default time budget for probing a single device.
+const DefaultProbeTimeout = 2 * time.Second
+
+// Transport is the interface via which a device is accessed.
+type Transport string
+
+const (
+	// TransportPCSC are readers of the PC/SC service.
+	TransportPCSC Transport = "pcsc"
+
+	// TransportUSB are USB CCID devices which are not listed by the PC/SC service.
+	TransportUSB Transport = "usb"
+
+	// TransportHID are FIDO authenticators connected via USB HID.
+	TransportHID Transport = "hid"
+)
+
+// Device is a device found by Devices.
+type Device struct {
+	Transport Transport `json:"transport"`
+
+	// Name is the reader name of PC/SC devices and the path of other devices.
+	Name string `json:"name"`
+
+	// ATR is the answer-to-reset of the card in a PC/SC reader.
+	ATR []byte `json:"atr,omitempty"`
+
+	// USB is the vendor and product ID of the device if it is connected via USB.
+	USB *USBID `json:"usb,omitempty"`
+
+	// Error is set if the device could not be probed.
+	Error error `json:"-"`
+}
+
+// DiscoverOptions configures Devices.
+type DiscoverOptions struct {
+	// Timeout is the time budget for probing a single device
+	// as well as for enumerating the devices of a transport.
+	// DefaultProbeTimeout is used if zero.
+	Timeout time.Duration
+
+	// Transports restricts the discovery to the given transports.
+	// All transports are discovered if nil.
+	Transports []Transport
+}
+
+// transport enumerates devices and probes them individually.
+type transport struct {
+	name  Transport
+	list  func() ([]string, error)
+	probe func(name string) (*Device, error)
+}
+
+//nolint:gochecknoglobals
+var transports = []transport{
+	{TransportPCSC, pcscReaders, probePCSC},
+	{TransportUSB, ccidDevices, probeCCID},
+	{TransportHID, hidDevices, probeHID},
+}
+
+// Devices discovers the devices of all transports.
+//
+// Devices are probed in parallel, each within its own time budget.
+// A device which does not respond in time, like a wedged reader,
+// is returned with ErrProbeTimeout instead of delaying the discovery.
+// Errors of enumerating a transport are joined and returned
+// together with the devices of the other transports.
+func Devices(ctx context.Context, opts *DiscoverOptions) ([]*Device, error) {
+	if opts == nil {
+		opts = &DiscoverOptions{}
+	}
+
+	budget := opts.Timeout
+	if budget <= 0 {
+		budget = DefaultProbeTimeout
+	}
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		devices []*Device
+		errs    []error
+	)
+
+	for _, t := range transports {
+		if opts.Transports != nil && !slices.Contains(opts.Transports, t.name) {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			names, err := within(ctx, budget, t.list)
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", t.name, err))
+				mu.Unlock()
+
+				return
+			}
+
+			for _, name := range names {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					d, err := within(ctx, budget, func() (*Device, error) {
+						return t.probe(name)
+					})
+					if d == nil {
+						d = &Device{
+							Transport: t.name,
+							Name:      name,
+						}
+					}
+
+					d.Error = err
+
+					mu.Lock()
+					devices = append(devices, d)
+					mu.Unlock()
+				}()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	slices.SortFunc(devices, func(a, b *Device) int {
+		return cmp.Or(cmp.Compare(a.Transport, b.Transport), cmp.Compare(a.Name, b.Name))
+	})
+
+	return withoutPCSCDuplicates(devices), errors.Join(errs...)
+}
+
+// within runs fn and returns ErrProbeTimeout if it does not return within the budget.
+// fn keeps running in the background as blocking PC/SC and device calls can not be interrupted.
+func within[T any](ctx context.Context, budget time.Duration, fn func() (T, error)) (T, error) {
+	ctx, cancel := context.WithTimeout(ctx, budget)
+	defer cancel()
+
+	type result struct {
+		v   T
+		err error
+	}
+
+	done := make(chan result, 1)
+
+	go func() {
+		v, err := fn()
+		done <- result{v, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.v, r.err
+	case <-ctx.Done():
+		var zero T
+		return zero, fmt.Errorf("%w: %w", ErrProbeTimeout, ctx.Err())
+	}
+}
+
+// withoutPCSCDuplicates removes USB CCID devices which are also listed as PC/SC readers.
+// The devices are matched by their USB IDs.
+func withoutPCSCDuplicates(devices []*Device) []*Device {
+	readers := map[USBID]int{}
+	for _, d := range devices {
+		if d.Transport == TransportPCSC && d.USB != nil {
+			readers[*d.USB]++
+		}
+	}
+
+	return slices.DeleteFunc(devices, func(d *Device) bool {
+		if d.Transport != TransportUSB || d.USB == nil || readers[*d.USB] == 0 {
+			return false
+		}
+
+		readers[*d.USB]--
+
+		return true
+	})
+}
+
+func hidDevices() ([]string, error) {
+	paths, err := ctap.HIDDevices()
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil, nil
+	}
+
+	return paths, err
+}
+
+// probeHID checks that the authenticator responds to the initialization of a CTAPHID channel.
+func probeHID(path string) (*Device, error) {
+	d := &Device{
+		Transport: TransportHID,
+		Name:      path,
+	}
+
+	h, err := ctap.OpenHID(path)
+	if err != nil {
+		return d, err
+	}
+
+	return d, h.Close()
+}
@@ -0,0 +1,89 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package device
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDevices(t *testing.T) {
+	require := require.New(t)
+
+	wedged := make(chan struct{})
+	defer close(wedged)
+
+	yubikey := &USBID{Vendor: 0x1050, Product: 0x0407}
+	errList := errors.New("failed to list") //nolint:err113
+
+	orig := transports
+	defer func() { transports = orig }()
+
+	transports = []transport{
+		{
+			name: TransportPCSC,
+			list: func() ([]string, error) {
+				return []string{"Wedged Reader 00 00", "Yubico YubiKey OTP+FIDO+CCID 00 00"}, nil
+			},
+			probe: func(name string) (*Device, error) {
+				if name == "Wedged Reader 00 00" {
+					<-wedged
+				}
+
+				return &Device{Transport: TransportPCSC, Name: name, USB: yubikey}, nil
+			},
+		},
+		{
+			name: TransportUSB,
+			list: func() ([]string, error) {
+				return []string{"/sys/bus/usb/devices/1-1", "/sys/bus/usb/devices/1-2"}, nil
+			},
+			probe: func(name string) (*Device, error) {
+				id := yubikey
+				if name == "/sys/bus/usb/devices/1-2" {
+					id = &USBID{Vendor: 0x20a0, Product: 0x42b2}
+				}
+
+				return &Device{Transport: TransportUSB, Name: name, USB: id}, nil
+			},
+		},
+		{
+			name: TransportHID,
+			list: func() ([]string, error) {
+				return nil, errList
+			},
+		},
+	}
+
+	start := time.Now()
+
+	devs, err := Devices(context.Background(), &DiscoverOptions{
+		Timeout: 100 * time.Millisecond,
+	})
+	require.ErrorIs(err, errList)
+	require.Less(time.Since(start), time.Second)
+
+	// The USB device of the YubiKey is also listed by PC/SC
+	require.Len(devs, 3)
+
+	require.Equal("Wedged Reader 00 00", devs[0].Name)
+	require.ErrorIs(devs[0].Error, ErrProbeTimeout)
+
+	require.Equal("Yubico YubiKey OTP+FIDO+CCID 00 00", devs[1].Name)
+	require.NoError(devs[1].Error)
+
+	require.Equal(TransportUSB, devs[2].Transport)
+	require.Equal(uint16(0x20a0), devs[2].USB.Vendor)
+
+	devs, err = Devices(context.Background(), &DiscoverOptions{
+		Timeout:    100 * time.Millisecond,
+		Transports: []Transport{TransportUSB},
+	})
+	require.NoError(err)
+	require.Len(devs, 2)
+}
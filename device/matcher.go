@@ -0,0 +1,186 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package device
+
+import (
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"cunicu.li/go-iso7816"
+	"cunicu.li/go-iso7816/filter"
+)
+
+// Matcher decides whether a device should be used.
+type Matcher func(info *Info) (bool, error)
+
+// Filter adapts the matcher for use with the discovery functions of go-iso7816.
+func (m Matcher) Filter() filter.Filter {
+	return func(card iso7816.PCSCCard) (bool, error) {
+		if card == nil {
+			return false, filter.ErrOpen
+		}
+
+		return m(Inspect(card))
+	}
+}
+
+// Match inspects the card and checks it against the matcher.
+func (m Matcher) Match(card iso7816.PCSCCard) (bool, error) {
+	return m(Inspect(card))
+}
+
+// Any matches all devices.
+func Any(*Info) (bool, error) {
+	return true, nil
+}
+
+// And matches devices which are matched by all matchers.
+func And(ms ...Matcher) Matcher {
+	return func(info *Info) (bool, error) {
+		for _, m := range ms {
+			if ok, err := m(info); err != nil || !ok {
+				return false, err
+			}
+		}
+
+		return true, nil
+	}
+}
+
+// Or matches devices which are matched by any of the matchers.
+func Or(ms ...Matcher) Matcher {
+	return func(info *Info) (bool, error) {
+		for _, m := range ms {
+			if ok, err := m(info); err != nil || ok {
+				return ok, err
+			}
+		}
+
+		return false, nil
+	}
+}
+
+// Not inverts a matcher.
+func Not(m Matcher) Matcher {
+	return func(info *Info) (bool, error) {
+		ok, err := m(info)
+		return !ok, err
+	}
+}
+
+// readerIndex matches the index which PC/SC implementations append to reader names.
+// pcsc-lite appends the reader and slot numbers in hex ("... CCID 00 00")
+// while Windows appends a decimal instance number ("... CCID 0").
+//
+//nolint:gochecknoglobals
+var readerIndex = regexp.MustCompile(`(?: [0-9A-F]{2} [0-9A-F]{2}| [0-9]+)$`)
+
+// NormalizeReaderName strips the platform-specific index from a reader name.
+// The result is the same on all platforms for a given reader model.
+func NormalizeReaderName(name string) string {
+	return strings.TrimSpace(readerIndex.ReplaceAllString(strings.TrimSpace(name), ""))
+}
+
+// ReaderName matches devices whose reader name matches the regular expression.
+// The pattern is matched against the name reported by the platform as well as
+// its normalized form so that patterns anchored at the end work everywhere.
+func ReaderName(pattern string) (Matcher, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPattern, err)
+	}
+
+	return func(info *Info) (bool, error) {
+		return re.MatchString(info.Reader) || re.MatchString(NormalizeReaderName(info.Reader)), nil
+	}, nil
+}
+
+// ATR matches devices by the answer-to-reset of the card.
+// The pattern is a hex string in which "xx" matches any byte.
+// It matches the beginning of the ATR.
+func ATR(pattern string) (Matcher, error) {
+	pattern = strings.ToLower(strings.NewReplacer(":", "", " ", "").Replace(pattern))
+	if len(pattern)%2 != 0 {
+		return nil, fmt.Errorf("%w: odd length ATR", ErrInvalidPattern)
+	}
+
+	value := make([]byte, len(pattern)/2)
+	mask := make([]byte, len(pattern)/2)
+
+	for i := range value {
+		b := pattern[2*i : 2*i+2]
+		if b == "xx" {
+			continue
+		}
+
+		v, err := hex.DecodeString(b)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidPattern, err)
+		}
+
+		value[i] = v[0]
+		mask[i] = 0xff
+	}
+
+	return func(info *Info) (bool, error) {
+		if len(info.ATR) < len(value) {
+			return false, nil
+		}
+
+		for i := range value {
+			if info.ATR[i]&mask[i] != value[i] {
+				return false, nil
+			}
+		}
+
+		return true, nil
+	}, nil
+}
+
+// USB matches devices by the USB vendor and product ID of their reader.
+// A product ID of zero matches all products of the vendor.
+func USB(vendor, product uint16) Matcher {
+	return func(info *Info) (bool, error) {
+		if info.USB == nil {
+			return false, nil
+		}
+
+		return info.USB.Vendor == vendor && (product == 0 || info.USB.Product == product), nil
+	}
+}
+
+// ParseUSB parses a USB ID in the form "vendor:product" or "vendor" (hexadecimal).
+func ParseUSB(s string) (Matcher, error) {
+	vendorStr, productStr, hasProduct := strings.Cut(s, ":")
+
+	vendor, err := strconv.ParseUint(vendorStr, 16, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPattern, err)
+	}
+
+	var product uint64
+	if hasProduct {
+		if product, err = strconv.ParseUint(productStr, 16, 16); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidPattern, err)
+		}
+	}
+
+	return USB(uint16(vendor), uint16(product)), nil
+}
+
+// HasApplet matches cards on which the applet with the given AID can be selected.
+func HasApplet(aid []byte) Matcher {
+	probe := filter.HasApplet(aid)
+
+	return func(info *Info) (bool, error) {
+		if info.card == nil {
+			return false, nil
+		}
+
+		return probe(info.card)
+	}
+}
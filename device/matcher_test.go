@@ -0,0 +1,73 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package device_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/device"
+)
+
+func TestMatchers(t *testing.T) {
+	require := require.New(t)
+
+	info := &device.Info{
+		Reader: "Yubico YubiKey OTP+FIDO+CCID 00 00",
+		ATR:    []byte{0x3b, 0xfd, 0x13, 0x00, 0x00, 0x81, 0x31},
+		USB:    &device.USBID{Vendor: 0x1050, Product: 0x0407},
+	}
+
+	match := func(m device.Matcher, err error) bool {
+		require.NoError(err)
+
+		ok, err := m(info)
+		require.NoError(err)
+
+		return ok
+	}
+
+	require.True(match(device.ReaderName("(?i)yubikey")))
+	require.False(match(device.ReaderName("^Nitrokey")))
+	require.True(match(device.ReaderName("CCID$")))
+
+	require.True(match(device.ATR("3b:fd:13")))
+	require.True(match(device.ATR("3B FD xx 00")))
+	require.False(match(device.ATR("3b:fe")))
+	require.False(match(device.ATR("3bfd13000081310000")))
+
+	require.True(match(device.ParseUSB("1050:0407")))
+	require.True(match(device.ParseUSB("1050")))
+	require.False(match(device.ParseUSB("20a0:42b2")))
+
+	require.True(match(device.And(device.Any, device.USB(0x1050, 0)), nil))
+	require.False(match(device.And(device.Any, device.USB(0x20a0, 0)), nil))
+	require.True(match(device.Or(device.USB(0x20a0, 0), device.USB(0x1050, 0)), nil))
+	require.True(match(device.Not(device.USB(0x20a0, 0)), nil))
+
+	_, err := device.ReaderName("(")
+	require.ErrorIs(err, device.ErrInvalidPattern)
+
+	_, err = device.ATR("3b:f")
+	require.ErrorIs(err, device.ErrInvalidPattern)
+
+	_, err = device.ParseUSB("xyz")
+	require.ErrorIs(err, device.ErrInvalidPattern)
+}
+
+func TestNormalizeReaderName(t *testing.T) {
+	require := require.New(t)
+
+	for name, normalized := range map[string]string{
+		"Yubico YubiKey OTP+FIDO+CCID 00 00":     "Yubico YubiKey OTP+FIDO+CCID",
+		"Yubico YubiKey OTP+FIDO+CCID 0":         "Yubico YubiKey OTP+FIDO+CCID",
+		"Nitrokey 3 [CCID/ICCD Interface] 01 00": "Nitrokey 3 [CCID/ICCD Interface]",
+		"Identiv uTrust 3700 F CL Reader 1":      "Identiv uTrust 3700 F CL Reader",
+		"Windows Hello for Business 1":           "Windows Hello for Business",
+		"Nitrokey 3 [CCID/ICCD Interface]":       "Nitrokey 3 [CCID/ICCD Interface]",
+	} {
+		require.Equal(normalized, device.NormalizeReaderName(name), name)
+	}
+}
@@ -0,0 +1,98 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package device
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// usbClassCCID is the USB interface class of smart card readers.
+const usbClassCCID = 0x0b
+
+//nolint:gochecknoglobals
+var sysfsUSBDevices = "/sys/bus/usb/devices"
+
+// usbID looks up the vendor and product ID of a USB device via sysfs.
+func usbID(bus, addr int) (USBID, error) {
+	devs, err := os.ReadDir(sysfsUSBDevices)
+	if err != nil {
+		return USBID{}, err
+	}
+
+	for _, dev := range devs {
+		dir := filepath.Join(sysfsUSBDevices, dev.Name())
+
+		if readInt(dir, "busnum", 10) != bus || readInt(dir, "devnum", 10) != addr {
+			continue
+		}
+
+		return USBID{
+			Vendor:  uint16(readInt(dir, "idVendor", 16)),  //nolint:gosec
+			Product: uint16(readInt(dir, "idProduct", 16)), //nolint:gosec
+		}, nil
+	}
+
+	return USBID{}, os.ErrNotExist
+}
+
+func readInt(dir, name string, base int) int {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return -1
+	}
+
+	i, err := strconv.ParseInt(strings.TrimSpace(string(data)), base, 32)
+	if err != nil {
+		return -1
+	}
+
+	return int(i)
+}
+
+// ccidDevices returns the paths of USB devices with a CCID interface.
+func ccidDevices() (paths []string, err error) {
+	ifaces, err := os.ReadDir(sysfsUSBDevices)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, iface := range ifaces {
+		// Interfaces are named "<device>:<config>.<interface>"
+		dev, _, ok := strings.Cut(iface.Name(), ":")
+		if !ok || readInt(filepath.Join(sysfsUSBDevices, iface.Name()), "bInterfaceClass", 16) != usbClassCCID {
+			continue
+		}
+
+		if path := filepath.Join(sysfsUSBDevices, dev); !slices.Contains(paths, path) {
+			paths = append(paths, path)
+		}
+	}
+
+	return paths, nil
+}
+
+// probeCCID reads the USB IDs of a CCID device from sysfs.
+func probeCCID(path string) (*Device, error) {
+	vendor, product := readInt(path, "idVendor", 16), readInt(path, "idProduct", 16)
+	if vendor < 0 || product < 0 {
+		return nil, os.ErrNotExist
+	}
+
+	return &Device{
+		Transport: TransportUSB,
+		Name:      path,
+		USB: &USBID{
+			Vendor:  uint16(vendor),  //nolint:gosec
+			Product: uint16(product), //nolint:gosec
+		},
+	}, nil
+}
@@ -0,0 +1,21 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux
+
+package device
+
+import "errors"
+
+func usbID(int, int) (USBID, error) {
+	return USBID{}, errors.ErrUnsupported
+}
+
+// ccidDevices finds no devices as USB devices are only enumerated on Linux.
+func ccidDevices() ([]string, error) {
+	return nil, nil
+}
+
+func probeCCID(string) (*Device, error) {
+	return nil, errors.ErrUnsupported
+}
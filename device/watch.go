@@ -0,0 +1,90 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package device
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"cunicu.li/hawkes/event"
+)
+
+// DefaultWatchInterval is the default period between two discoveries of Watch.
+const DefaultWatchInterval = 2 * time.Second
+
+// ID identifies a device by its transport and name, e.g. "pcsc:Yubico YubiKey OTP+FIDO+CCID 00 00".
+func (d *Device) ID() string {
+	return string(d.Transport) + ":" + d.Name
+}
+
+// Watch periodically discovers the devices and publishes
+// event.DeviceAttached and event.DeviceRemoved for changes
+// until ctx is done. The devices present at start are not published.
+//
+// Devices are not considered removed while a transport
+// fails to enumerate them, e.g. as the PC/SC service restarts.
+// DefaultWatchInterval is used if interval is not positive.
+func Watch(ctx context.Context, pub event.Publisher, interval time.Duration, opts *DiscoverOptions) {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+
+	present, _ := discoverIDs(ctx, opts)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		ids, err := discoverIDs(ctx, opts)
+		if err != nil {
+			slog.Debug("Failed to discover devices", slog.Any("error", err))
+		}
+
+		for id := range ids {
+			if _, ok := present[id]; !ok {
+				event.Publish(pub, event.Event{
+					Type:   event.DeviceAttached,
+					Device: id,
+				})
+			}
+		}
+
+		if err != nil {
+			// Keep the previous devices as they might only be missing
+			// due to the failed enumeration
+			for id := range present {
+				ids[id] = struct{}{}
+			}
+		} else {
+			for id := range present {
+				if _, ok := ids[id]; !ok {
+					event.Publish(pub, event.Event{
+						Type:   event.DeviceRemoved,
+						Device: id,
+					})
+				}
+			}
+		}
+
+		present = ids
+	}
+}
+
+func discoverIDs(ctx context.Context, opts *DiscoverOptions) (map[string]struct{}, error) {
+	devs, err := Devices(ctx, opts)
+
+	ids := map[string]struct{}{}
+	for _, d := range devs {
+		ids[d.ID()] = struct{}{}
+	}
+
+	return ids, err
+}
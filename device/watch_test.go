@@ -0,0 +1,94 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package device
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/event"
+)
+
+func TestWatch(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		mu      sync.Mutex
+		readers = []string{"Reader A"}
+		listErr error
+	)
+
+	set := func(r []string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		readers, listErr = r, err
+	}
+
+	orig := transports
+	defer func() { transports = orig }()
+
+	transports = []transport{
+		{
+			name: TransportPCSC,
+			list: func() ([]string, error) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				return readers, listErr
+			},
+			probe: func(name string) (*Device, error) {
+				return &Device{Transport: TransportPCSC, Name: name}, nil
+			},
+		},
+	}
+
+	bus := event.NewBus()
+	sub := bus.Subscribe(0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		Watch(ctx, bus, 10*time.Millisecond, nil)
+		close(done)
+	}()
+
+	next := func() event.Event {
+		select {
+		case e := <-sub.C():
+			return e
+		case <-time.After(time.Second):
+			require.FailNow("no event")
+			return event.Event{}
+		}
+	}
+
+	// Devices present at start are not published
+	time.Sleep(30 * time.Millisecond)
+	set([]string{"Reader A", "Reader B"}, nil)
+
+	e := next()
+	require.Equal(event.DeviceAttached, e.Type)
+	require.Equal("pcsc:Reader B", e.Device)
+
+	// Failed enumerations do not remove devices
+	set(nil, errors.New("service restarted")) //nolint:err113
+	time.Sleep(30 * time.Millisecond)
+	require.Empty(sub.C())
+
+	set([]string{"Reader B"}, nil)
+
+	e = next()
+	require.Equal(event.DeviceRemoved, e.Type)
+	require.Equal("pcsc:Reader A", e.Device)
+
+	cancel()
+	<-done
+}
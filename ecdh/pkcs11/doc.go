@@ -0,0 +1,7 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package pkcs11 provides an ECDH implementation backed by an PKCS11 compatible token or HSM.
+//
+// The package is empty in builds without cgo as the PKCS11 modules are loaded via dlopen.
+package pkcs11
@@ -1,7 +1,8 @@
 // SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
 // SPDX-License-Identifier: Apache-2.0
 
-// Package pkcs11 provides an ECDH implementation backed by an PKCS11 compatible token or HSM.
+//go:build cgo
+
 package pkcs11
 
 // Based on: https://github.com/garnoth/pkclient
@@ -60,8 +60,9 @@ func (dh *DH) ParsePublicKey(data []byte) (dh.PublicKey, error) {
 	}, nil
 }
 
+// Size returns the length of uncompressed public keys.
 func (dh *DH) Size() (l int) {
-	return curveSize(dh.curve) + 1
+	return 2*curveSize(dh.curve) + 1
 }
 
 func curveSize(curve ecdh.Curve) (l int) {
@@ -69,7 +70,7 @@ func curveSize(curve ecdh.Curve) (l int) {
 	case ecdh.P256():
 		l = 256
 	case ecdh.P384():
-		l = 348
+		l = 384
 	case ecdh.P521():
 		l = 521
 	}
@@ -0,0 +1,362 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package envelope implements a versioned format for wrapped keys,
+// sealed secrets and escrow blobs.
+//
+// An envelope is a CBOR map (RFC 8949) in deterministic encoding
+// with the following integer keys:
+//
+//	1: version (unsigned integer, currently 1)
+//	2: type (unsigned integer, see Type)
+//	3: algorithm identifier (text string, see Algorithm)
+//	4: provider hint (map: 1 => provider name, 2 => key ID, 3 => label)
+//	5: algorithm parameters (map of unsigned integers to byte strings, see Param)
+//	6: ciphertext (byte string)
+//
+// Keys from 1 to 63 are reserved for this format and unknown reserved keys
+// are rejected. Keys from 64 on are extensions which are preserved when parsing
+// and re-encoding an envelope. All fields but the ciphertext are authenticated
+// as additional data of the encryption.
+//
+// Envelopes of a newer version are rejected with ErrUnsupportedVersion.
+// Envelopes of earlier versions remain parsable by later releases.
+package envelope
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"cunicu.li/hawkes/internal/cbor"
+)
+
+var (
+	ErrMalformed            = errors.New("malformed envelope")
+	ErrUnsupportedVersion   = errors.New("unsupported envelope version")
+	ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+	ErrUnsupportedKey       = errors.New("unsupported key")
+	ErrDecrypt              = errors.New("failed to decrypt envelope")
+)
+
+// Version is the version of envelopes created by this package.
+const Version = 1
+
+// PEMType is the type of PEM blocks containing an envelope.
+const PEMType = "HAWKES ENVELOPE"
+
+// Type describes the purpose of an envelope.
+type Type int64
+
+const (
+	// TypeWrappedKey is key material wrapped with a key-encryption key.
+	TypeWrappedKey Type = 1
+
+	// TypeSealedSecret is a secret sealed to a key of a provider.
+	TypeSealedSecret Type = 2
+
+	// TypeEscrow is key material encrypted for an escrow agent.
+	TypeEscrow Type = 3
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeWrappedKey:
+		return "wrapped-key"
+	case TypeSealedSecret:
+		return "sealed-secret"
+	case TypeEscrow:
+		return "escrow"
+	default:
+		return fmt.Sprintf("unknown(%d)", int64(t))
+	}
+}
+
+// Param identifies an algorithm parameter.
+type Param int64
+
+const (
+	// ParamEphemeral is the ephemeral public key of ECDH-ES algorithms.
+	ParamEphemeral Param = 1
+
+	// ParamSalt is the challenge of HMAC-based algorithms.
+	ParamSalt Param = 2
+
+	// ParamNonce is the nonce of AEAD ciphers.
+	ParamNonce Param = 3
+)
+
+// Hint helps to find the key which opens an envelope.
+// Hints are authenticated but not confidential.
+type Hint struct {
+	Provider string
+	KeyID    []byte
+	Label    string
+}
+
+// Envelope is a versioned container for encrypted key material.
+type Envelope struct {
+	Version    int64
+	Type       Type
+	Algorithm  Algorithm
+	Hint       *Hint
+	Params     map[Param][]byte
+	Ciphertext []byte
+
+	// Extensions are fields with keys from 64 on.
+	Extensions map[int64]any
+}
+
+const (
+	keyVersion int64 = iota + 1
+	keyType
+	keyAlgorithm
+	keyHint
+	keyParams
+	keyCiphertext
+
+	keyExtensions int64 = 64
+)
+
+const (
+	hintProvider int64 = iota + 1
+	hintKeyID
+	hintLabel
+)
+
+// MarshalBinary encodes the envelope in deterministic CBOR.
+func (e *Envelope) MarshalBinary() ([]byte, error) {
+	m := e.header()
+	m[keyCiphertext] = e.Ciphertext
+
+	return cbor.Marshal(m)
+}
+
+// UnmarshalBinary parses an envelope strictly.
+// Data which is not in deterministic encoding, trailing data,
+// unknown reserved fields and fields of an unexpected type are rejected.
+func (e *Envelope) UnmarshalBinary(data []byte) error {
+	v, rest, err := cbor.Unmarshal(data)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformed, err)
+	} else if len(rest) > 0 {
+		return fmt.Errorf("%w: trailing data", ErrMalformed)
+	}
+
+	// The deterministic encoding is unique
+	if canonical, err := cbor.Marshal(v); err != nil || !bytes.Equal(canonical, data) {
+		return fmt.Errorf("%w: not in deterministic encoding", ErrMalformed)
+	}
+
+	m, ok := v.(map[any]any)
+	if !ok {
+		return fmt.Errorf("%w: not a map", ErrMalformed)
+	}
+
+	*e = Envelope{}
+
+	for k, v := range m {
+		key, ok := k.(int64)
+		if !ok || key < 1 {
+			return fmt.Errorf("%w: invalid key %v", ErrMalformed, k)
+		}
+
+		if err := e.decodeField(key, v); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case e.Version == 0:
+		return fmt.Errorf("%w: missing version", ErrMalformed)
+	case e.Version > Version:
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, e.Version)
+	case e.Type == 0:
+		return fmt.Errorf("%w: missing type", ErrMalformed)
+	case e.Algorithm == "":
+		return fmt.Errorf("%w: missing algorithm", ErrMalformed)
+	case e.Ciphertext == nil:
+		return fmt.Errorf("%w: missing ciphertext", ErrMalformed)
+	}
+
+	return nil
+}
+
+// Parse parses a binary or PEM-encoded envelope.
+func Parse(data []byte) (*Envelope, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != PEMType {
+			return nil, fmt.Errorf("%w: unexpected PEM block %s", ErrMalformed, block.Type)
+		}
+
+		data = block.Bytes
+	}
+
+	e := &Envelope{}
+	if err := e.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// PEM encodes the envelope as PEM block.
+func (e *Envelope) PEM() ([]byte, error) {
+	data, err := e.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  PEMType,
+		Bytes: data,
+	}), nil
+}
+
+// AdditionalData returns the encoding of all fields but the ciphertext
+// which is authenticated by the encryption of the envelope.
+func (e *Envelope) AdditionalData() ([]byte, error) {
+	return cbor.Marshal(e.header())
+}
+
+func (e *Envelope) header() map[any]any {
+	m := map[any]any{
+		keyVersion:   e.Version,
+		keyType:      int64(e.Type),
+		keyAlgorithm: string(e.Algorithm),
+	}
+
+	if h := e.Hint; h != nil {
+		hm := map[any]any{}
+
+		if h.Provider != "" {
+			hm[hintProvider] = h.Provider
+		}
+
+		if h.KeyID != nil {
+			hm[hintKeyID] = h.KeyID
+		}
+
+		if h.Label != "" {
+			hm[hintLabel] = h.Label
+		}
+
+		m[keyHint] = hm
+	}
+
+	if len(e.Params) > 0 {
+		pm := map[any]any{}
+		for p, v := range e.Params {
+			pm[int64(p)] = v
+		}
+
+		m[keyParams] = pm
+	}
+
+	for k, v := range e.Extensions {
+		m[k] = v
+	}
+
+	return m
+}
+
+func (e *Envelope) decodeField(key int64, v any) (err error) {
+	var ok bool
+
+	switch key {
+	case keyVersion:
+		e.Version, ok = v.(int64)
+		ok = ok && e.Version > 0
+
+	case keyType:
+		var t int64
+		t, ok = v.(int64)
+		e.Type = Type(t)
+		ok = ok && t > 0
+
+	case keyAlgorithm:
+		var a string
+		a, ok = v.(string)
+		e.Algorithm = Algorithm(a)
+
+	case keyHint:
+		e.Hint, err = decodeHint(v)
+		return err
+
+	case keyParams:
+		e.Params, err = decodeParams(v)
+		return err
+
+	case keyCiphertext:
+		e.Ciphertext, ok = v.([]byte)
+
+	default:
+		if key < keyExtensions {
+			return fmt.Errorf("%w: unknown field %d", ErrMalformed, key)
+		}
+
+		if e.Extensions == nil {
+			e.Extensions = map[int64]any{}
+		}
+
+		e.Extensions[key] = v
+		ok = true
+	}
+
+	if !ok {
+		return fmt.Errorf("%w: invalid field %d", ErrMalformed, key)
+	}
+
+	return nil
+}
+
+func decodeHint(v any) (*Hint, error) {
+	m, ok := v.(map[any]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: invalid hint", ErrMalformed)
+	}
+
+	h := &Hint{}
+
+	for k, v := range m {
+		switch k {
+		case hintProvider:
+			h.Provider, ok = v.(string)
+		case hintKeyID:
+			h.KeyID, ok = v.([]byte)
+		case hintLabel:
+			h.Label, ok = v.(string)
+		default:
+			ok = false
+		}
+
+		if !ok {
+			return nil, fmt.Errorf("%w: invalid hint field %v", ErrMalformed, k)
+		}
+	}
+
+	return h, nil
+}
+
+func decodeParams(v any) (map[Param][]byte, error) {
+	m, ok := v.(map[any]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: invalid parameters", ErrMalformed)
+	}
+
+	params := map[Param][]byte{}
+
+	for k, v := range m {
+		p, ok := k.(int64)
+		if !ok || p < 1 {
+			return nil, fmt.Errorf("%w: invalid parameter %v", ErrMalformed, k)
+		}
+
+		if params[Param(p)], ok = v.([]byte); !ok {
+			return nil, fmt.Errorf("%w: invalid parameter %d", ErrMalformed, p)
+		}
+	}
+
+	return params, nil
+}
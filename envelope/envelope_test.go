@@ -0,0 +1,208 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package envelope_test
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/ecdh/sw"
+	"cunicu.li/hawkes/envelope"
+	"cunicu.li/hawkes/internal/cbor"
+)
+
+// golden is a version 1 envelope which must remain parsable by all later releases.
+const golden = "a60101020103674132353647434d04a3016466696c650244010203040366676f6c64656e05a1034c3504c412e1a16d0d25abfa6406583066c770de9b7d4dc042ef25e702eebae6c56b9261dff671bd395264d3e7d00c140046969b92cd34998ccc3153203c6652" //nolint:lll
+
+type hmacKey []byte
+
+func (k hmacKey) HMAC(challenge []byte) ([]byte, error) {
+	h := hmac.New(sha256.New, k)
+	h.Write(challenge)
+	return h.Sum(nil), nil
+}
+
+func TestSealTo(t *testing.T) {
+	require := require.New(t)
+
+	sk, err := sw.GeneratePrivateKey(sw.Config{Curve: ecdh.P256()})
+	require.NoError(err)
+
+	e, err := envelope.SealTo(envelope.TypeEscrow, sk.Public(), &envelope.Hint{Label: "escrow"}, []byte("secret"))
+	require.NoError(err)
+
+	p, err := e.PEM()
+	require.NoError(err)
+
+	e2, err := envelope.Parse(p)
+	require.NoError(err)
+	require.Equal(e, e2)
+
+	pt, err := e2.Open(sk)
+	require.NoError(err)
+	require.Equal([]byte("secret"), pt)
+
+	other, err := sw.GeneratePrivateKey(sw.Config{Curve: ecdh.P256()})
+	require.NoError(err)
+
+	_, err = e2.Open(other)
+	require.ErrorIs(err, envelope.ErrDecrypt)
+
+	_, err = e2.Open(hmacKey("key"))
+	require.ErrorIs(err, envelope.ErrUnsupportedKey)
+}
+
+func TestSealHMAC(t *testing.T) {
+	require := require.New(t)
+
+	e, err := envelope.SealHMAC(envelope.TypeSealedSecret, hmacKey("key"), nil, []byte("secret"))
+	require.NoError(err)
+
+	pt, err := e.Open(hmacKey("key"))
+	require.NoError(err)
+	require.Equal([]byte("secret"), pt)
+
+	_, err = e.Open(hmacKey("other"))
+	require.ErrorIs(err, envelope.ErrDecrypt)
+
+	// The header is authenticated
+	e.Type = envelope.TypeEscrow
+
+	_, err = e.Open(hmacKey("key"))
+	require.ErrorIs(err, envelope.ErrDecrypt)
+}
+
+func TestGolden(t *testing.T) {
+	require := require.New(t)
+
+	data, err := hex.DecodeString(golden)
+	require.NoError(err)
+
+	e, err := envelope.Parse(data)
+	require.NoError(err)
+	require.Equal(int64(1), e.Version)
+	require.Equal(envelope.TypeWrappedKey, e.Type)
+	require.Equal(envelope.AlgA256GCM, e.Algorithm)
+	require.Equal(&envelope.Hint{Provider: "file", KeyID: []byte{1, 2, 3, 4}, Label: "golden"}, e.Hint)
+
+	key, err := e.Open(bytes.Repeat([]byte{0x42}, 32))
+	require.NoError(err)
+	require.Equal([]byte("0123456789abcdef0123456789abcdef"), key)
+
+	data2, err := e.MarshalBinary()
+	require.NoError(err)
+	require.Equal(data, data2)
+}
+
+func TestExtensions(t *testing.T) {
+	require := require.New(t)
+
+	kek := bytes.Repeat([]byte{0x42}, 32)
+
+	e, err := envelope.Wrap(kek, nil, []byte("key"))
+	require.NoError(err)
+
+	e.Extensions = map[int64]any{
+		64: "added by a later release",
+	}
+
+	data, err := e.MarshalBinary()
+	require.NoError(err)
+
+	e2, err := envelope.Parse(data)
+	require.NoError(err)
+	require.Equal(e.Extensions, e2.Extensions)
+
+	// Extensions are authenticated as well
+	_, err = e2.Open(kek)
+	require.ErrorIs(err, envelope.ErrDecrypt)
+}
+
+func TestStrictParsing(t *testing.T) {
+	data, err := hex.DecodeString(golden)
+	require.NoError(t, err)
+
+	encode := func(m map[any]any) []byte {
+		b, err := cbor.Marshal(m)
+		require.NoError(t, err)
+
+		return b
+	}
+
+	valid := map[any]any{
+		int64(1): int64(1),
+		int64(2): int64(1),
+		int64(3): "A256GCM",
+		int64(6): []byte{},
+	}
+
+	with := func(k int64, v any) []byte {
+		m := map[any]any{}
+		for k, v := range valid {
+			m[k] = v
+		}
+
+		if v == nil {
+			delete(m, k)
+		} else {
+			m[k] = v
+		}
+
+		return encode(m)
+	}
+
+	for name, tc := range map[string]struct {
+		data []byte
+		err  error
+	}{
+		"valid":              {encode(valid), nil},
+		"trailing data":      {append(bytes.Clone(data), 0x00), envelope.ErrMalformed},
+		"non-deterministic":  {append([]byte{0xa6, 0x01, 0x18, 0x01}, data[3:]...), envelope.ErrMalformed},
+		"not a map":          {encode(nil), envelope.ErrMalformed},
+		"newer version":      {with(1, int64(2)), envelope.ErrUnsupportedVersion},
+		"missing version":    {with(1, nil), envelope.ErrMalformed},
+		"missing ciphertext": {with(6, nil), envelope.ErrMalformed},
+		"unknown field":      {with(7, []byte{}), envelope.ErrMalformed},
+		"invalid type":       {with(2, "wrapped-key"), envelope.ErrMalformed},
+		"invalid hint":       {with(4, map[any]any{int64(4): "unknown"}), envelope.ErrMalformed},
+		"invalid params":     {with(5, map[any]any{int64(1): "string"}), envelope.ErrMalformed},
+		"extension":          {with(100, []any{int64(1)}), nil},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := envelope.Parse(tc.data)
+			if tc.err == nil {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestUnsupportedAlgorithm(t *testing.T) {
+	require := require.New(t)
+
+	e := &envelope.Envelope{
+		Version:    envelope.Version,
+		Type:       envelope.TypeWrappedKey,
+		Algorithm:  "ML-KEM-768+A256GCM",
+		Ciphertext: []byte{},
+	}
+
+	data, err := e.MarshalBinary()
+	require.NoError(err)
+
+	// Unknown algorithms can be parsed but not opened
+	e, err = envelope.Parse(data)
+	require.NoError(err)
+
+	_, err = e.Open(nil)
+	require.ErrorIs(err, envelope.ErrUnsupportedAlgorithm)
+}
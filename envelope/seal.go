@@ -0,0 +1,263 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	"github.com/katzenpost/nyquist/dh"
+	"golang.org/x/crypto/hkdf"
+
+	"cunicu.li/hawkes/ecdh"
+	"cunicu.li/hawkes/ecdh/sw"
+	"cunicu.li/hawkes/secret"
+)
+
+// Algorithm identifies how the content-encryption key of an envelope is derived.
+// The content is always encrypted with AES-256-GCM.
+type Algorithm string
+
+const (
+	// AlgECDHESP256 derives the key from an ECDH key agreement between an ephemeral
+	// and the recipient key on the P-256 curve with HKDF-SHA256.
+	AlgECDHESP256 Algorithm = "ECDH-ES-P256+HKDF-SHA256+A256GCM"
+
+	// AlgHMACSHA256 derives the key from the HMAC of a random salt
+	// calculated by a provider key with HKDF-SHA256.
+	AlgHMACSHA256 Algorithm = "HMAC-SHA256+HKDF-SHA256+A256GCM"
+
+	// AlgA256GCM uses a 256-bit key-encryption key directly.
+	AlgA256GCM Algorithm = "A256GCM"
+)
+
+// HMACKey is a key which calculates HMACs, e.g. a provider.PrivateKeyHMAC.
+type HMACKey interface {
+	HMAC(challenge []byte) ([]byte, error)
+}
+
+// deriveFunc derives the content-encryption key of an envelope.
+type deriveFunc func(e *Envelope, key any) ([]byte, error)
+
+//nolint:gochecknoglobals
+var algorithms = map[Algorithm]deriveFunc{
+	AlgECDHESP256: deriveECDHES,
+	AlgHMACSHA256: deriveHMAC,
+	AlgA256GCM:    deriveDirect,
+}
+
+const (
+	keySize   = 32
+	nonceSize = 12
+	saltSize  = 32
+)
+
+// SealTo encrypts plaintext for the holder of the private key of a P-256 public key,
+// e.g. a key of a provider or of an escrow agent.
+func SealTo(typ Type, pub dh.PublicKey, hint *Hint, plaintext []byte) (*Envelope, error) {
+	if _, err := sw.P256.ParsePublicKey(pub.Bytes()); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsupportedKey, err)
+	}
+
+	ephemeral, err := sw.P256.GenerateKeypair(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	ss, err := ephemeral.DH(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	defer secret.Wipe(ss)
+
+	e := newEnvelope(typ, AlgECDHESP256, hint)
+	e.Params[ParamEphemeral] = ephemeral.Public().Bytes()
+
+	cek, err := expand(e, ss, append(e.Params[ParamEphemeral], pub.Bytes()...))
+	if err != nil {
+		return nil, err
+	}
+
+	return e, e.seal(cek, plaintext)
+}
+
+// SealHMAC encrypts plaintext with a key derived from the HMAC of a random salt.
+// Only the same HMAC key can open the envelope.
+func SealHMAC(typ Type, key HMACKey, hint *Hint, plaintext []byte) (*Envelope, error) {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	e := newEnvelope(typ, AlgHMACSHA256, hint)
+	e.Params[ParamSalt] = salt
+
+	cek, err := deriveHMAC(e, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return e, e.seal(cek, plaintext)
+}
+
+// Wrap encrypts key material with a 256-bit key-encryption key.
+func Wrap(kek []byte, hint *Hint, key []byte) (*Envelope, error) {
+	e := newEnvelope(TypeWrappedKey, AlgA256GCM, hint)
+
+	cek, err := deriveDirect(e, kek)
+	if err != nil {
+		return nil, err
+	}
+
+	return e, e.seal(cek, key)
+}
+
+// Open decrypts the envelope with the key required by its algorithm:
+// an ecdh.PrivateKey for AlgECDHESP256, an HMACKey for AlgHMACSHA256
+// and the key-encryption key as byte slice for AlgA256GCM.
+func (e *Envelope) Open(key any) ([]byte, error) {
+	derive, ok := algorithms[e.Algorithm]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, e.Algorithm)
+	}
+
+	cek, err := derive(e, key)
+	if err != nil {
+		return nil, err
+	}
+
+	defer secret.Wipe(cek)
+
+	aead, err := newAEAD(cek)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := e.Params[ParamNonce]
+	if len(nonce) != nonceSize {
+		return nil, fmt.Errorf("%w: invalid nonce", ErrMalformed)
+	}
+
+	aad, err := e.AdditionalData()
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := aead.Open(nil, nonce, e.Ciphertext, aad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	return plaintext, nil
+}
+
+func newEnvelope(typ Type, alg Algorithm, hint *Hint) *Envelope {
+	return &Envelope{
+		Version:   Version,
+		Type:      typ,
+		Algorithm: alg,
+		Hint:      hint,
+		Params:    map[Param][]byte{},
+	}
+}
+
+func (e *Envelope) seal(cek, plaintext []byte) error {
+	defer secret.Wipe(cek)
+
+	aead, err := newAEAD(cek)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, nonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+
+	e.Params[ParamNonce] = nonce
+
+	aad, err := e.AdditionalData()
+	if err != nil {
+		return err
+	}
+
+	e.Ciphertext = aead.Seal(nil, nonce, plaintext, aad)
+
+	return nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// expand derives the content-encryption key bound to the algorithm of the envelope.
+func expand(e *Envelope, ikm, salt []byte) ([]byte, error) {
+	cek := make([]byte, keySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, ikm, salt, []byte("hawkes envelope "+e.Algorithm)), cek); err != nil {
+		return nil, err
+	}
+
+	return cek, nil
+}
+
+func deriveECDHES(e *Envelope, key any) ([]byte, error) {
+	sk, ok := key.(ecdh.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T is not a Diffie-Hellman key", ErrUnsupportedKey, key)
+	}
+
+	ephemeral, err := sw.P256.ParsePublicKey(e.Params[ParamEphemeral])
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid ephemeral key: %w", ErrMalformed, err)
+	}
+
+	ss, err := sk.DH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+
+	defer secret.Wipe(ss)
+
+	return expand(e, ss, append(ephemeral.Bytes(), sk.Public().Bytes()...))
+}
+
+func deriveHMAC(e *Envelope, key any) ([]byte, error) {
+	hk, ok := key.(HMACKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T is not a HMAC key", ErrUnsupportedKey, key)
+	}
+
+	salt := e.Params[ParamSalt]
+	if len(salt) != saltSize {
+		return nil, fmt.Errorf("%w: invalid salt", ErrMalformed)
+	}
+
+	mac, err := hk.HMAC(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	defer secret.Wipe(mac)
+
+	return expand(e, mac, nil)
+}
+
+func deriveDirect(_ *Envelope, key any) ([]byte, error) {
+	kek, ok := key.([]byte)
+	if !ok || len(kek) != keySize {
+		return nil, fmt.Errorf("%w: requires a %d byte key-encryption key", ErrUnsupportedKey, keySize)
+	}
+
+	return append([]byte{}, kek...), nil
+}
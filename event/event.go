@@ -0,0 +1,181 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package event distributes security-relevant notifications like
+// removed tokens or failed PIN attempts to subscribers so that
+// applications can alert users without scraping logs.
+package event
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBufferSize is the number of events buffered per subscription if none is given.
+const DefaultBufferSize = 16
+
+// Type identifies the kind of an event.
+type Type string
+
+const (
+	// DeviceAttached is published when a token or reader appears.
+	DeviceAttached Type = "device_attached"
+
+	// DeviceRemoved is published when a token or reader disappears.
+	DeviceRemoved Type = "device_removed"
+
+	// PINFailed is published after a wrong PIN or password has been presented.
+	PINFailed Type = "pin_failed"
+
+	// LockoutImminent is published before a PIN attempt when few attempts are left.
+	LockoutImminent Type = "lockout_imminent"
+
+	// TouchTimeout is published when a token has not been touched in time.
+	TouchTimeout Type = "touch_timeout"
+
+	// KeyRotated is published by applications after a key has been replaced.
+	KeyRotated Type = "key_rotated"
+
+	// AttestationMismatch is published when a key is refused as its attestation violates a policy.
+	AttestationMismatch Type = "attestation_mismatch"
+)
+
+// Event is a structured notification.
+// Fields which do not apply to the type of the event are empty.
+type Event struct {
+	Type Type      `json:"type"`
+	Time time.Time `json:"time"`
+
+	// Provider is the name of the provider concerned.
+	Provider string `json:"provider,omitempty"`
+
+	// Device is the transport and name of the device concerned, e.g. "pcsc:Yubico YubiKey".
+	Device string `json:"device,omitempty"`
+
+	// Key is the ID or reference of the key concerned.
+	Key string `json:"key,omitempty"`
+
+	// Remaining is the number of PIN attempts left.
+	Remaining int `json:"remaining,omitempty"`
+
+	// Error describes the failure which caused the event.
+	Error string `json:"error,omitempty"`
+}
+
+// Publisher receives events.
+// Implementations must be safe for concurrent use and must not block.
+type Publisher interface {
+	Publish(e Event)
+}
+
+// Func adapts a function to the Publisher interface.
+type Func func(e Event)
+
+func (f Func) Publish(e Event) {
+	f(e)
+}
+
+// Publish sends an event to p if it is not nil.
+// The time of the event is set if it is zero.
+func Publish(p Publisher, e Event) {
+	if p == nil {
+		return
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	p.Publish(e)
+}
+
+// Bus distributes published events to its subscriptions.
+// It is safe for concurrent use.
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+// NewBus creates a bus without subscriptions.
+func NewBus() *Bus {
+	return &Bus{
+		subs: map[*Subscription]struct{}{},
+	}
+}
+
+// Publish delivers an event to all subscriptions of its type.
+// It never blocks: events are dropped for subscriptions whose buffer is full.
+func (b *Bus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for s := range b.subs {
+		if len(s.types) > 0 && !slices.Contains(s.types, e.Type) {
+			continue
+		}
+
+		select {
+		case s.c <- e:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// Subscribe creates a subscription to the events of the given types
+// or all events if none are given.
+// Up to size events are buffered. DefaultBufferSize is used if size is not positive.
+func (b *Bus) Subscribe(size int, types ...Type) *Subscription {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+
+	s := &Subscription{
+		bus:   b,
+		c:     make(chan Event, size),
+		types: types,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.subs[s] = struct{}{}
+
+	return s
+}
+
+// Subscription receives the events of a bus.
+type Subscription struct {
+	bus     *Bus
+	c       chan Event
+	types   []Type
+	dropped atomic.Uint64
+}
+
+// C returns the channel of events which is closed by Close.
+func (s *Subscription) C() <-chan Event {
+	return s.c
+}
+
+// Dropped returns the number of events which have been dropped
+// as the subscriber did not keep up.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close removes the subscription from the bus and closes its channel.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	if _, ok := s.bus.subs[s]; ok {
+		delete(s.bus.subs, s)
+		close(s.c)
+	}
+}
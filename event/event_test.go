@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package event_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/event"
+)
+
+func TestBus(t *testing.T) {
+	require := require.New(t)
+
+	bus := event.NewBus()
+
+	all := bus.Subscribe(0)
+	pins := bus.Subscribe(1, event.PINFailed, event.LockoutImminent)
+
+	bus.Publish(event.Event{Type: event.DeviceAttached, Device: "pcsc:Yubico YubiKey"})
+	bus.Publish(event.Event{Type: event.PINFailed, Provider: "YKOATH", Remaining: 2})
+
+	e := <-all.C()
+	require.Equal(event.DeviceAttached, e.Type)
+	require.False(e.Time.IsZero())
+
+	e = <-all.C()
+	require.Equal(event.PINFailed, e.Type)
+
+	e = <-pins.C()
+	require.Equal(event.PINFailed, e.Type)
+	require.Equal(2, e.Remaining)
+
+	// Slow subscribers do not block publishers
+	bus.Publish(event.Event{Type: event.LockoutImminent})
+	bus.Publish(event.Event{Type: event.LockoutImminent})
+	require.Equal(uint64(1), pins.Dropped())
+	require.Equal(uint64(0), all.Dropped())
+
+	pins.Close()
+	pins.Close()
+
+	e, ok := <-pins.C()
+	require.True(ok)
+	require.Equal(event.LockoutImminent, e.Type)
+
+	_, ok = <-pins.C()
+	require.False(ok)
+
+	// Closed subscriptions receive no further events
+	bus.Publish(event.Event{Type: event.LockoutImminent})
+	require.Len(all.C(), 3)
+}
+
+func TestPublish(t *testing.T) {
+	require := require.New(t)
+
+	event.Publish(nil, event.Event{Type: event.KeyRotated})
+
+	var events []event.Event
+
+	event.Publish(event.Func(func(e event.Event) {
+		events = append(events, e)
+	}), event.Event{Type: event.KeyRotated, Key: "File:abc"})
+
+	require.Len(events, 1)
+	require.Equal("File:abc", events[0].Key)
+	require.False(events[0].Time.IsZero())
+}
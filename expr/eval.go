@@ -0,0 +1,581 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package expr
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type node interface {
+	eval(vars map[string]any) (any, error)
+}
+
+type arity struct {
+	args   int
+	method bool
+}
+
+// functions lists the supported functions and methods by their arity including the receiver.
+//
+//nolint:gochecknoglobals
+var functions = map[string]arity{
+	"size":       {1, false},
+	"int":        {1, false},
+	"string":     {1, false},
+	"has":        {1, false},
+	"startsWith": {2, true},
+	"endsWith":   {2, true},
+	"contains":   {2, true},
+	"matches":    {2, true},
+}
+
+type literalNode struct {
+	val any
+}
+
+func (n *literalNode) eval(map[string]any) (any, error) {
+	return n.val, nil
+}
+
+type identNode struct {
+	name string
+}
+
+func (n *identNode) eval(vars map[string]any) (any, error) {
+	v, ok := vars[n.name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknown, n.name)
+	}
+
+	return normalize(v), nil
+}
+
+type listNode struct {
+	elems []node
+}
+
+func (n *listNode) eval(vars map[string]any) (any, error) {
+	l := make([]any, 0, len(n.elems))
+
+	for _, e := range n.elems {
+		v, err := e.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+
+		l = append(l, v)
+	}
+
+	return l, nil
+}
+
+type selectNode struct {
+	x    node
+	name string
+}
+
+func (n *selectNode) eval(vars map[string]any) (any, error) {
+	v, ok, err := n.lookup(vars)
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchKey, n.name)
+	}
+
+	return v, nil
+}
+
+func (n *selectNode) lookup(vars map[string]any) (any, bool, error) {
+	x, err := n.x.eval(vars)
+	if err != nil {
+		return nil, false, err
+	}
+
+	m, ok := x.(map[string]any)
+	if !ok {
+		return nil, false, fmt.Errorf("%w: can not select field %s of %s", ErrType, n.name, typeName(x))
+	}
+
+	v, ok := m[n.name]
+
+	return normalize(v), ok, nil
+}
+
+type hasNode struct {
+	sel *selectNode
+}
+
+func (n *hasNode) eval(vars map[string]any) (any, error) {
+	_, ok, err := n.sel.lookup(vars)
+	return ok, err
+}
+
+type indexNode struct {
+	x, i node
+}
+
+func (n *indexNode) eval(vars map[string]any) (any, error) {
+	x, err := n.x.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	i, err := n.i.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch x := x.(type) {
+	case []any:
+		idx, ok := i.(int64)
+		if !ok {
+			return nil, fmt.Errorf("%w: list index must be int instead of %s", ErrType, typeName(i))
+		} else if idx < 0 || idx >= int64(len(x)) {
+			return nil, fmt.Errorf("%w: index %d out of range", ErrNoSuchKey, idx)
+		}
+
+		return x[idx], nil
+
+	case map[string]any:
+		key, ok := i.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: map key must be string instead of %s", ErrType, typeName(i))
+		}
+
+		v, ok := x[key]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrNoSuchKey, key)
+		}
+
+		return normalize(v), nil
+	}
+
+	return nil, fmt.Errorf("%w: can not index %s", ErrType, typeName(x))
+}
+
+type unaryNode struct {
+	op string
+	x  node
+}
+
+func (n *unaryNode) eval(vars map[string]any) (any, error) {
+	x, err := n.x.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch x := x.(type) {
+	case bool:
+		if n.op == "!" {
+			return !x, nil
+		}
+
+	case int64:
+		if n.op == "-" {
+			return -x, nil
+		}
+
+	case float64:
+		if n.op == "-" {
+			return -x, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: operator %s is not defined for %s", ErrType, n.op, typeName(x))
+}
+
+type condNode struct {
+	c, t, f node
+}
+
+func (n *condNode) eval(vars map[string]any) (any, error) {
+	c, err := evalBool(n.c, vars, "?:")
+	if err != nil {
+		return nil, err
+	}
+
+	if c {
+		return n.t.eval(vars)
+	}
+
+	return n.f.eval(vars)
+}
+
+type binaryNode struct {
+	op   string
+	l, r node
+}
+
+func (n *binaryNode) eval(vars map[string]any) (any, error) {
+	// Logical operators short-circuit
+	if n.op == "&&" || n.op == "||" {
+		l, err := evalBool(n.l, vars, n.op)
+		if err != nil {
+			return nil, err
+		}
+
+		if l == (n.op == "||") {
+			return l, nil
+		}
+
+		return evalBool(n.r, vars, n.op)
+	}
+
+	l, err := n.l.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := n.r.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return equal(l, r), nil
+
+	case "!=":
+		return !equal(l, r), nil
+
+	case "in":
+		switch r := r.(type) {
+		case []any:
+			for _, e := range r {
+				if equal(l, e) {
+					return true, nil
+				}
+			}
+
+			return false, nil
+
+		case map[string]any:
+			key, ok := l.(string)
+			if !ok {
+				return false, nil
+			}
+
+			_, ok = r[key]
+
+			return ok, nil
+		}
+
+	case "<", "<=", ">", ">=":
+		if c, ok := compare(l, r); ok {
+			switch n.op {
+			case "<":
+				return c < 0, nil
+			case "<=":
+				return c <= 0, nil
+			case ">":
+				return c > 0, nil
+			default:
+				return c >= 0, nil
+			}
+		}
+
+	default:
+		return arithmetic(n.op, l, r)
+	}
+
+	return nil, fmt.Errorf("%w: operator %s is not defined for %s and %s", ErrType, n.op, typeName(l), typeName(r))
+}
+
+func arithmetic(op string, l, r any) (any, error) {
+	switch l := l.(type) {
+	case int64:
+		r, ok := r.(int64)
+		if !ok {
+			break
+		}
+
+		switch op {
+		case "+":
+			return l + r, nil
+		case "-":
+			return l - r, nil
+		case "*":
+			return l * r, nil
+		case "/", "%":
+			if r == 0 {
+				return nil, ErrDivisionByZero
+			} else if op == "/" {
+				return l / r, nil
+			}
+
+			return l % r, nil
+		}
+
+	case float64:
+		r, ok := r.(float64)
+		if !ok {
+			break
+		}
+
+		switch op {
+		case "+":
+			return l + r, nil
+		case "-":
+			return l - r, nil
+		case "*":
+			return l * r, nil
+		case "/":
+			return l / r, nil
+		}
+
+	case string:
+		if r, ok := r.(string); ok && op == "+" {
+			return l + r, nil
+		}
+
+	case []any:
+		if r, ok := r.([]any); ok && op == "+" {
+			return append(append([]any{}, l...), r...), nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: operator %s is not defined for %s and %s", ErrType, op, typeName(l), typeName(r))
+}
+
+type matchNode struct {
+	x  node
+	re *regexp.Regexp
+}
+
+func (n *matchNode) eval(vars map[string]any) (any, error) {
+	x, err := n.x.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	s, ok := x.(string)
+	if !ok {
+		return nil, fmt.Errorf("%w: matches is not defined for %s", ErrType, typeName(x))
+	}
+
+	return n.re.MatchString(s), nil
+}
+
+type callNode struct {
+	name string
+	args []node
+}
+
+func (n *callNode) eval(vars map[string]any) (any, error) {
+	args := make([]any, 0, len(n.args))
+
+	for _, a := range n.args {
+		v, err := a.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, v)
+	}
+
+	switch n.name {
+	case "size":
+		switch x := args[0].(type) {
+		case string:
+			return int64(utf8.RuneCountInString(x)), nil
+		case []any:
+			return int64(len(x)), nil
+		case map[string]any:
+			return int64(len(x)), nil
+		}
+
+	case "int":
+		switch x := args[0].(type) {
+		case int64:
+			return x, nil
+		case float64:
+			return int64(x), nil
+		case string:
+			i, err := strconv.ParseInt(x, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrType, err)
+			}
+
+			return i, nil
+		}
+
+	case "string":
+		switch x := args[0].(type) {
+		case string:
+			return x, nil
+		case int64:
+			return strconv.FormatInt(x, 10), nil
+		case float64:
+			return strconv.FormatFloat(x, 'g', -1, 64), nil
+		case bool:
+			return strconv.FormatBool(x), nil
+		}
+
+	case "startsWith", "endsWith", "contains", "matches":
+		s, ok1 := args[0].(string)
+		t, ok2 := args[1].(string)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("%w: %s is not defined for %s and %s", ErrType, n.name, typeName(args[0]), typeName(args[1]))
+		}
+
+		switch n.name {
+		case "startsWith":
+			return strings.HasPrefix(s, t), nil
+		case "endsWith":
+			return strings.HasSuffix(s, t), nil
+		case "contains":
+			return strings.Contains(s, t), nil
+		default:
+			re, err := regexp.Compile(t)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid pattern: %w", ErrType, err)
+			}
+
+			return re.MatchString(s), nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s is not defined for %s", ErrType, n.name, typeName(args[0]))
+}
+
+func evalBool(n node, vars map[string]any, op string) (bool, error) {
+	v, err := n.eval(vars)
+	if err != nil {
+		return false, err
+	}
+
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%w: operator %s expects bool instead of %s", ErrType, op, typeName(v))
+	}
+
+	return b, nil
+}
+
+// equal compares two values. Integers and doubles are compared numerically.
+func equal(l, r any) bool {
+	if c, ok := compare(l, r); ok {
+		return c == 0
+	}
+
+	return reflect.DeepEqual(l, r)
+}
+
+// compare orders numbers and strings.
+func compare(l, r any) (int, bool) {
+	switch l := l.(type) {
+	case int64:
+		switch r := r.(type) {
+		case int64:
+			return cmp(l, r), true
+		case float64:
+			return cmp(float64(l), r), true
+		}
+
+	case float64:
+		switch r := r.(type) {
+		case int64:
+			return cmp(l, float64(r)), true
+		case float64:
+			return cmp(l, r), true
+		}
+
+	case string:
+		if r, ok := r.(string); ok {
+			return strings.Compare(l, r), true
+		}
+	}
+
+	return 0, false
+}
+
+func cmp[T int64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// normalize converts the values of variables into the types used by the evaluation.
+func normalize(v any) any {
+	switch v := v.(type) {
+	case nil, bool, int64, float64, string, map[string]any:
+		return v
+	}
+
+	rv := reflect.ValueOf(v)
+
+	switch rv.Kind() { //nolint:exhaustive
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(rv.Uint()) //nolint:gosec
+
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+
+	case reflect.String:
+		return rv.String()
+
+	case reflect.Bool:
+		return rv.Bool()
+
+	case reflect.Slice, reflect.Array:
+		l := make([]any, 0, rv.Len())
+		for i := range rv.Len() {
+			l = append(l, normalize(rv.Index(i).Interface()))
+		}
+
+		return l
+
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			break
+		}
+
+		m := make(map[string]any, rv.Len())
+		for iter := rv.MapRange(); iter.Next(); {
+			m[iter.Key().String()] = iter.Value().Interface()
+		}
+
+		return m
+	}
+
+	if s, ok := v.(fmt.Stringer); ok {
+		return s.String()
+	}
+
+	return v
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case int64:
+		return "int"
+	case float64:
+		return "double"
+	case string:
+		return "string"
+	case []any:
+		return "list"
+	case map[string]any:
+		return "map"
+	}
+
+	return fmt.Sprintf("%T", v)
+}
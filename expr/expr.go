@@ -0,0 +1,136 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package expr evaluates policy expressions written in a subset
+// of the Common Expression Language (CEL).
+//
+// Supported are literals of type int, double, string, bool, null and lists,
+// the operators ?:, ||, &&, !, ==, !=, <, <=, >, >=, in, +, -, *, / and %,
+// field selection and indexing of maps and lists,
+// the functions size(), int(), string() and has() as well as
+// the string methods startsWith(), endsWith(), contains() and matches().
+//
+// Like CEL, expressions are side-effect free and always terminate.
+// Their length and nesting depth are limited (see MaxLength and MaxDepth).
+// It does not depend on the CEL libraries.
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+var (
+	ErrSyntax         = errors.New("syntax error")
+	ErrUnknown        = errors.New("unknown identifier")
+	ErrType           = errors.New("type mismatch")
+	ErrNoSuchKey      = errors.New("no such key")
+	ErrDivisionByZero = errors.New("division by zero")
+	ErrLimit          = errors.New("expression exceeds limits")
+)
+
+// Limits of expressions which protect the parser and the
+// evaluation against excessive resource usage.
+const (
+	// MaxLength is the maximum length of an expression in bytes.
+	MaxLength = 4096
+
+	// MaxDepth is the maximum nesting depth of parentheses,
+	// lists, indices, function arguments and unary operators.
+	MaxDepth = 32
+)
+
+// Program is a compiled expression.
+// It is safe for concurrent use.
+type Program struct {
+	src    string
+	root   node
+	idents []string
+}
+
+// Compile parses an expression.
+// Expressions exceeding MaxLength or MaxDepth are rejected with ErrLimit.
+func Compile(src string) (*Program, error) {
+	if len(src) > MaxLength {
+		return nil, fmt.Errorf("%w: length of %d bytes exceeds %d", ErrLimit, len(src), MaxLength)
+	}
+
+	p := &parser{
+		lex: &lexer{src: src},
+	}
+
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	root, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind != tokEOF {
+		return nil, p.unexpected()
+	}
+
+	slices.Sort(p.idents)
+
+	return &Program{
+		src:    src,
+		root:   root,
+		idents: slices.Compact(p.idents),
+	}, nil
+}
+
+// String returns the source of the expression.
+func (p *Program) String() string {
+	return p.src
+}
+
+// Uses returns true if the expression refers to the variable name.
+// It allows callers to skip the collection of costly variables.
+func (p *Program) Uses(name string) bool {
+	_, found := slices.BinarySearch(p.idents, name)
+	return found
+}
+
+// Eval evaluates the expression with the given variables.
+//
+// Integers are returned as int64, floating point numbers as float64,
+// lists as []any and maps as map[string]any.
+// Variables may use any integer, float, slice or map type with string keys.
+func (p *Program) Eval(vars map[string]any) (any, error) {
+	return p.root.eval(vars)
+}
+
+// Bool evaluates an expression which must result in a boolean.
+func (p *Program) Bool(vars map[string]any) (bool, error) {
+	v, err := p.Eval(vars)
+	if err != nil {
+		return false, err
+	}
+
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%w: expression results in %s instead of bool", ErrType, typeName(v))
+	}
+
+	return b, nil
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (p *Program) MarshalText() ([]byte, error) {
+	return []byte(p.src), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (p *Program) UnmarshalText(text []byte) error {
+	q, err := Compile(string(text))
+	if err != nil {
+		return err
+	}
+
+	*p = *q
+
+	return nil
+}
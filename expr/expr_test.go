@@ -0,0 +1,181 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package expr_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/expr"
+)
+
+func TestEval(t *testing.T) {
+	vars := map[string]any{
+		"operation": "dh",
+		"peer":      "peer-a",
+		"peers":     []string{"peer-a", "peer-b"},
+		"time": map[string]int{
+			"hour":    22,
+			"weekday": 6,
+		},
+		"attestation": map[string]any{
+			"model":    "Yubico YubiKey OTP+FIDO+CCID",
+			"firmware": "5.7.1",
+		},
+	}
+
+	for src, expected := range map[string]any{
+		`1 + 2 * 3`:                              int64(7),
+		`(1 + 2) * 3`:                            int64(9),
+		`-7 / 2`:                                 int64(-3),
+		`7 % 3`:                                  int64(1),
+		`1.5 * 2.0`:                              3.0,
+		`1 < 1.5`:                                true,
+		`"a" + 'b'`:                              "ab",
+		`"esc\"aped\n"`:                          "esc\"aped\n",
+		`size("äbc")`:                            int64(3),
+		`[1, 2] + [3]`:                           []any{int64(1), int64(2), int64(3)},
+		`[1, 2, 3][1]`:                           int64(2),
+		`int("42")`:                              int64(42),
+		`string(42)`:                             "42",
+		`null == null`:                           true,
+		`operation == "dh" && peer in peers`:     true,
+		`operation != "dh" || peer == "peer-c"`:  false,
+		`!(time.hour >= 8 && time.hour < 18)`:    true,
+		`time.weekday in [0, 6]`:                 true,
+		`time["hour"] > 20 ? "late" : "early"`:   "late",
+		`"hour" in time`:                         true,
+		`peers.size() == 2`:                      true,
+		`attestation.model.startsWith("Yubico")`: true,
+		`attestation.model.contains("FIDO")`:     true,
+		`attestation.firmware.matches("^5\\.")`:  true,
+		`attestation.model.endsWith("CCID")`:     true,
+		`has(attestation.model)`:                 true,
+		`has(attestation.touch_policy)`:          false,
+		// Short-circuiting skips the evaluation of invalid operands
+		`false && unknown`: false,
+		`true || 1 / 0`:    true,
+	} {
+		p, err := expr.Compile(src)
+		require.NoError(t, err, src)
+
+		v, err := p.Eval(vars)
+		require.NoError(t, err, src)
+		require.Equal(t, expected, v, src)
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	vars := map[string]any{
+		"m": map[string]any{},
+		"l": []int{1},
+	}
+
+	for src, expected := range map[string]error{
+		`unknown`:        expr.ErrUnknown,
+		`m.missing`:      expr.ErrNoSuchKey,
+		`m["missing"]`:   expr.ErrNoSuchKey,
+		`l[1]`:           expr.ErrNoSuchKey,
+		`1 / 0`:          expr.ErrDivisionByZero,
+		`1 + "a"`:        expr.ErrType,
+		`1 && true`:      expr.ErrType,
+		`!1`:             expr.ErrType,
+		`l.field`:        expr.ErrType,
+		`"a" < 1`:        expr.ErrType,
+		`size(1)`:        expr.ErrType,
+		`"a".matches(l)`: expr.ErrType,
+	} {
+		p, err := expr.Compile(src)
+		require.NoError(t, err, src)
+
+		_, err = p.Eval(vars)
+		require.ErrorIs(t, err, expected, src)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, src := range []string{
+		``,
+		`1 +`,
+		`(1`,
+		`[1, 2`,
+		`"unterminated`,
+		`"\x"`,
+		`a ? b`,
+		`a b`,
+		`in`,
+		`unknown(1)`,
+		`size(1, 2)`,
+		`"a".has()`,
+		`startsWith("a", "b")`,
+		`has(a)`,
+		`a.matches("(")`,
+		`1 # 2`,
+	} {
+		_, err := expr.Compile(src)
+		require.ErrorIs(t, err, expr.ErrSyntax, src)
+	}
+}
+
+func TestCompileLimits(t *testing.T) {
+	require := require.New(t)
+
+	nested := func(n int, open, close string) string {
+		return strings.Repeat(open, n) + "1" + strings.Repeat(close, n)
+	}
+
+	for _, src := range []string{
+		nested(expr.MaxDepth-1, "(", ")"),
+		nested(expr.MaxDepth-1, "[", "]"),
+		strings.Repeat("!", expr.MaxDepth-1) + "true",
+		strings.Repeat("1 + ", expr.MaxLength/8) + "1",
+	} {
+		_, err := expr.Compile(src)
+		require.NoError(err)
+	}
+
+	for _, src := range []string{
+		nested(expr.MaxDepth, "(", ")"),
+		nested(expr.MaxDepth, "[", "]"),
+		nested(expr.MaxDepth, "size(", ")"),
+		nested(expr.MaxDepth, "a[", "]"),
+		strings.Repeat("!", expr.MaxDepth) + "true",
+		strings.Repeat("-", expr.MaxDepth) + "1",
+		strings.Repeat("true ? 1 : ", expr.MaxDepth) + "1",
+		strings.Repeat("1 + ", expr.MaxLength/4) + "1",
+		nested(100000, "(", ")"),
+	} {
+		_, err := expr.Compile(src)
+		require.ErrorIs(err, expr.ErrLimit)
+	}
+}
+
+func TestProgram(t *testing.T) {
+	require := require.New(t)
+
+	p := &expr.Program{}
+	err := p.UnmarshalText([]byte(`operation == "sign" && time.hour < 18`))
+	require.NoError(err)
+
+	require.True(p.Uses("operation"))
+	require.True(p.Uses("time"))
+	require.False(p.Uses("hour"))
+	require.False(p.Uses("attestation"))
+
+	text, err := p.MarshalText()
+	require.NoError(err)
+	require.Equal(`operation == "sign" && time.hour < 18`, string(text))
+
+	ok, err := p.Bool(map[string]any{"operation": "sign", "time": map[string]any{"hour": 9}})
+	require.NoError(err)
+	require.True(ok)
+
+	p, err = expr.Compile(`time.hour`)
+	require.NoError(err)
+
+	_, err = p.Bool(map[string]any{"time": map[string]any{"hour": 9}})
+	require.ErrorIs(err, expr.ErrType)
+}
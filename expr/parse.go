@@ -0,0 +1,491 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package expr
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokInt
+	tokDouble
+	tokString
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+
+	// val is the value of literals
+	val any
+}
+
+// operators are sorted so that longer operators are matched first.
+//
+//nolint:gochecknoglobals
+var operators = []string{
+	"||", "&&", "==", "!=", "<=", ">=",
+	"!", "<", ">", "+", "-", "*", "/", "%", "?", ":", "(", ")", "[", "]", ".", ",",
+}
+
+type lexer struct {
+	src string
+	pos int
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) && strings.ContainsRune(" \t\r\n", rune(l.src[l.pos])) {
+		l.pos++
+	}
+
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+
+	c := l.src[l.pos]
+
+	switch {
+	case c == '"' || c == '\'':
+		s, err := l.string(c)
+		if err != nil {
+			return token{}, err
+		}
+
+		return token{kind: tokString, text: l.src[start:l.pos], pos: start, val: s}, nil
+
+	case c >= '0' && c <= '9':
+		for l.pos < len(l.src) && strings.IndexByte("0123456789.eE", l.src[l.pos]) >= 0 {
+			l.pos++
+		}
+
+		text := l.src[start:l.pos]
+		if !strings.ContainsAny(text, ".eE") {
+			i, err := strconv.ParseInt(text, 10, 64)
+			if err != nil {
+				return token{}, fmt.Errorf("%w: invalid integer %q at %d", ErrSyntax, text, start)
+			}
+
+			return token{kind: tokInt, text: text, pos: start, val: i}, nil
+		}
+
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return token{}, fmt.Errorf("%w: invalid number %q at %d", ErrSyntax, text, start)
+		}
+
+		return token{kind: tokDouble, text: text, pos: start, val: f}, nil
+
+	case c == '_' || unicode.IsLetter(rune(c)):
+		for l.pos < len(l.src) {
+			r, n := utf8.DecodeRuneInString(l.src[l.pos:])
+			if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+				break
+			}
+
+			l.pos += n
+		}
+
+		return token{kind: tokIdent, text: l.src[start:l.pos], pos: start}, nil
+	}
+
+	for _, op := range operators {
+		if strings.HasPrefix(l.src[l.pos:], op) {
+			l.pos += len(op)
+			return token{kind: tokOp, text: op, pos: start}, nil
+		}
+	}
+
+	return token{}, fmt.Errorf("%w: unexpected character %q at %d", ErrSyntax, c, start)
+}
+
+// string lexes a quoted string with the escape sequences \\, \", \', \n, \r and \t.
+func (l *lexer) string(quote byte) (string, error) {
+	start := l.pos
+	sb := &strings.Builder{}
+
+	for l.pos++; l.pos < len(l.src); l.pos++ {
+		c := l.src[l.pos]
+
+		switch c {
+		case quote:
+			l.pos++
+			return sb.String(), nil
+
+		case '\\':
+			if l.pos++; l.pos >= len(l.src) {
+				break
+			}
+
+			switch e := l.src[l.pos]; e {
+			case '\\', '"', '\'':
+				sb.WriteByte(e)
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 't':
+				sb.WriteByte('\t')
+			default:
+				return "", fmt.Errorf("%w: invalid escape sequence \\%c at %d", ErrSyntax, e, l.pos-1)
+			}
+
+		default:
+			sb.WriteByte(c)
+		}
+	}
+
+	return "", fmt.Errorf("%w: unterminated string at %d", ErrSyntax, start)
+}
+
+type parser struct {
+	lex *lexer
+	tok token
+
+	// idents are the referenced variables
+	idents []string
+
+	// depth is the current nesting depth
+	depth int
+}
+
+// nest enters a nested expression. The returned function leaves it again.
+func (p *parser) nest() (func(), error) {
+	if p.depth >= MaxDepth {
+		return nil, fmt.Errorf("%w: nesting depth exceeds %d at %d", ErrLimit, MaxDepth, p.tok.pos)
+	}
+
+	p.depth++
+
+	return func() { p.depth-- }, nil
+}
+
+func (p *parser) next() (err error) {
+	p.tok, err = p.lex.next()
+	return err
+}
+
+func (p *parser) is(op string) bool {
+	return p.tok.kind == tokOp && p.tok.text == op
+}
+
+func (p *parser) expect(op string) error {
+	if !p.is(op) {
+		return p.unexpected()
+	}
+
+	return p.next()
+}
+
+func (p *parser) unexpected() error {
+	if p.tok.kind == tokEOF {
+		return fmt.Errorf("%w: unexpected end of expression", ErrSyntax)
+	}
+
+	return fmt.Errorf("%w: unexpected %q at %d", ErrSyntax, p.tok.text, p.tok.pos)
+}
+
+// expr parses a conditional expression.
+func (p *parser) expr() (node, error) {
+	leave, err := p.nest()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	c, err := p.binary(0)
+	if err != nil || !p.is("?") {
+		return c, err
+	}
+
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	t, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+
+	f, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+
+	return &condNode{c, t, f}, nil
+}
+
+// precedences lists the binary operators by increasing precedence.
+//
+//nolint:gochecknoglobals
+var precedences = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">=", "in"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// binary parses left-associative binary operators of the given precedence or higher.
+func (p *parser) binary(prec int) (node, error) {
+	if prec >= len(precedences) {
+		return p.unary()
+	}
+
+	l, err := p.binary(prec + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := p.tok.text
+		if (p.tok.kind != tokOp && (p.tok.kind != tokIdent || op != "in")) || !slices.Contains(precedences[prec], op) {
+			return l, nil
+		}
+
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+
+		r, err := p.binary(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		l = &binaryNode{op, l, r}
+	}
+}
+
+func (p *parser) unary() (node, error) {
+	if p.is("!") || p.is("-") {
+		op := p.tok.text
+
+		leave, err := p.nest()
+		if err != nil {
+			return nil, err
+		}
+		defer leave()
+
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+
+		return &unaryNode{op, x}, nil
+	}
+
+	return p.member()
+}
+
+// member parses field selections, indices and method calls.
+func (p *parser) member() (node, error) {
+	x, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch {
+		case p.is("."):
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+
+			if p.tok.kind != tokIdent {
+				return nil, p.unexpected()
+			}
+
+			name := p.tok.text
+
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+
+			if !p.is("(") {
+				x = &selectNode{x, name}
+				continue
+			}
+
+			args, err := p.args(")")
+			if err != nil {
+				return nil, err
+			}
+
+			if x, err = newCall(name, x, args); err != nil {
+				return nil, err
+			}
+
+		case p.is("["):
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+
+			i, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+
+			x = &indexNode{x, i}
+
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *parser) primary() (node, error) {
+	tok := p.tok
+
+	switch tok.kind {
+	case tokInt, tokDouble, tokString:
+		return &literalNode{tok.val}, p.next()
+
+	case tokIdent:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+
+		switch tok.text {
+		case "true":
+			return &literalNode{true}, nil
+		case "false":
+			return &literalNode{false}, nil
+		case "null":
+			return &literalNode{nil}, nil
+		case "in":
+			return nil, fmt.Errorf("%w: unexpected \"in\" at %d", ErrSyntax, tok.pos)
+		}
+
+		if !p.is("(") {
+			p.idents = append(p.idents, tok.text)
+			return &identNode{tok.text}, nil
+		}
+
+		args, err := p.args(")")
+		if err != nil {
+			return nil, err
+		}
+
+		return newCall(tok.text, nil, args)
+
+	case tokOp:
+		switch tok.text {
+		case "(":
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+
+			x, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+
+			return x, p.expect(")")
+
+		case "[":
+			elems, err := p.args("]")
+			if err != nil {
+				return nil, err
+			}
+
+			return &listNode{elems}, nil
+		}
+	}
+
+	return nil, p.unexpected()
+}
+
+// args parses a comma-separated list of expressions following
+// the current opening token up to the closing one.
+func (p *parser) args(closing string) (args []node, err error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	for !p.is(closing) {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, arg)
+	}
+
+	return args, p.next()
+}
+
+// newCall checks the arity of a function or method call.
+// Receivers are passed as first argument.
+func newCall(name string, recv node, args []node) (node, error) {
+	if recv != nil {
+		args = append([]node{recv}, args...)
+	}
+
+	arity, ok := functions[name]
+	if !ok || (recv != nil) != arity.method && name != "size" {
+		return nil, fmt.Errorf("%w: unknown function %s", ErrSyntax, name)
+	}
+
+	if len(args) != arity.args {
+		return nil, fmt.Errorf("%w: %s expects %d arguments", ErrSyntax, name, arity.args)
+	}
+
+	switch name {
+	case "has":
+		sel, ok := args[0].(*selectNode)
+		if !ok {
+			return nil, fmt.Errorf("%w: has expects a field selection", ErrSyntax)
+		}
+
+		return &hasNode{sel}, nil
+
+	case "matches":
+		// Patterns are compiled once if they are literals
+		if lit, ok := args[1].(*literalNode); ok {
+			pattern, ok := lit.val.(string)
+			if !ok {
+				return nil, fmt.Errorf("%w: matches expects a string pattern", ErrSyntax)
+			}
+
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid pattern: %w", ErrSyntax, err)
+			}
+
+			return &matchNode{args[0], re}, nil
+		}
+	}
+
+	return &callNode{name, args}, nil
+}
@@ -0,0 +1,156 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package fingerprint
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Bech32 encoding as specified by BIP 173 without its limit of 90 characters like age uses it.
+
+const charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+//nolint:gochecknoglobals
+var generator = []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+func polymod(values []byte) uint32 {
+	chk := uint32(1)
+
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+
+		for i := range 5 {
+			if (top>>i)&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+
+	return chk
+}
+
+func hrpExpand(hrp string) []byte {
+	h := []byte(strings.ToLower(hrp))
+	r := make([]byte, 0, 2*len(h)+1)
+
+	for _, c := range h {
+		r = append(r, c>>5)
+	}
+
+	r = append(r, 0)
+
+	for _, c := range h {
+		r = append(r, c&31)
+	}
+
+	return r
+}
+
+// convertBits regroups a byte slice from groups of frombits to groups of tobits.
+func convertBits(data []byte, frombits, tobits uint, pad bool) ([]byte, error) {
+	var ret []byte
+	acc, bits := uint32(0), uint(0)
+	maxv := byte(1<<tobits - 1)
+
+	for _, v := range data {
+		if v>>frombits != 0 {
+			return nil, fmt.Errorf("%w: invalid data range", ErrInvalidEncoding)
+		}
+
+		acc = acc<<frombits | uint32(v)
+		bits += frombits
+
+		for bits >= tobits {
+			bits -= tobits
+			ret = append(ret, byte(acc>>bits)&maxv)
+		}
+	}
+
+	if pad {
+		if bits > 0 {
+			ret = append(ret, byte(acc<<(tobits-bits))&maxv)
+		}
+	} else if bits >= frombits || byte(acc<<(tobits-bits))&maxv != 0 {
+		return nil, fmt.Errorf("%w: invalid padding", ErrInvalidEncoding)
+	}
+
+	return ret, nil
+}
+
+// EncodeBech32 encodes data with the human readable part hrp.
+// The result is lower case unless hrp is upper case.
+func EncodeBech32(hrp string, data []byte) (string, error) {
+	if hrp == "" {
+		return "", fmt.Errorf("%w: empty human readable part", ErrInvalidEncoding)
+	}
+
+	values, err := convertBits(data, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+
+	lower := strings.ToLower(hrp)
+
+	mod := polymod(append(append(hrpExpand(lower), values...), 0, 0, 0, 0, 0, 0)) ^ 1
+	for i := range 6 {
+		values = append(values, byte(mod>>(5*(5-i)))&31)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(lower)
+	sb.WriteByte('1')
+
+	for _, v := range values {
+		sb.WriteByte(charset[v])
+	}
+
+	if hrp == strings.ToUpper(hrp) {
+		return strings.ToUpper(sb.String()), nil
+	}
+
+	return sb.String(), nil
+}
+
+// DecodeBech32 decodes a Bech32 string into its human readable part in lower case and its data.
+func DecodeBech32(s string) (hrp string, data []byte, err error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, fmt.Errorf("%w: mixed case", ErrInvalidEncoding)
+	}
+
+	s = strings.ToLower(s)
+
+	pos := strings.LastIndexByte(s, '1')
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, fmt.Errorf("%w: invalid separator position", ErrInvalidEncoding)
+	}
+
+	hrp = s[:pos]
+	for _, c := range hrp {
+		if c < 33 || c > 126 {
+			return "", nil, fmt.Errorf("%w: invalid character in human readable part", ErrInvalidEncoding)
+		}
+	}
+
+	values := []byte{}
+	for _, c := range s[pos+1:] {
+		v := strings.IndexRune(charset, c)
+		if v < 0 {
+			return "", nil, fmt.Errorf("%w: invalid character %q", ErrInvalidEncoding, c)
+		}
+
+		values = append(values, byte(v))
+	}
+
+	if polymod(append(hrpExpand(hrp), values...)) != 1 {
+		return "", nil, fmt.Errorf("%w: invalid checksum", ErrInvalidEncoding)
+	}
+
+	if data, err = convertBits(values[:len(values)-6], 5, 8, false); err != nil {
+		return "", nil, err
+	}
+
+	return hrp, data, nil
+}
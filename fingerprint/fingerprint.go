@@ -0,0 +1,214 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package fingerprint derives stable identities from the public keys of providers.
+//
+// The canonical fingerprint of a key is the SHA-256 digest of its DER-encoded
+// SubjectPublicKeyInfo. It is represented as Bech32 string with the human
+// readable part "hawkes" or alternatively as hex string.
+// SSH-style fingerprints and age recipients are supported for interoperability.
+package fingerprint
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/katzenpost/nyquist/dh"
+	gossh "golang.org/x/crypto/ssh"
+
+	"cunicu.li/hawkes/provider"
+)
+
+var (
+	ErrInvalidEncoding = errors.New("invalid encoding")
+	ErrUnsupportedKey  = errors.New("unsupported key")
+)
+
+const (
+	// HRP is the human readable part of Bech32-encoded fingerprints.
+	HRP = "hawkes"
+
+	// AgeHRP is the human readable part of age X25519 recipients.
+	AgeHRP = "age"
+)
+
+// Fingerprint is the SHA-256 digest of the DER-encoded SubjectPublicKeyInfo of a public key.
+type Fingerprint [sha256.Size]byte
+
+// Of returns the fingerprint of a public key.
+func Of(pub crypto.PublicKey) (f Fingerprint, err error) {
+	if pub, err = normalize(pub); err != nil {
+		return f, err
+	}
+
+	spki, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return f, fmt.Errorf("%w: %w", ErrUnsupportedKey, err)
+	}
+
+	return sha256.Sum256(spki), nil
+}
+
+// OfKey returns the fingerprint of the public key of a provider key.
+func OfKey(key provider.PrivateKey) (f Fingerprint, err error) {
+	pub, err := PublicKey(key)
+	if err != nil {
+		return f, err
+	}
+
+	return Of(pub)
+}
+
+// Parse parses a fingerprint in its Bech32 or hex representation.
+// Hex strings may contain colons.
+func Parse(s string) (f Fingerprint, err error) {
+	var b []byte
+
+	if strings.HasPrefix(strings.ToLower(s), HRP+"1") {
+		var hrp string
+		if hrp, b, err = DecodeBech32(s); err != nil {
+			return f, err
+		} else if hrp != HRP {
+			return f, fmt.Errorf("%w: unexpected prefix %q", ErrInvalidEncoding, hrp)
+		}
+	} else if b, err = hex.DecodeString(strings.ReplaceAll(s, ":", "")); err != nil {
+		return f, fmt.Errorf("%w: %w", ErrInvalidEncoding, err)
+	}
+
+	if len(b) != len(f) {
+		return f, fmt.Errorf("%w: invalid length %d", ErrInvalidEncoding, len(b))
+	}
+
+	copy(f[:], b)
+
+	return f, nil
+}
+
+// String returns the Bech32 representation, e.g. "hawkes1...".
+func (f Fingerprint) String() string {
+	s, _ := EncodeBech32(HRP, f[:])
+	return s
+}
+
+// Hex returns the lower case hex representation.
+func (f Fingerprint) Hex() string {
+	return hex.EncodeToString(f[:])
+}
+
+func (f Fingerprint) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+func (f *Fingerprint) UnmarshalText(text []byte) (err error) {
+	*f, err = Parse(string(text))
+	return err
+}
+
+// SSH returns the fingerprint of a public key as printed by ssh-keygen, e.g. "SHA256:...".
+func SSH(pub crypto.PublicKey) (string, error) {
+	pub, err := normalize(pub)
+	if err != nil {
+		return "", err
+	}
+
+	sshPub, err := gossh.NewPublicKey(pub)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrUnsupportedKey, err)
+	}
+
+	return gossh.FingerprintSHA256(sshPub), nil
+}
+
+// AgeRecipient returns the age recipient of an X25519 public key, e.g. "age1...".
+func AgeRecipient(pub crypto.PublicKey) (string, error) {
+	pub, err := normalize(pub)
+	if err != nil {
+		return "", err
+	}
+
+	xpub, ok := pub.(*ecdh.PublicKey)
+	if !ok || xpub.Curve() != ecdh.X25519() {
+		return "", fmt.Errorf("%w: age recipients require X25519 keys", ErrUnsupportedKey)
+	}
+
+	return EncodeBech32(AgeHRP, xpub.Bytes())
+}
+
+// ParseAgeRecipient parses an age X25519 recipient.
+func ParseAgeRecipient(s string) (*ecdh.PublicKey, error) {
+	hrp, b, err := DecodeBech32(s)
+	if err != nil {
+		return nil, err
+	} else if hrp != AgeHRP {
+		return nil, fmt.Errorf("%w: unexpected prefix %q", ErrInvalidEncoding, hrp)
+	}
+
+	pub, err := ecdh.X25519().NewPublicKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidEncoding, err)
+	}
+
+	return pub, nil
+}
+
+// PublicKey returns the public key of a provider key.
+// The public key of the signer is preferred over the one used for key agreements.
+func PublicKey(key provider.PrivateKey) (crypto.PublicKey, error) {
+	if sk, ok := key.(provider.PrivateKeySigner); ok {
+		if signer, err := sk.Signer(); err == nil {
+			return signer.Public(), nil
+		}
+	}
+
+	if dk, ok := key.(provider.PrivateKeyDH); ok {
+		return normalize(dk.Public())
+	}
+
+	return nil, fmt.Errorf("%w: key has no public key", ErrUnsupportedKey)
+}
+
+// normalize converts Noise and ECDH public keys of NIST curves
+// into their ECDSA counterparts which are understood by all encodings.
+func normalize(pub crypto.PublicKey) (crypto.PublicKey, error) {
+	if dpub, ok := pub.(dh.PublicKey); ok {
+		b := dpub.Bytes()
+
+		var curve ecdh.Curve
+		switch len(b) {
+		case 32:
+			curve = ecdh.X25519()
+		case 65:
+			curve = ecdh.P256()
+		case 97:
+			curve = ecdh.P384()
+		case 133:
+			curve = ecdh.P521()
+		default:
+			return nil, fmt.Errorf("%w: public key of length %d", ErrUnsupportedKey, len(b))
+		}
+
+		epub, err := curve.NewPublicKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrUnsupportedKey, err)
+		}
+
+		pub = epub
+	}
+
+	if epub, ok := pub.(*ecdh.PublicKey); ok && epub.Curve() != ecdh.X25519() {
+		spki, err := x509.MarshalPKIXPublicKey(epub)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrUnsupportedKey, err)
+		}
+
+		return x509.ParsePKIXPublicKey(spki)
+	}
+
+	return pub, nil
+}
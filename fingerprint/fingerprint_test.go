@@ -0,0 +1,135 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package fingerprint_test
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"strings"
+	"testing"
+
+	"github.com/katzenpost/nyquist/dh"
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/ecdh/sw"
+	"cunicu.li/hawkes/fingerprint"
+)
+
+func TestBech32(t *testing.T) {
+	require := require.New(t)
+
+	// Test vectors of BIP 173
+	for _, s := range []string{
+		"A12UEL5L",
+		"an83characterlonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1tt5tgs",
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw",
+		"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w",
+	} {
+		hrp, data, err := fingerprint.DecodeBech32(s)
+		require.NoError(err, s)
+
+		if strings.ToUpper(s) == s {
+			hrp = strings.ToUpper(hrp)
+		}
+
+		enc, err := fingerprint.EncodeBech32(hrp, data)
+		require.NoError(err)
+		require.Equal(s, enc)
+	}
+
+	for _, s := range []string{
+		"pzry9x0s0muk",  // No separator
+		"1pzry9x0s0muk", // Empty HRP
+		"x1b4n0q5v",     // Invalid character
+		"A1G7SGD8",      // Invalid checksum
+		"aBcDeF1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw", // Mixed case
+	} {
+		_, _, err := fingerprint.DecodeBech32(s)
+		require.ErrorIs(err, fingerprint.ErrInvalidEncoding, s)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	require := require.New(t)
+
+	sk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	f, err := fingerprint.Of(sk.Public())
+	require.NoError(err)
+
+	spki, err := x509.MarshalPKIXPublicKey(sk.Public())
+	require.NoError(err)
+	require.Equal(sha256.Sum256(spki), [32]byte(f))
+
+	require.True(strings.HasPrefix(f.String(), "hawkes1"))
+
+	for _, s := range []string{f.String(), strings.ToUpper(f.String()), f.Hex()} {
+		g, err := fingerprint.Parse(s)
+		require.NoError(err)
+		require.Equal(f, g)
+	}
+
+	_, err = fingerprint.Parse("age1qqqq")
+	require.ErrorIs(err, fingerprint.ErrInvalidEncoding)
+
+	// Noise keys of the same curve have the same fingerprint
+	epub, err := sk.PublicKey.ECDH()
+	require.NoError(err)
+
+	dpub, err := sw.P256.ParsePublicKey(epub.Bytes())
+	require.NoError(err)
+
+	g, err := fingerprint.Of(dpub)
+	require.NoError(err)
+	require.Equal(f, g)
+
+	ssh, err := fingerprint.SSH(dpub)
+	require.NoError(err)
+	require.True(strings.HasPrefix(ssh, "SHA256:"))
+
+	_, err = fingerprint.AgeRecipient(dpub)
+	require.ErrorIs(err, fingerprint.ErrUnsupportedKey)
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(err)
+
+	_, err = fingerprint.SSH(pub)
+	require.NoError(err)
+}
+
+func TestAgeRecipient(t *testing.T) {
+	require := require.New(t)
+
+	// Test vector of the age specification
+	const recipient = "age1zvkyg2lqzraa2lnjvqej32nkuu0ues2s82hzrye869xeexvn73equnujwj"
+
+	pub, err := fingerprint.ParseAgeRecipient(recipient)
+	require.NoError(err)
+
+	s, err := fingerprint.AgeRecipient(pub)
+	require.NoError(err)
+	require.Equal(recipient, s)
+
+	kp, err := dh.X25519.GenerateKeypair(rand.Reader)
+	require.NoError(err)
+
+	s, err = fingerprint.AgeRecipient(kp.Public())
+	require.NoError(err)
+
+	pub, err = fingerprint.ParseAgeRecipient(s)
+	require.NoError(err)
+	require.Equal(kp.Public().Bytes(), pub.Bytes())
+
+	_, err = fingerprint.ParseAgeRecipient("hawkes1zvkyg2lqzraa2lnjvqej32nkuu0ues2s82hzrye869xeexvn73eqgpfyqk")
+	require.ErrorIs(err, fingerprint.ErrInvalidEncoding)
+
+	_, err = ecdh.X25519().NewPublicKey(pub.Bytes())
+	require.NoError(err)
+}
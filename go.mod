@@ -8,12 +8,14 @@ go 1.23.3
 require (
 	cunicu.li/go-iso7816 v0.8.4
 	cunicu.li/go-ykoath/v2 v2.1.13
+	github.com/ProtonMail/go-crypto v1.3.0
 	github.com/ebfe/scard v0.0.0-20241214075232-7af069cabc25
 	github.com/google/go-tpm v0.9.3
 	github.com/katzenpost/nyquist v0.0.10
 	github.com/miekg/pkcs11 v1.1.2-0.20231115102856-9078ad6b9d4b
-	golang.org/x/crypto v0.32.0
+	golang.org/x/crypto v0.33.0
 	golang.org/x/sync v0.10.0
+	golang.org/x/sys v0.30.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
@@ -22,6 +24,7 @@ require github.com/stretchr/testify v1.10.0 // test-only
 require (
 	codeberg.org/vula/highctidh v1.0.2024012400 // indirect
 	filippo.io/mlkem768 v0.0.0-20240221181710-5ce91625fdc1 // indirect
+	github.com/cloudflare/circl v1.6.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/go-faster/xor v1.0.0 // indirect
 	github.com/katzenpost/chacha20 v0.0.0-20190910113340-7ce890d6a556 // indirect
@@ -36,5 +39,4 @@ require (
 	github.com/stretchr/objx v0.5.2 // indirect
 	gitlab.com/yawning/bsaes.git v0.0.0-20190805113838-0a714cd429ec // indirect
 	gitlab.com/yawning/x448.git v0.0.0-20221003101044-617eb9b7d9b7 // indirect
-	golang.org/x/sys v0.29.0 // indirect
 )
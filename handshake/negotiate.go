@@ -0,0 +1,321 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package handshake
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/katzenpost/nyquist"
+	"github.com/katzenpost/nyquist/cipher"
+	"github.com/katzenpost/nyquist/dh"
+	"github.com/katzenpost/nyquist/hash"
+	"github.com/katzenpost/nyquist/pattern"
+)
+
+var (
+	ErrNoCommonAlgorithm = errors.New("no common algorithm")
+	ErrInvalidSelection  = errors.New("peer selected an algorithm which was not offered")
+	ErrMissingKey        = errors.New("missing key for offered group")
+	ErrPreambleTooLarge  = errors.New("preamble too large")
+)
+
+// prologueLabel prefixes the transcript of the negotiation
+// which is authenticated as prologue of the following handshake.
+const prologueLabel = "hawkes/negotiate/v1"
+
+//nolint:gochecknoglobals
+var (
+	DefaultCiphers = []string{cipher.ChaChaPoly.String(), cipher.AESGCM.String()}
+	DefaultHashes  = []string{hash.BLAKE2s.String(), hash.SHA256.String()}
+)
+
+// Capabilities are the algorithms supported by a peer in order of its preference.
+type Capabilities struct {
+	// DH are the Diffie-Hellman groups for which the peer holds a static key.
+	DH []string `json:"dh"`
+
+	// Ciphers are the supported AEADs. DefaultCiphers are used if empty.
+	Ciphers []string `json:"ciphers,omitempty"`
+
+	// Hashes are the supported hash functions. DefaultHashes are used if empty.
+	Hashes []string `json:"hashes,omitempty"`
+
+	// Suites are the supported combinations of cipher and hash function like "AESGCM_SHA256",
+	// e.g. FIPSSuites() in FIPS 140 environments.
+	// All combinations of Ciphers and Hashes are supported if empty.
+	// Otherwise, Ciphers and Hashes are derived from the suites if empty
+	// to restrict peers which do not negotiate suites.
+	Suites []string `json:"suites,omitempty"`
+
+	// PQHybrid announces support for post-quantum hybrid handshakes.
+	PQHybrid bool `json:"pq_hybrid,omitempty"`
+}
+
+// Selection are the algorithms chosen by the responder.
+type Selection struct {
+	DH       string `json:"dh,omitempty"`
+	Cipher   string `json:"cipher,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+	Suite    string `json:"suite,omitempty"`
+	PQHybrid bool   `json:"pq_hybrid,omitempty"`
+
+	Error string `json:"error,omitempty"`
+
+	// transcript are the preambles as exchanged with the peer.
+	transcript []byte
+}
+
+// Transcript returns the capabilities and the selection as exchanged with the peer.
+// It must be authenticated by the following handshake, e.g. as the prologue
+// of a Noise handshake, as otherwise an attacker could downgrade the algorithms
+// by rewriting the unauthenticated preambles.
+func (s *Selection) Transcript() []byte {
+	return s.transcript
+}
+
+// Protocol returns the Noise protocol for the selected algorithms and given pattern.
+func (s *Selection) Protocol(pa pattern.Pattern) (*nyquist.Protocol, error) {
+	p := &nyquist.Protocol{
+		Pattern: pa,
+		DH:      dh.FromString(s.DH),
+		Cipher:  cipher.FromString(s.Cipher),
+		Hash:    hash.FromString(s.Hash),
+	}
+
+	if p.DH == nil || p.Cipher == nil || p.Hash == nil {
+		return nil, fmt.Errorf("%w: %s_%s_%s", ErrNoCommonAlgorithm, s.DH, s.Cipher, s.Hash)
+	}
+
+	return p, nil
+}
+
+// Negotiate exchanges a preamble with the peer to agree on the algorithms of a following handshake.
+// The initiator sends its capabilities. The responder selects the first algorithm
+// of each kind preferred by the initiator which it supports itself and sends back its selection.
+// Both parties return an error wrapping ErrNoCommonAlgorithm if there is no overlap.
+// The preambles are not authenticated. The transcript of the returned selection
+// must therefore be bound to the following handshake (see Selection.Transcript).
+func Negotiate(rw io.ReadWriter, local *Capabilities, initiator bool) (*Selection, error) {
+	local = local.withDefaults()
+
+	if initiator {
+		offer, err := send(rw, local)
+		if err != nil {
+			return nil, err
+		}
+
+		s := &Selection{}
+		answer, err := receive(rw, s)
+		if err != nil {
+			return nil, err
+		}
+
+		if s.Error != "" {
+			return nil, fmt.Errorf("%w: %s", ErrNoCommonAlgorithm, s.Error)
+		}
+
+		if !slices.Contains(local.DH, s.DH) ||
+			!slices.Contains(local.suites(), suiteID(s.Cipher, s.Hash)) ||
+			(s.PQHybrid && !local.PQHybrid) {
+			return nil, fmt.Errorf("%w: %s_%s_%s", ErrInvalidSelection, s.DH, s.Cipher, s.Hash)
+		}
+
+		s.transcript = transcript(offer, answer)
+
+		return s, nil
+	}
+
+	remote := &Capabilities{}
+	offer, err := receive(rw, remote)
+	if err != nil {
+		return nil, err
+	}
+
+	remote = remote.withDefaults()
+
+	s, err := selectAlgorithms(local, remote)
+	if err != nil {
+		// Let the initiator know why we are aborting
+		if _, err := send(rw, &Selection{Error: err.Error()}); err != nil {
+			return nil, err
+		}
+
+		return nil, err
+	}
+
+	answer, err := send(rw, s)
+	if err != nil {
+		return nil, err
+	}
+
+	s.transcript = transcript(offer, answer)
+
+	return s, nil
+}
+
+// NegotiateNoiseHandshake negotiates the algorithms with the peer and creates a
+// Noise handshake using the local static key of the selected Diffie-Hellman group.
+// The keys are indexed by the names of their groups. The order of local.DH
+// decides which key is preferred.
+// As the remote static key is not known in advance, the pattern must not use pre-messages.
+// The negotiation is authenticated as prologue of the handshake so that
+// the handshake fails if the preambles have been tampered with.
+func NegotiateNoiseHandshake(pa pattern.Pattern, local *Capabilities, keys map[string]dh.Keypair, rw io.ReadWriter, initiator bool) (*NoiseHandshake, error) {
+	for _, g := range local.DH {
+		if _, ok := keys[g]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrMissingKey, g)
+		}
+	}
+
+	s, err := Negotiate(rw, local, initiator)
+	if err != nil {
+		return nil, fmt.Errorf("failed to negotiate algorithms: %w", err)
+	}
+
+	proto, err := s.Protocol(pa)
+	if err != nil {
+		return nil, err
+	}
+
+	return newNoiseHandshake(proto, keys[s.DH], nil, rw, initiator, s.Transcript())
+}
+
+func (c *Capabilities) withDefaults() *Capabilities {
+	d := *c
+
+	for _, id := range d.Suites {
+		ci, h, ok := strings.Cut(id, "_")
+		if !ok {
+			continue
+		}
+
+		if len(c.Ciphers) == 0 && !slices.Contains(d.Ciphers, ci) {
+			d.Ciphers = append(d.Ciphers, ci)
+		}
+
+		if len(c.Hashes) == 0 && !slices.Contains(d.Hashes, h) {
+			d.Hashes = append(d.Hashes, h)
+		}
+	}
+
+	if len(d.Ciphers) == 0 {
+		d.Ciphers = DefaultCiphers
+	}
+
+	if len(d.Hashes) == 0 {
+		d.Hashes = DefaultHashes
+	}
+
+	return &d
+}
+
+// suites returns the supported suites in order of preference.
+func (c *Capabilities) suites() []string {
+	if len(c.Suites) > 0 {
+		return c.Suites
+	}
+
+	ids := []string{}
+	for _, ci := range c.Ciphers {
+		for _, h := range c.Hashes {
+			ids = append(ids, suiteID(ci, h))
+		}
+	}
+
+	return ids
+}
+
+func suiteID(cipher, hash string) string {
+	return cipher + "_" + hash
+}
+
+func selectAlgorithms(local, remote *Capabilities) (*Selection, error) {
+	s := &Selection{
+		PQHybrid: local.PQHybrid && remote.PQHybrid,
+	}
+
+	for _, c := range []struct {
+		kind     string
+		local    []string
+		remote   []string
+		selected *string
+		valid    func(string) bool
+	}{
+		{"DH group", local.DH, remote.DH, &s.DH, func(n string) bool { return dh.FromString(n) != nil }},
+		{"cipher suite", local.suites(), remote.suites(), &s.Suite, func(id string) bool {
+			ci, h, ok := strings.Cut(id, "_")
+			return ok && cipher.FromString(ci) != nil && hash.FromString(h) != nil
+		}},
+	} {
+		// The preference of the initiator wins
+		idx := slices.IndexFunc(c.remote, func(n string) bool {
+			return slices.Contains(c.local, n) && c.valid(n)
+		})
+		if idx < 0 {
+			return nil, fmt.Errorf("%w: %s (offered %v, supported %v)", ErrNoCommonAlgorithm, c.kind, c.remote, c.local)
+		}
+
+		*c.selected = c.remote[idx]
+	}
+
+	s.Cipher, s.Hash, _ = strings.Cut(s.Suite, "_")
+
+	return s, nil
+}
+
+// transcript frames the exchanged preambles like on the wire.
+func transcript(offer, answer []byte) []byte {
+	t := []byte(prologueLabel)
+	t = binary.BigEndian.AppendUint16(t, uint16(len(offer))) //nolint:gosec
+	t = append(t, offer...)
+	t = binary.BigEndian.AppendUint16(t, uint16(len(answer))) //nolint:gosec
+	t = append(t, answer...)
+
+	return t
+}
+
+// send writes a preamble prefixed by its length and returns the encoded preamble.
+func send(w io.Writer, v any) ([]byte, error) {
+	msg, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(msg) > math.MaxUint16 {
+		return nil, fmt.Errorf("%w: %d bytes", ErrPreambleTooLarge, len(msg))
+	}
+
+	frame := binary.BigEndian.AppendUint16(nil, uint16(len(msg))) //nolint:gosec
+	if _, err := w.Write(append(frame, msg...)); err != nil {
+		return nil, fmt.Errorf("failed to send preamble: %w", err)
+	}
+
+	return msg, nil
+}
+
+// receive reads a preamble prefixed by its length and returns the encoded preamble.
+func receive(r io.Reader, v any) ([]byte, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, fmt.Errorf("failed to receive preamble: %w", err)
+	}
+
+	msg := make([]byte, binary.BigEndian.Uint16(hdr[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, fmt.Errorf("failed to receive preamble: %w", err)
+	}
+
+	if err := json.Unmarshal(msg, v); err != nil {
+		return nil, fmt.Errorf("%w: preamble: %w", ErrParse, err)
+	}
+
+	return msg, nil
+}
@@ -0,0 +1,211 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package handshake_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/katzenpost/nyquist/dh"
+	"github.com/katzenpost/nyquist/pattern"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+
+	"cunicu.li/hawkes/ecdh"
+	"cunicu.li/hawkes/ecdh/sw"
+	"cunicu.li/hawkes/handshake"
+)
+
+func TestNegotiate(t *testing.T) {
+	require := require.New(t)
+
+	p1, p2 := handshake.NewInProcessPipe()
+
+	// The initiator prefers X25519 but the responder holds only a P-256 key, e.g. on a PIV card
+	ikp1, err := dh.X25519.GenerateKeypair(rand.Reader)
+	require.NoError(err)
+
+	ikp2, err := sw.P256.GenerateKeypair(rand.Reader)
+	require.NoError(err)
+
+	rkp, err := sw.P256.GenerateKeypair(rand.Reader)
+	require.NoError(err)
+
+	ic := &handshake.Capabilities{
+		DH:      []string{dh.X25519.String(), sw.P256.String()},
+		Ciphers: []string{"AESGCM", "ChaChaPoly"},
+	}
+
+	rc := &handshake.Capabilities{
+		DH: []string{sw.P256.String()},
+	}
+
+	var ss1, ss2 []byte
+	var g errgroup.Group
+
+	g.Go(func() error {
+		hs, err := handshake.NegotiateNoiseHandshake(pattern.XX, ic, map[string]dh.Keypair{
+			dh.X25519.String(): ikp1,
+			sw.P256.String():   &ecdh.StaticKeypair{PrivateKey: ikp2},
+		}, p1, true)
+		if err != nil {
+			return err
+		}
+
+		ss1, err = hs.Secret(context.Background())
+		return err
+	})
+
+	g.Go(func() error {
+		hs, err := handshake.NegotiateNoiseHandshake(pattern.XX, rc, map[string]dh.Keypair{
+			sw.P256.String(): &ecdh.StaticKeypair{PrivateKey: rkp},
+		}, p2, false)
+		if err != nil {
+			return err
+		}
+
+		ss2, err = hs.Secret(context.Background())
+		return err
+	})
+
+	require.NoError(g.Wait())
+	require.NotEmpty(ss1)
+	require.Equal(ss1, ss2)
+}
+
+func TestNegotiateMismatch(t *testing.T) {
+	require := require.New(t)
+
+	p1, p2 := handshake.NewInProcessPipe()
+
+	var err1, err2 error
+	var g errgroup.Group
+
+	g.Go(func() (err error) {
+		_, err1 = handshake.Negotiate(p1, &handshake.Capabilities{
+			DH: []string{dh.X25519.String()},
+		}, true)
+		return nil
+	})
+
+	g.Go(func() (err error) {
+		_, err2 = handshake.Negotiate(p2, &handshake.Capabilities{
+			DH: []string{sw.P256.String()},
+		}, false)
+		return nil
+	})
+
+	require.NoError(g.Wait())
+	require.ErrorIs(err1, handshake.ErrNoCommonAlgorithm)
+	require.ErrorIs(err2, handshake.ErrNoCommonAlgorithm)
+
+	// Keys must be provided for all offered groups
+	_, err := handshake.NegotiateNoiseHandshake(pattern.XX, &handshake.Capabilities{
+		DH: []string{dh.X25519.String()},
+	}, nil, p1, true)
+	require.ErrorIs(err, handshake.ErrMissingKey)
+}
+
+// rewriteOffer relays between initiator and responder like an attacker
+// who rewrites the capabilities offered by the initiator.
+func rewriteOffer(t *testing.T, initiator, responder *handshake.InProcessPipe, rewrite func(*handshake.Capabilities)) {
+	t.Helper()
+
+	go func() {
+		defer responder.PipeWriter.Close()
+
+		var hdr [2]byte
+		if _, err := io.ReadFull(initiator, hdr[:]); err != nil {
+			return
+		}
+
+		msg := make([]byte, binary.BigEndian.Uint16(hdr[:]))
+		if _, err := io.ReadFull(initiator, msg); err != nil {
+			return
+		}
+
+		c := &handshake.Capabilities{}
+		if err := json.Unmarshal(msg, c); err != nil {
+			return
+		}
+
+		rewrite(c)
+
+		if msg, err := json.Marshal(c); err == nil {
+			frame := binary.BigEndian.AppendUint16(nil, uint16(len(msg))) //nolint:gosec
+			_, _ = responder.Write(append(frame, msg...))
+		}
+
+		_, _ = io.Copy(responder, initiator)
+	}()
+
+	go func() {
+		defer initiator.PipeWriter.Close()
+
+		_, _ = io.Copy(initiator, responder)
+	}()
+}
+
+func TestNegotiateDowngrade(t *testing.T) {
+	require := require.New(t)
+
+	ikp, err := dh.X25519.GenerateKeypair(rand.Reader)
+	require.NoError(err)
+
+	rkp, err := dh.X25519.GenerateKeypair(rand.Reader)
+	require.NoError(err)
+
+	caps := &handshake.Capabilities{
+		DH:     []string{dh.X25519.String()},
+		Suites: []string{"AESGCM_SHA512", "ChaChaPoly_BLAKE2s"},
+	}
+
+	p1, m1 := handshake.NewInProcessPipe()
+	m2, p2 := handshake.NewInProcessPipe()
+
+	// The attacker strips the preferred suite from the offer
+	rewriteOffer(t, m1, m2, func(c *handshake.Capabilities) {
+		c.Suites = c.Suites[1:]
+	})
+
+	var ierr, rerr error
+	var g errgroup.Group
+
+	g.Go(func() error {
+		hs, err := handshake.NegotiateNoiseHandshake(pattern.XX, caps, map[string]dh.Keypair{dh.X25519.String(): ikp}, p1, true)
+		if err == nil {
+			_, err = hs.Secret(context.Background())
+		}
+
+		// Unblock the responder waiting for the final message
+		p1.PipeWriter.Close()
+
+		ierr = err
+
+		return nil
+	})
+
+	g.Go(func() error {
+		hs, err := handshake.NegotiateNoiseHandshake(pattern.XX, caps, map[string]dh.Keypair{dh.X25519.String(): rkp}, p2, false)
+		if err == nil {
+			_, err = hs.Secret(context.Background())
+		}
+
+		p2.PipeWriter.Close()
+
+		rerr = err
+
+		return nil
+	})
+
+	require.NoError(g.Wait())
+
+	// Both parties agreed on the weaker suite but the handshake fails
+	require.Error(ierr)
+	require.Error(rerr)
+}
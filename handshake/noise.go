@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 
 	"github.com/katzenpost/nyquist"
 	"github.com/katzenpost/nyquist/cipher"
@@ -26,14 +27,29 @@ var protocol = nyquist.Protocol{
 
 var _ Handshake = (*NoiseHandshake)(nil)
 
+// PeerVerifier decides whether a static key received from a peer is trusted,
+// e.g. a peer.Store.
+type PeerVerifier interface {
+	VerifyPeer(name string, pub dh.PublicKey) error
+}
+
 type NoiseHandshake struct {
 	*nyquist.HandshakeState
 	cfg nyquist.HandshakeConfig
 
 	rw io.ReadWriter
+
+	peer     string
+	verifier PeerVerifier
+	verified bool
 }
 
 func NewNoiseHandshake(proto *nyquist.Protocol, ss dh.Keypair, sp dh.PublicKey, rw io.ReadWriter, initiator bool) (hs *NoiseHandshake, err error) {
+	return newNoiseHandshake(proto, ss, sp, rw, initiator, nil)
+}
+
+// newNoiseHandshake creates a handshake which authenticates the prologue.
+func newNoiseHandshake(proto *nyquist.Protocol, ss dh.Keypair, sp dh.PublicKey, rw io.ReadWriter, initiator bool, prologue []byte) (hs *NoiseHandshake, err error) {
 	hs = &NoiseHandshake{
 		rw: rw,
 		cfg: nyquist.HandshakeConfig{
@@ -43,6 +59,7 @@ func NewNoiseHandshake(proto *nyquist.Protocol, ss dh.Keypair, sp dh.PublicKey,
 			},
 			IsInitiator: initiator,
 			Protocol:    proto,
+			Prologue:    prologue,
 		},
 	}
 
@@ -53,47 +70,92 @@ func NewNoiseHandshake(proto *nyquist.Protocol, ss dh.Keypair, sp dh.PublicKey,
 	return hs, nil
 }
 
-func (hs *NoiseHandshake) Secret(_ context.Context) (ss Secret, err error) {
-	for {
-		var msg []byte
+// SetRand sets the entropy source for ephemeral keys.
+// It must be called before the handshake and is intended for deterministic test vectors.
+func (hs *NoiseHandshake) SetRand(rng io.Reader) {
+	hs.cfg.Rng = rng
+}
 
-		if msg, err = hs.WriteMessage(nil, nil); err != nil {
-			if errors.Is(err, nyquist.ErrDone) {
-				break
-			}
+// SetPeerVerifier requires the static key of the named peer to be trusted by the verifier.
+// The handshake is aborted as soon as an untrusted key is received.
+// It must be called before the handshake.
+func (hs *NoiseHandshake) SetPeerVerifier(name string, v PeerVerifier) {
+	hs.peer = name
+	hs.verifier = v
+}
 
-			return nil, fmt.Errorf("failed to write message: %w", err)
-		}
+// verifyPeer checks the remote static key once it has been received.
+func (hs *NoiseHandshake) verifyPeer() error {
+	if hs.verifier == nil || hs.verified {
+		return nil
+	}
 
-		fmt.Printf("%p Pre-Write\n", hs)
+	rs := hs.GetStatus().DH.RemoteStatic
+	if rs == nil {
+		return nil
+	}
 
-		if _, err := hs.rw.Write(msg); err != nil {
-			return nil, fmt.Errorf("failed to send message: %w", err)
-		}
+	if err := hs.verifier.VerifyPeer(hs.peer, rs); err != nil {
+		return fmt.Errorf("untrusted static key of peer %s: %w", hs.peer, err)
+	}
+
+	hs.verified = true
 
-		fmt.Printf("%p Write: %s\n", hs, hex.EncodeToString(msg))
+	return nil
+}
 
-		fmt.Printf("%p Pre-Read\n", hs)
+// Secret runs the handshake and returns the handshake hash.
+// The initiator sends the first message, afterwards the parties take turns
+// until the handshake is complete.
+func (hs *NoiseHandshake) Secret(_ context.Context) (ss Secret, err error) {
+	// Patterns with pre-messages know the remote static key in advance
+	if err := hs.verifyPeer(); err != nil {
+		return nil, err
+	}
 
-		msg = make([]byte, 1500)
-		n, err := hs.rw.Read(msg)
-		if err != nil {
-			return nil, fmt.Errorf("failed to receive message: %w", err)
-		}
-		msg = msg[:n]
+	for write := hs.cfg.IsInitiator; ; write = !write {
+		if write {
+			msg, err := hs.WriteMessage(nil, nil)
+			done := errors.Is(err, nyquist.ErrDone)
+			if err != nil && !done {
+				return nil, fmt.Errorf("failed to write message: %w", err)
+			}
+
+			// The final message is returned together with ErrDone
+			if _, err := hs.rw.Write(msg); err != nil {
+				return nil, fmt.Errorf("failed to send message: %w", err)
+			}
 
-		fmt.Printf("%p Read: %s\n", hs, hex.EncodeToString(msg))
+			slog.Debug("Sent handshake message", slog.String("message", hex.EncodeToString(msg)))
 
-		if _, err = hs.ReadMessage(nil, msg); err != nil {
-			if errors.Is(err, nyquist.ErrDone) {
+			if done {
 				break
 			}
+		} else {
+			msg := make([]byte, 1500)
+			n, err := hs.rw.Read(msg)
+			if err != nil {
+				return nil, fmt.Errorf("failed to receive message: %w", err)
+			}
+			msg = msg[:n]
 
-			return nil, fmt.Errorf("failed to read message: %w", err)
+			slog.Debug("Received handshake message", slog.String("message", hex.EncodeToString(msg)))
+
+			_, err = hs.ReadMessage(nil, msg)
+			done := errors.Is(err, nyquist.ErrDone)
+			if err != nil && !done {
+				return nil, fmt.Errorf("failed to read message: %w", err)
+			}
+
+			if err := hs.verifyPeer(); err != nil {
+				return nil, err
+			}
+
+			if done {
+				break
+			}
 		}
 	}
 
-	fmt.Printf("%p out", hs)
-
 	return hs.GetStatus().HandshakeHash, nil
 }
@@ -6,6 +6,7 @@ package handshake_test
 import (
 	"context"
 	"crypto/rand"
+	"path/filepath"
 	"testing"
 
 	"github.com/katzenpost/nyquist"
@@ -15,6 +16,7 @@ import (
 	"cunicu.li/hawkes/ecdh"
 	"cunicu.li/hawkes/ecdh/sw"
 	"cunicu.li/hawkes/handshake"
+	"cunicu.li/hawkes/peer"
 )
 
 func TestHandshake(t *testing.T) {
@@ -63,3 +65,58 @@ func TestHandshake(t *testing.T) {
 
 	require.Equal(ss1, ss2)
 }
+
+func TestHandshakePeerVerifier(t *testing.T) {
+	require := require.New(t)
+
+	proto, err := nyquist.NewProtocol("Noise_XX_P-256_ChaChaPoly_BLAKE2s")
+	require.NoError(err)
+
+	newKeypair := func() *ecdh.StaticKeypair {
+		kp, err := sw.P256.GenerateKeypair(rand.Reader)
+		require.NoError(err)
+
+		return &ecdh.StaticKeypair{
+			PrivateKey: kp,
+		}
+	}
+
+	alice, bob, mallory := newKeypair(), newKeypair(), newKeypair()
+	peers := peer.NewStore(filepath.Join(t.TempDir(), "peers.json"))
+
+	run := func(responder *ecdh.StaticKeypair) error {
+		p1, p2 := handshake.NewInProcessPipe()
+
+		hs1, err := handshake.NewNoiseHandshake(proto, alice, nil, p1, true)
+		require.NoError(err)
+
+		hs1.SetPeerVerifier("bob", peers)
+
+		hs2, err := handshake.NewNoiseHandshake(proto, responder, nil, p2, false)
+		require.NoError(err)
+
+		errs := make(chan error, 1)
+		go func() {
+			_, err := hs2.Secret(context.Background())
+			errs <- err
+		}()
+
+		_, err = hs1.Secret(context.Background())
+
+		// Unblock the responder waiting for the final message
+		if err != nil {
+			p1.PipeWriter.Close()
+		}
+
+		<-errs
+
+		return err
+	}
+
+	// The key of bob is trusted on first use
+	require.NoError(run(bob))
+	require.NoError(run(bob))
+
+	err = run(mallory)
+	require.ErrorIs(err, peer.ErrKeyMismatch)
+}
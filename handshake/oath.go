@@ -6,10 +6,12 @@ package handshake
 import (
 	"context"
 	"encoding/binary"
+	"fmt"
 	"math"
 	"time"
 
 	"cunicu.li/hawkes/provider"
+	"cunicu.li/hawkes/timesync"
 )
 
 var _ Handshake = (*OATHHandshake)(nil)
@@ -17,11 +19,33 @@ var _ Handshake = (*OATHHandshake)(nil)
 type OATHHandshake struct {
 	Timestep time.Duration
 	Key      provider.PrivateKeyHMAC
-	Clock    func() time.Time
+
+	// Clock is the time for which the TOTP is calculated.
+	// Machines with a skewed clock use a corrected clock like the one of config.TimeSync.
+	// The local clock is used if nil.
+	Clock timesync.Clock
+
+	// Counter determines the time step instead of Clock if set, so that
+	// the secret of a past time step is not derived again after the clock has been
+	// set back or the host restarted. Its period must be equal to Timestep.
+	Counter *timesync.Epochs
 }
 
 func (hs *OATHHandshake) Secret(_ context.Context) (ss Secret, err error) {
-	return hs.calculateTOTP(hs.Clock())
+	if hs.Counter != nil {
+		if hs.Counter.Period != hs.Timestep {
+			return nil, fmt.Errorf("%w: counter period %s does not match timestep %s", timesync.ErrInvalidPeriod, hs.Counter.Period, hs.Timestep)
+		}
+
+		counter, err := hs.Counter.Current()
+		if err != nil {
+			return nil, err
+		}
+
+		return hs.calculateHOTP(counter)
+	}
+
+	return hs.calculateTOTP(timesync.Now(hs.Clock))
 }
 
 func (hs *OATHHandshake) calculateTOTP(t time.Time) ([]byte, error) {
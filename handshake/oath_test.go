@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package handshake_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/handshake"
+	"cunicu.li/hawkes/provider"
+	"cunicu.li/hawkes/timesync"
+)
+
+type hmacKey []byte
+
+func (k hmacKey) ID() provider.KeyID      { return nil }
+func (k hmacKey) Details() map[string]any { return nil }
+func (k hmacKey) Close() error            { return nil }
+
+func (k hmacKey) HMAC(challenge []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, k)
+	mac.Write(challenge)
+
+	return mac.Sum(nil), nil
+}
+
+func TestOATHCounter(t *testing.T) {
+	require := require.New(t)
+
+	now := time.Unix(1700000000, 0)
+	clock := timesync.ClockFunc(func() time.Time { return now })
+
+	hs := &handshake.OATHHandshake{
+		Timestep: 30 * time.Second,
+		Key:      hmacKey("secret"),
+		Clock:    clock,
+	}
+
+	ss1, err := hs.Secret(context.Background())
+	require.NoError(err)
+
+	hs.Counter = timesync.NewEpochs("oath", hs.Timestep, clock, nil)
+
+	ss2, err := hs.Secret(context.Background())
+	require.NoError(err)
+	require.Equal(ss1, ss2)
+
+	// The secret of a past time step is not derived again if the clock is set back
+	now = now.Add(-5 * time.Minute)
+
+	ss3, err := hs.Secret(context.Background())
+	require.NoError(err)
+	require.Equal(ss1, ss3)
+
+	hs.Counter = nil
+
+	ss4, err := hs.Secret(context.Background())
+	require.NoError(err)
+	require.NotEqual(ss1, ss4)
+
+	hs.Counter = timesync.NewEpochs("oath", time.Minute, clock, nil)
+
+	_, err = hs.Secret(context.Background())
+	require.ErrorIs(err, timesync.ErrInvalidPeriod)
+}
@@ -0,0 +1,137 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package handshake
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/katzenpost/nyquist/cipher"
+	"github.com/katzenpost/nyquist/hash"
+)
+
+var (
+	ErrUnknownSuite     = errors.New("unknown cipher suite")
+	ErrConflictingSuite = errors.New("conflicting cipher suite")
+)
+
+// Suite is a combination of an AEAD and a hash function which also
+// determines the HKDF of the symmetric state of Noise handshakes.
+type Suite struct {
+	Cipher cipher.Cipher
+	Hash   hash.Hash
+
+	// FIPS marks suites which consist of FIPS 140 approved algorithms.
+	FIPS bool
+}
+
+// ID returns the identifier of the suite as used in Noise protocol names, e.g. "AESGCM_SHA256".
+func (s *Suite) ID() string {
+	return s.Cipher.String() + "_" + s.Hash.String()
+}
+
+//nolint:gochecknoglobals
+var (
+	SuiteChaChaPolyBLAKE2s = &Suite{Cipher: cipher.ChaChaPoly, Hash: hash.BLAKE2s}
+	SuiteChaChaPolySHA256  = &Suite{Cipher: cipher.ChaChaPoly, Hash: hash.SHA256}
+	SuiteAESGCMSHA256      = &Suite{Cipher: cipher.AESGCM, Hash: hash.SHA256, FIPS: true}
+	SuiteAESGCMSHA512      = &Suite{Cipher: cipher.AESGCM, Hash: hash.SHA512, FIPS: true}
+
+	// DefaultSuites are offered if a peer announces no suites.
+	DefaultSuites = []string{SuiteChaChaPolyBLAKE2s.ID(), SuiteAESGCMSHA256.ID()}
+
+	suites   = map[string]*Suite{}
+	suitesMu sync.RWMutex
+)
+
+//nolint:gochecknoinits
+func init() {
+	for _, s := range []*Suite{
+		SuiteChaChaPolyBLAKE2s,
+		SuiteChaChaPolySHA256,
+		SuiteAESGCMSHA256,
+		SuiteAESGCMSHA512,
+	} {
+		if err := RegisterSuite(s); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RegisterSuite registers a cipher suite for the negotiation and for parsing protocol names.
+// Its cipher and hash function are registered with nyquist if they are not known yet.
+// Like the registration of providers, it must be called during initialization.
+// Registering an identical suite again has no effect.
+func RegisterSuite(s *Suite) error {
+	if c := cipher.FromString(s.Cipher.String()); c == nil {
+		cipher.Register(s.Cipher)
+	} else if c != s.Cipher {
+		return fmt.Errorf("%w: a different cipher named %s is registered", ErrConflictingSuite, s.Cipher)
+	}
+
+	if h := hash.FromString(s.Hash.String()); h == nil {
+		hash.Register(s.Hash)
+	} else if h != s.Hash {
+		return fmt.Errorf("%w: a different hash named %s is registered", ErrConflictingSuite, s.Hash)
+	}
+
+	suitesMu.Lock()
+	defer suitesMu.Unlock()
+
+	if r, ok := suites[s.ID()]; ok {
+		if r.Cipher != s.Cipher || r.Hash != s.Hash || r.FIPS != s.FIPS {
+			return fmt.Errorf("%w: %s is already registered", ErrConflictingSuite, s.ID())
+		}
+
+		return nil
+	}
+
+	suites[s.ID()] = s
+
+	return nil
+}
+
+// LookupSuite returns the registered suite with the given identifier.
+func LookupSuite(id string) (*Suite, error) {
+	suitesMu.RLock()
+	defer suitesMu.RUnlock()
+
+	s, ok := suites[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSuite, id)
+	}
+
+	return s, nil
+}
+
+// Suites returns the sorted identifiers of all registered suites.
+func Suites() []string {
+	suitesMu.RLock()
+	defer suitesMu.RUnlock()
+
+	ids := make([]string, 0, len(suites))
+	for id := range suites {
+		ids = append(ids, id)
+	}
+
+	slices.Sort(ids)
+
+	return ids
+}
+
+// FIPSSuites returns the sorted identifiers of the registered suites
+// which consist of FIPS 140 approved algorithms.
+func FIPSSuites() []string {
+	ids := []string{}
+
+	for _, id := range Suites() {
+		if s, err := LookupSuite(id); err == nil && s.FIPS {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
@@ -0,0 +1,165 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package handshake_test
+
+import (
+	"context"
+	gocipher "crypto/cipher"
+	"crypto/rand"
+	"testing"
+
+	"github.com/katzenpost/nyquist/cipher"
+	"github.com/katzenpost/nyquist/dh"
+	"github.com/katzenpost/nyquist/hash"
+	"github.com/katzenpost/nyquist/pattern"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+
+	"cunicu.li/hawkes/handshake"
+)
+
+// testAEAD is an integrator-provided AEAD which delegates to AES-GCM.
+type testAEAD struct{}
+
+func (testAEAD) String() string { return "TestAEAD" }
+
+func (testAEAD) New(key []byte) (gocipher.AEAD, error) { return cipher.AESGCM.New(key) }
+
+func (testAEAD) EncodeNonce(nonce uint64) []byte { return cipher.AESGCM.EncodeNonce(nonce) }
+
+func negotiate(t *testing.T, ic, rc *handshake.Capabilities) (is, rs *handshake.Selection, ierr, rerr error) {
+	t.Helper()
+
+	p1, p2 := handshake.NewInProcessPipe()
+
+	var g errgroup.Group
+
+	g.Go(func() error {
+		is, ierr = handshake.Negotiate(p1, ic, true)
+		return nil
+	})
+
+	g.Go(func() error {
+		rs, rerr = handshake.Negotiate(p2, rc, false)
+		return nil
+	})
+
+	require.NoError(t, g.Wait())
+
+	return is, rs, ierr, rerr
+}
+
+func TestNegotiateFIPS(t *testing.T) {
+	require := require.New(t)
+
+	x25519 := []string{dh.X25519.String()}
+
+	// A FIPS peer negotiates with a peer supporting the defaults
+	is, rs, ierr, rerr := negotiate(t,
+		&handshake.Capabilities{DH: x25519},
+		&handshake.Capabilities{DH: x25519, Suites: handshake.FIPSSuites()})
+	require.NoError(ierr)
+	require.NoError(rerr)
+	require.Equal(is, rs)
+	require.Equal("AESGCM_SHA256", is.Suite)
+	require.Equal("AESGCM", is.Cipher)
+	require.Equal("SHA256", is.Hash)
+
+	// ChaChaPoly is not acceptable for a FIPS peer
+	_, _, ierr, rerr = negotiate(t,
+		&handshake.Capabilities{DH: x25519, Ciphers: []string{"ChaChaPoly"}},
+		&handshake.Capabilities{DH: x25519, Suites: handshake.FIPSSuites()})
+	require.ErrorIs(ierr, handshake.ErrNoCommonAlgorithm)
+	require.ErrorIs(rerr, handshake.ErrNoCommonAlgorithm)
+
+	// Suites pair ciphers and hashes which are otherwise negotiated independently
+	is, _, ierr, rerr = negotiate(t,
+		&handshake.Capabilities{DH: x25519, Suites: []string{"ChaChaPoly_BLAKE2s", "AESGCM_SHA512"}},
+		&handshake.Capabilities{DH: x25519, Ciphers: []string{"AESGCM"}, Hashes: []string{"BLAKE2s", "SHA512"}})
+	require.NoError(ierr)
+	require.NoError(rerr)
+	require.Equal("AESGCM_SHA512", is.Suite)
+}
+
+func TestRegisterSuite(t *testing.T) {
+	require := require.New(t)
+
+	suite := &handshake.Suite{Cipher: testAEAD{}, Hash: hash.SHA256}
+
+	err := handshake.RegisterSuite(suite)
+	require.NoError(err)
+	require.Contains(handshake.Suites(), "TestAEAD_SHA256")
+	require.NotContains(handshake.FIPSSuites(), "TestAEAD_SHA256")
+
+	s, err := handshake.LookupSuite("TestAEAD_SHA256")
+	require.NoError(err)
+	require.Equal(suite, s)
+
+	// Registering an identical suite again is a no-op
+	err = handshake.RegisterSuite(&handshake.Suite{Cipher: testAEAD{}, Hash: hash.SHA256})
+	require.NoError(err)
+
+	err = handshake.RegisterSuite(&handshake.Suite{Cipher: testAEAD{}, Hash: hash.SHA256, FIPS: true})
+	require.ErrorIs(err, handshake.ErrConflictingSuite)
+
+	_, err = handshake.LookupSuite("Unknown_SHA256")
+	require.ErrorIs(err, handshake.ErrUnknownSuite)
+
+	// The name of the AESGCM cipher is taken
+	err = handshake.RegisterSuite(&handshake.Suite{Cipher: namedAEAD("AESGCM"), Hash: hash.SHA256})
+	require.ErrorIs(err, handshake.ErrConflictingSuite)
+
+	// The registered suite is usable for Noise handshakes
+	caps := &handshake.Capabilities{
+		DH:     []string{dh.X25519.String()},
+		Suites: []string{"TestAEAD_SHA256"},
+	}
+
+	ikp, err := dh.X25519.GenerateKeypair(rand.Reader)
+	require.NoError(err)
+
+	rkp, err := dh.X25519.GenerateKeypair(rand.Reader)
+	require.NoError(err)
+
+	p1, p2 := handshake.NewInProcessPipe()
+
+	var ss1, ss2 []byte
+	var g errgroup.Group
+
+	g.Go(func() error {
+		hs, err := handshake.NegotiateNoiseHandshake(pattern.XX, caps, map[string]dh.Keypair{dh.X25519.String(): ikp}, p1, true)
+		if err != nil {
+			return err
+		}
+
+		ss1, err = hs.Secret(context.Background())
+		return err
+	})
+
+	g.Go(func() error {
+		hs, err := handshake.NegotiateNoiseHandshake(pattern.XX, caps, map[string]dh.Keypair{dh.X25519.String(): rkp}, p2, false)
+		if err != nil {
+			return err
+		}
+
+		ss2, err = hs.Secret(context.Background())
+		return err
+	})
+
+	require.NoError(g.Wait())
+	require.NotEmpty(ss1)
+	require.Equal(ss1, ss2)
+
+	// Protocol names with the suite can be parsed
+	_, err = handshake.ParseProtocol("Noise_XX_25519_TestAEAD_SHA256")
+	require.NoError(err)
+}
+
+type namedAEAD string
+
+func (n namedAEAD) String() string { return string(n) }
+
+func (namedAEAD) New(key []byte) (gocipher.AEAD, error) { return cipher.AESGCM.New(key) }
+
+func (namedAEAD) EncodeNonce(nonce uint64) []byte { return cipher.AESGCM.EncodeNonce(nonce) }
@@ -0,0 +1,277 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package testvectors generates deterministic test vectors of the handshakes
+// so that third-party implementations can verify their interoperability.
+//
+// All inputs are derived from fixed labels as SHA-256("hawkes test vector: " + label).
+// Noise protocols using NIST curves are omitted as the Go standard library
+// ignores the random source when generating their ephemeral keys.
+package testvectors
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/katzenpost/nyquist"
+	"github.com/katzenpost/nyquist/dh"
+	"golang.org/x/sync/errgroup"
+
+	"cunicu.li/hawkes/handshake"
+	"cunicu.li/hawkes/provider"
+	"cunicu.li/hawkes/timesync"
+)
+
+// Bytes is a byte slice which is hex-encoded in JSON.
+type Bytes []byte
+
+func (b Bytes) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(b)), nil
+}
+
+func (b *Bytes) UnmarshalText(text []byte) (err error) {
+	*b, err = hex.DecodeString(string(text))
+	return err
+}
+
+// Vectors are the test vectors of all handshakes.
+type Vectors struct {
+	OATH  []*OATHVector  `json:"oath"`
+	Noise []*NoiseVector `json:"noise"`
+}
+
+// OATHVector is a test vector of the OATH-TOTP handshake.
+// The secret is HMAC-SHA256(key, counter) with the counter as
+// 64-bit big-endian integer of the Unix time divided by the time step.
+type OATHVector struct {
+	Key      Bytes  `json:"key"`
+	Timestep string `json:"timestep"`
+	Time     int64  `json:"time"`
+	Counter  uint64 `json:"counter"`
+	Secret   Bytes  `json:"secret"`
+}
+
+// NoiseVector is a test vector of a Noise handshake.
+// The secret is the handshake hash.
+// The ephemeral keys are the private keys used by the initiator and responder.
+type NoiseVector struct {
+	Protocol           string  `json:"protocol"`
+	InitiatorStatic    Bytes   `json:"initiator_static"`
+	ResponderStatic    Bytes   `json:"responder_static"`
+	InitiatorEphemeral Bytes   `json:"initiator_ephemeral"`
+	ResponderEphemeral Bytes   `json:"responder_ephemeral"`
+	Messages           []Bytes `json:"messages"`
+	Secret             Bytes   `json:"secret"`
+}
+
+//nolint:gochecknoglobals
+var (
+	oathTimesteps = []time.Duration{30 * time.Second, time.Minute}
+	oathTimes     = []int64{59, 1111111109, 1234567890, 2000000000, 20000000000}
+
+	noiseProtocols = []string{
+		"Noise_XX_25519_ChaChaPoly_BLAKE2s",
+		"Noise_IK_25519_ChaChaPoly_BLAKE2s", // WireGuard
+		"Noise_XX_25519_AESGCM_SHA256",
+	}
+)
+
+// Generate calculates the test vectors.
+func Generate() (*Vectors, error) {
+	v := &Vectors{}
+
+	for _, ts := range oathTimesteps {
+		for _, t := range oathTimes {
+			ov, err := generateOATH(ts, t)
+			if err != nil {
+				return nil, fmt.Errorf("failed to generate OATH vector: %w", err)
+			}
+
+			v.OATH = append(v.OATH, ov)
+		}
+	}
+
+	for _, p := range noiseProtocols {
+		nv, err := generateNoise(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate %s vector: %w", p, err)
+		}
+
+		v.Noise = append(v.Noise, nv)
+	}
+
+	return v, nil
+}
+
+// Write generates the test vectors and writes them as indented JSON.
+func Write(w io.Writer) error {
+	v, err := Generate()
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(v)
+}
+
+func generateOATH(ts time.Duration, t int64) (*OATHVector, error) {
+	key := input(fmt.Sprintf("oath %s", ts))
+
+	hs := &handshake.OATHHandshake{
+		Timestep: ts,
+		Key:      hmacKey(key),
+		Clock: timesync.ClockFunc(func() time.Time {
+			return time.Unix(t, 0)
+		}),
+	}
+
+	secret, err := hs.Secret(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	return &OATHVector{
+		Key:      key,
+		Timestep: ts.String(),
+		Time:     t,
+		Counter:  uint64(t / int64(ts/time.Second)), //nolint:gosec
+		Secret:   Bytes(secret),
+	}, nil
+}
+
+func generateNoise(name string) (*NoiseVector, error) {
+	proto, err := nyquist.NewProtocol(name)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &NoiseVector{
+		Protocol:           name,
+		InitiatorStatic:    input(name + " initiator static"),
+		ResponderStatic:    input(name + " responder static"),
+		InitiatorEphemeral: input(name + " initiator ephemeral"),
+		ResponderEphemeral: input(name + " responder ephemeral"),
+	}
+
+	iss, err := proto.DH.ParsePrivateKey(v.InitiatorStatic)
+	if err != nil {
+		return nil, err
+	}
+
+	rss, err := proto.DH.ParsePrivateKey(v.ResponderStatic)
+	if err != nil {
+		return nil, err
+	}
+
+	// Patterns with a pre-message know the static key of the responder in advance
+	var rsp dh.PublicKey
+	if len(proto.Pattern.PreMessages()) > 0 {
+		rsp = rss.Public()
+	}
+
+	p1, p2 := handshake.NewInProcessPipe()
+	rec := &recorder{}
+
+	ihs, err := handshake.NewNoiseHandshake(proto, iss, rsp, rec.wrap(p1), true)
+	if err != nil {
+		return nil, err
+	}
+
+	rhs, err := handshake.NewNoiseHandshake(proto, rss, nil, rec.wrap(p2), false)
+	if err != nil {
+		return nil, err
+	}
+
+	ihs.SetRand(bytes.NewReader(v.InitiatorEphemeral))
+	rhs.SetRand(bytes.NewReader(v.ResponderEphemeral))
+
+	var is, rs handshake.Secret
+	var g errgroup.Group
+
+	g.Go(func() (err error) {
+		is, err = ihs.Secret(context.Background())
+		return err
+	})
+
+	g.Go(func() (err error) {
+		rs, err = rhs.Secret(context.Background())
+		return err
+	})
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(is, rs) {
+		return nil, fmt.Errorf("handshake hashes differ") //nolint:err113
+	}
+
+	v.Messages = rec.messages
+	v.Secret = Bytes(is)
+
+	return v, nil
+}
+
+func input(label string) Bytes {
+	digest := sha256.Sum256([]byte("hawkes test vector: " + label))
+	return digest[:]
+}
+
+// recorder records the messages written by both parties of a handshake.
+type recorder struct {
+	mu       sync.Mutex
+	messages []Bytes
+}
+
+func (r *recorder) wrap(rw io.ReadWriter) io.ReadWriter {
+	return &recordingPipe{rw, r}
+}
+
+type recordingPipe struct {
+	io.ReadWriter
+
+	recorder *recorder
+}
+
+func (p *recordingPipe) Write(b []byte) (int, error) {
+	// Handshake messages are strictly alternating so the order is deterministic
+	p.recorder.mu.Lock()
+	p.recorder.messages = append(p.recorder.messages, bytes.Clone(b))
+	p.recorder.mu.Unlock()
+
+	return p.ReadWriter.Write(b)
+}
+
+var _ provider.PrivateKeyHMAC = hmacKey(nil)
+
+// hmacKey is a software HMAC-SHA256 key.
+type hmacKey []byte
+
+func (k hmacKey) ID() provider.KeyID {
+	resp, _ := k.HMAC(nil)
+	return provider.KeyID(resp)
+}
+
+func (k hmacKey) Details() map[string]any {
+	return map[string]any{}
+}
+
+func (k hmacKey) Close() error {
+	return nil
+}
+
+func (k hmacKey) HMAC(challenge []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, k)
+	mac.Write(challenge)
+	return mac.Sum(nil), nil
+}
@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package testvectors_test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/handshake/testvectors"
+)
+
+const golden = "testdata/vectors.json"
+
+//nolint:gochecknoglobals
+var update = flag.Bool("update", false, "update golden files")
+
+func TestGolden(t *testing.T) {
+	require := require.New(t)
+
+	buf := &bytes.Buffer{}
+	err := testvectors.Write(buf)
+	require.NoError(err)
+
+	if *update {
+		err := os.WriteFile(golden, buf.Bytes(), 0o644) //nolint:gosec
+		require.NoError(err)
+	}
+
+	expected, err := os.ReadFile(golden)
+	require.NoError(err)
+	require.Equal(string(expected), buf.String(), "run 'go test ./handshake/testvectors -update' to update the golden file")
+}
+
+func TestOATH(t *testing.T) {
+	require := require.New(t)
+
+	expected, err := os.ReadFile(golden)
+	require.NoError(err)
+
+	var v testvectors.Vectors
+	err = json.Unmarshal(expected, &v)
+	require.NoError(err)
+	require.NotEmpty(v.OATH)
+	require.NotEmpty(v.Noise)
+
+	for _, ov := range v.OATH {
+		mac := hmac.New(sha256.New, ov.Key)
+		err := binary.Write(mac, binary.BigEndian, ov.Counter)
+		require.NoError(err)
+		require.Equal([]byte(ov.Secret), mac.Sum(nil))
+	}
+}
@@ -0,0 +1,80 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package atomicfile replaces files so that readers and crashes
+// never observe a partially written or lost file.
+package atomicfile
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// Write replaces the file at path with data readable only by the owner.
+// The data is written to a temporary file in the same directory
+// which is flushed to the disk before it is renamed over the file.
+// Afterwards, the directory is flushed so that the rename survives a crash.
+// Missing parent directories are created.
+func Write(path string, data []byte) error {
+	dir := filepath.Dir(path)
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	return SyncDir(dir)
+}
+
+// WriteJSON replaces the file at path with the indented JSON encoding of v (see Write).
+func WriteJSON(path string, v any) error {
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return Write(path, buf)
+}
+
+// SyncDir flushes the entries of a directory to the disk,
+// e.g. after renaming or removing files in it.
+// Windows can not flush directories but journals renames itself.
+func SyncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
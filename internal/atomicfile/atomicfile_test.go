@@ -0,0 +1,47 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package atomicfile_test
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/internal/atomicfile"
+)
+
+func TestWrite(t *testing.T) {
+	require := require.New(t)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sub", "state.json")
+
+	require.NoError(atomicfile.WriteJSON(path, map[string]int{"a": 1}))
+
+	buf, err := os.ReadFile(path)
+	require.NoError(err)
+	require.JSONEq(`{"a": 1}`, string(buf))
+
+	require.NoError(atomicfile.Write(path, []byte("replaced")))
+
+	buf, err = os.ReadFile(path)
+	require.NoError(err)
+	require.Equal("replaced", string(buf))
+
+	if runtime.GOOS != "windows" {
+		fi, err := os.Stat(path)
+		require.NoError(err)
+		require.Equal(os.FileMode(0o600), fi.Mode().Perm())
+	}
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(err)
+	require.Len(entries, 1)
+
+	require.Error(atomicfile.WriteJSON(path, func() {}))
+}
@@ -0,0 +1,306 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package cbor implements the subset of the Concise Binary Object Representation (RFC 8949)
+// which is required for COSE.
+//
+// Values are represented by the following Go types:
+// int64, uint64 (for values exceeding int64), []byte, string, []any,
+// map[any]any, Tag, bool and nil.
+package cbor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+var (
+	ErrUnsupportedType = errors.New("unsupported type")
+	ErrTruncated       = errors.New("truncated data")
+	ErrMalformed       = errors.New("malformed data")
+	ErrTooDeep         = errors.New("maximum nesting depth exceeded")
+)
+
+const maxDepth = 16
+
+const (
+	majorUnsigned byte = iota
+	majorNegative
+	majorBytes
+	majorText
+	majorArray
+	majorMap
+	majorTag
+	majorSimple
+)
+
+const (
+	simpleFalse byte = 20
+	simpleTrue  byte = 21
+	simpleNull  byte = 22
+)
+
+// Tag is a tagged data item.
+type Tag struct {
+	Number  uint64
+	Content any
+}
+
+// Marshal encodes a value using the core deterministic encoding requirements (RFC 8949 Section 4.2.1).
+func Marshal(v any) ([]byte, error) {
+	return appendValue(nil, v)
+}
+
+func appendHead(b []byte, major byte, n uint64) []byte {
+	m := major << 5
+
+	switch {
+	case n < 24:
+		return append(b, m|byte(n))
+	case n <= 0xff:
+		return append(b, m|24, byte(n))
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16(append(b, m|25), uint16(n))
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32(append(b, m|26), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, m|27), n)
+	}
+}
+
+func appendValue(b []byte, v any) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, majorSimple<<5|simpleNull), nil
+
+	case bool:
+		if v {
+			return append(b, majorSimple<<5|simpleTrue), nil
+		}
+
+		return append(b, majorSimple<<5|simpleFalse), nil
+
+	case int:
+		return appendValue(b, int64(v))
+
+	case int64:
+		if v < 0 {
+			return appendHead(b, majorNegative, uint64(-(v + 1))), nil
+		}
+
+		return appendHead(b, majorUnsigned, uint64(v)), nil
+
+	case uint64:
+		return appendHead(b, majorUnsigned, v), nil
+
+	case []byte:
+		return append(appendHead(b, majorBytes, uint64(len(v))), v...), nil
+
+	case string:
+		return append(appendHead(b, majorText, uint64(len(v))), v...), nil
+
+	case []any:
+		var err error
+
+		b = appendHead(b, majorArray, uint64(len(v)))
+		for _, e := range v {
+			if b, err = appendValue(b, e); err != nil {
+				return nil, err
+			}
+		}
+
+		return b, nil
+
+	case map[any]any:
+		// Keys are sorted by the bytewise lexicographic order of their encoding
+		type entry struct{ k, v []byte }
+
+		entries := make([]entry, 0, len(v))
+		for k, e := range v {
+			kb, err := Marshal(k)
+			if err != nil {
+				return nil, err
+			}
+
+			vb, err := Marshal(e)
+			if err != nil {
+				return nil, err
+			}
+
+			entries = append(entries, entry{kb, vb})
+		}
+
+		slices.SortFunc(entries, func(a, b entry) int {
+			return bytes.Compare(a.k, b.k)
+		})
+
+		b = appendHead(b, majorMap, uint64(len(v)))
+		for _, e := range entries {
+			b = append(append(b, e.k...), e.v...)
+		}
+
+		return b, nil
+
+	case Tag:
+		return appendValue(appendHead(b, majorTag, v.Number), v.Content)
+	}
+
+	return nil, fmt.Errorf("%w: %T", ErrUnsupportedType, v)
+}
+
+// Unmarshal decodes a single data item and returns it with the remaining data.
+// Indefinite-length items and floating-point numbers are not supported.
+func Unmarshal(b []byte) (v any, rest []byte, err error) {
+	return decode(b, 0)
+}
+
+func decodeHead(b []byte) (major byte, n uint64, rest []byte, err error) {
+	if len(b) < 1 {
+		return 0, 0, nil, ErrTruncated
+	}
+
+	major = b[0] >> 5
+	info := b[0] & 0x1f
+	b = b[1:]
+
+	switch {
+	case info < 24:
+		return major, uint64(info), b, nil
+	case info == 24:
+		if len(b) < 1 {
+			return 0, 0, nil, ErrTruncated
+		}
+
+		return major, uint64(b[0]), b[1:], nil
+	case info == 25:
+		if len(b) < 2 {
+			return 0, 0, nil, ErrTruncated
+		}
+
+		return major, uint64(binary.BigEndian.Uint16(b)), b[2:], nil
+	case info == 26:
+		if len(b) < 4 {
+			return 0, 0, nil, ErrTruncated
+		}
+
+		return major, uint64(binary.BigEndian.Uint32(b)), b[4:], nil
+	case info == 27:
+		if len(b) < 8 {
+			return 0, 0, nil, ErrTruncated
+		}
+
+		return major, binary.BigEndian.Uint64(b), b[8:], nil
+	}
+
+	return 0, 0, nil, fmt.Errorf("%w: unsupported additional information %d", ErrMalformed, info)
+}
+
+func decode(b []byte, depth int) (any, []byte, error) {
+	if depth > maxDepth {
+		return nil, nil, ErrTooDeep
+	}
+
+	major, n, b, err := decodeHead(b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case majorUnsigned:
+		if n > 1<<63-1 {
+			return n, b, nil
+		}
+
+		return int64(n), b, nil
+
+	case majorNegative:
+		if n > 1<<63-1 {
+			return nil, nil, fmt.Errorf("%w: negative integer overflow", ErrMalformed)
+		}
+
+		return -1 - int64(n), b, nil
+
+	case majorBytes, majorText:
+		if uint64(len(b)) < n {
+			return nil, nil, ErrTruncated
+		}
+
+		if major == majorText {
+			return string(b[:n]), b[n:], nil
+		}
+
+		return bytes.Clone(b[:n]), b[n:], nil
+
+	case majorArray:
+		// Each item requires at least one byte
+		if uint64(len(b)) < n {
+			return nil, nil, ErrTruncated
+		}
+
+		a := make([]any, 0, n)
+		for range n {
+			var e any
+			if e, b, err = decode(b, depth+1); err != nil {
+				return nil, nil, err
+			}
+
+			a = append(a, e)
+		}
+
+		return a, b, nil
+
+	case majorMap:
+		if uint64(len(b)) < 2*n {
+			return nil, nil, ErrTruncated
+		}
+
+		m := make(map[any]any, n)
+		for range n {
+			var k, e any
+			if k, b, err = decode(b, depth+1); err != nil {
+				return nil, nil, err
+			}
+
+			switch k.(type) {
+			case int64, uint64, string:
+			default:
+				return nil, nil, fmt.Errorf("%w: unsupported map key type %T", ErrMalformed, k)
+			}
+
+			if e, b, err = decode(b, depth+1); err != nil {
+				return nil, nil, err
+			}
+
+			if _, dup := m[k]; dup {
+				return nil, nil, fmt.Errorf("%w: duplicate map key", ErrMalformed)
+			}
+
+			m[k] = e
+		}
+
+		return m, b, nil
+
+	case majorTag:
+		c, b, err := decode(b, depth+1)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return Tag{Number: n, Content: c}, b, nil
+
+	case majorSimple:
+		switch byte(n) {
+		case simpleFalse:
+			return false, b, nil
+		case simpleTrue:
+			return true, b, nil
+		case simpleNull:
+			return nil, b, nil
+		}
+	}
+
+	return nil, nil, fmt.Errorf("%w: unsupported major type %d", ErrMalformed, major)
+}
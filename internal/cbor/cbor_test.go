@@ -0,0 +1,79 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package cbor_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/internal/cbor"
+)
+
+func TestMarshal(t *testing.T) {
+	// Examples from RFC 8949 Appendix A
+	for _, tc := range []struct {
+		value any
+		hex   string
+	}{
+		{0, "00"},
+		{23, "17"},
+		{24, "1818"},
+		{1000000, "1a000f4240"},
+		{uint64(18446744073709551615), "1bffffffffffffffff"},
+		{-1, "20"},
+		{-1000, "3903e7"},
+		{false, "f4"},
+		{nil, "f6"},
+		{[]byte{1, 2, 3, 4}, "4401020304"},
+		{"IETF", "6449455446"},
+		{[]any{1, []any{2, 3}}, "820182020 3"},
+		{map[any]any{int64(1): 2, int64(3): 4}, "a201020304"},
+		{map[any]any{"b": 1, "a": 2}, "a261610261620 1"},
+		{cbor.Tag{Number: 1, Content: 1363896240}, "c11a514b67b0"},
+	} {
+		enc, err := cbor.Marshal(tc.value)
+		require.NoError(t, err)
+
+		expected, err := hex.DecodeString(stripSpaces(tc.hex))
+		require.NoError(t, err)
+		require.Equal(t, expected, enc, "%v", tc.value)
+
+		dec, rest, err := cbor.Unmarshal(enc)
+		require.NoError(t, err)
+		require.Empty(t, rest)
+
+		reenc, err := cbor.Marshal(dec)
+		require.NoError(t, err)
+		require.Equal(t, enc, reenc)
+	}
+}
+
+func stripSpaces(s string) string {
+	out := []byte{}
+	for i := range len(s) {
+		if s[i] != ' ' {
+			out = append(out, s[i])
+		}
+	}
+
+	return string(out)
+}
+
+func FuzzUnmarshal(f *testing.F) {
+	f.Add([]byte{0xa2, 0x01, 0x02, 0x03, 0x04})
+	f.Add([]byte{0xd2, 0x84, 0x43, 0xa1, 0x01, 0x26, 0xa0, 0xf6, 0x40})
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		v, _, err := cbor.Unmarshal(b)
+		if err != nil {
+			return
+		}
+
+		if _, err := cbor.Marshal(v); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
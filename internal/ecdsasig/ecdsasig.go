@@ -0,0 +1,72 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package ecdsasig converts ECDSA signatures between the ASN.1 encoding
+// of crypto.Signer and the fixed-size R || S encoding used by JOSE and COSE.
+package ecdsasig
+
+import (
+	"crypto/ecdsa"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var ErrInvalidSignature = errors.New("invalid signature")
+
+type signature struct {
+	R, S *big.Int
+}
+
+// Size returns the size of R and S for the curve of the public key.
+func Size(pub *ecdsa.PublicKey) int {
+	return (pub.Curve.Params().BitSize + 7) / 8
+}
+
+// ToRaw converts an ASN.1 encoded signature to R || S with n bytes each.
+func ToRaw(sig []byte, n int) ([]byte, error) {
+	var s signature
+	if rest, err := asn1.Unmarshal(sig, &s); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	} else if len(rest) > 0 {
+		return nil, fmt.Errorf("%w: trailing data", ErrInvalidSignature)
+	}
+
+	if s.R.Sign() <= 0 || s.S.Sign() <= 0 || s.R.BitLen() > 8*n || s.S.BitLen() > 8*n {
+		return nil, ErrInvalidSignature
+	}
+
+	raw := make([]byte, 2*n)
+	s.R.FillBytes(raw[:n])
+	s.S.FillBytes(raw[n:])
+
+	return raw, nil
+}
+
+// Verify checks a R || S encoded signature.
+func Verify(pub *ecdsa.PublicKey, digest, raw []byte) bool {
+	n := Size(pub)
+	if len(raw) != 2*n {
+		return false
+	}
+
+	r := new(big.Int).SetBytes(raw[:n])
+	s := new(big.Int).SetBytes(raw[n:])
+
+	return ecdsa.Verify(pub, digest, r, s)
+}
+
+// FromRaw converts a R || S encoded signature to the ASN.1 encoding.
+func FromRaw(raw []byte) ([]byte, error) {
+	if len(raw) == 0 || len(raw)%2 != 0 {
+		return nil, ErrInvalidSignature
+	}
+
+	n := len(raw) / 2
+
+	return asn1.Marshal(signature{
+		R: new(big.Int).SetBytes(raw[:n]),
+		S: new(big.Int).SetBytes(raw[n:]),
+	})
+}
@@ -0,0 +1,39 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package flock serializes read-modify-write cycles of files across processes.
+package flock
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Lock takes an exclusive advisory lock on a file next to path with the suffix ".lock".
+// The lock file is kept, as files which are replaced atomically can not be locked themselves.
+// It blocks until the lock is acquired and returns a function releasing it.
+// Missing parent directories are created.
+func Lock(path string) (func() error, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lock(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return func() error {
+		if err := unlock(f); err != nil {
+			f.Close()
+			return err
+		}
+
+		return f.Close()
+	}, nil
+}
@@ -0,0 +1,50 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package flock_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/internal/flock"
+)
+
+func TestLock(t *testing.T) {
+	require := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "sub", "state.json")
+
+	unlock, err := flock.Lock(path)
+	require.NoError(err)
+
+	// Locks of separate open files exclude each other like those of other processes
+	var unlock2 func() error
+
+	locked := make(chan error)
+
+	go func() {
+		var err error
+		unlock2, err = flock.Lock(path)
+		locked <- err
+	}()
+
+	select {
+	case <-locked:
+		require.Fail("lock has been acquired twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	require.NoError(unlock())
+
+	select {
+	case err := <-locked:
+		require.NoError(err)
+		require.NoError(unlock2())
+	case <-time.After(5 * time.Second):
+		require.Fail("lock has not been released")
+	}
+}
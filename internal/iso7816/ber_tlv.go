@@ -5,11 +5,10 @@ package iso7816
 
 import (
 	"errors"
-	"fmt"
 )
 
 var (
-	errInvalidTag    = fmt.Errorf("invalid tag")
+	errInvalidTag    = errors.New("invalid tag")
 	errInvalidLength = errors.New("invalid length")
 )
 
@@ -25,29 +24,46 @@ func EncodeTLV(t Tag, data []byte) (b []byte) {
 	return b
 }
 
+// DecodeTag decodes a BER-TLV tag from the beginning of b.
+// It returns TagInvalid if b does not start with a complete tag.
 func DecodeTag(b []byte) (Tag, []byte) {
+	if len(b) < 1 {
+		return TagInvalid, nil
+	}
+
 	// ISO 7816-4 Section 5.2.2.1 BER-TLV tag fields
 	switch {
 	case b[0]&0x1f != 0x1f:
 		return Tag(b[0]), b[1:]
+	case len(b) < 2:
+		return TagInvalid, nil
 	case b[1]&0x80 == 0 && b[1]&0x7f > 30:
 		return Tag(uint32(b[0])<<8 | uint32(b[1])), b[2:]
+	case len(b) < 3:
+		return TagInvalid, nil
 	case b[1]&0x80 == 0x80 && b[1]&0x7f != 0:
-		return Tag(uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[0])), b[3:]
+		return Tag(uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])), b[3:]
 	}
 
-	return 0, nil
+	return TagInvalid, nil
 }
 
+// DecodeLength decodes a BER-TLV length field from the beginning of b.
 func DecodeLength(b []byte) (int, []byte, error) {
+	if len(b) < 1 {
+		return -1, nil, errInvalidLength
+	}
+
 	// Short form
 	if b[0] <= 0x7f {
 		return int(b[0]), b[1:], nil
 	}
 
 	// Long form
+	// We do not support the indefinite form (0x80) and lengths which
+	// exceed the range of 3 bytes (ISO 7816-4 Section 5.2.2.2)
 	n := int(b[0] & 0x7f)
-	if len(b) < n+1 {
+	if n < 1 || n > 3 || len(b) < n+1 {
 		return -1, nil, errInvalidLength
 	}
 
@@ -60,6 +76,8 @@ func DecodeLength(b []byte) (int, []byte, error) {
 	return l, b[n+1:], nil
 }
 
+// DecodeTLV decodes a single BER-TLV data object from the beginning of b.
+// It returns the tag t, the value v and the remaining bytes c following the data object.
 func DecodeTLV(b []byte) (t Tag, v, c []byte, err error) {
 	var l int
 
@@ -71,16 +89,26 @@ func DecodeTLV(b []byte) (t Tag, v, c []byte, err error) {
 		return 0, nil, nil, err
 	}
 
+	if len(b) < l {
+		return 0, nil, nil, errInvalidLength
+	}
+
 	return t, b[:l], b[l:], nil
 }
 
+// DecodeCompactTLV decodes a single COMPACT-TLV data object from the beginning of b.
+// It returns the tag t, the value v and the remaining bytes c following the data object.
 func DecodeCompactTLV(b []byte) (t CompactTag, v, c []byte, err error) {
 	if len(b) < 1 {
 		return 0, nil, nil, errInvalidLength
 	}
 
 	t = CompactTag(b[0] >> 4)
-	l := b[0] & 0xf
+	l := int(b[0] & 0xf)
+
+	if len(b) < 1+l {
+		return 0, nil, nil, errInvalidLength
+	}
 
-	return t, v[1 : 1+l], v[1+l:], nil
+	return t, b[1 : 1+l], b[1+l:], nil
 }
@@ -2,3 +2,81 @@
 // SPDX-License-Identifier: Apache-2.0
 
 package iso7816_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	iso "cunicu.li/hawkes/internal/iso7816"
+)
+
+func TestDecodeTLV(t *testing.T) {
+	require := require.New(t)
+
+	tag, v, c, err := iso.DecodeTLV([]byte{0x5f, 0x2d, 0x02, 'd', 'e', 0x01})
+	require.NoError(err)
+	require.Equal(iso.Tag(0x5f2d), tag)
+	require.Equal([]byte("de"), v)
+	require.Equal([]byte{0x01}, c)
+
+	tag, v, c, err = iso.DecodeTLV([]byte{0x7f, 0x81, 0x21, 0x81, 0x01, 0xaa})
+	require.NoError(err)
+	require.Equal(iso.Tag(0x7f8121), tag)
+	require.Equal([]byte{0xaa}, v)
+	require.Empty(c)
+
+	for _, b := range [][]byte{
+		{},
+		{0x5f},
+		{0x4f},
+		{0x4f, 0x02, 0x00},
+		{0x4f, 0x80},
+		{0x4f, 0x85, 0x00, 0x00, 0x00, 0x00, 0x01},
+	} {
+		_, _, _, err = iso.DecodeTLV(b)
+		require.Error(err, "% x", b)
+	}
+}
+
+func FuzzDecodeTLV(f *testing.F) {
+	f.Add([]byte{0x4f, 0x02, 0x01, 0x02})
+	f.Add([]byte{0x5f, 0x2d, 0x02, 'd', 'e'})
+	f.Add([]byte{0x7f, 0x81, 0x21, 0x81, 0x01, 0xaa})
+	f.Add([]byte{0x6e, 0x82, 0x00, 0x01, 0x00})
+	f.Add([]byte{0x5f})
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		tag, v, c, err := iso.DecodeTLV(b)
+		if err != nil {
+			return
+		}
+
+		if tag == iso.TagInvalid {
+			t.Fatal("decoded invalid tag without error")
+		}
+
+		if len(v)+len(c) > len(b) {
+			t.Fatalf("decoded more bytes than available: %d + %d > %d", len(v), len(c), len(b))
+		}
+	})
+}
+
+func FuzzDecodeCompactTLV(f *testing.F) {
+	f.Add([]byte{0x73, 0xc0, 0x01, 0x80})
+	f.Add([]byte{0x31, 0xc0})
+	f.Add([]byte{0x0f})
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		_, v, c, err := iso.DecodeCompactTLV(b)
+		if err != nil {
+			return
+		}
+
+		if len(v)+len(c) != len(b)-1 {
+			t.Fatalf("invalid split: %d + %d != %d", len(v), len(c), len(b)-1)
+		}
+	})
+}
@@ -0,0 +1,49 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package keywrap_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/internal/keywrap"
+)
+
+// See: RFC 3394 Section 4
+func TestVectors(t *testing.T) {
+	for _, v := range []struct {
+		kek, key, wrapped string
+	}{
+		{
+			"000102030405060708090A0B0C0D0E0F",
+			"00112233445566778899AABBCCDDEEFF",
+			"1FA68B0A8112B447AEF34BD8FB5A7B829D3E862371D2CFE5",
+		},
+		{
+			"000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F",
+			"00112233445566778899AABBCCDDEEFF000102030405060708090A0B0C0D0E0F",
+			"28C9F404C4B810F4CBCCB35CFB87F8263F5786E2D80ED326CBC7F0E71A99F43BFB988B9B7A02DD21",
+		},
+	} {
+		require := require.New(t)
+
+		kek, _ := hex.DecodeString(v.kek)
+		key, _ := hex.DecodeString(v.key)
+		expected, _ := hex.DecodeString(v.wrapped)
+
+		wrapped, err := keywrap.Wrap(kek, key)
+		require.NoError(err)
+		require.Equal(expected, wrapped)
+
+		unwrapped, err := keywrap.Unwrap(kek, wrapped)
+		require.NoError(err)
+		require.Equal(key, unwrapped)
+
+		wrapped[0] ^= 1
+		_, err = keywrap.Unwrap(kek, wrapped)
+		require.ErrorIs(err, keywrap.ErrIntegrity)
+	}
+}
@@ -1,6 +1,8 @@
 // SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
 // SPDX-License-Identifier: Apache-2.0
 
+//go:build (cgo || windows) && !nopcsc
+
 package openpgp
 
 import (
@@ -17,6 +19,7 @@ import (
 var (
 	errResponseTooLarge = errors.New("expected data response too large")
 	errCommandTooLarge  = errors.New("command data too large")
+	errInvalidSlot      = errors.New("invalid key slot")
 )
 
 type Card struct {
@@ -98,7 +101,10 @@ func (c *Card) communicate(ins iso.Instruction, p1, p2 byte, data []byte, lenExp
 
 	log.Printf("<- Received response APDU: %s", hex.EncodeToString(resp))
 
-	resp, sw1, sw2 := decodeResponse(resp)
+	resp, sw1, sw2, err := decodeResponse(resp)
+	if err != nil {
+		return nil, err
+	}
 
 	// t_ans = (time.time() - t_env) * 1000
 	// logger.debug(
@@ -117,7 +123,9 @@ func (c *Card) communicate(ins iso.Instruction, p1, p2 byte, data []byte, lenExp
 			return nil, err
 		}
 
-		respRem, sw1, sw2 = decodeResponse(respRem)
+		if respRem, sw1, sw2, err = decodeResponse(respRem); err != nil {
+			return nil, err
+		}
 
 		//     t_ans = (time.time() - t_env) * 1000
 		//     logger.debug(
@@ -142,8 +150,6 @@ func (c *Card) send(ins iso.Instruction, p1, p2 byte, data []byte) error {
 }
 
 // See: OpenPGP Smart Card Application - Section 7.2.5 SELECT DATA
-//
-//nolint:unused
 func (c *Card) selectData(t iso.Tag, idx byte) error {
 	data := iso.EncodeTLV(0x60,
 		iso.EncodeTLV(0x5c, t.Bytes()))
@@ -168,7 +174,6 @@ func (c *Card) getNextData(_ iso.Tag) ([]byte, error) {
 	return c.communicate(insGetNextData, 0x7f, 0x21, nil, c.longer)
 }
 
-//nolint:unused
 func (c *Card) getDataIndex(t iso.Tag, i byte) ([]byte, error) {
 	if err := c.selectData(t, i); err != nil {
 		return nil, err
@@ -242,14 +247,42 @@ func (c *Card) GetCardholder() (ch Cardholder, err error) {
 	return ch, ch.Decode(resp)
 }
 
+// GetCertificate returns the certificate stored for a key slot.
+// For the Yubico attestation key this is the attestation certificate.
+// For the other slots it is the cardholder certificate which holds the
+// attestation statement if one has been generated for the key.
+// See: OpenPGP Smart Card Application - Section 4.4.3.13 Cardholder certificate
+func (c *Card) GetCertificate(slot Slot) ([]byte, error) {
+	// Cardholder certificates are ordered AUT, DEC, SIG
+	switch slot {
+	case SlotAuthn:
+		return c.getDataIndex(tagCert, 0)
+	case SlotDecrypt:
+		return c.getDataIndex(tagCert, 1)
+	case SlotSign:
+		return c.getDataIndex(tagCert, 2)
+	case SlotAttest:
+		return c.getData(tagCertAtt)
+	default:
+		return nil, errInvalidSlot
+	}
+}
+
 // See: OpenPGP Smart Card Application - Section 7.2.15 GET CHALLENGE
 func (c *Card) GetChallenge(cnt int) ([]byte, error) {
 	return c.communicate(iso.InsGetChallenge, 0x00, 0x00, nil, cnt)
 }
 
 // See: OpenPGP Smart Card Application - Section 7.2.10 PSO: COMPUTE DIGITAL SIGNATURE
-func (c *Card) Sign(_ []byte) ([]byte, error) {
-	return nil, nil // TODO
+//
+// For RSA keys data is the DigestInfo, for ECDSA and EdDSA keys the digest or message.
+func (c *Card) Sign(data []byte) ([]byte, error) {
+	return c.communicate(iso.InsPerformSecurityOperation, 0x9e, 0x9a, data, apduShort)
+}
+
+// See: OpenPGP Smart Card Application - Section 7.2.13 INTERNAL AUTHENTICATE
+func (c *Card) Authenticate(data []byte) ([]byte, error) {
+	return c.communicate(iso.InsInternalAuthenticate, 0x00, 0x00, data, apduShort)
 }
 
 // See: OpenPGP Smart Card Application - Section 7.2.12 PSO: ENCIPHER
@@ -258,8 +291,13 @@ func (c *Card) Encipher(_ []byte) ([]byte, error) {
 }
 
 // See: OpenPGP Smart Card Application - Section 7.2.11 PSO: DECIPHER
-func (c *Card) Decipher(_ []byte) ([]byte, error) {
-	return nil, nil // TODO
+//
+// The ciphertext is RSA encrypted and the card removes the PKCS #1 padding.
+func (c *Card) Decipher(ct []byte) ([]byte, error) {
+	// The padding indicator byte 0x00 precedes RSA cryptograms
+	data := append([]byte{0x00}, ct...)
+
+	return c.communicate(iso.InsPerformSecurityOperation, 0x80, 0x86, data, apduShort)
 }
 
 // See: OpenPGP Smart Card Application - Section 7.2.11 PSO: DECIPHER
@@ -348,13 +386,3 @@ func (c *Card) ResetRetryCounterWithResetCode(pw, rc string) error {
 
 	return c.send(iso.InsResetRetryCounter, 0x00, PW1, []byte(rc+pw))
 }
-
-func decodeResponse(resp []byte) (data []byte, sw1 byte, sw2 byte) {
-	lenResp := len(resp)
-
-	sw1 = resp[lenResp-2]
-	sw2 = resp[lenResp-1]
-	data = resp[:lenResp-2]
-
-	return data, sw1, sw2
-}
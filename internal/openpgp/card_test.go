@@ -1,6 +1,8 @@
 // SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
 // SPDX-License-Identifier: Apache-2.0
 
+//go:build (cgo || windows) && !nopcsc
+
 package openpgp_test
 
 import (
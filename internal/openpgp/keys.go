@@ -0,0 +1,131 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build (cgo || windows) && !nopcsc
+
+package openpgp
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/katzenpost/nyquist/dh"
+
+	ecdhx "cunicu.li/hawkes/ecdh"
+	"cunicu.li/hawkes/internal/ecdsasig"
+)
+
+var (
+	_ crypto.Signer    = (*PrivateKey)(nil)
+	_ crypto.Decrypter = (*PrivateKey)(nil)
+
+	errUnsupportedKey  = errors.New("unsupported key type")
+	errUnsupportedHash = errors.New("unsupported hash")
+)
+
+// digestInfoPrefixes are the DER-encoded DigestInfo headers which are prepended
+// to the digest for RSA signatures (RFC 8017 Section 9.2).
+//
+//nolint:gochecknoglobals
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// PrivateKey is a key held in one of the slots of the card.
+//
+// Depending on the slot and key type it implements crypto.Signer,
+// crypto.Decrypter or ecdh.PrivateKey. As the card does not expose
+// the public keys of its slots, it must be provided by the caller.
+type PrivateKey struct {
+	card   *Card
+	slot   Slot
+	public crypto.PublicKey
+}
+
+// PrivateKey returns the private key in the given slot.
+func (c *Card) PrivateKey(slot Slot, pub crypto.PublicKey) *PrivateKey {
+	return &PrivateKey{
+		card:   c,
+		slot:   slot,
+		public: pub,
+	}
+}
+
+func (k *PrivateKey) Public() crypto.PublicKey {
+	return k.public
+}
+
+// Sign signs the digest with the signature or authentication key.
+func (k *PrivateKey) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) (sig []byte, err error) {
+	var data []byte
+
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		prefix, ok := digestInfoPrefixes[opts.HashFunc()]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", errUnsupportedHash, opts.HashFunc())
+		}
+
+		data = append(prefix[:len(prefix):len(prefix)], digest...)
+
+	case *ecdsa.PublicKey:
+		// The card truncates the digest to the size of the curve
+		data = digest
+
+	case ed25519.PublicKey:
+		if opts.HashFunc() != crypto.Hash(0) {
+			return nil, fmt.Errorf("%w: %s", errUnsupportedHash, opts.HashFunc())
+		}
+
+		data = digest
+
+	default:
+		return nil, fmt.Errorf("%w: %T", errUnsupportedKey, pub)
+	}
+
+	switch k.slot {
+	case SlotSign:
+		sig, err = k.card.Sign(data)
+	case SlotAuthn:
+		sig, err = k.card.Authenticate(data)
+	default:
+		return nil, fmt.Errorf("%w: slot %d can not sign", errUnsupportedKey, k.slot)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// crypto.Signer expects ASN.1 encoded ECDSA signatures
+	if _, ok := k.public.(*ecdsa.PublicKey); ok {
+		return ecdsasig.FromRaw(sig)
+	}
+
+	return sig, nil
+}
+
+// Decrypt decrypts a PKCS #1 v1.5 encrypted message with the RSA decryption key.
+func (k *PrivateKey) Decrypt(_ io.Reader, msg []byte, _ crypto.DecrypterOpts) ([]byte, error) {
+	if _, ok := k.public.(*rsa.PublicKey); !ok || k.slot != SlotDecrypt {
+		return nil, fmt.Errorf("%w: %T in slot %d can not decrypt", errUnsupportedKey, k.public, k.slot)
+	}
+
+	return k.card.Decipher(msg)
+}
+
+// DH calculates the shared secret with the ECDH decryption key.
+func (k *PrivateKey) DH(pk dh.PublicKey) ([]byte, error) {
+	ecpk, ok := pk.(*ecdhx.PublicKey)
+	if !ok || k.slot != SlotDecrypt {
+		return nil, fmt.Errorf("%w: %T", errUnsupportedKey, pk)
+	}
+
+	return k.card.CalculateSharedSecret(ecpk.Bytes())
+}
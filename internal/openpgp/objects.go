@@ -95,7 +95,12 @@ type HistoricalBytes struct {
 }
 
 func (h *HistoricalBytes) Decode(b []byte) (err error) {
+	if len(b) < 1 {
+		return errInvalidLength
+	}
+
 	h.CategoryIndicator = b[0]
+	b = b[1:]
 
 	switch h.CategoryIndicator {
 	case 0x10:
@@ -103,6 +108,10 @@ func (h *HistoricalBytes) Decode(b []byte) (err error) {
 
 	case 0x00:
 		lb := len(b)
+		if lb < 3 {
+			return errInvalidLength
+		}
+
 		h.StatusIndicator = b[lb-3:]
 		b = b[:lb-3]
 		fallthrough
@@ -277,7 +286,7 @@ func (ar *ApplicationRelated) Decode(b []byte) (err error) {
 					ar.Keys[SlotAttest].GenerationTime = decodeTime(x[0:])
 
 				case tagKeyInfo:
-					for i := 0; i < len(x)/2; i++ {
+					for i := 0; i < len(x)/2 && i < len(ar.Keys); i++ {
 						ar.Keys[i].Reference = x[i*2+0]
 						ar.Keys[i].Status = x[i*2+1]
 					}
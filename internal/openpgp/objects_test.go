@@ -0,0 +1,85 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package openpgp
+
+import (
+	"io"
+	"log"
+	"log/slog"
+	"testing"
+)
+
+// Decoders log unknown tags which would flood the output while fuzzing
+func quiet(tb testing.TB) {
+	tb.Helper()
+
+	logger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	logWriter := log.Writer()
+	log.SetOutput(io.Discard)
+
+	tb.Cleanup(func() {
+		slog.SetDefault(logger)
+		log.SetOutput(logWriter)
+	})
+}
+
+func FuzzDecodeResponse(f *testing.F) {
+	f.Add([]byte{0x90, 0x00})
+	f.Add([]byte{0x01, 0x02, 0x61, 0x10})
+	f.Add([]byte{0x6a})
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		data, _, _, err := decodeResponse(b)
+		if err != nil {
+			return
+		}
+
+		if len(data) != len(b)-2 {
+			t.Fatalf("invalid data length: %d != %d", len(data), len(b)-2)
+		}
+	})
+}
+
+func FuzzApplicationRelatedDecode(f *testing.F) {
+	quiet(f)
+
+	f.Add([]byte{0x6e, 0x00})
+	f.Add([]byte{0x6e, 0x05, 0x73, 0x03, 0xde, 0x01, 0x07})
+	f.Add([]byte{0x6e, 0x0c, 0x73, 0x0a, 0xde, 0x08, 0x01, 0x01, 0x02, 0x01, 0x03, 0x01, 0x81, 0x01})
+	f.Add([]byte{0x6e, 0x06, 0x5f, 0x52, 0x03, 0x00, 0x73, 0x00})
+	f.Add([]byte{0x6e, 0x09, 0x5f, 0x52, 0x06, 0x00, 0x31, 0xc5, 0x05, 0x90, 0x00})
+	f.Add([]byte{0x6e, 0x05, 0x73, 0x03, 0xc4, 0x01, 0x00})
+
+	f.Fuzz(func(_ *testing.T, b []byte) {
+		var ar ApplicationRelated
+		_ = ar.Decode(b)
+	})
+}
+
+func FuzzCardholderDecode(f *testing.F) {
+	quiet(f)
+
+	f.Add([]byte{0x65, 0x08, 0x5b, 0x03, 'D', 'o', 'e', 0x5f, 0x35, 0x00})
+	f.Add([]byte{0x65, 0x05, 0x5f, 0x2d, 0x02, 'd', 'e'})
+
+	f.Fuzz(func(_ *testing.T, b []byte) {
+		var ch Cardholder
+		_ = ch.Decode(b)
+	})
+}
+
+func FuzzSecuritySupportTemplateDecode(f *testing.F) {
+	quiet(f)
+
+	f.Add([]byte{0x7a, 0x05, 0x93, 0x03, 0x00, 0x00, 0x01})
+	f.Add([]byte{0x7a, 0x02, 0x93, 0x00})
+
+	f.Fuzz(func(_ *testing.T, b []byte) {
+		var sst SecuritySupportTemplate
+		_ = sst.Decode(b)
+	})
+}
@@ -5,3 +5,17 @@
 // on ISO Smart Card Operating Systems v3.4.1
 // See: https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.1.pdf
 package openpgp
+
+// decodeResponse splits a response APDU into its data field and the trailing status bytes.
+func decodeResponse(resp []byte) (data []byte, sw1 byte, sw2 byte, err error) {
+	lenResp := len(resp)
+	if lenResp < 2 {
+		return nil, 0, 0, errInvalidResponse
+	}
+
+	sw1 = resp[lenResp-2]
+	sw2 = resp[lenResp-1]
+	data = resp[:lenResp-2]
+
+	return data, sw1, sw2, nil
+}
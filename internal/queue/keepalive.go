@@ -0,0 +1,94 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"context"
+	"time"
+
+	"cunicu.li/go-iso7816"
+)
+
+// OnKeepAlive registers a ping which is run by KeepAlive while the card is idle.
+// The ping should send a harmless command or re-select the applet of its user.
+// It is a no-op for cards without a shared queue.
+func OnKeepAlive(card iso7816.PCSCCard, ping func()) {
+	switch card := card.(type) {
+	case *Card:
+		card.pings = append(card.pings, ping)
+	case *LazyCard:
+		card.pings = append(card.pings, ping)
+	}
+}
+
+// KeepAlive runs the registered pings whenever no operation has been
+// completed for the given interval. This keeps readers and cards which
+// deselect applets or power down after idle periods active.
+//
+// Lazy cards which have been disconnected are not connected for pings
+// and pings do not delay their idle disconnect.
+// The returned function stops the keep-alive.
+func (q *Queue) KeepAlive(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				q.ping(interval)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
+
+func (q *Queue) ping(interval time.Duration) {
+	ctx := context.Background()
+	if q.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, q.timeout)
+		defer cancel()
+	}
+
+	select {
+	case <-q.token:
+	case <-ctx.Done():
+		return
+	}
+
+	defer func() {
+		q.token <- struct{}{}
+	}()
+
+	if len(q.pings) == 0 || time.Since(q.last) < interval {
+		return
+	}
+
+	// The session is not connected as this would reset the idle timer of lazy cards
+	if q.session != nil && !q.session.Connected() {
+		return
+	}
+
+	if q.locker != nil {
+		if err := q.lock(ctx); err != nil {
+			return
+		}
+
+		defer q.locker.Unlock() //nolint:errcheck
+	}
+
+	for _, ping := range q.pings {
+		ping()
+	}
+
+	q.last = time.Now()
+}
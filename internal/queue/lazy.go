@@ -0,0 +1,193 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"cunicu.li/go-iso7816"
+)
+
+var ErrDisconnected = errors.New("card is disconnected")
+
+var (
+	_ iso7816.PCSCCard = (*LazyCard)(nil)
+	_ Locker           = (*LazyCard)(nil)
+)
+
+// LazyCard is a card which is connected on the first operation of its queue
+// and disconnected after it has been idle for a configurable period.
+// This avoids holding the card exclusively in long-running processes.
+//
+// Users which keep state of the connection such as selected applets
+// must register a callback via OnDisconnect.
+type LazyCard struct {
+	*Queue
+
+	open func() (iso7816.PCSCCard, error)
+	idle time.Duration
+
+	card  iso7816.PCSCCard
+	timer *time.Timer
+	gen   uint64
+
+	onDisconnect []func()
+}
+
+// NewLazyCard creates a new card which is connected via open on first use.
+// An idle period of zero keeps the card connected until it is closed.
+func NewLazyCard(open func() (iso7816.PCSCCard, error), timeout, idle time.Duration) *LazyCard {
+	c := &LazyCard{
+		Queue: New(timeout),
+		open:  open,
+		idle:  idle,
+	}
+
+	c.session = c
+	c.locker = c
+
+	return c
+}
+
+// OnDisconnect registers a callback which is invoked before a lazy card gets disconnected.
+// It is a no-op for other cards.
+func OnDisconnect(card iso7816.PCSCCard, cb func()) {
+	if lc, ok := card.(*LazyCard); ok {
+		lc.onDisconnect = append(lc.onDisconnect, cb)
+	}
+}
+
+// IsLazy returns true if the card is connected on first use.
+func IsLazy(card iso7816.PCSCCard) bool {
+	_, ok := card.(*LazyCard)
+	return ok
+}
+
+// Connected returns true if the card is currently connected.
+// It must only be called from within an operation of the queue.
+func (c *LazyCard) Connected() bool {
+	return c.card != nil
+}
+
+func (c *LazyCard) Transmit(cmd []byte) ([]byte, error) {
+	if c.card == nil {
+		return nil, ErrDisconnected
+	}
+
+	return c.card.Transmit(cmd)
+}
+
+func (c *LazyCard) BeginTransaction() error {
+	if c.card == nil {
+		return ErrDisconnected
+	}
+
+	return c.card.BeginTransaction()
+}
+
+func (c *LazyCard) EndTransaction() error {
+	if c.card == nil {
+		return ErrDisconnected
+	}
+
+	return c.card.EndTransaction()
+}
+
+// Lock locks the connected card if it is shared with other processes.
+func (c *LazyCard) Lock(ctx context.Context) error {
+	if l, ok := c.card.(Locker); ok {
+		return l.Lock(ctx)
+	}
+
+	return nil
+}
+
+// Unlock unlocks the connected card if it is shared with other processes.
+func (c *LazyCard) Unlock() error {
+	if l, ok := c.card.(Locker); ok {
+		return l.Unlock()
+	}
+
+	return nil
+}
+
+func (c *LazyCard) Base() iso7816.PCSCCard {
+	return c
+}
+
+// Close disconnects the card.
+func (c *LazyCard) Close() error {
+	<-c.token
+	defer func() {
+		c.token <- struct{}{}
+	}()
+
+	return c.disconnect()
+}
+
+// connect is called by the queue before each operation.
+func (c *LazyCard) connect() (err error) {
+	// Invalidate pending idle timers
+	c.gen++
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+
+	if c.card == nil {
+		if c.card, err = c.open(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// release is called by the queue after each operation.
+func (c *LazyCard) release() {
+	if c.idle <= 0 {
+		return
+	}
+
+	gen := c.gen
+	c.timer = time.AfterFunc(c.idle, func() {
+		c.expire(gen)
+	})
+}
+
+func (c *LazyCard) expire(gen uint64) {
+	<-c.token
+	defer func() {
+		c.token <- struct{}{}
+	}()
+
+	// The card has been used since the timer was started
+	if gen != c.gen {
+		return
+	}
+
+	_ = c.disconnect()
+}
+
+func (c *LazyCard) disconnect() error {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+
+	if c.card == nil {
+		return nil
+	}
+
+	for _, cb := range c.onDisconnect {
+		cb()
+	}
+
+	card := c.card
+	c.card = nil
+
+	return card.Close()
+}
@@ -0,0 +1,170 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package queue implements a serialized operation queue for devices
+// which can only process a single operation at a time such as smart cards.
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"cunicu.li/go-iso7816"
+
+	"cunicu.li/hawkes/tap"
+)
+
+// Queue serializes operations on a shared device.
+//
+// Operations are started in the order in which they have been queued.
+// An operation which can not be started before its context is done
+// or the per-operation timeout elapses is dropped from the queue.
+type Queue struct {
+	token   chan struct{}
+	timeout time.Duration
+	locker  Locker
+	session session
+
+	// last is the completion time of the last operation.
+	last time.Time
+
+	// pings are run by KeepAlive when the queue is idle.
+	pings []func()
+
+	// resets are run after the device has been reset (see OnReset).
+	resets []func()
+}
+
+// session is implemented by devices which must be connected for each operation.
+type session interface {
+	Connected() bool
+	connect() error
+	release()
+	disconnect() error
+}
+
+// Locker is implemented by devices which are shared with other processes
+// and must be locked for the duration of each operation.
+//
+// Lock returns an error wrapping ErrReset if the device has been reset
+// since it was locked last. The device is locked nevertheless.
+type Locker interface {
+	Lock(ctx context.Context) error
+	Unlock() error
+}
+
+// New creates a new queue.
+// A timeout of zero disables the per-operation deadline.
+func New(timeout time.Duration) *Queue {
+	q := &Queue{
+		token:   make(chan struct{}, 1),
+		timeout: timeout,
+	}
+
+	q.token <- struct{}{}
+
+	return q
+}
+
+// Do waits until all previously queued operations have completed and runs fn.
+// The context passed to fn carries the per-operation deadline of the queue.
+func (q *Queue) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	if q.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, q.timeout)
+		defer cancel()
+	}
+
+	// Goroutines blocked on a channel are woken up in FIFO order.
+	select {
+	case <-q.token:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	defer func() {
+		q.last = time.Now()
+		q.token <- struct{}{}
+	}()
+
+	if q.session != nil {
+		if err := q.session.connect(); err != nil {
+			return err
+		}
+
+		defer q.session.release()
+	}
+
+	if q.locker != nil {
+		if err := q.lock(ctx); err != nil {
+			return err
+		}
+
+		defer q.locker.Unlock() //nolint:errcheck
+	}
+
+	err := fn(ctx)
+	if errors.Is(err, ErrReset) {
+		q.reset()
+	} else if errors.Is(err, tap.ErrRemoved) && q.session != nil {
+		// The connection to a removed card can not be reused.
+		// The next operation connects to the card of the next tap.
+		_ = q.session.disconnect()
+	}
+
+	return err
+}
+
+// lock locks a shared device and notifies the users of the queue
+// if the device has been reset in the meantime.
+func (q *Queue) lock(ctx context.Context) error {
+	if err := q.locker.Lock(ctx); errors.Is(err, ErrReset) {
+		q.reset()
+	} else if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+var _ iso7816.PCSCCard = (*Card)(nil)
+
+// Card is a smart card whose operations are serialized by a queue
+// shared between all users of the card.
+//
+// Note: Transmit does not acquire the queue by itself as most operations
+// consist of multiple APDUs which must not be interleaved.
+// Users must wrap their operations with Do.
+type Card struct {
+	iso7816.PCSCCard
+	*Queue
+}
+
+// NewCard wraps a card with a new queue.
+// Cards implementing Locker are additionally locked for each operation.
+func NewCard(card iso7816.PCSCCard, timeout time.Duration) *Card {
+	q := New(timeout)
+
+	if l, ok := card.(Locker); ok {
+		q.locker = l
+	}
+
+	return &Card{
+		PCSCCard: card,
+		Queue:    q,
+	}
+}
+
+// Of returns the queue of a card or a new queue
+// if the card has not been wrapped with NewCard.
+func Of(card iso7816.PCSCCard) *Queue {
+	switch card := card.(type) {
+	case *Card:
+		return card.Queue
+	case *LazyCard:
+		return card.Queue
+	}
+
+	return New(0)
+}
@@ -0,0 +1,293 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cunicu.li/go-iso7816"
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/internal/queue"
+	"cunicu.li/hawkes/tap"
+)
+
+func TestOrder(t *testing.T) {
+	require := require.New(t)
+
+	q := queue.New(0)
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	var order []int
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		err := q.Do(context.Background(), func(context.Context) error {
+			close(started)
+			<-release
+			return nil
+		})
+		require.NoError(err)
+	}()
+
+	<-started
+
+	for i := range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := q.Do(context.Background(), func(context.Context) error {
+				order = append(order, i)
+				return nil
+			})
+			require.NoError(err)
+		}()
+
+		// Give the goroutine a chance to queue up
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(release)
+	wg.Wait()
+
+	require.Equal([]int{0, 1, 2, 3, 4}, order)
+}
+
+func TestTimeout(t *testing.T) {
+	require := require.New(t)
+
+	q := queue.New(50 * time.Millisecond)
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	go func() {
+		_ = q.Do(context.Background(), func(context.Context) error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+
+	<-started
+
+	err := q.Do(context.Background(), func(context.Context) error {
+		return nil
+	})
+	require.ErrorIs(err, context.DeadlineExceeded)
+
+	close(release)
+
+	err = q.Do(context.Background(), func(ctx context.Context) error {
+		_, ok := ctx.Deadline()
+		require.True(ok)
+		return nil
+	})
+	require.NoError(err)
+}
+
+type mockCard struct {
+	closed atomic.Bool
+}
+
+func (c *mockCard) Transmit(cmd []byte) ([]byte, error) { return cmd, nil }
+func (c *mockCard) BeginTransaction() error             { return nil }
+func (c *mockCard) EndTransaction() error               { return nil }
+func (c *mockCard) Base() iso7816.PCSCCard              { return c }
+
+func (c *mockCard) Close() error {
+	c.closed.Store(true)
+	return nil
+}
+
+func TestLazyCard(t *testing.T) {
+	require := require.New(t)
+
+	var opened []*mockCard
+	var disconnects atomic.Int32
+
+	c := queue.NewLazyCard(func() (iso7816.PCSCCard, error) {
+		card := &mockCard{}
+		opened = append(opened, card)
+		return card, nil
+	}, 0, 50*time.Millisecond)
+
+	queue.OnDisconnect(c, func() {
+		disconnects.Add(1)
+	})
+
+	require.True(queue.IsLazy(c))
+	require.False(c.Connected())
+	require.Empty(opened)
+
+	_, err := c.Transmit([]byte{1})
+	require.ErrorIs(err, queue.ErrDisconnected)
+
+	for range 2 {
+		err = c.Do(context.Background(), func(context.Context) error {
+			_, err := c.Transmit([]byte{1})
+			return err
+		})
+		require.NoError(err)
+	}
+
+	require.Len(opened, 1)
+
+	require.Eventually(func() bool {
+		return opened[0].closed.Load()
+	}, time.Second, 10*time.Millisecond)
+
+	require.EqualValues(1, disconnects.Load())
+
+	err = c.Do(context.Background(), func(context.Context) error {
+		return nil
+	})
+	require.NoError(err)
+	require.Len(opened, 2)
+
+	require.NoError(c.Close())
+	require.True(opened[1].closed.Load())
+	require.EqualValues(2, disconnects.Load())
+}
+
+func TestLazyCardRemoved(t *testing.T) {
+	require := require.New(t)
+
+	var opened []*mockCard
+
+	c := queue.NewLazyCard(func() (iso7816.PCSCCard, error) {
+		card := &mockCard{}
+		opened = append(opened, card)
+		return card, nil
+	}, 0, 0)
+
+	// The connection to a removed card is dropped
+	err := c.Do(context.Background(), func(context.Context) error {
+		return tap.Interrupted(errors.New("transmit failed"))
+	})
+	require.ErrorIs(err, tap.ErrRemoved)
+	require.True(opened[0].closed.Load())
+	require.False(c.Connected())
+
+	// The next operation connects to the card of the next tap
+	err = c.Do(context.Background(), func(context.Context) error {
+		return nil
+	})
+	require.NoError(err)
+	require.Len(opened, 2)
+}
+
+func TestKeepAlive(t *testing.T) {
+	require := require.New(t)
+
+	var pings atomic.Int32
+
+	c := queue.NewCard(&mockCard{}, 0)
+	queue.OnKeepAlive(c, func() {
+		pings.Add(1)
+	})
+
+	stop := c.KeepAlive(20 * time.Millisecond)
+	defer stop()
+
+	require.Eventually(func() bool {
+		return pings.Load() >= 2
+	}, time.Second, 10*time.Millisecond)
+
+	// Lazy cards are only pinged while connected
+	var lazyPings atomic.Int32
+
+	lc := queue.NewLazyCard(func() (iso7816.PCSCCard, error) {
+		return &mockCard{}, nil
+	}, 0, 0)
+
+	queue.OnKeepAlive(lc, func() {
+		lazyPings.Add(1)
+	})
+
+	stopLazy := lc.KeepAlive(20 * time.Millisecond)
+	defer stopLazy()
+
+	time.Sleep(100 * time.Millisecond)
+	require.Zero(lazyPings.Load())
+
+	err := lc.Do(context.Background(), func(context.Context) error {
+		return nil
+	})
+	require.NoError(err)
+
+	require.Eventually(func() bool {
+		return lazyPings.Load() >= 1
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(lc.Close())
+}
+
+// sharedCard is a card which is shared with other processes
+// and has been reset before its first lock.
+type sharedCard struct {
+	mockCard
+
+	locked bool
+	reset  bool
+}
+
+func (c *sharedCard) Lock(context.Context) error {
+	c.locked = true
+
+	if c.reset {
+		c.reset = false
+		return fmt.Errorf("%w: by another process", queue.ErrReset)
+	}
+
+	return nil
+}
+
+func (c *sharedCard) Unlock() error {
+	c.locked = false
+	return nil
+}
+
+func TestReset(t *testing.T) {
+	require := require.New(t)
+
+	card := &sharedCard{reset: true}
+	resets := 0
+
+	lc := queue.NewLazyCard(func() (iso7816.PCSCCard, error) {
+		return card, nil
+	}, 0, 0)
+
+	queue.OnReset(lc, func() {
+		resets++
+	})
+
+	// Resets detected while locking are not returned to the operation
+	err := lc.Do(context.Background(), func(context.Context) error {
+		require.True(card.locked)
+		return nil
+	})
+	require.NoError(err)
+	require.False(card.locked)
+	require.Equal(1, resets)
+
+	// Resets detected during an operation are
+	err = lc.Do(context.Background(), func(context.Context) error {
+		return fmt.Errorf("failed to select applet: %w", queue.ErrReset)
+	})
+	require.ErrorIs(err, queue.ErrReset)
+	require.Equal(2, resets)
+
+	require.NoError(lc.Close())
+}
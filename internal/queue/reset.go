@@ -0,0 +1,32 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"errors"
+
+	"cunicu.li/go-iso7816"
+)
+
+// ErrReset is returned by devices which have been reset by another process
+// or the operating system. All applets have been deselected.
+var ErrReset = errors.New("card has been reset")
+
+// OnReset registers a callback which is invoked after a reset of the card has been detected.
+// Users which keep state of the connection such as selected applets must discard it.
+// It is a no-op for cards without a shared queue.
+func OnReset(card iso7816.PCSCCard, cb func()) {
+	switch card := card.(type) {
+	case *Card:
+		card.resets = append(card.resets, cb)
+	case *LazyCard:
+		card.resets = append(card.resets, cb)
+	}
+}
+
+func (q *Queue) reset() {
+	for _, cb := range q.resets {
+		cb()
+	}
+}
@@ -0,0 +1,246 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package inventory collects firmware versions, key slot policies and
+// attestation certificates of connected tokens into a signed report
+// for compliance audits of issued hardware.
+//
+// The OpenPGP, PIV, YKOATH and YubiKey management applets are inspected.
+// PIV attestation certificates can be checked against the roots of the
+// manufacturer by PIV.Verify.
+//
+// FIDO attestation is not collected as it is only issued when creating
+// a credential which requires the user to touch the token.
+package inventory
+
+import (
+	"crypto"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"cunicu.li/go-iso7816"
+	"cunicu.li/go-ykoath/v2"
+
+	"cunicu.li/hawkes/device"
+	"cunicu.li/hawkes/jose"
+	"cunicu.li/hawkes/yubikey"
+)
+
+var ErrInvalidReport = errors.New("invalid report")
+
+// MediaType is the JWS type of signed reports.
+const MediaType = "hawkes-inventory+json"
+
+// Report is the inventory of all inspected devices.
+type Report struct {
+	Created  time.Time `json:"created"`
+	Hostname string    `json:"hostname,omitempty"`
+	Devices  []*Device `json:"devices"`
+}
+
+// Device describes a single token.
+type Device struct {
+	Reader string `json:"reader"`
+	ATR    string `json:"atr,omitempty"`
+	USB    string `json:"usb,omitempty"`
+
+	YubiKey *YubiKey `json:"yubikey,omitempty"`
+	OATH    *OATH    `json:"oath,omitempty"`
+	OpenPGP *OpenPGP `json:"openpgp,omitempty"`
+	PIV     *PIV     `json:"piv,omitempty"`
+
+	// Errors lists applets which could not be inspected.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// YubiKey describes a YubiKey as reported by its management applet.
+type YubiKey struct {
+	Serial     uint32 `json:"serial,omitempty"`
+	Firmware   string `json:"firmware"`
+	FormFactor string `json:"form_factor"`
+	FIPS       bool   `json:"fips,omitempty"`
+	Locked     bool   `json:"locked,omitempty"`
+
+	// Enabled lists the enabled applications per interface.
+	Enabled map[string][]string `json:"enabled"`
+}
+
+// OATH describes the YKOATH applet of a token.
+type OATH struct {
+	Version           string `json:"version"`
+	PasswordProtected bool   `json:"password_protected"`
+}
+
+// OpenPGP describes the OpenPGP applet of a token.
+type OpenPGP struct {
+	Version      string  `json:"version"`
+	Manufacturer string  `json:"manufacturer"`
+	Serial       string  `json:"serial"`
+	Slots        []*Slot `json:"slots"`
+}
+
+// Slot describes a key slot of the OpenPGP or PIV applet.
+type Slot struct {
+	Name      string `json:"name"`
+	Algorithm string `json:"algorithm,omitempty"`
+
+	// Origin is "generated" for keys generated on the card and "imported" otherwise.
+	Origin      string     `json:"origin,omitempty"`
+	Fingerprint string     `json:"fingerprint,omitempty"`
+	Created     *time.Time `json:"created,omitempty"`
+	PINPolicy   string     `json:"pin_policy,omitempty"`
+	TouchPolicy string     `json:"touch_policy,omitempty"`
+
+	// Certificate is the DER encoded attestation or cardholder certificate.
+	Certificate []byte `json:"certificate,omitempty"`
+}
+
+// Collect inspects the cards and gathers a report.
+// Failures to inspect an applet are recorded in the report instead of aborting.
+func Collect(cards []iso7816.PCSCCard) *Report {
+	r := &Report{
+		Created: time.Now().UTC(),
+		Devices: []*Device{},
+	}
+
+	if hostname, err := os.Hostname(); err == nil {
+		r.Hostname = hostname
+	}
+
+	for _, card := range cards {
+		r.Devices = append(r.Devices, inspect(card))
+	}
+
+	return r
+}
+
+func inspect(card iso7816.PCSCCard) *Device {
+	info := device.Inspect(card)
+
+	d := &Device{
+		Reader: info.Reader,
+		ATR:    hex.EncodeToString(info.ATR),
+	}
+
+	if info.USB != nil {
+		d.USB = info.USB.String()
+	}
+
+	// The OpenPGP applet resets the card and is therefore inspected first
+	var err error
+	if d.OpenPGP, err = inspectOpenPGP(card); err != nil {
+		d.Errors = append(d.Errors, fmt.Sprintf("openpgp: %s", err))
+	}
+
+	if d.YubiKey, err = inspectYubiKey(card); err != nil {
+		d.Errors = append(d.Errors, fmt.Sprintf("yubikey: %s", err))
+	}
+
+	if d.OATH, err = inspectOATH(card); err != nil {
+		d.Errors = append(d.Errors, fmt.Sprintf("oath: %s", err))
+	}
+
+	if d.PIV, err = inspectPIV(card); err != nil {
+		d.Errors = append(d.Errors, fmt.Sprintf("piv: %s", err))
+	}
+
+	return d
+}
+
+// inspectYubiKey reads the device information of YubiKeys.
+// Other tokens without a management applet are skipped.
+func inspectYubiKey(card iso7816.PCSCCard) (*YubiKey, error) {
+	c, err := yubikey.NewCard(card)
+	if errors.Is(err, iso7816.ErrFileOrAppNotFound) {
+		return nil, nil //nolint:nilnil
+	} else if err != nil {
+		return nil, err
+	}
+
+	info, err := c.DeviceInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	yk := &YubiKey{
+		Serial:     info.Serial,
+		Firmware:   info.Firmware.String(),
+		FormFactor: info.FormFactor.String(),
+		FIPS:       info.FIPS,
+		Locked:     info.Locked,
+		Enabled:    map[string][]string{},
+	}
+
+	for iface, caps := range info.Enabled {
+		yk.Enabled[iface.String()] = append([]string{}, caps.Names()...)
+	}
+
+	return yk, nil
+}
+
+func inspectOATH(card iso7816.PCSCCard) (*OATH, error) {
+	c, err := ykoath.NewCard(card)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	sel, err := c.Select()
+	if err != nil {
+		return nil, err
+	}
+
+	return &OATH{
+		Version:           version(sel.Version),
+		PasswordProtected: len(sel.Challenge) > 0,
+	}, nil
+}
+
+// Sign serializes the report as JSON and signs it as a JWS in compact serialization.
+func (r *Report) Sign(key *jose.SigningKey) (string, error) {
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+
+	return key.Sign(payload, MediaType)
+}
+
+// Verify checks the signature of a signed report and returns the report.
+func Verify(token string, pub crypto.PublicKey) (*Report, error) {
+	hdr, payload, err := jose.Verify(token, pub)
+	if err != nil {
+		return nil, err
+	}
+
+	if hdr.Type != MediaType {
+		return nil, fmt.Errorf("%w: unexpected type %q", ErrInvalidReport, hdr.Type)
+	}
+
+	r := &Report{}
+	if err := json.Unmarshal(payload, r); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidReport, err)
+	}
+
+	return r, nil
+}
+
+func version(v []byte) string {
+	if len(v) == 2 {
+		// OpenPGP applets use BCD encoded major and minor versions
+		return fmt.Sprintf("%x.%x", v[0], v[1])
+	}
+
+	parts := make([]string, 0, len(v))
+	for _, b := range v {
+		parts = append(parts, strconv.Itoa(int(b)))
+	}
+
+	return strings.Join(parts, ".")
+}
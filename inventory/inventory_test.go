@@ -0,0 +1,257 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package inventory_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+
+	"cunicu.li/go-iso7816"
+	"cunicu.li/go-iso7816/encoding/tlv"
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/inventory"
+	"cunicu.li/hawkes/jose"
+	"cunicu.li/hawkes/piv"
+)
+
+// oathCard answers the SELECT of the YKOATH applet, the device information
+// of the management applet and rejects all other commands.
+type oathCard struct {
+	reader string
+}
+
+func (c *oathCard) Transmit(cmd []byte) ([]byte, error) {
+	if c.reader != "YubiKey" {
+		return []byte{0x6a, 0x82}, nil // File not found
+	}
+
+	switch {
+	case bytes.Equal(cmd, append([]byte{0x00, 0xa4, 0x04, 0x00, byte(len(iso7816.AidYubicoOATH))}, append(iso7816.AidYubicoOATH, 0x00)...)):
+		// Version 5.7.1 and a password challenge
+		return []byte{0x79, 0x03, 0x05, 0x07, 0x01, 0x74, 0x02, 0xaa, 0xbb, 0x90, 0x00}, nil
+
+	case bytes.HasPrefix(cmd, append([]byte{0x00, 0xa4, 0x04, 0x00, byte(len(iso7816.AidYubicoManagement))}, iso7816.AidYubicoManagement...)):
+		return append([]byte("Virtual mgr - FW version 5.7.1"), 0x90, 0x00), nil
+
+	case bytes.HasPrefix(cmd, []byte{0x00, 0x1d, 0x00}):
+		return []byte{
+			0x0d,
+			0x02, 0x04, 0x00, 0xbc, 0x61, 0x4e, // Serial
+			0x04, 0x01, 0x03, // USB-C Keychain
+			0x01, 0x02, 0x02, 0x3b, // Supported and enabled via USB
+			0x90, 0x00,
+		}, nil
+	}
+
+	return []byte{0x6a, 0x82}, nil // File not found
+}
+
+func (c *oathCard) BeginTransaction() error     { return nil }
+func (c *oathCard) EndTransaction() error       { return nil }
+func (c *oathCard) Close() error                { return nil }
+func (c *oathCard) Base() iso7816.PCSCCard      { return c }
+func (c *oathCard) Reader() string              { return c.reader }
+func (c *oathCard) Metadata() map[string]string { return nil }
+
+func TestReport(t *testing.T) {
+	require := require.New(t)
+
+	r := inventory.Collect([]iso7816.PCSCCard{
+		&oathCard{reader: "YubiKey"},
+		&oathCard{reader: "Other"},
+	})
+
+	require.Len(r.Devices, 2)
+
+	yk := r.Devices[0]
+	require.Equal("YubiKey", yk.Reader)
+	require.NotNil(yk.OATH)
+	require.Equal("5.7.1", yk.OATH.Version)
+	require.True(yk.OATH.PasswordProtected)
+	require.Equal(&inventory.YubiKey{
+		Serial:     12345678,
+		Firmware:   "5.7.1",
+		FormFactor: "USB-C Keychain",
+		Enabled: map[string][]string{
+			"usb": {"otp", "u2f", "openpgp", "piv", "oath", "fido2"},
+		},
+	}, yk.YubiKey)
+	require.Empty(yk.Errors)
+
+	other := r.Devices[1]
+	require.Nil(other.OATH)
+	require.Nil(other.YubiKey)
+	require.Len(other.Errors, 1)
+
+	sk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	key, err := jose.NewSigningKey(sk)
+	require.NoError(err)
+
+	token, err := r.Sign(key)
+	require.NoError(err)
+
+	r2, err := inventory.Verify(token, &sk.PublicKey)
+	require.NoError(err)
+	require.Equal(r.Devices, r2.Devices)
+	require.True(r.Created.Equal(r2.Created))
+
+	// Other JWS are not accepted as reports
+	jwt, err := key.SignJWT(map[string]any{"sub": "alice"})
+	require.NoError(err)
+
+	_, err = inventory.Verify(jwt, &sk.PublicKey)
+	require.ErrorIs(err, inventory.ErrInvalidReport)
+}
+
+// pivCard answers the commands of the PIV applet for a key
+// generated in slot 9a and an imported key in slot 9c.
+type pivCard struct {
+	device, attested []byte
+}
+
+func (c *pivCard) Transmit(cmd []byte) ([]byte, error) {
+	ok := []byte{0x90, 0x00}
+
+	switch {
+	case bytes.HasPrefix(cmd, append([]byte{0x00, 0xa4, 0x04, 0x00, byte(len(iso7816.AidPIV))}, iso7816.AidPIV...)):
+		return ok, nil
+
+	case bytes.HasPrefix(cmd, []byte{0x00, 0xfd}): // GET VERSION
+		return []byte{0x05, 0x07, 0x01, 0x90, 0x00}, nil
+
+	case bytes.HasPrefix(cmd, []byte{0x00, 0xcb, 0x3f, 0xff}): // GET DATA
+		obj, _ := tlv.EncodeBER(tlv.New(0x70, c.device))
+		resp, _ := tlv.EncodeBER(tlv.New(0x53, obj))
+		return append(resp, ok...), nil
+
+	case bytes.HasPrefix(cmd, []byte{0x00, 0xf7, 0x00, 0x9a}): // GET METADATA
+		return []byte{0x01, 0x01, 0x11, 0x02, 0x02, 0x02, 0x02, 0x03, 0x01, 0x01, 0x90, 0x00}, nil
+
+	case bytes.HasPrefix(cmd, []byte{0x00, 0xf7, 0x00, 0x9c}):
+		return []byte{0x01, 0x01, 0x07, 0x02, 0x02, 0x03, 0x01, 0x03, 0x01, 0x02, 0x90, 0x00}, nil
+
+	case bytes.HasPrefix(cmd, []byte{0x00, 0xf7}):
+		return []byte{0x6a, 0x88}, nil // Referenced data not found
+
+	case bytes.HasPrefix(cmd, []byte{0x00, 0xf9, 0x9a}): // ATTEST
+		return append(append([]byte{}, c.attested...), ok...), nil
+	}
+
+	return []byte{0x6a, 0x82}, nil // File not found
+}
+
+func (c *pivCard) BeginTransaction() error     { return nil }
+func (c *pivCard) EndTransaction() error       { return nil }
+func (c *pivCard) Close() error                { return nil }
+func (c *pivCard) Base() iso7816.PCSCCard      { return c }
+func (c *pivCard) Reader() string              { return "YubiKey PIV" }
+func (c *pivCard) Metadata() map[string]string { return nil }
+
+func newCertificate(t *testing.T, tmpl, parent *x509.Certificate, pub *ecdsa.PublicKey, priv *ecdsa.PrivateKey) *x509.Certificate {
+	t.Helper()
+
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+
+	if parent == nil {
+		parent = tmpl
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, priv)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return cert
+}
+
+func TestPIV(t *testing.T) {
+	require := require.New(t)
+
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	root := newCertificate(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test PIV Root CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, &rootKey.PublicKey, rootKey)
+
+	deviceKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	device := newCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "Test PIV Attestation"},
+	}, root, &deviceKey.PublicKey, rootKey)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	attested := newCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "YubiKey PIV Attestation 9a"},
+		ExtraExtensions: []pkix.Extension{
+			{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 41482, 3, 8}, Value: []byte{0x02, 0x02}},
+		},
+	}, device, &key.PublicKey, deviceKey)
+
+	r := inventory.Collect([]iso7816.PCSCCard{
+		&pivCard{device: device.Raw, attested: attested.Raw},
+	})
+
+	require.Len(r.Devices, 1)
+
+	p := r.Devices[0].PIV
+	require.NotNil(p)
+	require.Equal("5.7.1", p.Version)
+	require.Equal(device.Raw, p.Certificate)
+	require.Equal([]*inventory.Slot{
+		{
+			Name:        "9a",
+			Algorithm:   "ECC-P256",
+			Origin:      "generated",
+			PINPolicy:   "once",
+			TouchPolicy: "always",
+			Certificate: attested.Raw,
+		},
+		{
+			Name:        "9c",
+			Algorithm:   "RSA-2048",
+			Origin:      "imported",
+			PINPolicy:   "always",
+			TouchPolicy: "never",
+		},
+	}, p.Slots)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+
+	require.NoError(p.Verify(piv.VerifyOptions{Roots: roots}))
+
+	// Reported policies must match the attested ones
+	p.Slots[0].TouchPolicy = "never"
+	require.ErrorIs(p.Verify(piv.VerifyOptions{Roots: roots}), piv.ErrInvalidAttestation)
+
+	p.Slots[0].TouchPolicy = "always"
+	require.ErrorIs(p.Verify(piv.VerifyOptions{Roots: x509.NewCertPool()}), piv.ErrInvalidAttestation)
+
+	// Generated keys must be attested
+	p.Slots[0].Certificate = nil
+	require.ErrorIs(p.Verify(piv.VerifyOptions{Roots: roots}), piv.ErrInvalidAttestation)
+}
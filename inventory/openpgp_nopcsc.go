@@ -0,0 +1,15 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build (!cgo && !windows) || nopcsc
+
+package inventory
+
+import (
+	"cunicu.li/go-iso7816"
+)
+
+// inspectOpenPGP skips the OpenPGP applet as this build has no PC/SC support.
+func inspectOpenPGP(iso7816.PCSCCard) (*OpenPGP, error) {
+	return nil, nil //nolint:nilnil
+}
@@ -0,0 +1,109 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build (cgo || windows) && !nopcsc
+
+package inventory
+
+import (
+	"encoding/hex"
+
+	"cunicu.li/go-iso7816"
+	"cunicu.li/go-iso7816/drivers/pcsc"
+
+	"cunicu.li/hawkes/internal/openpgp"
+)
+
+// inspectOpenPGP reads the key slots of the OpenPGP applet.
+// Cards which are not accessed via PC/SC are skipped.
+func inspectOpenPGP(card iso7816.PCSCCard) (*OpenPGP, error) {
+	pc, ok := card.Base().(*pcsc.Card)
+	if !ok {
+		return nil, nil //nolint:nilnil
+	}
+
+	c, err := openpgp.NewCard(pc.Card)
+	if err != nil {
+		return nil, err
+	}
+
+	ar, err := c.GetApplicationRelatedData()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &OpenPGP{
+		Version:      version(ar.AID.Version[:]),
+		Manufacturer: ar.AID.ManufacturerName(),
+		Serial:       hex.EncodeToString(ar.AID.Serial[:]),
+	}
+
+	for slot, name := range []string{"sign", "decrypt", "authenticate", "attest"} {
+		ki := ar.Keys[slot]
+
+		s := &Slot{
+			Name: name,
+		}
+
+		// The UIF data objects are only present on cards with a button
+		if ki.UIF.Feature != 0 {
+			s.TouchPolicy = touchPolicy(ki.UIF.Requirement)
+		}
+
+		switch ki.Status {
+		case 0x01:
+			s.Origin = "generated"
+		case 0x02:
+			s.Origin = "imported"
+		}
+
+		if ki.AlgAttrs.Algorithm != 0 {
+			s.Algorithm = ki.AlgAttrs.Algorithm.String()
+		}
+
+		if len(ki.Fingerprint) > 0 && !isZero(ki.Fingerprint) {
+			s.Fingerprint = hex.EncodeToString(ki.Fingerprint)
+		}
+
+		if ki.GenerationTime.Unix() > 0 {
+			created := ki.GenerationTime.UTC()
+			s.Created = &created
+		}
+
+		// Certificates are optional and absent on many cards
+		if cert, err := c.GetCertificate(openpgp.Slot(slot)); err == nil && len(cert) > 0 { //nolint:gosec
+			s.Certificate = cert
+		}
+
+		p.Slots = append(p.Slots, s)
+	}
+
+	return p, nil
+}
+
+func touchPolicy(req byte) string {
+	switch req {
+	case 0x00:
+		return "never"
+	case 0x01:
+		return "always"
+	case 0x02:
+		return "always-fixed"
+	case 0x03:
+		return "cached"
+	case 0x04:
+		return "cached-fixed"
+	default:
+		return ""
+	}
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+
+	return true
+}
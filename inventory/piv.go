@@ -0,0 +1,140 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package inventory
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"cunicu.li/go-iso7816"
+
+	"cunicu.li/hawkes/piv"
+)
+
+// PIV describes the PIV applet of a token.
+type PIV struct {
+	Version string `json:"version"`
+
+	// Certificate is the DER encoded certificate of the attestation key
+	// which is issued by the manufacturer of the token.
+	Certificate []byte  `json:"certificate,omitempty"`
+	Slots       []*Slot `json:"slots"`
+}
+
+// pivSlots are the slots which are inspected.
+func pivSlots() (slots []piv.Slot) {
+	slots = []piv.Slot{
+		piv.SlotAuthentication,
+		piv.SlotSignature,
+		piv.SlotKeyManagement,
+		piv.SlotCardAuthentication,
+	}
+
+	for s := piv.SlotRetired1; s <= piv.SlotRetired20; s++ {
+		slots = append(slots, s)
+	}
+
+	return slots
+}
+
+// inspectPIV reads the metadata and attestation certificates of all occupied PIV slots.
+// Cards without a PIV applet are skipped.
+func inspectPIV(card iso7816.PCSCCard) (*PIV, error) {
+	c, err := piv.NewCard(card)
+	if errors.Is(err, iso7816.ErrFileOrAppNotFound) {
+		return nil, nil //nolint:nilnil
+	} else if err != nil {
+		return nil, err
+	}
+
+	p := &PIV{
+		Slots: []*Slot{},
+	}
+
+	if v, err := c.Version(); err == nil {
+		p.Version = version(v)
+	}
+
+	// The attestation key is absent on tokens of other manufacturers
+	if cert, err := c.AttestationCertificate(); err == nil {
+		p.Certificate = cert.Raw
+	}
+
+	for _, slot := range pivSlots() {
+		md, err := c.Metadata(slot)
+		if errors.Is(err, iso7816.ErrReferenceNotFound) {
+			continue
+		} else if err != nil {
+			return p, fmt.Errorf("failed to read metadata of slot %s: %w", slot, err)
+		}
+
+		s := &Slot{
+			Name:        slot.String(),
+			Algorithm:   md.Algorithm.String(),
+			Origin:      "imported",
+			PINPolicy:   md.Policies.PIN.String(),
+			TouchPolicy: md.Policies.Touch.String(),
+		}
+
+		// Only generated keys can be attested
+		if md.Generated {
+			s.Origin = "generated"
+
+			if p.Certificate != nil {
+				cert, err := c.Attest(slot)
+				if err != nil {
+					return p, err
+				}
+
+				s.Certificate = cert.Raw
+			}
+		}
+
+		p.Slots = append(p.Slots, s)
+	}
+
+	return p, nil
+}
+
+// Verify checks the attestation certificates of the PIV slots against the roots
+// of the manufacturer and that the attested policies match the reported ones.
+// Slots without attestation certificate are rejected unless they hold an imported key.
+func (p *PIV) Verify(opts piv.VerifyOptions) error {
+	if p.Certificate == nil {
+		return fmt.Errorf("%w: missing attestation certificate", piv.ErrInvalidAttestation)
+	}
+
+	device, err := x509.ParseCertificate(p.Certificate)
+	if err != nil {
+		return fmt.Errorf("%w: %w", piv.ErrInvalidAttestation, err)
+	}
+
+	for _, s := range p.Slots {
+		if s.Certificate == nil {
+			if s.Origin == "imported" {
+				continue
+			}
+
+			return fmt.Errorf("%w: slot %s is not attested", piv.ErrInvalidAttestation, s.Name)
+		}
+
+		cert, err := x509.ParseCertificate(s.Certificate)
+		if err != nil {
+			return fmt.Errorf("%w: slot %s: %w", piv.ErrInvalidAttestation, s.Name, err)
+		}
+
+		a, err := piv.VerifyAttestation(device, cert, opts)
+		if err != nil {
+			return fmt.Errorf("slot %s: %w", s.Name, err)
+		}
+
+		if a.Policies.PIN.String() != s.PINPolicy || a.Policies.Touch.String() != s.TouchPolicy {
+			return fmt.Errorf("%w: slot %s has policies %s/%s but reported %s/%s", piv.ErrInvalidAttestation,
+				s.Name, a.Policies.PIN, a.Policies.Touch, s.PINPolicy, s.TouchPolicy)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,272 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package jose implements JSON Web Signatures (JWS) and Tokens (JWT)
+// signed by keys of providers.
+package jose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"cunicu.li/hawkes/internal/ecdsasig"
+	"cunicu.li/hawkes/provider"
+)
+
+var (
+	ErrUnsupportedKey       = errors.New("unsupported key")
+	ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+	ErrInvalidToken         = errors.New("invalid token")
+	ErrInvalidSignature     = errors.New("invalid signature")
+)
+
+// Algorithm is a JWS signature algorithm as defined by RFC 7518.
+type Algorithm string
+
+const (
+	ES256 Algorithm = "ES256"
+	ES384 Algorithm = "ES384"
+	RS256 Algorithm = "RS256"
+	EdDSA Algorithm = "EdDSA"
+)
+
+func (a Algorithm) hash() crypto.Hash {
+	switch a {
+	case ES256, RS256:
+		return crypto.SHA256
+	case ES384:
+		return crypto.SHA384
+	default:
+		return 0
+	}
+}
+
+// AlgorithmOf returns the signature algorithm matching a public key.
+func AlgorithmOf(pub crypto.PublicKey) (Algorithm, error) {
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			return ES256, nil
+		case elliptic.P384():
+			return ES384, nil
+		}
+
+	case *rsa.PublicKey:
+		return RS256, nil
+
+	case ed25519.PublicKey:
+		return EdDSA, nil
+	}
+
+	return "", fmt.Errorf("%w: %T", ErrUnsupportedKey, pub)
+}
+
+// Header is the protected header of a JWS.
+type Header struct {
+	Algorithm Algorithm `json:"alg"`
+	Type      string    `json:"typ,omitempty"`
+	KeyID     string    `json:"kid,omitempty"`
+
+	// X509Chain contains the base64 encoded DER certificates of the key.
+	X509Chain []string `json:"x5c,omitempty"`
+}
+
+// SigningKey signs JWS and JWTs with a crypto.Signer.
+type SigningKey struct {
+	Signer    crypto.Signer
+	Algorithm Algorithm
+	KeyID     string
+
+	// Certificates are included in the x5c header if not empty.
+	Certificates []*x509.Certificate
+}
+
+// NewSigningKey creates a signing key whose algorithm is derived from the public key.
+// The key ID is the JWK thumbprint (RFC 7638) of the public key.
+func NewSigningKey(signer crypto.Signer) (*SigningKey, error) {
+	alg, err := AlgorithmOf(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	kid, err := Thumbprint(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	return &SigningKey{
+		Signer:    signer,
+		Algorithm: alg,
+		KeyID:     kid,
+	}, nil
+}
+
+// NewProviderSigningKey creates a signing key from a key of a provider.
+func NewProviderSigningKey(key provider.PrivateKey) (*SigningKey, error) {
+	sk, ok := key.(provider.PrivateKeySigner)
+	if !ok {
+		return nil, fmt.Errorf("%w: key does not support signing", ErrUnsupportedKey)
+	}
+
+	signer, err := sk.Signer()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSigningKey(signer)
+}
+
+// WithCertificate includes the certificate chain in the header and derives
+// the key ID from the SHA-256 fingerprint of the first (leaf) certificate.
+// This is useful for keys whose certificate has been issued by an attestation.
+func (k *SigningKey) WithCertificate(chain ...*x509.Certificate) *SigningKey {
+	if len(chain) > 0 {
+		fp := sha256.Sum256(chain[0].Raw)
+		k.KeyID = base64.RawURLEncoding.EncodeToString(fp[:])
+		k.Certificates = chain
+	}
+
+	return k
+}
+
+// Sign creates a JWS in compact serialization.
+func (k *SigningKey) Sign(payload []byte, typ string) (string, error) {
+	hdr := Header{
+		Algorithm: k.Algorithm,
+		Type:      typ,
+		KeyID:     k.KeyID,
+	}
+
+	for _, cert := range k.Certificates {
+		hdr.X509Chain = append(hdr.X509Chain, base64.StdEncoding.EncodeToString(cert.Raw))
+	}
+
+	hdrJSON, err := json.Marshal(hdr)
+	if err != nil {
+		return "", err
+	}
+
+	input := b64(hdrJSON) + "." + b64(payload)
+
+	sig, err := k.sign([]byte(input))
+	if err != nil {
+		return "", err
+	}
+
+	return input + "." + b64(sig), nil
+}
+
+// SignJWT creates a JWT with the JSON encoded claims.
+func (k *SigningKey) SignJWT(claims any) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	return k.Sign(payload, "JWT")
+}
+
+func (k *SigningKey) sign(input []byte) ([]byte, error) {
+	h := k.Algorithm.hash()
+
+	digest := input
+	if h != 0 {
+		hh := h.New()
+		hh.Write(input)
+		digest = hh.Sum(nil)
+	}
+
+	sig, err := k.Signer.Sign(rand.Reader, digest, h)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+
+	// JWS uses the concatenation of R and S instead of ASN.1 for ECDSA
+	if pub, ok := k.Signer.Public().(*ecdsa.PublicKey); ok {
+		return ecdsasig.ToRaw(sig, ecdsasig.Size(pub))
+	}
+
+	return sig, nil
+}
+
+// Verify checks the signature of a JWS in compact serialization and returns its header and payload.
+func Verify(token string, pub crypto.PublicKey) (*Header, []byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, nil, ErrInvalidToken
+	}
+
+	hdrJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	hdr := &Header{}
+	if err := json.Unmarshal(hdrJSON, hdr); err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	// Prevent algorithm confusion by deriving the algorithm from the key
+	alg, err := AlgorithmOf(pub)
+	if err != nil {
+		return nil, nil, err
+	} else if alg != hdr.Algorithm {
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, hdr.Algorithm)
+	}
+
+	input := []byte(parts[0] + "." + parts[1])
+
+	if !verify(alg, pub, input, sig) {
+		return nil, nil, ErrInvalidSignature
+	}
+
+	return hdr, payload, nil
+}
+
+func verify(alg Algorithm, pub crypto.PublicKey, input, sig []byte) bool {
+	var digest []byte
+	if h := alg.hash(); h != 0 {
+		hh := h.New()
+		hh.Write(input)
+		digest = hh.Sum(nil)
+	}
+
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsasig.Verify(pub, digest, sig)
+
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(pub, alg.hash(), digest, sig) == nil
+
+	case ed25519.PublicKey:
+		return ed25519.Verify(pub, input, sig)
+	}
+
+	return false
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
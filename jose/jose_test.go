@@ -0,0 +1,111 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package jose_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/jose"
+)
+
+func TestSignVerify(t *testing.T) {
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	for alg, signer := range map[jose.Algorithm]crypto.Signer{
+		jose.ES256: p256,
+		jose.ES384: p384,
+		jose.RS256: rsaKey,
+		jose.EdDSA: edKey,
+	} {
+		t.Run(string(alg), func(t *testing.T) {
+			require := require.New(t)
+
+			sk, err := jose.NewSigningKey(signer)
+			require.NoError(err)
+			require.Equal(alg, sk.Algorithm)
+
+			claims := map[string]any{"sub": "hawkes"}
+
+			token, err := sk.SignJWT(claims)
+			require.NoError(err)
+
+			hdr, payload, err := jose.Verify(token, signer.Public())
+			require.NoError(err)
+			require.Equal(alg, hdr.Algorithm)
+			require.Equal("JWT", hdr.Type)
+			require.Equal(sk.KeyID, hdr.KeyID)
+			require.JSONEq(`{"sub":"hawkes"}`, string(payload))
+
+			// Tamper with the payload
+			parts := strings.Split(token, ".")
+			parts[1] = base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"mallory"}`))
+
+			_, _, err = jose.Verify(strings.Join(parts, "."), signer.Public())
+			require.ErrorIs(err, jose.ErrInvalidSignature)
+		})
+	}
+}
+
+func TestThumbprint(t *testing.T) {
+	require := require.New(t)
+
+	// Test vector from RFC 7638 section 3.1
+	n, err := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	require.NoError(err)
+
+	pub := &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: 65537,
+	}
+
+	kid, err := jose.Thumbprint(pub)
+	require.NoError(err)
+	require.Equal("NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", kid)
+}
+
+func TestPublicJWK(t *testing.T) {
+	require := require.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	sk, err := jose.NewSigningKey(key)
+	require.NoError(err)
+
+	jwk, err := sk.PublicJWK()
+	require.NoError(err)
+
+	buf, err := json.Marshal(jwk)
+	require.NoError(err)
+
+	var m map[string]string
+	require.NoError(json.Unmarshal(buf, &m))
+	require.Equal("EC", m["kty"])
+	require.Equal("P-256", m["crv"])
+	require.Equal("ES256", m["alg"])
+	require.Equal(sk.KeyID, m["kid"])
+	require.Len(m["x"], 43)
+}
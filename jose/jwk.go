@@ -0,0 +1,118 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package jose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// JWK is a public JSON Web Key as defined by RFC 7517.
+type JWK struct {
+	KeyType string `json:"kty"`
+	Curve   string `json:"crv,omitempty"`
+	X       string `json:"x,omitempty"`
+	Y       string `json:"y,omitempty"`
+	N       string `json:"n,omitempty"`
+	E       string `json:"e,omitempty"`
+
+	KeyID     string    `json:"kid,omitempty"`
+	Algorithm Algorithm `json:"alg,omitempty"`
+	Use       string    `json:"use,omitempty"`
+}
+
+// NewJWK encodes a public key as JWK.
+func NewJWK(pub crypto.PublicKey) (*JWK, error) {
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		n := (pub.Curve.Params().BitSize + 7) / 8
+
+		return &JWK{
+			KeyType: "EC",
+			Curve:   pub.Curve.Params().Name,
+			X:       b64(pub.X.FillBytes(make([]byte, n))),
+			Y:       b64(pub.Y.FillBytes(make([]byte, n))),
+		}, nil
+
+	case *rsa.PublicKey:
+		return &JWK{
+			KeyType: "RSA",
+			N:       b64(pub.N.Bytes()),
+			E:       b64(big.NewInt(int64(pub.E)).Bytes()),
+		}, nil
+
+	case ed25519.PublicKey:
+		return &JWK{
+			KeyType: "OKP",
+			Curve:   "Ed25519",
+			X:       b64(pub),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, pub)
+}
+
+// PublicJWK returns the public key of the signing key as JWK
+// for publishing in a JWK set.
+func (k *SigningKey) PublicJWK() (*JWK, error) {
+	jwk, err := NewJWK(k.Signer.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	jwk.KeyID = k.KeyID
+	jwk.Algorithm = k.Algorithm
+	jwk.Use = "sig"
+
+	return jwk, nil
+}
+
+// Thumbprint calculates the JWK thumbprint of a public key as defined by RFC 7638.
+func Thumbprint(pub crypto.PublicKey) (string, error) {
+	jwk, err := NewJWK(pub)
+	if err != nil {
+		return "", err
+	}
+
+	// Only the required members in lexicographic order
+	var members any
+	switch jwk.KeyType {
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Curve, jwk.KeyType, jwk.X, jwk.Y}
+
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N}
+
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Curve, jwk.KeyType, jwk.X}
+	}
+
+	buf, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+
+	digest := sha256.Sum256(buf)
+
+	return b64(digest[:]), nil
+}
@@ -0,0 +1,211 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package kdf derives encryption keys from passphrases with
+// the memory-hard Argon2id function (RFC 9106).
+//
+// Parameters are encoded in the PHC string format used by other
+// Argon2 implementations, e.g. "$argon2id$v=19$m=65536,t=3,p=4$<salt>".
+package kdf
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+var (
+	ErrInvalidParams = errors.New("invalid KDF parameters")
+	ErrParse         = errors.New("failed to parse KDF parameters")
+)
+
+const (
+	// SaltSize is the size of generated salts.
+	SaltSize = 16
+
+	// MinSaltSize is the smallest accepted salt.
+	MinSaltSize = 8
+
+	// DefaultTime, DefaultMemory and DefaultThreads are the second
+	// recommended option of RFC 9106 for memory-constrained environments.
+	DefaultTime    = 3
+	DefaultMemory  = 64 * 1024 // KiB
+	DefaultThreads = 4
+
+	// MaxTime, MaxMemory and MaxThreads bound the parameters
+	// so that a tampered keystore can not exhaust the host.
+	MaxTime    = 10000
+	MaxMemory  = 4 * 1024 * 1024 // KiB
+	MaxThreads = 64
+)
+
+// Argon2id are the parameters of an Argon2id key derivation.
+type Argon2id struct {
+	// Time is the number of passes over the memory.
+	Time uint32
+
+	// Memory is the size of the memory in KiB.
+	Memory uint32
+
+	// Threads is the degree of parallelism.
+	Threads uint8
+
+	// Salt is a random value unique to each derived key.
+	Salt []byte
+}
+
+// Default returns the default parameters with a new random salt.
+func Default() (*Argon2id, error) {
+	p := &Argon2id{
+		Time:    DefaultTime,
+		Memory:  DefaultMemory,
+		Threads: DefaultThreads,
+	}
+
+	return p, p.GenerateSalt()
+}
+
+// GenerateSalt replaces the salt with a new random one.
+func (p *Argon2id) GenerateSalt() error {
+	p.Salt = make([]byte, SaltSize)
+	if _, err := rand.Read(p.Salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	return nil
+}
+
+// Validate checks the parameters against the limits of RFC 9106
+// and the upper bounds MaxTime, MaxMemory and MaxThreads.
+func (p *Argon2id) Validate() error {
+	switch {
+	case p.Time < 1:
+		return fmt.Errorf("%w: at least one pass is required", ErrInvalidParams)
+	case p.Time > MaxTime:
+		return fmt.Errorf("%w: at most %d passes are allowed", ErrInvalidParams, MaxTime)
+	case p.Threads < 1:
+		return fmt.Errorf("%w: at least one thread is required", ErrInvalidParams)
+	case p.Threads > MaxThreads:
+		return fmt.Errorf("%w: at most %d threads are allowed", ErrInvalidParams, MaxThreads)
+	case p.Memory < 8*uint32(p.Threads):
+		return fmt.Errorf("%w: at least %d KiB of memory are required for %d threads", ErrInvalidParams, 8*uint32(p.Threads), p.Threads)
+	case p.Memory > MaxMemory:
+		return fmt.Errorf("%w: at most %d KiB of memory are allowed", ErrInvalidParams, MaxMemory)
+	case len(p.Salt) < MinSaltSize:
+		return fmt.Errorf("%w: salt must have at least %d bytes", ErrInvalidParams, MinSaltSize)
+	}
+
+	return nil
+}
+
+// Key derives a key of the given size from a passphrase.
+func (p *Argon2id) Key(passphrase []byte, size uint32) ([]byte, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	return argon2.IDKey(passphrase, p.Salt, p.Time, p.Memory, p.Threads, size), nil
+}
+
+// String encodes the parameters as PHC string.
+func (p *Argon2id) String() string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s",
+		argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(p.Salt))
+}
+
+// Parse decodes parameters from a PHC string.
+// A trailing hash is ignored.
+// Parameters exceeding MaxTime, MaxMemory or MaxThreads are rejected before any derivation.
+func Parse(s string) (*Argon2id, error) {
+	parts := strings.Split(s, "$")
+	if len(parts) < 5 || parts[0] != "" || parts[1] != "argon2id" {
+		return nil, fmt.Errorf("%w: not an Argon2id PHC string", ErrParse)
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return nil, fmt.Errorf("%w: invalid version: %w", ErrParse, err)
+	} else if version != argon2.Version {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrParse, version)
+	}
+
+	p := &Argon2id{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return nil, fmt.Errorf("%w: invalid parameters: %w", ErrParse, err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid salt: %w", ErrParse, err)
+	}
+
+	p.Salt = salt
+
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (p *Argon2id) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (p *Argon2id) UnmarshalText(text []byte) error {
+	q, err := Parse(string(text))
+	if err != nil {
+		return err
+	}
+
+	*p = *q
+
+	return nil
+}
+
+// Calibrate benchmarks the host and returns parameters with the given
+// memory in KiB and threads whose derivation takes at least the target duration.
+// The number of passes is increased until the target is reached, so that
+// the memory hardness is kept while the latency is tuned.
+// The passes are limited to MaxTime.
+// The returned parameters have a new random salt.
+func Calibrate(target time.Duration, memory uint32, threads uint8) (*Argon2id, error) {
+	p := &Argon2id{
+		Time:    1,
+		Memory:  memory,
+		Threads: threads,
+	}
+
+	if err := p.GenerateSalt(); err != nil {
+		return nil, err
+	}
+
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	passphrase := []byte("calibration")
+
+	for {
+		start := time.Now()
+		argon2.IDKey(passphrase, p.Salt, p.Time, p.Memory, p.Threads, 32)
+		elapsed := time.Since(start)
+
+		if elapsed >= target || p.Time >= MaxTime {
+			return p, nil
+		}
+
+		// Passes scale linearly, so extrapolate from the measurement
+		// but at most double the passes to account for noise
+		next := uint32(float64(p.Time) * float64(target) / float64(max(elapsed, time.Microsecond)))
+		p.Time = min(MaxTime, max(p.Time+1, min(next, 2*p.Time)))
+	}
+}
@@ -0,0 +1,109 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package kdf_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/kdf"
+)
+
+func TestArgon2id(t *testing.T) {
+	require := require.New(t)
+
+	p, err := kdf.Default()
+	require.NoError(err)
+	require.Len(p.Salt, kdf.SaltSize)
+
+	// Keep the test fast
+	p.Memory = 64
+	p.Time = 1
+
+	k1, err := p.Key([]byte("correct horse"), 32)
+	require.NoError(err)
+	require.Len(k1, 32)
+
+	k2, err := p.Key([]byte("correct horse"), 32)
+	require.NoError(err)
+	require.Equal(k1, k2)
+
+	k3, err := p.Key([]byte("battery staple"), 32)
+	require.NoError(err)
+	require.NotEqual(k1, k3)
+
+	// Parameters are persisted as PHC string
+	text, err := p.MarshalText()
+	require.NoError(err)
+	require.Regexp(`^\$argon2id\$v=19\$m=64,t=1,p=4\$[A-Za-z0-9+/]+$`, string(text))
+
+	q := &kdf.Argon2id{}
+	require.NoError(q.UnmarshalText(text))
+	require.Equal(p, q)
+
+	k4, err := q.Key([]byte("correct horse"), 32)
+	require.NoError(err)
+	require.Equal(k1, k4)
+}
+
+func TestParse(t *testing.T) {
+	require := require.New(t)
+
+	// Reference encoding including a hash
+	p, err := kdf.Parse("$argon2id$v=19$m=65536,t=3,p=4$c29tZXNhbHQ$RdescudvJCsgt3ub+b+dWRWJTmaaJObG")
+	require.NoError(err)
+	require.Equal(&kdf.Argon2id{
+		Time:    3,
+		Memory:  65536,
+		Threads: 4,
+		Salt:    []byte("somesalt"),
+	}, p)
+
+	for _, s := range []string{
+		"",
+		"$argon2i$v=19$m=65536,t=3,p=4$c29tZXNhbHQ",
+		"$argon2id$v=16$m=65536,t=3,p=4$c29tZXNhbHQ",
+		"$argon2id$v=19$m=65536,t=3$c29tZXNhbHQ",
+		"$argon2id$v=19$m=65536,t=3,p=4$!",
+	} {
+		_, err := kdf.Parse(s)
+		require.ErrorIs(err, kdf.ErrParse, s)
+	}
+
+	for _, s := range []string{
+		"$argon2id$v=19$m=65536,t=0,p=4$c29tZXNhbHQ",
+		"$argon2id$v=19$m=16,t=3,p=4$c29tZXNhbHQ",
+		"$argon2id$v=19$m=65536,t=3,p=4$c2FsdA",
+		"$argon2id$v=19$m=4294967295,t=3,p=4$c29tZXNhbHQ",
+		"$argon2id$v=19$m=65536,t=4294967295,p=4$c29tZXNhbHQ",
+		"$argon2id$v=19$m=65536,t=3,p=255$c29tZXNhbHQ",
+	} {
+		_, err := kdf.Parse(s)
+		require.ErrorIs(err, kdf.ErrInvalidParams, s)
+	}
+}
+
+func TestCalibrate(t *testing.T) {
+	require := require.New(t)
+
+	target := 20 * time.Millisecond
+
+	p, err := kdf.Calibrate(target, 1024, 1)
+	require.NoError(err)
+	require.Equal(uint32(1024), p.Memory)
+	require.Equal(uint8(1), p.Threads)
+	require.GreaterOrEqual(p.Time, uint32(1))
+
+	start := time.Now()
+	_, err = p.Key([]byte("passphrase"), 32)
+	require.NoError(err)
+
+	// Allow for noise of the host
+	require.Greater(time.Since(start), target/4)
+
+	_, err = kdf.Calibrate(target, 4, 1)
+	require.ErrorIs(err, kdf.ErrInvalidParams)
+}
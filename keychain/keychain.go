@@ -0,0 +1,50 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package keychain stores PINs and passwords in the secret store of the operating system.
+//
+// It uses the macOS Keychain, the Windows Credential Manager or the
+// freedesktop.org Secret Service (via secret-tool) depending on the platform.
+package keychain
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DefaultService is the service name under which secrets are stored by default.
+const DefaultService = "hawkes"
+
+var (
+	ErrNotFound    = errors.New("secret not found in keychain")
+	ErrUnsupported = errors.New("keychain is not supported on this platform")
+)
+
+// Get retrieves the secret stored for the given service and account.
+func Get(service, account string) ([]byte, error) {
+	return get(service, account)
+}
+
+// Set stores or replaces the secret for the given service and account.
+func Set(service, account string, secret []byte) error {
+	return set(service, account, secret)
+}
+
+// Delete removes the secret for the given service and account.
+func Delete(service, account string) error {
+	return del(service, account)
+}
+
+// PINFunc returns a function which looks up PINs in the keychain
+// using the name of the provider as account.
+// It is suitable as provider.PINFunc.
+func PINFunc(service string) func(provider string) ([]byte, error) {
+	return func(name string) ([]byte, error) {
+		pin, err := Get(service, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get PIN for %s from keychain: %w", name, err)
+		}
+
+		return pin, nil
+	}
+}
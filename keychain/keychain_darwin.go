@@ -0,0 +1,48 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package keychain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+// errItemNotFound is the exit code of security(1) if no matching item exists.
+const errItemNotFound = 44
+
+func security(args ...string) ([]byte, error) {
+	out, err := exec.Command("security", args...).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == errItemNotFound {
+			return nil, ErrNotFound
+		}
+
+		return nil, fmt.Errorf("failed to run security: %w", err)
+	}
+
+	return out, nil
+}
+
+func get(service, account string) ([]byte, error) {
+	out, err := security("find-generic-password", "-s", service, "-a", account, "-w")
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(out, []byte("\n")), nil
+}
+
+func set(service, account string, secret []byte) error {
+	// Note: security(1) only accepts the password as argument
+	_, err := security("add-generic-password", "-U", "-s", service, "-a", account, "-w", string(secret))
+	return err
+}
+
+func del(service, account string) error {
+	_, err := security("delete-generic-password", "-s", service, "-a", account)
+	return err
+}
@@ -0,0 +1,18 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !darwin && !windows && !linux && !freebsd && !openbsd && !netbsd && !dragonfly
+
+package keychain
+
+func get(string, string) ([]byte, error) {
+	return nil, ErrUnsupported
+}
+
+func set(string, string, []byte) error {
+	return ErrUnsupported
+}
+
+func del(string, string) error {
+	return ErrUnsupported
+}
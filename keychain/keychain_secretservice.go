@@ -0,0 +1,60 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux || freebsd || openbsd || netbsd || dragonfly
+
+package keychain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+func secretTool(stdin []byte, args ...string) ([]byte, error) {
+	cmd := exec.Command("secret-tool", args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && len(exitErr.Stderr) == 0 {
+			return nil, ErrNotFound
+		}
+
+		if errors.Is(err, exec.ErrNotFound) {
+			return nil, fmt.Errorf("%w: secret-tool not found", ErrUnsupported)
+		}
+
+		return nil, fmt.Errorf("failed to run secret-tool: %w", err)
+	}
+
+	return out, nil
+}
+
+func get(service, account string) ([]byte, error) {
+	out, err := secretTool(nil, "lookup", "service", service, "account", account)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(out) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return out, nil
+}
+
+func set(service, account string, secret []byte) error {
+	label := fmt.Sprintf("%s: %s", service, account)
+	_, err := secretTool(secret, "store", "--label", label, "service", service, "account", account)
+	return err
+}
+
+func del(service, account string) error {
+	_, err := secretTool(nil, "clear", "service", service, "account", account)
+	return err
+}
@@ -0,0 +1,51 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package keychain_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/keychain"
+)
+
+// fakeSecretTool emulates secret-tool(1) by storing secrets in a directory.
+const fakeSecretTool = `#!/bin/sh
+cmd=$1; shift
+f="$STORE/$2-$4"
+case $cmd in
+lookup) [ -f "$f" ] || exit 1; cat "$f" ;;
+store) shift 2; cat > "$STORE/$2-$4" ;;
+clear) rm -f "$f" ;;
+esac
+`
+
+func TestSecretService(t *testing.T) {
+	require := require.New(t)
+
+	dir := t.TempDir()
+	require.NoError(os.WriteFile(filepath.Join(dir, "secret-tool"), []byte(fakeSecretTool), 0o700)) //nolint:gosec
+
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("STORE", dir)
+
+	_, err := keychain.Get(keychain.DefaultService, "YKOATH")
+	require.ErrorIs(err, keychain.ErrNotFound)
+
+	require.NoError(keychain.Set(keychain.DefaultService, "YKOATH", []byte("123456")))
+
+	pin, err := keychain.PINFunc(keychain.DefaultService)("YKOATH")
+	require.NoError(err)
+	require.Equal([]byte("123456"), pin)
+
+	require.NoError(keychain.Delete(keychain.DefaultService, "YKOATH"))
+
+	_, err = keychain.Get(keychain.DefaultService, "YKOATH")
+	require.ErrorIs(err, keychain.ErrNotFound)
+}
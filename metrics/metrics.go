@@ -0,0 +1,63 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package metrics defines a hook for observing operations of providers and keys.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Outcome classifies the result of an operation.
+type Outcome string
+
+const (
+	OutcomeSuccess  Outcome = "success"
+	OutcomeError    Outcome = "error"
+	OutcomeTimeout  Outcome = "timeout"
+	OutcomeCanceled Outcome = "canceled"
+)
+
+// OutcomeOf returns the outcome of an operation which returned err.
+func OutcomeOf(err error) Outcome {
+	switch {
+	case err == nil:
+		return OutcomeSuccess
+	case errors.Is(err, context.DeadlineExceeded):
+		return OutcomeTimeout
+	case errors.Is(err, context.Canceled):
+		return OutcomeCanceled
+	default:
+		return OutcomeError
+	}
+}
+
+// Metrics is invoked after each operation.
+// Implementations must be safe for concurrent use.
+type Metrics interface {
+	Observe(operation, provider string, duration time.Duration, outcome Outcome)
+}
+
+// HealthReporter is implemented by metrics which expose
+// the results of the health checks of providers.
+type HealthReporter interface {
+	SetHealthy(provider string, healthy bool)
+}
+
+// Func is an adapter to use ordinary functions as Metrics.
+type Func func(operation, provider string, duration time.Duration, outcome Outcome)
+
+func (f Func) Observe(operation, provider string, duration time.Duration, outcome Outcome) {
+	f(operation, provider, duration, outcome)
+}
+
+// Time runs fn and reports its duration and outcome to m.
+func Time(m Metrics, operation, provider string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	m.Observe(operation, provider, time.Since(start), OutcomeOf(err))
+
+	return err
+}
@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/metrics"
+)
+
+func TestOutcomeOf(t *testing.T) {
+	require := require.New(t)
+
+	require.Equal(metrics.OutcomeSuccess, metrics.OutcomeOf(nil))
+	require.Equal(metrics.OutcomeError, metrics.OutcomeOf(errors.ErrUnsupported))
+	require.Equal(metrics.OutcomeTimeout, metrics.OutcomeOf(context.DeadlineExceeded))
+	require.Equal(metrics.OutcomeCanceled, metrics.OutcomeOf(context.Canceled))
+}
+
+func TestPrometheus(t *testing.T) {
+	require := require.New(t)
+
+	p := metrics.NewPrometheus("hawkes")
+
+	p.Observe("hmac", "YKOATH", 30*time.Millisecond, metrics.OutcomeSuccess)
+	p.Observe("hmac", "YKOATH", 3*time.Second, metrics.OutcomeSuccess)
+
+	err := metrics.Time(p, "open_key", "File", func() error {
+		return errors.ErrUnsupported
+	})
+	require.ErrorIs(err, errors.ErrUnsupported)
+
+	sb := &strings.Builder{}
+	_, err = p.WriteTo(sb)
+	require.NoError(err)
+
+	out := sb.String()
+	require.Contains(out, "# TYPE hawkes_operation_duration_seconds histogram\n")
+	require.Contains(out, `hawkes_operation_duration_seconds_bucket{operation="hmac",provider="YKOATH",outcome="success",le="0.025"} 0`)
+	require.Contains(out, `hawkes_operation_duration_seconds_bucket{operation="hmac",provider="YKOATH",outcome="success",le="0.05"} 1`)
+	require.Contains(out, `hawkes_operation_duration_seconds_bucket{operation="hmac",provider="YKOATH",outcome="success",le="+Inf"} 2`)
+	require.Contains(out, `hawkes_operation_duration_seconds_count{operation="open_key",provider="File",outcome="error"} 1`)
+	require.NotContains(out, "hawkes_provider_up")
+
+	p.SetHealthy("YKOATH", false)
+	p.SetHealthy("File", true)
+
+	sb.Reset()
+	_, err = p.WriteTo(sb)
+	require.NoError(err)
+
+	out = sb.String()
+	require.Contains(out, "# TYPE hawkes_provider_up gauge\n")
+	require.Contains(out, "hawkes_provider_up{provider=\"File\"} 1\nhawkes_provider_up{provider=\"YKOATH\"} 0\n")
+}
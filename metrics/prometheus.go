@@ -0,0 +1,175 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds of the duration histogram in seconds.
+//
+//nolint:gochecknoglobals
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type labels struct {
+	operation, provider string
+	outcome             Outcome
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+var (
+	_ Metrics        = (*Prometheus)(nil)
+	_ HealthReporter = (*Prometheus)(nil)
+)
+
+// Prometheus collects operation metrics and exposes them
+// in the Prometheus text exposition format.
+//
+// It does not depend on the Prometheus client library.
+// Mount it as an HTTP handler or write it to an existing
+// endpoint via WriteTo.
+type Prometheus struct {
+	namespace string
+	buckets   []float64
+
+	mu         sync.Mutex
+	histograms map[labels]*histogram
+	healthy    map[string]bool
+}
+
+// NewPrometheus creates a new collector whose metric names are prefixed by namespace.
+func NewPrometheus(namespace string) *Prometheus {
+	return &Prometheus{
+		namespace:  namespace,
+		buckets:    DefaultBuckets,
+		histograms: map[labels]*histogram{},
+		healthy:    map[string]bool{},
+	}
+}
+
+func (p *Prometheus) Observe(operation, provider string, duration time.Duration, outcome Outcome) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	l := labels{operation, provider, outcome}
+
+	h, ok := p.histograms[l]
+	if !ok {
+		h = &histogram{
+			counts: make([]uint64, len(p.buckets)),
+		}
+		p.histograms[l] = h
+	}
+
+	secs := duration.Seconds()
+	for i, le := range p.buckets {
+		if secs <= le {
+			h.counts[i]++
+		}
+	}
+
+	h.count++
+	h.sum += secs
+}
+
+// SetHealthy records the result of the last health check of a provider.
+func (p *Prometheus) SetHealthy(provider string, healthy bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.healthy[provider] = healthy
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format.
+func (p *Prometheus) WriteTo(w io.Writer) (int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	name := p.name("operation_duration_seconds")
+
+	// Sort for a stable output
+	keys := make([]labels, 0, len(p.histograms))
+	for l := range p.histograms {
+		keys = append(keys, l)
+	}
+
+	slices.SortFunc(keys, func(a, b labels) int {
+		return strings.Compare(a.String(), b.String())
+	})
+
+	sb := &strings.Builder{}
+
+	fmt.Fprintf(sb, "# HELP %s Duration of operations on providers and keys.\n", name)
+	fmt.Fprintf(sb, "# TYPE %s histogram\n", name)
+
+	for _, l := range keys {
+		h := p.histograms[l]
+
+		for i, le := range p.buckets {
+			fmt.Fprintf(sb, "%s_bucket{%s,le=\"%s\"} %d\n", name, l, strconv.FormatFloat(le, 'g', -1, 64), h.counts[i])
+		}
+
+		fmt.Fprintf(sb, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, l, h.count)
+		fmt.Fprintf(sb, "%s_sum{%s} %s\n", name, l, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(sb, "%s_count{%s} %d\n", name, l, h.count)
+	}
+
+	if len(p.healthy) > 0 {
+		up := p.name("provider_up")
+
+		providers := make([]string, 0, len(p.healthy))
+		for provider := range p.healthy {
+			providers = append(providers, provider)
+		}
+
+		slices.Sort(providers)
+
+		fmt.Fprintf(sb, "# HELP %s Result of the last health check of providers.\n", up)
+		fmt.Fprintf(sb, "# TYPE %s gauge\n", up)
+
+		for _, provider := range providers {
+			v := 0
+			if p.healthy[provider] {
+				v = 1
+			}
+
+			fmt.Fprintf(sb, "%s{provider=%q} %d\n", up, provider, v)
+		}
+	}
+
+	n, err := io.WriteString(w, sb.String())
+
+	return int64(n), err
+}
+
+// ServeHTTP implements http.Handler.
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = p.WriteTo(w)
+}
+
+// name prefixes the name of a metric with the namespace.
+func (p *Prometheus) name(metric string) string {
+	if p.namespace == "" {
+		return metric
+	}
+
+	return p.namespace + "_" + metric
+}
+
+func (l labels) String() string {
+	return fmt.Sprintf("operation=%q,provider=%q,outcome=%q", l.operation, l.provider, l.outcome)
+}
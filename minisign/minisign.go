@@ -0,0 +1,360 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package minisign creates and verifies Ed25519 signatures in the formats
+// of minisign and OpenBSD's signify so that release artifacts can be signed
+// with hardware-backed keys and verified with the widely available tools.
+//
+// See: https://jedisct1.github.io/minisign/
+// See: https://man.openbsd.org/signify
+package minisign
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+
+	"cunicu.li/hawkes/provider"
+)
+
+var (
+	ErrUnsupportedKey       = errors.New("unsupported key type")
+	ErrUnsupportedAlgorithm = errors.New("unsupported signature algorithm")
+	ErrMalformed            = errors.New("malformed input")
+	ErrKeyIDMismatch        = errors.New("key ID mismatch")
+	ErrInvalidSignature     = errors.New("invalid signature")
+	ErrInvalidComment       = errors.New("comments must not contain line breaks")
+)
+
+const (
+	untrustedPrefix = "untrusted comment: "
+	trustedPrefix   = "trusted comment: "
+)
+
+// Algorithm is the signature algorithm identifier.
+type Algorithm string
+
+const (
+	// Ed25519 signs the message itself as done by signify and legacy minisign.
+	Ed25519 Algorithm = "Ed"
+
+	// Ed25519BLAKE2b signs the BLAKE2b-512 digest of the message as done by minisign.
+	Ed25519BLAKE2b Algorithm = "ED"
+)
+
+// KeyID identifies the key of a signature.
+type KeyID [8]byte
+
+func (id KeyID) String() string {
+	// minisign prints the little-endian key number in hex
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(id[:]))
+}
+
+// PublicKey is a minisign or signify public key.
+type PublicKey struct {
+	KeyID KeyID
+	Key   ed25519.PublicKey
+}
+
+// ParsePublicKey parses a public key file or its base64-encoded key line.
+func ParsePublicKey(b []byte) (*PublicKey, error) {
+	lines := splitLines(b)
+	if len(lines) > 0 && strings.HasPrefix(lines[0], untrustedPrefix) {
+		lines = lines[1:]
+	}
+
+	if len(lines) != 1 {
+		return nil, fmt.Errorf("%w: expected a single key line", ErrMalformed)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformed, err)
+	} else if len(raw) != 2+8+ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: invalid key length", ErrMalformed)
+	} else if Algorithm(raw[:2]) != Ed25519 {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, raw[:2])
+	}
+
+	pk := &PublicKey{
+		Key: ed25519.PublicKey(raw[10:]),
+	}
+
+	copy(pk.KeyID[:], raw[2:10])
+
+	return pk, nil
+}
+
+// String returns the base64-encoded key as it is passed to "minisign -P".
+func (pk *PublicKey) String() string {
+	raw := append([]byte(Ed25519), pk.KeyID[:]...)
+	raw = append(raw, pk.Key...)
+
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+// Marshal returns the content of a minisign public key file.
+func (pk *PublicKey) Marshal() []byte {
+	return fmt.Appendf(nil, "%sminisign public key %s\n%s\n", untrustedPrefix, pk.KeyID, pk)
+}
+
+// MarshalSignify returns the content of a signify public key file.
+func (pk *PublicKey) MarshalSignify() []byte {
+	return fmt.Appendf(nil, "%ssignify public key\n%s\n", untrustedPrefix, pk)
+}
+
+// Verify checks the signature of the message including the trusted comment if present.
+func (pk *PublicKey) Verify(sig *Signature, message io.Reader) error {
+	if sig.KeyID != pk.KeyID {
+		return fmt.Errorf("%w: signature key %s, public key %s", ErrKeyIDMismatch, sig.KeyID, pk.KeyID)
+	}
+
+	msg, err := signedMessage(sig.Algorithm, message)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(pk.Key, msg, sig.Signature) {
+		return ErrInvalidSignature
+	}
+
+	if sig.GlobalSignature != nil {
+		if !ed25519.Verify(pk.Key, sig.global(), sig.GlobalSignature) {
+			return fmt.Errorf("%w: trusted comment", ErrInvalidSignature)
+		}
+	} else if sig.Algorithm == Ed25519BLAKE2b {
+		return fmt.Errorf("%w: missing trusted comment", ErrMalformed)
+	}
+
+	return nil
+}
+
+// Signature is a minisign or signify signature.
+//
+// Signify signatures have neither a trusted comment nor a global signature.
+type Signature struct {
+	Algorithm        Algorithm
+	KeyID            KeyID
+	Signature        []byte
+	UntrustedComment string
+
+	// TrustedComment is authenticated by the GlobalSignature.
+	TrustedComment  string
+	GlobalSignature []byte
+}
+
+// global returns the data which is signed by the global signature.
+func (s *Signature) global() []byte {
+	return append(bytes.Clone(s.Signature), s.TrustedComment...)
+}
+
+// Marshal returns the content of the signature file.
+func (s *Signature) Marshal() []byte {
+	raw := append([]byte(s.Algorithm), s.KeyID[:]...)
+	raw = append(raw, s.Signature...)
+
+	b := fmt.Appendf(nil, "%s%s\n%s\n", untrustedPrefix, s.UntrustedComment, base64.StdEncoding.EncodeToString(raw))
+
+	if s.GlobalSignature != nil {
+		b = fmt.Appendf(b, "%s%s\n%s\n", trustedPrefix, s.TrustedComment, base64.StdEncoding.EncodeToString(s.GlobalSignature))
+	}
+
+	return b
+}
+
+// ParseSignature parses a minisign or signify signature file.
+func ParseSignature(b []byte) (*Signature, error) {
+	lines := splitLines(b)
+	if len(lines) != 2 && len(lines) != 4 {
+		return nil, fmt.Errorf("%w: unexpected number of lines", ErrMalformed)
+	}
+
+	if !strings.HasPrefix(lines[0], untrustedPrefix) {
+		return nil, fmt.Errorf("%w: missing untrusted comment", ErrMalformed)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformed, err)
+	} else if len(raw) != 2+8+ed25519.SignatureSize {
+		return nil, fmt.Errorf("%w: invalid signature length", ErrMalformed)
+	}
+
+	s := &Signature{
+		Algorithm:        Algorithm(raw[:2]),
+		Signature:        raw[10:],
+		UntrustedComment: strings.TrimPrefix(lines[0], untrustedPrefix),
+	}
+
+	copy(s.KeyID[:], raw[2:10])
+
+	if s.Algorithm != Ed25519 && s.Algorithm != Ed25519BLAKE2b {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, s.Algorithm)
+	}
+
+	if len(lines) == 4 {
+		if !strings.HasPrefix(lines[2], trustedPrefix) {
+			return nil, fmt.Errorf("%w: missing trusted comment", ErrMalformed)
+		}
+
+		s.TrustedComment = strings.TrimPrefix(lines[2], trustedPrefix)
+
+		if s.GlobalSignature, err = base64.StdEncoding.DecodeString(lines[3]); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrMalformed, err)
+		} else if len(s.GlobalSignature) != ed25519.SignatureSize {
+			return nil, fmt.Errorf("%w: invalid global signature length", ErrMalformed)
+		}
+	}
+
+	return s, nil
+}
+
+// Signer creates signatures with an Ed25519 crypto.Signer.
+type Signer struct {
+	crypto.Signer
+
+	KeyID KeyID
+}
+
+// NewSigner creates a signer whose key ID is derived from the public key.
+func NewSigner(signer crypto.Signer) (*Signer, error) {
+	pub, ok := signer.Public().(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, signer.Public())
+	}
+
+	// Hardware keys have no random key number stored alongside,
+	// so we derive a stable one from the public key.
+	digest := blake2b.Sum512(pub)
+
+	s := &Signer{
+		Signer: signer,
+	}
+
+	copy(s.KeyID[:], digest[:])
+
+	return s, nil
+}
+
+// NewProviderSigner creates a signer for an Ed25519 key of a provider.
+func NewProviderSigner(key provider.PrivateKey) (*Signer, error) {
+	sk, ok := key.(provider.PrivateKeySigner)
+	if !ok {
+		return nil, fmt.Errorf("%w: key does not support signing", ErrUnsupportedKey)
+	}
+
+	signer, err := sk.Signer()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSigner(signer)
+}
+
+// PublicKey returns the public key of the signer.
+func (s *Signer) PublicKey() *PublicKey {
+	return &PublicKey{
+		KeyID: s.KeyID,
+		Key:   s.Public().(ed25519.PublicKey), //nolint:forcetypeassert
+	}
+}
+
+// Sign creates a minisign signature over the BLAKE2b-512 digest of the message.
+// If empty, the trusted comment defaults to the current timestamp like minisign does.
+func (s *Signer) Sign(message io.Reader, trustedComment string) (*Signature, error) {
+	if strings.ContainsAny(trustedComment, "\r\n") {
+		return nil, ErrInvalidComment
+	}
+
+	if trustedComment == "" {
+		trustedComment = fmt.Sprintf("timestamp:%d", time.Now().Unix())
+	}
+
+	sig, err := s.sign(Ed25519BLAKE2b, message)
+	if err != nil {
+		return nil, err
+	}
+
+	sig.UntrustedComment = "signature from hawkes secret key"
+	sig.TrustedComment = trustedComment
+
+	if sig.GlobalSignature, err = s.Signer.Sign(rand.Reader, sig.global(), crypto.Hash(0)); err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+
+	return sig, nil
+}
+
+// SignSignify creates a signify signature over the message.
+// The key file name is used in the untrusted comment as signify does.
+func (s *Signer) SignSignify(message io.Reader, keyFile string) (*Signature, error) {
+	if strings.ContainsAny(keyFile, "\r\n") {
+		return nil, ErrInvalidComment
+	}
+
+	sig, err := s.sign(Ed25519, message)
+	if err != nil {
+		return nil, err
+	}
+
+	sig.UntrustedComment = "verify with " + keyFile
+
+	return sig, nil
+}
+
+func (s *Signer) sign(alg Algorithm, message io.Reader) (*Signature, error) {
+	msg, err := signedMessage(alg, message)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := s.Signer.Sign(rand.Reader, msg, crypto.Hash(0))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+
+	return &Signature{
+		Algorithm: alg,
+		KeyID:     s.KeyID,
+		Signature: sig,
+	}, nil
+}
+
+func signedMessage(alg Algorithm, message io.Reader) ([]byte, error) {
+	switch alg {
+	case Ed25519:
+		return io.ReadAll(message)
+
+	case Ed25519BLAKE2b:
+		h, _ := blake2b.New512(nil)
+		if _, err := io.Copy(h, message); err != nil {
+			return nil, err
+		}
+
+		return h.Sum(nil), nil
+
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, alg)
+	}
+}
+
+func splitLines(b []byte) (lines []string) {
+	// Only line endings are stripped as trailing spaces are part of the trusted comment
+	for _, line := range strings.Split(string(b), "\n") {
+		if line = strings.TrimSuffix(line, "\r"); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
@@ -0,0 +1,105 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package minisign_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/minisign"
+)
+
+func newSigner(t *testing.T) *minisign.Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	s, err := minisign.NewSigner(key)
+	require.NoError(t, err)
+
+	return s
+}
+
+func TestMinisign(t *testing.T) {
+	require := require.New(t)
+
+	s := newSigner(t)
+	message := []byte("hawkes-v1.0.0.tar.gz contents")
+
+	sig, err := s.Sign(bytes.NewReader(message), "timestamp:1700000000\tfile:hawkes-v1.0.0.tar.gz")
+	require.NoError(err)
+	require.Equal(minisign.Ed25519BLAKE2b, sig.Algorithm)
+
+	pk, err := minisign.ParsePublicKey(s.PublicKey().Marshal())
+	require.NoError(err)
+	require.Equal(s.KeyID, pk.KeyID)
+
+	// The bare key as passed to "minisign -P"
+	pk2, err := minisign.ParsePublicKey([]byte(pk.String()))
+	require.NoError(err)
+	require.Equal(pk, pk2)
+
+	parsed, err := minisign.ParseSignature(sig.Marshal())
+	require.NoError(err)
+	require.Equal(sig, parsed)
+	require.NoError(pk.Verify(parsed, bytes.NewReader(message)))
+
+	require.ErrorIs(pk.Verify(parsed, bytes.NewReader(message[1:])), minisign.ErrInvalidSignature)
+
+	// The trusted comment is authenticated
+	parsed.TrustedComment = "timestamp:1800000000"
+	require.ErrorIs(pk.Verify(parsed, bytes.NewReader(message)), minisign.ErrInvalidSignature)
+
+	// Signatures without trusted comment are only accepted for signify
+	parsed.GlobalSignature = nil
+	require.ErrorIs(pk.Verify(parsed, bytes.NewReader(message)), minisign.ErrMalformed)
+
+	other := newSigner(t)
+	require.ErrorIs(other.PublicKey().Verify(sig, bytes.NewReader(message)), minisign.ErrKeyIDMismatch)
+
+	// Line breaks would corrupt the signature file
+	for _, comment := range []string{"timestamp:1\nfile:x", "timestamp:1\r"} {
+		_, err = s.Sign(bytes.NewReader(message), comment)
+		require.ErrorIs(err, minisign.ErrInvalidComment)
+	}
+}
+
+func TestSignify(t *testing.T) {
+	require := require.New(t)
+
+	s := newSigner(t)
+	message := []byte("SHA256 (hawkes-v1.0.0.tar.gz) = 0123\n")
+
+	sig, err := s.SignSignify(bytes.NewReader(message), "hawkes.pub")
+	require.NoError(err)
+	require.Equal(minisign.Ed25519, sig.Algorithm)
+	require.Nil(sig.GlobalSignature)
+	require.Equal(2, bytes.Count(sig.Marshal(), []byte("\n")))
+
+	pk, err := minisign.ParsePublicKey(s.PublicKey().MarshalSignify())
+	require.NoError(err)
+
+	parsed, err := minisign.ParseSignature(sig.Marshal())
+	require.NoError(err)
+	require.Equal("verify with hawkes.pub", parsed.UntrustedComment)
+	require.NoError(pk.Verify(parsed, bytes.NewReader(message)))
+
+	// Signify signs the message directly, so the signature is a plain Ed25519 signature
+	require.True(ed25519.Verify(pk.Key, message, parsed.Signature))
+}
+
+func TestUnsupportedKey(t *testing.T) {
+	require := require.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	_, err = minisign.NewSigner(key)
+	require.ErrorIs(err, minisign.ErrUnsupportedKey)
+}
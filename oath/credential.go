@@ -0,0 +1,314 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package oath implements OATH HOTP/TOTP credentials and
+// their exchange with authenticator applications.
+package oath
+
+import (
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrInvalidCredential = errors.New("invalid credential")
+	ErrInvalidURI        = errors.New("invalid otpauth URI")
+	ErrUnsupportedType   = errors.New("unsupported credential type")
+	ErrUnsupportedAlgo   = errors.New("unsupported algorithm")
+	ErrCounterNotFound   = errors.New("counter not found")
+)
+
+// Type is the type of an OATH credential.
+type Type string
+
+const (
+	TOTP Type = "totp"
+	HOTP Type = "hotp"
+)
+
+// Algorithm is the hash algorithm of the HMAC used by an OATH credential.
+type Algorithm string
+
+const (
+	SHA1   Algorithm = "SHA1"
+	SHA256 Algorithm = "SHA256"
+	SHA512 Algorithm = "SHA512"
+)
+
+const (
+	DefaultDigits = 6
+	DefaultPeriod = 30 * time.Second
+)
+
+// Credential is an OATH HOTP or TOTP credential.
+type Credential struct {
+	Type      Type
+	Algorithm Algorithm
+	Issuer    string
+	Account   string
+	Secret    []byte
+	Digits    int
+
+	// Period is the time step of TOTP credentials.
+	Period time.Duration
+
+	// Counter is the moving factor of HOTP credentials.
+	Counter uint64
+
+	// Touch requires a physical tap on the token for each code.
+	// It is only supported by hardware tokens.
+	Touch bool
+}
+
+// Validate checks the credential and fills in defaults for missing parameters.
+func (c *Credential) Validate() error {
+	switch c.Type {
+	case TOTP, HOTP:
+	case "":
+		c.Type = TOTP
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedType, c.Type)
+	}
+
+	algo, err := ParseAlgorithm(string(c.Algorithm))
+	if err != nil {
+		return err
+	}
+
+	c.Algorithm = algo
+
+	if c.Digits == 0 {
+		c.Digits = DefaultDigits
+	} else if c.Digits < 6 || c.Digits > 8 {
+		return fmt.Errorf("%w: unsupported number of digits %d", ErrInvalidCredential, c.Digits)
+	}
+
+	if c.Type == TOTP && c.Period == 0 {
+		c.Period = DefaultPeriod
+	} else if c.Period < 0 || c.Period%time.Second != 0 {
+		return fmt.Errorf("%w: invalid period %s", ErrInvalidCredential, c.Period)
+	}
+
+	if len(c.Secret) == 0 {
+		return fmt.Errorf("%w: missing secret", ErrInvalidCredential)
+	}
+
+	if c.Account == "" {
+		return fmt.Errorf("%w: missing account name", ErrInvalidCredential)
+	}
+
+	if !utf8.ValidString(c.Issuer) || !utf8.ValidString(c.Account) {
+		return fmt.Errorf("%w: name is not valid UTF-8", ErrInvalidCredential)
+	}
+
+	// The issuer is separated from the account by the first colon
+	if strings.Contains(c.Issuer, ":") {
+		return fmt.Errorf("%w: issuer must not contain a colon", ErrInvalidCredential)
+	}
+
+	return nil
+}
+
+// Name returns the credential name as used by the YKOATH applet and ykman:
+// "[period/][issuer:]account" where the period is omitted if it is the default.
+//
+// Names are kept unambiguous for ParseName: accounts containing colons
+// get an empty issuer prefix and the default period is included if the
+// name would otherwise start with something looking like a period.
+func (c *Credential) Name() string {
+	n := c.Account
+	if c.Issuer != "" || strings.Contains(n, ":") {
+		n = c.Issuer + ":" + n
+	}
+
+	if c.Type == TOTP {
+		period := c.Period
+		if period == 0 {
+			period = DefaultPeriod
+		}
+
+		if _, ok := parsePeriod(n); ok || period != DefaultPeriod {
+			n = fmt.Sprintf("%d/%s", int(period.Seconds()), n)
+		}
+	}
+
+	return n
+}
+
+// ParseName splits a credential name as returned by Name into its parts.
+// The period is the default period if the name has no period prefix.
+func ParseName(name string) (period time.Duration, issuer, account string) {
+	period = DefaultPeriod
+
+	if p, ok := parsePeriod(name); ok {
+		period = p
+		_, name, _ = strings.Cut(name, "/")
+	}
+
+	if i, a, ok := strings.Cut(name, ":"); ok {
+		return period, i, a
+	}
+
+	return period, "", name
+}
+
+// parsePeriod parses the period prefix of a credential name.
+func parsePeriod(name string) (time.Duration, bool) {
+	p, _, ok := strings.Cut(name, "/")
+	if !ok {
+		return 0, false
+	}
+
+	secs, err := strconv.Atoi(p)
+	if err != nil || secs <= 0 {
+		return 0, false
+	}
+
+	return time.Duration(secs) * time.Second, true
+}
+
+func (c *Credential) String() string {
+	s := fmt.Sprintf("%s (%s, %s, %d digits)", c.Name(), strings.ToUpper(string(c.Type)), c.Algorithm, c.Digits)
+	if c.Touch {
+		s += " [touch]"
+	}
+
+	return s
+}
+
+// ParseAlgorithm parses the name of a hash algorithm in the notations used by authenticator apps.
+// An empty name defaults to SHA1.
+func ParseAlgorithm(s string) (Algorithm, error) {
+	switch strings.ToUpper(strings.ReplaceAll(s, "-", "")) {
+	case "", "SHA1", "HMACSHA1":
+		return SHA1, nil
+	case "SHA256", "HMACSHA256":
+		return SHA256, nil
+	case "SHA512", "HMACSHA512":
+		return SHA512, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedAlgo, s)
+	}
+}
+
+// DecodeSecret decodes a base32-encoded secret as used by otpauth URIs.
+// Padding, whitespace and lowercase letters are tolerated.
+func DecodeSecret(s string) ([]byte, error) {
+	s = strings.ToUpper(strings.Join(strings.Fields(s), ""))
+	s = strings.TrimRight(s, "=")
+
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid secret: %w", ErrInvalidCredential, err)
+	}
+
+	return secret, nil
+}
+
+// ParseURI parses an otpauth:// URI as described by
+// https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+func ParseURI(s string) (*Credential, error) {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidURI, err)
+	}
+
+	if u.Scheme != "otpauth" {
+		return nil, fmt.Errorf("%w: unexpected scheme %q", ErrInvalidURI, u.Scheme)
+	}
+
+	q := u.Query()
+	c := &Credential{
+		Type: Type(strings.ToLower(u.Host)),
+	}
+
+	if c.Issuer, c.Account, err = parseLabel(u, q.Get("issuer")); err != nil {
+		return nil, err
+	}
+
+	// The issuer parameter takes precedence over the label prefix
+	if issuer := q.Get("issuer"); issuer != "" {
+		c.Issuer = issuer
+	}
+
+	if c.Secret, err = DecodeSecret(q.Get("secret")); err != nil {
+		return nil, err
+	}
+
+	if c.Algorithm, err = ParseAlgorithm(q.Get("algorithm")); err != nil {
+		return nil, err
+	}
+
+	if d := q.Get("digits"); d != "" {
+		if c.Digits, err = strconv.Atoi(d); err != nil {
+			return nil, fmt.Errorf("%w: invalid digits: %w", ErrInvalidURI, err)
+		}
+	}
+
+	if p := q.Get("period"); p != "" {
+		secs, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid period: %w", ErrInvalidURI, err)
+		}
+
+		c.Period = time.Duration(secs) * time.Second
+	}
+
+	if cnt := q.Get("counter"); cnt != "" {
+		if c.Counter, err = strconv.ParseUint(cnt, 10, 64); err != nil {
+			return nil, fmt.Errorf("%w: invalid counter: %w", ErrInvalidURI, err)
+		}
+	} else if c.Type == HOTP {
+		return nil, fmt.Errorf("%w: missing counter", ErrInvalidURI)
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// parseLabel splits the label of an otpauth:// URI into issuer and account.
+// A literal colon separates both so that URL-encoded colons can be part of
+// the account. Labels without literal colon are split at an encoded colon
+// as allowed by the key URI format unless the remainder does not match the issuer parameter.
+func parseLabel(u *url.URL, issuer string) (string, string, error) {
+	unescape := func(s string) (string, error) {
+		s, err := url.PathUnescape(s)
+		if err != nil {
+			return "", fmt.Errorf("%w: invalid label: %w", ErrInvalidURI, err)
+		}
+
+		return strings.TrimSpace(s), nil
+	}
+
+	raw := strings.TrimPrefix(u.EscapedPath(), "/")
+	if i, a, ok := strings.Cut(raw, ":"); ok {
+		i, err := unescape(i)
+		if err != nil {
+			return "", "", err
+		}
+
+		a, err = unescape(a)
+
+		return i, a, err
+	}
+
+	label, err := unescape(raw)
+	if err != nil {
+		return "", "", err
+	}
+
+	if i, a, ok := strings.Cut(label, ":"); ok && (issuer == "" || issuer == i) {
+		return strings.TrimSpace(i), strings.TrimSpace(a), nil
+	}
+
+	return "", label, nil
+}
@@ -0,0 +1,145 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package oath
+
+import (
+	"encoding/base32"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// URI returns the credential as otpauth:// URI as described by
+// https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+//
+// Colons within the account are URL-encoded as a literal colon separates it from the issuer.
+func (c *Credential) URI() string {
+	escape := func(s string) string {
+		return strings.ReplaceAll(url.PathEscape(s), ":", "%3A")
+	}
+
+	label, rawLabel := c.Account, escape(c.Account)
+	if c.Issuer != "" || strings.Contains(c.Account, ":") {
+		label = c.Issuer + ":" + label
+		rawLabel = escape(c.Issuer) + ":" + rawLabel
+	}
+
+	q := url.Values{}
+	q.Set("secret", base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(c.Secret))
+
+	if c.Issuer != "" {
+		q.Set("issuer", c.Issuer)
+	}
+
+	if c.Algorithm != "" && c.Algorithm != SHA1 {
+		q.Set("algorithm", string(c.Algorithm))
+	}
+
+	if c.Digits != 0 && c.Digits != DefaultDigits {
+		q.Set("digits", strconv.Itoa(c.Digits))
+	}
+
+	switch c.Type {
+	case HOTP:
+		q.Set("counter", strconv.FormatUint(c.Counter, 10))
+	default:
+		if c.Period != 0 && c.Period != DefaultPeriod {
+			q.Set("period", strconv.Itoa(int(c.Period.Seconds())))
+		}
+	}
+
+	typ := c.Type
+	if typ == "" {
+		typ = TOTP
+	}
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     string(typ),
+		Path:     "/" + label,
+		RawPath:  "/" + rawLabel,
+		RawQuery: q.Encode(),
+	}
+
+	return u.String()
+}
+
+// ExportURIs writes the credentials as otpauth:// URIs, one per line.
+func ExportURIs(w io.Writer, creds []*Credential) error {
+	for _, c := range creds {
+		if _, err := fmt.Fprintln(w, c.URI()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PassName returns the name of the password store entry for a credential: "otp/[issuer/]account".
+func PassName(c *Credential) string {
+	clean := func(s string) string {
+		return strings.NewReplacer("/", "_", "\x00", "").Replace(s)
+	}
+
+	if c.Issuer == "" {
+		return "otp/" + clean(c.Account)
+	}
+
+	return "otp/" + clean(c.Issuer) + "/" + clean(c.Account)
+}
+
+// ExportPass writes a shell script which inserts the credentials into
+// the password store using the pass-otp extension (https://github.com/tadfisher/pass-otp).
+// As pass encrypts entries with GnuPG, the credentials are piped into "pass otp insert".
+func ExportPass(w io.Writer, creds []*Credential) error {
+	quote := func(s string) string {
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	}
+
+	if _, err := fmt.Fprintln(w, "#!/bin/sh\nset -e"); err != nil {
+		return err
+	}
+
+	for _, c := range creds {
+		if _, err := fmt.Fprintf(w, "printf '%%s\\n' %s | pass otp insert --force %s\n", quote(c.URI()), quote(PassName(c))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ExportKeePassXC writes the credentials as CSV file which can be imported by KeePassXC.
+// KeePassXC only supports TOTP, HOTP credentials are skipped.
+func ExportKeePassXC(w io.Writer, creds []*Credential) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write([]string{"Group", "Title", "Username", "Password", "URL", "Notes", "TOTP"}); err != nil {
+		return err
+	}
+
+	for _, c := range creds {
+		if c.Type == HOTP {
+			slog.Warn("Skipping HOTP credential which is not supported by KeePassXC", slog.String("name", c.Name()))
+			continue
+		}
+
+		title := c.Issuer
+		if title == "" {
+			title = c.Account
+		}
+
+		if err := cw.Write([]string{"OTP", title, c.Account, "", "", "", c.URI()}); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
@@ -0,0 +1,132 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package oath_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/oath"
+)
+
+func testCredentials() []*oath.Credential {
+	return []*oath.Credential{
+		{
+			Type:      oath.TOTP,
+			Algorithm: oath.SHA256,
+			Issuer:    "Example Corp",
+			Account:   "alice@example.com",
+			Secret:    []byte(testSecretString),
+			Digits:    8,
+			Period:    time.Minute,
+		},
+		{
+			Type:      oath.HOTP,
+			Algorithm: oath.SHA1,
+			Account:   "bob",
+			Secret:    []byte(testSecretString),
+			Digits:    6,
+			Counter:   5,
+		},
+	}
+}
+
+func TestURIRoundTrip(t *testing.T) {
+	require := require.New(t)
+
+	for _, c := range testCredentials() {
+		uri := c.URI()
+
+		c2, err := oath.ParseURI(uri)
+		require.NoError(err, uri)
+		require.Equal(c, c2)
+	}
+
+	require.Equal("otpauth://hotp/bob?counter=5&secret="+testSecret, testCredentials()[1].URI())
+}
+
+func TestURIRoundTripNames(t *testing.T) {
+	require := require.New(t)
+
+	for _, n := range []struct{ issuer, account string }{
+		{"Bücherei München", "jürgen@example.de"},
+		{"楽天", "ユーザー@example.jp"},
+		{"בנק", "משתמש"},
+		{"مصرف", "مستخدم@example.com"},
+		{"Example", "alice@host:1234"},
+		{"", "host:1234"},
+		{"", "2024/alice"},
+		{"", "60/alice"},
+		{"Example", "a%3Ab/c?d"},
+	} {
+		c := &oath.Credential{
+			Type:    oath.TOTP,
+			Issuer:  n.issuer,
+			Account: n.account,
+			Secret:  []byte(testSecretString),
+		}
+		require.NoError(c.Validate())
+
+		c2, err := oath.ParseURI(c.URI())
+		require.NoError(err, c.URI())
+		require.Equal(c, c2, c.URI())
+
+		period, issuer, account := oath.ParseName(c.Name())
+		require.Equal(oath.DefaultPeriod, period, c.Name())
+		require.Equal(n.issuer, issuer, c.Name())
+		require.Equal(n.account, account, c.Name())
+	}
+
+	// Encoded colons separate the issuer as allowed by the key URI format
+	c, err := oath.ParseURI("otpauth://totp/Example%3Aalice?secret=" + testSecret + "&issuer=Example")
+	require.NoError(err)
+	require.Equal("Example", c.Issuer)
+	require.Equal("alice", c.Account)
+
+	c, err = oath.ParseURI("otpauth://totp/B%C3%BCcherei:j%C3%BCrgen?secret=" + testSecret)
+	require.NoError(err)
+	require.Equal("Bücherei", c.Issuer)
+	require.Equal("jürgen", c.Account)
+
+	err = (&oath.Credential{Issuer: "a:b", Account: "alice", Secret: []byte("x")}).Validate()
+	require.ErrorIs(err, oath.ErrInvalidCredential)
+
+	err = (&oath.Credential{Account: "\xff\xfe", Secret: []byte("x")}).Validate()
+	require.ErrorIs(err, oath.ErrInvalidCredential)
+}
+
+func TestExportPass(t *testing.T) {
+	require := require.New(t)
+
+	buf := &bytes.Buffer{}
+	require.NoError(oath.ExportPass(buf, testCredentials()))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(lines, 4)
+	require.Equal("#!/bin/sh", lines[0])
+	require.Contains(lines[2], "| pass otp insert --force 'otp/Example Corp/alice@example.com'")
+	require.Contains(lines[3], "'otpauth://hotp/bob?counter=5&secret="+testSecret+"'")
+}
+
+func TestExportKeePassXC(t *testing.T) {
+	require := require.New(t)
+
+	buf := &bytes.Buffer{}
+	require.NoError(oath.ExportKeePassXC(buf, testCredentials()))
+
+	records, err := csv.NewReader(buf).ReadAll()
+	require.NoError(err)
+	require.Len(records, 2) // HOTP is not supported
+	require.Equal("TOTP", records[0][6])
+	require.Equal("Example Corp", records[1][1])
+
+	c, err := oath.ParseURI(records[1][6])
+	require.NoError(err)
+	require.Equal(testCredentials()[0], c)
+}
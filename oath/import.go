@@ -0,0 +1,487 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package oath
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1" //nolint:gosec
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+var (
+	ErrUnknownFormat    = errors.New("unknown backup format")
+	ErrPasswordRequired = errors.New("backup is encrypted and requires a password")
+	ErrDecrypt          = errors.New("failed to decrypt backup")
+)
+
+// Format is the format of an authenticator backup.
+type Format string
+
+const (
+	FormatAegis   Format = "aegis"
+	FormatAndOTP  Format = "andotp"
+	FormatFreeOTP Format = "freeotp+"
+	FormatURIs    Format = "uris"
+	FormatUnknown Format = ""
+)
+
+// Import parses an authenticator backup of the given format.
+// If the format is FormatUnknown, it is detected from the contents.
+// The password is only required for encrypted backups.
+// Entries of unsupported types like Steam or mOTP are skipped.
+func Import(data, password []byte, format Format) ([]*Credential, error) {
+	if format == FormatUnknown {
+		format = DetectFormat(data)
+	}
+
+	switch format {
+	case FormatAegis:
+		return ImportAegis(data, password)
+	case FormatAndOTP:
+		return ImportAndOTP(data, password)
+	case FormatFreeOTP:
+		return ImportFreeOTP(data)
+	case FormatURIs:
+		return ImportURIs(data)
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+// DetectFormat guesses the format of an authenticator backup.
+// Encrypted andOTP backups can not be distinguished from random data
+// and are assumed if no other format matches.
+func DetectFormat(data []byte) Format {
+	trimmed := bytes.TrimSpace(data)
+
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("otpauth://")):
+		return FormatURIs
+
+	case bytes.HasPrefix(trimmed, []byte("[")):
+		return FormatAndOTP
+
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		var probe struct {
+			Header json.RawMessage `json:"header"`
+			Tokens json.RawMessage `json:"tokens"`
+		}
+
+		if err := json.Unmarshal(trimmed, &probe); err != nil {
+			return FormatUnknown
+		}
+
+		switch {
+		case probe.Header != nil:
+			return FormatAegis
+		case probe.Tokens != nil:
+			return FormatFreeOTP
+		}
+
+		return FormatUnknown
+	}
+
+	return FormatAndOTP
+}
+
+// ImportURIs parses a list of otpauth:// URIs, one per line.
+// This is the plain-text export format of FreeOTP+, Aegis and others.
+func ImportURIs(data []byte) (creds []*Credential, err error) {
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; s.Scan(); lineNo++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+
+		c, err := ParseURI(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+
+		creds = append(creds, c)
+	}
+
+	return creds, s.Err()
+}
+
+func skip(format Format, name, typ string) {
+	slog.Warn("Skipping credential of unsupported type",
+		slog.String("format", string(format)),
+		slog.String("name", name),
+		slog.String("type", typ))
+}
+
+// Aegis
+// See: https://github.com/beemdevelopment/Aegis/blob/master/docs/vault.md
+
+type aegisVault struct {
+	Version int `json:"version"`
+	Header  struct {
+		Slots  []aegisSlot   `json:"slots"`
+		Params *aegisKeyInfo `json:"params"`
+	} `json:"header"`
+	DB json.RawMessage `json:"db"`
+}
+
+type aegisKeyInfo struct {
+	Nonce string `json:"nonce"`
+	Tag   string `json:"tag"`
+}
+
+type aegisSlot struct {
+	Type      int          `json:"type"`
+	Key       string       `json:"key"`
+	KeyParams aegisKeyInfo `json:"key_params"`
+	N         int          `json:"n"`
+	R         int          `json:"r"`
+	P         int          `json:"p"`
+	Salt      string       `json:"salt"`
+}
+
+type aegisDB struct {
+	Version int `json:"version"`
+	Entries []struct {
+		Type   string `json:"type"`
+		Name   string `json:"name"`
+		Issuer string `json:"issuer"`
+		Info   struct {
+			Secret  string `json:"secret"`
+			Algo    string `json:"algo"`
+			Digits  int    `json:"digits"`
+			Period  int    `json:"period"`
+			Counter uint64 `json:"counter"`
+		} `json:"info"`
+	} `json:"entries"`
+}
+
+const aegisSlotPassword = 1
+
+// ImportAegis parses a plain or encrypted Aegis vault.
+func ImportAegis(data, password []byte) ([]*Credential, error) {
+	var vault aegisVault
+	if err := json.Unmarshal(data, &vault); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnknownFormat, err)
+	}
+
+	dbJSON := []byte(vault.DB)
+
+	if vault.Header.Params != nil {
+		if password == nil {
+			return nil, ErrPasswordRequired
+		}
+
+		var ct string
+		if err := json.Unmarshal(vault.DB, &ct); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrUnknownFormat, err)
+		}
+
+		ctBytes, err := base64.StdEncoding.DecodeString(ct)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrUnknownFormat, err)
+		}
+
+		masterKey, err := vault.masterKey(password)
+		if err != nil {
+			return nil, err
+		}
+
+		if dbJSON, err = aegisOpen(masterKey, *vault.Header.Params, ctBytes); err != nil {
+			return nil, err
+		}
+	}
+
+	var db aegisDB
+	if err := json.Unmarshal(dbJSON, &db); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnknownFormat, err)
+	}
+
+	creds := []*Credential{}
+	for _, e := range db.Entries {
+		typ := Type(strings.ToLower(e.Type))
+		if typ != TOTP && typ != HOTP {
+			skip(FormatAegis, e.Name, e.Type)
+			continue
+		}
+
+		secret, err := DecodeSecret(e.Info.Secret)
+		if err != nil {
+			return nil, err
+		}
+
+		algo, err := ParseAlgorithm(e.Info.Algo)
+		if err != nil {
+			return nil, err
+		}
+
+		c := &Credential{
+			Type:      typ,
+			Algorithm: algo,
+			Issuer:    e.Issuer,
+			Account:   e.Name,
+			Secret:    secret,
+			Digits:    e.Info.Digits,
+			Period:    time.Duration(e.Info.Period) * time.Second,
+			Counter:   e.Info.Counter,
+		}
+
+		if err := c.Validate(); err != nil {
+			return nil, err
+		}
+
+		creds = append(creds, c)
+	}
+
+	return creds, nil
+}
+
+// masterKey tries to decrypt the master key with all password slots.
+func (v *aegisVault) masterKey(password []byte) ([]byte, error) {
+	for _, slot := range v.Header.Slots {
+		if slot.Type != aegisSlotPassword {
+			continue
+		}
+
+		salt, err := hex.DecodeString(slot.Salt)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrUnknownFormat, err)
+		}
+
+		key, err := scrypt.Key(password, salt, slot.N, slot.R, slot.P, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrDecrypt, err)
+		}
+
+		ct, err := hex.DecodeString(slot.Key)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrUnknownFormat, err)
+		}
+
+		if masterKey, err := aegisOpen(key, slot.KeyParams, ct); err == nil {
+			return masterKey, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: wrong password", ErrDecrypt)
+}
+
+func aegisOpen(key []byte, info aegisKeyInfo, ct []byte) ([]byte, error) {
+	nonce, err := hex.DecodeString(info.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnknownFormat, err)
+	}
+
+	tag, err := hex.DecodeString(info.Tag)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnknownFormat, err)
+	}
+
+	return gcmOpen(key, nonce, append(ct, tag...))
+}
+
+func gcmOpen(key, nonce, ct []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecrypt, err)
+	}
+
+	aead, err := cipher.NewGCMWithNonceSize(block, len(nonce))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecrypt, err)
+	}
+
+	pt, err := aead.Open(nil, nonce, ct, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecrypt, err)
+	}
+
+	return pt, nil
+}
+
+// andOTP
+// See: https://github.com/andOTP/andOTP/wiki/Backups
+
+type andOTPEntry struct {
+	Secret    string `json:"secret"`
+	Issuer    string `json:"issuer"`
+	Label     string `json:"label"`
+	Digits    int    `json:"digits"`
+	Type      string `json:"type"`
+	Algorithm string `json:"algorithm"`
+	Period    int    `json:"period"`
+	Counter   uint64 `json:"counter"`
+}
+
+const (
+	andOTPSaltSize  = 12
+	andOTPNonceSize = 12
+)
+
+// ImportAndOTP parses a plain or password-encrypted andOTP backup.
+func ImportAndOTP(data, password []byte) ([]*Credential, error) {
+	if trimmed := bytes.TrimSpace(data); !bytes.HasPrefix(trimmed, []byte("[")) {
+		if password == nil {
+			return nil, ErrPasswordRequired
+		}
+
+		var err error
+		if data, err = andOTPDecrypt(data, password); err != nil {
+			return nil, err
+		}
+	}
+
+	var entries []andOTPEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnknownFormat, err)
+	}
+
+	creds := []*Credential{}
+	for _, e := range entries {
+		typ := Type(strings.ToLower(e.Type))
+		if typ != TOTP && typ != HOTP {
+			skip(FormatAndOTP, e.Label, e.Type)
+			continue
+		}
+
+		secret, err := DecodeSecret(e.Secret)
+		if err != nil {
+			return nil, err
+		}
+
+		algo, err := ParseAlgorithm(e.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+
+		c := &Credential{
+			Type:      typ,
+			Algorithm: algo,
+			Issuer:    e.Issuer,
+			Account:   e.Label,
+			Secret:    secret,
+			Digits:    e.Digits,
+			Period:    time.Duration(e.Period) * time.Second,
+			Counter:   e.Counter,
+		}
+
+		// Older versions of andOTP stored the issuer as part of the label
+		if c.Issuer == "" {
+			if issuer, account, ok := strings.Cut(c.Account, ":"); ok {
+				c.Issuer, c.Account = strings.TrimSpace(issuer), strings.TrimSpace(account)
+			}
+		}
+
+		if err := c.Validate(); err != nil {
+			return nil, err
+		}
+
+		creds = append(creds, c)
+	}
+
+	return creds, nil
+}
+
+// andOTPDecrypt decrypts password-protected backups which are laid out as
+// iterations (uint32) || salt || nonce || ciphertext || tag.
+func andOTPDecrypt(data, password []byte) ([]byte, error) {
+	if len(data) < 4+andOTPSaltSize+andOTPNonceSize+16 {
+		return nil, fmt.Errorf("%w: backup too short", ErrUnknownFormat)
+	}
+
+	iterations := int(binary.BigEndian.Uint32(data))
+	if iterations < 1 || iterations > 10_000_000 {
+		return nil, fmt.Errorf("%w: invalid iteration count %d", ErrUnknownFormat, iterations)
+	}
+
+	data = data[4:]
+	salt, data := data[:andOTPSaltSize], data[andOTPSaltSize:]
+	nonce, ct := data[:andOTPNonceSize], data[andOTPNonceSize:]
+
+	key := pbkdf2.Key(password, salt, iterations, 32, sha1.New)
+
+	return gcmOpen(key, nonce, ct)
+}
+
+// FreeOTP+
+// See: https://github.com/helloworld1/FreeOTPPlus
+
+type freeOTPBackup struct {
+	Tokens []struct {
+		Algo      string `json:"algo"`
+		Counter   uint64 `json:"counter"`
+		Digits    int    `json:"digits"`
+		IssuerExt string `json:"issuerExt"`
+		IssuerInt string `json:"issuerInt"`
+		Label     string `json:"label"`
+		Period    int    `json:"period"`
+		Secret    []int8 `json:"secret"`
+		Type      string `json:"type"`
+	} `json:"tokens"`
+}
+
+// ImportFreeOTP parses a JSON backup of FreeOTP+.
+func ImportFreeOTP(data []byte) ([]*Credential, error) {
+	var backup freeOTPBackup
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnknownFormat, err)
+	}
+
+	creds := []*Credential{}
+	for _, t := range backup.Tokens {
+		typ := Type(strings.ToLower(t.Type))
+		if typ != TOTP && typ != HOTP {
+			skip(FormatFreeOTP, t.Label, t.Type)
+			continue
+		}
+
+		algo, err := ParseAlgorithm(t.Algo)
+		if err != nil {
+			return nil, err
+		}
+
+		// Secrets are stored as Java byte arrays
+		secret := make([]byte, len(t.Secret))
+		for i, b := range t.Secret {
+			secret[i] = byte(b)
+		}
+
+		issuer := t.IssuerExt
+		if issuer == "" {
+			issuer = t.IssuerInt
+		}
+
+		c := &Credential{
+			Type:      typ,
+			Algorithm: algo,
+			Issuer:    issuer,
+			Account:   t.Label,
+			Secret:    secret,
+			Digits:    t.Digits,
+			Period:    time.Duration(t.Period) * time.Second,
+			Counter:   t.Counter,
+		}
+
+		if err := c.Validate(); err != nil {
+			return nil, err
+		}
+
+		creds = append(creds, c)
+	}
+
+	return creds, nil
+}
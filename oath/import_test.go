@@ -0,0 +1,247 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package oath_test
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+
+	"cunicu.li/hawkes/oath"
+)
+
+// "Hello!\xde\xad\xbe\xef" in base32
+const (
+	testSecret       = "JBSWY3DPEHPK3PXP"
+	testSecretString = "Hello!\xde\xad\xbe\xef"
+)
+
+func TestParseURI(t *testing.T) {
+	require := require.New(t)
+
+	c, err := oath.ParseURI("otpauth://totp/Example:alice@example.com?secret=" + testSecret + "&issuer=Example&algorithm=SHA256&digits=8&period=60")
+	require.NoError(err)
+	require.Equal(&oath.Credential{
+		Type:      oath.TOTP,
+		Algorithm: oath.SHA256,
+		Issuer:    "Example",
+		Account:   "alice@example.com",
+		Secret:    []byte(testSecretString),
+		Digits:    8,
+		Period:    time.Minute,
+	}, c)
+	require.Equal("60/Example:alice@example.com", c.Name())
+
+	c, err = oath.ParseURI("otpauth://hotp/bob?secret=" + testSecret + "&counter=42")
+	require.NoError(err)
+	require.Equal(oath.HOTP, c.Type)
+	require.Equal(oath.SHA1, c.Algorithm)
+	require.EqualValues(42, c.Counter)
+	require.Equal("bob", c.Name())
+
+	_, err = oath.ParseURI("otpauth://hotp/bob?secret=" + testSecret)
+	require.ErrorIs(err, oath.ErrInvalidURI)
+
+	_, err = oath.ParseURI("otpauth://steam/bob?secret=" + testSecret)
+	require.ErrorIs(err, oath.ErrUnsupportedType)
+}
+
+func sealGCM(t *testing.T, key, nonce, pt []byte) []byte {
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+
+	aead, err := cipher.NewGCM(block)
+	require.NoError(t, err)
+
+	return aead.Seal(nil, nonce, pt, nil)
+}
+
+func random(t *testing.T, n int) []byte {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	require.NoError(t, err)
+
+	return b
+}
+
+const aegisDB = `{
+	"version": 2,
+	"entries": [
+		{"type": "totp", "name": "alice", "issuer": "Example", "info": {"secret": "` + testSecret + `", "algo": "SHA1", "digits": 6, "period": 30}},
+		{"type": "hotp", "name": "bob", "issuer": "", "info": {"secret": "` + testSecret + `", "algo": "SHA512", "digits": 8, "counter": 7}},
+		{"type": "steam", "name": "carol", "issuer": "Steam", "info": {"secret": "` + testSecret + `", "algo": "SHA1", "digits": 5, "period": 30}}
+	]
+}`
+
+func checkAegis(t *testing.T, creds []*oath.Credential) {
+	require := require.New(t)
+
+	require.Len(creds, 2)
+	require.Equal("Example:alice", creds[0].Name())
+	require.Equal([]byte(testSecretString), creds[0].Secret)
+	require.Equal(oath.HOTP, creds[1].Type)
+	require.Equal(oath.SHA512, creds[1].Algorithm)
+	require.EqualValues(7, creds[1].Counter)
+}
+
+func TestImportAegis(t *testing.T) {
+	t.Run("plain", func(t *testing.T) {
+		require := require.New(t)
+
+		vault := `{"version": 1, "header": {"slots": null, "params": null}, "db": ` + aegisDB + `}`
+		require.Equal(oath.FormatAegis, oath.DetectFormat([]byte(vault)))
+
+		creds, err := oath.Import([]byte(vault), nil, oath.FormatUnknown)
+		require.NoError(err)
+		checkAegis(t, creds)
+	})
+
+	t.Run("encrypted", func(t *testing.T) {
+		require := require.New(t)
+
+		password := []byte("test")
+		salt := random(t, 32)
+		masterKey := random(t, 32)
+
+		slotKey, err := scrypt.Key(password, salt, 1<<10, 8, 1, 32)
+		require.NoError(err)
+
+		slotNonce := random(t, 12)
+		slotCT := sealGCM(t, slotKey, slotNonce, masterKey)
+
+		dbNonce := random(t, 12)
+		dbCT := sealGCM(t, masterKey, dbNonce, []byte(aegisDB))
+
+		vault, err := json.Marshal(map[string]any{
+			"version": 1,
+			"header": map[string]any{
+				"slots": []any{
+					map[string]any{
+						"type": 1,
+						"key":  hex.EncodeToString(slotCT[:len(slotCT)-16]),
+						"key_params": map[string]any{
+							"nonce": hex.EncodeToString(slotNonce),
+							"tag":   hex.EncodeToString(slotCT[len(slotCT)-16:]),
+						},
+						"n":    1 << 10,
+						"r":    8,
+						"p":    1,
+						"salt": hex.EncodeToString(salt),
+					},
+				},
+				"params": map[string]any{
+					"nonce": hex.EncodeToString(dbNonce),
+					"tag":   hex.EncodeToString(dbCT[len(dbCT)-16:]),
+				},
+			},
+			"db": base64.StdEncoding.EncodeToString(dbCT[:len(dbCT)-16]),
+		})
+		require.NoError(err)
+
+		_, err = oath.ImportAegis(vault, nil)
+		require.ErrorIs(err, oath.ErrPasswordRequired)
+
+		_, err = oath.ImportAegis(vault, []byte("wrong"))
+		require.ErrorIs(err, oath.ErrDecrypt)
+
+		creds, err := oath.ImportAegis(vault, password)
+		require.NoError(err)
+		checkAegis(t, creds)
+	})
+}
+
+const andOTPBackup = `[
+	{"secret": "` + testSecret + `", "issuer": "", "label": "Example:alice", "digits": 6, "type": "TOTP", "algorithm": "SHA1", "period": 30},
+	{"secret": "` + testSecret + `", "issuer": "Other", "label": "bob", "digits": 8, "type": "HOTP", "algorithm": "SHA256", "counter": 3},
+	{"secret": "` + testSecret + `", "issuer": "Steam", "label": "carol", "digits": 5, "type": "STEAM", "algorithm": "SHA1", "period": 30}
+]`
+
+func TestImportAndOTP(t *testing.T) {
+	check := func(t *testing.T, creds []*oath.Credential) {
+		require := require.New(t)
+
+		require.Len(creds, 2)
+		require.Equal("Example", creds[0].Issuer)
+		require.Equal("alice", creds[0].Account)
+		require.Equal("Other:bob", creds[1].Name())
+		require.Equal(oath.SHA256, creds[1].Algorithm)
+	}
+
+	t.Run("plain", func(t *testing.T) {
+		creds, err := oath.Import([]byte(andOTPBackup), nil, oath.FormatUnknown)
+		require.NoError(t, err)
+		check(t, creds)
+	})
+
+	t.Run("encrypted", func(t *testing.T) {
+		require := require.New(t)
+
+		password := []byte("test")
+		salt := random(t, 12)
+		nonce := random(t, 12)
+		iterations := 1000
+
+		key := pbkdf2.Key(password, salt, iterations, 32, sha1.New)
+
+		backup := binary.BigEndian.AppendUint32(nil, uint32(iterations))
+		backup = append(backup, salt...)
+		backup = append(backup, nonce...)
+		backup = append(backup, sealGCM(t, key, nonce, []byte(andOTPBackup))...)
+
+		require.Equal(oath.FormatAndOTP, oath.DetectFormat(backup))
+
+		_, err := oath.Import(backup, []byte("wrong"), oath.FormatUnknown)
+		require.ErrorIs(err, oath.ErrDecrypt)
+
+		creds, err := oath.Import(backup, password, oath.FormatUnknown)
+		require.NoError(err)
+		check(t, creds)
+	})
+}
+
+func TestImportFreeOTP(t *testing.T) {
+	require := require.New(t)
+
+	secret := []int8{}
+	for _, b := range []byte(testSecretString) {
+		secret = append(secret, int8(b))
+	}
+
+	secretJSON, err := json.Marshal(secret)
+	require.NoError(err)
+
+	backup := fmt.Sprintf(`{
+		"tokenOrder": ["Example:alice"],
+		"tokens": [
+			{"algo": "SHA1", "counter": 0, "digits": 6, "issuerExt": "Example", "label": "alice", "period": 30, "secret": %s, "type": "TOTP"}
+		]
+	}`, secretJSON)
+
+	require.Equal(oath.FormatFreeOTP, oath.DetectFormat([]byte(backup)))
+
+	creds, err := oath.Import([]byte(backup), nil, oath.FormatUnknown)
+	require.NoError(err)
+	require.Len(creds, 1)
+	require.Equal("Example:alice", creds[0].Name())
+	require.Equal([]byte(testSecretString), creds[0].Secret)
+
+	uris := "otpauth://totp/Example:alice?secret=" + testSecret + "\n\notpauth://hotp/bob?secret=" + testSecret + "&counter=1\n"
+	require.Equal(oath.FormatURIs, oath.DetectFormat([]byte(uris)))
+
+	creds, err = oath.Import([]byte(uris), nil, oath.FormatUnknown)
+	require.NoError(err)
+	require.Len(creds, 2)
+}
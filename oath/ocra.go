@@ -0,0 +1,279 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package oath
+
+import (
+	"crypto/hmac"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidSuite    = errors.New("invalid OCRA suite")
+	ErrInvalidQuestion = errors.New("invalid OCRA question")
+)
+
+const (
+	ocraVersion        = "OCRA-1"
+	ocraQuestionLength = 128
+)
+
+// QuestionFormat is the encoding of an OCRA challenge question.
+type QuestionFormat byte
+
+const (
+	QuestionAlphanumeric QuestionFormat = 'A'
+	QuestionNumeric      QuestionFormat = 'N'
+	QuestionHex          QuestionFormat = 'H'
+)
+
+// OCRASuite describes the computation of an OCRA response (RFC 6287 Section 6).
+type OCRASuite struct {
+	Algorithm Algorithm
+
+	// Digits is the length of the truncated response.
+	// Zero disables truncation and yields the full HMAC value in hex.
+	Digits int
+
+	// Counter includes a counter value in the data input.
+	Counter bool
+
+	QuestionFormat QuestionFormat
+	QuestionLength int
+
+	// PIN is the hash algorithm of an included PIN or empty if none.
+	PIN Algorithm
+
+	// SessionLength is the length of included session information in bytes.
+	SessionLength int
+
+	// TimeStep is the granularity of an included timestamp or zero if none.
+	TimeStep time.Duration
+
+	suite string
+}
+
+// OCRAInput holds the parameters of a single OCRA computation.
+type OCRAInput struct {
+	Counter  uint64
+	Question string
+	PIN      string
+	Session  []byte
+	Time     time.Time
+}
+
+// ParseOCRASuite parses an OCRA suite string like "OCRA-1:HOTP-SHA1-6:QN08".
+func ParseOCRASuite(s string) (*OCRASuite, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 || parts[0] != ocraVersion {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSuite, s)
+	}
+
+	suite := &OCRASuite{
+		suite: s,
+	}
+
+	fn := strings.Split(parts[1], "-")
+	if len(fn) != 3 || fn[0] != "HOTP" {
+		return nil, fmt.Errorf("%w: invalid crypto function %s", ErrInvalidSuite, parts[1])
+	}
+
+	var err error
+	if suite.Algorithm, err = ParseAlgorithm(fn[1]); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSuite, err)
+	}
+
+	if suite.Digits, err = strconv.Atoi(fn[2]); err != nil || (suite.Digits != 0 && (suite.Digits < 4 || suite.Digits > 10)) {
+		return nil, fmt.Errorf("%w: invalid truncation length %s", ErrInvalidSuite, fn[2])
+	}
+
+	inputs := strings.Split(parts[2], "-")
+	if inputs[0] == "C" {
+		suite.Counter = true
+		inputs = inputs[1:]
+	}
+
+	if len(inputs) == 0 || len(inputs[0]) != 4 || inputs[0][0] != 'Q' {
+		return nil, fmt.Errorf("%w: missing question", ErrInvalidSuite)
+	}
+
+	suite.QuestionFormat = QuestionFormat(inputs[0][1])
+	switch suite.QuestionFormat {
+	case QuestionAlphanumeric, QuestionNumeric, QuestionHex:
+	default:
+		return nil, fmt.Errorf("%w: invalid question format %c", ErrInvalidSuite, suite.QuestionFormat)
+	}
+
+	if suite.QuestionLength, err = strconv.Atoi(inputs[0][2:]); err != nil || suite.QuestionLength < 4 || suite.QuestionLength > 64 {
+		return nil, fmt.Errorf("%w: invalid question length %s", ErrInvalidSuite, inputs[0][2:])
+	}
+
+	for _, input := range inputs[1:] {
+		switch {
+		case strings.HasPrefix(input, "P") && suite.PIN == "" && suite.SessionLength == 0 && suite.TimeStep == 0:
+			if suite.PIN, err = ParseAlgorithm(input[1:]); err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidSuite, err)
+			}
+
+		case strings.HasPrefix(input, "S") && suite.SessionLength == 0 && suite.TimeStep == 0:
+			if suite.SessionLength, err = strconv.Atoi(input[1:]); err != nil || len(input) != 4 || suite.SessionLength == 0 {
+				return nil, fmt.Errorf("%w: invalid session length %s", ErrInvalidSuite, input)
+			}
+
+		case strings.HasPrefix(input, "T") && suite.TimeStep == 0 && len(input) >= 3:
+			if suite.TimeStep, err = parseTimeStep(input[1:]); err != nil {
+				return nil, err
+			}
+
+		default:
+			return nil, fmt.Errorf("%w: unexpected data input %s", ErrInvalidSuite, input)
+		}
+	}
+
+	return suite, nil
+}
+
+func parseTimeStep(s string) (time.Duration, error) {
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%w: invalid time step %s", ErrInvalidSuite, s)
+	}
+
+	switch unit := s[len(s)-1]; {
+	case unit == 'S' && n <= 59:
+		return time.Duration(n) * time.Second, nil
+	case unit == 'M' && n <= 59:
+		return time.Duration(n) * time.Minute, nil
+	case unit == 'H' && n <= 48:
+		return time.Duration(n) * time.Hour, nil
+	default:
+		return 0, fmt.Errorf("%w: invalid time step %s", ErrInvalidSuite, s)
+	}
+}
+
+// String returns the OCRA suite string.
+func (s *OCRASuite) String() string {
+	return s.suite
+}
+
+// DataInput returns the message which is authenticated by the HMAC (RFC 6287 Section 5.1).
+func (s *OCRASuite) DataInput(in *OCRAInput) ([]byte, error) {
+	data := append([]byte(s.suite), 0)
+
+	if s.Counter {
+		data = binary.BigEndian.AppendUint64(data, in.Counter)
+	}
+
+	q, err := s.question(in.Question)
+	if err != nil {
+		return nil, err
+	}
+
+	data = append(data, q...)
+
+	if s.PIN != "" {
+		h, err := s.PIN.Hash()
+		if err != nil {
+			return nil, err
+		}
+
+		md := h()
+		md.Write([]byte(in.PIN))
+		data = md.Sum(data)
+	}
+
+	if s.SessionLength > 0 {
+		if len(in.Session) > s.SessionLength {
+			return nil, fmt.Errorf("%w: session information exceeds %d bytes", ErrInvalidSuite, s.SessionLength)
+		}
+
+		session := make([]byte, s.SessionLength)
+		copy(session[s.SessionLength-len(in.Session):], in.Session)
+		data = append(data, session...)
+	}
+
+	if s.TimeStep > 0 {
+		steps := uint64(in.Time.Unix()) / uint64(s.TimeStep.Seconds()) //nolint:gosec
+		data = binary.BigEndian.AppendUint64(data, steps)
+	}
+
+	return data, nil
+}
+
+// question encodes the challenge question as hex digits, left-aligned and
+// zero padded to 128 bytes.
+// The length of the suite is not enforced as the mutual challenge-response
+// mode of RFC 6287 concatenates the client and server questions.
+func (s *OCRASuite) question(q string) ([]byte, error) {
+	if len(q) < 4 || len(q) > 2*64 {
+		return nil, fmt.Errorf("%w: invalid length %d", ErrInvalidQuestion, len(q))
+	}
+
+	var digits string
+
+	switch s.QuestionFormat {
+	case QuestionNumeric:
+		n, ok := new(big.Int).SetString(q, 10)
+		if !ok || n.Sign() < 0 {
+			return nil, fmt.Errorf("%w: not numeric: %s", ErrInvalidQuestion, q)
+		}
+
+		digits = n.Text(16)
+
+	case QuestionHex:
+		digits = q
+
+	case QuestionAlphanumeric:
+		digits = hex.EncodeToString([]byte(q))
+	}
+
+	if len(digits)%2 != 0 {
+		digits += "0"
+	}
+
+	b, err := hex.DecodeString(digits)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidQuestion, err)
+	} else if len(b) > ocraQuestionLength {
+		return nil, fmt.Errorf("%w: too long", ErrInvalidQuestion)
+	}
+
+	buf := make([]byte, ocraQuestionLength)
+	copy(buf, b)
+
+	return buf, nil
+}
+
+// Response truncates the HMAC of the data input to the OCRA response.
+func (s *OCRASuite) Response(mac []byte) string {
+	if s.Digits == 0 {
+		return hex.EncodeToString(mac)
+	}
+
+	return Truncate(mac, s.Digits)
+}
+
+// OCRA calculates the OCRA response for the challenge using the secret of the credential.
+func (c *Credential) OCRA(suite *OCRASuite, in *OCRAInput) (string, error) {
+	data, err := suite.DataInput(in)
+	if err != nil {
+		return "", err
+	}
+
+	h, err := suite.Algorithm.Hash()
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(h, c.Secret)
+	mac.Write(data)
+
+	return suite.Response(mac.Sum(nil)), nil
+}
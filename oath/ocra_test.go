@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package oath_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/oath"
+)
+
+// See: RFC 6287 Appendix C
+func TestOCRA(t *testing.T) {
+	const (
+		key20 = "12345678901234567890"
+		key32 = "12345678901234567890123456789012"
+		key64 = "1234567890123456789012345678901234567890123456789012345678901234"
+	)
+
+	ts := time.Unix(0x132d0b6*60, 0)
+
+	for _, v := range []struct {
+		suite    string
+		key      string
+		input    oath.OCRAInput
+		expected string
+	}{
+		{"OCRA-1:HOTP-SHA1-6:QN08", key20, oath.OCRAInput{Question: "00000000"}, "237653"},
+		{"OCRA-1:HOTP-SHA1-6:QN08", key20, oath.OCRAInput{Question: "11111111"}, "243178"},
+		{"OCRA-1:HOTP-SHA1-6:QN08", key20, oath.OCRAInput{Question: "99999999"}, "294470"},
+		{"OCRA-1:HOTP-SHA256-8:C-QN08-PSHA1", key32, oath.OCRAInput{Counter: 0, Question: "12345678", PIN: "1234"}, "65347737"},
+		{"OCRA-1:HOTP-SHA256-8:C-QN08-PSHA1", key32, oath.OCRAInput{Counter: 9, Question: "12345678", PIN: "1234"}, "08522129"},
+		{"OCRA-1:HOTP-SHA256-8:QN08-PSHA1", key32, oath.OCRAInput{Question: "00000000", PIN: "1234"}, "83238735"},
+		{"OCRA-1:HOTP-SHA256-8:QN08-PSHA1", key32, oath.OCRAInput{Question: "44444444", PIN: "1234"}, "86807031"},
+		{"OCRA-1:HOTP-SHA512-8:C-QN08", key64, oath.OCRAInput{Counter: 0, Question: "00000000"}, "07016083"},
+		{"OCRA-1:HOTP-SHA512-8:C-QN08", key64, oath.OCRAInput{Counter: 9, Question: "99999999"}, "31409299"},
+		{"OCRA-1:HOTP-SHA512-8:QN08-T1M", key64, oath.OCRAInput{Question: "00000000", Time: ts}, "95209754"},
+		{"OCRA-1:HOTP-SHA512-8:QN08-T1M", key64, oath.OCRAInput{Question: "44444444", Time: ts}, "36209546"},
+		{"OCRA-1:HOTP-SHA256-8:QA08", key32, oath.OCRAInput{Question: "CLI22220SRV11110"}, "28247970"},
+		{"OCRA-1:HOTP-SHA512-8:QA08-PSHA1", key64, oath.OCRAInput{Question: "SRV11110CLI22220", PIN: "1234"}, "18806276"},
+		{"OCRA-1:HOTP-SHA256-8:QA08", key32, oath.OCRAInput{Question: "SIG10000"}, "53095496"},
+		{"OCRA-1:HOTP-SHA512-8:QA10-T1M", key64, oath.OCRAInput{Question: "SIG1000000", Time: ts}, "77537423"},
+	} {
+		suite, err := oath.ParseOCRASuite(v.suite)
+		require.NoError(t, err)
+
+		cred := &oath.Credential{
+			Secret: []byte(v.key),
+		}
+
+		resp, err := cred.OCRA(suite, &v.input)
+		require.NoError(t, err)
+		require.Equal(t, v.expected, resp, "%s %+v", v.suite, v.input)
+	}
+}
+
+func TestParseOCRASuite(t *testing.T) {
+	require := require.New(t)
+
+	suite, err := oath.ParseOCRASuite("OCRA-1:HOTP-SHA512-0:C-QH40-PSHA256-S128-T30S")
+	require.NoError(err)
+	require.Equal(oath.SHA512, suite.Algorithm)
+	require.Equal(0, suite.Digits)
+	require.True(suite.Counter)
+	require.Equal(oath.QuestionHex, suite.QuestionFormat)
+	require.Equal(40, suite.QuestionLength)
+	require.Equal(oath.SHA256, suite.PIN)
+	require.Equal(128, suite.SessionLength)
+	require.Equal(30*time.Second, suite.TimeStep)
+
+	for _, s := range []string{
+		"OCRA-2:HOTP-SHA1-6:QN08",
+		"OCRA-1:HOTP-MD5-6:QN08",
+		"OCRA-1:HOTP-SHA1-3:QN08",
+		"OCRA-1:HOTP-SHA1-6:C",
+		"OCRA-1:HOTP-SHA1-6:QX08",
+		"OCRA-1:HOTP-SHA1-6:QN99",
+		"OCRA-1:HOTP-SHA1-6:QN08-T60M",
+		"OCRA-1:HOTP-SHA1-6:QN08-T1M-PSHA1",
+	} {
+		_, err := oath.ParseOCRASuite(s)
+		require.ErrorIs(err, oath.ErrInvalidSuite, s)
+	}
+}
@@ -0,0 +1,130 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package oath
+
+import (
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"time"
+)
+
+// Hash returns the hash function of the algorithm.
+func (a Algorithm) Hash() (func() hash.Hash, error) {
+	switch a {
+	case SHA1, "":
+		return sha1.New, nil
+	case SHA256:
+		return sha256.New, nil
+	case SHA512:
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgo, a)
+	}
+}
+
+// TimeStep returns the TOTP moving factor for the given time (RFC 6238 Section 4).
+func (c *Credential) TimeStep(t time.Time) uint64 {
+	period := c.Period
+	if period <= 0 {
+		period = DefaultPeriod
+	}
+
+	return uint64(t.Unix()) / uint64(period.Seconds()) //nolint:gosec
+}
+
+// HOTP calculates the one-time password for the counter value (RFC 4226 Section 5).
+func (c *Credential) HOTP(counter uint64) (string, error) {
+	h, err := c.Algorithm.Hash()
+	if err != nil {
+		return "", err
+	}
+
+	digits := c.Digits
+	if digits == 0 {
+		digits = DefaultDigits
+	}
+
+	mac := hmac.New(h, c.Secret)
+	mac.Write(binary.BigEndian.AppendUint64(nil, counter))
+
+	return Truncate(mac.Sum(nil), digits), nil
+}
+
+// TOTP calculates the one-time password for the given time.
+func (c *Credential) TOTP(t time.Time) (string, error) {
+	return c.HOTP(c.TimeStep(t))
+}
+
+// TOTPWindow calculates the one-time passwords for the periods from skew
+// steps before up to skew steps after the given time in chronological order.
+func (c *Credential) TOTPWindow(t time.Time, skew int) ([]string, error) {
+	step := c.TimeStep(t)
+	skew = max(skew, 0)
+
+	codes := make([]string, 0, 2*skew+1)
+
+	for i := -skew; i <= skew; i++ {
+		code, err := c.HOTP(step + uint64(i)) //nolint:gosec
+		if err != nil {
+			return nil, err
+		}
+
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// FindCounter searches the HOTP counter values from start up to start+window
+// for a sequence of consecutive codes and returns the counter following the last one.
+// It is used to resynchronize the counter of a HOTP token which has drifted
+// ahead, e.g. by button presses without submitting the codes (RFC 4226 Section 7.4).
+func (c *Credential) FindCounter(start uint64, window int, codes ...string) (uint64, error) {
+	if len(codes) == 0 {
+		return 0, fmt.Errorf("%w: no codes", ErrCounterNotFound)
+	}
+
+	expected := make([]string, 0, window+len(codes))
+
+	for i := range uint64(window) + uint64(len(codes)) { //nolint:gosec
+		code, err := c.HOTP(start + i)
+		if err != nil {
+			return 0, err
+		}
+
+		expected = append(expected, code)
+	}
+
+outer:
+	for i := range window + 1 {
+		for j, code := range codes {
+			if subtle.ConstantTimeCompare([]byte(code), []byte(expected[i+j])) != 1 {
+				continue outer
+			}
+		}
+
+		return start + uint64(i+len(codes)), nil //nolint:gosec
+	}
+
+	return 0, ErrCounterNotFound
+}
+
+// Truncate applies the dynamic truncation of RFC 4226 Section 5.3 to an HMAC value.
+func Truncate(mac []byte, digits int) string {
+	offset := mac[len(mac)-1] & 0xf
+	code := binary.BigEndian.Uint32(mac[offset:]) & 0x7fffffff
+
+	mod := uint64(1)
+	for range digits {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", digits, uint64(code)%mod)
+}
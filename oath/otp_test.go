@@ -0,0 +1,144 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package oath_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/oath"
+)
+
+// See: RFC 4226 Appendix D
+func TestHOTP(t *testing.T) {
+	require := require.New(t)
+
+	cred := &oath.Credential{
+		Type:   oath.HOTP,
+		Secret: []byte("12345678901234567890"),
+	}
+
+	for counter, expected := range []string{
+		"755224", "287082", "359152", "969429", "338314",
+		"254676", "287922", "162583", "399871", "520489",
+	} {
+		code, err := cred.HOTP(uint64(counter))
+		require.NoError(err)
+		require.Equal(expected, code)
+	}
+}
+
+// See: RFC 6238 Appendix B
+func TestTOTP(t *testing.T) {
+	secrets := map[oath.Algorithm]string{
+		oath.SHA1:   "12345678901234567890",
+		oath.SHA256: "12345678901234567890123456789012",
+		oath.SHA512: "1234567890123456789012345678901234567890123456789012345678901234",
+	}
+
+	for _, v := range []struct {
+		time     int64
+		expected map[oath.Algorithm]string
+	}{
+		{59, map[oath.Algorithm]string{oath.SHA1: "94287082", oath.SHA256: "46119246", oath.SHA512: "90693936"}},
+		{1111111109, map[oath.Algorithm]string{oath.SHA1: "07081804", oath.SHA256: "68084774", oath.SHA512: "25091201"}},
+		{1234567890, map[oath.Algorithm]string{oath.SHA1: "89005924", oath.SHA256: "91819424", oath.SHA512: "93441116"}},
+		{20000000000, map[oath.Algorithm]string{oath.SHA1: "65353130", oath.SHA256: "77737706", oath.SHA512: "47863826"}},
+	} {
+		for algo, expected := range v.expected {
+			cred := &oath.Credential{
+				Algorithm: algo,
+				Secret:    []byte(secrets[algo]),
+				Digits:    8,
+				Period:    30 * time.Second,
+			}
+
+			code, err := cred.TOTP(time.Unix(v.time, 0))
+			require.NoError(t, err)
+			require.Equal(t, expected, code, "time %d, %s", v.time, algo)
+		}
+	}
+}
+
+func TestTOTPWindow(t *testing.T) {
+	require := require.New(t)
+
+	cred := &oath.Credential{
+		Algorithm: oath.SHA1,
+		Secret:    []byte("12345678901234567890"),
+		Digits:    8,
+		Period:    30 * time.Second,
+	}
+
+	now := time.Unix(1111111109, 0)
+
+	codes, err := cred.TOTPWindow(now, 1)
+	require.NoError(err)
+	require.Len(codes, 3)
+
+	for i, d := range []time.Duration{-30 * time.Second, 0, 30 * time.Second} {
+		code, err := cred.TOTP(now.Add(d))
+		require.NoError(err)
+		require.Equal(code, codes[i])
+	}
+
+	codes, err = cred.TOTPWindow(now, 0)
+	require.NoError(err)
+	require.Equal([]string{"07081804"}, codes)
+}
+
+func TestParseName(t *testing.T) {
+	require := require.New(t)
+
+	for name, expected := range map[string]struct {
+		period          time.Duration
+		issuer, account string
+	}{
+		"alice":               {oath.DefaultPeriod, "", "alice"},
+		"Example:alice":       {oath.DefaultPeriod, "Example", "alice"},
+		"60/Example:alice":    {time.Minute, "Example", "alice"},
+		"60/alice":            {time.Minute, "", "alice"},
+		"a/b:alice@host:1234": {oath.DefaultPeriod, "a/b", "alice@host:1234"},
+		":host:1234":          {oath.DefaultPeriod, "", "host:1234"},
+		"30/2024/alice":       {oath.DefaultPeriod, "", "2024/alice"},
+		"Bücherei:jürgen":     {oath.DefaultPeriod, "Bücherei", "jürgen"},
+	} {
+		period, issuer, account := oath.ParseName(name)
+		require.Equal(expected.period, period, name)
+		require.Equal(expected.issuer, issuer, name)
+		require.Equal(expected.account, account, name)
+	}
+
+	cred := &oath.Credential{Type: oath.TOTP, Issuer: "Example", Account: "alice", Period: time.Minute}
+	period, issuer, account := oath.ParseName(cred.Name())
+	require.Equal(cred.Period, period)
+	require.Equal(cred.Issuer, issuer)
+	require.Equal(cred.Account, account)
+}
+
+func TestFindCounter(t *testing.T) {
+	require := require.New(t)
+
+	cred := &oath.Credential{
+		Type:   oath.HOTP,
+		Secret: []byte("12345678901234567890"),
+	}
+
+	// RFC 4226 Appendix D: counters 4 and 5
+	next, err := cred.FindCounter(0, 10, "338314", "254676")
+	require.NoError(err)
+	require.EqualValues(6, next)
+
+	next, err = cred.FindCounter(4, 0, "338314")
+	require.NoError(err)
+	require.EqualValues(5, next)
+
+	_, err = cred.FindCounter(5, 10, "338314")
+	require.ErrorIs(err, oath.ErrCounterNotFound)
+
+	_, err = cred.FindCounter(0, 3, "338314", "254676")
+	require.ErrorIs(err, oath.ErrCounterNotFound)
+}
@@ -0,0 +1,286 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package peer keeps track of the hardware keys which are trusted for peers.
+//
+// The store maps peer names to the fingerprints of their static keys.
+// Keys of unknown peers are trusted on first use (TOFU) and then required
+// for all further handshakes. Keys can also be pinned explicitly, expire
+// and be revoked so that they are never trusted again.
+package peer
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/katzenpost/nyquist/dh"
+
+	"cunicu.li/hawkes/fingerprint"
+	"cunicu.li/hawkes/internal/atomicfile"
+	"cunicu.li/hawkes/timesync"
+)
+
+var (
+	ErrUnknownPeer = errors.New("unknown peer")
+	ErrKeyMismatch = errors.New("key does not match the trusted keys of the peer")
+	ErrRevoked     = errors.New("key has been revoked")
+	ErrExpired     = errors.New("trust in key has expired")
+)
+
+// Entry is a key of a peer.
+type Entry struct {
+	Fingerprint fingerprint.Fingerprint `json:"fingerprint"`
+
+	// Pinned is true if the key has been pinned explicitly rather than trusted on first use.
+	Pinned bool `json:"pinned,omitempty"`
+
+	Added time.Time `json:"added"`
+
+	// Expires is the time after which the key is not trusted anymore.
+	// A zero value never expires.
+	Expires time.Time `json:"expires,omitempty"`
+
+	// Revoked is the time at which the key has been revoked.
+	// Revoked keys are kept so that they are never trusted again.
+	Revoked time.Time `json:"revoked,omitempty"`
+	Reason  string    `json:"reason,omitempty"`
+}
+
+// Valid returns true if the key is trusted at the given time.
+func (e *Entry) Valid(at time.Time) bool {
+	return e.Revoked.IsZero() && (e.Expires.IsZero() || at.Before(e.Expires))
+}
+
+// Store persists the keys of peers in a JSON file.
+type Store struct {
+	// TOFU trusts the first key of unknown peers.
+	TOFU bool
+
+	// Expiry is the lifetime of keys which are trusted on first use.
+	// A zero value never expires.
+	Expiry time.Duration
+
+	// Clock is the time source. Defaults to timesync.System.
+	Clock timesync.Clock
+
+	path string
+	mu   sync.Mutex
+}
+
+// DefaultPath returns the default location of the peer file.
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find user config directory: %w", err)
+	}
+
+	return filepath.Join(dir, "hawkes", "peers.json"), nil
+}
+
+// NewStore creates a store which keeps the peers in the file at path
+// and trusts keys of unknown peers on first use.
+func NewStore(path string) *Store {
+	return &Store{
+		TOFU: true,
+		path: path,
+	}
+}
+
+// Check decides whether pub is a trusted key of the named peer and returns its entry.
+//
+// The first key of an unknown peer is added if TOFU is enabled.
+// Afterwards, only the trusted keys of the peer are accepted.
+// Peers whose keys have all expired or been revoked are not trusted on first use
+// again but require a key to be pinned.
+func (s *Store) Check(name string, pub crypto.PublicKey) (*Entry, error) {
+	fp, err := fingerprint.Of(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	now := timesync.Now(s.Clock)
+	entries := st[name]
+
+	if idx := slices.IndexFunc(entries, func(e *Entry) bool { return e.Fingerprint == fp }); idx >= 0 {
+		switch e := entries[idx]; {
+		case !e.Revoked.IsZero():
+			return nil, fmt.Errorf("%w: %s of %s: %s", ErrRevoked, fp, name, e.Reason)
+		case !e.Valid(now):
+			return nil, fmt.Errorf("%w: %s of %s since %s", ErrExpired, fp, name, e.Expires)
+		default:
+			return e, nil
+		}
+	}
+
+	if len(entries) > 0 {
+		return nil, fmt.Errorf("%w: %s of %s", ErrKeyMismatch, fp, name)
+	} else if !s.TOFU {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownPeer, name)
+	}
+
+	e := &Entry{
+		Fingerprint: fp,
+		Added:       now,
+	}
+
+	if s.Expiry > 0 {
+		e.Expires = now.Add(s.Expiry)
+	}
+
+	st[name] = append(entries, e)
+
+	if err := s.save(st); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// VerifyPeer checks the static key received from a peer during a handshake
+// (see handshake.PeerVerifier).
+func (s *Store) VerifyPeer(name string, pub dh.PublicKey) error {
+	_, err := s.Check(name, pub)
+	return err
+}
+
+// Pin trusts the key of a peer until it expires.
+// Keys which have been trusted on first use are replaced.
+// Multiple keys can be pinned for a peer, e.g. of backup tokens.
+// A revoked key can not be pinned again.
+func (s *Store) Pin(name string, fp fingerprint.Fingerprint, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	entries := slices.DeleteFunc(st[name], func(e *Entry) bool {
+		return !e.Pinned && e.Revoked.IsZero()
+	})
+
+	idx := slices.IndexFunc(entries, func(e *Entry) bool { return e.Fingerprint == fp })
+	if idx < 0 {
+		entries = append(entries, &Entry{
+			Fingerprint: fp,
+			Added:       timesync.Now(s.Clock),
+		})
+		idx = len(entries) - 1
+	} else if !entries[idx].Revoked.IsZero() {
+		return fmt.Errorf("%w: %s of %s", ErrRevoked, fp, name)
+	}
+
+	entries[idx].Pinned = true
+	entries[idx].Expires = expires
+
+	st[name] = entries
+
+	return s.save(st)
+}
+
+// Revoke marks the key of a peer as revoked so that it is never trusted again.
+// The key is added as revoked if it is not known yet.
+func (s *Store) Revoke(name string, fp fingerprint.Fingerprint, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	now := timesync.Now(s.Clock)
+
+	idx := slices.IndexFunc(st[name], func(e *Entry) bool { return e.Fingerprint == fp })
+	if idx < 0 {
+		st[name] = append(st[name], &Entry{
+			Fingerprint: fp,
+			Added:       now,
+		})
+		idx = len(st[name]) - 1
+	}
+
+	st[name][idx].Revoked = now
+	st[name][idx].Reason = reason
+
+	return s.save(st)
+}
+
+// Forget removes the trusted keys of a peer so that its next key is trusted on first use again.
+// Revoked keys are kept. Hence, the next key of a peer with revoked keys must be pinned.
+func (s *Store) Forget(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	entries := slices.DeleteFunc(st[name], func(e *Entry) bool {
+		return e.Revoked.IsZero()
+	})
+
+	if len(entries) == 0 {
+		delete(st, name)
+	} else {
+		st[name] = entries
+	}
+
+	return s.save(st)
+}
+
+// Peers returns the entries of all peers indexed by their names.
+func (s *Store) Peers() (map[string][]*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.load()
+}
+
+// Path returns the path of the peer file.
+func (s *Store) Path() string {
+	return s.path
+}
+
+func (s *Store) load() (map[string][]*Entry, error) {
+	st := map[string][]*Entry{}
+
+	buf, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return st, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read peers: %w", err)
+	}
+
+	if err := json.Unmarshal(buf, &st); err != nil {
+		return nil, fmt.Errorf("failed to parse peers: %w", err)
+	}
+
+	return st, nil
+}
+
+func (s *Store) save(st map[string][]*Entry) error {
+	if err := atomicfile.WriteJSON(s.path, st); err != nil {
+		return fmt.Errorf("failed to write peers: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package peer_test
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/fingerprint"
+	"cunicu.li/hawkes/peer"
+	"cunicu.li/hawkes/timesync"
+)
+
+func newKey(t *testing.T) (*ecdh.PublicKey, fingerprint.Fingerprint) {
+	sk, err := ecdh.P256().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	fp, err := fingerprint.Of(sk.PublicKey())
+	require.NoError(t, err)
+
+	return sk.PublicKey(), fp
+}
+
+func TestStore(t *testing.T) {
+	require := require.New(t)
+
+	now := time.Unix(1700000000, 0)
+	path := filepath.Join(t.TempDir(), "peers.json")
+
+	s := peer.NewStore(path)
+	s.Clock = timesync.ClockFunc(func() time.Time { return now })
+	s.Expiry = time.Hour
+
+	pub1, fp1 := newKey(t)
+	pub2, fp2 := newKey(t)
+	pub3, fp3 := newKey(t)
+
+	// The first key is trusted on first use
+	e, err := s.Check("alice", pub1)
+	require.NoError(err)
+	require.Equal(fp1, e.Fingerprint)
+	require.False(e.Pinned)
+	require.Equal(now.Add(time.Hour), e.Expires)
+
+	_, err = s.Check("alice", pub1)
+	require.NoError(err)
+
+	// Other keys are rejected afterwards, also after a restart
+	s = peer.NewStore(path)
+	s.Clock = timesync.ClockFunc(func() time.Time { return now })
+
+	_, err = s.Check("alice", pub2)
+	require.ErrorIs(err, peer.ErrKeyMismatch)
+
+	// Keys expire
+	now = now.Add(2 * time.Hour)
+
+	_, err = s.Check("alice", pub1)
+	require.ErrorIs(err, peer.ErrExpired)
+
+	_, err = s.Check("alice", pub2)
+	require.ErrorIs(err, peer.ErrKeyMismatch)
+
+	// Pinned keys replace keys trusted on first use
+	require.NoError(s.Pin("alice", fp2, time.Time{}))
+	require.NoError(s.Pin("alice", fp3, time.Time{}))
+
+	e, err = s.Check("alice", pub2)
+	require.NoError(err)
+	require.True(e.Pinned)
+
+	_, err = s.Check("alice", pub3)
+	require.NoError(err)
+
+	_, err = s.Check("alice", pub1)
+	require.ErrorIs(err, peer.ErrKeyMismatch)
+
+	// Revoked keys are never trusted again
+	require.NoError(s.Revoke("alice", fp3, "token lost"))
+
+	_, err = s.Check("alice", pub3)
+	require.ErrorIs(err, peer.ErrRevoked)
+	require.ErrorIs(s.Pin("alice", fp3, time.Time{}), peer.ErrRevoked)
+
+	require.NoError(s.Forget("alice"))
+
+	_, err = s.Check("alice", pub3)
+	require.ErrorIs(err, peer.ErrRevoked)
+
+	peers, err := s.Peers()
+	require.NoError(err)
+	require.Len(peers["alice"], 1)
+
+	// Unknown peers are rejected without TOFU
+	s.TOFU = false
+
+	_, err = s.Check("bob", pub1)
+	require.ErrorIs(err, peer.ErrUnknownPeer)
+}
+
+func TestStoreForgetRevoked(t *testing.T) {
+	require := require.New(t)
+
+	s := peer.NewStore(filepath.Join(t.TempDir(), "peers.json"))
+
+	pub1, fp1 := newKey(t)
+	pub2, fp2 := newKey(t)
+	pub3, _ := newKey(t)
+
+	_, err := s.Check("alice", pub1)
+	require.NoError(err)
+
+	require.NoError(s.Revoke("alice", fp1, "token lost"))
+	require.NoError(s.Forget("alice"))
+
+	// Revocations are kept and prevent trust on first use
+	_, err = s.Check("alice", pub2)
+	require.ErrorIs(err, peer.ErrKeyMismatch)
+
+	_, err = s.Check("alice", pub1)
+	require.ErrorIs(err, peer.ErrRevoked)
+
+	// The next key must be pinned
+	require.NoError(s.Pin("alice", fp2, time.Time{}))
+
+	e, err := s.Check("alice", pub2)
+	require.NoError(err)
+	require.True(e.Pinned)
+
+	// Forgetting a peer without revocations re-enables trust on first use
+	_, err = s.Check("bob", pub2)
+	require.NoError(err)
+
+	require.NoError(s.Forget("bob"))
+
+	_, err = s.Check("bob", pub3)
+	require.NoError(err)
+}
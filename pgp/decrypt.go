@@ -0,0 +1,74 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package pgp
+
+import (
+	"crypto"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+const blockMessage = "PGP MESSAGE"
+
+// Message is the literal data of a decrypted message.
+type Message struct {
+	Data     []byte
+	Filename string
+	ModTime  time.Time
+}
+
+// Decrypt decrypts an armored or binary message for the recipient key.
+//
+// The private key must either be a crypto.Decrypter for RSA keys
+// or an ecdh.PrivateKey like those of providers and OpenPGP cards for ECDH keys.
+// Signatures contained in the message are not verified.
+//
+// All failures to recover the session key result in the same error
+// so that they can not be used as a decryption oracle.
+func Decrypt(msg []byte, key *packet.PublicKey, priv crypto.PrivateKey) (*Message, error) {
+	sk, err := NewPrivateKey(key, priv)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := unarmor(msg, blockMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	kr := &keyRing{
+		key: openpgp.Key{
+			PublicKey:  key,
+			PrivateKey: sk,
+		},
+	}
+
+	md, err := openpgp.ReadMessage(r, kr, nil, &packet.Config{})
+	if err != nil {
+		return nil, ErrDecrypt
+	} else if !md.IsEncrypted {
+		return nil, fmt.Errorf("%w: message is not encrypted", ErrMalformed)
+	}
+
+	// The integrity of the message is checked after reading all data
+	data, err := io.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecrypt, err)
+	}
+
+	m := &Message{
+		Data: data,
+	}
+
+	if md.LiteralData != nil {
+		m.Filename = md.LiteralData.FileName
+		m.ModTime = time.Unix(int64(md.LiteralData.Time), 0)
+	}
+
+	return m, nil
+}
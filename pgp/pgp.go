@@ -0,0 +1,276 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package pgp creates OpenPGP signatures and decrypts OpenPGP messages
+// with keys held by providers or OpenPGP cards.
+//
+// The OpenPGP packets are handled by github.com/ProtonMail/go-crypto/openpgp.
+// This package only adapts the private keys, i.e. a crypto.Signer, crypto.Decrypter
+// or ecdh.PrivateKey, so that card-held keys can be used without gpg.
+package pgp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	pgpecdh "github.com/ProtonMail/go-crypto/openpgp/ecdh"
+	"github.com/ProtonMail/go-crypto/openpgp/eddsa"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+
+	ecdhx "cunicu.li/hawkes/ecdh"
+)
+
+var (
+	ErrMalformed        = errors.New("malformed data")
+	ErrUnsupportedKey   = errors.New("unsupported key")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrDecrypt          = errors.New("failed to decrypt")
+)
+
+// ReadPublicKeys returns the primary keys and subkeys of an armored
+// or binary key ring, e.g. the output of "gpg --export".
+func ReadPublicKeys(data []byte) ([]*packet.PublicKey, error) {
+	var (
+		entities openpgp.EntityList
+		err      error
+	)
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN ")) {
+		entities, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		entities, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keys: %w", err)
+	}
+
+	keys := []*packet.PublicKey{}
+
+	for _, e := range entities {
+		keys = append(keys, e.PrimaryKey)
+
+		for _, sk := range e.Subkeys {
+			keys = append(keys, sk.PublicKey)
+		}
+	}
+
+	return keys, nil
+}
+
+// NewPrivateKey returns an OpenPGP private key whose operations are performed by priv.
+//
+// The private key must be a crypto.Signer or crypto.Decrypter for RSA keys,
+// a crypto.Signer for ECDSA and EdDSA keys and an ecdh.PrivateKey for ECDH keys.
+func NewPrivateKey(pub *packet.PublicKey, priv crypto.PrivateKey) (*packet.PrivateKey, error) {
+	sk := &packet.PrivateKey{
+		PublicKey: *pub,
+	}
+
+	switch pk := pub.PublicKey.(type) {
+	case *rsa.PublicKey:
+		signer, _ := priv.(crypto.Signer)
+		decrypter, _ := priv.(crypto.Decrypter)
+
+		if signer == nil && decrypter == nil {
+			return nil, fmt.Errorf("%w: %T is neither a signer nor a decrypter", ErrUnsupportedKey, priv)
+		}
+
+		sk.PrivateKey = &rsaKey{
+			public:    pk,
+			signer:    signer,
+			decrypter: decrypter,
+		}
+
+	case *eddsa.PublicKey:
+		signer, ok := priv.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("%w: %T is not a signer", ErrUnsupportedKey, priv)
+		}
+
+		esk := eddsa.NewPrivateKey(*eddsa.NewPublicKey(&eddsaCurve{
+			eddsaCurveFuncs: pk.GetCurve(),
+			signer:          signer,
+		}))
+		esk.X = pk.X
+
+		sk.PrivateKey = esk
+
+	case *pgpecdh.PublicKey:
+		dk, ok := priv.(ecdhx.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: %T does not support ECDH", ErrUnsupportedKey, priv)
+		}
+
+		curve, err := stdCurve(pk.GetCurve().GetCurveName())
+		if err != nil {
+			return nil, err
+		}
+
+		epk := pgpecdh.NewPublicKey(&ecdhCurve{
+			ecdhCurveFuncs: pk.GetCurve(),
+			curve:          curve,
+			key:            dk,
+		}, pk.KDF.Hash, pk.KDF.Cipher)
+		epk.Point = pk.Point
+
+		sk.PrivateKey = pgpecdh.NewPrivateKey(*epk)
+
+	default:
+		// ECDSA keys are used by go-crypto via the crypto.Signer interface
+		signer, ok := priv.(crypto.Signer)
+		if !ok || pub.PubKeyAlgo != packet.PubKeyAlgoECDSA {
+			return nil, fmt.Errorf("%w: algorithm %d", ErrUnsupportedKey, pub.PubKeyAlgo)
+		}
+
+		sk.PrivateKey = signer
+	}
+
+	return sk, nil
+}
+
+// rsaKey implements both interfaces as go-crypto requires
+// a crypto.Signer for signing and a crypto.Decrypter for decryption.
+type rsaKey struct {
+	public    *rsa.PublicKey
+	signer    crypto.Signer
+	decrypter crypto.Decrypter
+}
+
+func (k *rsaKey) Public() crypto.PublicKey {
+	return k.public
+}
+
+func (k *rsaKey) Sign(r io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if k.signer == nil {
+		return nil, fmt.Errorf("%w: key can not sign", ErrUnsupportedKey)
+	}
+
+	return k.signer.Sign(r, digest, opts)
+}
+
+func (k *rsaKey) Decrypt(r io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	if k.decrypter == nil {
+		return nil, fmt.Errorf("%w: key can not decrypt", ErrUnsupportedKey)
+	}
+
+	return k.decrypter.Decrypt(r, msg, opts)
+}
+
+// eddsaCurveFuncs are the methods of the EdDSA curves of go-crypto.
+type eddsaCurveFuncs interface {
+	GetCurveName() string
+	MarshalBytePoint(x []byte) []byte
+	UnmarshalBytePoint([]byte) (x []byte)
+	MarshalByteSecret(d []byte) []byte
+	UnmarshalByteSecret(d []byte) []byte
+	MarshalSignature(sig []byte) (r, s []byte)
+	UnmarshalSignature(r, s []byte) (sig []byte)
+	GenerateEdDSA(rand io.Reader) (pub, priv []byte, err error)
+	Sign(publicKey, privateKey, message []byte) (sig []byte, err error)
+	Verify(publicKey, message, sig []byte) bool
+	ValidateEdDSA(publicKey, privateKey []byte) (err error)
+}
+
+// eddsaCurve signs with a crypto.Signer instead of the private key.
+type eddsaCurve struct {
+	eddsaCurveFuncs
+
+	signer crypto.Signer
+}
+
+func (c *eddsaCurve) Sign(_, _, message []byte) ([]byte, error) {
+	return c.signer.Sign(rand.Reader, message, crypto.Hash(0))
+}
+
+// ecdhCurveFuncs are the methods of the ECDH curves of go-crypto.
+type ecdhCurveFuncs interface {
+	GetCurveName() string
+	MarshalBytePoint([]byte) (encoded []byte)
+	UnmarshalBytePoint(encoded []byte) []byte
+	MarshalByteSecret(d []byte) []byte
+	UnmarshalByteSecret(d []byte) []byte
+	GenerateECDH(rand io.Reader) (point []byte, secret []byte, err error)
+	Encaps(rand io.Reader, point []byte) (ephemeral, sharedSecret []byte, err error)
+	Decaps(ephemeral, secret []byte) (sharedSecret []byte, err error)
+	ValidateECDH(public []byte, secret []byte) error
+}
+
+// ecdhCurve performs the key agreement with an ecdh.PrivateKey instead of the private key.
+type ecdhCurve struct {
+	ecdhCurveFuncs
+
+	curve ecdh.Curve
+	key   ecdhx.PrivateKey
+}
+
+func (c *ecdhCurve) Decaps(ephemeral, _ []byte) ([]byte, error) {
+	pk, err := c.curve.NewPublicKey(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.key.DH(&ecdhx.PublicKey{PublicKey: pk})
+}
+
+func stdCurve(name string) (ecdh.Curve, error) {
+	switch name {
+	case "P-256":
+		return ecdh.P256(), nil
+	case "P-384":
+		return ecdh.P384(), nil
+	case "P-521":
+		return ecdh.P521(), nil
+	case "curve25519":
+		return ecdh.X25519(), nil
+	}
+
+	return nil, fmt.Errorf("%w: curve %s", ErrUnsupportedKey, name)
+}
+
+// keyRing contains a single key of which the private key is available.
+type keyRing struct {
+	key openpgp.Key
+}
+
+func (r *keyRing) KeysById(id uint64) []openpgp.Key { //nolint:revive,stylecheck
+	if id != r.key.PublicKey.KeyId {
+		return nil
+	}
+
+	return []openpgp.Key{r.key}
+}
+
+func (r *keyRing) KeysByIdUsage(id uint64, _ byte) []openpgp.Key { //nolint:revive,stylecheck
+	return r.KeysById(id)
+}
+
+func (r *keyRing) DecryptionKeys() []openpgp.Key {
+	return []openpgp.Key{r.key}
+}
+
+// unarmor decodes armored data of the given block type. Binary data is returned as is.
+func unarmor(data []byte, blockType string) (io.Reader, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN ")) {
+		return bytes.NewReader(data), nil
+	}
+
+	block, err := armor.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformed, err)
+	}
+
+	if block.Type != blockType {
+		return nil, fmt.Errorf("%w: unexpected armor type %s", ErrMalformed, block.Type)
+	}
+
+	return block.Body, nil
+}
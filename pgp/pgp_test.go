@@ -0,0 +1,227 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package pgp_test
+
+import (
+	"bytes"
+	"crypto"
+	stdecdh "crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	pgpecdh "github.com/ProtonMail/go-crypto/openpgp/ecdh"
+	pgpecdsa "github.com/ProtonMail/go-crypto/openpgp/ecdsa"
+	"github.com/ProtonMail/go-crypto/openpgp/eddsa"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/ecdh/sw"
+	"cunicu.li/hawkes/pgp"
+)
+
+// opaqueSigner hides the type of a private key like hardware keys do.
+type opaqueSigner struct {
+	crypto.Signer
+}
+
+// opaqueRSA is an RSA key which can both sign and decrypt.
+type opaqueRSA struct {
+	key *rsa.PrivateKey
+}
+
+func (k *opaqueRSA) Public() crypto.PublicKey {
+	return k.key.Public()
+}
+
+func (k *opaqueRSA) Sign(r io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return k.key.Sign(r, digest, opts)
+}
+
+func (k *opaqueRSA) Decrypt(r io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	return k.key.Decrypt(r, msg, opts)
+}
+
+// newEntity generates an OpenPGP key and returns its exported public keys
+// together with the private keys as they would be held by an OpenPGP card.
+func newEntity(t *testing.T, cfg *packet.Config) (*openpgp.Entity, []*packet.PublicKey, []crypto.PrivateKey) {
+	require := require.New(t)
+
+	e, err := openpgp.NewEntity("Hawkes", "", "hawkes@example.com", cfg)
+	require.NoError(err)
+
+	buf := &bytes.Buffer{}
+	w, err := armor.Encode(buf, openpgp.PublicKeyType, nil)
+	require.NoError(err)
+	require.NoError(e.Serialize(w))
+	require.NoError(w.Close())
+
+	keys, err := pgp.ReadPublicKeys(buf.Bytes())
+	require.NoError(err)
+	require.Len(keys, 2)
+
+	var privs []crypto.PrivateKey
+
+	for _, sk := range []*packet.PrivateKey{e.PrivateKey, e.Subkeys[0].PrivateKey} {
+		switch sk := sk.PrivateKey.(type) {
+		case *rsa.PrivateKey:
+			privs = append(privs, &opaqueRSA{sk})
+
+		case *pgpecdsa.PrivateKey:
+			privs = append(privs, opaqueSigner{&ecdsa.PrivateKey{
+				PublicKey: ecdsa.PublicKey{
+					Curve: elliptic.P256(),
+					X:     sk.X,
+					Y:     sk.Y,
+				},
+				D: sk.D,
+			}})
+
+		case *eddsa.PrivateKey:
+			privs = append(privs, opaqueSigner{ed25519.NewKeyFromSeed(sk.D)})
+
+		case *pgpecdh.PrivateKey:
+			curve := stdecdh.P256()
+			if len(sk.Point) == 32 {
+				curve = stdecdh.X25519()
+			}
+
+			k, err := curve.NewPrivateKey(sk.D)
+			require.NoError(err)
+
+			privs = append(privs, &sw.PrivateKey{PrivateKey: k})
+
+		default:
+			require.FailNowf("unexpected key", "%T", sk)
+		}
+	}
+
+	return e, keys, privs
+}
+
+//nolint:gochecknoglobals
+var configs = map[string]*packet.Config{
+	"rsa": {
+		Algorithm: packet.PubKeyAlgoRSA,
+		RSABits:   2048,
+	},
+	"nist": {
+		Algorithm: packet.PubKeyAlgoECDSA,
+		Curve:     packet.CurveNistP256,
+	},
+	"25519": {
+		Algorithm: packet.PubKeyAlgoEdDSA,
+		Curve:     packet.Curve25519,
+	},
+}
+
+func TestSignVerify(t *testing.T) {
+	message := []byte("tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n")
+
+	for name, cfg := range configs {
+		t.Run(name, func(t *testing.T) {
+			e, keys, privs := newEntity(t, cfg)
+
+			for _, text := range []bool{false, true} {
+				require := require.New(t)
+
+				signer, ok := privs[0].(crypto.Signer)
+				require.True(ok)
+
+				created := time.Now().Add(time.Minute).Truncate(time.Second)
+
+				sig, err := pgp.Sign(signer, keys[0], bytes.NewReader(message), &pgp.SignOptions{
+					Text: text,
+					Time: created,
+				})
+				require.NoError(err)
+
+				armored, err := pgp.Armor(sig)
+				require.NoError(err)
+
+				parsed, err := pgp.ParseSignature(armored)
+				require.NoError(err)
+				require.Equal(keys[0].KeyId, *parsed.IssuerKeyId)
+				require.True(created.Equal(parsed.CreationTime))
+
+				require.NoError(pgp.Verify(parsed, keys[0], bytes.NewReader(message)))
+				require.ErrorIs(pgp.Verify(parsed, keys[0], bytes.NewReader(message[1:])), pgp.ErrInvalidSignature)
+				require.ErrorIs(pgp.Verify(parsed, keys[1], bytes.NewReader(message)), pgp.ErrInvalidSignature)
+
+				// The signature is accepted by go-crypto as well
+				_, err = openpgp.CheckArmoredDetachedSignature(openpgp.EntityList{e}, bytes.NewReader(message), bytes.NewReader(armored), &packet.Config{
+					Time: func() time.Time { return created },
+				})
+				require.NoError(err)
+			}
+		})
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	data := []byte("Attack at dawn")
+
+	for name, cfg := range configs {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+
+			e, keys, privs := newEntity(t, cfg)
+
+			buf := &bytes.Buffer{}
+			aw, err := armor.Encode(buf, "PGP MESSAGE", nil)
+			require.NoError(err)
+
+			w, err := openpgp.Encrypt(aw, openpgp.EntityList{e}, nil, &openpgp.FileHints{FileName: "dawn.txt"}, nil)
+			require.NoError(err)
+
+			_, err = w.Write(data)
+			require.NoError(err)
+			require.NoError(w.Close())
+			require.NoError(aw.Close())
+
+			m, err := pgp.Decrypt(buf.Bytes(), keys[1], privs[1])
+			require.NoError(err)
+			require.Equal(data, m.Data)
+			require.Equal("dawn.txt", m.Filename)
+
+			// Flipping a bit of the ciphertext must be detected
+			block, err := armor.Decode(bytes.NewReader(buf.Bytes()))
+			require.NoError(err)
+
+			msg, err := io.ReadAll(block.Body)
+			require.NoError(err)
+
+			msg[len(msg)-30] ^= 1
+
+			_, err = pgp.Decrypt(msg, keys[1], privs[1])
+			require.ErrorIs(err, pgp.ErrDecrypt)
+
+			// A key which is not among the recipients
+			_, others, otherPrivs := newEntity(t, cfg)
+
+			_, err = pgp.Decrypt(buf.Bytes(), others[1], otherPrivs[1])
+			require.ErrorIs(err, pgp.ErrDecrypt)
+		})
+	}
+}
+
+func TestNewPrivateKey(t *testing.T) {
+	require := require.New(t)
+
+	_, keys, privs := newEntity(t, configs["nist"])
+
+	// ECDH keys require a key agreement
+	_, err := pgp.NewPrivateKey(keys[1], privs[0])
+	require.ErrorIs(err, pgp.ErrUnsupportedKey)
+
+	// ECDSA keys require a signer
+	_, err = pgp.NewPrivateKey(keys[0], privs[1])
+	require.ErrorIs(err, pgp.ErrUnsupportedKey)
+}
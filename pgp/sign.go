@@ -0,0 +1,158 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package pgp
+
+import (
+	"bytes"
+	"crypto"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+const blockSignature = "PGP SIGNATURE"
+
+// SignOptions are the options for creating a signature.
+type SignOptions struct {
+	// Hash defaults to SHA-256.
+	Hash crypto.Hash
+
+	// Time is the creation time of the signature and defaults to the current time.
+	Time time.Time
+
+	// Text creates a text signature over the message with CRLF line endings.
+	Text bool
+}
+
+// Sign creates a detached signature of the message as produced by "gpg --detach-sign".
+// The key is the OpenPGP public key of the signer, e.g. read by ReadPublicKeys.
+func Sign(signer crypto.Signer, key *packet.PublicKey, message io.Reader, opts *SignOptions) (*packet.Signature, error) {
+	if opts == nil {
+		opts = &SignOptions{}
+	}
+
+	sk, err := NewPrivateKey(key, signer)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &packet.Config{
+		DefaultHash: opts.Hash,
+		Time:        func() time.Time { return opts.Time },
+	}
+
+	if opts.Hash == 0 {
+		config.DefaultHash = crypto.SHA256
+	}
+
+	if opts.Time.IsZero() {
+		config.Time = time.Now
+	}
+
+	sig := &packet.Signature{
+		Version:           key.Version,
+		SigType:           packet.SigTypeBinary,
+		PubKeyAlgo:        key.PubKeyAlgo,
+		Hash:              config.DefaultHash,
+		CreationTime:      config.Now(),
+		IssuerKeyId:       &key.KeyId,
+		IssuerFingerprint: key.Fingerprint,
+	}
+
+	if opts.Text {
+		sig.SigType = packet.SigTypeText
+	}
+
+	h, err := sig.PrepareSign(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.Copy(messageHash(h, sig.SigType), message); err != nil {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+
+	if err := sig.Sign(h, sk, config); err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+
+	return sig, nil
+}
+
+// Armor encodes a signature as armored "PGP SIGNATURE" block.
+func Armor(sig *packet.Signature) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	w, err := armor.Encode(buf, blockSignature, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sig.Serialize(w); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// ParseSignature decodes an armored or binary signature.
+func ParseSignature(data []byte) (*packet.Signature, error) {
+	r, err := unarmor(data, blockSignature)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := packet.Read(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformed, err)
+	}
+
+	sig, ok := p.(*packet.Signature)
+	if !ok {
+		return nil, fmt.Errorf("%w: not a signature", ErrMalformed)
+	}
+
+	return sig, nil
+}
+
+// Verify checks a detached signature of the message by the key.
+func Verify(sig *packet.Signature, key *packet.PublicKey, message io.Reader) error {
+	if !sig.CheckKeyIdOrFingerprint(key) {
+		return fmt.Errorf("%w: issued by another key", ErrInvalidSignature)
+	}
+
+	h, err := sig.PrepareVerify()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+
+	if _, err := io.Copy(messageHash(h, sig.SigType), message); err != nil {
+		return fmt.Errorf("failed to read message: %w", err)
+	}
+
+	if err := key.VerifySignature(h, sig); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+
+	return nil
+}
+
+// messageHash returns the writer for the message,
+// which canonicalizes the line endings for text signatures.
+func messageHash(h hash.Hash, sigType packet.SignatureType) io.Writer {
+	if sigType == packet.SigTypeText {
+		return openpgp.NewCanonicalTextHash(h)
+	}
+
+	return h
+}
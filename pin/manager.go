@@ -0,0 +1,280 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package pin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"cunicu.li/hawkes/event"
+	"cunicu.li/hawkes/internal/atomicfile"
+	"cunicu.li/hawkes/internal/flock"
+	"cunicu.li/hawkes/provider"
+)
+
+var ErrBlocked = errors.New("no PIN attempts left")
+
+const (
+	// DefaultMaxAttempts matches the retry counter of most tokens.
+	DefaultMaxAttempts = 3
+
+	// DefaultWarnAttempts warns before the last attempt.
+	DefaultWarnAttempts = 1
+)
+
+// Manager enforces a policy on PIN changes and tracks failed attempts
+// per provider name so that a token is not blocked by repeated retries.
+//
+// Only errors matching provider.ErrWrongPIN count as failed attempts.
+// As YKOATH applets and file keystores have no retry counter of their own,
+// the manager refuses further attempts once MaxAttempts is reached
+// until the attempts are Reset.
+type Manager struct {
+	Policy Policy
+
+	// Path is the file persisting the failed attempts so that the limit
+	// also holds across processes and restarts (see DefaultPath).
+	// Failed attempts are only counted in memory if empty.
+	Path string
+
+	// MaxAttempts is the number of failed attempts after which
+	// the manager refuses to try a PIN for a provider.
+	MaxAttempts int
+
+	// WarnAttempts is the number of remaining attempts at or below which Warn is called.
+	WarnAttempts int
+
+	// Warn is called before an attempt when few attempts are left.
+	Warn func(name string, remaining int)
+
+	// Events receives failed attempts and imminent lockouts.
+	// No events are published if nil.
+	Events event.Publisher
+
+	mu       sync.Mutex
+	failures map[string]int
+}
+
+// DefaultPath returns the default location of the file persisting failed attempts.
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find user config directory: %w", err)
+	}
+
+	return filepath.Join(dir, "hawkes", "pin-attempts.json"), nil
+}
+
+// NewManager creates a manager with the default policy which logs warnings.
+func NewManager() *Manager {
+	return &Manager{
+		Policy:       DefaultPolicy,
+		MaxAttempts:  DefaultMaxAttempts,
+		WarnAttempts: DefaultWarnAttempts,
+		Warn: func(name string, remaining int) {
+			slog.Warn("Provider will be blocked after further failed PIN attempts",
+				slog.String("provider", name),
+				slog.Int("remaining", remaining))
+		},
+		failures: map[string]int{},
+	}
+}
+
+// Remaining returns the number of PIN attempts left for a provider.
+func (m *Manager) Remaining(name string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.load(); err != nil {
+		// Refuse attempts rather than risking to block the token
+		slog.Error("Failed to load failed PIN attempts", slog.Any("error", err))
+		return 0
+	}
+
+	return max(m.MaxAttempts-m.failures[name], 0)
+}
+
+// Unlock unlocks a provider unless no attempts are left.
+// It implements provider.UnlockFunc.
+func (m *Manager) Unlock(name string, lp provider.LockableProvider, pin []byte) error {
+	if err := m.attempt(name); err != nil {
+		return err
+	}
+
+	return m.track(name, lp.Unlock(pin))
+}
+
+// ChangePIN verifies the old PIN and sets a new one which must satisfy the policy.
+func (m *Manager) ChangePIN(name string, lp provider.LockableProvider, old, new []byte) error {
+	pc, ok := lp.(provider.PINChanger)
+	if !ok {
+		return fmt.Errorf("%w: %s can not change its PIN", errors.ErrUnsupported, name)
+	}
+
+	if bytes.Equal(old, new) {
+		return fmt.Errorf("%w: new PIN must differ from the old one", ErrWeak)
+	}
+
+	if err := m.Policy.Check(new); err != nil {
+		return err
+	}
+
+	if err := m.attempt(name); err != nil {
+		return err
+	}
+
+	return m.track(name, pc.ChangePIN(old, new))
+}
+
+// Reset forgets the failed attempts counted for a provider,
+// e.g. after an administrator verified the identity of its user.
+func (m *Manager) Reset(name string) error {
+	return m.update(func() error {
+		delete(m.failures, name)
+		return nil
+	})
+}
+
+// attempt reserves an attempt by counting it as failed before the PIN is tried,
+// so that concurrent attempts of this and other processes can not exceed the limit.
+// The reservation is released by track unless the PIN was wrong.
+// Hence, attempts of processes which crash while trying a PIN remain counted.
+func (m *Manager) attempt(name string) error {
+	var remaining int
+
+	if err := m.update(func() error {
+		if remaining = max(m.MaxAttempts-m.failures[name], 0); remaining <= 0 {
+			return fmt.Errorf("%w: %s", ErrBlocked, name)
+		}
+
+		m.failures[name]++
+
+		return nil
+	}); err != nil {
+		if !errors.Is(err, ErrBlocked) {
+			// Refuse attempts rather than risking to block the token
+			slog.Error("Failed to reserve PIN attempt", slog.Any("error", err))
+		}
+
+		return err
+	}
+
+	if remaining <= m.WarnAttempts {
+		if m.Warn != nil {
+			m.Warn(name, remaining)
+		}
+
+		event.Publish(m.Events, event.Event{
+			Type:      event.LockoutImminent,
+			Provider:  name,
+			Remaining: remaining,
+		})
+	}
+
+	return nil
+}
+
+// track keeps the reserved attempt of a wrong PIN as failed attempt and resets the count after a success.
+// Other errors like transport failures or timeouts release the reserved attempt.
+func (m *Manager) track(name string, err error) error {
+	wrong := errors.Is(err, provider.ErrWrongPIN)
+
+	if !wrong {
+		if saveErr := m.update(func() error {
+			if err == nil {
+				delete(m.failures, name)
+			} else if m.failures[name] > 0 {
+				m.failures[name]--
+			}
+
+			return nil
+		}); saveErr != nil {
+			slog.Error("Failed to save failed PIN attempts", slog.Any("error", saveErr))
+		}
+
+		return err
+	}
+
+	event.Publish(m.Events, event.Event{
+		Type:      event.PINFailed,
+		Provider:  name,
+		Remaining: m.Remaining(name),
+		Error:     err.Error(),
+	})
+
+	return err
+}
+
+// update modifies the failed attempts of all processes.
+// The file is locked while it is read, modified and written
+// so that concurrent processes do not lose their changes.
+// Nothing is written if fn fails.
+func (m *Manager) update(fn func() error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Path != "" {
+		unlock, err := flock.Lock(m.Path)
+		if err != nil {
+			return fmt.Errorf("failed to lock %s: %w", m.Path, err)
+		}
+
+		defer unlock() //nolint:errcheck
+	}
+
+	if err := m.load(); err != nil {
+		return err
+	}
+
+	if err := fn(); err != nil {
+		return err
+	}
+
+	return m.save()
+}
+
+// load reads the failed attempts of all processes from the file.
+// It must be called with the lock held.
+func (m *Manager) load() error {
+	if m.failures == nil {
+		m.failures = map[string]int{}
+	}
+
+	if m.Path == "" {
+		return nil
+	}
+
+	buf, err := os.ReadFile(m.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		clear(m.failures)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	failures := map[string]int{}
+	if err := json.Unmarshal(buf, &failures); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", m.Path, err)
+	}
+
+	m.failures = failures
+
+	return nil
+}
+
+// save persists the failed attempts.
+// It must be called with the lock held.
+func (m *Manager) save() error {
+	if m.Path == "" {
+		return nil
+	}
+
+	return atomicfile.WriteJSON(m.Path, m.failures)
+}
@@ -0,0 +1,240 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package pin_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+
+	"cunicu.li/hawkes/event"
+	"cunicu.li/hawkes/pin"
+	"cunicu.li/hawkes/provider"
+)
+
+var (
+	errWrongPIN  = fmt.Errorf("%w: 63c2", provider.ErrWrongPIN)
+	errTransport = errors.New("card removed")
+)
+
+type lockableProvider struct {
+	provider.Provider
+
+	pin     []byte
+	removed bool
+}
+
+func (p *lockableProvider) Locked() bool { return true }
+
+func (p *lockableProvider) Unlock(pin []byte) error {
+	if p.removed {
+		return errTransport
+	}
+
+	if !bytes.Equal(pin, p.pin) {
+		return errWrongPIN
+	}
+
+	return nil
+}
+
+func (p *lockableProvider) ChangePIN(old, new []byte) error {
+	if err := p.Unlock(old); err != nil {
+		return err
+	}
+
+	p.pin = new
+
+	return nil
+}
+
+func TestPolicy(t *testing.T) {
+	require := require.New(t)
+
+	p := pin.DefaultPolicy
+
+	require.NoError(p.Check([]byte("739215")))
+	require.ErrorIs(p.Check([]byte("7392")), pin.ErrTooShort)
+	require.ErrorIs(p.Check([]byte("123456")), pin.ErrWeak)
+	require.ErrorIs(p.Check([]byte("111111")), pin.ErrWeak)
+	require.ErrorIs(p.Check([]byte("112112")), pin.ErrWeak)
+	require.ErrorIs(p.Check([]byte("987654")), pin.ErrWeak)
+	require.ErrorIs(p.Check([]byte("abcdefgh")), pin.ErrWeak)
+
+	p.MaxLength = 8
+	require.ErrorIs(p.Check([]byte("739215739")), pin.ErrTooLong)
+}
+
+func TestManager(t *testing.T) {
+	require := require.New(t)
+
+	var warnings []int
+
+	m := pin.NewManager()
+	m.Warn = func(_ string, remaining int) {
+		warnings = append(warnings, remaining)
+	}
+
+	p := &lockableProvider{
+		pin: []byte("739215"),
+	}
+
+	require.ErrorIs(m.Unlock("a", p, []byte("000000")), errWrongPIN)
+	require.ErrorIs(m.Unlock("a", p, []byte("000000")), errWrongPIN)
+	require.Equal(1, m.Remaining("a"))
+
+	// Failures are tracked per provider
+	require.Equal(pin.DefaultMaxAttempts, m.Remaining("b"))
+
+	// Success resets the failures
+	require.NoError(m.Unlock("a", p, []byte("739215")))
+	require.Equal(pin.DefaultMaxAttempts, m.Remaining("a"))
+	require.Equal([]int{1}, warnings)
+
+	// Other errors are no failed attempts
+	p.removed = true
+	for range pin.DefaultMaxAttempts {
+		require.ErrorIs(m.Unlock("a", p, []byte("739215")), errTransport)
+	}
+
+	require.Equal(pin.DefaultMaxAttempts, m.Remaining("a"))
+	p.removed = false
+
+	for range pin.DefaultMaxAttempts {
+		require.ErrorIs(m.Unlock("a", p, []byte("000000")), errWrongPIN)
+	}
+
+	// The manager refuses to block the token
+	require.ErrorIs(m.Unlock("a", p, []byte("739215")), pin.ErrBlocked)
+
+	require.NoError(m.Reset("a"))
+	require.NoError(m.Unlock("a", p, []byte("739215")))
+
+	// PIN changes
+	require.ErrorIs(m.ChangePIN("a", p, []byte("739215"), []byte("739215")), pin.ErrWeak)
+	require.ErrorIs(m.ChangePIN("a", p, []byte("739215"), []byte("12345")), pin.ErrTooShort)
+	require.NoError(m.ChangePIN("a", p, []byte("739215"), []byte("604817")))
+	require.NoError(m.Unlock("a", p, []byte("604817")))
+}
+
+func TestManagerPersistence(t *testing.T) {
+	require := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "pin-attempts.json")
+
+	m := pin.NewManager()
+	m.Warn = nil
+	m.Path = path
+
+	p := &lockableProvider{
+		pin: []byte("739215"),
+	}
+
+	for range pin.DefaultMaxAttempts {
+		require.ErrorIs(m.Unlock("a", p, []byte("000000")), errWrongPIN)
+	}
+
+	// Another process shares the failed attempts
+	m2 := pin.NewManager()
+	m2.Path = path
+
+	require.Equal(0, m2.Remaining("a"))
+	require.ErrorIs(m2.Unlock("a", p, []byte("739215")), pin.ErrBlocked)
+
+	require.NoError(m2.Reset("a"))
+	require.Equal(pin.DefaultMaxAttempts, m.Remaining("a"))
+	require.NoError(m.Unlock("a", p, []byte("739215")))
+}
+
+func TestManagerEvents(t *testing.T) {
+	require := require.New(t)
+
+	bus := event.NewBus()
+	sub := bus.Subscribe(0)
+
+	m := pin.NewManager()
+	m.Warn = nil
+	m.Events = bus
+
+	p := &lockableProvider{
+		pin: []byte("739215"),
+	}
+
+	require.ErrorIs(m.Unlock("a", p, []byte("000000")), errWrongPIN)
+	require.ErrorIs(m.Unlock("a", p, []byte("000000")), errWrongPIN)
+	require.ErrorIs(m.Unlock("a", p, []byte("000000")), errWrongPIN)
+
+	var events []event.Event
+	for range 4 {
+		events = append(events, <-sub.C())
+	}
+
+	require.Equal(event.PINFailed, events[0].Type)
+	require.Equal(2, events[0].Remaining)
+	require.Equal(errWrongPIN.Error(), events[0].Error)
+	require.Equal(event.PINFailed, events[1].Type)
+	require.Equal(1, events[1].Remaining)
+
+	// Warnings are published before the attempt
+	require.Equal(event.LockoutImminent, events[2].Type)
+	require.Equal(1, events[2].Remaining)
+	require.Equal(event.PINFailed, events[3].Type)
+	require.Equal("a", events[3].Provider)
+	require.Empty(sub.C())
+}
+
+// slowProvider counts the PINs tried on the token and rejects them after a delay.
+type slowProvider struct {
+	provider.Provider
+
+	tried atomic.Int32
+}
+
+func (p *slowProvider) Locked() bool { return true }
+
+func (p *slowProvider) Unlock([]byte) error {
+	p.tried.Add(1)
+	time.Sleep(10 * time.Millisecond)
+
+	return errWrongPIN
+}
+
+func TestManagerConcurrent(t *testing.T) {
+	require := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "pin-attempts.json")
+	p := &slowProvider{}
+
+	var g errgroup.Group
+
+	// Managers of separate processes share the file
+	for range 10 {
+		m := pin.NewManager()
+		m.Warn = nil
+		m.Path = path
+
+		g.Go(func() error {
+			if err := m.Unlock("a", p, []byte("000000")); !errors.Is(err, errWrongPIN) && !errors.Is(err, pin.ErrBlocked) {
+				return err
+			}
+
+			return nil
+		})
+	}
+
+	require.NoError(g.Wait())
+	require.EqualValues(pin.DefaultMaxAttempts, p.tried.Load())
+
+	m := pin.NewManager()
+	m.Path = path
+
+	require.Equal(0, m.Remaining("a"))
+}
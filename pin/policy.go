@@ -0,0 +1,100 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package pin enforces PIN complexity rules and tracks failed
+// attempts across providers to avoid blocking tokens by accident.
+package pin
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+var (
+	ErrTooShort = errors.New("PIN is too short")
+	ErrTooLong  = errors.New("PIN is too long")
+	ErrWeak     = errors.New("PIN is too weak")
+)
+
+// Policy describes the complexity requirements for new PINs.
+type Policy struct {
+	// MinLength and MaxLength limit the length of the PIN.
+	// A zero MaxLength does not limit the length.
+	MinLength int
+	MaxLength int
+
+	// MinUnique is the minimum number of distinct characters.
+	MinUnique int
+
+	// ForbidSequences rejects PINs which consist of a single
+	// ascending or descending sequence like "123456" or "fedcba".
+	ForbidSequences bool
+
+	// Blocklist contains PINs which are never accepted, e.g. factory defaults.
+	Blocklist []string
+}
+
+// DefaultPolicy rejects short and trivial PINs as well as the factory defaults of common tokens.
+//
+//nolint:gochecknoglobals
+var DefaultPolicy = Policy{
+	MinLength:       6,
+	MinUnique:       3,
+	ForbidSequences: true,
+	Blocklist: []string{
+		"123456",   // PIV and OpenPGP user PIN
+		"12345678", // PIV PUK and OpenPGP admin PIN
+		"000000",
+		"password",
+	},
+}
+
+// Check returns an error if the PIN does not satisfy the policy.
+func (p *Policy) Check(pin []byte) error {
+	if len(pin) < p.MinLength {
+		return fmt.Errorf("%w: at least %d characters required", ErrTooShort, p.MinLength)
+	}
+
+	if p.MaxLength > 0 && len(pin) > p.MaxLength {
+		return fmt.Errorf("%w: at most %d characters allowed", ErrTooLong, p.MaxLength)
+	}
+
+	if slices.Contains(p.Blocklist, string(pin)) {
+		return fmt.Errorf("%w: PIN is not allowed", ErrWeak)
+	}
+
+	unique := map[byte]struct{}{}
+	for _, c := range pin {
+		unique[c] = struct{}{}
+	}
+
+	if len(unique) < p.MinUnique {
+		return fmt.Errorf("%w: at least %d distinct characters required", ErrWeak, p.MinUnique)
+	}
+
+	if p.ForbidSequences && isSequence(pin) {
+		return fmt.Errorf("%w: sequences are not allowed", ErrWeak)
+	}
+
+	return nil
+}
+
+func isSequence(pin []byte) bool {
+	if len(pin) < 2 {
+		return false
+	}
+
+	step := int(pin[1]) - int(pin[0])
+	if step != 1 && step != -1 {
+		return false
+	}
+
+	for i := 2; i < len(pin); i++ {
+		if int(pin[i])-int(pin[i-1]) != step {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,256 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package piv
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+
+	"cunicu.li/go-iso7816"
+	"cunicu.li/go-iso7816/encoding/tlv"
+)
+
+// See: https://developers.yubico.com/PIV/Introduction/PIV_attestation.html
+
+const (
+	insAttest     iso7816.Instruction = 0xf9
+	insGetVersion iso7816.Instruction = 0xfd
+
+	// SlotAttestation holds the attestation key of YubiKeys.
+	SlotAttestation Slot = 0xf9
+
+	tagMetadataPolicy tlv.Tag = 0x02
+	tagMetadataOrigin tlv.Tag = 0x03
+
+	tagObjectID   tlv.Tag = 0x5c
+	tagObjectData tlv.Tag = 0x53
+
+	// tagCertData is the number of the application-specific tag 0x70.
+	tagCertData = 16
+
+	originGenerated byte = 0x01
+)
+
+var ErrInvalidAttestation = errors.New("invalid attestation")
+
+//nolint:gochecknoglobals
+var (
+	// objectAttestation is the data object of the certificate in the attestation slot.
+	objectAttestation = []byte{0x5f, 0xff, 0x01}
+
+	oidFirmware   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 41482, 3, 3}
+	oidSerial     = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 41482, 3, 7}
+	oidPolicy     = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 41482, 3, 8}
+	oidFormFactor = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 41482, 3, 9}
+)
+
+// Metadata describes the key in a slot.
+type Metadata struct {
+	Algorithm Algorithm
+	Policies  Policies
+
+	// Generated is true for keys generated on the card and false for imported keys.
+	Generated bool
+}
+
+// Metadata returns the algorithm, policies and origin of the key in a slot.
+// It is a Yubico extension introduced with firmware 5.3.
+// Empty slots return iso7816.ErrReferenceNotFound.
+func (c *Card) Metadata(slot Slot) (*Metadata, error) {
+	resp, err := c.Send(&iso7816.CAPDU{
+		Ins: insGetMetadata,
+		P2:  byte(slot),
+		Ne:  iso7816.MaxLenRespDataStandard,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tvs, err := tlv.DecodeBER(resp)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+	}
+
+	md := &Metadata{}
+
+	if v, _, ok := tvs.Get(tagMetadataAlgorithm); ok && len(v) == 1 {
+		md.Algorithm = Algorithm(v[0])
+	}
+
+	if v, _, ok := tvs.Get(tagMetadataPolicy); ok && len(v) == 2 {
+		md.Policies.PIN = PINPolicy(v[0])
+		md.Policies.Touch = TouchPolicy(v[1])
+	}
+
+	if v, _, ok := tvs.Get(tagMetadataOrigin); ok && len(v) == 1 {
+		md.Generated = v[0] == originGenerated
+	}
+
+	return md, nil
+}
+
+// Version returns the firmware version of the PIV applet.
+func (c *Card) Version() ([]byte, error) {
+	resp, err := c.Send(&iso7816.CAPDU{
+		Ins: insGetVersion,
+		Ne:  iso7816.MaxLenRespDataStandard,
+	})
+	if err != nil {
+		return nil, err
+	} else if len(resp) != 3 {
+		return nil, fmt.Errorf("%w: version has invalid length", ErrInvalidResponse)
+	}
+
+	return resp, nil
+}
+
+// Attest returns a certificate for the key in a slot which is signed by the attestation key.
+// Only keys generated on the card can be attested.
+func (c *Card) Attest(slot Slot) (*x509.Certificate, error) {
+	resp, err := c.Send(&iso7816.CAPDU{
+		Ins: insAttest,
+		P1:  byte(slot),
+		Ne:  iso7816.MaxLenResponseDataExtended,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to attest slot %s: %w", slot, err)
+	}
+
+	cert, err := x509.ParseCertificate(resp)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+	}
+
+	return cert, nil
+}
+
+// AttestationCertificate returns the certificate of the attestation key
+// which is issued by Yubico during manufacturing.
+func (c *Card) AttestationCertificate() (*x509.Certificate, error) {
+	data, err := tlv.EncodeBER(tlv.New(tagObjectID, objectAttestation))
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Send(&iso7816.CAPDU{
+		Ins:  iso7816.InsGetDataOdd,
+		P1:   0x3f,
+		P2:   0xff,
+		Data: data,
+		Ne:   iso7816.MaxLenResponseDataExtended,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attestation certificate: %w", err)
+	}
+
+	tvs, err := tlv.DecodeBER(resp)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+	}
+
+	obj, _, ok := tvs.Get(tagObjectData)
+	if !ok {
+		return nil, fmt.Errorf("%w: missing data object", ErrInvalidResponse)
+	}
+
+	// The certificate tag is constructed and would otherwise
+	// be decoded recursively by tlv.DecodeBER.
+	var der asn1.RawValue
+	if _, err := asn1.Unmarshal(obj, &der); err != nil || der.Class != asn1.ClassApplication || der.Tag != tagCertData {
+		return nil, fmt.Errorf("%w: missing certificate", ErrInvalidResponse)
+	}
+
+	cert, err := x509.ParseCertificate(der.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+	}
+
+	return cert, nil
+}
+
+// Attestation are the properties of an attested key
+// as certified by the attestation key of the card.
+type Attestation struct {
+	PublicKey  crypto.PublicKey
+	Serial     uint32
+	Firmware   []byte
+	FormFactor byte
+	Policies   Policies
+}
+
+// VerifyOptions are the trust anchors for verifying attestations.
+type VerifyOptions struct {
+	// Roots must contain the Yubico PIV root CA.
+	Roots *x509.CertPool
+
+	// Intermediates may contain the Yubico intermediate CAs
+	// which issue the attestation certificates of recent YubiKeys.
+	Intermediates *x509.CertPool
+}
+
+// VerifyAttestation checks that the certificate of a slot has been signed by the attestation key of the card
+// and that the certificate of the attestation key chains up to one of the roots.
+func VerifyAttestation(device, slot *x509.Certificate, opts VerifyOptions) (*Attestation, error) {
+	if opts.Roots == nil {
+		return nil, fmt.Errorf("%w: no roots", ErrInvalidAttestation)
+	}
+
+	// The attestation certificates are issued for a key usage unknown to crypto/x509
+	if _, err := device.Verify(x509.VerifyOptions{
+		Roots:         opts.Roots,
+		Intermediates: opts.Intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidAttestation, err)
+	}
+
+	// Attestation certificates of older firmware lack the basic constraints
+	// required by x509.Certificate.CheckSignatureFrom.
+	if err := device.CheckSignature(slot.SignatureAlgorithm, slot.RawTBSCertificate, slot.Signature); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidAttestation, err)
+	}
+
+	a := &Attestation{
+		PublicKey: slot.PublicKey,
+	}
+
+	for _, ext := range slot.Extensions {
+		switch {
+		case ext.Id.Equal(oidFirmware):
+			if len(ext.Value) != 3 {
+				return nil, fmt.Errorf("%w: invalid firmware version", ErrInvalidAttestation)
+			}
+
+			a.Firmware = ext.Value
+
+		case ext.Id.Equal(oidSerial):
+			var serial int64
+			if _, err := asn1.Unmarshal(ext.Value, &serial); err != nil || serial < 0 || serial > 1<<32-1 {
+				return nil, fmt.Errorf("%w: invalid serial", ErrInvalidAttestation)
+			}
+
+			a.Serial = uint32(serial) //nolint:gosec
+
+		case ext.Id.Equal(oidPolicy):
+			if len(ext.Value) != 2 {
+				return nil, fmt.Errorf("%w: invalid policy", ErrInvalidAttestation)
+			}
+
+			a.Policies.PIN = PINPolicy(ext.Value[0])
+			a.Policies.Touch = TouchPolicy(ext.Value[1])
+
+		case ext.Id.Equal(oidFormFactor):
+			if len(ext.Value) != 1 {
+				return nil, fmt.Errorf("%w: invalid form factor", ErrInvalidAttestation)
+			}
+
+			a.FormFactor = ext.Value[0]
+		}
+	}
+
+	return a, nil
+}
@@ -0,0 +1,241 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package piv
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des" //nolint:gosec
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+
+	"cunicu.li/go-iso7816"
+	"cunicu.li/go-iso7816/encoding/tlv"
+)
+
+const (
+	insGetMetadata iso7816.Instruction = 0xf7
+	insImportKey   iso7816.Instruction = 0xfe
+
+	// keyRefManagement references the card management key.
+	keyRefManagement byte = 0x9b
+
+	tagDynamicAuth tlv.Tag = 0x7c
+	tagWitness     tlv.Tag = 0x80
+	tagChallenge   tlv.Tag = 0x81
+	tagResponse    tlv.Tag = 0x82
+
+	tagMetadataAlgorithm tlv.Tag = 0x01
+)
+
+// DefaultManagementKey is the factory default management key of YubiKeys.
+//
+//nolint:gochecknoglobals
+var DefaultManagementKey = []byte{
+	0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+	0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+	0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+}
+
+// Card is a smart card with a selected PIV applet.
+type Card struct {
+	*iso7816.Card
+}
+
+// NewCard selects the PIV applet of a card.
+func NewCard(card iso7816.PCSCCard) (*Card, error) {
+	c := &Card{
+		Card: iso7816.NewCard(card),
+	}
+
+	if _, err := c.Select(iso7816.AidPIV); err != nil {
+		return nil, fmt.Errorf("failed to select applet: %w", err)
+	}
+
+	return c, nil
+}
+
+// Authenticate performs a mutual authentication with the card management key
+// which is required for importing keys.
+func (c *Card) Authenticate(key []byte) error {
+	alg := c.managementKeyAlgorithm()
+
+	block, err := newBlockCipher(alg, key)
+	if err != nil {
+		return err
+	}
+
+	bs := block.BlockSize()
+
+	// Request a witness which is encrypted with the management key
+	witness, err := c.generalAuthenticate(alg, keyRefManagement, tagWitness, tlv.New(tagWitness))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrAuthentication, err)
+	} else if len(witness) != bs {
+		return fmt.Errorf("%w: witness has invalid length", ErrInvalidResponse)
+	}
+
+	block.Decrypt(witness, witness)
+
+	challenge := make([]byte, bs)
+	if _, err := rand.Read(challenge); err != nil {
+		return err
+	}
+
+	// Return the decrypted witness and challenge the card in return
+	resp, err := c.generalAuthenticate(alg, keyRefManagement, tagResponse,
+		tlv.New(tagWitness, witness),
+		tlv.New(tagChallenge, challenge))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrAuthentication, err)
+	}
+
+	expected := make([]byte, bs)
+	block.Encrypt(expected, challenge)
+
+	if subtle.ConstantTimeCompare(resp, expected) != 1 {
+		return fmt.Errorf("%w: card returned invalid response", ErrAuthentication)
+	}
+
+	return nil
+}
+
+// SignECDSA signs a digest with the EC key in a slot and returns the ASN.1 encoded signature.
+// The PIN must have been verified before if the PIN policy of the slot requires it.
+func (c *Card) SignECDSA(slot Slot, alg Algorithm, digest []byte) ([]byte, error) {
+	var size int
+	switch alg {
+	case AlgECCP256:
+		size = 32
+	case AlgECCP384:
+		size = 48
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedKey, alg)
+	}
+
+	// The card expects the digest truncated or padded to the size of the curve
+	if len(digest) > size {
+		digest = digest[:size]
+	} else if len(digest) < size {
+		digest = append(make([]byte, size-len(digest)), digest...)
+	}
+
+	sig, err := c.generalAuthenticate(alg, byte(slot), tagResponse,
+		tlv.New(tagResponse),
+		tlv.New(tagChallenge, digest))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+
+	return sig, nil
+}
+
+// SignECDSABatch signs the digests with the EC key in a slot within
+// a single card transaction, so that other applications can not
+// interleave their commands and a verified PIN stays valid for the whole batch.
+func (c *Card) SignECDSABatch(slot Slot, alg Algorithm, digests [][]byte) (sigs [][]byte, err error) {
+	tx, err := c.NewTransaction()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if cerr := tx.Close(); cerr != nil && err == nil {
+			err = fmt.Errorf("failed to end transaction: %w", cerr)
+		}
+	}()
+
+	sigs = make([][]byte, len(digests))
+	for i, digest := range digests {
+		if sigs[i], err = c.SignECDSA(slot, alg, digest); err != nil {
+			return nil, err
+		}
+	}
+
+	return sigs, nil
+}
+
+// managementKeyAlgorithm returns the algorithm of the management key.
+func (c *Card) managementKeyAlgorithm() Algorithm {
+	resp, err := c.Send(&iso7816.CAPDU{
+		Ins: insGetMetadata,
+		P2:  keyRefManagement,
+		Ne:  iso7816.MaxLenRespDataStandard,
+	})
+	if err != nil {
+		// Cards without metadata support only 3DES management keys
+		return Alg3DES
+	}
+
+	tvs, err := tlv.DecodeBER(resp)
+	if err != nil {
+		return Alg3DES
+	}
+
+	if v, _, ok := tvs.Get(tagMetadataAlgorithm); ok && len(v) == 1 {
+		return Algorithm(v[0])
+	}
+
+	return Alg3DES
+}
+
+// generalAuthenticate sends a GENERAL AUTHENTICATE command for the referenced key with the
+// dynamic authentication template and returns the value of the given tag from the response.
+func (c *Card) generalAuthenticate(alg Algorithm, key byte, tag tlv.Tag, tvs ...tlv.TagValue) ([]byte, error) {
+	data, err := tlv.EncodeBER(tlv.New(tagDynamicAuth, tlv.TagValues(tvs)))
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Send(&iso7816.CAPDU{
+		Ins:  iso7816.InsGeneralAuthenticate,
+		P1:   byte(alg),
+		P2:   key,
+		Data: data,
+		Ne:   iso7816.MaxLenRespDataStandard,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rtvs, err := tlv.DecodeBER(resp)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+	}
+
+	v, _, ok := rtvs.GetChild(tagDynamicAuth, tag)
+	if !ok {
+		return nil, fmt.Errorf("%w: missing tag %x", ErrInvalidResponse, tag)
+	}
+
+	return v, nil
+}
+
+func newBlockCipher(alg Algorithm, key []byte) (cipher.Block, error) {
+	var keyLen int
+	switch alg {
+	case Alg3DES:
+		if len(key) != 24 {
+			return nil, fmt.Errorf("%w: 3DES management key must have 24 bytes", ErrAuthentication)
+		}
+
+		return des.NewTripleDESCipher(key) //nolint:gosec
+
+	case AlgAES128:
+		keyLen = 16
+	case AlgAES192:
+		keyLen = 24
+	case AlgAES256:
+		keyLen = 32
+	default:
+		return nil, fmt.Errorf("%w: unsupported management key algorithm %#x", ErrAuthentication, byte(alg))
+	}
+
+	if len(key) != keyLen {
+		return nil, fmt.Errorf("%w: AES management key must have %d bytes", ErrAuthentication, keyLen)
+	}
+
+	return aes.NewCipher(key)
+}
@@ -0,0 +1,187 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package piv
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"cunicu.li/go-iso7816"
+	"cunicu.li/go-iso7816/encoding/tlv"
+
+	"cunicu.li/hawkes/secret"
+)
+
+const (
+	tagRSAP    tlv.Tag = 0x01
+	tagRSAQ    tlv.Tag = 0x02
+	tagRSADP   tlv.Tag = 0x03
+	tagRSADQ   tlv.Tag = 0x04
+	tagRSAQInv tlv.Tag = 0x05
+	tagECC     tlv.Tag = 0x06
+
+	tagPINPolicy   tlv.Tag = 0xaa
+	tagTouchPolicy tlv.Tag = 0xab
+)
+
+// ImportKey stores an existing RSA or EC private key in a slot.
+// It is a Yubico extension which requires a prior Authenticate with the management key.
+// Supported are RSA keys with 1024 to 4096 bits and a public exponent of 65537,
+// as well as ECDSA and ECDH keys on the P-256 and P-384 curves.
+// RSA keys with 3072 and 4096 bits require YubiKey firmware 5.7.
+func (c *Card) ImportKey(slot Slot, key crypto.PrivateKey, policies Policies) error {
+	alg, tvs, err := encodeKey(key)
+	if err != nil {
+		return err
+	}
+
+	if policies.PIN != PINPolicyDefault {
+		tvs = append(tvs, tlv.New(tagPINPolicy, byte(policies.PIN)))
+	}
+
+	if policies.Touch != TouchPolicyDefault {
+		tvs = append(tvs, tlv.New(tagTouchPolicy, byte(policies.Touch)))
+	}
+
+	data, err := tlv.EncodeBER(tvs...)
+	if err != nil {
+		return err
+	}
+
+	defer secret.Wipe(data)
+
+	for _, tv := range tvs {
+		secret.Wipe(tv.Value)
+	}
+
+	if _, err := c.Send(&iso7816.CAPDU{
+		Ins:  insImportKey,
+		P1:   byte(alg),
+		P2:   byte(slot),
+		Data: data,
+	}); err != nil {
+		return fmt.Errorf("failed to import key: %w", err)
+	}
+
+	return nil
+}
+
+// CheckImport validates a key and its policies for ImportKey without accessing the card.
+// It returns the algorithm which the key would be stored with.
+func CheckImport(key crypto.PrivateKey, policies Policies) (Algorithm, error) {
+	alg, tvs, err := encodeKey(key)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, tv := range tvs {
+		secret.Wipe(tv.Value)
+	}
+
+	if int(policies.PIN) >= len(pinPolicies) {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidPolicy, policies.PIN)
+	}
+
+	if int(policies.Touch) >= len(touchPolicies) {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidPolicy, policies.Touch)
+	}
+
+	return alg, nil
+}
+
+// ParsePrivateKey parses a PEM-encoded private key in PKCS #8, PKCS #1 or SEC 1 form.
+func ParsePrivateKey(data []byte) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: no PEM block found", ErrUnsupportedKey)
+	}
+
+	defer secret.Wipe(block.Bytes)
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%w: unexpected PEM block %s", ErrUnsupportedKey, block.Type)
+	}
+}
+
+func encodeKey(key crypto.PrivateKey) (Algorithm, []tlv.TagValue, error) {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return encodeRSAKey(key)
+
+	case *ecdsa.PrivateKey:
+		ek, err := key.ECDH()
+		if err != nil {
+			return 0, nil, fmt.Errorf("%w: %w", ErrUnsupportedKey, err)
+		}
+
+		return encodeKey(ek)
+
+	case *ecdh.PrivateKey:
+		var alg Algorithm
+		switch key.Curve() {
+		case ecdh.P256():
+			alg = AlgECCP256
+		case ecdh.P384():
+			alg = AlgECCP384
+		default:
+			return 0, nil, fmt.Errorf("%w: unsupported curve %s", ErrUnsupportedKey, key.Curve())
+		}
+
+		return alg, []tlv.TagValue{
+			tlv.New(tagECC, key.Bytes()),
+		}, nil
+
+	default:
+		return 0, nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, key)
+	}
+}
+
+func encodeRSAKey(key *rsa.PrivateKey) (Algorithm, []tlv.TagValue, error) {
+	var alg Algorithm
+	switch key.N.BitLen() {
+	case 1024:
+		alg = AlgRSA1024
+	case 2048:
+		alg = AlgRSA2048
+	case 3072:
+		alg = AlgRSA3072
+	case 4096:
+		alg = AlgRSA4096
+	default:
+		return 0, nil, fmt.Errorf("%w: unsupported RSA key size %d", ErrUnsupportedKey, key.N.BitLen())
+	}
+
+	if key.E != 65537 {
+		return 0, nil, fmt.Errorf("%w: unsupported public exponent %d", ErrUnsupportedKey, key.E)
+	}
+
+	if len(key.Primes) != 2 {
+		return 0, nil, fmt.Errorf("%w: multi-prime keys are not supported", ErrUnsupportedKey)
+	}
+
+	key.Precompute()
+
+	// All parameters are padded to half the modulus size
+	n := key.Size() / 2
+
+	return alg, []tlv.TagValue{
+		tlv.New(tagRSAP, key.Primes[0].FillBytes(make([]byte, n))),
+		tlv.New(tagRSAQ, key.Primes[1].FillBytes(make([]byte, n))),
+		tlv.New(tagRSADP, key.Precomputed.Dp.FillBytes(make([]byte, n))),
+		tlv.New(tagRSADQ, key.Precomputed.Dq.FillBytes(make([]byte, n))),
+		tlv.New(tagRSAQInv, key.Precomputed.Qinv.FillBytes(make([]byte, n))),
+	}, nil
+}
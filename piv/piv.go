@@ -0,0 +1,174 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package piv manages keys of the PIV applet (NIST SP 800-73-4)
+// including the Yubico extensions for importing existing keys.
+package piv
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidSlot     = errors.New("invalid slot")
+	ErrInvalidPolicy   = errors.New("invalid policy")
+	ErrUnsupportedKey  = errors.New("unsupported key")
+	ErrAuthentication  = errors.New("management key authentication failed")
+	ErrInvalidResponse = errors.New("invalid response")
+)
+
+// Slot is the key reference of a PIV key slot.
+type Slot byte
+
+const (
+	SlotAuthentication     Slot = 0x9a
+	SlotSignature          Slot = 0x9c
+	SlotKeyManagement      Slot = 0x9d
+	SlotCardAuthentication Slot = 0x9e
+
+	// Retired key management slots 1-20.
+	SlotRetired1  Slot = 0x82
+	SlotRetired20 Slot = 0x95
+)
+
+// ParseSlot parses the hexadecimal key reference of a slot like "9a".
+func ParseSlot(s string) (Slot, error) {
+	v, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(s), "0x"), 16, 8)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidSlot, s)
+	}
+
+	slot := Slot(v)
+
+	switch {
+	case slot == SlotAuthentication,
+		slot == SlotSignature,
+		slot == SlotKeyManagement,
+		slot == SlotCardAuthentication,
+		slot >= SlotRetired1 && slot <= SlotRetired20:
+		return slot, nil
+
+	default:
+		return 0, fmt.Errorf("%w: %s", ErrInvalidSlot, s)
+	}
+}
+
+func (s Slot) String() string {
+	return fmt.Sprintf("%02x", byte(s))
+}
+
+// PINPolicy decides when the PIN must be verified before using a key.
+type PINPolicy byte
+
+const (
+	PINPolicyDefault PINPolicy = iota // Default policy of the slot
+	PINPolicyNever
+	PINPolicyOnce // Once per session
+	PINPolicyAlways
+)
+
+// TouchPolicy decides when the token must be touched before using a key.
+type TouchPolicy byte
+
+const (
+	TouchPolicyDefault TouchPolicy = iota // Default policy of the slot
+	TouchPolicyNever
+	TouchPolicyAlways
+	TouchPolicyCached // Cached for 15 seconds
+)
+
+//nolint:gochecknoglobals
+var (
+	pinPolicies   = []string{"default", "never", "once", "always"}
+	touchPolicies = []string{"default", "never", "always", "cached"}
+)
+
+// ParsePINPolicy parses the name of a PIN policy.
+func ParsePINPolicy(s string) (PINPolicy, error) {
+	for i, name := range pinPolicies {
+		if s == name {
+			return PINPolicy(i), nil //nolint:gosec
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %s", ErrInvalidPolicy, s)
+}
+
+func (p PINPolicy) String() string {
+	if int(p) < len(pinPolicies) {
+		return pinPolicies[p]
+	}
+
+	return fmt.Sprintf("unknown(%d)", p)
+}
+
+// ParseTouchPolicy parses the name of a touch policy.
+func ParseTouchPolicy(s string) (TouchPolicy, error) {
+	for i, name := range touchPolicies {
+		if s == name {
+			return TouchPolicy(i), nil //nolint:gosec
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %s", ErrInvalidPolicy, s)
+}
+
+func (p TouchPolicy) String() string {
+	if int(p) < len(touchPolicies) {
+		return touchPolicies[p]
+	}
+
+	return fmt.Sprintf("unknown(%d)", p)
+}
+
+// Policies are the usage policies of a key.
+type Policies struct {
+	PIN   PINPolicy
+	Touch TouchPolicy
+}
+
+// Algorithm identifies the cryptographic mechanism of a key.
+type Algorithm byte
+
+const (
+	Alg3DES    Algorithm = 0x03
+	AlgAES128  Algorithm = 0x08
+	AlgAES192  Algorithm = 0x0a
+	AlgAES256  Algorithm = 0x0c
+	AlgRSA1024 Algorithm = 0x06
+	AlgRSA2048 Algorithm = 0x07
+	AlgRSA3072 Algorithm = 0x05
+	AlgRSA4096 Algorithm = 0x16
+	AlgECCP256 Algorithm = 0x11
+	AlgECCP384 Algorithm = 0x14
+)
+
+func (a Algorithm) String() string {
+	switch a {
+	case Alg3DES:
+		return "3DES"
+	case AlgAES128:
+		return "AES-128"
+	case AlgAES192:
+		return "AES-192"
+	case AlgAES256:
+		return "AES-256"
+	case AlgRSA1024:
+		return "RSA-1024"
+	case AlgRSA2048:
+		return "RSA-2048"
+	case AlgRSA3072:
+		return "RSA-3072"
+	case AlgRSA4096:
+		return "RSA-4096"
+	case AlgECCP256:
+		return "ECC-P256"
+	case AlgECCP384:
+		return "ECC-P384"
+	default:
+		return fmt.Sprintf("unknown(%#02x)", byte(a))
+	}
+}
@@ -0,0 +1,567 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package piv_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des" //nolint:gosec
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"cunicu.li/go-iso7816"
+	"cunicu.li/go-iso7816/encoding/tlv"
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/piv"
+)
+
+// pivCard simulates the management key authentication and key import of a YubiKey.
+type pivCard struct {
+	alg   piv.Algorithm
+	block cipher.Block
+
+	metadata bool
+	witness  []byte
+	authed   bool
+
+	imported []byte // INS | P1 | P2 | data of the last import
+
+	device   []byte                     // Certificate of the attestation key
+	attested map[byte][]byte            // Attestation certificates per slot
+	slots    map[byte][]byte            // Metadata per slot
+	keys     map[byte]*ecdsa.PrivateKey // Keys per slot
+
+	transactions  int // Number of begun transactions
+	inTransaction bool
+	signedInTx    int // Number of signatures within a transaction
+}
+
+func newPIVCard(t *testing.T, alg piv.Algorithm, key []byte) *pivCard {
+	t.Helper()
+
+	var (
+		block cipher.Block
+		err   error
+	)
+
+	if alg == piv.Alg3DES {
+		block, err = des.NewTripleDESCipher(key) //nolint:gosec
+	} else {
+		block, err = aes.NewCipher(key)
+	}
+
+	require.NoError(t, err)
+
+	return &pivCard{
+		alg:      alg,
+		block:    block,
+		metadata: alg != piv.Alg3DES,
+	}
+}
+
+func (c *pivCard) Transmit(cmd []byte) ([]byte, error) {
+	ok := []byte{0x90, 0x00}
+
+	ins, p1, p2 := cmd[1], cmd[2], cmd[3]
+
+	var data []byte
+	if len(cmd) > 5 {
+		if cmd[4] == 0 { // Extended length
+			l := int(cmd[5])<<8 | int(cmd[6])
+			data = cmd[7 : 7+l]
+		} else {
+			data = cmd[5 : 5+int(cmd[4])]
+		}
+	}
+
+	switch ins {
+	case 0xa4: // SELECT
+		return ok, nil
+
+	case 0xf7: // GET METADATA
+		if !c.metadata {
+			return []byte{0x6d, 0x00}, nil
+		}
+
+		if p2 != 0x9b {
+			md, found := c.slots[p2]
+			if !found {
+				return []byte{0x6a, 0x88}, nil
+			}
+
+			return append(md, ok...), nil
+		}
+
+		return []byte{0x01, 0x01, byte(c.alg), 0x90, 0x00}, nil
+
+	case 0x87: // GENERAL AUTHENTICATE
+		if key, found := c.keys[p2]; found {
+			tvs, err := tlv.DecodeBER(data)
+			if err != nil {
+				return []byte{0x6a, 0x80}, nil //nolint:nilerr
+			}
+
+			digest, _, _ := tvs.GetChild(0x7c, 0x81)
+
+			if c.inTransaction {
+				c.signedInTx++
+			}
+
+			sig, err := ecdsa.SignASN1(rand.Reader, key, digest)
+			if err != nil {
+				return nil, err
+			}
+
+			resp, _ := tlv.EncodeBER(tlv.New(0x7c, tlv.New(0x82, sig)))
+			return append(resp, ok...), nil
+		}
+
+		if p1 != byte(c.alg) || p2 != 0x9b {
+			return []byte{0x6a, 0x86}, nil
+		}
+
+		tvs, err := tlv.DecodeBER(data)
+		if err != nil {
+			return []byte{0x6a, 0x80}, nil //nolint:nilerr
+		}
+
+		bs := c.block.BlockSize()
+
+		witness, _, _ := tvs.GetChild(0x7c, 0x80)
+		challenge, _, hasChallenge := tvs.GetChild(0x7c, 0x81)
+
+		if !hasChallenge {
+			c.witness = make([]byte, bs)
+			_, _ = rand.Read(c.witness)
+
+			enc := make([]byte, bs)
+			c.block.Encrypt(enc, c.witness)
+
+			resp, _ := tlv.EncodeBER(tlv.New(0x7c, tlv.New(0x80, enc)))
+			return append(resp, ok...), nil
+		}
+
+		if !bytes.Equal(witness, c.witness) {
+			return []byte{0x69, 0x82}, nil
+		}
+
+		c.authed = true
+
+		enc := make([]byte, bs)
+		c.block.Encrypt(enc, challenge)
+
+		resp, _ := tlv.EncodeBER(tlv.New(0x7c, tlv.New(0x82, enc)))
+		return append(resp, ok...), nil
+
+	case 0xfe: // IMPORT ASYMMETRIC KEY
+		if !c.authed {
+			return []byte{0x69, 0x82}, nil
+		}
+
+		c.imported = append([]byte{ins, p1, p2}, data...)
+
+		return ok, nil
+
+	case 0xf9: // ATTEST
+		cert, found := c.attested[p1]
+		if !found {
+			return []byte{0x6a, 0x80}, nil
+		}
+
+		return append(cert, 0x90, 0x00), nil
+
+	case 0xcb: // GET DATA
+		if !bytes.Equal(data, []byte{0x5c, 0x03, 0x5f, 0xff, 0x01}) || c.device == nil {
+			return []byte{0x6a, 0x82}, nil
+		}
+
+		obj, _ := tlv.EncodeBER(tlv.New(0x70, c.device), tlv.New(0x71, []byte{0x00}))
+		resp, _ := tlv.EncodeBER(tlv.New(0x53, obj))
+		return append(resp, 0x90, 0x00), nil
+	}
+
+	return []byte{0x6d, 0x00}, nil
+}
+
+func (c *pivCard) BeginTransaction() error {
+	c.transactions++
+	c.inTransaction = true
+
+	return nil
+}
+
+func (c *pivCard) EndTransaction() error {
+	c.inTransaction = false
+	return nil
+}
+
+func (c *pivCard) Close() error           { return nil }
+func (c *pivCard) Base() iso7816.PCSCCard { return c }
+
+// decodeImport decodes the flat TLV list of an import command.
+// The policy tags are not valid BER tags as they have the constructed bit set.
+func decodeImport(t *testing.T, b []byte) map[byte][]byte {
+	t.Helper()
+
+	tvs := map[byte][]byte{}
+
+	for len(b) > 0 {
+		require.GreaterOrEqual(t, len(b), 2)
+
+		tag, l := b[0], int(b[1])
+		b = b[2:]
+
+		if l > 0x80 {
+			n := l - 0x80
+			l = 0
+			for _, c := range b[:n] {
+				l = l<<8 | int(c)
+			}
+			b = b[n:]
+		}
+
+		require.GreaterOrEqual(t, len(b), l)
+
+		tvs[tag] = b[:l]
+		b = b[l:]
+	}
+
+	return tvs
+}
+
+func TestAuthenticate(t *testing.T) {
+	require := require.New(t)
+
+	aesKey := bytes.Repeat([]byte{0x42}, 24)
+
+	for _, tc := range []struct {
+		alg piv.Algorithm
+		key []byte
+	}{
+		{piv.Alg3DES, piv.DefaultManagementKey},
+		{piv.AlgAES192, aesKey},
+	} {
+		sc := newPIVCard(t, tc.alg, tc.key)
+
+		c, err := piv.NewCard(sc)
+		require.NoError(err)
+
+		// Imports require authentication
+		sk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(err)
+
+		err = c.ImportKey(piv.SlotAuthentication, sk, piv.Policies{})
+		require.ErrorIs(err, iso7816.ErrSecurityStatusNotSatisfied)
+
+		wrongKey := bytes.Repeat([]byte{0x01}, 24)
+		require.ErrorIs(c.Authenticate(wrongKey), piv.ErrAuthentication)
+		require.False(sc.authed)
+
+		require.NoError(c.Authenticate(tc.key))
+		require.True(sc.authed)
+	}
+}
+
+func TestImportKey(t *testing.T) {
+	require := require.New(t)
+
+	sc := newPIVCard(t, piv.Alg3DES, piv.DefaultManagementKey)
+
+	c, err := piv.NewCard(sc)
+	require.NoError(err)
+
+	require.NoError(c.Authenticate(piv.DefaultManagementKey))
+
+	// EC keys
+	ek, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(err)
+
+	err = c.ImportKey(piv.SlotSignature, ek, piv.Policies{
+		PIN:   piv.PINPolicyAlways,
+		Touch: piv.TouchPolicyCached,
+	})
+	require.NoError(err)
+
+	require.Equal([]byte{0xfe, byte(piv.AlgECCP384), byte(piv.SlotSignature)}, sc.imported[:3])
+
+	tvs := decodeImport(t, sc.imported[3:])
+	require.Equal(map[byte][]byte{
+		0x06: ek.D.FillBytes(make([]byte, 48)),
+		0xaa: {byte(piv.PINPolicyAlways)},
+		0xab: {byte(piv.TouchPolicyCached)},
+	}, tvs)
+
+	// RSA keys
+	rk, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(err)
+
+	err = c.ImportKey(piv.SlotKeyManagement, rk, piv.Policies{})
+	require.NoError(err)
+
+	require.Equal([]byte{0xfe, byte(piv.AlgRSA2048), byte(piv.SlotKeyManagement)}, sc.imported[:3])
+
+	tvs = decodeImport(t, sc.imported[3:])
+	require.Len(tvs, 5)
+	require.Len(tvs[0x01], 128)
+	require.Equal(rk.N, new(big.Int).Mul(new(big.Int).SetBytes(tvs[0x01]), new(big.Int).SetBytes(tvs[0x02])))
+
+	// Unsupported keys
+	err = c.ImportKey(piv.SlotAuthentication, "not a key", piv.Policies{})
+	require.ErrorIs(err, piv.ErrUnsupportedKey)
+
+	ek224, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	require.NoError(err)
+
+	err = c.ImportKey(piv.SlotAuthentication, ek224, piv.Policies{})
+	require.ErrorIs(err, piv.ErrUnsupportedKey)
+}
+
+func TestParse(t *testing.T) {
+	require := require.New(t)
+
+	slot, err := piv.ParseSlot("9a")
+	require.NoError(err)
+	require.Equal(piv.SlotAuthentication, slot)
+
+	slot, err = piv.ParseSlot("0x95")
+	require.NoError(err)
+	require.Equal(piv.SlotRetired20, slot)
+
+	_, err = piv.ParseSlot("9b")
+	require.ErrorIs(err, piv.ErrInvalidSlot)
+
+	pp, err := piv.ParsePINPolicy("once")
+	require.NoError(err)
+	require.Equal(piv.PINPolicyOnce, pp)
+
+	tp, err := piv.ParseTouchPolicy("cached")
+	require.NoError(err)
+	require.Equal(piv.TouchPolicyCached, tp)
+	require.Equal("cached", tp.String())
+
+	_, err = piv.ParseTouchPolicy("sometimes")
+	require.ErrorIs(err, piv.ErrInvalidPolicy)
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	require := require.New(t)
+
+	sk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	der, err := x509.MarshalPKCS8PrivateKey(sk)
+	require.NoError(err)
+
+	key, err := piv.ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	require.NoError(err)
+	require.True(sk.Equal(key))
+
+	der, err = x509.MarshalECPrivateKey(sk)
+	require.NoError(err)
+
+	key, err = piv.ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	require.NoError(err)
+	require.True(sk.Equal(key))
+
+	_, err = piv.ParsePrivateKey([]byte("not a key"))
+	require.ErrorIs(err, piv.ErrUnsupportedKey)
+
+	_, err = piv.ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	require.ErrorIs(err, piv.ErrUnsupportedKey)
+}
+
+func TestCheckImport(t *testing.T) {
+	require := require.New(t)
+
+	ek, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	alg, err := piv.CheckImport(ek, piv.Policies{Touch: piv.TouchPolicyAlways})
+	require.NoError(err)
+	require.Equal(piv.AlgECCP256, alg)
+	require.Equal("ECC-P256", alg.String())
+
+	_, err = piv.CheckImport(ek, piv.Policies{PIN: piv.PINPolicy(7)})
+	require.ErrorIs(err, piv.ErrInvalidPolicy)
+
+	_, err = piv.CheckImport("not a key", piv.Policies{})
+	require.ErrorIs(err, piv.ErrUnsupportedKey)
+}
+
+// newAttestation issues a root CA, the certificate of the attestation key
+// and an attestation certificate for a key like the Yubico PKI.
+func newAttestation(t *testing.T, serial int64) (roots *x509.CertPool, device, slot []byte, key *ecdsa.PrivateKey) {
+	t.Helper()
+
+	require := require.New(t)
+
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	rootTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test PIV Root CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	rootDER, err := x509.CreateCertificate(rand.Reader, rootTmpl, rootTmpl, &rootKey.PublicKey, rootKey)
+	require.NoError(err)
+
+	root, err := x509.ParseCertificate(rootDER)
+	require.NoError(err)
+
+	roots = x509.NewCertPool()
+	roots.AddCert(root)
+
+	// The attestation certificates of older firmware lack basic constraints
+	deviceKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	deviceTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "Test PIV Attestation"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	device, err = x509.CreateCertificate(rand.Reader, deviceTmpl, root, &deviceKey.PublicKey, rootKey)
+	require.NoError(err)
+
+	deviceCert, err := x509.ParseCertificate(device)
+	require.NoError(err)
+
+	serialExt, err := asn1.Marshal(serial)
+	require.NoError(err)
+
+	key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	slotTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "YubiKey PIV Attestation 9a"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{
+			{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 41482, 3, 3}, Value: []byte{5, 7, 1}},
+			{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 41482, 3, 7}, Value: serialExt},
+			{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 41482, 3, 8}, Value: []byte{byte(piv.PINPolicyOnce), byte(piv.TouchPolicyAlways)}},
+			{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 41482, 3, 9}, Value: []byte{0x03}},
+		},
+	}
+
+	slot, err = x509.CreateCertificate(rand.Reader, slotTmpl, deviceCert, &key.PublicKey, deviceKey)
+	require.NoError(err)
+
+	return roots, device, slot, key
+}
+
+func TestAttest(t *testing.T) {
+	require := require.New(t)
+
+	roots, device, slot, key := newAttestation(t, 12345678)
+
+	sc := newPIVCard(t, piv.AlgAES192, make([]byte, 24))
+	sc.device = device
+	sc.attested = map[byte][]byte{0x9a: slot}
+	sc.keys = map[byte]*ecdsa.PrivateKey{0x9a: key}
+	sc.slots = map[byte][]byte{
+		0x9a: {0x01, 0x01, byte(piv.AlgECCP256), 0x02, 0x02, byte(piv.PINPolicyOnce), byte(piv.TouchPolicyAlways), 0x03, 0x01, 0x01},
+		0x9c: {0x01, 0x01, byte(piv.AlgRSA2048), 0x02, 0x02, byte(piv.PINPolicyAlways), byte(piv.TouchPolicyNever), 0x03, 0x01, 0x02},
+	}
+
+	c, err := piv.NewCard(sc)
+	require.NoError(err)
+
+	md, err := c.Metadata(piv.SlotAuthentication)
+	require.NoError(err)
+	require.Equal(&piv.Metadata{
+		Algorithm: piv.AlgECCP256,
+		Policies:  piv.Policies{PIN: piv.PINPolicyOnce, Touch: piv.TouchPolicyAlways},
+		Generated: true,
+	}, md)
+
+	md, err = c.Metadata(piv.SlotSignature)
+	require.NoError(err)
+	require.False(md.Generated)
+
+	_, err = c.Metadata(piv.SlotKeyManagement)
+	require.ErrorIs(err, iso7816.ErrReferenceNotFound)
+
+	deviceCert, err := c.AttestationCertificate()
+	require.NoError(err)
+	require.Equal(device, deviceCert.Raw)
+
+	slotCert, err := c.Attest(piv.SlotAuthentication)
+	require.NoError(err)
+
+	a, err := piv.VerifyAttestation(deviceCert, slotCert, piv.VerifyOptions{Roots: roots})
+	require.NoError(err)
+	require.Equal(uint32(12345678), a.Serial)
+	require.Equal([]byte{5, 7, 1}, a.Firmware)
+	require.Equal(byte(0x03), a.FormFactor)
+	require.Equal(piv.Policies{PIN: piv.PINPolicyOnce, Touch: piv.TouchPolicyAlways}, a.Policies)
+	require.Equal(slotCert.PublicKey, a.PublicKey)
+
+	// Imported keys can not be attested
+	_, err = c.Attest(piv.SlotSignature)
+	require.Error(err)
+
+	// Attested keys prove their possession by signatures
+	digest := []byte("0123456789abcdef0123456789abcdef")
+
+	sig, err := c.SignECDSA(piv.SlotAuthentication, piv.AlgECCP256, digest)
+	require.NoError(err)
+	require.True(ecdsa.VerifyASN1(&key.PublicKey, digest, sig))
+
+	_, err = c.SignECDSA(piv.SlotAuthentication, piv.AlgRSA2048, digest)
+	require.ErrorIs(err, piv.ErrUnsupportedKey)
+
+	// Batches are signed within a single transaction
+	digests := [][]byte{digest, []byte("fedcba9876543210fedcba9876543210")}
+
+	sigs, err := c.SignECDSABatch(piv.SlotAuthentication, piv.AlgECCP256, digests)
+	require.NoError(err)
+	require.Len(sigs, 2)
+	require.Equal(1, sc.transactions)
+	require.Equal(2, sc.signedInTx)
+	require.False(sc.inTransaction)
+
+	for i, digest := range digests {
+		require.True(ecdsa.VerifyASN1(&key.PublicKey, digest, sigs[i]))
+	}
+
+	_, err = c.SignECDSABatch(piv.SlotAuthentication, piv.AlgRSA2048, digests)
+	require.ErrorIs(err, piv.ErrUnsupportedKey)
+	require.False(sc.inTransaction)
+
+	// Attestations of other roots are rejected
+	otherRoots, otherDevice, _, _ := newAttestation(t, 1)
+
+	_, err = piv.VerifyAttestation(deviceCert, slotCert, piv.VerifyOptions{Roots: otherRoots})
+	require.ErrorIs(err, piv.ErrInvalidAttestation)
+
+	otherDeviceCert, err := x509.ParseCertificate(otherDevice)
+	require.NoError(err)
+
+	_, err = piv.VerifyAttestation(otherDeviceCert, slotCert, piv.VerifyOptions{Roots: otherRoots})
+	require.ErrorIs(err, piv.ErrInvalidAttestation)
+
+	_, err = piv.VerifyAttestation(deviceCert, slotCert, piv.VerifyOptions{})
+	require.ErrorIs(err, piv.ErrInvalidAttestation)
+}
@@ -1,20 +1,17 @@
 // SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
 // SPDX-License-Identifier: Apache-2.0
 
-//go:build darwin
+//go:build darwin && cgo
 
 package provider
 
 import (
 	"bytes"
-	"errors"
 	"os"
 
 	se "cunicu.li/hawkes/ecdh/applese"
 )
 
-var ErrKeyNotFound = errors.New("key not found")
-
 var _ PrivateKeyDH = (*appleSecureEnclaveKey)(nil)
 
 type appleSecureEnclaveKey struct {
@@ -42,6 +39,14 @@ func newAppleSecureEnclaveProvider() (Provider, error) {
 	return &appleSecureEnclaveProvider{}, nil
 }
 
+func (p *appleSecureEnclaveProvider) Capabilities() Capabilities {
+	return Capabilities{
+		KeyTypes: []KeyType{KeyTypeECP256},
+		DH:       true,
+		Hardware: true,
+	}
+}
+
 func (p *appleSecureEnclaveProvider) Keys() (keyIDs []KeyID, err error) {
 	keys, err := se.Keys(nil)
 	if err != nil {
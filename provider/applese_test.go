@@ -1,7 +1,7 @@
 // SPDX-FileCopyrightText: 2023 Steffen Vogel
 // SPDX-License-Identifier: Apache-2.0
 
-//go:build darwin
+//go:build darwin && cgo
 
 package provider
 
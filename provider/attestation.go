@@ -0,0 +1,329 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"cunicu.li/go-iso7816"
+	"github.com/katzenpost/nyquist/dh"
+
+	"cunicu.li/hawkes/oath"
+	"cunicu.li/hawkes/piv"
+	"cunicu.li/hawkes/yubikey"
+)
+
+var (
+	ErrNotAttested       = errors.New("key can not be attested")
+	ErrAttestationPolicy = errors.New("attestation does not satisfy policy")
+)
+
+// Attestation describes the hardware which holds a key.
+// Model, firmware and serial are only trustworthy after
+// the attestation has been verified (see Attestation.Verify).
+type Attestation struct {
+	// Model identifies the token, e.g. "YubiKey USB-C Keychain".
+	Model string `json:"model"`
+
+	// Firmware is the version of the token firmware.
+	Firmware iso7816.Version `json:"firmware"`
+
+	// Serial is the serial number of the token.
+	Serial uint32 `json:"serial,omitempty"`
+
+	// TouchPolicy decides when the token must be touched to use the key.
+	// It is reported by the token within the session in which
+	// it proved the possession of the attested key.
+	TouchPolicy Policy `json:"touch_policy"`
+
+	// Certificates are the DER encoded certificate of a key generated on the token
+	// followed by the certificate of the attestation key of the token which issued it.
+	Certificates [][]byte `json:"certificates,omitempty"`
+}
+
+// Verify checks the certificates of the attestation against the trust anchors and
+// returns the attestation with the model, firmware and serial taken from the certificates.
+func (a *Attestation) Verify(opts piv.VerifyOptions) (*Attestation, error) {
+	if len(a.Certificates) != 2 {
+		return nil, fmt.Errorf("%w: missing certificates", ErrNotAttested)
+	}
+
+	slot, err := x509.ParseCertificate(a.Certificates[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotAttested, err)
+	}
+
+	device, err := x509.ParseCertificate(a.Certificates[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotAttested, err)
+	}
+
+	pa, err := piv.VerifyAttestation(device, slot, opts)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotAttested, err)
+	}
+
+	// The upper bits of the form factor flag FIPS and Security Key series
+	v := &Attestation{
+		Model:        "YubiKey " + yubikey.FormFactor(pa.FormFactor&0x0f).String(),
+		Serial:       pa.Serial,
+		TouchPolicy:  a.TouchPolicy,
+		Certificates: a.Certificates,
+	}
+
+	if len(pa.Firmware) == 3 {
+		v.Firmware = iso7816.Version{
+			Major: int(pa.Firmware[0]),
+			Minor: int(pa.Firmware[1]),
+			Patch: int(pa.Firmware[2]),
+		}
+	}
+
+	return v, nil
+}
+
+// PrivateKeyAttester is implemented by keys whose provider
+// can attest the properties of the hardware holding them.
+type PrivateKeyAttester interface {
+	PrivateKey
+
+	// Attest returns the attested properties of the hardware holding the key.
+	Attest() (*Attestation, error)
+}
+
+// AttestationPolicy constrains the hardware which may unwrap key material.
+// Empty fields do not constrain the attestation.
+type AttestationPolicy struct {
+	// Anchors are the trust anchors against which attestations are verified.
+	// Keys are refused if no roots are given.
+	Anchors piv.VerifyOptions
+
+	// Models is a list of patterns of which one must match the attested model.
+	Models []*regexp.Regexp
+
+	// MinFirmware is the lowest accepted firmware version.
+	MinFirmware *iso7816.Version
+
+	// TouchPolicy is the weakest accepted touch policy.
+	// PolicyOnce is satisfied by PolicyAlways.
+	TouchPolicy Policy
+}
+
+// Check returns an error if the verified attestation violates the policy.
+func (p *AttestationPolicy) Check(a *Attestation) error {
+	if len(p.Models) > 0 {
+		matched := false
+		for _, m := range p.Models {
+			if m.MatchString(a.Model) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return fmt.Errorf("%w: model %q is not allowed", ErrAttestationPolicy, a.Model)
+		}
+	}
+
+	if p.MinFirmware != nil && versionBefore(a.Firmware, *p.MinFirmware) {
+		return fmt.Errorf("%w: firmware %s is older than %s", ErrAttestationPolicy, a.Firmware, p.MinFirmware)
+	}
+
+	if policyStrength(a.TouchPolicy) < policyStrength(p.TouchPolicy) {
+		return fmt.Errorf("%w: touch policy %q is weaker than %q", ErrAttestationPolicy, a.TouchPolicy, p.TouchPolicy)
+	}
+
+	return nil
+}
+
+// CheckKey attests a key, verifies the attestation against the trust anchors
+// and checks it against the policy.
+// Keys whose attestation can not be verified are refused.
+func (p *AttestationPolicy) CheckKey(key PrivateKey) error {
+	a, err := attest(key, p.Anchors)
+	if err != nil {
+		return err
+	}
+
+	return p.Check(a)
+}
+
+// attest returns the verified attestation of a key.
+func attest(key PrivateKey, opts piv.VerifyOptions) (*Attestation, error) {
+	ak, ok := key.(PrivateKeyAttester)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrNotAttested, key)
+	}
+
+	a, err := ak.Attest()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotAttested, err)
+	}
+
+	return a.Verify(opts)
+}
+
+// RequireAttestation wraps a Diffie-Hellman or HMAC key so that the key agreements
+// and HMAC calculations used to unwrap key material are refused unless the
+// attestation of the key satisfies the policy.
+// The attestation is checked before each operation as properties like
+// the touch policy may change while the key is open.
+// Keys which can neither agree on keys nor calculate HMACs are returned unchanged.
+func RequireAttestation(key PrivateKey, policy *AttestationPolicy) PrivateKey {
+	return requireAttestation(key, policy, nil)
+}
+
+// requireAttestation is like RequireAttestation but
+// calls refused for operations refused by the policy.
+func requireAttestation(key PrivateKey, policy *AttestationPolicy, refused func(error)) PrivateKey {
+	ak := &attestedKey{
+		PrivateKey: key,
+		policy:     policy,
+		refused:    refused,
+	}
+
+	_, isHMAC := key.(PrivateKeyHMAC)
+	_, isDH := key.(PrivateKeyDH)
+
+	switch {
+	case isHMAC && isDH:
+		return &attestedDHHMACKey{ak}
+	case isHMAC:
+		return &attestedHMACKey{ak}
+	case isDH:
+		return &attestedDHKey{ak}
+	default:
+		return key
+	}
+}
+
+type attestedKey struct {
+	PrivateKey
+
+	policy  *AttestationPolicy
+	refused func(error)
+}
+
+func (k *attestedKey) check() error {
+	err := k.policy.CheckKey(k.PrivateKey)
+	if err != nil && k.refused != nil {
+		k.refused(err)
+	}
+
+	return err
+}
+
+func (k *attestedKey) Attest() (*Attestation, error) {
+	ak, ok := k.PrivateKey.(PrivateKeyAttester)
+	if !ok {
+		return nil, ErrNotAttested
+	}
+
+	return ak.Attest()
+}
+
+// Signer returns the signer if the underlying key supports signing.
+// Signatures are not restricted by the policy.
+func (k *attestedKey) Signer() (crypto.Signer, error) {
+	sk, ok := k.PrivateKey.(PrivateKeySigner)
+	if !ok {
+		return nil, ErrUnsupportedKeyType
+	}
+
+	return sk.Signer()
+}
+
+// SignBatch signs the digests if the underlying key supports signing.
+// Signatures are not restricted by the policy.
+func (k *attestedKey) SignBatch(ctx context.Context, digests [][]byte, opts crypto.SignerOpts) ([][]byte, error) {
+	return SignBatch(ctx, k.PrivateKey, digests, opts)
+}
+
+// Credential exports the key as OATH credential if the attestation satisfies the policy.
+func (k *attestedKey) Credential() (*oath.Credential, error) {
+	ek, ok := k.PrivateKey.(PrivateKeyExportable)
+	if !ok {
+		return nil, ErrNotExportable
+	}
+
+	if err := k.check(); err != nil {
+		return nil, err
+	}
+
+	return ek.Credential()
+}
+
+func (k *attestedKey) hmac(challenge []byte) ([]byte, error) {
+	if err := k.check(); err != nil {
+		return nil, err
+	}
+
+	return k.PrivateKey.(PrivateKeyHMAC).HMAC(challenge) //nolint:forcetypeassert
+}
+
+// HMACBatch calculates the HMACs after a single check of the attestation.
+// It is only exposed by keys which calculate HMACs (see PrivateKeyBatchHMAC).
+func (k *attestedKey) HMACBatch(ctx context.Context, challenges [][]byte) ([][]byte, error) {
+	if err := k.check(); err != nil {
+		return nil, err
+	}
+
+	return HMACKeyBatch(ctx, k.PrivateKey, challenges)
+}
+
+func (k *attestedKey) dh(pk dh.PublicKey) ([]byte, error) {
+	if err := k.check(); err != nil {
+		return nil, err
+	}
+
+	return k.PrivateKey.(PrivateKeyDH).DH(pk) //nolint:forcetypeassert
+}
+
+func (k *attestedKey) public() dh.PublicKey {
+	return k.PrivateKey.(PrivateKeyDH).Public() //nolint:forcetypeassert
+}
+
+type attestedHMACKey struct{ *attestedKey }
+
+func (k *attestedHMACKey) HMAC(challenge []byte) ([]byte, error) { return k.hmac(challenge) }
+
+type attestedDHKey struct{ *attestedKey }
+
+func (k *attestedDHKey) DH(pk dh.PublicKey) ([]byte, error) { return k.dh(pk) }
+func (k *attestedDHKey) Public() dh.PublicKey               { return k.public() }
+
+type attestedDHHMACKey struct{ *attestedKey }
+
+func (k *attestedDHHMACKey) HMAC(challenge []byte) ([]byte, error) { return k.hmac(challenge) }
+func (k *attestedDHHMACKey) DH(pk dh.PublicKey) ([]byte, error)    { return k.dh(pk) }
+func (k *attestedDHHMACKey) Public() dh.PublicKey                  { return k.public() }
+
+// policyStrength orders policies by the number of confirmations they require.
+func policyStrength(p Policy) int {
+	switch p {
+	case PolicyOnce:
+		return 1
+	case PolicyAlways:
+		return 2
+	default:
+		return 0
+	}
+}
+
+func versionBefore(v, w iso7816.Version) bool {
+	if v.Major != w.Major {
+		return v.Major < w.Major
+	}
+
+	if v.Minor != w.Minor {
+		return v.Minor < w.Minor
+	}
+
+	return v.Patch < w.Patch
+}
@@ -0,0 +1,295 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"regexp"
+	"testing"
+	"time"
+
+	"cunicu.li/go-iso7816"
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/event"
+	"cunicu.li/hawkes/piv"
+)
+
+// attestedFileKey attests a software key as if it was held by a token.
+type attestedFileKey struct {
+	PrivateKeyHMAC
+
+	attestation *Attestation
+}
+
+func (k *attestedFileKey) Attest() (*Attestation, error) {
+	return k.attestation, nil
+}
+
+// newAttestation issues the certificates of an attested key
+// like a YubiKey 5C with firmware 5.7.1 would.
+func newAttestation(t *testing.T, touch Policy) (*Attestation, piv.VerifyOptions) {
+	require := require.New(t)
+
+	newKey := func() *ecdsa.PrivateKey {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(err)
+
+		return key
+	}
+
+	rootKey, deviceKey, slotKey := newKey(), newKey(), newKey()
+
+	rootTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test PIV Root CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	root, err := x509.CreateCertificate(rand.Reader, rootTmpl, rootTmpl, &rootKey.PublicKey, rootKey)
+	require.NoError(err)
+
+	deviceTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "Yubico PIV Attestation"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	device, err := x509.CreateCertificate(rand.Reader, deviceTmpl, rootTmpl, &deviceKey.PublicKey, rootKey)
+	require.NoError(err)
+
+	serial, err := asn1.Marshal(12345678)
+	require.NoError(err)
+
+	slotTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "YubiKey PIV Attestation 9e"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{
+			{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 41482, 3, 3}, Value: []byte{5, 7, 1}},
+			{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 41482, 3, 7}, Value: serial},
+			{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 41482, 3, 9}, Value: []byte{0x03}},
+		},
+	}
+
+	slot, err := x509.CreateCertificate(rand.Reader, slotTmpl, deviceTmpl, &slotKey.PublicKey, deviceKey)
+	require.NoError(err)
+
+	rootCert, err := x509.ParseCertificate(root)
+	require.NoError(err)
+
+	opts := piv.VerifyOptions{
+		Roots: x509.NewCertPool(),
+	}
+	opts.Roots.AddCert(rootCert)
+
+	return &Attestation{
+		Model:        "Yubico YubiKey OTP+FIDO+CCID 00 00",
+		TouchPolicy:  touch,
+		Certificates: [][]byte{slot, device},
+	}, opts
+}
+
+func TestAttestationVerify(t *testing.T) {
+	require := require.New(t)
+
+	a, opts := newAttestation(t, PolicyAlways)
+
+	v, err := a.Verify(opts)
+	require.NoError(err)
+	require.Equal("YubiKey USB-C Keychain", v.Model)
+	require.Equal(iso7816.Version{Major: 5, Minor: 7, Patch: 1}, v.Firmware)
+	require.Equal(uint32(12345678), v.Serial)
+	require.Equal(PolicyAlways, v.TouchPolicy)
+
+	// Self-reported properties are not trusted
+	_, err = (&Attestation{Model: "YubiKey USB-C Keychain"}).Verify(opts)
+	require.ErrorIs(err, ErrNotAttested)
+
+	_, err = a.Verify(piv.VerifyOptions{})
+	require.ErrorIs(err, ErrNotAttested)
+
+	_, otherOpts := newAttestation(t, PolicyAlways)
+	_, err = a.Verify(otherOpts)
+	require.ErrorIs(err, ErrNotAttested)
+}
+
+func TestAttestationPolicy(t *testing.T) {
+	require := require.New(t)
+
+	p := &AttestationPolicy{
+		Models:      []*regexp.Regexp{regexp.MustCompile("^Yubico YubiKey")},
+		MinFirmware: &iso7816.Version{Major: 5, Minor: 7, Patch: -1},
+		TouchPolicy: PolicyOnce,
+	}
+
+	a := &Attestation{
+		Model:       "Yubico YubiKey OTP+FIDO+CCID 00 00",
+		Firmware:    iso7816.Version{Major: 5, Minor: 7, Patch: 1},
+		TouchPolicy: PolicyAlways,
+	}
+
+	require.NoError(p.Check(a))
+
+	for _, mod := range []func(a *Attestation){
+		func(a *Attestation) { a.Model = "Nitrokey 3" },
+		func(a *Attestation) { a.Firmware = iso7816.Version{Major: 5, Minor: 4, Patch: 3} },
+		func(a *Attestation) { a.TouchPolicy = PolicyNever },
+	} {
+		b := *a
+		mod(&b)
+
+		require.ErrorIs(p.Check(&b), ErrAttestationPolicy)
+	}
+
+	require.NoError((&AttestationPolicy{}).Check(&Attestation{}))
+}
+
+func TestRequireAttestation(t *testing.T) {
+	require := require.New(t)
+
+	p, err := newFileProvider()
+	require.NoError(err)
+
+	id, err := p.CreateKey("unwrap")
+	require.NoError(err)
+
+	defer func() {
+		err := p.DestroyKey(id)
+		require.NoError(err)
+	}()
+
+	key, err := p.OpenKey(id)
+	require.NoError(err)
+
+	attestation, anchors := newAttestation(t, PolicyNever)
+
+	policy := &AttestationPolicy{
+		Anchors:     anchors,
+		TouchPolicy: PolicyAlways,
+	}
+
+	// Software keys can not be attested
+	gk, ok := RequireAttestation(key, policy).(PrivateKeyHMAC)
+	require.True(ok)
+
+	_, err = gk.HMAC([]byte("challenge"))
+	require.ErrorIs(err, ErrNotAttested)
+
+	_, err = gk.(PrivateKeyExportable).Credential() //nolint:forcetypeassert
+	require.ErrorIs(err, ErrNotAttested)
+
+	// Signatures are not restricted
+	_, err = gk.(PrivateKeySigner).Signer() //nolint:forcetypeassert
+	require.NoError(err)
+
+	ak := &attestedFileKey{
+		PrivateKeyHMAC: key.(PrivateKeyHMAC), //nolint:forcetypeassert
+		attestation:    attestation,
+	}
+
+	gk, ok = RequireAttestation(ak, policy).(PrivateKeyHMAC)
+	require.True(ok)
+
+	_, err = gk.HMAC([]byte("challenge"))
+	require.ErrorIs(err, ErrAttestationPolicy)
+
+	// Attestations which can not be verified are refused
+	untrusted := &AttestationPolicy{}
+
+	gk2, ok := RequireAttestation(ak, untrusted).(PrivateKeyHMAC)
+	require.True(ok)
+
+	_, err = gk2.HMAC([]byte("challenge"))
+	require.ErrorIs(err, ErrNotAttested)
+
+	// The attestation is checked again for each operation
+	ak.attestation.TouchPolicy = PolicyAlways
+
+	expected, err := key.(PrivateKeyHMAC).HMAC([]byte("challenge")) //nolint:forcetypeassert
+	require.NoError(err)
+
+	resp, err := gk.HMAC([]byte("challenge"))
+	require.NoError(err)
+	require.Equal(expected, resp)
+}
+
+// attestedProvider opens its keys as if they were held by a token.
+type attestedProvider struct {
+	Provider
+
+	attestation *Attestation
+}
+
+func (p *attestedProvider) OpenKey(id KeyID) (PrivateKey, error) {
+	key, err := p.Provider.OpenKey(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &attestedFileKey{
+		PrivateKeyHMAC: key.(PrivateKeyHMAC), //nolint:forcetypeassert
+		attestation:    p.attestation,
+	}, nil
+}
+
+func TestAttestationMismatchEvent(t *testing.T) {
+	require := require.New(t)
+
+	fp, err := newFileProvider()
+	require.NoError(err)
+
+	id, err := fp.CreateKey("mismatch")
+	require.NoError(err)
+
+	defer func() {
+		err := fp.DestroyKey(id)
+		require.NoError(err)
+	}()
+
+	bus := event.NewBus()
+	sub := bus.Subscribe(0, event.AttestationMismatch)
+
+	attestation, anchors := newAttestation(t, PolicyNever)
+
+	p := &MultiProvider{
+		cfg: MultiProviderConfig{
+			UnwrapPolicy: &AttestationPolicy{
+				Anchors:     anchors,
+				TouchPolicy: PolicyAlways,
+			},
+			Events: bus,
+		},
+		providers: []Provider{
+			&attestedProvider{
+				Provider:    fp,
+				attestation: attestation,
+			},
+		},
+		names: []string{"YKOATH"},
+	}
+
+	key, err := p.OpenKey(id)
+	require.NoError(err)
+
+	_, err = key.(PrivateKeyHMAC).HMAC([]byte("challenge")) //nolint:forcetypeassert
+	require.ErrorIs(err, ErrAttestationPolicy)
+
+	e := <-sub.C()
+	require.Equal(event.AttestationMismatch, e.Type)
+	require.Equal(id.String(), e.Key)
+	require.Contains(e.Error, "touch policy")
+}
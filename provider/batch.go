@@ -0,0 +1,195 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidDigest = errors.New("invalid digest")
+
+// HMACBatch groups HMAC operations which are executed together by Run.
+//
+// Providers backed by smart cards execute all operations of a batch
+// within a single card operation and use multi-result instructions
+// where possible to minimize the number of APDU round trips.
+type HMACBatch interface {
+	// HMAC queues the calculation of a HMAC over the challenge with the given key.
+	HMAC(id KeyID, challenge []byte) HMACBatch
+
+	// Run executes all queued operations and returns their results in order.
+	Run(ctx context.Context) ([][]byte, error)
+}
+
+// BatchProvider is implemented by providers which natively support batched operations.
+type BatchProvider interface {
+	Provider
+
+	BatchHMAC() HMACBatch
+}
+
+// NewHMACBatch returns a new batch of HMAC operations for the provider.
+// Operations are executed one after another if the provider
+// does not implement BatchProvider.
+func NewHMACBatch(p Provider) HMACBatch {
+	if bp, ok := p.(BatchProvider); ok {
+		return bp.BatchHMAC()
+	}
+
+	return &sequentialHMACBatch{
+		provider: p,
+	}
+}
+
+type hmacOp struct {
+	id        KeyID
+	challenge []byte
+}
+
+type sequentialHMACBatch struct {
+	provider Provider
+	ops      []hmacOp
+}
+
+func (b *sequentialHMACBatch) HMAC(id KeyID, challenge []byte) HMACBatch {
+	b.ops = append(b.ops, hmacOp{id, challenge})
+	return b
+}
+
+func (b *sequentialHMACBatch) Run(ctx context.Context) ([][]byte, error) {
+	results := make([][]byte, len(b.ops))
+
+	for i, op := range b.ops {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		key, err := b.provider.OpenKey(op.id)
+		if err != nil {
+			return nil, err
+		}
+
+		hmacKey, ok := key.(PrivateKeyHMAC)
+		if !ok {
+			key.Close()
+			return nil, fmt.Errorf("%w: %s is not a HMAC key", ErrUnsupportedKeyType, op.id)
+		}
+
+		results[i], err = hmacKey.HMAC(op.challenge)
+		key.Close()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// PrivateKeyBatchSigner is implemented by keys which sign multiple digests
+// within a single authenticated session or transaction, so that the whole
+// batch requires only one PIN entry, touch or confirmation.
+type PrivateKeyBatchSigner interface {
+	PrivateKey
+
+	// SignBatch signs the digests with the same options
+	// as crypto.Signer.Sign and returns the signatures in order.
+	SignBatch(ctx context.Context, digests [][]byte, opts crypto.SignerOpts) ([][]byte, error)
+}
+
+// PrivateKeyBatchHMAC is implemented by keys which calculate multiple HMACs
+// within a single card transaction.
+type PrivateKeyBatchHMAC interface {
+	PrivateKeyHMAC
+
+	// HMACBatch calculates the HMACs over the challenges and returns them in order.
+	HMACBatch(ctx context.Context, challenges [][]byte) ([][]byte, error)
+}
+
+// SignBatch signs the digests with the key.
+// All digests must have the size of the hash function of the options.
+// Digests are signed one after another if the key
+// does not implement PrivateKeyBatchSigner.
+func SignBatch(ctx context.Context, key PrivateKey, digests [][]byte, opts crypto.SignerOpts) ([][]byte, error) {
+	if err := checkDigests(digests, opts); err != nil {
+		return nil, err
+	}
+
+	if bk, ok := key.(PrivateKeyBatchSigner); ok {
+		return bk.SignBatch(ctx, digests, opts)
+	}
+
+	sk, ok := key.(PrivateKeySigner)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is not a signing key", ErrUnsupportedKeyType, key.ID())
+	}
+
+	signer, err := sk.Signer()
+	if err != nil {
+		return nil, err
+	}
+
+	sigs := make([][]byte, len(digests))
+
+	for i, digest := range digests {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if sigs[i], err = signer.Sign(rand.Reader, digest, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	return sigs, nil
+}
+
+// HMACKeyBatch calculates the HMACs over the challenges with the key.
+// They are calculated one after another if the key
+// does not implement PrivateKeyBatchHMAC.
+func HMACKeyBatch(ctx context.Context, key PrivateKey, challenges [][]byte) ([][]byte, error) {
+	if bk, ok := key.(PrivateKeyBatchHMAC); ok {
+		return bk.HMACBatch(ctx, challenges)
+	}
+
+	hk, ok := key.(PrivateKeyHMAC)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is not a HMAC key", ErrUnsupportedKeyType, key.ID())
+	}
+
+	results := make([][]byte, len(challenges))
+
+	for i, challenge := range challenges {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		var err error
+		if results[i], err = hk.HMAC(challenge); err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// checkDigests checks the digests against the size of the hash function.
+func checkDigests(digests [][]byte, opts crypto.SignerOpts) error {
+	hash := opts.HashFunc()
+	if hash == 0 {
+		return nil
+	}
+
+	for _, digest := range digests {
+		if len(digest) != hash.Size() {
+			return fmt.Errorf("%w: %s digests have %d bytes, not %d", ErrInvalidDigest, hash, hash.Size(), len(digest))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,201 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/timesync"
+)
+
+func TestSequentialHMACBatch(t *testing.T) {
+	require := require.New(t)
+
+	p, err := newFileProvider()
+	require.NoError(err)
+
+	fp, ok := p.(*fileProvider)
+	require.True(ok)
+
+	secret, err := generateSecret()
+	require.NoError(err)
+
+	id, err := fp.CreateKeyFromSecret("batch", secret)
+	require.NoError(err)
+
+	defer func() {
+		err := p.DestroyKey(id)
+		require.NoError(err)
+	}()
+
+	key, err := p.OpenKey(id)
+	require.NoError(err)
+
+	hmacKey, ok := key.(PrivateKeyHMAC)
+	require.True(ok)
+
+	expected1, err := hmacKey.HMAC([]byte("a"))
+	require.NoError(err)
+
+	expected2, err := hmacKey.HMAC([]byte("b"))
+	require.NoError(err)
+
+	results, err := NewHMACBatch(p).
+		HMAC(id, []byte("a")).
+		HMAC(id, []byte("b")).
+		Run(context.Background())
+	require.NoError(err)
+	require.Equal([][]byte{expected1, expected2}, results)
+
+	_, err = NewHMACBatch(p).
+		HMAC(KeyID("unknown"), []byte("a")).
+		Run(context.Background())
+	require.Error(err)
+}
+
+func TestSignBatch(t *testing.T) {
+	require := require.New(t)
+
+	p := &fileProvider{keyDir: t.TempDir()}
+
+	id, err := p.CreateKey("batch")
+	require.NoError(err)
+
+	key, err := p.OpenKey(id)
+	require.NoError(err)
+
+	defer key.Close()
+
+	d1 := sha256.Sum256([]byte("manifest 1"))
+	d2 := sha256.Sum256([]byte("manifest 2"))
+	digests := [][]byte{d1[:], d2[:]}
+
+	now := time.Unix(1700000000, 0)
+	policy := &UsagePolicy{
+		MaxPerMinute: 3,
+		Confirm:      []string{OperationSign},
+		Clock:        timesync.ClockFunc(func() time.Time { return now }),
+	}
+
+	confirmations := 0
+	lk := LimitUsage(key, policy, func(string, KeyID) error {
+		confirmations++
+		return nil
+	})
+
+	// The whole batch is confirmed once
+	sigs, err := SignBatch(context.Background(), lk, digests, crypto.SHA256)
+	require.NoError(err)
+	require.Len(sigs, 2)
+	require.Equal(1, confirmations)
+
+	signer, err := key.(PrivateKeySigner).Signer() //nolint:forcetypeassert
+	require.NoError(err)
+
+	pub, ok := signer.Public().(*ecdsa.PublicKey)
+	require.True(ok)
+
+	for i, digest := range digests {
+		require.True(ecdsa.VerifyASN1(pub, digest, sigs[i]))
+	}
+
+	// Each signature counts towards the rate limit and
+	// batches exceeding it are refused before asking for a confirmation
+	_, err = SignBatch(context.Background(), lk, digests, crypto.SHA256)
+	require.ErrorIs(err, ErrRateLimited)
+	require.Equal(1, confirmations)
+
+	// Digests must match the hash function of the options
+	_, err = SignBatch(context.Background(), key, [][]byte{[]byte("short")}, crypto.SHA256)
+	require.ErrorIs(err, ErrInvalidDigest)
+
+	// Invalid batches are neither confirmed nor accounted
+	now = now.Add(time.Minute)
+
+	_, err = lk.(PrivateKeyBatchSigner).SignBatch(context.Background(), [][]byte{[]byte("short"), d1[:]}, crypto.SHA256) //nolint:forcetypeassert
+	require.ErrorIs(err, ErrInvalidDigest)
+	require.Equal(1, confirmations)
+
+	_, err = SignBatch(context.Background(), lk, [][]byte{d1[:], d2[:], d1[:]}, crypto.SHA256)
+	require.NoError(err)
+	require.Equal(2, confirmations)
+
+	d3 := sha512.Sum384([]byte("manifest 3"))
+
+	_, err = SignBatch(context.Background(), key, [][]byte{d1[:], d3[:]}, crypto.SHA256)
+	require.ErrorIs(err, ErrInvalidDigest)
+
+	sigs, err = SignBatch(context.Background(), key, [][]byte{d3[:]}, crypto.SHA384)
+	require.NoError(err)
+	require.True(ecdsa.VerifyASN1(pub, d3[:], sigs[0]))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = SignBatch(ctx, key, digests, crypto.SHA256)
+	require.ErrorIs(err, context.Canceled)
+}
+
+func TestHMACKeyBatch(t *testing.T) {
+	require := require.New(t)
+
+	p := &fileProvider{keyDir: t.TempDir()}
+
+	id, err := p.CreateKey("batch")
+	require.NoError(err)
+
+	key, err := p.OpenKey(id)
+	require.NoError(err)
+
+	defer key.Close()
+
+	hk, ok := key.(PrivateKeyHMAC)
+	require.True(ok)
+
+	expected, err := hk.HMAC([]byte("b"))
+	require.NoError(err)
+
+	now := time.Unix(1700000000, 0)
+	policy := &UsagePolicy{
+		MaxPerMinute: 3,
+		Confirm:      []string{OperationHMAC},
+		Clock:        timesync.ClockFunc(func() time.Time { return now }),
+	}
+
+	confirmations := 0
+	lk := LimitUsage(key, policy, func(string, KeyID) error {
+		confirmations++
+		return nil
+	})
+
+	_, ok = lk.(PrivateKeyBatchHMAC)
+	require.True(ok)
+
+	// The whole batch is confirmed once
+	results, err := HMACKeyBatch(context.Background(), lk, [][]byte{[]byte("a"), []byte("b")})
+	require.NoError(err)
+	require.Len(results, 2)
+	require.Equal(expected, results[1])
+	require.Equal(1, confirmations)
+
+	// Each HMAC counts towards the rate limit and
+	// batches exceeding it are refused before asking for a confirmation
+	_, err = HMACKeyBatch(context.Background(), lk, [][]byte{[]byte("a"), []byte("b")})
+	require.ErrorIs(err, ErrRateLimited)
+	require.Equal(1, confirmations)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = HMACKeyBatch(ctx, key, [][]byte{[]byte("a")})
+	require.ErrorIs(err, context.Canceled)
+}
@@ -0,0 +1,106 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"slices"
+)
+
+// KeyType identifies the type of key material.
+type KeyType string
+
+const (
+	KeyTypeHMACSHA1   KeyType = "HMAC-SHA1"
+	KeyTypeHMACSHA256 KeyType = "HMAC-SHA256"
+	KeyTypeHMACSHA512 KeyType = "HMAC-SHA512"
+	KeyTypeECP256     KeyType = "EC-P256"
+	KeyTypeECP384     KeyType = "EC-P384"
+	KeyTypeX25519     KeyType = "X25519"
+)
+
+// Policy describes when a user has to confirm an operation by touch or PIN.
+type Policy string
+
+const (
+	PolicyNever  Policy = "never"
+	PolicyOnce   Policy = "once"   // Once per session
+	PolicyAlways Policy = "always" // For each operation
+)
+
+// Capabilities describes the features supported by a provider.
+type Capabilities struct {
+	// KeyTypes are the types of keys which can be created by the provider.
+	KeyTypes []KeyType `json:"key_types"`
+
+	// DH is true if keys support Diffie-Hellman key agreement.
+	DH bool `json:"dh"`
+
+	// HMAC is true if keys support HMAC calculation.
+	HMAC bool `json:"hmac"`
+
+	// Signing is true if keys support the creation of signatures.
+	Signing bool `json:"signing"`
+
+	// OATH is true if the provider can store OATH credentials for authenticator applications.
+	OATH bool `json:"oath"`
+
+	// Attestation is true if the provider can attest that a key has been generated on the device.
+	Attestation bool `json:"attestation"`
+
+	// Hardware is true if keys can not be exported from the device.
+	Hardware bool `json:"hardware"`
+
+	// TouchPolicies are the supported policies for requiring touch.
+	TouchPolicies []Policy `json:"touch_policies,omitempty"`
+
+	// PINPolicies are the supported policies for requiring a PIN.
+	PINPolicies []Policy `json:"pin_policies,omitempty"`
+
+	// MaxKeys is the maximum number of keys which can be stored.
+	// It is zero if the number is not limited.
+	MaxKeys int `json:"max_keys,omitempty"`
+}
+
+// Supports returns true if the provider can create keys of the given type.
+func (c Capabilities) Supports(kt KeyType) bool {
+	return slices.Contains(c.KeyTypes, kt)
+}
+
+// Merge combines the capabilities of multiple providers.
+func (c Capabilities) Merge(o Capabilities) Capabilities {
+	union := func(a, b []Policy) []Policy {
+		r := slices.Clone(a)
+		for _, p := range b {
+			if !slices.Contains(r, p) {
+				r = append(r, p)
+			}
+		}
+
+		return r
+	}
+
+	m := Capabilities{
+		KeyTypes:      slices.Clone(c.KeyTypes),
+		DH:            c.DH || o.DH,
+		HMAC:          c.HMAC || o.HMAC,
+		Signing:       c.Signing || o.Signing,
+		OATH:          c.OATH || o.OATH,
+		Attestation:   c.Attestation || o.Attestation,
+		Hardware:      c.Hardware || o.Hardware,
+		TouchPolicies: union(c.TouchPolicies, o.TouchPolicies),
+		PINPolicies:   union(c.PINPolicies, o.PINPolicies),
+	}
+
+	for _, kt := range o.KeyTypes {
+		if !slices.Contains(m.KeyTypes, kt) {
+			m.KeyTypes = append(m.KeyTypes, kt)
+		}
+	}
+
+	if c.MaxKeys > 0 && o.MaxKeys > 0 {
+		m.MaxKeys = c.MaxKeys + o.MaxKeys
+	}
+
+	return m
+}
@@ -0,0 +1,45 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapabilitiesMerge(t *testing.T) {
+	require := require.New(t)
+
+	a := Capabilities{
+		KeyTypes:      []KeyType{KeyTypeHMACSHA256},
+		HMAC:          true,
+		Hardware:      true,
+		TouchPolicies: []Policy{PolicyNever, PolicyAlways},
+		MaxKeys:       32,
+	}
+
+	b := Capabilities{
+		KeyTypes:      []KeyType{KeyTypeECP256, KeyTypeHMACSHA256},
+		DH:            true,
+		TouchPolicies: []Policy{PolicyNever},
+		MaxKeys:       24,
+	}
+
+	m := a.Merge(b)
+	require.Equal([]KeyType{KeyTypeHMACSHA256, KeyTypeECP256}, m.KeyTypes)
+	require.True(m.DH)
+	require.True(m.HMAC)
+	require.True(m.Hardware)
+	require.False(m.Attestation)
+	require.Equal([]Policy{PolicyNever, PolicyAlways}, m.TouchPolicies)
+	require.Equal(56, m.MaxKeys)
+
+	require.True(m.Supports(KeyTypeECP256))
+	require.False(m.Supports(KeyTypeX25519))
+
+	// Unlimited providers make the combination unlimited
+	m = a.Merge(Capabilities{})
+	require.Zero(m.MaxKeys)
+}
@@ -0,0 +1,311 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"cunicu.li/go-iso7816"
+
+	"cunicu.li/hawkes/oath"
+	"cunicu.li/hawkes/piv"
+)
+
+var (
+	_ OATHProvider        = (*dryRunProvider)(nil)
+	_ HOTPCounterProvider = (*dryRunProvider)(nil)
+	_ OATHLister          = (*dryRunProvider)(nil)
+	_ Pinger              = (*dryRunProvider)(nil)
+	_ SecretImporter      = (*dryRunProvider)(nil)
+)
+
+// Action is an operation which would have modified a token.
+type Action struct {
+	Provider  string `json:"provider"`
+	Operation string `json:"operation"`
+	Target    string `json:"target,omitempty"`
+	Note      string `json:"note,omitempty"`
+}
+
+func (a Action) String() string {
+	s := a.Provider + ": " + a.Operation
+	if a.Target != "" {
+		s += " " + a.Target
+	}
+
+	if a.Note != "" {
+		s += " (" + a.Note + ")"
+	}
+
+	return s
+}
+
+// Plan collects the actions which have been skipped in dry-run mode.
+// It is safe for concurrent use.
+type Plan struct {
+	mu      sync.Mutex
+	actions []Action
+}
+
+// Add records an action.
+func (p *Plan) Add(a Action) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.actions = append(p.actions, a)
+}
+
+// Actions returns the recorded actions in the order in which they have been added.
+func (p *Plan) Actions() []Action {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return slices.Clone(p.actions)
+}
+
+// dryRunProvider validates operations which modify the token and
+// records them in a plan instead of performing them.
+type dryRunProvider struct {
+	Provider
+
+	name string
+	plan *Plan
+}
+
+// DryRun wraps a provider so that operations which modify the token,
+// like the creation and removal of keys or the storage of OATH credentials,
+// are only validated and recorded in plan.
+//
+// The provider is still queried for its keys, credentials and capabilities
+// so that the same errors are returned as by the actual operation,
+// e.g. ErrKeyNotFound, ErrCredentialExists or iso7816.ErrNoSpace.
+// CreateKey returns an empty key ID.
+func DryRun(p Provider, name string, plan *Plan) Provider {
+	return &dryRunProvider{
+		Provider: p,
+		name:     name,
+		plan:     plan,
+	}
+}
+
+func (p *dryRunProvider) add(op, target, note string) {
+	p.plan.Add(Action{
+		Provider:  p.name,
+		Operation: op,
+		Target:    target,
+		Note:      note,
+	})
+}
+
+func (p *dryRunProvider) CreateKey(label string) (KeyID, error) {
+	caps := p.Capabilities()
+	if len(caps.KeyTypes) == 0 {
+		return nil, fmt.Errorf("%w: provider can not create keys", errors.ErrUnsupported)
+	}
+
+	if err := p.checkSpace(caps); err != nil {
+		return nil, err
+	}
+
+	p.add("create_key", label, string(caps.KeyTypes[0]))
+
+	return nil, nil
+}
+
+// CreateKeyFromSecret records the creation of a key from a secret.
+func (p *dryRunProvider) CreateKeyFromSecret(label string, _ []byte) (KeyID, error) {
+	if _, ok := p.Provider.(SecretImporter); !ok {
+		return nil, errors.ErrUnsupported
+	}
+
+	if err := p.checkSpace(p.Capabilities()); err != nil {
+		return nil, err
+	}
+
+	p.add("create_key", label, "from secret")
+
+	return nil, nil
+}
+
+func (p *dryRunProvider) DestroyKey(id KeyID) error {
+	ids, err := p.Keys()
+	if err != nil {
+		return err
+	}
+
+	if !slices.ContainsFunc(ids, func(i KeyID) bool {
+		return slices.Equal(i, id)
+	}) {
+		return ErrKeyNotFound
+	}
+
+	p.add("destroy_key", id.String(), "")
+
+	return nil
+}
+
+func (p *dryRunProvider) PutCredential(cred *oath.Credential, overwrite bool) error {
+	if _, ok := p.Provider.(OATHProvider); !ok {
+		return errors.ErrUnsupported
+	}
+
+	if err := cred.Validate(); err != nil {
+		return err
+	}
+
+	exists, err := p.hasCredential(cred)
+	if err != nil {
+		return err
+	}
+
+	var note string
+	switch {
+	case exists && !overwrite:
+		return ErrCredentialExists
+	case exists:
+		note = "replaces existing credential"
+	default:
+		if err := p.checkSpace(p.Capabilities()); err != nil {
+			return err
+		}
+	}
+
+	p.add("put_credential", cred.Name(), note)
+
+	return nil
+}
+
+func (p *dryRunProvider) SetCounter(cred *oath.Credential, counter uint64) error {
+	if _, ok := p.Provider.(HOTPCounterProvider); !ok {
+		return errors.ErrUnsupported
+	}
+
+	if cred.Type != oath.HOTP {
+		return fmt.Errorf("%w: %s", oath.ErrUnsupportedType, cred.Type)
+	}
+
+	if err := cred.Validate(); err != nil {
+		return err
+	}
+
+	p.add("set_counter", cred.Name(), fmt.Sprintf("counter %d", counter))
+
+	return nil
+}
+
+// Counter records the calculation of a code as it consumes a counter value of the token.
+// It returns the counter of the credential instead of the one of the token.
+func (p *dryRunProvider) Counter(cred *oath.Credential, _ int) (uint64, error) {
+	if _, ok := p.Provider.(HOTPCounterProvider); !ok {
+		return 0, errors.ErrUnsupported
+	}
+
+	if cred.Type != oath.HOTP {
+		return 0, fmt.Errorf("%w: %s", oath.ErrUnsupportedType, cred.Type)
+	}
+
+	exists, err := p.hasCredential(cred)
+	if err != nil {
+		return 0, err
+	} else if !exists {
+		return 0, fmt.Errorf("%w: %s", ErrKeyNotFound, cred.Name())
+	}
+
+	p.add("calculate", cred.Name(), "advances the counter")
+
+	return cred.Counter, nil
+}
+
+// Credentials forwards to the underlying provider if it lists its credentials.
+func (p *dryRunProvider) Credentials() ([]*oath.Credential, error) {
+	ol, ok := p.Provider.(OATHLister)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+
+	return ol.Credentials()
+}
+
+// Ping forwards to the underlying provider as it does not modify the token.
+func (p *dryRunProvider) Ping(ctx context.Context) error {
+	return Ping(ctx, p.Provider)
+}
+
+// PIVImporter stores existing private keys in the slots of a PIV card (see piv.Card.ImportKey).
+type PIVImporter interface {
+	ImportKey(slot piv.Slot, key crypto.PrivateKey, policies piv.Policies) error
+}
+
+type dryRunPIVImporter struct {
+	name string
+	plan *Plan
+}
+
+// DryRunPIV returns an importer which only validates keys and
+// their policies and records their imports in plan (see DryRun).
+func DryRunPIV(name string, plan *Plan) PIVImporter {
+	return &dryRunPIVImporter{
+		name: name,
+		plan: plan,
+	}
+}
+
+func (i *dryRunPIVImporter) ImportKey(slot piv.Slot, key crypto.PrivateKey, policies piv.Policies) error {
+	alg, err := piv.CheckImport(key, policies)
+	if err != nil {
+		return err
+	}
+
+	i.plan.Add(Action{
+		Provider:  i.name,
+		Operation: "import_key",
+		Target:    slot.String(),
+		Note:      fmt.Sprintf("%s, PIN policy %s, touch policy %s", alg, policies.PIN, policies.Touch),
+	})
+
+	return nil
+}
+
+// hasCredential checks if a credential with the same name is stored.
+// Providers which do not list their credentials are assumed to have none.
+func (p *dryRunProvider) hasCredential(cred *oath.Credential) (bool, error) {
+	ol, ok := p.Provider.(OATHLister)
+	if !ok {
+		return false, nil
+	}
+
+	creds, err := ol.Credentials()
+	if err != nil {
+		return false, err
+	}
+
+	name := cred.Name()
+
+	return slices.ContainsFunc(creds, func(c *oath.Credential) bool {
+		return c.Name() == name
+	}), nil
+}
+
+// checkSpace fails like the token would if no further key can be stored.
+func (p *dryRunProvider) checkSpace(caps Capabilities) error {
+	if caps.MaxKeys == 0 {
+		return nil
+	}
+
+	ids, err := p.Keys()
+	if err != nil {
+		return err
+	}
+
+	if len(ids) >= caps.MaxKeys {
+		return fmt.Errorf("%w: %d of %d keys are stored", iso7816.ErrNoSpace, len(ids), caps.MaxKeys)
+	}
+
+	return nil
+}
@@ -0,0 +1,180 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"cunicu.li/go-iso7816"
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/oath"
+	"cunicu.li/hawkes/piv"
+)
+
+// oathStore is an OATH provider which fails if it is modified.
+type oathStore struct {
+	Provider
+
+	creds []*oath.Credential
+}
+
+func (s *oathStore) Capabilities() Capabilities {
+	return Capabilities{
+		KeyTypes: []KeyType{KeyTypeHMACSHA256},
+		OATH:     true,
+		MaxKeys:  2,
+	}
+}
+
+func (s *oathStore) Keys() (ids []KeyID, err error) {
+	for _, cred := range s.creds {
+		ids = append(ids, KeyID(cred.Name()))
+	}
+
+	return ids, nil
+}
+
+func (s *oathStore) PutCredential(*oath.Credential, bool) error {
+	return errors.New("modified") //nolint:err113
+}
+
+func (s *oathStore) Credentials() ([]*oath.Credential, error) {
+	return s.creds, nil
+}
+
+func (s *oathStore) SetCounter(*oath.Credential, uint64) error {
+	return errors.New("modified") //nolint:err113
+}
+
+func (s *oathStore) Counter(*oath.Credential, int) (uint64, error) {
+	return 0, errors.New("modified") //nolint:err113
+}
+
+func TestDryRun(t *testing.T) {
+	require := require.New(t)
+
+	fp, err := newFileProvider()
+	require.NoError(err)
+
+	id, err := fp.CreateKey("dry-run")
+	require.NoError(err)
+
+	defer func() {
+		err := fp.DestroyKey(id)
+		require.NoError(err)
+	}()
+
+	plan := &Plan{}
+	p := DryRun(fp, "file", plan)
+
+	err = p.DestroyKey(id)
+	require.NoError(err)
+
+	err = p.DestroyKey(KeyID("missing"))
+	require.ErrorIs(err, ErrKeyNotFound)
+
+	newID, err := p.CreateKey("new")
+	require.NoError(err)
+	require.Empty(newID)
+
+	// Nothing has been modified
+	ids, err := fp.Keys()
+	require.NoError(err)
+	require.Equal([]KeyID{id}, ids)
+
+	require.Equal([]Action{
+		{Provider: "file", Operation: "destroy_key", Target: id.String()},
+		{Provider: "file", Operation: "create_key", Target: "new", Note: "EC-P256"},
+	}, plan.Actions())
+
+	_, ok := p.(OATHProvider)
+	require.True(ok)
+
+	err = p.(OATHProvider).PutCredential(&oath.Credential{}, false) //nolint:forcetypeassert
+	require.ErrorIs(err, errors.ErrUnsupported)
+}
+
+func TestDryRunCredentials(t *testing.T) {
+	require := require.New(t)
+
+	alice := &oath.Credential{Type: oath.TOTP, Algorithm: oath.SHA1, Account: "alice", Secret: []byte("12345678901234567890")}
+	bob := &oath.Credential{Type: oath.TOTP, Algorithm: oath.SHA1, Account: "bob", Secret: []byte("12345678901234567890")}
+
+	plan := &Plan{}
+	p, ok := DryRun(&oathStore{creds: []*oath.Credential{alice}}, "ykoath", plan).(OATHProvider)
+	require.True(ok)
+
+	err := p.PutCredential(alice, false)
+	require.ErrorIs(err, ErrCredentialExists)
+
+	err = p.PutCredential(alice, true)
+	require.NoError(err)
+
+	err = p.PutCredential(&oath.Credential{Account: "invalid"}, false)
+	require.Error(err)
+
+	err = p.PutCredential(bob, false)
+	require.NoError(err)
+
+	require.Equal([]Action{
+		{Provider: "ykoath", Operation: "put_credential", Target: "alice", Note: "replaces existing credential"},
+		{Provider: "ykoath", Operation: "put_credential", Target: "bob"},
+	}, plan.Actions())
+
+	// The token is full
+	p, ok = DryRun(&oathStore{creds: []*oath.Credential{alice, bob}}, "ykoath", plan).(OATHProvider)
+	require.True(ok)
+
+	err = p.PutCredential(&oath.Credential{Type: oath.HOTP, Algorithm: oath.SHA1, Account: "carol", Secret: []byte("12345678901234567890")}, false)
+	require.ErrorIs(err, iso7816.ErrNoSpace)
+
+	require.Equal("ykoath: put_credential alice (replaces existing credential)", plan.Actions()[0].String())
+}
+
+func TestDryRunCounter(t *testing.T) {
+	require := require.New(t)
+
+	carol := &oath.Credential{Type: oath.HOTP, Algorithm: oath.SHA1, Account: "carol", Secret: []byte("12345678901234567890"), Counter: 42}
+
+	plan := &Plan{}
+	p, ok := DryRun(&oathStore{creds: []*oath.Credential{carol}}, "ykoath", plan).(HOTPCounterProvider)
+	require.True(ok)
+
+	// Calculating a code would advance the counter of the token
+	counter, err := p.Counter(carol, 10)
+	require.NoError(err)
+	require.EqualValues(42, counter)
+
+	_, err = p.Counter(&oath.Credential{Type: oath.HOTP, Account: "dave"}, 10)
+	require.ErrorIs(err, ErrKeyNotFound)
+
+	require.Equal([]Action{
+		{Provider: "ykoath", Operation: "calculate", Target: "carol", Note: "advances the counter"},
+	}, plan.Actions())
+}
+
+func TestDryRunPIV(t *testing.T) {
+	require := require.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	plan := &Plan{}
+	importer := DryRunPIV("piv", plan)
+
+	err = importer.ImportKey(piv.SlotSignature, key, piv.Policies{Touch: piv.TouchPolicyAlways})
+	require.NoError(err)
+
+	err = importer.ImportKey(piv.SlotSignature, "not a key", piv.Policies{})
+	require.ErrorIs(err, piv.ErrUnsupportedKey)
+
+	require.Len(plan.Actions(), 1)
+	require.Equal("import_key", plan.Actions()[0].Operation)
+	require.Equal(piv.SlotSignature.String(), plan.Actions()[0].Target)
+}
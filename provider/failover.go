@@ -0,0 +1,465 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/katzenpost/nyquist/dh"
+
+	"cunicu.li/hawkes/internal/queue"
+	"cunicu.li/hawkes/tap"
+)
+
+var (
+	ErrNoBackend       = errors.New("no backend of the key is available")
+	ErrBackendMismatch = errors.New("backends hold different keys")
+)
+
+// KeyRef references a key of a named provider.
+type KeyRef struct {
+	Provider string
+	ID       KeyID
+}
+
+// String formats the reference as "<provider>:<id>".
+func (r KeyRef) String() string {
+	return r.Provider + ":" + r.ID.String()
+}
+
+// ParseKeyURI parses a comma-separated list of key references
+// like "YKOATH:<id>,YKOATH:<id>,File:<id>" in order of preference.
+func ParseKeyURI(uri string) (refs []KeyRef, err error) {
+	for _, s := range strings.Split(uri, ",") {
+		name, id, ok := strings.Cut(strings.TrimSpace(s), ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%w: key reference %q", ErrParse, s)
+		}
+
+		ref := KeyRef{Provider: name}
+		if err := ref.ID.UnmarshalText([]byte(id)); err != nil {
+			return nil, err
+		}
+
+		refs = append(refs, ref)
+	}
+
+	return refs, nil
+}
+
+// FormatKeyURI formats a list of key references as URI.
+func FormatKeyURI(refs []KeyRef) string {
+	s := []string{}
+	for _, ref := range refs {
+		s = append(s, ref.String())
+	}
+
+	return strings.Join(s, ",")
+}
+
+// KeyOpener opens a backend of a failover key.
+type KeyOpener func() (PrivateKey, error)
+
+// NewFailoverKey creates a logical key backed by an ordered list of keys,
+// e.g. on a primary and backup token or a software escrow.
+// Each operation uses the first available backend. Backends are skipped if
+// they are absent or can not be reached, e.g. as a token has been removed.
+// Other errors like wrong PINs, touch timeouts or refusals by policies
+// do not fail over as the next backend would only hide them.
+//
+// All backends must hold the same key material. It is compared by the
+// public keys of the backends or their HMAC of a fixed challenge,
+// as their IDs depend on their providers. The available backends are
+// opened and compared right away, the others once they become available.
+// Mismatching backends are refused with ErrBackendMismatch.
+// The type of the key is decided by the first available backend.
+func NewFailoverKey(id KeyID, openers ...KeyOpener) (PrivateKey, error) {
+	fk := &failoverKey{
+		id:      id,
+		openers: openers,
+		keys:    make([]PrivateKey, len(openers)),
+		current: -1,
+	}
+
+	errs := []error{ErrNoBackend}
+
+	for i := range openers {
+		if _, err := fk.open(i); err != nil {
+			if !failover(err) {
+				fk.Close()
+				return nil, fmt.Errorf("backend %d: %w", i, err)
+			}
+
+			errs = append(errs, fmt.Errorf("backend %d: %w", i, err))
+		} else if fk.current < 0 {
+			fk.current = i
+		}
+	}
+
+	if fk.current < 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	first := fk.keys[fk.current]
+
+	_, isHMAC := first.(PrivateKeyHMAC)
+	_, isDH := first.(PrivateKeyDH)
+
+	switch {
+	case isHMAC && isDH:
+		return &failoverDHHMACKey{fk}, nil
+	case isHMAC:
+		return &failoverHMACKey{fk}, nil
+	case isDH:
+		return &failoverDHKey{fk}, nil
+	default:
+		return fk, nil
+	}
+}
+
+type failoverKey struct {
+	id      KeyID
+	openers []KeyOpener
+
+	mu          sync.Mutex
+	keys        []PrivateKey
+	current     int
+	fingerprint fingerprintFunc
+	material    []byte
+}
+
+// do runs fn with the first available backend.
+func (k *failoverKey) do(fn func(PrivateKey) error) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	errs := []error{ErrNoBackend}
+
+	for i := range k.openers {
+		key := k.keys[i]
+		if key == nil {
+			var err error
+			if key, err = k.open(i); err != nil {
+				if !failover(err) {
+					return fmt.Errorf("backend %d: %w", i, err)
+				}
+
+				errs = append(errs, fmt.Errorf("backend %d: %w", i, err))
+				continue
+			}
+		}
+
+		err := fn(key)
+		if err == nil || !failover(err) {
+			k.current = i
+			return err
+		}
+
+		// Reopen the backend for the next operation
+		key.Close()
+		k.keys[i] = nil
+
+		errs = append(errs, fmt.Errorf("backend %d: %w", i, err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// open opens a backend and checks that it holds the same key material
+// as the backends which have been opened before.
+func (k *failoverKey) open(i int) (PrivateKey, error) {
+	key, err := k.openers[i]()
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint := k.fingerprint
+	if fingerprint == nil {
+		fingerprint = fingerprintOf(key)
+	}
+
+	material, err := fingerprint(key)
+	if err != nil {
+		key.Close()
+		return nil, err
+	}
+
+	if k.material == nil {
+		k.fingerprint = fingerprint
+		k.material = material
+	} else if !bytes.Equal(material, k.material) {
+		key.Close()
+		return nil, ErrBackendMismatch
+	}
+
+	k.keys[i] = key
+
+	return key, nil
+}
+
+// failover decides whether an operation is retried with the next backend.
+// Only backends which are absent or can not be reached are skipped.
+func failover(err error) bool {
+	if tap.IsRetryable(err) || isCardUnavailable(err) {
+		return true
+	}
+
+	for _, target := range []error{
+		ErrKeyNotFound,
+		ErrNoSmartCards,
+		os.ErrNotExist,
+		tap.ErrRemoved,
+		queue.ErrDisconnected,
+		queue.ErrReset,
+		net.ErrClosed,
+		io.EOF,
+		io.ErrUnexpectedEOF,
+		syscall.ECONNREFUSED,
+		syscall.ECONNRESET,
+		syscall.EPIPE,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fingerprintFunc identifies the key material of a backend.
+type fingerprintFunc func(PrivateKey) ([]byte, error)
+
+// fingerprintOf returns the fingerprint for backends of the type of key.
+// HMAC keys are compared by their response to the identification
+// challenge of the YKOATH provider, other keys by their public key.
+func fingerprintOf(key PrivateKey) fingerprintFunc {
+	switch key.(type) {
+	case PrivateKeyHMAC:
+		return func(key PrivateKey) ([]byte, error) {
+			hk, ok := key.(PrivateKeyHMAC)
+			if !ok {
+				return nil, ErrBackendMismatch
+			}
+
+			return hk.HMAC([]byte(idChallenge))
+		}
+
+	case PrivateKeyDH:
+		return func(key PrivateKey) ([]byte, error) {
+			dk, ok := key.(PrivateKeyDH)
+			if !ok {
+				return nil, ErrBackendMismatch
+			}
+
+			return dk.Public().Bytes(), nil
+		}
+
+	case PrivateKeySigner:
+		return func(key PrivateKey) ([]byte, error) {
+			sk, ok := key.(PrivateKeySigner)
+			if !ok {
+				return nil, ErrBackendMismatch
+			}
+
+			signer, err := sk.Signer()
+			if err != nil {
+				return nil, err
+			}
+
+			return x509.MarshalPKIXPublicKey(signer.Public())
+		}
+
+	default:
+		return func(PrivateKey) ([]byte, error) {
+			return nil, ErrUnsupportedKeyType
+		}
+	}
+}
+
+func (k *failoverKey) ID() KeyID {
+	return k.id
+}
+
+func (k *failoverKey) Details() map[string]any {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	details := map[string]any{}
+	if key := k.keys[k.current]; key != nil {
+		details = key.Details()
+	}
+
+	details["backend"] = k.current
+
+	return details
+}
+
+func (k *failoverKey) Close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	errs := []error{}
+	for i, key := range k.keys {
+		if key != nil {
+			errs = append(errs, key.Close())
+			k.keys[i] = nil
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Signer returns a signer which fails over between the backends.
+func (k *failoverKey) Signer() (signer crypto.Signer, err error) {
+	if err := k.do(func(key PrivateKey) error {
+		sk, ok := key.(PrivateKeySigner)
+		if !ok {
+			return ErrUnsupportedKeyType
+		}
+
+		signer, err = sk.Signer()
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return &failoverSigner{
+		key:    k,
+		public: signer.Public(),
+	}, nil
+}
+
+// SignBatch signs all digests with the same backend.
+// The whole batch is repeated with the next backend if one fails.
+func (k *failoverKey) SignBatch(ctx context.Context, digests [][]byte, opts crypto.SignerOpts) (sigs [][]byte, err error) {
+	err = k.do(func(key PrivateKey) error {
+		sigs, err = SignBatch(ctx, key, digests, opts)
+		return err
+	})
+
+	return sigs, err
+}
+
+// HMACBatch calculates all HMACs with the same backend.
+// It is only exposed by keys which calculate HMACs (see PrivateKeyBatchHMAC).
+func (k *failoverKey) HMACBatch(ctx context.Context, challenges [][]byte) (results [][]byte, err error) {
+	err = k.do(func(key PrivateKey) error {
+		results, err = HMACKeyBatch(ctx, key, challenges)
+		return err
+	})
+
+	return results, err
+}
+
+// Attest attests the first available backend.
+// Backends which can not be attested do not fail over.
+func (k *failoverKey) Attest() (a *Attestation, err error) {
+	err = k.do(func(key PrivateKey) error {
+		ak, ok := key.(PrivateKeyAttester)
+		if !ok {
+			return ErrNotAttested
+		}
+
+		a, err = ak.Attest()
+		return err
+	})
+
+	return a, err
+}
+
+func (k *failoverKey) hmac(challenge []byte) (resp []byte, err error) {
+	err = k.do(func(key PrivateKey) error {
+		hk, ok := key.(PrivateKeyHMAC)
+		if !ok {
+			return ErrUnsupportedKeyType
+		}
+
+		resp, err = hk.HMAC(challenge)
+		return err
+	})
+
+	return resp, err
+}
+
+func (k *failoverKey) dh(pk dh.PublicKey) (ss []byte, err error) {
+	err = k.do(func(key PrivateKey) error {
+		dk, ok := key.(PrivateKeyDH)
+		if !ok {
+			return ErrUnsupportedKeyType
+		}
+
+		ss, err = dk.DH(pk)
+		return err
+	})
+
+	return ss, err
+}
+
+func (k *failoverKey) public() (pk dh.PublicKey) {
+	_ = k.do(func(key PrivateKey) error {
+		dk, ok := key.(PrivateKeyDH)
+		if !ok {
+			return ErrUnsupportedKeyType
+		}
+
+		pk = dk.Public()
+		return nil
+	})
+
+	return pk
+}
+
+type failoverSigner struct {
+	key    *failoverKey
+	public crypto.PublicKey
+}
+
+func (s *failoverSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+func (s *failoverSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) (sig []byte, err error) {
+	err = s.key.do(func(key PrivateKey) error {
+		sk, ok := key.(PrivateKeySigner)
+		if !ok {
+			return ErrUnsupportedKeyType
+		}
+
+		signer, err := sk.Signer()
+		if err != nil {
+			return err
+		}
+
+		sig, err = signer.Sign(rand, digest, opts)
+		return err
+	})
+
+	return sig, err
+}
+
+type failoverHMACKey struct{ *failoverKey }
+
+func (k *failoverHMACKey) HMAC(challenge []byte) ([]byte, error) { return k.hmac(challenge) }
+
+type failoverDHKey struct{ *failoverKey }
+
+func (k *failoverDHKey) DH(pk dh.PublicKey) ([]byte, error) { return k.dh(pk) }
+func (k *failoverDHKey) Public() dh.PublicKey               { return k.public() }
+
+type failoverDHHMACKey struct{ *failoverKey }
+
+func (k *failoverDHHMACKey) HMAC(challenge []byte) ([]byte, error) { return k.hmac(challenge) }
+func (k *failoverDHHMACKey) DH(pk dh.PublicKey) ([]byte, error)    { return k.dh(pk) }
+func (k *failoverDHHMACKey) Public() dh.PublicKey                  { return k.public() }
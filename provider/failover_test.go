@@ -0,0 +1,183 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/tap"
+)
+
+var errRemoved = errors.New("card has been removed")
+
+// removableKey simulates a key on a token which can be removed.
+type removableKey struct {
+	PrivateKeyHMAC
+
+	present *bool
+	err     error
+}
+
+func (k *removableKey) HMAC(challenge []byte) ([]byte, error) {
+	if !*k.present {
+		return nil, tap.Interrupted(errRemoved)
+	} else if k.err != nil {
+		return nil, k.err
+	}
+
+	return k.PrivateKeyHMAC.HMAC(challenge)
+}
+
+func TestParseKeyURI(t *testing.T) {
+	require := require.New(t)
+
+	refs, err := ParseKeyURI("YKOATH:AQI=, File:AwQ=")
+	require.NoError(err)
+	require.Equal([]KeyRef{
+		{"YKOATH", KeyID{1, 2}},
+		{"File", KeyID{3, 4}},
+	}, refs)
+	require.Equal("YKOATH:AQI=,File:AwQ=", FormatKeyURI(refs))
+
+	_, err = ParseKeyURI("AQI=")
+	require.ErrorIs(err, ErrParse)
+
+	_, err = ParseKeyURI("File:not base64")
+	require.ErrorIs(err, ErrParse)
+}
+
+func TestFailoverKey(t *testing.T) {
+	require := require.New(t)
+
+	p, err := newFileProvider()
+	require.NoError(err)
+
+	ids := []KeyID{}
+
+	for _, label := range []string{"primary", "other"} {
+		id, err := p.CreateKey(label)
+		require.NoError(err)
+
+		defer func() {
+			err := p.DestroyKey(id)
+			require.NoError(err)
+		}()
+
+		ids = append(ids, id)
+	}
+
+	// Both backends hold the same key
+	present, available := false, true
+	var failure error
+
+	openBackend := func(id KeyID, present *bool) KeyOpener {
+		return func() (PrivateKey, error) {
+			if !*present {
+				return nil, ErrKeyNotFound
+			}
+
+			key, err := p.OpenKey(id)
+			if err != nil {
+				return nil, err
+			}
+
+			return &removableKey{key.(PrivateKeyHMAC), present, failure}, nil //nolint:forcetypeassert
+		}
+	}
+
+	fk, err := NewFailoverKey(ids[0], openBackend(ids[0], &present), openBackend(ids[0], &available))
+	require.NoError(err)
+	require.Equal(ids[0], fk.ID())
+
+	hk, ok := fk.(PrivateKeyHMAC)
+	require.True(ok)
+
+	// The primary is absent
+	_, err = hk.HMAC([]byte("challenge"))
+	require.NoError(err)
+	require.Equal(1, fk.Details()["backend"])
+
+	// The primary is preferred once it is available
+	present = true
+
+	_, err = hk.HMAC([]byte("challenge"))
+	require.NoError(err)
+	require.Equal(0, fk.Details()["backend"])
+
+	// The primary has been removed
+	present = false
+
+	_, err = hk.HMAC([]byte("challenge"))
+	require.NoError(err)
+	require.Equal(1, fk.Details()["backend"])
+
+	// Wrong PINs and touch timeouts do not fail over
+	present = true
+
+	for _, failure = range []error{ErrWrongPIN, ErrTouchTimeout} {
+		fk, err := NewFailoverKey(ids[0], openBackend(ids[0], &present), openBackend(ids[0], &available))
+		require.ErrorIs(err, failure)
+		require.Nil(fk)
+	}
+
+	failure = nil
+
+	// Backends must hold the same key
+	_, err = NewFailoverKey(ids[0], openBackend(ids[0], &present), openBackend(ids[1], &available))
+	require.ErrorIs(err, ErrBackendMismatch)
+
+	// Backends which were absent are checked once they become available
+	present = false
+
+	fk, err = NewFailoverKey(ids[0], openBackend(ids[1], &present), openBackend(ids[0], &available))
+	require.NoError(err)
+
+	present = true
+
+	_, err = fk.(PrivateKeyHMAC).HMAC([]byte("challenge")) //nolint:forcetypeassert
+	require.ErrorIs(err, ErrBackendMismatch)
+
+	// Policy refusals do not fail over
+	fk, err = NewFailoverKey(ids[0],
+		func() (PrivateKey, error) {
+			key, err := p.OpenKey(ids[0])
+			if err != nil {
+				return nil, err
+			}
+
+			return LimitUsage(key, &UsagePolicy{MaxPerMinute: 1}, nil), nil
+		},
+		openBackend(ids[0], &available))
+	require.NoError(err)
+
+	_, err = fk.(PrivateKeyHMAC).HMAC([]byte("challenge")) //nolint:forcetypeassert
+	require.ErrorIs(err, ErrRateLimited)
+
+	// Attestations are forwarded to the backend
+	attestation, anchors := newAttestation(t, PolicyAlways)
+
+	fk, err = NewFailoverKey(ids[0],
+		func() (PrivateKey, error) {
+			key, err := p.OpenKey(ids[0])
+			if err != nil {
+				return nil, err
+			}
+
+			return &attestedFileKey{key.(PrivateKeyHMAC), attestation}, nil //nolint:forcetypeassert
+		})
+	require.NoError(err)
+
+	err = (&AttestationPolicy{Anchors: anchors, TouchPolicy: PolicyAlways}).CheckKey(fk)
+	require.NoError(err)
+
+	// No backend is available
+	_, err = NewFailoverKey(ids[0], func() (PrivateKey, error) {
+		return nil, ErrKeyNotFound
+	})
+	require.ErrorIs(err, ErrNoBackend)
+	require.ErrorIs(err, ErrKeyNotFound)
+}
@@ -5,24 +5,40 @@ package provider
 
 import (
 	"bytes"
+	"context"
+	"crypto"
 	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"errors"
 	"fmt"
+	"io"
+	"math/big"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 
 	"github.com/katzenpost/nyquist/dh"
+	"golang.org/x/crypto/hkdf"
 
 	"cunicu.li/hawkes/ecdh/sw"
+	"cunicu.li/hawkes/oath"
+	"cunicu.li/hawkes/secret"
 )
 
+// fileSignerInfo separates the signing key from the key used for DH and HMAC.
+const fileSignerInfo = "hawkes file signing key"
+
 var (
-	_ PrivateKeyHMAC = (*fileKey)(nil)
-	_ PrivateKeyDH   = (*fileKey)(nil)
+	_ PrivateKeyHMAC       = (*fileKey)(nil)
+	_ PrivateKeyDH         = (*fileKey)(nil)
+	_ PrivateKeySigner     = (*fileKey)(nil)
+	_ PrivateKeyExportable = (*fileKey)(nil)
 
 	//nolint:gochecknoglobals
 	cfg = sw.Config{
@@ -32,17 +48,15 @@ var (
 )
 
 type fileKey struct {
-	key   []byte
+	key   *secret.Buffer
 	label string
+
+	// id is determined when opening the key as the key is destroyed by Close.
+	id KeyID
 }
 
 func (k *fileKey) ID() KeyID {
-	sk, err := sw.LoadPrivateKey(cfg, k.key)
-	if err != nil {
-		panic(err) // TODO
-	}
-
-	return keyID(sk.Public())
+	return k.id
 }
 
 func (k *fileKey) Details() map[string]any {
@@ -51,38 +65,158 @@ func (k *fileKey) Details() map[string]any {
 	}
 }
 
-func (k *fileKey) HMAC(chal []byte) ([]byte, error) {
-	h := hmac.New(sha256.New, k.key)
-	h.Write(chal)
-	return h.Sum(nil), nil
+func (k *fileKey) HMAC(chal []byte) (mac []byte, err error) {
+	err = k.key.Use(func(key []byte) error {
+		h := hmac.New(sha256.New, key)
+		h.Write(chal)
+		mac = h.Sum(nil)
+		return nil
+	})
+
+	return mac, err
 }
 
-func (k *fileKey) DH(pk dh.PublicKey) ([]byte, error) {
-	sk, err := sw.LoadPrivateKey(cfg, k.key)
-	if err != nil {
+func (k *fileKey) DH(pk dh.PublicKey) (ss []byte, err error) {
+	err = k.key.Use(func(key []byte) error {
+		sk, err := sw.LoadPrivateKey(cfg, key)
+		if err != nil {
+			return err
+		}
+
+		ss, err = sk.DH(pk)
+		return err
+	})
+
+	return ss, err
+}
+
+func (k *fileKey) Public() (pk dh.PublicKey) {
+	_ = k.key.Use(func(key []byte) error {
+		sk, err := sw.LoadPrivateKey(cfg, key)
+		if err != nil {
+			return err
+		}
+
+		pk = sk.Public()
+		return nil
+	})
+
+	return pk
+}
+
+// Signer returns an ECDSA signer whose key is derived from the P256 scalar
+// so that the same key is not used for both key agreement and signatures.
+// The signing key is derived again for each signature and wiped afterwards.
+func (k *fileKey) Signer() (crypto.Signer, error) {
+	var pub *ecdsa.PublicKey
+
+	if err := k.withSigningKey(func(sk *ecdsa.PrivateKey) error {
+		pub = &ecdsa.PublicKey{
+			Curve: sk.Curve,
+			X:     new(big.Int).Set(sk.X),
+			Y:     new(big.Int).Set(sk.Y),
+		}
+
+		return nil
+	}); err != nil {
 		return nil, err
 	}
 
-	return sk.DH(pk)
+	return &fileSigner{
+		key: k,
+		pub: pub,
+	}, nil
 }
 
-func (k *fileKey) Public() dh.PublicKey {
-	sk, err := sw.LoadPrivateKey(cfg, k.key)
-	if err != nil {
+// withSigningKey derives the signing key and wipes it after fn returns.
+func (k *fileKey) withSigningKey(fn func(sk *ecdsa.PrivateKey) error) error {
+	return k.key.Use(func(key []byte) error {
+		d := make([]byte, 32)
+		defer secret.Wipe(d)
+
+		if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte(fileSignerInfo)), d); err != nil {
+			return err
+		}
+
+		esk, err := ecdh.P256().NewPrivateKey(d)
+		if err != nil {
+			return fmt.Errorf("failed to derive signing key: %w", err)
+		}
+
+		// Uncompressed point: 0x04 || X || Y
+		pk := esk.PublicKey().Bytes()
+
+		sk := &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{
+				Curve: elliptic.P256(),
+				X:     new(big.Int).SetBytes(pk[1:33]),
+				Y:     new(big.Int).SetBytes(pk[33:]),
+			},
+			D: new(big.Int).SetBytes(d),
+		}
+
+		defer func() {
+			clear(sk.D.Bits())
+			sk.D.SetInt64(0)
+		}()
+
+		return fn(sk)
+	})
+}
+
+// fileSigner signs with the derived signing key of a file key.
+type fileSigner struct {
+	key *fileKey
+	pub *ecdsa.PublicKey
+}
+
+func (s *fileSigner) Public() crypto.PublicKey {
+	return s.pub
+}
+
+func (s *fileSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) (sig []byte, err error) {
+	err = s.key.withSigningKey(func(sk *ecdsa.PrivateKey) error {
+		sig, err = sk.Sign(rand, digest, opts)
+		return err
+	})
+
+	return sig, err
+}
+
+// Credential returns the HMAC secret as OATH credential with the
+// same parameters as used by CreateKeyFromSecret of the YKOATH provider.
+func (k *fileKey) Credential() (cred *oath.Credential, err error) {
+	err = k.key.Use(func(key []byte) error {
+		cred = &oath.Credential{
+			Type:      oath.TOTP,
+			Algorithm: oath.SHA256,
+			Account:   k.label,
+			Secret:    bytes.Clone(key),
+			Digits:    oath.DefaultDigits,
+			Period:    oath.DefaultPeriod,
+		}
+
 		return nil
-	}
+	})
 
-	return sk.Public()
+	return cred, err
 }
 
+// Close wipes the key material from memory.
 func (k *fileKey) Close() error {
+	k.key.Destroy()
+
 	return nil
 }
 
-var _ Provider = (*fileProvider)(nil)
+var _ Pinger = (*fileProvider)(nil)
 
 type fileProvider struct {
 	keyDir string
+
+	mu       sync.Mutex
+	keystore *keystore      // nil if keys are not encrypted
+	kek      *secret.Buffer // nil while locked
 }
 
 func newFileProvider() (Provider, error) {
@@ -96,11 +230,36 @@ func newFileProvider() (Provider, error) {
 		return nil, fmt.Errorf("failed to create: %s: %w", keyDir, err)
 	}
 
+	ks, err := loadKeystore(keyDir)
+	if err != nil {
+		return nil, err
+	}
+
 	return &fileProvider{
-		keyDir: keyDir,
+		keyDir:   keyDir,
+		keystore: ks,
 	}, nil
 }
 
+// Ping checks that the key directory is still accessible,
+// e.g. if it resides on a removable or network volume.
+func (p *fileProvider) Ping(context.Context) error {
+	if _, err := os.Stat(p.keyDir); err != nil {
+		return fmt.Errorf("failed to access key directory: %w", err)
+	}
+
+	return nil
+}
+
+func (p *fileProvider) Capabilities() Capabilities {
+	return Capabilities{
+		KeyTypes: []KeyType{KeyTypeECP256},
+		DH:       true,
+		HMAC:     true,
+		Signing:  true,
+	}
+}
+
 func (p *fileProvider) Keys() (keyIDs []KeyID, err error) {
 	keys, err := p.keys()
 	if err != nil {
@@ -126,8 +285,9 @@ func (p *fileProvider) OpenKey(id KeyID) (PrivateKey, error) {
 	}
 
 	return &fileKey{
-		key:   key,
+		key:   secret.FromBytes(key),
 		label: label,
+		id:    slices.Clone(id),
 	}, nil
 }
 
@@ -144,7 +304,7 @@ func (p *fileProvider) CreateKeyFromSecret(label string, secret []byte) (KeyID,
 		return nil, fmt.Errorf("failed to load key: %w", err)
 	}
 
-	return keyID(sk.Public()), os.WriteFile(keyFile, sk.Bytes(), 0o600)
+	return keyID(sk.Public()), p.writeKey(keyFile, label, sk.Bytes())
 }
 
 func (p *fileProvider) CreateKey(label string) (KeyID, error) {
@@ -160,7 +320,7 @@ func (p *fileProvider) CreateKey(label string) (KeyID, error) {
 		return nil, fmt.Errorf("failed to generate key: %w", err)
 	}
 
-	return keyID(sk.Public()), os.WriteFile(keyFile, sk.Bytes(), 0o600)
+	return keyID(sk.Public()), p.writeKey(keyFile, label, sk.Bytes())
 }
 
 func (p *fileProvider) DestroyKey(id KeyID) error {
@@ -181,9 +341,9 @@ func (p *fileProvider) keys() (map[string][]byte, error) {
 	keys := map[string][]byte{}
 
 	for _, keyFile := range keyFiles {
-		key, err := os.ReadFile(keyFile)
+		key, err := p.readKey(keyFile)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read file: %w", err)
+			return nil, err
 		}
 
 		keys[keyFile] = key
@@ -254,7 +414,7 @@ func (p *fileProvider) keyByID(id KeyID) (string, []byte, error) {
 
 	keyLabel := filepath.Base(keyFile)
 	keyLabel = strings.TrimSuffix(keyLabel, ".key")
-	key, err := os.ReadFile(keyFile)
+	key, err := p.readKey(keyFile)
 
 	return keyLabel, key, err
 }
@@ -0,0 +1,413 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cunicu.li/hawkes/ecdh/sw"
+	"cunicu.li/hawkes/envelope"
+	"cunicu.li/hawkes/internal/atomicfile"
+	"cunicu.li/hawkes/kdf"
+	"cunicu.li/hawkes/secret"
+)
+
+var (
+	// ErrWrongPassphrase is the ErrWrongPIN of file providers.
+	ErrWrongPassphrase = fmt.Errorf("%w: passphrase does not match", ErrWrongPIN)
+
+	// ErrUnprotectedKey reports an unencrypted key file in a protected keystore.
+	ErrUnprotectedKey = errors.New("unencrypted key in protected keystore")
+)
+
+var (
+	_ LockableProvider    = (*fileProvider)(nil)
+	_ PINChanger          = (*fileProvider)(nil)
+	_ PassphraseProtector = (*fileProvider)(nil)
+)
+
+const (
+	// keystoreFile holds the KDF parameters and a verifier of the passphrase.
+	keystoreFile = "keystore.kdf"
+
+	// pendingSuffix marks the files of an unfinished Protect.
+	pendingSuffix = ".pending"
+
+	keystoreVerifierInfo = "hawkes keystore verifier"
+)
+
+// PassphraseProtector is implemented by software providers
+// which encrypt their keys with a key derived from a passphrase.
+type PassphraseProtector interface {
+	LockableProvider
+
+	// Protect encrypts the keys with a key derived from the passphrase
+	// with the given Argon2id parameters and replaces a previous passphrase.
+	// The keys are decrypted again if the passphrase is empty.
+	// The provider must be unlocked.
+	Protect(passphrase []byte, params *kdf.Argon2id) error
+}
+
+// keystore describes the encryption of the keys of a file provider.
+type keystore struct {
+	params   *kdf.Argon2id
+	verifier []byte
+}
+
+// loadKeystore reads the keystore after completing or discarding
+// an interrupted change of the passphrase (see commitKeystore).
+func loadKeystore(dir string) (*keystore, error) {
+	if err := recoverKeystore(dir); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, keystoreFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read keystore: %w", err)
+	}
+
+	lines := strings.Fields(string(data))
+	if len(lines) != 2 {
+		return nil, fmt.Errorf("%w: keystore", ErrParse)
+	}
+
+	params, err := kdf.Parse(lines[0])
+	if err != nil {
+		return nil, err
+	}
+
+	verifier, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: keystore verifier: %w", ErrParse, err)
+	}
+
+	return &keystore{
+		params:   params,
+		verifier: verifier,
+	}, nil
+}
+
+func (ks *keystore) encode() []byte {
+	return []byte(ks.params.String() + "\n" + base64.StdEncoding.EncodeToString(ks.verifier) + "\n")
+}
+
+// commitKeystore replaces the keys and the keystore so that a crash can not
+// leave keys behind which do not match the keystore:
+// All files are first written next to the old ones, with the keystore last.
+// Its pending file marks the others complete and is committed after them.
+// A nil keystore removes the encryption and is marked by an empty pending file.
+func commitKeystore(dir string, ks *keystore, files map[string][]byte) error {
+	for keyFile, data := range files {
+		if err := atomicfile.Write(keyFile+pendingSuffix, data); err != nil {
+			return err
+		}
+	}
+
+	var data []byte
+	if ks != nil {
+		data = ks.encode()
+	}
+
+	if err := atomicfile.Write(filepath.Join(dir, keystoreFile+pendingSuffix), data); err != nil {
+		return err
+	}
+
+	return recoverKeystore(dir)
+}
+
+// recoverKeystore completes a replacement of the keystore by commitKeystore
+// whose pending files are complete or discards it otherwise.
+// It is idempotent so that it can be interrupted itself.
+func recoverKeystore(dir string) error {
+	pending, err := filepath.Glob(filepath.Join(dir, "*.key"+pendingSuffix))
+	if err != nil {
+		return err
+	}
+
+	pendingKeystore := filepath.Join(dir, keystoreFile+pendingSuffix)
+
+	data, err := os.ReadFile(pendingKeystore)
+	if errors.Is(err, os.ErrNotExist) {
+		for _, fn := range pending {
+			if err := os.Remove(fn); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("failed to discard pending key: %w", err)
+			}
+		}
+
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read pending keystore: %w", err)
+	}
+
+	for _, fn := range pending {
+		if err := os.Rename(fn, strings.TrimSuffix(fn, pendingSuffix)); err != nil {
+			return fmt.Errorf("failed to commit key: %w", err)
+		}
+	}
+
+	if err := atomicfile.SyncDir(dir); err != nil {
+		return err
+	}
+
+	if len(data) > 0 {
+		if err := os.Rename(pendingKeystore, filepath.Join(dir, keystoreFile)); err != nil {
+			return fmt.Errorf("failed to commit keystore: %w", err)
+		}
+	} else {
+		if err := os.Remove(filepath.Join(dir, keystoreFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove keystore: %w", err)
+		}
+
+		if err := os.Remove(pendingKeystore); err != nil {
+			return fmt.Errorf("failed to commit keystore: %w", err)
+		}
+	}
+
+	return atomicfile.SyncDir(dir)
+}
+
+// kek derives the key-encryption key and checks it against the verifier.
+func (ks *keystore) kek(passphrase []byte) ([]byte, error) {
+	kek, err := ks.params.Key(passphrase, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	if !hmac.Equal(keystoreVerifier(kek), ks.verifier) {
+		secret.Wipe(kek)
+		return nil, ErrWrongPassphrase
+	}
+
+	return kek, nil
+}
+
+func keystoreVerifier(kek []byte) []byte {
+	mac := hmac.New(sha256.New, kek)
+	mac.Write([]byte(keystoreVerifierInfo))
+
+	return mac.Sum(nil)
+}
+
+// Locked returns true if the keys are encrypted and the passphrase has not been given yet.
+func (p *fileProvider) Locked() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.keystore != nil && p.kek == nil
+}
+
+// Unlock derives the key-encryption key from the passphrase.
+// Providers whose keys are not encrypted accept any passphrase.
+func (p *fileProvider) Unlock(passphrase []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.keystore == nil {
+		return nil
+	}
+
+	kek, err := p.keystore.kek(passphrase)
+	if err != nil {
+		return err
+	}
+
+	if p.kek != nil {
+		p.kek.Destroy()
+	}
+
+	p.kek = secret.FromBytes(kek)
+
+	return nil
+}
+
+// ChangePIN replaces the passphrase and keeps the Argon2id parameters.
+// Keys which are not encrypted yet are encrypted with the default parameters.
+func (p *fileProvider) ChangePIN(old, new []byte) error {
+	if err := p.Unlock(old); err != nil {
+		return err
+	}
+
+	var params *kdf.Argon2id
+
+	p.mu.Lock()
+	if p.keystore != nil {
+		params = &kdf.Argon2id{}
+		*params = *p.keystore.params
+	}
+	p.mu.Unlock()
+
+	if params != nil {
+		if err := params.GenerateSalt(); err != nil {
+			return err
+		}
+	}
+
+	return p.Protect(new, params)
+}
+
+// Protect encrypts all keys with a key derived from the passphrase.
+// The default parameters are used if params is nil.
+func (p *fileProvider) Protect(passphrase []byte, params *kdf.Argon2id) (err error) {
+	if p.Locked() {
+		return ErrLocked
+	}
+
+	keys, err := p.keys()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		for _, key := range keys {
+			secret.Wipe(key)
+		}
+	}()
+
+	var (
+		ks  *keystore
+		kek []byte
+	)
+
+	if len(passphrase) > 0 {
+		if params == nil {
+			if params, err = kdf.Default(); err != nil {
+				return err
+			}
+		}
+
+		if kek, err = params.Key(passphrase, 32); err != nil {
+			return err
+		}
+
+		ks = &keystore{
+			params:   params,
+			verifier: keystoreVerifier(kek),
+		}
+	}
+
+	// Encode all keys before modifying any file
+	files := map[string][]byte{}
+	for keyFile, key := range keys {
+		label := strings.TrimSuffix(filepath.Base(keyFile), ".key")
+		if files[keyFile], err = encodeKey(kek, label, key); err != nil {
+			return err
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := commitKeystore(p.keyDir, ks, files); err != nil {
+		return err
+	}
+
+	if p.kek != nil {
+		p.kek.Destroy()
+		p.kek = nil
+	}
+
+	if kek != nil {
+		p.kek = secret.FromBytes(kek)
+	}
+
+	p.keystore = ks
+
+	return nil
+}
+
+// writeKey stores a new key, encrypted if the keystore is protected.
+func (p *fileProvider) writeKey(keyFile, label string, key []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.keystore == nil {
+		return os.WriteFile(keyFile, key, 0o600)
+	} else if p.kek == nil {
+		return ErrLocked
+	}
+
+	return p.kek.Use(func(kek []byte) error {
+		data, err := encodeKey(kek, label, key)
+		if err != nil {
+			return err
+		}
+
+		return os.WriteFile(keyFile, data, 0o600)
+	})
+}
+
+// readKey reads a plain or encrypted key.
+// Plain keys are refused once the keystore is protected, so that planted
+// or left-over key files can not downgrade its protection.
+func (p *fileProvider) readKey(keyFile string) ([]byte, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !bytes.HasPrefix(data, []byte("-----BEGIN ")) {
+		if p.keystore != nil {
+			secret.Wipe(data)
+			return nil, fmt.Errorf("%w: %s", ErrUnprotectedKey, filepath.Base(keyFile))
+		}
+
+		return data, nil
+	}
+
+	env, err := envelope.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.kek == nil {
+		return nil, ErrLocked
+	}
+
+	var key []byte
+
+	err = p.kek.Use(func(kek []byte) (err error) {
+		key, err = env.Open(kek)
+		return err
+	})
+
+	return key, err
+}
+
+// encodeKey wraps a key in an envelope whose hint identifies it,
+// so that it can also be recovered as escrow (see MigrateOptions).
+// Keys are returned unchanged if kek is nil.
+func encodeKey(kek []byte, label string, key []byte) ([]byte, error) {
+	if kek == nil {
+		return bytes.Clone(key), nil
+	}
+
+	sk, err := sw.LoadPrivateKey(cfg, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load key: %w", err)
+	}
+
+	env, err := envelope.Wrap(kek, &envelope.Hint{
+		Provider: "File",
+		KeyID:    keyID(sk.Public()),
+		Label:    label,
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return env.PEM()
+}
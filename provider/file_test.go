@@ -4,10 +4,24 @@
 package provider
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/envelope"
+	"cunicu.li/hawkes/internal/atomicfile"
+	"cunicu.li/hawkes/kdf"
+	"cunicu.li/hawkes/oath"
+	"cunicu.li/hawkes/secret"
 )
 
 func TestMemory(t *testing.T) {
@@ -80,3 +94,269 @@ func TestMemory(t *testing.T) {
 
 	require.Equal(ss1, ss2)
 }
+
+func TestFileSigner(t *testing.T) {
+	require := require.New(t)
+
+	p := &fileProvider{keyDir: t.TempDir()}
+
+	id, err := p.CreateKey("signer")
+	require.NoError(err)
+
+	key, err := p.OpenKey(id)
+	require.NoError(err)
+
+	sk, ok := key.(PrivateKeySigner)
+	require.True(ok)
+
+	signer, err := sk.Signer()
+	require.NoError(err)
+
+	digest := sha256.Sum256([]byte("message"))
+
+	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	require.NoError(err)
+
+	pub, ok := signer.Public().(*ecdsa.PublicKey)
+	require.True(ok)
+	require.True(ecdsa.VerifyASN1(pub, digest[:], sig))
+
+	// The signing key differs from the key used for DH
+	dk, ok := key.(PrivateKeyDH)
+	require.True(ok)
+
+	ecdhPub, err := pub.ECDH()
+	require.NoError(err)
+	require.NotEqual(dk.Public().Bytes(), ecdhPub.Bytes())
+
+	require.NoError(key.Close())
+
+	// The ID remains available after the key material has been destroyed
+	require.Equal(id, key.ID())
+
+	// Signers do not retain the signing key
+	_, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	require.ErrorIs(err, secret.ErrDestroyed)
+}
+
+func TestFileExport(t *testing.T) {
+	require := require.New(t)
+
+	p := &fileProvider{keyDir: t.TempDir()}
+
+	id, err := p.CreateKey("export")
+	require.NoError(err)
+
+	// The secrets of private keys are only exported on request
+	creds, err := ExportCredentials(p, nil)
+	require.NoError(err)
+	require.Empty(creds)
+
+	creds, err = ExportCredentials(p, &ExportOptions{Keys: true})
+	require.NoError(err)
+
+	idx := slices.IndexFunc(creds, func(c *oath.Credential) bool { return c.Account == "export" })
+	require.NotEqual(-1, idx)
+
+	cred := creds[idx]
+	require.Equal(oath.SHA256, cred.Algorithm)
+
+	key, err := p.OpenKey(id)
+	require.NoError(err)
+
+	hk, ok := key.(PrivateKeyHMAC)
+	require.True(ok)
+
+	// The exported secret must produce the same HMAC as the key
+	expected, err := hk.HMAC([]byte("challenge"))
+	require.NoError(err)
+
+	mac := hmac.New(sha256.New, cred.Secret)
+	mac.Write([]byte("challenge"))
+	require.Equal(expected, mac.Sum(nil))
+
+	require.NoError(key.Close())
+}
+
+func TestFileKeystore(t *testing.T) {
+	require := require.New(t)
+
+	dir := t.TempDir()
+	p := &fileProvider{keyDir: dir}
+	require.False(p.Locked())
+
+	id1, err := p.CreateKey("before")
+	require.NoError(err)
+
+	// Cheap parameters to keep the test fast
+	params := &kdf.Argon2id{Time: 1, Memory: 64, Threads: 1}
+	require.NoError(params.GenerateSalt())
+	require.NoError(p.Protect([]byte("passphrase"), params))
+
+	id2, err := p.CreateKey("after")
+	require.NoError(err)
+
+	// Keys are stored as envelopes identifying the key
+	for label, id := range map[string]KeyID{"before": id1, "after": id2} {
+		data, err := os.ReadFile(filepath.Join(dir, label+".key"))
+		require.NoError(err)
+
+		env, err := envelope.Parse(data)
+		require.NoError(err)
+		require.Equal(label, env.Hint.Label)
+		require.Equal([]byte(id), env.Hint.KeyID)
+	}
+
+	// A new provider instance is locked until the passphrase is given
+	ks, err := loadKeystore(dir)
+	require.NoError(err)
+
+	p = &fileProvider{keyDir: dir, keystore: ks}
+	require.True(p.Locked())
+
+	_, err = p.Keys()
+	require.ErrorIs(err, ErrLocked)
+
+	_, err = p.CreateKey("locked")
+	require.ErrorIs(err, ErrLocked)
+
+	require.ErrorIs(p.Unlock([]byte("wrong")), ErrWrongPassphrase)
+	require.True(p.Locked())
+
+	require.NoError(p.Unlock([]byte("passphrase")))
+	require.False(p.Locked())
+
+	keys, err := p.Keys()
+	require.NoError(err)
+	require.ElementsMatch([]KeyID{id1, id2}, keys)
+
+	// The passphrase is changed with the same parameters
+	require.ErrorIs(p.ChangePIN([]byte("wrong"), []byte("new")), ErrWrongPassphrase)
+	require.NoError(p.ChangePIN([]byte("passphrase"), []byte("new")))
+	require.Equal(params.Memory, p.keystore.params.Memory)
+	require.NotEqual(params.Salt, p.keystore.params.Salt)
+
+	ks, err = loadKeystore(dir)
+	require.NoError(err)
+
+	_, err = ks.kek([]byte("passphrase"))
+	require.ErrorIs(err, ErrWrongPassphrase)
+
+	_, err = ks.kek([]byte("new"))
+	require.NoError(err)
+
+	key, err := p.OpenKey(id1)
+	require.NoError(err)
+	require.Equal(id1, key.ID())
+	require.NoError(key.Close())
+
+	// Unencrypted key files are refused by protected keystores
+	other := &fileProvider{keyDir: t.TempDir()}
+
+	_, err = other.CreateKey("planted")
+	require.NoError(err)
+
+	planted, err := os.ReadFile(filepath.Join(other.keyDir, "planted.key"))
+	require.NoError(err)
+	require.NoError(os.WriteFile(filepath.Join(dir, "planted.key"), planted, 0o600))
+
+	_, err = p.Keys()
+	require.ErrorIs(err, ErrUnprotectedKey)
+
+	require.NoError(os.Remove(filepath.Join(dir, "planted.key")))
+
+	// An empty passphrase removes the encryption
+	require.NoError(p.Protect(nil, nil))
+	require.False(p.Locked())
+
+	_, err = os.Stat(filepath.Join(dir, keystoreFile))
+	require.ErrorIs(err, os.ErrNotExist)
+
+	data, err := os.ReadFile(filepath.Join(dir, "after.key"))
+	require.NoError(err)
+	require.Len(data, 32)
+}
+
+func TestFileKeystoreRecovery(t *testing.T) {
+	require := require.New(t)
+
+	dir := t.TempDir()
+	p := &fileProvider{keyDir: dir}
+
+	id1, err := p.CreateKey("a")
+	require.NoError(err)
+
+	id2, err := p.CreateKey("b")
+	require.NoError(err)
+
+	params := &kdf.Argon2id{Time: 1, Memory: 64, Threads: 1}
+	require.NoError(params.GenerateSalt())
+
+	kek, err := params.Key([]byte("passphrase"), 32)
+	require.NoError(err)
+
+	keys, err := p.keys()
+	require.NoError(err)
+
+	files := map[string][]byte{}
+	for keyFile, key := range keys {
+		label := strings.TrimSuffix(filepath.Base(keyFile), ".key")
+		files[keyFile], err = encodeKey(kek, label, key)
+		require.NoError(err)
+	}
+
+	// Keys written before a crash are discarded without the pending keystore
+	for keyFile, data := range files {
+		require.NoError(atomicfile.Write(keyFile+pendingSuffix, data))
+	}
+
+	ks, err := loadKeystore(dir)
+	require.NoError(err)
+	require.Nil(ks)
+
+	pending, err := filepath.Glob(filepath.Join(dir, "*"+pendingSuffix))
+	require.NoError(err)
+	require.Empty(pending)
+
+	data, err := os.ReadFile(filepath.Join(dir, "a.key"))
+	require.NoError(err)
+	require.Len(data, 32)
+
+	// A crash while committing the complete pending files is rolled forward
+	for keyFile, data := range files {
+		require.NoError(atomicfile.Write(keyFile+pendingSuffix, data))
+	}
+
+	ks = &keystore{
+		params:   params,
+		verifier: keystoreVerifier(kek),
+	}
+	require.NoError(atomicfile.Write(filepath.Join(dir, keystoreFile+pendingSuffix), ks.encode()))
+	require.NoError(os.Rename(filepath.Join(dir, "a.key"+pendingSuffix), filepath.Join(dir, "a.key")))
+
+	ks, err = loadKeystore(dir)
+	require.NoError(err)
+	require.NotNil(ks)
+
+	pending, err = filepath.Glob(filepath.Join(dir, "*"+pendingSuffix))
+	require.NoError(err)
+	require.Empty(pending)
+
+	p = &fileProvider{keyDir: dir, keystore: ks}
+	require.NoError(p.Unlock([]byte("passphrase")))
+
+	ids, err := p.Keys()
+	require.NoError(err)
+	require.ElementsMatch([]KeyID{id1, id2}, ids)
+}
+
+func generateSecret() ([]byte, error) {
+	// RFC4226 recommends a secret length of 160bits
+	// but we use 256bits for compatibility with P256 private keys
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
@@ -0,0 +1,97 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cunicu.li/hawkes/metrics"
+)
+
+// Pinger is implemented by providers which can cheaply check whether
+// their token is present and responsive without performing a key operation,
+// e.g. by selecting their applet again.
+type Pinger interface {
+	Provider
+
+	// Ping checks the token and returns an error if it is absent or wedged.
+	Ping(ctx context.Context) error
+}
+
+// Ping checks the health of a provider.
+// Providers which do not implement Pinger do not depend on
+// removable hardware and are considered healthy.
+func Ping(ctx context.Context, p Provider) error {
+	if pp, ok := p.(Pinger); ok {
+		return pp.Ping(ctx)
+	}
+
+	return nil
+}
+
+// Health is the result of the health check of a single provider.
+type Health struct {
+	Provider string        `json:"provider"`
+	Healthy  bool          `json:"healthy"`
+	Error    string        `json:"error,omitempty"`
+	Latency  time.Duration `json:"latency"`
+
+	err error
+}
+
+// Healthy checks the health of all providers.
+// The health of each provider name is reported to the metrics
+// if they implement metrics.HealthReporter. A name is only reported
+// as healthy if all of its providers are, e.g. those of multiple YubiKeys.
+func (p *MultiProvider) Healthy(ctx context.Context) []Health {
+	health := make([]Health, 0, len(p.providers))
+	healthy := map[string]bool{}
+
+	for i, provider := range p.providers {
+		start := time.Now()
+		err := Ping(ctx, provider)
+
+		h := Health{
+			Provider: p.names[i],
+			Healthy:  err == nil,
+			Latency:  time.Since(start),
+			err:      err,
+		}
+
+		if err != nil {
+			h.Error = err.Error()
+		}
+
+		if ok, seen := healthy[h.Provider]; !seen || ok {
+			healthy[h.Provider] = h.Healthy
+		}
+
+		health = append(health, h)
+	}
+
+	if hr, ok := p.cfg.Metrics.(metrics.HealthReporter); ok {
+		for name, ok := range healthy {
+			hr.SetHealthy(name, ok)
+		}
+	}
+
+	return health
+}
+
+// Ping checks the health of all providers and
+// returns the joined errors of the unhealthy ones.
+func (p *MultiProvider) Ping(ctx context.Context) error {
+	errs := []error{}
+
+	for _, h := range p.Healthy(ctx) {
+		if !h.Healthy {
+			errs = append(errs, fmt.Errorf("%s: %w", h.Provider, h.err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,76 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/metrics"
+)
+
+var errAbsent = errors.New("token is absent")
+
+type pingProvider struct {
+	Provider
+
+	err error
+}
+
+func (p *pingProvider) Ping(context.Context) error {
+	return p.err
+}
+
+func TestHealthy(t *testing.T) {
+	require := require.New(t)
+
+	fp, err := newFileProvider()
+	require.NoError(err)
+
+	var ops []string
+
+	m := metrics.Func(func(op, provider string, _ time.Duration, outcome metrics.Outcome) {
+		ops = append(ops, op+"/"+provider+"/"+string(outcome))
+	})
+
+	prom := metrics.NewPrometheus("hawkes")
+
+	p := &MultiProvider{
+		cfg: MultiProviderConfig{
+			Metrics: prom,
+		},
+		providers: []Provider{
+			fp,
+			WithMetrics(&pingProvider{Provider: fp}, "YKOATH", m),
+			DryRun(&pingProvider{Provider: fp, err: errAbsent}, "YKOATH", &Plan{}),
+		},
+		names: []string{"File", "YKOATH", "YKOATH"},
+	}
+
+	health := p.Healthy(context.Background())
+	require.Len(health, 3)
+	require.True(health[0].Healthy)
+	require.True(health[1].Healthy)
+	require.False(health[2].Healthy)
+	require.Equal(errAbsent.Error(), health[2].Error)
+
+	// Wrapped providers are pinged as well
+	require.Equal([]string{"ping/YKOATH/success"}, ops)
+
+	err = p.Ping(context.Background())
+	require.ErrorIs(err, errAbsent)
+	require.ErrorContains(err, "YKOATH")
+
+	// A name is only healthy if all its providers are
+	sb := &strings.Builder{}
+	_, err = prom.WriteTo(sb)
+	require.NoError(err)
+	require.Contains(sb.String(), `hawkes_provider_up{provider="File"} 1`)
+	require.Contains(sb.String(), `hawkes_provider_up{provider="YKOATH"} 0`)
+}
@@ -0,0 +1,427 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/katzenpost/nyquist/dh"
+
+	"cunicu.li/hawkes/event"
+	"cunicu.li/hawkes/metrics"
+	"cunicu.li/hawkes/oath"
+)
+
+var (
+	_ BatchProvider       = (*instrumentedProvider)(nil)
+	_ OATHProvider        = (*instrumentedProvider)(nil)
+	_ HOTPCounterProvider = (*instrumentedProvider)(nil)
+	_ TOTPCalculator      = (*instrumentedProvider)(nil)
+	_ OATHLister          = (*instrumentedProvider)(nil)
+	_ Pinger              = (*instrumentedProvider)(nil)
+	_ SecretImporter      = (*instrumentedProvider)(nil)
+)
+
+// Auditor records the usage of keys, e.g. an audit.Log.
+// Implementations must be safe for concurrent use.
+type Auditor interface {
+	Record(operation, key string, err error) error
+}
+
+// SealedAuditor is an auditor whose records are sealed
+// by signatures of a key, e.g. an audit.Log.
+type SealedAuditor interface {
+	Auditor
+
+	SetSealer(s crypto.Signer)
+}
+
+// instrumentedProvider reports all operations of a provider and its keys
+// to a metrics hook and the usage of its keys to an auditor.
+type instrumentedProvider struct {
+	Provider
+
+	name    string
+	metrics metrics.Metrics
+	auditor Auditor
+	events  event.Publisher
+}
+
+// WithMetrics wraps a provider so that all its operations and
+// those of its keys are reported to m under the given provider name.
+func WithMetrics(p Provider, name string, m metrics.Metrics) Provider {
+	return &instrumentedProvider{
+		Provider: p,
+		name:     name,
+		metrics:  m,
+	}
+}
+
+// WithAudit wraps a provider so that all signatures, key agreements,
+// HMAC calculations and exports of its keys are recorded by a.
+// Keys are identified as "<name>:<id>".
+// Operations fail if they can not be recorded.
+func WithAudit(p Provider, name string, a Auditor) Provider {
+	return &instrumentedProvider{
+		Provider: p,
+		name:     name,
+		auditor:  a,
+	}
+}
+
+func (p *instrumentedProvider) time(op string, fn func() error) error {
+	if p.metrics == nil {
+		return fn()
+	}
+
+	return metrics.Time(p.metrics, op, p.name, fn)
+}
+
+// WithEvents wraps a provider so that touch timeouts during
+// operations of its keys are published to pub.
+func WithEvents(p Provider, name string, pub event.Publisher) Provider {
+	return &instrumentedProvider{
+		Provider: p,
+		name:     name,
+		events:   pub,
+	}
+}
+
+// use times an operation of a key and records it for auditing.
+func (p *instrumentedProvider) use(op, key string, fn func() error) error {
+	err := p.time(op, fn)
+
+	if errors.Is(err, ErrTouchTimeout) {
+		event.Publish(p.events, event.Event{
+			Type:     event.TouchTimeout,
+			Provider: p.name,
+			Key:      key,
+			Error:    err.Error(),
+		})
+	}
+
+	if p.auditor != nil {
+		if aerr := p.auditor.Record(op, key, err); aerr != nil {
+			return fmt.Errorf("failed to record %s operation: %w", op, aerr)
+		}
+	}
+
+	return err
+}
+
+func (p *instrumentedProvider) Ping(ctx context.Context) error {
+	return p.time("ping", func() error {
+		return Ping(ctx, p.Provider)
+	})
+}
+
+func (p *instrumentedProvider) Keys() (ids []KeyID, err error) {
+	err = p.time("keys", func() (err error) {
+		ids, err = p.Provider.Keys()
+		return err
+	})
+
+	return ids, err
+}
+
+func (p *instrumentedProvider) CreateKey(label string) (id KeyID, err error) {
+	err = p.time("create_key", func() (err error) {
+		id, err = p.Provider.CreateKey(label)
+		return err
+	})
+
+	return id, err
+}
+
+// CreateKeyFromSecret forwards to the underlying provider if it can import secrets.
+func (p *instrumentedProvider) CreateKeyFromSecret(label string, secret []byte) (id KeyID, err error) {
+	si, ok := p.Provider.(SecretImporter)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+
+	err = p.time("create_key", func() (err error) {
+		id, err = si.CreateKeyFromSecret(label, secret)
+		return err
+	})
+
+	return id, err
+}
+
+func (p *instrumentedProvider) DestroyKey(id KeyID) error {
+	return p.time("destroy_key", func() error {
+		return p.Provider.DestroyKey(id)
+	})
+}
+
+func (p *instrumentedProvider) OpenKey(id KeyID) (key PrivateKey, err error) {
+	if err := p.time("open_key", func() (err error) {
+		key, err = p.Provider.OpenKey(id)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	ik := &instrumentedKey{
+		PrivateKey: key,
+		provider:   p,
+		uri:        p.name + ":" + id.String(),
+	}
+
+	_, isHMAC := key.(PrivateKeyHMAC)
+	_, isDH := key.(PrivateKeyDH)
+
+	switch {
+	case isHMAC && isDH:
+		return &instrumentedDHHMACKey{ik}, nil
+	case isHMAC:
+		return &instrumentedHMACKey{ik}, nil
+	case isDH:
+		return &instrumentedDHKey{ik}, nil
+	default:
+		return ik, nil
+	}
+}
+
+// PutCredential forwards to the underlying provider if it supports OATH credentials.
+func (p *instrumentedProvider) PutCredential(cred *oath.Credential, overwrite bool) error {
+	op, ok := p.Provider.(OATHProvider)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+
+	return p.time("put_credential", func() error {
+		return op.PutCredential(cred, overwrite)
+	})
+}
+
+// SetCounter forwards to the underlying provider if it keeps HOTP counters.
+func (p *instrumentedProvider) SetCounter(cred *oath.Credential, counter uint64) error {
+	cp, ok := p.Provider.(HOTPCounterProvider)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+
+	return p.time("set_counter", func() error {
+		return cp.SetCounter(cred, counter)
+	})
+}
+
+// Counter forwards to the underlying provider if it keeps HOTP counters.
+func (p *instrumentedProvider) Counter(cred *oath.Credential, window int) (counter uint64, err error) {
+	cp, ok := p.Provider.(HOTPCounterProvider)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+
+	err = p.time("counter", func() (err error) {
+		counter, err = cp.Counter(cred, window)
+		return err
+	})
+
+	return counter, err
+}
+
+// Credentials forwards to the underlying provider if it lists its credentials.
+func (p *instrumentedProvider) Credentials() (creds []*oath.Credential, err error) {
+	ol, ok := p.Provider.(OATHLister)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+
+	err = p.time("credentials", func() (err error) {
+		creds, err = ol.Credentials()
+		return err
+	})
+
+	return creds, err
+}
+
+// Calculate forwards to the underlying provider if it calculates TOTP codes.
+func (p *instrumentedProvider) Calculate(name string, at time.Time) (code string, err error) {
+	tc, ok := p.Provider.(TOTPCalculator)
+	if !ok {
+		return "", errors.ErrUnsupported
+	}
+
+	err = p.time("calculate", func() (err error) {
+		code, err = tc.Calculate(name, at)
+		return err
+	})
+
+	return code, err
+}
+
+// CalculateWindow forwards to the underlying provider if it calculates TOTP codes.
+func (p *instrumentedProvider) CalculateWindow(name string, at time.Time, skew int) (codes []string, err error) {
+	tc, ok := p.Provider.(TOTPCalculator)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+
+	err = p.time("calculate_window", func() (err error) {
+		codes, err = tc.CalculateWindow(name, at, skew)
+		return err
+	})
+
+	return codes, err
+}
+
+func (p *instrumentedProvider) BatchHMAC() HMACBatch {
+	return &instrumentedHMACBatch{
+		HMACBatch: NewHMACBatch(p.Provider),
+		provider:  p,
+	}
+}
+
+type instrumentedHMACBatch struct {
+	HMACBatch
+
+	provider *instrumentedProvider
+}
+
+func (b *instrumentedHMACBatch) HMAC(id KeyID, challenge []byte) HMACBatch {
+	b.HMACBatch.HMAC(id, challenge)
+	return b
+}
+
+func (b *instrumentedHMACBatch) Run(ctx context.Context) (results [][]byte, err error) {
+	err = b.provider.time("batch_hmac", func() (err error) {
+		results, err = b.HMACBatch.Run(ctx)
+		return err
+	})
+
+	return results, err
+}
+
+type instrumentedKey struct {
+	PrivateKey
+
+	provider *instrumentedProvider
+	uri      string
+}
+
+func (k *instrumentedKey) hmac(challenge []byte) (resp []byte, err error) {
+	err = k.provider.use("hmac", k.uri, func() (err error) {
+		resp, err = k.PrivateKey.(PrivateKeyHMAC).HMAC(challenge) //nolint:forcetypeassert
+		return err
+	})
+
+	return resp, err
+}
+
+func (k *instrumentedKey) dh(pk dh.PublicKey) (ss []byte, err error) {
+	err = k.provider.use("dh", k.uri, func() (err error) {
+		ss, err = k.PrivateKey.(PrivateKeyDH).DH(pk) //nolint:forcetypeassert
+		return err
+	})
+
+	return ss, err
+}
+
+func (k *instrumentedKey) public() dh.PublicKey {
+	return k.PrivateKey.(PrivateKeyDH).Public() //nolint:forcetypeassert
+}
+
+// Signer returns an instrumented signer if the underlying key supports signing.
+func (k *instrumentedKey) Signer() (crypto.Signer, error) {
+	sk, ok := k.PrivateKey.(PrivateKeySigner)
+	if !ok {
+		return nil, ErrUnsupportedKeyType
+	}
+
+	signer, err := sk.Signer()
+	if err != nil {
+		return nil, err
+	}
+
+	return &instrumentedSigner{
+		Signer:   signer,
+		provider: k.provider,
+		uri:      k.uri,
+	}, nil
+}
+
+// SignBatch signs the digests as a single operation.
+func (k *instrumentedKey) SignBatch(ctx context.Context, digests [][]byte, opts crypto.SignerOpts) (sigs [][]byte, err error) {
+	err = k.provider.use("sign_batch", k.uri, func() (err error) {
+		sigs, err = SignBatch(ctx, k.PrivateKey, digests, opts)
+		return err
+	})
+
+	return sigs, err
+}
+
+// HMACBatch calculates the HMACs as a single operation.
+// It is only exposed by keys which calculate HMACs (see PrivateKeyBatchHMAC).
+func (k *instrumentedKey) HMACBatch(ctx context.Context, challenges [][]byte) (results [][]byte, err error) {
+	err = k.provider.use("hmac_batch", k.uri, func() (err error) {
+		results, err = HMACKeyBatch(ctx, k.PrivateKey, challenges)
+		return err
+	})
+
+	return results, err
+}
+
+// Credential returns the OATH credential if the underlying key is exportable.
+// Exports are recorded like other uses of the key.
+func (k *instrumentedKey) Credential() (cred *oath.Credential, err error) {
+	ek, ok := k.PrivateKey.(PrivateKeyExportable)
+	if !ok {
+		return nil, ErrNotExportable
+	}
+
+	err = k.provider.use("export", k.uri, func() (err error) {
+		cred, err = ek.Credential()
+		return err
+	})
+
+	return cred, err
+}
+
+// Attest returns the attestation if the underlying key can be attested.
+func (k *instrumentedKey) Attest() (*Attestation, error) {
+	ak, ok := k.PrivateKey.(PrivateKeyAttester)
+	if !ok {
+		return nil, ErrNotAttested
+	}
+
+	return ak.Attest()
+}
+
+type instrumentedSigner struct {
+	crypto.Signer
+
+	provider *instrumentedProvider
+	uri      string
+}
+
+func (s *instrumentedSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) (sig []byte, err error) {
+	err = s.provider.use("sign", s.uri, func() (err error) {
+		sig, err = s.Signer.Sign(rand, digest, opts)
+		return err
+	})
+
+	return sig, err
+}
+
+type instrumentedHMACKey struct{ *instrumentedKey }
+
+func (k *instrumentedHMACKey) HMAC(challenge []byte) ([]byte, error) { return k.hmac(challenge) }
+
+type instrumentedDHKey struct{ *instrumentedKey }
+
+func (k *instrumentedDHKey) DH(pk dh.PublicKey) ([]byte, error) { return k.dh(pk) }
+func (k *instrumentedDHKey) Public() dh.PublicKey               { return k.public() }
+
+type instrumentedDHHMACKey struct{ *instrumentedKey }
+
+func (k *instrumentedDHHMACKey) HMAC(challenge []byte) ([]byte, error) { return k.hmac(challenge) }
+func (k *instrumentedDHHMACKey) DH(pk dh.PublicKey) ([]byte, error)    { return k.dh(pk) }
+func (k *instrumentedDHHMACKey) Public() dh.PublicKey                  { return k.public() }
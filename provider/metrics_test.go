@@ -0,0 +1,231 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/audit"
+	"cunicu.li/hawkes/event"
+	"cunicu.li/hawkes/metrics"
+)
+
+func TestWithMetrics(t *testing.T) {
+	require := require.New(t)
+
+	var mu sync.Mutex
+	var ops []string
+
+	m := metrics.Func(func(op, provider string, _ time.Duration, outcome metrics.Outcome) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		ops = append(ops, op+"/"+provider+"/"+string(outcome))
+	})
+
+	fp, err := newFileProvider()
+	require.NoError(err)
+
+	p := WithMetrics(fp, "File", m)
+
+	id, err := p.CreateKey("metrics")
+	require.NoError(err)
+
+	defer func() {
+		err := p.DestroyKey(id)
+		require.NoError(err)
+	}()
+
+	key, err := p.OpenKey(id)
+	require.NoError(err)
+
+	hmacKey, ok := key.(PrivateKeyHMAC)
+	require.True(ok)
+
+	_, ok = key.(PrivateKeyDH)
+	require.True(ok)
+
+	_, err = hmacKey.HMAC([]byte("challenge"))
+	require.NoError(err)
+
+	_, err = p.OpenKey(KeyID("unknown"))
+	require.Error(err)
+
+	require.Equal([]string{
+		"create_key/File/success",
+		"open_key/File/success",
+		"hmac/File/success",
+		"open_key/File/error",
+	}, ops)
+}
+
+type auditFunc func(operation, key string, err error) error
+
+func (f auditFunc) Record(operation, key string, err error) error {
+	return f(operation, key, err)
+}
+
+func TestWithAudit(t *testing.T) {
+	require := require.New(t)
+
+	var records []string
+	var fail error
+
+	a := auditFunc(func(op, key string, err error) error {
+		records = append(records, op+"/"+key+"/"+string(metrics.OutcomeOf(err)))
+		return fail
+	})
+
+	fp, err := newFileProvider()
+	require.NoError(err)
+
+	p := WithAudit(fp, "File", a)
+
+	id, err := p.CreateKey("audit")
+	require.NoError(err)
+
+	defer func() {
+		err := p.DestroyKey(id)
+		require.NoError(err)
+	}()
+
+	key, err := p.OpenKey(id)
+	require.NoError(err)
+
+	_, err = key.(PrivateKeyHMAC).HMAC([]byte("challenge")) //nolint:forcetypeassert
+	require.NoError(err)
+
+	signer, err := key.(PrivateKeySigner).Signer() //nolint:forcetypeassert
+	require.NoError(err)
+
+	_, err = signer.Sign(nil, make([]byte, 32), crypto.SHA256)
+	require.NoError(err)
+
+	_, err = key.(PrivateKeyExportable).Credential() //nolint:forcetypeassert
+	require.NoError(err)
+
+	// Only key usage is recorded
+	uri := "File:" + id.String()
+	require.Equal([]string{
+		"hmac/" + uri + "/success",
+		"sign/" + uri + "/success",
+		"export/" + uri + "/success",
+	}, records)
+
+	// Operations fail if they can not be recorded
+	fail = errors.New("disk full") //nolint:err113
+
+	_, err = key.(PrivateKeyHMAC).HMAC([]byte("challenge")) //nolint:forcetypeassert
+	require.ErrorIs(err, fail)
+}
+
+func TestAuditSealer(t *testing.T) {
+	require := require.New(t)
+
+	fp, err := newFileProvider()
+	require.NoError(err)
+
+	id, err := fp.CreateKey("sealer")
+	require.NoError(err)
+
+	defer func() {
+		err := fp.DestroyKey(id)
+		require.NoError(err)
+	}()
+
+	buf := &bytes.Buffer{}
+	log := audit.New(buf)
+
+	p := &MultiProvider{
+		cfg: MultiProviderConfig{
+			Audit:       log,
+			AuditSealer: KeyRef{Provider: "File", ID: id}.String(),
+		},
+		providers: []Provider{WithAudit(fp, "File", log)},
+		names:     []string{"File"},
+	}
+
+	require.NoError(p.openAuditSealer())
+
+	key, err := p.OpenKey(id)
+	require.NoError(err)
+
+	signer, err := key.(PrivateKeySigner).Signer() //nolint:forcetypeassert
+	require.NoError(err)
+
+	_, err = key.(PrivateKeyHMAC).HMAC([]byte("challenge")) //nolint:forcetypeassert
+	require.NoError(err)
+
+	// Pending records are sealed on close
+	require.NoError(p.Close())
+
+	res, err := audit.Verify(bytes.NewReader(buf.Bytes()), signer.Public())
+	require.NoError(err)
+	require.Equal(uint64(1), res.Sealed)
+
+	// Auditors which can not be sealed are refused
+	p.cfg.Audit = auditFunc(func(string, string, error) error { return nil })
+	require.ErrorIs(p.openAuditSealer(), errors.ErrUnsupported)
+}
+
+// touchProvider opens keys whose token is never touched.
+type touchProvider struct {
+	Provider
+}
+
+type untouchedKey struct {
+	PrivateKeyHMAC
+}
+
+func (k *untouchedKey) HMAC([]byte) ([]byte, error) {
+	return nil, ErrTouchTimeout
+}
+
+func (p *touchProvider) OpenKey(id KeyID) (PrivateKey, error) {
+	key, err := p.Provider.OpenKey(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &untouchedKey{key.(PrivateKeyHMAC)}, nil //nolint:forcetypeassert
+}
+
+func TestWithEvents(t *testing.T) {
+	require := require.New(t)
+
+	fp, err := newFileProvider()
+	require.NoError(err)
+
+	bus := event.NewBus()
+	sub := bus.Subscribe(0)
+
+	p := WithEvents(&touchProvider{fp}, "YKOATH", bus)
+
+	id, err := p.CreateKey("events")
+	require.NoError(err)
+
+	defer func() {
+		err := p.DestroyKey(id)
+		require.NoError(err)
+	}()
+
+	key, err := p.OpenKey(id)
+	require.NoError(err)
+
+	_, err = key.(PrivateKeyHMAC).HMAC([]byte("challenge")) //nolint:forcetypeassert
+	require.ErrorIs(err, ErrTouchTimeout)
+
+	e := <-sub.C()
+	require.Equal(event.TouchTimeout, e.Type)
+	require.Equal("YKOATH", e.Provider)
+	require.Equal("YKOATH:"+id.String(), e.Key)
+	require.Empty(sub.C())
+}
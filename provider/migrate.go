@@ -0,0 +1,264 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"cunicu.li/hawkes/envelope"
+	"cunicu.li/hawkes/secret"
+)
+
+var (
+	ErrProviderNotFound = errors.New("provider not found")
+	ErrNoEscrow         = errors.New("no escrow for key")
+	ErrLossyMigration   = errors.New("destination does not support all operations of the key")
+)
+
+// SecretImporter is implemented by providers which can
+// create keys from existing secret key material.
+type SecretImporter interface {
+	Provider
+
+	// CreateKeyFromSecret creates a new key with the given label from a secret.
+	CreateKeyFromSecret(label string, secret []byte) (KeyID, error)
+}
+
+// MigrationPath describes how the key material of a migrated key was obtained.
+type MigrationPath string
+
+const (
+	// MigrationExport is used for keys whose secret is exportable.
+	MigrationExport MigrationPath = "export"
+
+	// MigrationEscrow is used for keys whose secret was recovered from an escrow or wrapped key envelope.
+	MigrationEscrow MigrationPath = "escrow"
+)
+
+// MigrateOptions configures a key migration.
+type MigrateOptions struct {
+	// Escrow are envelopes of type escrow or wrapped key which recover
+	// the secrets of keys which are not exportable.
+	// They are matched to the keys by the key ID of their hint.
+	Escrow []*envelope.Envelope
+
+	// EscrowKey opens the escrow envelopes (see envelope.Envelope.Open).
+	EscrowKey any
+
+	// AllowLoss confirms the migration of keys to a provider which does not
+	// support all of their operations, e.g. of File keys to YKOATH which
+	// only supports HMAC. Such keys are refused with ErrLossyMigration otherwise.
+	AllowLoss bool
+}
+
+// Migration is the result of the migration of a single key.
+type Migration struct {
+	Source      KeyRef        `json:"source"`
+	Destination *KeyRef       `json:"destination,omitempty"`
+	Path        MigrationPath `json:"path,omitempty"`
+	Error       string        `json:"error,omitempty"`
+
+	// Lost are the operations of the source key which
+	// are not supported by the destination (see MigrateOptions.AllowLoss).
+	Lost []string `json:"lost,omitempty"`
+
+	err error
+}
+
+// Err returns the reason why the key could not be migrated.
+func (m *Migration) Err() error {
+	return m.err
+}
+
+// IDChanged returns true if the migrated key has another ID than its source.
+// This is the case for most providers as they derive the IDs differently,
+// so that references to the key have to be updated.
+func (m *Migration) IDChanged() bool {
+	return m.Destination != nil && m.Destination.ID != nil && !bytes.Equal(m.Destination.ID, m.Source.ID)
+}
+
+// Provider returns the first provider with the given name.
+func (p *MultiProvider) Provider(name string) (Provider, error) {
+	for i, provider := range p.providers {
+		if p.names[i] == name {
+			return provider, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrProviderNotFound, name)
+}
+
+// Migrate re-provisions keys from one provider to the provider named dst.
+//
+// The source is either the name of a provider whose keys are all migrated or
+// a key URI (see ParseKeyURI). The secrets of exportable keys are copied directly.
+// Other keys are recovered from the escrow envelopes of the options.
+// The source keys are left untouched.
+//
+// Keys are only migrated if dst supports all of their operations unless
+// the loss has been confirmed via MigrateOptions.AllowLoss.
+// The migrated keys usually have a new ID (see Migration.IDChanged).
+//
+// A migration is returned for each source key. Keys which could not be moved
+// are reported with an error instead of aborting the migration of the remaining ones.
+func (p *MultiProvider) Migrate(src, dst string, opts *MigrateOptions) ([]Migration, error) {
+	if opts == nil {
+		opts = &MigrateOptions{}
+	}
+
+	dp, err := p.Provider(dst)
+	if err != nil {
+		return nil, err
+	}
+
+	si, ok := dp.(SecretImporter)
+	if !ok {
+		return nil, fmt.Errorf("%w: provider %s can not import keys", errors.ErrUnsupported, dst)
+	}
+
+	refs, err := p.migrationSources(src)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := []Migration{}
+
+	for _, ref := range refs {
+		m := Migration{
+			Source: ref,
+		}
+
+		if ref.Provider == dst {
+			m.err = fmt.Errorf("%w: key is already stored by %s", errors.ErrUnsupported, dst)
+		} else if id, err := p.migrate(&m, si, opts); err != nil {
+			m.err = err
+		} else {
+			m.Destination = &KeyRef{
+				Provider: dst,
+				ID:       id,
+			}
+		}
+
+		if m.err != nil {
+			m.Error = m.err.Error()
+		}
+
+		migrations = append(migrations, m)
+	}
+
+	return migrations, nil
+}
+
+// migrationSources resolves the source of a migration into key references.
+func (p *MultiProvider) migrationSources(src string) (refs []KeyRef, err error) {
+	if strings.Contains(src, ":") {
+		return ParseKeyURI(src)
+	}
+
+	sp, err := p.Provider(src)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := sp.Keys()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		refs = append(refs, KeyRef{
+			Provider: src,
+			ID:       id,
+		})
+	}
+
+	return refs, nil
+}
+
+func (p *MultiProvider) migrate(m *Migration, dst SecretImporter, opts *MigrateOptions) (KeyID, error) {
+	label, sk, err := p.recoverSecret(m, dst.Capabilities(), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if label == "" {
+		label = hex.EncodeToString(m.Source.ID)
+	}
+
+	id, err := dst.CreateKeyFromSecret(label, sk)
+	secret.Wipe(sk)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key: %w", err)
+	}
+
+	return id, nil
+}
+
+// recoverSecret returns the label and secret of a key, either by
+// exporting it or by opening its escrow envelope.
+// Keys with operations which are not supported by the destination are refused
+// before their secret is recovered unless the loss has been confirmed.
+func (p *MultiProvider) recoverSecret(m *Migration, caps Capabilities, opts *MigrateOptions) (string, []byte, error) {
+	ref := m.Source
+
+	key, err := p.openKey(ref.ID, ref.Provider)
+	if err != nil {
+		return "", nil, err
+	}
+	defer key.Close()
+
+	if m.Lost = lostOperations(key, caps); len(m.Lost) > 0 && !opts.AllowLoss {
+		return "", nil, fmt.Errorf("%w: %s", ErrLossyMigration, strings.Join(m.Lost, ", "))
+	}
+
+	if ek, ok := key.(PrivateKeyExportable); ok {
+		cred, err := ek.Credential()
+		if err == nil {
+			m.Path = MigrationExport
+			return cred.Account, cred.Secret, nil
+		} else if !errors.Is(err, ErrNotExportable) {
+			return "", nil, err
+		}
+	}
+
+	for _, env := range opts.Escrow {
+		if env.Hint == nil || !bytes.Equal(env.Hint.KeyID, ref.ID) ||
+			(env.Type != envelope.TypeEscrow && env.Type != envelope.TypeWrappedKey) {
+			continue
+		}
+
+		sk, err := env.Open(opts.EscrowKey)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to open escrow: %w", err)
+		}
+
+		m.Path = MigrationEscrow
+
+		return env.Hint.Label, sk, nil
+	}
+
+	return "", nil, fmt.Errorf("%w: %w", ErrNotExportable, ErrNoEscrow)
+}
+
+// lostOperations returns the operations of a key which are not supported by a provider.
+func lostOperations(key PrivateKey, caps Capabilities) (lost []string) {
+	if _, ok := key.(PrivateKeySigner); ok && !caps.Signing {
+		lost = append(lost, OperationSign)
+	}
+
+	if _, ok := key.(PrivateKeyDH); ok && !caps.DH {
+		lost = append(lost, OperationDH)
+	}
+
+	if _, ok := key.(PrivateKeyHMAC); ok && !caps.HMAC {
+		lost = append(lost, OperationHMAC)
+	}
+
+	return lost
+}
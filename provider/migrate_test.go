@@ -0,0 +1,163 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/envelope"
+)
+
+// sealedProvider hides the secrets of the keys of a provider like a hardware token.
+type sealedProvider struct {
+	Provider
+}
+
+type sealedKey struct {
+	PrivateKey
+}
+
+func (p *sealedProvider) OpenKey(id KeyID) (PrivateKey, error) {
+	key, err := p.Provider.OpenKey(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sealedKey{key}, nil
+}
+
+// hmacProvider only supports HMAC keys like the YKOATH provider.
+type hmacProvider struct {
+	*fileProvider
+}
+
+func (p *hmacProvider) Capabilities() Capabilities {
+	return Capabilities{
+		KeyTypes: []KeyType{KeyTypeHMACSHA256},
+		HMAC:     true,
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	require := require.New(t)
+
+	src := &fileProvider{keyDir: t.TempDir()}
+	hw := &fileProvider{keyDir: t.TempDir()}
+	dst := &fileProvider{keyDir: t.TempDir()}
+
+	secret1, err := generateSecret()
+	require.NoError(err)
+
+	id1, err := src.CreateKeyFromSecret("soft", secret1)
+	require.NoError(err)
+
+	secret2, err := generateSecret()
+	require.NoError(err)
+
+	id2, err := hw.CreateKeyFromSecret("escrowed", secret2)
+	require.NoError(err)
+
+	id3, err := hw.CreateKey("sealed")
+	require.NoError(err)
+
+	kek := make([]byte, 32)
+	_, err = rand.Read(kek)
+	require.NoError(err)
+
+	escrow, err := envelope.Wrap(kek, &envelope.Hint{
+		Provider: "Hardware",
+		KeyID:    id2,
+		Label:    "escrowed",
+	}, secret2)
+	require.NoError(err)
+
+	p := &MultiProvider{
+		providers: []Provider{src, &sealedProvider{hw}, dst},
+		names:     []string{"File", "Hardware", "Backup"},
+	}
+
+	_, err = p.Migrate("File", "Unknown", nil)
+	require.ErrorIs(err, ErrProviderNotFound)
+
+	// Exportable keys are copied
+	migrations, err := p.Migrate("File", "Backup", nil)
+	require.NoError(err)
+	require.Len(migrations, 1)
+	require.NoError(migrations[0].Err())
+	require.Equal(MigrationExport, migrations[0].Path)
+	require.Equal(KeyRef{"Backup", id1}, *migrations[0].Destination)
+
+	// Sealed keys require an escrow
+	migrations, err = p.Migrate(FormatKeyURI([]KeyRef{{"Hardware", id2}, {"Hardware", id3}}), "Backup", &MigrateOptions{
+		Escrow:    []*envelope.Envelope{escrow},
+		EscrowKey: kek,
+	})
+	require.NoError(err)
+	require.Len(migrations, 2)
+
+	require.NoError(migrations[0].Err())
+	require.Equal(MigrationEscrow, migrations[0].Path)
+	require.Equal(KeyRef{"Backup", id2}, *migrations[0].Destination)
+
+	require.ErrorIs(migrations[1].Err(), ErrNotExportable)
+	require.ErrorIs(migrations[1].Err(), ErrNoEscrow)
+	require.Nil(migrations[1].Destination)
+	require.NotEmpty(migrations[1].Error)
+
+	ids, err := dst.Keys()
+	require.NoError(err)
+	require.ElementsMatch([]KeyID{id1, id2}, ids)
+
+	// Keys are not migrated twice
+	migrations, err = p.Migrate("File", "Backup", nil)
+	require.NoError(err)
+	require.Len(migrations, 1)
+	require.Error(migrations[0].Err())
+
+	// Dry runs do not create keys
+	plan := &Plan{}
+	p.providers[2] = DryRun(dst, "Backup", plan)
+
+	migrations, err = p.Migrate("Hardware:"+id2.String(), "Backup", &MigrateOptions{
+		Escrow:    []*envelope.Envelope{escrow},
+		EscrowKey: kek,
+	})
+	require.NoError(err)
+	require.NoError(migrations[0].Err())
+	require.Len(plan.Actions(), 1)
+	require.Equal("create_key", plan.Actions()[0].Operation)
+
+	// Keys are refused if the destination does not support all of their operations
+	oath := &hmacProvider{&fileProvider{keyDir: t.TempDir()}}
+	p.providers[2] = oath
+
+	migrations, err = p.Migrate("File", "Backup", nil)
+	require.NoError(err)
+	require.ErrorIs(migrations[0].Err(), ErrLossyMigration)
+	require.ElementsMatch([]string{OperationSign, OperationDH}, migrations[0].Lost)
+	require.Nil(migrations[0].Destination)
+
+	ids, err = oath.Keys()
+	require.NoError(err)
+	require.Empty(ids)
+
+	// unless the loss is confirmed
+	migrations, err = p.Migrate("File", "Backup", &MigrateOptions{
+		AllowLoss: true,
+	})
+	require.NoError(err)
+	require.NoError(migrations[0].Err())
+	require.ElementsMatch([]string{OperationSign, OperationDH}, migrations[0].Lost)
+	require.False(migrations[0].IDChanged())
+
+	// The destination must be able to import keys
+	p.providers[2] = &sealedProvider{dst}
+
+	_, err = p.Migrate("File", "Backup", nil)
+	require.ErrorIs(err, errors.ErrUnsupported)
+}
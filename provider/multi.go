@@ -4,16 +4,27 @@
 package provider
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"log/slog"
 	"os"
+	"slices"
+	"time"
 
 	"cunicu.li/go-iso7816"
-	"cunicu.li/go-iso7816/drivers/pcsc"
 	"cunicu.li/go-iso7816/filter"
-	"github.com/ebfe/scard"
 	"github.com/google/go-tpm/tpm2/transport"
+
+	"cunicu.li/hawkes/broker"
+	"cunicu.li/hawkes/device"
+	"cunicu.li/hawkes/event"
+	"cunicu.li/hawkes/internal/queue"
+	"cunicu.li/hawkes/metrics"
+	"cunicu.li/hawkes/secret"
 )
 
 var _ Provider = (*MultiProvider)(nil)
@@ -24,6 +35,7 @@ var _ Provider = (*MultiProvider)(nil)
 //nolint:gochecknoglobals
 var (
 	providers = map[string]any{}
+	matchers  = map[string]device.Matcher{}
 )
 
 type (
@@ -39,16 +51,96 @@ type MultiProviderConfig struct {
 	TPMPaths    []string
 	FilterCards CardFilter
 	FilterTPMs  TPMFilter
+
+	// OperationTimeout limits the time an operation waits for a card
+	// which is busy with operations of other goroutines or providers.
+	// A zero value waits indefinitely.
+	OperationTimeout time.Duration
+
+	// Providers restricts the created providers to the given names.
+	// All registered providers are created if nil.
+	Providers []string
+
+	// PIN is used to unlock providers which are locked.
+	PIN PINFunc
+
+	// Unlock is invoked to unlock providers with the PIN.
+	// The providers are unlocked directly if nil.
+	Unlock UnlockFunc
+
+	// Broker is the socket path of a card broker.
+	// Cards are accessed via the broker if it is running.
+	Broker string
+
+	// IdleTimeout enables lazy connections to cards.
+	// Cards are connected on first use and disconnected
+	// after they have been idle for the given period.
+	// A zero value keeps cards connected.
+	IdleTimeout time.Duration
+
+	// Metrics is invoked for each operation of the providers and their keys.
+	Metrics metrics.Metrics
+
+	// Audit records the usage of keys (see WithAudit).
+	// Usage is not recorded if nil.
+	// It is closed together with the provider if it implements io.Closer.
+	Audit Auditor
+
+	// AuditSealer is the URI of a signing key which seals the records
+	// of the auditor (see SealedAuditor).
+	// Records are not sealed if empty.
+	AuditSealer string
+
+	// Devices restricts the discovered cards.
+	// It is applied in addition to FilterCards.
+	Devices device.Matcher
+
+	// ProviderDevices overrides the default matchers of card-based providers
+	// which decide on which cards a provider is created.
+	ProviderDevices map[string]device.Matcher
+
+	// KeepAlive enables periodic pings of connected cards which have
+	// been idle for the given period to keep their applets selected.
+	// A zero value disables the keep-alive.
+	KeepAlive time.Duration
+
+	// UnwrapPolicy restricts the opened keys to hardware whose attestation
+	// satisfies the policy (see RequireAttestation).
+	// Keys are not restricted if nil.
+	UnwrapPolicy *AttestationPolicy
+
+	// UsagePolicies limit the use of keys indexed by their ID (see LimitUsage).
+	UsagePolicies map[string]*UsagePolicy
+
+	// Confirm asks for the approval of operations which require a confirmation.
+	Confirm ConfirmFunc
+
+	// Events receives security-relevant notifications like failed PIN attempts,
+	// touch timeouts and keys refused by the UnwrapPolicy.
+	// Failed PIN attempts are only published if Unlock is nil,
+	// custom unlock functions like pin.Manager.Unlock publish their own.
+	// No events are published if nil.
+	Events event.Publisher
+
+	// DryRun records operations which would modify tokens in the plan
+	// instead of performing them (see DryRun).
+	// Operations are performed if nil.
+	DryRun *Plan
 }
 
 type MultiProvider struct {
 	cfg   MultiProviderConfig
-	scard *scard.Context
+	scard pcscContext
 
 	cards []iso7816.PCSCCard
 	tpms  []transport.TPMCloser
 
 	providers []Provider
+	names     []string
+
+	stopKeepAlives []func()
+
+	auditSealer PrivateKey
 }
 
 func NewProvider(cfg MultiProviderConfig) (p *MultiProvider, err error) {
@@ -56,7 +148,9 @@ func NewProvider(cfg MultiProviderConfig) (p *MultiProvider, err error) {
 		cfg: cfg,
 	}
 
-	if p.scard, err = scard.EstablishContext(); err != nil {
+	if p.scard, err = establishContext(); errors.Is(err, ErrNoSmartCards) {
+		slog.Debug("Smart cards are only accessible via the card broker", slog.Any("error", err))
+	} else if err != nil {
 		return nil, fmt.Errorf("failed to establish scard context: %w", err)
 	}
 
@@ -70,6 +164,10 @@ func NewProvider(cfg MultiProviderConfig) (p *MultiProvider, err error) {
 	}
 
 	for name, ctor := range providers {
+		if cfg.Providers != nil && !slices.Contains(cfg.Providers, name) {
+			continue
+		}
+
 		switch ctor := ctor.(type) {
 		case newProviderStd:
 			provider, err := ctor()
@@ -77,16 +175,26 @@ func NewProvider(cfg MultiProviderConfig) (p *MultiProvider, err error) {
 				return nil, fmt.Errorf("failed to create %s provider: %w", name, err)
 			}
 
-			p.providers = append(p.providers, provider)
+			if err := p.addProvider(name, provider); err != nil {
+				return nil, err
+			}
 
 		case newProviderCard:
 			for _, card := range p.cards {
+				if ok, err := p.matchCard(name, card); err != nil {
+					return nil, fmt.Errorf("failed to match card for %s provider: %w", name, err)
+				} else if !ok {
+					continue
+				}
+
 				provider, err := ctor(card)
 				if err != nil {
 					return nil, fmt.Errorf("failed to create %s provider: %w", name, err)
 				}
 
-				p.providers = append(p.providers, provider)
+				if err := p.addProvider(name, provider); err != nil {
+					return nil, err
+				}
 			}
 
 		case NewProviderTPM:
@@ -96,15 +204,156 @@ func NewProvider(cfg MultiProviderConfig) (p *MultiProvider, err error) {
 					return nil, fmt.Errorf("failed to create %s provider: %w", name, err)
 				}
 
-				p.providers = append(p.providers, provider)
+				if err := p.addProvider(name, provider); err != nil {
+					return nil, err
+				}
 			}
 		}
 	}
 
+	if cfg.KeepAlive > 0 {
+		for _, card := range p.cards {
+			p.stopKeepAlives = append(p.stopKeepAlives, queue.Of(card).KeepAlive(cfg.KeepAlive))
+		}
+	}
+
+	if cfg.AuditSealer != "" {
+		if err := p.openAuditSealer(); err != nil {
+			p.Close()
+			return nil, err
+		}
+	}
+
 	return p, nil
 }
 
+// openAuditSealer opens the key which seals the records of the auditor.
+func (p *MultiProvider) openAuditSealer() error {
+	sa, ok := p.cfg.Audit.(SealedAuditor)
+	if !ok {
+		return fmt.Errorf("%w: auditor can not be sealed", errors.ErrUnsupported)
+	}
+
+	key, err := p.OpenKeyURI(p.cfg.AuditSealer)
+	if err != nil {
+		return fmt.Errorf("failed to open audit sealer: %w", err)
+	}
+
+	sk, ok := key.(PrivateKeySigner)
+	if !ok {
+		key.Close()
+		return fmt.Errorf("%w: audit sealer can not sign", ErrUnsupportedKeyType)
+	}
+
+	signer, err := sk.Signer()
+	if err != nil {
+		key.Close()
+		return fmt.Errorf("failed to open audit sealer: %w", err)
+	}
+
+	sa.SetSealer(signer)
+	p.auditSealer = key
+
+	return nil
+}
+
+func (p *MultiProvider) addProvider(name string, provider Provider) error {
+	if lp, ok := provider.(LockableProvider); ok && lp.Locked() {
+		if p.cfg.PIN == nil {
+			return fmt.Errorf("%w: %s", ErrLocked, name)
+		}
+
+		pinBytes, err := p.cfg.PIN(name)
+		if err != nil {
+			return fmt.Errorf("failed to get PIN for %s provider: %w", name, err)
+		}
+
+		pin := secret.FromBytes(pinBytes)
+		defer pin.Destroy()
+
+		unlock := p.cfg.Unlock
+		if unlock == nil {
+			unlock = func(name string, lp LockableProvider, pin []byte) error {
+				err := lp.Unlock(pin)
+				if err != nil {
+					event.Publish(p.cfg.Events, event.Event{
+						Type:     event.PINFailed,
+						Provider: name,
+						Error:    err.Error(),
+					})
+				}
+
+				return err
+			}
+		}
+
+		if err := pin.Use(func(pin []byte) error {
+			return unlock(name, lp, pin)
+		}); err != nil {
+			return fmt.Errorf("failed to unlock %s provider: %w", name, err)
+		}
+	}
+
+	if p.cfg.Metrics != nil {
+		provider = WithMetrics(provider, name, p.cfg.Metrics)
+	}
+
+	if p.cfg.Audit != nil {
+		provider = WithAudit(provider, name, p.cfg.Audit)
+	}
+
+	if p.cfg.Events != nil {
+		provider = WithEvents(provider, name, p.cfg.Events)
+	}
+
+	if p.cfg.DryRun != nil {
+		provider = DryRun(provider, name, p.cfg.DryRun)
+	}
+
+	p.providers = append(p.providers, provider)
+	p.names = append(p.names, name)
+
+	return nil
+}
+
+// matchCard checks if the provider with the given name should be created for the card.
+func (p *MultiProvider) matchCard(name string, card iso7816.PCSCCard) (ok bool, err error) {
+	m, hasMatcher := p.cfg.ProviderDevices[name]
+	if !hasMatcher {
+		if m, hasMatcher = matchers[name]; !hasMatcher {
+			return true, nil
+		}
+	}
+
+	// Probing may require the card to be connected
+	err = queue.Of(card).Do(context.Background(), func(context.Context) (err error) {
+		ok, err = m.Match(card)
+		return err
+	})
+
+	return ok, err
+}
+
 func (p *MultiProvider) Close() error {
+	for _, stop := range p.stopKeepAlives {
+		stop()
+	}
+
+	// Pending records are sealed before the key of the sealer is closed
+	if c, ok := p.cfg.Audit.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			return err
+		}
+	}
+
+	if p.auditSealer != nil {
+		if err := p.auditSealer.Close(); err != nil {
+			return err
+		}
+
+		p.auditSealer = nil
+	}
+
 	for _, card := range p.cards {
 		if err := card.Close(); err != nil {
 			return err
@@ -133,6 +382,30 @@ func (p *MultiProvider) Keys() (allKeys []KeyID, err error) {
 	return allKeys, nil
 }
 
+// Capabilities returns the combined capabilities of all providers.
+func (p *MultiProvider) Capabilities() (c Capabilities) {
+	for i, provider := range p.providers {
+		if i == 0 {
+			c = provider.Capabilities()
+		} else {
+			c = c.Merge(provider.Capabilities())
+		}
+	}
+
+	return c
+}
+
+// ProviderFor returns the first provider which can create keys of the given type.
+func (p *MultiProvider) ProviderFor(kt KeyType) (Provider, error) {
+	for _, provider := range p.providers {
+		if provider.Capabilities().Supports(kt) {
+			return provider, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedKeyType, kt)
+}
+
 func (p *MultiProvider) CreateKey(_ /*label*/ string) (KeyID, error) {
 	return nil, errors.ErrUnsupported
 }
@@ -141,12 +414,147 @@ func (p *MultiProvider) DestroyKey(KeyID) error {
 	return errors.ErrUnsupported
 }
 
-func (p *MultiProvider) OpenKey(KeyID) (PrivateKey, error) {
-	return nil, errors.ErrUnsupported
+func (p *MultiProvider) OpenKey(id KeyID) (PrivateKey, error) {
+	key, err := p.openKey(id, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return p.restrict(id, key), nil
+}
+
+// OpenKeyRef opens a key of the providers with the referenced name.
+func (p *MultiProvider) OpenKeyRef(ref KeyRef) (PrivateKey, error) {
+	key, err := p.openKey(ref.ID, ref.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.restrict(ref.ID, key), nil
+}
+
+// OpenKeyURI opens the keys referenced by a URI (see ParseKeyURI).
+// Multiple references are combined into a failover key (see NewFailoverKey)
+// which is identified by the first reference.
+func (p *MultiProvider) OpenKeyURI(uri string) (PrivateKey, error) {
+	refs, err := ParseKeyURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(refs) == 1 {
+		return p.OpenKeyRef(refs[0])
+	}
+
+	openers := []KeyOpener{}
+	for _, ref := range refs {
+		openers = append(openers, func() (PrivateKey, error) {
+			return p.openKey(ref.ID, ref.Provider)
+		})
+	}
+
+	key, err := NewFailoverKey(refs[0].ID, openers...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Policies apply to the logical key
+	return p.restrict(refs[0].ID, key), nil
+}
+
+// openKey opens a key of the first provider with the given name which has it.
+// Any provider is considered if the name is empty.
+func (p *MultiProvider) openKey(id KeyID, name string) (PrivateKey, error) {
+	for i, provider := range p.providers {
+		if name != "" && p.names[i] != name {
+			continue
+		}
+
+		keys, err := provider.Keys()
+		if err != nil {
+			return nil, err
+		}
+
+		if !slices.ContainsFunc(keys, func(key KeyID) bool {
+			return bytes.Equal(key, id)
+		}) {
+			continue
+		}
+
+		return provider.OpenKey(id)
+	}
+
+	if name != "" {
+		return nil, fmt.Errorf("%w: %s:%s", ErrKeyNotFound, name, id)
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, id)
+}
+
+// restrict applies the usage and unwrap policies to a key.
+func (p *MultiProvider) restrict(id KeyID, key PrivateKey) PrivateKey {
+	if up, ok := p.cfg.UsagePolicies[id.String()]; ok {
+		key = LimitUsage(key, up, p.cfg.Confirm)
+	}
+
+	if p.cfg.UnwrapPolicy != nil {
+		key = requireAttestation(key, p.cfg.UnwrapPolicy, func(err error) {
+			if errors.Is(err, ErrAttestationPolicy) {
+				event.Publish(p.cfg.Events, event.Event{
+					Type:  event.AttestationMismatch,
+					Key:   id.String(),
+					Error: err.Error(),
+				})
+			}
+		})
+	}
+
+	return key
 }
 
 func (p *MultiProvider) openCards() (cards []iso7816.PCSCCard, err error) {
-	return pcsc.OpenCards(p.scard, 0, p.cfg.FilterCards, false)
+	flt := p.cardFilter()
+
+	brokered := false
+	if p.cfg.Broker != "" {
+		if cards, err = broker.OpenCards(p.cfg.Broker, flt); err == nil {
+			brokered = true
+		} else {
+			slog.Debug("Card broker is not available. Falling back to direct access",
+				slog.String("path", p.cfg.Broker),
+				slog.Any("error", err))
+		}
+	}
+
+	if !brokered {
+		if p.cfg.IdleTimeout > 0 {
+			return p.openLazyCards(flt)
+		}
+
+		if cards, err = p.openPCSCCards(flt); err != nil {
+			return nil, err
+		}
+	}
+
+	// All providers of a card share a single queue
+	for i, card := range cards {
+		cards[i] = queue.NewCard(card, p.cfg.OperationTimeout)
+	}
+
+	return cards, nil
+}
+
+func (p *MultiProvider) cardFilter() CardFilter {
+	flt := p.cfg.FilterCards
+	if flt == nil {
+		flt = filter.Any
+	}
+
+	if p.cfg.Devices != nil {
+		flt = filter.And(flt, p.cfg.Devices.Filter())
+	}
+
+	return flt
 }
 
 func (p *MultiProvider) openTPMs() (tpms []transport.TPMCloser, err error) {
@@ -170,7 +578,7 @@ func (p *MultiProvider) openTPMs() (tpms []transport.TPMCloser, err error) {
 				continue
 			}
 
-			tpm, err := transport.OpenTPM(tpmDevPath)
+			tpm, err := openTPM(tpmDevPath)
 			if err != nil {
 				return nil, err
 			}
@@ -205,3 +613,21 @@ func (p *MultiProvider) findTPMs() (tpmDevPaths []string) {
 func Register(name string, p any) {
 	providers[name] = p
 }
+
+// RegisterMatcher registers the default matcher which decides
+// on which cards a card-based provider is created.
+func RegisterMatcher(name string, m device.Matcher) {
+	matchers[name] = m
+}
+
+// Registered returns the sorted names of all registered providers.
+func Registered() []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	return names
+}
@@ -0,0 +1,132 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"cunicu.li/hawkes/oath"
+)
+
+// ImportCredentials stores OATH credentials in a provider, e.g. for migrating
+// from an authenticator app to a hardware token.
+// Credentials which already exist are skipped unless overwrite is true.
+// The import continues after failures and returns the number of stored credentials.
+func ImportCredentials(p OATHProvider, creds []*oath.Credential, overwrite bool) (imported int, err error) {
+	var errs []error
+
+	for _, cred := range creds {
+		if err := p.PutCredential(cred, overwrite); errors.Is(err, ErrCredentialExists) {
+			slog.Warn("Skipping existing credential", slog.String("name", cred.Name()))
+			continue
+		} else if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", cred.Name(), err))
+			continue
+		}
+
+		imported++
+	}
+
+	return imported, errors.Join(errs...)
+}
+
+// ExportOptions configures the export of OATH credentials.
+type ExportOptions struct {
+	// Keys also exports the secrets of keys which are used for key agreement or
+	// signatures, like those of the File provider. Their secret is the private key,
+	// so exporting it discloses the key to everyone who can read the export.
+	Keys bool
+}
+
+// ExportCredentials returns the OATH credentials of all keys of a provider
+// whose secrets can be recovered. Keys of hardware providers are skipped.
+// Keys which are also private keys for DH or signing are only exported if
+// requested by the options.
+func ExportCredentials(p Provider, opts *ExportOptions) (creds []*oath.Credential, err error) {
+	if opts == nil {
+		opts = &ExportOptions{}
+	}
+
+	ids, err := p.Keys()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		cred, err := exportCredential(p, id, opts)
+		if errors.Is(err, ErrNotExportable) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		creds = append(creds, cred)
+	}
+
+	return creds, nil
+}
+
+func exportCredential(p Provider, id KeyID, opts *ExportOptions) (*oath.Credential, error) {
+	key, err := p.OpenKey(id)
+	if err != nil {
+		return nil, err
+	}
+	defer key.Close()
+
+	ek, ok := key.(PrivateKeyExportable)
+	if !ok {
+		return nil, ErrNotExportable
+	}
+
+	if !opts.Keys {
+		_, isDH := key.(PrivateKeyDH)
+		_, isSigner := key.(PrivateKeySigner)
+
+		if isDH || isSigner {
+			return nil, fmt.Errorf("%w: secret is a private key", ErrNotExportable)
+		}
+	}
+
+	return ek.Credential()
+}
+
+// OATHProvider returns the first provider which can store OATH credentials.
+func (p *MultiProvider) OATHProvider() (OATHProvider, error) {
+	for _, provider := range p.providers {
+		if op, ok := provider.(OATHProvider); ok && provider.Capabilities().OATH {
+			return op, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: no provider supports OATH credentials", errors.ErrUnsupported)
+}
+
+// CalculateOCRA computes an OCRA response (RFC 6287) with a HMAC key of a provider.
+// The data input is passed as a raw challenge to the key, which for YKOATH
+// tokens is an untruncated CALCULATE. Hence only HMAC-SHA256 suites are
+// supported and tokens may reject data inputs which exceed their buffer size.
+func CalculateOCRA(key PrivateKey, suite *oath.OCRASuite, in *oath.OCRAInput) (string, error) {
+	hk, ok := key.(PrivateKeyHMAC)
+	if !ok {
+		return "", fmt.Errorf("%w: not a HMAC key", ErrUnsupportedKeyType)
+	}
+
+	if suite.Algorithm != oath.SHA256 {
+		return "", fmt.Errorf("%w: OCRA suite requires HMAC-%s", ErrUnsupportedKeyType, suite.Algorithm)
+	}
+
+	data, err := suite.DataInput(in)
+	if err != nil {
+		return "", err
+	}
+
+	mac, err := hk.HMAC(data)
+	if err != nil {
+		return "", err
+	}
+
+	return suite.Response(mac), nil
+}
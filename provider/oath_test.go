@@ -0,0 +1,135 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/metrics"
+	"cunicu.li/hawkes/oath"
+)
+
+type memoryOATHProvider struct {
+	Provider
+
+	creds map[string]*oath.Credential
+}
+
+func (p *memoryOATHProvider) Capabilities() Capabilities {
+	return Capabilities{OATH: true}
+}
+
+func (p *memoryOATHProvider) PutCredential(cred *oath.Credential, overwrite bool) error {
+	if err := cred.Validate(); err != nil {
+		return err
+	}
+
+	if _, ok := p.creds[cred.Name()]; ok && !overwrite {
+		return ErrCredentialExists
+	}
+
+	p.creds[cred.Name()] = cred
+
+	return nil
+}
+
+func TestImportCredentials(t *testing.T) {
+	require := require.New(t)
+
+	mp := &memoryOATHProvider{
+		creds: map[string]*oath.Credential{},
+	}
+
+	// Credentials must be forwarded through the metrics wrapper
+	p, ok := WithMetrics(mp, "memory", metrics.Func(func(string, string, time.Duration, metrics.Outcome) {})).(OATHProvider)
+	require.True(ok)
+
+	creds := []*oath.Credential{
+		{Account: "alice", Issuer: "Example", Secret: []byte("secret")},
+		{Account: "bob", Secret: []byte("secret"), Type: oath.HOTP},
+		{Account: "carol"}, // Missing secret
+	}
+
+	n, err := ImportCredentials(p, creds, false)
+	require.ErrorIs(err, oath.ErrInvalidCredential)
+	require.Equal(2, n)
+	require.Contains(mp.creds, "Example:alice")
+	require.Contains(mp.creds, "bob")
+
+	// Existing credentials are skipped
+	n, err = ImportCredentials(p, creds[:2], false)
+	require.NoError(err)
+	require.Equal(0, n)
+
+	n, err = ImportCredentials(p, creds[:2], true)
+	require.NoError(err)
+	require.Equal(2, n)
+
+	// Providers without OATH support
+	fp, err := newFileProvider()
+	require.NoError(err)
+
+	op, ok := WithMetrics(fp, "file", nil).(OATHProvider)
+	require.True(ok)
+	require.ErrorIs(op.PutCredential(creds[0], false), errors.ErrUnsupported)
+
+	cp, ok := op.(HOTPCounterProvider)
+	require.True(ok)
+	require.ErrorIs(cp.SetCounter(creds[1], 10), errors.ErrUnsupported)
+
+	tc, ok := op.(TOTPCalculator)
+	require.True(ok)
+
+	_, err = tc.CalculateWindow("bob", time.Now(), 1)
+	require.ErrorIs(err, errors.ErrUnsupported)
+
+	ol, ok := op.(OATHLister)
+	require.True(ok)
+
+	_, err = ol.Credentials()
+	require.ErrorIs(err, errors.ErrUnsupported)
+}
+
+func TestCalculateOCRA(t *testing.T) {
+	require := require.New(t)
+
+	p, err := newFileProvider()
+	require.NoError(err)
+
+	id, err := p.CreateKey("ocra")
+	require.NoError(err)
+
+	defer func() {
+		err := p.DestroyKey(id)
+		require.NoError(err)
+	}()
+
+	key, err := p.OpenKey(id)
+	require.NoError(err)
+
+	cred, err := key.(PrivateKeyExportable).Credential() //nolint:forcetypeassert
+	require.NoError(err)
+
+	suite, err := oath.ParseOCRASuite("OCRA-1:HOTP-SHA256-8:C-QN08-PSHA1")
+	require.NoError(err)
+
+	in := &oath.OCRAInput{Counter: 1, Question: "12345678", PIN: "1234"}
+
+	expected, err := cred.OCRA(suite, in)
+	require.NoError(err)
+
+	resp, err := CalculateOCRA(key, suite, in)
+	require.NoError(err)
+	require.Equal(expected, resp)
+
+	suite, err = oath.ParseOCRASuite("OCRA-1:HOTP-SHA1-6:QN08")
+	require.NoError(err)
+
+	_, err = CalculateOCRA(key, suite, in)
+	require.ErrorIs(err, ErrUnsupportedKeyType)
+}
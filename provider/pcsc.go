@@ -0,0 +1,262 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build (cgo || windows) && !nopcsc
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"cunicu.li/go-iso7816"
+	"cunicu.li/go-iso7816/drivers/pcsc"
+	"github.com/ebfe/scard"
+
+	"cunicu.li/hawkes/device"
+	"cunicu.li/hawkes/internal/queue"
+	"cunicu.li/hawkes/tap"
+)
+
+type pcscContext = *scard.Context
+
+// SmartCardSupport returns ErrNoSmartCards if this build can not access
+// smart cards via PC/SC directly. Brokered cards are available regardless.
+func SmartCardSupport() error {
+	return nil
+}
+
+// establishContext connects to the PC/SC service.
+// Windows only runs its smart card service while a reader is attached.
+// A missing service is hence treated like a system without readers.
+func establishContext() (pcscContext, error) {
+	ctx, err := scard.EstablishContext()
+	if isNoService(err) {
+		return nil, fmt.Errorf("%w: %w", ErrNoSmartCards, err)
+	}
+
+	return ctx, err
+}
+
+// openPCSCCards connects to the cards of all present readers which match the filter.
+// Readers which fail or do not respond in time are skipped (see connectReaders).
+func (p *MultiProvider) openPCSCCards(flt CardFilter) (cards []iso7816.PCSCCard, err error) {
+	readers, err := p.listReaders()
+	if err != nil {
+		return nil, err
+	}
+
+	_, cards = connectReaders(readers, p.connectCard, flt, device.DefaultProbeTimeout)
+
+	return cards, nil
+}
+
+// openLazyCards returns cards for all matching readers which are disconnected until first use.
+func (p *MultiProvider) openLazyCards(flt CardFilter) (cards []iso7816.PCSCCard, err error) {
+	readers, err := p.listReaders()
+	if err != nil {
+		return nil, err
+	}
+
+	// Connect once to apply the filter
+	readers, matched := connectReaders(readers, p.connectCard, flt, device.DefaultProbeTimeout)
+	for _, card := range matched {
+		if err := card.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, reader := range readers {
+		open := func() (iso7816.PCSCCard, error) {
+			return p.connectCard(reader)
+		}
+
+		cards = append(cards, queue.NewLazyCard(open, p.cfg.OperationTimeout, p.cfg.IdleTimeout))
+	}
+
+	return cards, nil
+}
+
+// listReaders returns the sorted names of all readers with a card.
+// The readers are probed in parallel by device.Devices so that
+// empty or wedged readers are skipped before connecting to them.
+func (p *MultiProvider) listReaders() (readers []string, err error) {
+	if p.scard == nil {
+		return nil, nil
+	}
+
+	devices, err := device.Devices(context.Background(), &device.DiscoverOptions{
+		Transports: []device.Transport{device.TransportPCSC},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list readers: %w", err)
+	}
+
+	for _, d := range devices {
+		if d.Error != nil {
+			slog.Warn("Skipping reader", slog.String("reader", d.Name), slog.Any("error", d.Error))
+		} else if d.ATR != nil {
+			readers = append(readers, d.Name)
+		}
+	}
+
+	// Make the list of returned cards deterministic
+	slices.Sort(readers)
+
+	return readers, nil
+}
+
+// connectCard connects to the card in a reader.
+// Cards are shared with other processes if the platform requires it
+// or another process already holds a shared connection.
+func (p *MultiProvider) connectCard(reader string) (iso7816.PCSCCard, error) {
+	shared := pcscShared
+
+	card, err := pcsc.NewCard(p.scard, reader, shared)
+	if !shared && errors.Is(err, scard.ErrSharingViolation) {
+		shared = true
+		card, err = pcsc.NewCard(p.scard, reader, shared)
+	}
+
+	if err != nil {
+		return nil, tap.Classify(err)
+	}
+
+	pc, ok := card.Base().(*pcsc.Card)
+	if !ok {
+		return card, nil
+	}
+
+	return &pcscCard{
+		Card:   pc,
+		shared: shared,
+	}, nil
+}
+
+func isNoService(err error) bool {
+	return errors.Is(err, scard.ErrNoService) || errors.Is(err, scard.ErrServiceStopped)
+}
+
+// isCardUnavailable checks whether an operation failed as the card or
+// its reader is absent or PC/SC is not running.
+func isCardUnavailable(err error) bool {
+	for _, target := range []error{
+		scard.ErrNoSmartcard,
+		scard.ErrRemovedCard,
+		scard.ErrUnpoweredCard,
+		scard.ErrUnresponsiveCard,
+		scard.ErrCommDataLost,
+		scard.ErrReaderUnavailable,
+		scard.ErrUnknownReader,
+		scard.ErrNoReadersAvailable,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return isNoService(err)
+}
+
+var _ queue.Locker = (*pcscCard)(nil)
+
+// pcscCard handles resets of cards and the sharing of cards with other processes.
+//
+// Shared cards are locked by a transaction for the duration of each operation.
+// Cards are reset by other processes or by Windows when the session gets locked.
+// The card is connected again and the reset is reported via queue.ErrReset
+// as its applets have been deselected.
+// Errors caused by a card leaving the field of a contactless reader
+// are marked as retryable (see tap.Classify).
+type pcscCard struct {
+	*pcsc.Card
+
+	shared bool
+	locked bool
+}
+
+func (c *pcscCard) Transmit(cmd []byte) ([]byte, error) {
+	resp, err := c.Card.Transmit(cmd)
+	if errors.Is(err, scard.ErrResetCard) {
+		if err := c.reconnect(); err != nil {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("%w: %w", queue.ErrReset, err)
+	}
+
+	return resp, tap.Classify(err)
+}
+
+func (c *pcscCard) Lock(context.Context) error {
+	if !c.shared {
+		return nil
+	}
+
+	if err := c.Card.BeginTransaction(); errors.Is(err, scard.ErrResetCard) {
+		if err := c.reconnect(); err != nil {
+			return err
+		}
+
+		if err := c.Card.BeginTransaction(); err != nil {
+			return err
+		}
+
+		c.locked = true
+
+		return fmt.Errorf("%w: %w", queue.ErrReset, err)
+	} else if err != nil {
+		return tap.Classify(err)
+	}
+
+	c.locked = true
+
+	return nil
+}
+
+func (c *pcscCard) Unlock() error {
+	if !c.locked {
+		return nil
+	}
+
+	c.locked = false
+
+	return c.Card.EndTransaction()
+}
+
+// Close disconnects from the card.
+// Shared cards are left untouched as other processes might still use them.
+func (c *pcscCard) Close() error {
+	if c.shared {
+		return c.Card.Card.Disconnect(scard.LeaveCard)
+	}
+
+	return c.Card.Close()
+}
+
+func (c *pcscCard) Base() iso7816.PCSCCard {
+	return c.Card
+}
+
+// reconnect acknowledges a reset of the card and restores a pending transaction.
+func (c *pcscCard) reconnect() error {
+	mode := scard.ShareExclusive
+	if c.shared {
+		mode = scard.ShareShared
+	}
+
+	if err := c.Card.Card.Reconnect(mode, scard.ProtocolAny, scard.LeaveCard); err != nil {
+		return fmt.Errorf("failed to reconnect after reset: %w", err)
+	}
+
+	if c.locked {
+		if err := c.Card.BeginTransaction(); err != nil {
+			return fmt.Errorf("failed to begin transaction after reset: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,38 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build (!cgo && !windows) || nopcsc
+
+package provider
+
+import (
+	"cunicu.li/go-iso7816"
+)
+
+// pcscContext is a placeholder as PC/SC requires cgo or pcsc-lite on most platforms.
+type pcscContext struct{}
+
+// SmartCardSupport returns ErrNoSmartCards if this build can not access
+// smart cards via PC/SC directly. Brokered cards are available regardless.
+func SmartCardSupport() error {
+	return ErrNoSmartCards
+}
+
+func establishContext() (pcscContext, error) {
+	return pcscContext{}, ErrNoSmartCards
+}
+
+// openPCSCCards finds no cards without PC/SC support.
+func (p *MultiProvider) openPCSCCards(CardFilter) ([]iso7816.PCSCCard, error) {
+	return nil, nil
+}
+
+// openLazyCards finds no cards without PC/SC support.
+func (p *MultiProvider) openLazyCards(CardFilter) ([]iso7816.PCSCCard, error) {
+	return nil, nil
+}
+
+// isCardUnavailable is always false as no cards are accessed via PC/SC.
+func isCardUnavailable(error) bool {
+	return false
+}
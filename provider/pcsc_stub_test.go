@@ -0,0 +1,32 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build (!cgo && !windows) || nopcsc
+
+package provider
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNoSmartCards(t *testing.T) {
+	require := require.New(t)
+
+	require.ErrorIs(SmartCardSupport(), ErrNoSmartCards)
+
+	p := &MultiProvider{}
+
+	_, err := establishContext()
+	require.ErrorIs(err, ErrNoSmartCards)
+
+	cards, err := p.openCards()
+	require.NoError(err)
+	require.Empty(cards)
+
+	p.cfg.IdleTimeout = 1
+	cards, err = p.openCards()
+	require.NoError(err)
+	require.Empty(cards)
+}
@@ -0,0 +1,9 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build cgo && !windows && !nopcsc
+
+package provider
+
+// pcscShared connects to cards exclusively unless another process holds a shared connection.
+const pcscShared = false
@@ -0,0 +1,10 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !nopcsc
+
+package provider
+
+// pcscShared connects to cards in shared mode as the certificate propagation
+// service and minidrivers of Windows keep connections to all cards.
+const pcscShared = true
@@ -5,13 +5,17 @@
 package provider
 
 import (
+	"crypto"
 	"crypto/sha256"
 	"encoding/base64"
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/katzenpost/nyquist/dh"
 
 	"cunicu.li/hawkes/ecdh"
+	"cunicu.li/hawkes/oath"
 )
 
 var (
@@ -20,6 +24,14 @@ var (
 	ErrUnsupportedCurve         = errors.New("unsupported curve")
 	ErrUnsupportedHashAlgorithm = errors.New("unsupported hash algorithm")
 	ErrUnsupportedProtocol      = errors.New("unsupported protocol")
+	ErrKeyNotFound              = errors.New("key not found")
+	ErrLocked                   = errors.New("provider is locked")
+	ErrWrongPIN                 = errors.New("wrong PIN or password")
+	ErrUnsupportedKeyType       = errors.New("unsupported key type")
+	ErrCredentialExists         = errors.New("credential already exists")
+	ErrNotExportable            = errors.New("key is not exportable")
+	ErrNoSmartCards             = errors.New("smart card support is not available in this build")
+	ErrTouchTimeout             = errors.New("token was not touched in time")
 )
 
 type KeyID []byte
@@ -28,6 +40,18 @@ func (i KeyID) String() string {
 	return base64.StdEncoding.EncodeToString(i)
 }
 
+func (i KeyID) MarshalText() ([]byte, error) {
+	return []byte(i.String()), nil
+}
+
+func (i *KeyID) UnmarshalText(text []byte) (err error) {
+	if *i, err = base64.StdEncoding.DecodeString(string(text)); err != nil {
+		return fmt.Errorf("%w: %w", ErrParse, err)
+	}
+
+	return nil
+}
+
 func keyID(sk dh.PublicKey) KeyID {
 	digest := sha256.New()
 	digest.Write(sk.Bytes())
@@ -46,6 +70,80 @@ type Provider interface {
 
 	// DestroyKey removes the cryptographic key material from the provider.
 	DestroyKey(KeyID) error
+
+	// Capabilities describes the features supported by the provider.
+	Capabilities() Capabilities
+}
+
+// OATHProvider is implemented by providers which can store
+// OATH credentials for use by authenticator applications.
+type OATHProvider interface {
+	Provider
+
+	// PutCredential stores an OATH credential.
+	// Existing credentials with the same name are only replaced if overwrite is true.
+	PutCredential(cred *oath.Credential, overwrite bool) error
+}
+
+// OATHLister is implemented by OATH providers which enumerate their stored credentials.
+type OATHLister interface {
+	OATHProvider
+
+	// Credentials returns the stored credentials without their secrets.
+	Credentials() ([]*oath.Credential, error)
+}
+
+// HOTPCounterProvider is implemented by OATH providers which
+// keep the moving factor of HOTP credentials on the token.
+type HOTPCounterProvider interface {
+	OATHProvider
+
+	// SetCounter re-aligns the counter of a stored HOTP credential.
+	SetCounter(cred *oath.Credential, counter uint64) error
+
+	// Counter returns the next counter value of a stored HOTP credential.
+	// The counter is determined by calculating a code which consumes one counter value.
+	Counter(cred *oath.Credential, window int) (uint64, error)
+}
+
+// TOTPCalculator is implemented by OATH providers which calculate
+// TOTP codes of stored credentials for arbitrary points in time.
+type TOTPCalculator interface {
+	OATHProvider
+
+	// Calculate returns the TOTP code of a stored credential for the given time.
+	Calculate(name string, at time.Time) (string, error)
+
+	// CalculateWindow returns the TOTP codes of a stored credential for the periods
+	// from skew steps before up to skew steps after the given time in chronological order.
+	CalculateWindow(name string, at time.Time, skew int) ([]string, error)
+}
+
+// PINFunc returns the PIN or password for unlocking the named provider.
+type PINFunc func(provider string) ([]byte, error)
+
+// LockableProvider is implemented by providers which
+// need to be unlocked with a PIN or password before use.
+type LockableProvider interface {
+	Provider
+
+	// Locked returns true if the provider must be unlocked before use.
+	Locked() bool
+
+	// Unlock unlocks the provider with the given PIN or password.
+	Unlock(pin []byte) error
+}
+
+// UnlockFunc unlocks the named provider with a PIN.
+// It allows to interpose policies like the tracking of failed attempts.
+type UnlockFunc func(name string, p LockableProvider, pin []byte) error
+
+// PINChanger is implemented by lockable providers whose PIN can be changed.
+type PINChanger interface {
+	LockableProvider
+
+	// ChangePIN replaces the PIN after verifying the old one.
+	ChangePIN(old, new []byte) error
 }
 
 type PrivateKey interface {
@@ -72,3 +170,19 @@ type PrivateKeyHMAC interface {
 
 	HMAC(challenge []byte) ([]byte, error)
 }
+
+type PrivateKeySigner interface {
+	PrivateKey
+
+	// Signer returns a signer for creating signatures with the key.
+	Signer() (crypto.Signer, error)
+}
+
+// PrivateKeyExportable is implemented by keys whose secret can be recovered,
+// i.e. those of software providers.
+type PrivateKeyExportable interface {
+	PrivateKey
+
+	// Credential returns the key as OATH credential.
+	Credential() (*oath.Credential, error)
+}
@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"cunicu.li/go-iso7816"
+)
+
+// connectReaders connects to the cards in all readers in parallel and returns
+// the readers and cards which match the filter in the order of the readers.
+//
+// Readers which fail or do not respond within the timeout are skipped so that
+// a single wedged reader does not prevent the use of the others.
+// Connections which succeed after the timeout are closed.
+func connectReaders(readers []string, connect func(string) (iso7816.PCSCCard, error), flt CardFilter, timeout time.Duration) (matched []string, cards []iso7816.PCSCCard) {
+	type result struct {
+		card iso7816.PCSCCard
+		err  error
+	}
+
+	results := make([]chan result, len(readers))
+
+	for i, reader := range readers {
+		results[i] = make(chan result, 1)
+
+		go func() {
+			card, err := connect(reader)
+			if err == nil {
+				if match, ferr := flt(card); ferr != nil || !match {
+					card.Close()
+					card, err = nil, ferr
+				}
+			}
+
+			results[i] <- result{card, err}
+		}()
+	}
+
+	// All readers are connected at once and hence share the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for i, reader := range readers {
+		r, ok := receive(ctx, results[i])
+		if !ok {
+			slog.Warn("Skipping reader", slog.String("reader", reader), slog.Any("error", ctx.Err()))
+
+			go func() {
+				if r := <-results[i]; r.card != nil {
+					r.card.Close()
+				}
+			}()
+
+			continue
+		}
+
+		if r.err != nil {
+			slog.Warn("Skipping reader", slog.String("reader", reader), slog.Any("error", r.err))
+		} else if r.card != nil {
+			matched = append(matched, reader)
+			cards = append(cards, r.card)
+		}
+	}
+
+	return matched, cards
+}
+
+// receive waits for a value until ctx is done.
+// Available values are preferred over an expired ctx.
+func receive[T any](ctx context.Context, ch <-chan T) (v T, ok bool) {
+	select {
+	case v = <-ch:
+		return v, true
+	default:
+	}
+
+	select {
+	case v = <-ch:
+		return v, true
+	case <-ctx.Done():
+		return v, false
+	}
+}
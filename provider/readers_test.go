@@ -0,0 +1,70 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cunicu.li/go-iso7816"
+	"github.com/stretchr/testify/require"
+)
+
+type readerCard struct {
+	reader string
+	closed atomic.Bool
+}
+
+func (c *readerCard) Transmit([]byte) ([]byte, error) { return []byte{0x90, 0x00}, nil }
+func (c *readerCard) BeginTransaction() error         { return nil }
+func (c *readerCard) EndTransaction() error           { return nil }
+func (c *readerCard) Base() iso7816.PCSCCard          { return c }
+
+func (c *readerCard) Close() error {
+	c.closed.Store(true)
+	return nil
+}
+
+func TestConnectReaders(t *testing.T) {
+	require := require.New(t)
+
+	wedged := make(chan struct{})
+	late := &readerCard{reader: "wedged"}
+	opened := map[string]*readerCard{}
+
+	for _, reader := range []string{"a", "other", "b"} {
+		opened[reader] = &readerCard{reader: reader}
+	}
+
+	connect := func(reader string) (iso7816.PCSCCard, error) {
+		switch reader {
+		case "failing":
+			return nil, errors.New("failed to connect") //nolint:err113
+		case "wedged":
+			<-wedged
+			return late, nil
+		default:
+			return opened[reader], nil
+		}
+	}
+
+	flt := func(card iso7816.PCSCCard) (bool, error) {
+		return card.(*readerCard).reader != "other", nil //nolint:forcetypeassert
+	}
+
+	readers, cards := connectReaders([]string{"a", "failing", "wedged", "other", "b"}, connect, flt, 50*time.Millisecond)
+	require.Equal([]string{"a", "b"}, readers)
+	require.Equal([]iso7816.PCSCCard{opened["a"], opened["b"]}, cards)
+
+	// Cards which do not match are closed
+	require.True(opened["other"].closed.Load())
+	require.False(opened["a"].closed.Load())
+
+	// Cards which are connected too late are closed
+	close(wedged)
+
+	require.Eventually(late.closed.Load, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,14 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+
+package provider
+
+import (
+	"github.com/google/go-tpm/tpm2/transport"
+)
+
+func openTPM(path string) (transport.TPMCloser, error) {
+	return transport.OpenTPM(path)
+}
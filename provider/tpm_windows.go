@@ -0,0 +1,16 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/go-tpm/tpm2/transport"
+)
+
+// openTPM fails as Windows provides access to the TPM only via TBS rather than device paths.
+func openTPM(path string) (transport.TPMCloser, error) {
+	return nil, fmt.Errorf("%w: TPM device %s", errors.ErrUnsupported, path)
+}
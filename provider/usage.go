@@ -0,0 +1,423 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/katzenpost/nyquist/dh"
+
+	"cunicu.li/hawkes/expr"
+	"cunicu.li/hawkes/piv"
+	"cunicu.li/hawkes/timesync"
+)
+
+var (
+	ErrRateLimited  = errors.New("key usage rate exceeded")
+	ErrNotConfirmed = errors.New("key usage has not been confirmed")
+	ErrDenied       = errors.New("key usage denied by policy expression")
+)
+
+// Operations of keys which are subject to usage policies.
+const (
+	OperationSign = "sign"
+	OperationHMAC = "hmac"
+	OperationDH   = "dh"
+)
+
+// ConfirmFunc asks the user to approve an operation of a key.
+// It returns an error if the operation has been rejected.
+type ConfirmFunc func(operation string, key KeyID) error
+
+// UsagePolicy limits the use of a key so that a compromised client
+// can not silently drain signatures or derivations from a token.
+// A policy must be shared by all handles of a key as it tracks their usage.
+type UsagePolicy struct {
+	// MaxPerMinute limits the number of operations within any minute.
+	// A zero value does not limit the rate.
+	MaxPerMinute int
+
+	// Confirm lists the operations which require an interactive confirmation.
+	Confirm []string
+
+	// Expression must evaluate to true for an operation to be allowed.
+	// It is evaluated before any confirmation with the variables:
+	//
+	//   - operation: the operation ("sign", "hmac" or "dh")
+	//   - key: the ID of the key
+	//   - peer: the ID of the public key of the peer for "dh", empty otherwise
+	//   - time: the local time as map of hour, minute, weekday (0 is Sunday) and unix
+	//   - attested: true if the attestation of the key has been verified against the anchors
+	//   - attestation: the model, firmware, serial and touch_policy of an attested key
+	//
+	// Keys are only attested if the expression refers to their attestation.
+	// Errors during the evaluation deny the operation.
+	Expression *expr.Program
+
+	// Anchors are the trust anchors against which attestations are verified.
+	// Without roots, no key is considered attested.
+	Anchors piv.VerifyOptions
+
+	// Clock is the time source. Defaults to timesync.System.
+	Clock timesync.Clock
+
+	mu   sync.Mutex
+	uses []time.Time
+}
+
+// allow checks the policy and accounts an operation of a key.
+// The peer is only known for key agreements.
+func (p *UsagePolicy) allow(op string, key PrivateKey, peer dh.PublicKey, confirm ConfirmFunc) error {
+	return p.allowN(op, 1, key, peer, confirm)
+}
+
+// Allow checks the policy and accounts an operation of a key which has not
+// been opened, e.g. one which the card broker recognized in the commands of its clients.
+// As it can not be attested, expressions referring to its attestation consider it not attested.
+func (p *UsagePolicy) Allow(op string, id KeyID, confirm ConfirmFunc) error {
+	return p.allow(op, keyRef(id), nil, confirm)
+}
+
+// keyRef is a key of which only the ID is known.
+type keyRef KeyID
+
+func (k keyRef) ID() KeyID               { return KeyID(k) }
+func (k keyRef) Details() map[string]any { return nil }
+func (k keyRef) Close() error            { return nil }
+
+// allowN checks the policy once and accounts n operations of a key.
+// The operations are refused altogether if they would exceed the rate.
+// The rate is checked before asking for a confirmation, so that users
+// do not approve operations which are refused afterwards.
+func (p *UsagePolicy) allowN(op string, n int, key PrivateKey, peer dh.PublicKey, confirm ConfirmFunc) error {
+	now := timesync.Now(p.Clock)
+
+	if p.Expression != nil {
+		if err := p.evaluate(op, key, peer, now); err != nil {
+			return err
+		}
+	}
+
+	if err := p.reserve(n, now); err != nil {
+		return err
+	}
+
+	if slices.Contains(p.Confirm, op) {
+		if confirm == nil {
+			p.release(n, now)
+			return fmt.Errorf("%w: no confirmation prompt for %s", ErrNotConfirmed, op)
+		}
+
+		if err := confirm(op, key.ID()); err != nil {
+			p.release(n, now)
+			return fmt.Errorf("%w: %w", ErrNotConfirmed, err)
+		}
+	}
+
+	return nil
+}
+
+// reserve accounts n operations if they do not exceed the rate.
+func (p *UsagePolicy) reserve(n int, now time.Time) error {
+	if p.MaxPerMinute <= 0 {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Forget operations which dropped out of the sliding window
+	p.uses = slices.DeleteFunc(p.uses, func(t time.Time) bool {
+		return now.Sub(t) >= time.Minute
+	})
+
+	if len(p.uses)+n > p.MaxPerMinute {
+		return fmt.Errorf("%w: %d operations per minute", ErrRateLimited, p.MaxPerMinute)
+	}
+
+	for range n {
+		p.uses = append(p.uses, now)
+	}
+
+	return nil
+}
+
+// release returns n operations reserved at the given time which have not been confirmed.
+func (p *UsagePolicy) release(n int, now time.Time) {
+	if p.MaxPerMinute <= 0 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i := len(p.uses) - 1; i >= 0 && n > 0; i-- {
+		if p.uses[i].Equal(now) {
+			p.uses = slices.Delete(p.uses, i, i+1)
+			n--
+		}
+	}
+}
+
+// evaluate checks an operation against the expression of the policy.
+func (p *UsagePolicy) evaluate(op string, key PrivateKey, peer dh.PublicKey, now time.Time) error {
+	vars := map[string]any{
+		"operation": op,
+		"key":       key.ID().String(),
+		"peer":      "",
+		"time": map[string]any{
+			"hour":    now.Hour(),
+			"minute":  now.Minute(),
+			"weekday": int(now.Weekday()),
+			"unix":    now.Unix(),
+		},
+	}
+
+	if peer != nil {
+		vars["peer"] = keyID(peer).String()
+	}
+
+	if p.Expression.Uses("attested") || p.Expression.Uses("attestation") {
+		attestation := map[string]any{}
+
+		if a, err := attest(key, p.Anchors); err == nil {
+			attestation["model"] = a.Model
+			attestation["firmware"] = a.Firmware.String()
+			attestation["serial"] = int64(a.Serial)
+			attestation["touch_policy"] = string(a.TouchPolicy)
+		}
+
+		vars["attested"] = len(attestation) > 0
+		vars["attestation"] = attestation
+	}
+
+	ok, err := p.Expression.Bool(vars)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDenied, err)
+	} else if !ok {
+		return fmt.Errorf("%w: %s", ErrDenied, p.Expression)
+	}
+
+	return nil
+}
+
+// LimitUsage wraps a key so that its signatures, key agreements and
+// HMAC calculations are refused if they violate the policy.
+// The wrapper only implements the interfaces of the operations which the key supports.
+func LimitUsage(key PrivateKey, policy *UsagePolicy, confirm ConfirmFunc) PrivateKey {
+	lk := &limitedKey{
+		PrivateKey: key,
+		policy:     policy,
+		confirm:    confirm,
+	}
+
+	s, h, d, a := limitedSigning{lk}, limitedHMAC{lk}, limitedDH{lk}, limitedAttest{lk}
+
+	_, isSigner := key.(PrivateKeySigner)
+	_, isHMAC := key.(PrivateKeyHMAC)
+	_, isDH := key.(PrivateKeyDH)
+	_, isAttester := key.(PrivateKeyAttester)
+
+	switch {
+	case isSigner && isHMAC && isDH && isAttester:
+		return &struct {
+			*limitedKey
+			limitedSigning
+			limitedHMAC
+			limitedDH
+			limitedAttest
+		}{lk, s, h, d, a}
+	case isSigner && isHMAC && isDH:
+		return &struct {
+			*limitedKey
+			limitedSigning
+			limitedHMAC
+			limitedDH
+		}{lk, s, h, d}
+	case isSigner && isHMAC && isAttester:
+		return &struct {
+			*limitedKey
+			limitedSigning
+			limitedHMAC
+			limitedAttest
+		}{lk, s, h, a}
+	case isSigner && isDH && isAttester:
+		return &struct {
+			*limitedKey
+			limitedSigning
+			limitedDH
+			limitedAttest
+		}{lk, s, d, a}
+	case isHMAC && isDH && isAttester:
+		return &struct {
+			*limitedKey
+			limitedHMAC
+			limitedDH
+			limitedAttest
+		}{lk, h, d, a}
+	case isSigner && isHMAC:
+		return &struct {
+			*limitedKey
+			limitedSigning
+			limitedHMAC
+		}{lk, s, h}
+	case isSigner && isDH:
+		return &struct {
+			*limitedKey
+			limitedSigning
+			limitedDH
+		}{lk, s, d}
+	case isSigner && isAttester:
+		return &struct {
+			*limitedKey
+			limitedSigning
+			limitedAttest
+		}{lk, s, a}
+	case isHMAC && isDH:
+		return &struct {
+			*limitedKey
+			limitedHMAC
+			limitedDH
+		}{lk, h, d}
+	case isHMAC && isAttester:
+		return &struct {
+			*limitedKey
+			limitedHMAC
+			limitedAttest
+		}{lk, h, a}
+	case isDH && isAttester:
+		return &struct {
+			*limitedKey
+			limitedDH
+			limitedAttest
+		}{lk, d, a}
+	case isSigner:
+		return &struct {
+			*limitedKey
+			limitedSigning
+		}{lk, s}
+	case isHMAC:
+		return &struct {
+			*limitedKey
+			limitedHMAC
+		}{lk, h}
+	case isDH:
+		return &struct {
+			*limitedKey
+			limitedDH
+		}{lk, d}
+	case isAttester:
+		return &struct {
+			*limitedKey
+			limitedAttest
+		}{lk, a}
+	default:
+		return lk
+	}
+}
+
+type limitedKey struct {
+	PrivateKey
+
+	policy  *UsagePolicy
+	confirm ConfirmFunc
+}
+
+func (k *limitedKey) allow(op string, peer dh.PublicKey) error {
+	return k.policy.allow(op, k.PrivateKey, peer, k.confirm)
+}
+
+// limitedSigning implements PrivateKeySigner and PrivateKeyBatchSigner for signing keys.
+type limitedSigning struct{ key *limitedKey }
+
+// Signer returns a limited signer.
+func (k limitedSigning) Signer() (crypto.Signer, error) {
+	signer, err := k.key.PrivateKey.(PrivateKeySigner).Signer() //nolint:forcetypeassert
+	if err != nil {
+		return nil, err
+	}
+
+	return &limitedSigner{
+		Signer: signer,
+		key:    k.key,
+	}, nil
+}
+
+// SignBatch signs all digests after a single check and confirmation of the policy.
+// Each signature is accounted for the rate limit. Invalid digests are refused
+// before the policy is checked.
+func (k limitedSigning) SignBatch(ctx context.Context, digests [][]byte, opts crypto.SignerOpts) ([][]byte, error) {
+	if err := checkDigests(digests, opts); err != nil {
+		return nil, err
+	}
+
+	if err := k.key.policy.allowN(OperationSign, len(digests), k.key.PrivateKey, nil, k.key.confirm); err != nil {
+		return nil, err
+	}
+
+	return SignBatch(ctx, k.key.PrivateKey, digests, opts)
+}
+
+// limitedHMAC implements PrivateKeyHMAC and PrivateKeyBatchHMAC for HMAC keys.
+type limitedHMAC struct{ key *limitedKey }
+
+func (k limitedHMAC) HMAC(challenge []byte) ([]byte, error) {
+	if err := k.key.allow(OperationHMAC, nil); err != nil {
+		return nil, err
+	}
+
+	return k.key.PrivateKey.(PrivateKeyHMAC).HMAC(challenge) //nolint:forcetypeassert
+}
+
+// HMACBatch calculates all HMACs after a single check and confirmation of the policy.
+func (k limitedHMAC) HMACBatch(ctx context.Context, challenges [][]byte) ([][]byte, error) {
+	if err := k.key.policy.allowN(OperationHMAC, len(challenges), k.key.PrivateKey, nil, k.key.confirm); err != nil {
+		return nil, err
+	}
+
+	return HMACKeyBatch(ctx, k.key.PrivateKey, challenges)
+}
+
+// limitedDH implements PrivateKeyDH for key agreement keys.
+type limitedDH struct{ key *limitedKey }
+
+func (k limitedDH) DH(pk dh.PublicKey) ([]byte, error) {
+	if err := k.key.allow(OperationDH, pk); err != nil {
+		return nil, err
+	}
+
+	return k.key.PrivateKey.(PrivateKeyDH).DH(pk) //nolint:forcetypeassert
+}
+
+func (k limitedDH) Public() dh.PublicKey {
+	return k.key.PrivateKey.(PrivateKeyDH).Public() //nolint:forcetypeassert
+}
+
+// limitedAttest implements PrivateKeyAttester for keys which can be attested.
+type limitedAttest struct{ key *limitedKey }
+
+func (k limitedAttest) Attest() (*Attestation, error) {
+	return k.key.PrivateKey.(PrivateKeyAttester).Attest() //nolint:forcetypeassert
+}
+
+type limitedSigner struct {
+	crypto.Signer
+
+	key *limitedKey
+}
+
+func (s *limitedSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if err := s.key.allow(OperationSign, nil); err != nil {
+		return nil, err
+	}
+
+	return s.Signer.Sign(rand, digest, opts)
+}
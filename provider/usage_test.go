@@ -0,0 +1,220 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"crypto"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/expr"
+	"cunicu.li/hawkes/timesync"
+)
+
+func TestLimitUsage(t *testing.T) {
+	require := require.New(t)
+
+	p, err := newFileProvider()
+	require.NoError(err)
+
+	id, err := p.CreateKey("usage")
+	require.NoError(err)
+
+	defer func() {
+		err := p.DestroyKey(id)
+		require.NoError(err)
+	}()
+
+	key, err := p.OpenKey(id)
+	require.NoError(err)
+
+	now := time.Unix(1700000000, 0)
+	policy := &UsagePolicy{
+		MaxPerMinute: 2,
+		Confirm:      []string{OperationSign},
+		Clock:        timesync.ClockFunc(func() time.Time { return now }),
+	}
+
+	var confirmed []string
+	approve := true
+
+	lk := LimitUsage(key, policy, func(op string, kid KeyID) error {
+		require.Equal(id, kid)
+		confirmed = append(confirmed, op)
+
+		if !approve {
+			return errors.New("rejected") //nolint:err113
+		}
+
+		return nil
+	})
+
+	hk, ok := lk.(PrivateKeyHMAC)
+	require.True(ok)
+
+	_, ok = lk.(PrivateKeyDH)
+	require.True(ok)
+
+	for range 2 {
+		_, err = hk.HMAC([]byte("challenge"))
+		require.NoError(err)
+	}
+
+	_, err = hk.HMAC([]byte("challenge"))
+	require.ErrorIs(err, ErrRateLimited)
+
+	// The window slides
+	now = now.Add(time.Minute)
+
+	signer, err := lk.(PrivateKeySigner).Signer() //nolint:forcetypeassert
+	require.NoError(err)
+
+	_, err = signer.Sign(nil, make([]byte, 32), crypto.SHA256)
+	require.NoError(err)
+
+	approve = false
+
+	_, err = signer.Sign(nil, make([]byte, 32), crypto.SHA256)
+	require.ErrorIs(err, ErrNotConfirmed)
+	require.Equal([]string{OperationSign, OperationSign}, confirmed)
+
+	// Rejected operations are not accounted
+	_, err = hk.HMAC([]byte("challenge"))
+	require.NoError(err)
+
+	// Operations exceeding the rate are refused before asking for a confirmation
+	approve = true
+
+	_, err = signer.Sign(nil, make([]byte, 32), crypto.SHA256)
+	require.ErrorIs(err, ErrRateLimited)
+	require.Len(confirmed, 2)
+
+	// Operations requiring confirmation are refused without a prompt
+	_, err = LimitUsage(key, &UsagePolicy{
+		Confirm: []string{OperationHMAC},
+	}, nil).(PrivateKeyHMAC).HMAC([]byte("challenge")) //nolint:forcetypeassert
+	require.ErrorIs(err, ErrNotConfirmed)
+}
+
+func TestUsageExpression(t *testing.T) {
+	require := require.New(t)
+
+	p, err := newFileProvider()
+	require.NoError(err)
+
+	ids := []KeyID{}
+	for _, label := range []string{"expression", "peer-a", "peer-b"} {
+		id, err := p.CreateKey(label)
+		require.NoError(err)
+
+		ids = append(ids, id)
+	}
+
+	defer func() {
+		for _, id := range ids {
+			err := p.DestroyKey(id)
+			require.NoError(err)
+		}
+	}()
+
+	keys := []PrivateKeyDH{}
+	for _, id := range ids {
+		key, err := p.OpenKey(id)
+		require.NoError(err)
+
+		keys = append(keys, key.(PrivateKeyDH)) //nolint:forcetypeassert
+	}
+
+	now := time.Date(2024, 1, 1, 9, 0, 0, 0, time.Local)
+
+	prog, err := expr.Compile(`operation == "hmac" || (peer == "` + ids[1].String() + `" && time.hour < 18 && !attested)`)
+	require.NoError(err)
+
+	lk := LimitUsage(keys[0], &UsagePolicy{
+		Expression: prog,
+		Clock:      timesync.ClockFunc(func() time.Time { return now }),
+	}, nil)
+
+	_, err = lk.(PrivateKeyHMAC).HMAC([]byte("challenge")) //nolint:forcetypeassert
+	require.NoError(err)
+
+	// Only the allowed peer may trigger a key agreement
+	_, err = lk.(PrivateKeyDH).DH(keys[1].Public()) //nolint:forcetypeassert
+	require.NoError(err)
+
+	_, err = lk.(PrivateKeyDH).DH(keys[2].Public()) //nolint:forcetypeassert
+	require.ErrorIs(err, ErrDenied)
+
+	// Outside of office hours
+	now = now.Add(10 * time.Hour)
+
+	_, err = lk.(PrivateKeyDH).DH(keys[1].Public()) //nolint:forcetypeassert
+	require.ErrorIs(err, ErrDenied)
+
+	// Evaluation errors deny the operation
+	prog, err = expr.Compile(`unknown`)
+	require.NoError(err)
+
+	_, err = LimitUsage(keys[0], &UsagePolicy{
+		Expression: prog,
+	}, nil).(PrivateKeyHMAC).HMAC([]byte("challenge")) //nolint:forcetypeassert
+	require.ErrorIs(err, ErrDenied)
+	require.ErrorIs(err, expr.ErrUnknown)
+}
+
+// hmacOnlyKey only calculates HMACs like the keys of the YKOATH provider.
+type hmacOnlyKey struct {
+	PrivateKeyHMAC
+}
+
+func TestLimitUsageCapabilities(t *testing.T) {
+	require := require.New(t)
+
+	p := &fileProvider{keyDir: t.TempDir()}
+
+	id, err := p.CreateKey("usage")
+	require.NoError(err)
+
+	key, err := p.OpenKey(id)
+	require.NoError(err)
+
+	defer key.Close()
+
+	lk := LimitUsage(hmacOnlyKey{key.(PrivateKeyHMAC)}, &UsagePolicy{}, nil) //nolint:forcetypeassert
+
+	_, ok := lk.(PrivateKeyHMAC)
+	require.True(ok)
+
+	_, ok = lk.(PrivateKeyBatchHMAC)
+	require.True(ok)
+
+	_, ok = lk.(PrivateKeySigner)
+	require.False(ok)
+
+	_, ok = lk.(PrivateKeyBatchSigner)
+	require.False(ok)
+
+	_, ok = lk.(PrivateKeyDH)
+	require.False(ok)
+
+	_, ok = lk.(PrivateKeyAttester)
+	require.False(ok)
+
+	lk = LimitUsage(key, &UsagePolicy{}, nil)
+
+	_, ok = lk.(PrivateKeySigner)
+	require.True(ok)
+
+	_, ok = lk.(PrivateKeyBatchSigner)
+	require.True(ok)
+
+	_, ok = lk.(PrivateKeyDH)
+	require.True(ok)
+
+	_, ok = lk.(PrivateKeyAttester)
+	require.False(ok)
+}
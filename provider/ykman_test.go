@@ -0,0 +1,282 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build ykman && (cgo || windows) && !nopcsc
+
+// The interoperability tests provision OATH credentials with this package and
+// verify that ykman(1) sees them identically and vice versa.
+// They require ykman and a YubiKey whose OATH applet is reset by the tests.
+//
+//	go test -tags ykman -run TestYkman ./provider
+//
+// The YubiKey is selected by its serial in HAWKES_YKMAN_DEVICE if multiple are connected.
+
+package provider
+
+import (
+	"bytes"
+	"encoding/base32"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"cunicu.li/go-iso7816/drivers/pcsc"
+	"cunicu.li/go-iso7816/filter"
+	"github.com/ebfe/scard"
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/oath"
+)
+
+// interopCredentials cover the encodings of names, algorithms and flags
+// which have to be handled identically by all tools.
+//
+//nolint:gochecknoglobals
+var interopCredentials = []*oath.Credential{
+	{
+		Type:      oath.TOTP,
+		Algorithm: oath.SHA1,
+		Issuer:    "Example.org",
+		Account:   "alice.smith@example.com",
+		Digits:    6,
+		Period:    30 * time.Second,
+	},
+	{
+		Type:      oath.TOTP,
+		Algorithm: oath.SHA256,
+		Issuer:    "ACME Co.",
+		Account:   "bob",
+		Digits:    8,
+		Period:    30 * time.Second,
+	},
+	{
+		Type:      oath.TOTP,
+		Algorithm: oath.SHA1,
+		Issuer:    "Period",
+		Account:   "carol.c",
+		Digits:    6,
+		Period:    60 * time.Second,
+	},
+	{
+		Type:      oath.HOTP,
+		Algorithm: oath.SHA256,
+		Issuer:    "Counter",
+		Account:   "dave.d",
+		Digits:    6,
+	},
+	{
+		Type:      oath.TOTP,
+		Algorithm: oath.SHA256,
+		Issuer:    "Touch",
+		Account:   "erin",
+		Digits:    6,
+		Period:    30 * time.Second,
+		Touch:     true,
+	},
+}
+
+func TestYkmanInterop(t *testing.T) {
+	if _, err := exec.LookPath("ykman"); err != nil {
+		t.Skip("ykman is not installed")
+	}
+
+	for _, cred := range interopCredentials {
+		cred.Secret = []byte("12345678901234567890" + cred.Account)
+	}
+
+	t.Run("hawkes-to-ykman", func(t *testing.T) {
+		require := require.New(t)
+
+		ykman(t, "oath", "reset", "--force")
+
+		withYubiKey(t, func(p *ykoathProvider) {
+			for _, cred := range interopCredentials {
+				require.NoError(p.PutCredential(cred, false), cred.Name())
+			}
+		})
+
+		// ykman lists the issuer and account without the period prefix
+		expected := []string{}
+		for _, cred := range interopCredentials {
+			line := cred.Issuer + ":" + cred.Account + ", " + strings.ToUpper(string(cred.Type))
+			if cred.Type == oath.TOTP {
+				line += fmt.Sprintf(", %d", int(cred.Period.Seconds()))
+			}
+
+			expected = append(expected, line)
+		}
+
+		lines := []string{}
+		for _, line := range ykmanLines(t, "oath", "accounts", "list", "--oath-type", "--period") {
+			// The period of HOTP credentials is meaningless
+			if strings.Contains(line, ", HOTP, ") {
+				line, _, _ = strings.Cut(line, ", HOTP, ")
+				line += ", HOTP"
+			}
+
+			lines = append(lines, line)
+		}
+
+		require.ElementsMatch(expected, lines)
+
+		codes := ykmanCodes(t)
+		now := time.Now()
+
+		for _, cred := range interopCredentials {
+			name := cred.Issuer + ":" + cred.Account
+			code, ok := codes[name]
+			require.True(ok, name)
+
+			switch {
+			case cred.Touch:
+				require.Equal("[Requires Touch]", code, name)
+
+			case cred.Type == oath.HOTP:
+				require.Equal("[HOTP Account]", code, name)
+
+			default:
+				// Tolerate a period boundary between ykman and this test
+				expected, err := cred.TOTPWindow(now, 1)
+				require.NoError(err)
+				require.Contains(expected, code, name)
+			}
+		}
+	})
+
+	t.Run("ykman-to-hawkes", func(t *testing.T) {
+		require := require.New(t)
+
+		ykman(t, "oath", "reset", "--force")
+
+		for _, cred := range interopCredentials {
+			args := []string{
+				"oath", "accounts", "add", "--force",
+				"--oath-type", strings.ToUpper(string(cred.Type)),
+				"--algorithm", string(cred.Algorithm),
+				"--digits", fmt.Sprint(cred.Digits),
+				"--issuer", cred.Issuer,
+			}
+
+			if cred.Type == oath.TOTP {
+				args = append(args, "--period", fmt.Sprint(int(cred.Period.Seconds())))
+			}
+
+			if cred.Touch {
+				args = append(args, "--touch")
+			}
+
+			args = append(args, cred.Account, base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(cred.Secret))
+
+			ykman(t, args...)
+		}
+
+		withYubiKey(t, func(p *ykoathProvider) {
+			creds, err := p.Credentials()
+			require.NoError(err)
+			require.Len(creds, len(interopCredentials))
+
+			for _, expected := range interopCredentials {
+				idx := slices.IndexFunc(creds, func(c *oath.Credential) bool {
+					return c.Name() == expected.Name()
+				})
+				require.GreaterOrEqual(idx, 0, expected.Name())
+
+				cred := creds[idx]
+				require.Equal(expected.Type, cred.Type, expected.Name())
+				require.Equal(expected.Algorithm, cred.Algorithm, expected.Name())
+				require.Equal(expected.Issuer, cred.Issuer, expected.Name())
+				require.Equal(expected.Account, cred.Account, expected.Name())
+
+				if expected.Type != oath.TOTP {
+					continue
+				}
+
+				require.Equal(expected.Period, cred.Period, expected.Name())
+				require.Equal(expected.Touch, cred.Touch, expected.Name())
+
+				if expected.Touch {
+					continue
+				}
+
+				now := time.Now()
+
+				code, err := p.Calculate(expected.Name(), now)
+				require.NoError(err)
+
+				codes, err := expected.TOTPWindow(now, 0)
+				require.NoError(err)
+				require.Equal(codes[0], code, expected.Name())
+			}
+		})
+	})
+}
+
+// withYubiKey opens the OATH provider of the first YubiKey and closes
+// it afterwards so that ykman can access the YubiKey in between.
+func withYubiKey(t *testing.T, cb func(p *ykoathProvider)) {
+	require := require.New(t)
+
+	sc, err := scard.EstablishContext()
+	require.NoError(err)
+
+	defer sc.Release() //nolint:errcheck
+
+	card, err := pcsc.OpenFirstCard(sc, filter.IsYubiKey, true)
+	require.NoError(err)
+
+	defer card.Close()
+
+	p, err := newYKOATHProvider(card)
+	require.NoError(err)
+
+	ykp, ok := p.(*ykoathProvider)
+	require.True(ok)
+
+	cb(ykp)
+}
+
+func ykman(t *testing.T, args ...string) string {
+	if serial, ok := os.LookupEnv("HAWKES_YKMAN_DEVICE"); ok {
+		args = append([]string{"--device", serial}, args...)
+	}
+
+	stderr := &bytes.Buffer{}
+
+	cmd := exec.Command("ykman", args...)
+	cmd.Stderr = stderr
+
+	out, err := cmd.Output()
+	require.NoError(t, err, "ykman %s: %s", strings.Join(args, " "), stderr)
+
+	return string(out)
+}
+
+func ykmanLines(t *testing.T, args ...string) (lines []string) {
+	for _, line := range strings.Split(ykman(t, args...), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
+
+// ykmanCodes returns the codes listed by ykman indexed by the credential names.
+// Credentials without a code are listed with a note like "[Requires Touch]".
+func ykmanCodes(t *testing.T) map[string]string {
+	codes := map[string]string{}
+
+	for _, line := range ykmanLines(t, "oath", "accounts", "code") {
+		if i := strings.LastIndex(line, "  ["); i >= 0 {
+			codes[strings.TrimSpace(line[:i])] = line[i+2:]
+		} else if i := strings.LastIndex(line, " "); i >= 0 {
+			codes[strings.TrimSpace(line[:i])] = line[i+1:]
+		}
+	}
+
+	return codes
+}
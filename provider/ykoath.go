@@ -5,26 +5,63 @@ package provider
 
 import (
 	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
 	"crypto/rand"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"math"
 	"os"
+	"slices"
+	"time"
 
 	"cunicu.li/go-iso7816"
+	"cunicu.li/go-iso7816/encoding/tlv"
 	"cunicu.li/go-ykoath/v2"
+	"golang.org/x/crypto/pbkdf2"
+
+	"cunicu.li/hawkes/device"
+	"cunicu.li/hawkes/internal/queue"
+	"cunicu.li/hawkes/oath"
+	"cunicu.li/hawkes/piv"
+	"cunicu.li/hawkes/secret"
 )
 
-var _ PrivateKeyHMAC = (*ykoathKey)(nil)
+var (
+	_ PrivateKeyHMAC     = (*ykoathKey)(nil)
+	_ PrivateKeyAttester = (*ykoathKey)(nil)
+)
 
 const idChallenge = "hawkes/v1"
 
+const (
+	ykoathInsSetCode      iso7816.Instruction = 0x03
+	ykoathInsValidate     iso7816.Instruction = 0xA3
+	ykoathInsCalculateAll iso7816.Instruction = 0xA4
+
+	ykoathTagName      tlv.Tag = 0x71
+	ykoathTagKey       tlv.Tag = 0x73
+	ykoathTagChallenge tlv.Tag = 0x74
+	ykoathTagResponse  tlv.Tag = 0x75
+	ykoathTagTouch     tlv.Tag = 0x7c
+)
+
 type ykoathKey struct {
 	provider *ykoathProvider
 	name     string
 }
 
-func (k *ykoathKey) ID() KeyID {
-	id, _ := k.provider.keyID(k.name)
+func (k *ykoathKey) ID() (id KeyID) {
+	_ = k.provider.do(func() (err error) {
+		id, err = k.provider.keyID(k.name)
+		return err
+	})
+
 	return id
 }
 
@@ -36,82 +73,720 @@ func (k *ykoathKey) Details() map[string]any {
 	return map[string]any{}
 }
 
-func (k *ykoathKey) HMAC(chal []byte) ([]byte, error) {
-	secret, _, err := k.provider.CalculateChallengeResponse(k.name, chal)
+// Attest proves that the key is held by a genuine YubiKey.
+// OATH credentials can not be attested themselves. Instead, the attestation of the
+// key in the PIV card authentication slot is read together with the certificate of
+// the attestation key of the token. Within the same card transaction, the token
+// must sign a random challenge with the attested key so that certificates replayed
+// from another token are rejected. The touch policy is reported by the OATH applet
+// in the same transaction.
+// Tokens without a generated key in the card authentication slot can not be attested.
+func (k *ykoathKey) Attest() (a *Attestation, err error) {
+	a = &Attestation{
+		TouchPolicy: PolicyNever,
+	}
+
+	if err := k.provider.do(func() error {
+		// Only CALCULATE ALL reports whether credentials require touch
+		_, touch, err := k.provider.calculateAll([]byte(idChallenge))
+		if err != nil {
+			return err
+		}
+
+		if slices.Contains(touch, k.name) {
+			a.TouchPolicy = PolicyAlways
+		}
+
+		// Selecting the PIV applet deselects the OATH applet
+		defer k.provider.closeSession()
+
+		a.Certificates, err = attestPIV(k.provider.card)
+
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// attestPIV returns the certificates of the key in the PIV card authentication slot
+// and of the attestation key after the card proved the possession of the attested key.
+func attestPIV(card iso7816.PCSCCard) ([][]byte, error) {
+	pc, err := piv.NewCard(card)
 	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotAttested, err)
+	}
+
+	device, err := pc.AttestationCertificate()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotAttested, err)
+	}
+
+	slot, err := pc.Attest(piv.SlotCardAuthentication)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotAttested, err)
+	}
+
+	pk, ok := slot.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: card authentication key is no EC key", ErrNotAttested)
+	}
+
+	var alg piv.Algorithm
+	switch pk.Curve {
+	case elliptic.P256():
+		alg = piv.AlgECCP256
+	case elliptic.P384():
+		alg = piv.AlgECCP384
+	default:
+		return nil, fmt.Errorf("%w: unsupported curve %s", ErrNotAttested, pk.Curve.Params().Name)
+	}
+
+	challenge := make([]byte, (pk.Curve.Params().BitSize+7)/8)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+
+	sig, err := pc.SignECDSA(piv.SlotCardAuthentication, alg, challenge)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotAttested, err)
+	}
+
+	if !ecdsa.VerifyASN1(pk, challenge, sig) {
+		return nil, fmt.Errorf("%w: token does not hold the attested key", ErrNotAttested)
+	}
+
+	return [][]byte{slot.Raw, device.Raw}, nil
+}
+
+func (k *ykoathKey) HMAC(chal []byte) (secret []byte, err error) {
+	if err := k.provider.do(func() (err error) {
+		secret, _, err = k.provider.CalculateChallengeResponse(k.name, chal)
+		return err
+	}); isTouchTimeout(err) {
+		return nil, fmt.Errorf("%w: %w", ErrTouchTimeout, err)
+	} else if err != nil {
 		return nil, err
 	}
 
 	return secret, nil
 }
 
-var _ Provider = (*ykoathProvider)(nil)
+var (
+	_ LockableProvider    = (*ykoathProvider)(nil)
+	_ PINChanger          = (*ykoathProvider)(nil)
+	_ OATHProvider        = (*ykoathProvider)(nil)
+	_ HOTPCounterProvider = (*ykoathProvider)(nil)
+	_ TOTPCalculator      = (*ykoathProvider)(nil)
+	_ OATHLister          = (*ykoathProvider)(nil)
+	_ Pinger              = (*ykoathProvider)(nil)
+)
 
 type ykoathProvider struct {
 	*ykoath.Card
+
+	card   iso7816.PCSCCard
+	queue  *queue.Queue
+	locked bool
+
+	// key is the access key derived from the password which is retained
+	// to validate again after the applet was reset, deselected or reconnected.
+	// The password itself is never retained.
+	key *secret.Buffer
+
+	version iso7816.Version
 }
 
 func newYKOATHProvider(card iso7816.PCSCCard) (Provider, error) {
-	ykoathCard, err := ykoath.NewCard(card)
+	p := &ykoathProvider{
+		card:  card,
+		queue: queue.Of(card),
+	}
+
+	queue.OnDisconnect(card, p.closeSession)
+	queue.OnReset(card, p.closeSession)
+	queue.OnKeepAlive(card, p.ping)
+
+	// Lazy cards are connected on first use
+	if !queue.IsLazy(card) {
+		if err := p.do(func() error { return nil }); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// do runs fn as a single operation in the queue of the card.
+func (p *ykoathProvider) do(fn func() error) error {
+	return p.queue.Do(context.Background(), func(context.Context) error {
+		if err := p.openSession(); err != nil {
+			return err
+		}
+
+		err := fn()
+
+		// The applet loses its authentication if the card has been reset
+		// or the applet was selected by another application in the meantime.
+		if errors.Is(err, queue.ErrReset) || (p.key != nil && isAuthRequired(err)) {
+			slog.Debug("Selecting YKOATH applet again after losing its session", slog.Any("error", err))
+
+			p.closeSession()
+
+			if err := p.openSession(); err != nil {
+				return err
+			}
+
+			err = fn()
+		}
+
+		return err
+	})
+}
+
+func (p *ykoathProvider) openSession() (err error) {
+	if p.Card != nil {
+		return nil
+	}
+
+	card, err := ykoath.NewCard(p.card)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	sel, err := ykoathCard.Select()
+	sel, err := card.Select()
 	if err != nil {
-		return nil, fmt.Errorf("failed to select app: %w", err)
+		return fmt.Errorf("failed to select app: %w", err)
+	}
+
+	p.version = iso7816.Version{
+		Major: int(sel.Version[0]),
+		Minor: int(sel.Version[1]),
+		Patch: int(sel.Version[2]),
 	}
 
 	slog.Debug("Selected YKOATH applet",
-		slog.String("version", fmt.Sprintf("%d.%d.%d", sel.Version[0], sel.Version[1], sel.Version[2])))
+		slog.String("version", p.version.String()))
 
-	return &ykoathProvider{
-		Card: ykoathCard,
-	}, nil
+	// The applet only includes a challenge if it is password protected
+	p.locked = len(sel.Challenge) > 0
+
+	if p.locked && p.key != nil {
+		if err := p.key.Use(func(key []byte) error {
+			return validateAccessKey(card, key)
+		}); err != nil {
+			return fmt.Errorf("failed to unlock after reconnect: %w", err)
+		}
+
+		p.locked = false
+	}
+
+	p.Card = card
+
+	return nil
+}
+
+// closeSession is called before a lazy card gets disconnected.
+func (p *ykoathProvider) closeSession() {
+	if p.Card != nil {
+		_ = p.Card.Close()
+		p.Card = nil
+	}
+}
+
+// ping keeps the applet selected by listing the credentials.
+// If the applet has been deselected, the session is closed
+// so that the next operation selects it again.
+func (p *ykoathProvider) ping() {
+	if p.Card == nil || p.locked {
+		return
+	}
+
+	if _, err := p.List(); err != nil {
+		slog.Debug("Keep-alive of YKOATH applet failed", slog.Any("error", err))
+		p.closeSession()
+	}
+}
+
+// Ping selects the YKOATH applet again and validates
+// the retained access key if the applet is password protected.
+func (p *ykoathProvider) Ping(ctx context.Context) error {
+	return p.queue.Do(ctx, func(context.Context) error {
+		p.closeSession()
+		return p.openSession()
+	})
+}
+
+func (p *ykoathProvider) Capabilities() Capabilities {
+	// Lazy cards must be connected to determine the version
+	_ = p.do(func() error { return nil })
+
+	maxKeys := 32
+	if p.version.Major > 5 || (p.version.Major == 5 && p.version.Minor >= 7) {
+		maxKeys = 64
+	}
+
+	return Capabilities{
+		KeyTypes:      []KeyType{KeyTypeHMACSHA256},
+		HMAC:          true,
+		OATH:          true,
+		Hardware:      true,
+		TouchPolicies: []Policy{PolicyNever, PolicyAlways},
+		PINPolicies:   []Policy{PolicyNever, PolicyOnce},
+		MaxKeys:       maxKeys,
+	}
+}
+
+func (p *ykoathProvider) Locked() bool {
+	// Lazy cards must be connected to determine the state
+	_ = p.do(func() error { return nil })
+
+	return p.locked
+}
+
+func (p *ykoathProvider) Unlock(pin []byte) error {
+	return p.do(func() error {
+		sel, err := p.Select()
+		if err != nil {
+			return err
+		}
+
+		key := accessKey(sel, pin)
+
+		if err := validateAccessKey(p.Card, key); err != nil {
+			return err
+		}
+
+		p.locked = false
+		p.setKey(key)
+
+		return nil
+	})
+}
+
+// setKey retains the access key to validate again after the applet lost its authentication.
+func (p *ykoathProvider) setKey(key []byte) {
+	if p.key != nil {
+		p.key.Destroy()
+		p.key = nil
+	}
+
+	if len(key) > 0 {
+		p.key = secret.FromBytes(key)
+	}
+}
+
+// accessKey derives the access key of the applet from its password.
+func accessKey(sel *ykoath.Select, pin []byte) []byte {
+	alg := ykoath.HmacSha1
+	if len(sel.Algorithm) > 0 {
+		alg = ykoath.Algorithm(sel.Algorithm[0])
+	}
+
+	return pbkdf2.Key(pin, sel.Name, 1000, 16, alg.Hash())
+}
+
+// validateAccessKey authenticates to the applet with the access key
+// and checks the response of the applet to our own challenge.
+// Unlike ykoath.Card.Validate it does not require the password.
+func validateAccessKey(card *ykoath.Card, key []byte) error {
+	sel, err := card.Select()
+	if err != nil {
+		return fmt.Errorf("failed to select app: %w", err)
+	}
+
+	// Applets without password do not send a challenge
+	if len(sel.Challenge) == 0 {
+		return nil
+	} else if len(sel.Algorithm) < 1 {
+		return ykoath.ErrNoSuchObject
+	}
+
+	alg := ykoath.Algorithm(sel.Algorithm[0])
+
+	mac := hmac.New(alg.Hash(), key)
+	mac.Write(sel.Challenge)
+	resp := mac.Sum(nil)
+
+	chal := make([]byte, 8)
+	if _, err := rand.Read(chal); err != nil {
+		return fmt.Errorf("failed to generate challenge: %w", err)
+	}
+
+	data, err := tlv.EncodeSimple(
+		tlv.New(ykoathTagResponse, resp),
+		tlv.New(ykoathTagChallenge, chal),
+	)
+	if err != nil {
+		return err
+	}
+
+	buf, err := card.Send(&iso7816.CAPDU{
+		Ins:  ykoathInsValidate,
+		Data: data,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to validate: %w", wrongPIN(err))
+	}
+
+	tvs, err := tlv.DecodeSimple(buf)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrParse, err)
+	}
+
+	mac.Reset()
+	mac.Write(chal)
+
+	if tv, _, ok := tvs.Get(ykoathTagResponse); !ok || !hmac.Equal(tv, mac.Sum(nil)) {
+		return ykoath.ErrResponseDoesNotMatch
+	}
+
+	return nil
+}
+
+// wrongPIN marks the rejection of a password by the applet as ErrWrongPIN.
+func wrongPIN(err error) error {
+	if errors.Is(err, ykoath.ErrResponseDoesNotMatch) || errors.Is(err, iso7816.Code(ykoath.ErrResponseDoesNotMatch)) {
+		return fmt.Errorf("%w: %w", ErrWrongPIN, err)
+	}
+
+	return err
+}
+
+// isAuthRequired checks whether an operation failed as the applet is not authenticated.
+func isAuthRequired(err error) bool {
+	return errors.Is(err, ykoath.ErrAuthRequired) || errors.Is(err, iso7816.ErrSecurityStatusNotSatisfied)
+}
+
+// isTouchTimeout checks whether a calculation failed as a credential requiring touch was not touched in time.
+func isTouchTimeout(err error) bool {
+	return errors.Is(err, ykoath.Error(iso7816.ErrConditionsOfUseNotSatisfied)) || errors.Is(err, iso7816.ErrConditionsOfUseNotSatisfied)
+}
+
+// ChangePIN sets a new password for the applet or removes it if new is empty.
+// SET CODE is sent directly as ykoath.Card.SetCode re-selects the applet
+// which discards the authentication with the old password.
+func (p *ykoathProvider) ChangePIN(old, new []byte) error {
+	return p.do(func() error {
+		sel, err := p.Select()
+		if err != nil {
+			return err
+		}
+
+		if len(sel.Challenge) > 0 {
+			if err := p.Validate(old); err != nil {
+				return wrongPIN(err)
+			}
+		}
+
+		tvs := []tlv.TagValue{tlv.New(ykoathTagKey)}
+
+		var key []byte
+
+		if len(new) > 0 {
+			alg := ykoath.HmacSha1
+			key = pbkdf2.Key(new, sel.Name, 1000, 16, alg.Hash())
+
+			chal := make([]byte, 8)
+			if _, err := rand.Read(chal); err != nil {
+				return fmt.Errorf("failed to generate challenge: %w", err)
+			}
+
+			mac := hmac.New(alg.Hash(), key)
+			mac.Write(chal)
+
+			tvs = []tlv.TagValue{
+				tlv.New(ykoathTagKey, []byte{byte(alg)}, key),
+				tlv.New(ykoathTagChallenge, chal),
+				tlv.New(ykoathTagResponse, mac.Sum(nil)),
+			}
+		}
+
+		data, err := tlv.EncodeSimple(tvs...)
+		if err != nil {
+			return err
+		}
+
+		if _, err := p.Send(&iso7816.CAPDU{
+			Ins:  ykoathInsSetCode,
+			Data: data,
+		}); err != nil {
+			return fmt.Errorf("failed to set code: %w", err)
+		}
+
+		p.locked = false
+		p.setKey(key)
+
+		return nil
+	})
 }
 
 func (p *ykoathProvider) Keys() (keyIDs []KeyID, err error) {
+	err = p.do(func() (err error) {
+		keyIDs, err = p.keys()
+		return err
+	})
+
+	return keyIDs, err
+}
+
+func (p *ykoathProvider) keys() (keyIDs []KeyID, err error) {
+	ids, err := p.keyIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(ids)) {
+		keyIDs = append(keyIDs, ids[name])
+	}
+
+	return keyIDs, nil
+}
+
+// keyIDs returns the IDs of the hawkes keys by their credential names.
+// Hawkes keys are TOTP credentials with HMAC-SHA256 which do not require touch.
+// Other credentials, e.g. imported HOTP or touch credentials of authenticators,
+// are never calculated as this would advance their counters or wait for a touch.
+func (p *ykoathProvider) keyIDs() (map[string]KeyID, error) {
 	slots, err := p.List()
 	if err != nil {
 		return nil, err
 	}
 
+	// CALCULATE ALL only calculates TOTP credentials which do not require touch
+	codes, _, err := p.calculateAll([]byte(idChallenge))
+	if err != nil {
+		return nil, err
+	}
+
+	ids := map[string]KeyID{}
+
 	for _, slot := range slots {
-		if slot.Algorithm != ykoath.HmacSha256 {
+		if slot.Algorithm != ykoath.HmacSha256 || slot.Type != ykoath.Totp {
 			continue
 		}
 
-		keyID, err := p.keyID(slot.Name)
+		if code, ok := codes[slot.Name]; ok {
+			ids[slot.Name] = code
+		}
+	}
+
+	return ids, nil
+}
+
+func (p *ykoathProvider) DestroyKey(id KeyID) error {
+	return p.do(func() error {
+		name, err := p.nameByID(id)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		keyIDs = append(keyIDs, keyID)
+		return p.Delete(name)
+	})
+}
+
+func (p *ykoathProvider) CreateKeyFromSecret(label string, secret []byte) (id KeyID, err error) {
+	if err := p.do(func() (err error) {
+		if err := p.Put(label, ykoath.HmacSha256, ykoath.Totp, 6, secret, false, 0); err != nil {
+			return err
+		}
+
+		id, err = p.keyID(label)
+		return err
+	}); err != nil {
+		return nil, err
 	}
 
-	return keyIDs, nil
+	return id, nil
 }
 
-func (p *ykoathProvider) DestroyKey(id KeyID) error {
-	name, err := p.nameByID(id)
-	if err != nil {
+func (p *ykoathProvider) PutCredential(cred *oath.Credential, overwrite bool) error {
+	if err := cred.Validate(); err != nil {
 		return err
 	}
 
-	return p.Delete(name)
+	name := cred.Name()
+
+	return p.do(func() error {
+		if !overwrite {
+			slots, err := p.List()
+			if err != nil {
+				return err
+			}
+
+			if slices.ContainsFunc(slots, func(n *ykoath.Name) bool { return n.Name == name }) {
+				return fmt.Errorf("%w: %s", ErrCredentialExists, name)
+			}
+		}
+
+		return p.put(cred, cred.Counter)
+	})
 }
 
-func (p *ykoathProvider) CreateKeyFromSecret(label string, secret []byte) (KeyID, error) {
-	if err := p.Put(label, ykoath.HmacSha256, ykoath.Totp, 6, secret, false, 0); err != nil {
-		return nil, err
+// SetCounter stores the credential again with the counter as initial moving factor
+// as YKOATH has no command for changing the counter of an existing credential.
+func (p *ykoathProvider) SetCounter(cred *oath.Credential, counter uint64) error {
+	if cred.Type != oath.HOTP {
+		return fmt.Errorf("%w: %s", oath.ErrUnsupportedType, cred.Type)
+	}
+
+	if err := cred.Validate(); err != nil {
+		return err
+	}
+
+	return p.do(func() error {
+		return p.put(cred, counter)
+	})
+}
+
+// Counter calculates a code on the token and searches it within the window
+// starting at the counter of the credential as YKOATH can not read the counter.
+func (p *ykoathProvider) Counter(cred *oath.Credential, window int) (counter uint64, err error) {
+	if cred.Type != oath.HOTP {
+		return 0, fmt.Errorf("%w: %s", oath.ErrUnsupportedType, cred.Type)
+	}
+
+	if err := cred.Validate(); err != nil {
+		return 0, err
+	}
+
+	var code string
+	if err := p.do(func() error {
+		mac, digits, err := p.CalculateChallengeResponse(cred.Name(), nil)
+		if err != nil {
+			return err
+		}
+
+		code = oath.Truncate(mac, digits)
+
+		return nil
+	}); err != nil {
+		return 0, err
 	}
 
-	id, err := p.keyID(label)
+	return cred.FindCounter(cred.Counter, window, code)
+}
+
+// Calculate returns the TOTP code of a stored credential for the given time
+// rather than the time of the clock of the card.
+func (p *ykoathProvider) Calculate(name string, at time.Time) (string, error) {
+	codes, err := p.CalculateWindow(name, at, 0)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	return id, nil
+	return codes[0], nil
+}
+
+// CalculateWindow calculates the TOTP codes for multiple periods within a single transaction.
+// HOTP credentials are rejected as each calculation would advance their counter.
+func (p *ykoathProvider) CalculateWindow(name string, at time.Time, skew int) (codes []string, err error) {
+	period, _, _ := oath.ParseName(name)
+	step := at.Unix() / int64(period.Seconds())
+	skew = max(skew, 0)
+
+	err = p.do(func() error {
+		names, err := p.List()
+		if err != nil {
+			return fmt.Errorf("failed to list credentials: %w", err)
+		}
+
+		idx := slices.IndexFunc(names, func(n *ykoath.Name) bool {
+			return n.Name == name
+		})
+		if idx < 0 {
+			return fmt.Errorf("%w: %s", ErrKeyNotFound, name)
+		} else if names[idx].Type != ykoath.Totp {
+			return fmt.Errorf("%w: %s", oath.ErrUnsupportedType, names[idx].Type)
+		}
+
+		for i := -skew; i <= skew; i++ {
+			chal := binary.BigEndian.AppendUint64(nil, uint64(step+int64(i))) //nolint:gosec
+			mac, digits, err := p.CalculateChallengeResponse(name, chal)
+			if err != nil {
+				return err
+			}
+
+			codes = append(codes, oath.Truncate(mac, digits))
+		}
+
+		return nil
+	})
+
+	return codes, err
+}
+
+func (p *ykoathProvider) put(cred *oath.Credential, counter uint64) error {
+	var alg ykoath.Algorithm
+	switch cred.Algorithm {
+	case oath.SHA1:
+		alg = ykoath.HmacSha1
+	case oath.SHA256:
+		alg = ykoath.HmacSha256
+	case oath.SHA512:
+		alg = ykoath.HmacSha512
+	}
+
+	typ := ykoath.Totp
+	if cred.Type == oath.HOTP {
+		typ = ykoath.Hotp
+	}
+
+	if counter > math.MaxUint32 {
+		return fmt.Errorf("%w: counter exceeds 32 bits", oath.ErrInvalidCredential)
+	}
+
+	return p.Put(cred.Name(), alg, typ, cred.Digits, cred.Secret, cred.Touch, uint32(counter))
+}
+
+// Credentials lists the stored credentials including the hawkes HMAC keys.
+// The touch requirement of HOTP credentials can not be determined and is always false.
+func (p *ykoathProvider) Credentials() (creds []*oath.Credential, err error) {
+	err = p.do(func() error {
+		names, err := p.List()
+		if err != nil {
+			return fmt.Errorf("failed to list credentials: %w", err)
+		}
+
+		// Only CALCULATE ALL reports whether TOTP credentials require touch
+		_, touch, err := p.calculateAll([]byte(idChallenge))
+		if err != nil {
+			return err
+		}
+
+		for _, n := range names {
+			period, issuer, account := oath.ParseName(n.Name)
+
+			cred := &oath.Credential{
+				Type:    oath.TOTP,
+				Issuer:  issuer,
+				Account: account,
+				Period:  period,
+				Touch:   slices.Contains(touch, n.Name),
+			}
+
+			if n.Type == ykoath.Hotp {
+				cred.Type = oath.HOTP
+				cred.Period = 0
+			}
+
+			switch n.Algorithm {
+			case ykoath.HmacSha1:
+				cred.Algorithm = oath.SHA1
+			case ykoath.HmacSha256:
+				cred.Algorithm = oath.SHA256
+			case ykoath.HmacSha512:
+				cred.Algorithm = oath.SHA512
+			}
+
+			creds = append(creds, cred)
+		}
+
+		return nil
+	})
+
+	return creds, err
 }
 
 func (p *ykoathProvider) CreateKey(label string) (KeyID, error) {
@@ -124,8 +799,11 @@ func (p *ykoathProvider) CreateKey(label string) (KeyID, error) {
 }
 
 func (p *ykoathProvider) OpenKey(id KeyID) (PrivateKey, error) {
-	name, err := p.nameByID(id)
-	if err != nil {
+	var name string
+	if err := p.do(func() (err error) {
+		name, err = p.nameByID(id)
+		return err
+	}); err != nil {
 		return nil, err
 	}
 
@@ -136,23 +814,14 @@ func (p *ykoathProvider) OpenKey(id KeyID) (PrivateKey, error) {
 }
 
 func (p *ykoathProvider) nameByID(id KeyID) (string, error) {
-	slots, err := p.List()
+	ids, err := p.keyIDs()
 	if err != nil {
 		return "", err
 	}
 
-	for _, slot := range slots {
-		if slot.Algorithm != ykoath.HmacSha256 {
-			continue
-		}
-
-		key, err := p.keyID(slot.Name)
-		if err != nil {
-			return "", err
-		}
-
+	for name, key := range ids {
 		if bytes.Equal(key, id) {
-			return slot.Name, nil
+			return name, nil
 		}
 	}
 
@@ -171,4 +840,5 @@ func (p *ykoathProvider) keyID(name string) (KeyID, error) {
 //nolint:gochecknoinits
 func init() {
 	Register("YKOATH", newYKOATHProvider)
+	RegisterMatcher("YKOATH", device.HasApplet(iso7816.AidYubicoOATH))
 }
@@ -0,0 +1,200 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	"cunicu.li/go-iso7816"
+	"cunicu.li/go-iso7816/encoding/tlv"
+)
+
+var (
+	_ BatchProvider       = (*ykoathProvider)(nil)
+	_ PrivateKeyBatchHMAC = (*ykoathKey)(nil)
+)
+
+// HMACBatch calculates all HMACs within a single operation of the card queue
+// and hence a single card transaction.
+func (k *ykoathKey) HMACBatch(ctx context.Context, challenges [][]byte) (results [][]byte, err error) {
+	if err := k.provider.do(func() error {
+		results = make([][]byte, len(challenges))
+
+		for i, challenge := range challenges {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			if results[i], _, err = k.provider.CalculateChallengeResponse(k.name, challenge); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}); isTouchTimeout(err) {
+		return nil, fmt.Errorf("%w: %w", ErrTouchTimeout, err)
+	} else if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+type ykoathHMACBatch struct {
+	provider *ykoathProvider
+	ops      []hmacOp
+}
+
+func (p *ykoathProvider) BatchHMAC() HMACBatch {
+	return &ykoathHMACBatch{
+		provider: p,
+	}
+}
+
+func (b *ykoathHMACBatch) HMAC(id KeyID, challenge []byte) HMACBatch {
+	b.ops = append(b.ops, hmacOp{id, challenge})
+	return b
+}
+
+// Run executes all operations within a single operation of the card queue.
+// Operations sharing a challenge are calculated by a single CALCULATE ALL instruction.
+func (b *ykoathHMACBatch) Run(ctx context.Context) (results [][]byte, err error) {
+	p := b.provider
+	results = make([][]byte, len(b.ops))
+
+	if err := p.queue.Do(ctx, func(ctx context.Context) error {
+		if err := p.openSession(); err != nil {
+			return err
+		}
+
+		names, err := b.names()
+		if err != nil {
+			return err
+		}
+
+		// Group operations by challenge while preserving their order
+		groups := map[string][]int{}
+		challenges := []string{}
+		for i, op := range b.ops {
+			c := string(op.challenge)
+			if _, ok := groups[c]; !ok {
+				challenges = append(challenges, c)
+			}
+
+			groups[c] = append(groups[c], i)
+		}
+
+		for _, c := range challenges {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			idxs := groups[c]
+
+			var codes map[string][]byte
+			if len(idxs) > 1 {
+				if codes, _, err = p.calculateAll([]byte(c)); err != nil {
+					return err
+				}
+			}
+
+			for _, i := range idxs {
+				name := names[i]
+
+				// Credentials requiring touch are not included in CALCULATE ALL
+				if code, ok := codes[name]; ok {
+					results[i] = code
+					continue
+				}
+
+				if results[i], _, err = p.CalculateChallengeResponse(name, []byte(c)); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// names resolves the credential names of all operations.
+func (b *ykoathHMACBatch) names() ([]string, error) {
+	p := b.provider
+
+	// The IDs of all credentials are calculated by a single CALCULATE ALL
+	ids, _, err := p.calculateAll([]byte(idChallenge))
+	if err != nil {
+		return nil, err
+	}
+
+	byID := map[string]string{}
+	for name, id := range ids {
+		byID[string(id)] = name
+	}
+
+	names := make([]string, len(b.ops))
+	for i, op := range b.ops {
+		name, ok := byID[string(op.id)]
+		if !ok {
+			if name, err = p.nameByID(op.id); err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, op.id)
+			}
+		}
+
+		names[i] = name
+	}
+
+	return names, nil
+}
+
+// calculateAll implements the CALCULATE ALL instruction of the YKOATH protocol
+// and returns the full responses of all credentials which do not require touch
+// as well as the names of the TOTP credentials which require touch.
+func (p *ykoathProvider) calculateAll(challenge []byte) (codes map[string][]byte, touch []string, err error) {
+	data, err := tlv.EncodeSimple(tlv.New(ykoathTagChallenge, challenge))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := p.Send(&iso7816.CAPDU{
+		Ins:  ykoathInsCalculateAll,
+		Data: data,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tvs, err := tlv.DecodeSimple(resp)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrParse, err)
+	}
+
+	codes = map[string][]byte{}
+
+	var name string
+	for _, tv := range tvs {
+		switch tv.Tag {
+		case ykoathTagName:
+			name = string(tv.Value)
+
+		case ykoathTagResponse:
+			// The first byte contains the number of digits
+			if len(tv.Value) < 1 {
+				return nil, nil, ErrParse
+			}
+
+			codes[name] = tv.Value[1:]
+
+		case ykoathTagTouch:
+			touch = append(touch, name)
+		}
+	}
+
+	return codes, touch, nil
+}
@@ -1,19 +1,26 @@
 // SPDX-FileCopyrightText: 2023 Steffen Vogel
 // SPDX-License-Identifier: Apache-2.0
 
+//go:build (cgo || windows) && !nopcsc
+
 package provider
 
 import (
-	"crypto/rand"
+	"context"
 	"log/slog"
 	"os"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"cunicu.li/go-iso7816"
 	"cunicu.li/go-iso7816/filter"
 	"cunicu.li/go-iso7816/test"
 	"cunicu.li/go-ykoath/v2"
 	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/oath"
 )
 
 func TestYKOATH(t *testing.T) {
@@ -108,18 +115,200 @@ func TestYKOATH(t *testing.T) {
 		require.NoError(err)
 
 		require.Equal(ss1, ss2)
+
+		results, err := ykp.BatchHMAC().
+			HMAC(id1, challenge).
+			HMAC(id1, challenge).
+			HMAC(id1, []byte("5678")).
+			Run(context.Background())
+		require.NoError(err)
+		require.Len(results, 3)
+		require.Equal(ss1, results[0])
+		require.Equal(ss1, results[1])
+
+		keyResults, err := HMACKeyBatch(context.Background(), key1, [][]byte{challenge, []byte("5678")})
+		require.NoError(err)
+		require.Equal([][]byte{ss1, results[2]}, keyResults)
+	})
+}
+
+func TestYKOATHCalculate(t *testing.T) {
+	withCard(t, func(t *testing.T, card *iso7816.Card) {
+		require := require.New(t)
+
+		p, err := newYKOATHProvider(card)
+		require.NoError(err)
+
+		ykp, ok := p.(*ykoathProvider)
+		require.True(ok)
+
+		cred := &oath.Credential{
+			Type:      oath.TOTP,
+			Algorithm: oath.SHA1,
+			Account:   "alice",
+			Secret:    []byte("12345678901234567890"),
+			Digits:    8,
+			Period:    time.Minute,
+		}
+
+		err = ykp.PutCredential(cred, false)
+		require.NoError(err)
+
+		at := time.Unix(1111111109, 0)
+
+		expected, err := cred.TOTPWindow(at, 1)
+		require.NoError(err)
+
+		codes, err := ykp.CalculateWindow(cred.Name(), at, 1)
+		require.NoError(err)
+		require.Equal(expected, codes)
+
+		code, err := ykp.Calculate(cred.Name(), at)
+		require.NoError(err)
+		require.Equal(expected[1], code)
+
+		_, err = ykp.Calculate("missing", at)
+		require.ErrorIs(err, ErrKeyNotFound)
+	})
+}
+
+func TestYKOATHCredentials(t *testing.T) {
+	withCard(t, func(t *testing.T, card *iso7816.Card) {
+		require := require.New(t)
+
+		p, err := newYKOATHProvider(card)
+		require.NoError(err)
+
+		ykp, ok := p.(*ykoathProvider)
+		require.True(ok)
+
+		for _, cred := range []*oath.Credential{
+			{Type: oath.TOTP, Algorithm: oath.SHA256, Issuer: "Example", Account: "alice", Period: time.Minute, Touch: true},
+			{Type: oath.HOTP, Algorithm: oath.SHA1, Account: "bob"},
+		} {
+			cred.Secret = []byte("12345678901234567890")
+			err = ykp.PutCredential(cred, false)
+			require.NoError(err)
+		}
+
+		creds, err := ykp.Credentials()
+		require.NoError(err)
+		require.Len(creds, 2)
+
+		slices.SortFunc(creds, func(a, b *oath.Credential) int {
+			return strings.Compare(a.Account, b.Account)
+		})
+
+		require.Equal(&oath.Credential{
+			Type:      oath.TOTP,
+			Algorithm: oath.SHA256,
+			Issuer:    "Example",
+			Account:   "alice",
+			Period:    time.Minute,
+			Touch:     true,
+		}, creds[0])
+
+		require.Equal(&oath.Credential{
+			Type:      oath.HOTP,
+			Algorithm: oath.SHA1,
+			Account:   "bob",
+		}, creds[1])
+	})
+}
+
+func TestYKOATHKeysSkipCredentials(t *testing.T) {
+	withCard(t, func(t *testing.T, card *iso7816.Card) {
+		require := require.New(t)
+
+		p, err := newYKOATHProvider(card)
+		require.NoError(err)
+
+		ykp, ok := p.(*ykoathProvider)
+		require.True(ok)
+
+		hotp := &oath.Credential{
+			Type:      oath.HOTP,
+			Algorithm: oath.SHA256,
+			Account:   "alice",
+			Secret:    []byte("12345678901234567890"),
+			Digits:    6,
+			Counter:   5,
+		}
+
+		touch := &oath.Credential{
+			Type:      oath.TOTP,
+			Algorithm: oath.SHA256,
+			Account:   "bob",
+			Secret:    []byte("12345678901234567890"),
+			Digits:    6,
+			Period:    30 * time.Second,
+			Touch:     true,
+		}
+
+		for _, cred := range []*oath.Credential{hotp, touch} {
+			err = ykp.PutCredential(cred, false)
+			require.NoError(err)
+		}
+
+		id, err := ykp.CreateKey("test")
+		require.NoError(err)
+
+		// Enumerating keys neither advances HOTP counters nor waits for a touch
+		for range 3 {
+			keys, err := p.Keys()
+			require.NoError(err)
+			require.Equal([]KeyID{id}, keys)
+		}
+
+		// The first code calculated by the token is still the one of the initial counter
+		counter, err := ykp.Counter(hotp, 10)
+		require.NoError(err)
+		require.EqualValues(hotp.Counter+1, counter)
+
+		// Credentials are not keys and can not be destroyed as such
+		err = p.DestroyKey(KeyID("alice"))
+		require.ErrorIs(err, os.ErrNotExist)
+
+		creds, err := ykp.Credentials()
+		require.NoError(err)
+		require.Len(creds, 3)
 	})
 }
 
-func generateSecret() ([]byte, error) {
-	// RFC4226 recommends a secret length of 160bits
-	// but we use 256bits for compatibility with P256 private keys
-	secret := make([]byte, 32)
-	if _, err := rand.Read(secret); err != nil {
-		return nil, err
-	}
+func TestYKOATHValidateAgain(t *testing.T) {
+	withCard(t, func(t *testing.T, card *iso7816.Card) {
+		require := require.New(t)
+
+		p, err := newYKOATHProvider(card)
+		require.NoError(err)
+
+		ykp, ok := p.(*ykoathProvider)
+		require.True(ok)
+
+		err = ykp.ChangePIN(nil, []byte("secret"))
+		require.NoError(err)
 
-	return secret, nil
+		// Only the derived access key is retained
+		err = ykp.key.Use(func(key []byte) error {
+			require.Len(key, 16)
+			require.NotContains(string(key), "secret")
+			return nil
+		})
+		require.NoError(err)
+
+		// Wrong passwords are reported as such to count failed attempts
+		require.ErrorIs(ykp.Unlock([]byte("wrong")), ErrWrongPIN)
+
+		// Another application selects the applet and discards the authentication
+		other, err := ykoath.NewCard(card)
+		require.NoError(err)
+
+		_, err = other.Select()
+		require.NoError(err)
+
+		_, err = ykp.Credentials()
+		require.NoError(err)
+	})
 }
 
 func withCard(t *testing.T, cb func(t *testing.T, card *iso7816.Card)) {
@@ -0,0 +1,221 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package rollid derives short identifiers which rotate every epoch
+// from an HMAC key, e.g. a credential on a hardware token.
+//
+// Peers sharing the HMAC secret can use the identifiers as beacons for
+// discovery and rendezvous without revealing a long-term identity to observers.
+package rollid
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"cunicu.li/hawkes/provider"
+	"cunicu.li/hawkes/timesync"
+)
+
+var (
+	ErrUnknownID   = errors.New("unknown identifier")
+	ErrInvalidSize = errors.New("invalid identifier size")
+)
+
+const (
+	// DefaultEpoch is the lifetime of an identifier.
+	DefaultEpoch = 15 * time.Minute
+
+	// DefaultSize is the length of identifiers in bytes.
+	DefaultSize = 16
+
+	// DefaultWindow is the number of epochs accepted before and after the current one.
+	DefaultWindow = 1
+)
+
+// ID is a rolling identifier.
+type ID []byte
+
+func (id ID) String() string {
+	return hex.EncodeToString(id)
+}
+
+// Deriver derives the identifiers of a key.
+// Derived identifiers are cached so that hardware keys are only used once per epoch.
+type Deriver struct {
+	Key provider.PrivateKeyHMAC
+
+	// Label separates identifiers derived for different purposes from the same key.
+	Label string
+
+	// Epoch is the lifetime of an identifier.
+	Epoch time.Duration
+
+	// Size is the length of identifiers in bytes. At most the HMAC output size.
+	Size int
+
+	// Window is the number of epochs accepted by Verify before and after the current one.
+	Window int
+
+	// Clock is the time source. Defaults to timesync.System.
+	Clock timesync.Clock
+
+	// Counter determines the current epoch instead of Clock if set, so that
+	// identifiers of past epochs are not issued again after the clock has been
+	// set back or the host restarted. Its period must be equal to Epoch.
+	Counter *timesync.Epochs
+
+	mu    sync.Mutex
+	cache map[uint64]ID
+}
+
+// New creates a deriver with default parameters.
+func New(key provider.PrivateKeyHMAC, label string) *Deriver {
+	return &Deriver{
+		Key:    key,
+		Label:  label,
+		Epoch:  DefaultEpoch,
+		Size:   DefaultSize,
+		Window: DefaultWindow,
+	}
+}
+
+// EpochAt returns the number of the epoch containing t.
+func (d *Deriver) EpochAt(t time.Time) uint64 {
+	return uint64(t.Unix() / int64(d.Epoch/time.Second)) //nolint:gosec
+}
+
+// Current returns the identifier of the current epoch.
+func (d *Deriver) Current() (ID, error) {
+	epoch, err := d.current()
+	if err != nil {
+		return nil, err
+	}
+
+	return d.ID(epoch)
+}
+
+func (d *Deriver) current() (uint64, error) {
+	if d.Counter != nil {
+		return d.Counter.Current()
+	}
+
+	return d.EpochAt(timesync.Now(d.Clock)), nil
+}
+
+// ID returns the identifier of an epoch.
+// It is the truncated HMAC over the label and the epoch number.
+func (d *Deriver) ID(epoch uint64) (ID, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if id, ok := d.cache[epoch]; ok {
+		return id, nil
+	}
+
+	msg := append([]byte("hawkes rolling id\x00"+d.Label+"\x00"), make([]byte, 8)...)
+	binary.BigEndian.PutUint64(msg[len(msg)-8:], epoch)
+
+	mac, err := d.Key.HMAC(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate HMAC: %w", err)
+	}
+
+	if d.Size <= 0 || d.Size > len(mac) {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidSize, d.Size)
+	}
+
+	id := ID(mac[:d.Size])
+
+	if d.cache == nil {
+		d.cache = map[uint64]ID{}
+	}
+
+	// Forget identifiers of epochs which can not be verified anymore
+	current := d.EpochAt(timesync.Now(d.Clock))
+	for e := range d.cache {
+		if e+uint64(d.Window) < current { //nolint:gosec
+			delete(d.cache, e)
+		}
+	}
+
+	d.cache[epoch] = id
+
+	return id, nil
+}
+
+// Verify checks whether id is the identifier of the current epoch or one of the
+// neighbouring epochs within the window. It returns the epoch of the identifier.
+func (d *Deriver) Verify(id ID) (uint64, error) {
+	current, err := d.current()
+	if err != nil {
+		return 0, err
+	}
+
+	for offset := -d.Window; offset <= d.Window; offset++ {
+		epoch := current + uint64(offset) //nolint:gosec
+
+		exp, err := d.ID(epoch)
+		if err != nil {
+			return 0, err
+		}
+
+		if subtle.ConstantTimeCompare(exp, id) == 1 {
+			return epoch, nil
+		}
+	}
+
+	return 0, ErrUnknownID
+}
+
+// Resolver maps the identifiers received from multiple peers to their names.
+type Resolver struct {
+	mu       sync.Mutex
+	derivers map[string]*Deriver
+}
+
+// NewResolver creates an empty resolver.
+func NewResolver() *Resolver {
+	return &Resolver{
+		derivers: map[string]*Deriver{},
+	}
+}
+
+// Add registers the deriver of a peer.
+func (r *Resolver) Add(name string, d *Deriver) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.derivers[name] = d
+}
+
+// Remove unregisters a peer.
+func (r *Resolver) Remove(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.derivers, name)
+}
+
+// Resolve returns the name of the peer and the epoch of a received identifier.
+func (r *Resolver) Resolve(id ID) (string, uint64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for name, d := range r.derivers {
+		epoch, err := d.Verify(id)
+		if errors.Is(err, ErrUnknownID) {
+			continue
+		} else if err != nil {
+			return "", 0, fmt.Errorf("failed to verify identifier of %s: %w", name, err)
+		}
+
+		return name, epoch, nil
+	}
+
+	return "", 0, ErrUnknownID
+}
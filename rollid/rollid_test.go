@@ -0,0 +1,141 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package rollid_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/provider"
+	"cunicu.li/hawkes/rollid"
+	"cunicu.li/hawkes/timesync"
+)
+
+// hmacKey is a software HMAC-SHA256 key which counts its uses.
+type hmacKey struct {
+	secret []byte
+	uses   int
+}
+
+func (k *hmacKey) ID() provider.KeyID      { return nil }
+func (k *hmacKey) Details() map[string]any { return nil }
+func (k *hmacKey) Close() error            { return nil }
+
+func (k *hmacKey) HMAC(challenge []byte) ([]byte, error) {
+	k.uses++
+
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write(challenge)
+	return mac.Sum(nil), nil
+}
+
+func TestDeriver(t *testing.T) {
+	require := require.New(t)
+
+	now := time.Unix(1700000000, 0)
+	clock := timesync.ClockFunc(func() time.Time { return now })
+
+	key := &hmacKey{secret: []byte("shared secret")}
+	sender := rollid.New(key, "rendezvous")
+	sender.Clock = clock
+
+	receiver := rollid.New(&hmacKey{secret: []byte("shared secret")}, "rendezvous")
+	receiver.Clock = clock
+
+	id1, err := sender.Current()
+	require.NoError(err)
+	require.Len(id1, rollid.DefaultSize)
+
+	// Identifiers are cached per epoch
+	id, err := sender.Current()
+	require.NoError(err)
+	require.Equal(id1, id)
+	require.Equal(1, key.uses)
+
+	epoch, err := receiver.Verify(id1)
+	require.NoError(err)
+	require.Equal(sender.EpochAt(now), epoch)
+
+	// Identifiers rotate every epoch
+	now = now.Add(rollid.DefaultEpoch)
+
+	id2, err := sender.Current()
+	require.NoError(err)
+	require.NotEqual(id1, id2)
+
+	// Identifiers of the previous epoch are still accepted
+	_, err = receiver.Verify(id1)
+	require.NoError(err)
+
+	now = now.Add(rollid.DefaultEpoch)
+
+	_, err = receiver.Verify(id1)
+	require.ErrorIs(err, rollid.ErrUnknownID)
+
+	// Labels separate identifiers
+	other := rollid.New(&hmacKey{secret: []byte("shared secret")}, "discovery")
+	other.Clock = clock
+
+	id3, err := other.Current()
+	require.NoError(err)
+
+	id4, err := sender.Current()
+	require.NoError(err)
+	require.NotEqual(id3, id4)
+}
+
+func TestResolver(t *testing.T) {
+	require := require.New(t)
+
+	alice := rollid.New(&hmacKey{secret: []byte("alice")}, "rendezvous")
+	bob := rollid.New(&hmacKey{secret: []byte("bob")}, "rendezvous")
+
+	r := rollid.NewResolver()
+	r.Add("alice", rollid.New(&hmacKey{secret: []byte("alice")}, "rendezvous"))
+	r.Add("bob", rollid.New(&hmacKey{secret: []byte("bob")}, "rendezvous"))
+
+	id, err := bob.Current()
+	require.NoError(err)
+
+	name, _, err := r.Resolve(id)
+	require.NoError(err)
+	require.Equal("bob", name)
+
+	id, err = alice.Current()
+	require.NoError(err)
+
+	r.Remove("alice")
+
+	_, _, err = r.Resolve(id)
+	require.ErrorIs(err, rollid.ErrUnknownID)
+}
+
+func TestDeriverCounter(t *testing.T) {
+	require := require.New(t)
+
+	now := time.Unix(1700000000, 0)
+
+	d := rollid.New(&hmacKey{secret: []byte("shared secret")}, "rendezvous")
+	d.Counter = timesync.NewEpochs("rendezvous", d.Epoch, timesync.ClockFunc(func() time.Time { return now }), nil)
+
+	id1, err := d.Current()
+	require.NoError(err)
+
+	now = now.Add(rollid.DefaultEpoch)
+
+	id2, err := d.Current()
+	require.NoError(err)
+	require.NotEqual(id1, id2)
+
+	// Setting the clock back does not issue the previous identifier again
+	now = now.Add(-rollid.DefaultEpoch)
+
+	id, err := d.Current()
+	require.NoError(err)
+	require.Equal(id2, id)
+}
@@ -0,0 +1,158 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package secret holds sensitive material like PINs, passwords and
+// derived keys in memory which is locked against swapping where the
+// platform allows and wiped once it is no longer needed.
+package secret
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"os"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+var ErrDestroyed = errors.New("secret has been destroyed")
+
+// Buffer is a fixed-size container for sensitive bytes.
+// Its contents are only accessible within Use and are wiped by Destroy.
+// Buffers which are garbage collected without being destroyed are wiped as well.
+type Buffer struct {
+	mu   sync.RWMutex
+	data []byte
+
+	// pages are the locked memory pages which hold data.
+	pages []byte
+}
+
+// New allocates a zeroed buffer of n bytes.
+func New(n int) *Buffer {
+	b := &Buffer{}
+
+	if n > 0 {
+		// Locks are not reference counted.
+		// Hence buffers occupy whole pages which are not shared with other buffers.
+		pageSize := os.Getpagesize()
+		size := (n + pageSize - 1) / pageSize * pageSize
+
+		raw := make([]byte, size+pageSize)
+		offset := (pageSize - int(uintptr(unsafe.Pointer(&raw[0]))%uintptr(pageSize))) % pageSize //nolint:gosec
+		pages := raw[offset : offset+size : offset+size]
+
+		if err := lock(pages); err != nil {
+			slog.Debug("Failed to lock secret memory", slog.Any("error", err))
+		} else {
+			b.pages = pages
+		}
+
+		b.data = pages[:n:n]
+	} else {
+		b.data = []byte{}
+	}
+
+	runtime.SetFinalizer(b, (*Buffer).Destroy)
+
+	return b
+}
+
+// FromBytes moves data into a new buffer and wipes the source slice.
+func FromBytes(data []byte) *Buffer {
+	b := New(len(data))
+	copy(b.data, data)
+	Wipe(data)
+
+	return b
+}
+
+// Len returns the size of the buffer or zero if it has been destroyed.
+func (b *Buffer) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return len(b.data)
+}
+
+// Use invokes fn with the contents of the buffer.
+// The slice must not be retained after fn returns.
+func (b *Buffer) Use(fn func([]byte) error) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.data == nil {
+		return ErrDestroyed
+	}
+
+	return fn(b.data)
+}
+
+// Clone returns a copy in a new buffer.
+func (b *Buffer) Clone() (*Buffer, error) {
+	var c *Buffer
+
+	err := b.Use(func(data []byte) error {
+		c = New(len(data))
+		copy(c.data, data)
+
+		return nil
+	})
+
+	return c, err
+}
+
+// Equal compares the contents of two buffers in constant time.
+// Destroyed buffers are not equal to any buffer, including themselves.
+func (b *Buffer) Equal(o *Buffer) bool {
+	// Only one buffer is locked at a time so that concurrent
+	// comparisons in opposite order can not deadlock
+	c, err := o.Clone()
+	if err != nil {
+		return false
+	}
+
+	defer c.Destroy()
+
+	var eq bool
+
+	_ = b.Use(func(data []byte) error {
+		eq = subtle.ConstantTimeCompare(data, c.data) == 1
+		return nil
+	})
+
+	return eq
+}
+
+// Destroy wipes and unlocks the buffer.
+// Further calls to Use fail with ErrDestroyed.
+func (b *Buffer) Destroy() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.data == nil {
+		return
+	}
+
+	Wipe(b.data)
+
+	if b.pages != nil {
+		if err := unlock(b.pages); err != nil {
+			slog.Debug("Failed to unlock secret memory", slog.Any("error", err))
+		}
+	}
+
+	b.data = nil
+	b.pages = nil
+
+	runtime.SetFinalizer(b, nil)
+}
+
+// Wipe overwrites a slice with zeros.
+func Wipe(b []byte) {
+	clear(b)
+
+	// Prevent the compiler from eliding the writes to a dead slice
+	runtime.KeepAlive(b)
+}
@@ -0,0 +1,16 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package secret
+
+import "golang.org/x/sys/unix"
+
+func lock(b []byte) error {
+	return unix.Mlock(b)
+}
+
+func unlock(b []byte) error {
+	return unix.Munlock(b)
+}
@@ -0,0 +1,102 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package secret_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/secret"
+)
+
+func TestBuffer(t *testing.T) {
+	require := require.New(t)
+
+	src := []byte("739215")
+	b := secret.FromBytes(src)
+
+	// The source is wiped
+	require.Equal(make([]byte, 6), src)
+	require.Equal(6, b.Len())
+
+	err := b.Use(func(data []byte) error {
+		require.Equal([]byte("739215"), data)
+		return nil
+	})
+	require.NoError(err)
+
+	c, err := b.Clone()
+	require.NoError(err)
+	require.True(b.Equal(c))
+	require.True(b.Equal(b))
+	require.False(b.Equal(secret.FromBytes([]byte("739216"))))
+
+	b.Destroy()
+	require.Zero(b.Len())
+	require.ErrorIs(b.Use(func([]byte) error { return nil }), secret.ErrDestroyed)
+	require.False(b.Equal(c))
+	require.False(c.Equal(b))
+	require.False(b.Equal(b))
+
+	// Destroying twice is harmless
+	b.Destroy()
+
+	// Clones are independent
+	require.Equal(6, c.Len())
+	c.Destroy()
+}
+
+func TestBufferEmpty(t *testing.T) {
+	require := require.New(t)
+
+	b := secret.New(0)
+	require.NoError(b.Use(func(data []byte) error {
+		require.Empty(data)
+		return nil
+	}))
+
+	b.Destroy()
+	require.ErrorIs(b.Use(func([]byte) error { return nil }), secret.ErrDestroyed)
+}
+
+func TestBufferEqualConcurrent(t *testing.T) {
+	a := secret.FromBytes([]byte("739215"))
+	b := secret.FromBytes([]byte("739215"))
+
+	var wg sync.WaitGroup
+
+	for range 100 {
+		wg.Add(3)
+
+		go func() {
+			defer wg.Done()
+			a.Equal(b)
+		}()
+
+		go func() {
+			defer wg.Done()
+			b.Equal(a)
+		}()
+
+		go func() {
+			defer wg.Done()
+			a.Equal(a)
+		}()
+	}
+
+	// Pending writers must not deadlock comparisons in opposite order
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		a.Destroy()
+	}()
+
+	wg.Wait()
+
+	require.False(t, a.Equal(b))
+	b.Destroy()
+}
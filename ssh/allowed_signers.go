@@ -0,0 +1,251 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+var (
+	ErrInvalidAllowedSigners = errors.New("invalid allowed signers entry")
+	ErrNotAllowed            = errors.New("signer is not allowed")
+)
+
+// AllowedSigner is an entry of an allowed signers file as described by ssh-keygen(1).
+type AllowedSigner struct {
+	Principals    []string
+	CertAuthority bool
+	Namespaces    []string
+	ValidAfter    time.Time
+	ValidBefore   time.Time
+	PublicKey     gossh.PublicKey
+}
+
+// AllowedSigners is the list of trusted signers as used by git's gpg.ssh.allowedSignersFile.
+type AllowedSigners []AllowedSigner
+
+// ParseAllowedSigners parses an allowed signers file.
+func ParseAllowedSigners(r io.Reader) (AllowedSigners, error) {
+	var signers AllowedSigners
+
+	s := bufio.NewScanner(r)
+	for lineNo := 1; s.Scan(); lineNo++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		signer, err := parseAllowedSigner(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+
+		signers = append(signers, signer)
+	}
+
+	return signers, s.Err()
+}
+
+func parseAllowedSigner(line string) (as AllowedSigner, err error) {
+	principals, rest := nextField(line)
+	as.Principals = strings.Split(principals, ",")
+
+	// Options are present if the next field is not a key type
+	if !isKey(rest) {
+		var opts string
+		opts, rest = nextField(rest)
+
+		if err := as.parseOptions(opts); err != nil {
+			return as, err
+		}
+	}
+
+	if as.PublicKey, _, _, _, err = gossh.ParseAuthorizedKey([]byte(rest)); err != nil {
+		return as, fmt.Errorf("%w: %w", ErrInvalidAllowedSigners, err)
+	}
+
+	return as, nil
+}
+
+func (as *AllowedSigner) parseOptions(opts string) (err error) {
+	for _, opt := range splitOptions(opts) {
+		name, value, _ := strings.Cut(opt, "=")
+		value = strings.Trim(value, `"`)
+
+		switch strings.ToLower(name) {
+		case "cert-authority":
+			as.CertAuthority = true
+
+		case "namespaces":
+			as.Namespaces = strings.Split(value, ",")
+
+		case "valid-after":
+			if as.ValidAfter, err = parseTime(value); err != nil {
+				return err
+			}
+
+		case "valid-before":
+			if as.ValidBefore, err = parseTime(value); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("%w: unknown option %q", ErrInvalidAllowedSigners, name)
+		}
+	}
+
+	return nil
+}
+
+func isKey(s string) bool {
+	_, rest := nextField(s)
+	blob, _ := nextField(rest)
+
+	b, err := base64.StdEncoding.DecodeString(blob)
+	if err != nil {
+		return false
+	}
+
+	_, err = gossh.ParsePublicKey(b)
+
+	return err == nil
+}
+
+// nextField splits off the first whitespace-separated field while respecting double quotes.
+func nextField(s string) (field, rest string) {
+	quoted := false
+
+	for i, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case !quoted && (c == ' ' || c == '\t'):
+			return s[:i], strings.TrimLeft(s[i:], " \t")
+		}
+	}
+
+	return s, ""
+}
+
+// splitOptions splits comma-separated options while respecting double quotes.
+func splitOptions(s string) (opts []string) {
+	quoted := false
+	start := 0
+
+	for i, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case !quoted && c == ',':
+			opts = append(opts, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(opts, s[start:])
+}
+
+// parseTime parses timestamps in the format YYYYMMDD[HHMM[SS]][Z].
+func parseTime(s string) (time.Time, error) {
+	loc := time.Local
+	if strings.HasSuffix(s, "Z") {
+		s = strings.TrimSuffix(s, "Z")
+		loc = time.UTC
+	}
+
+	for _, layout := range []string{"20060102", "200601021504", "20060102150405"} {
+		if len(s) == len(layout) {
+			t, err := time.ParseInLocation(layout, s, loc)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("%w: %w", ErrInvalidAllowedSigners, err)
+			}
+
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("%w: invalid time %q", ErrInvalidAllowedSigners, s)
+}
+
+func (as *AllowedSigner) matchesKey(pub gossh.PublicKey) bool {
+	if cert, ok := pub.(*gossh.Certificate); ok {
+		return as.CertAuthority && bytes.Equal(cert.SignatureKey.Marshal(), as.PublicKey.Marshal())
+	}
+
+	return !as.CertAuthority && bytes.Equal(pub.Marshal(), as.PublicKey.Marshal())
+}
+
+func (as *AllowedSigner) matchesPrincipal(principal string) bool {
+	for _, p := range as.Principals {
+		negate := strings.HasPrefix(p, "!")
+		if ok, _ := path.Match(strings.TrimPrefix(p, "!"), principal); ok {
+			return !negate
+		}
+	}
+
+	return false
+}
+
+func (as *AllowedSigner) valid(namespace string, at time.Time) bool {
+	if as.Namespaces != nil && !slices.ContainsFunc(as.Namespaces, func(ns string) bool {
+		ok, _ := path.Match(ns, namespace)
+		return ok
+	}) {
+		return false
+	}
+
+	if !as.ValidAfter.IsZero() && at.Before(as.ValidAfter) {
+		return false
+	}
+
+	if !as.ValidBefore.IsZero() && !at.Before(as.ValidBefore) {
+		return false
+	}
+
+	return true
+}
+
+// Principals returns the principals of all entries which accept the public key of the signature.
+func (a AllowedSigners) Principals(sig *Signature, at time.Time) (principals []string) {
+	for _, as := range a {
+		if as.matchesKey(sig.PublicKey) && as.valid(sig.Namespace, at) {
+			principals = append(principals, as.Principals...)
+		}
+	}
+
+	return principals
+}
+
+// Verify checks the signature and that it has been made by an allowed signer for the principal.
+func (a AllowedSigners) Verify(sig *Signature, principal, namespace string, message io.Reader, at time.Time) error {
+	allowed := slices.ContainsFunc(a, func(as AllowedSigner) bool {
+		return as.matchesKey(sig.PublicKey) && as.matchesPrincipal(principal) && as.valid(namespace, at)
+	})
+	if !allowed {
+		return ErrNotAllowed
+	}
+
+	if cert, ok := sig.PublicKey.(*gossh.Certificate); ok {
+		checker := gossh.CertChecker{
+			Clock: func() time.Time { return at },
+		}
+
+		if err := checker.CheckCert(principal, cert); err != nil {
+			return fmt.Errorf("%w: %w", ErrNotAllowed, err)
+		}
+	}
+
+	return sig.Verify(namespace, message)
+}
@@ -0,0 +1,192 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package ssh implements an OpenSSH certificate authority
+// whose signing key is held by a provider.
+package ssh
+
+import (
+	"crypto"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+
+	"cunicu.li/hawkes/provider"
+	"cunicu.li/hawkes/timesync"
+)
+
+var (
+	ErrUnsupportedKey  = errors.New("unsupported key")
+	ErrInvalidValidity = errors.New("invalid validity period")
+	ErrNoPrincipals    = errors.New("certificates require at least one principal")
+)
+
+// CertType is the type of an OpenSSH certificate.
+type CertType uint32
+
+const (
+	UserCert = CertType(gossh.UserCert)
+	HostCert = CertType(gossh.HostCert)
+)
+
+const (
+	// DefaultValidity is the validity of issued certificates if not specified.
+	DefaultValidity = 24 * time.Hour
+
+	// backdate accounts for clock skew between the CA and servers.
+	backdate = 5 * time.Minute
+)
+
+// DefaultUserExtensions are the extensions which ssh-keygen adds to user certificates by default.
+//
+//nolint:gochecknoglobals
+var DefaultUserExtensions = map[string]string{
+	"permit-X11-forwarding":   "",
+	"permit-agent-forwarding": "",
+	"permit-port-forwarding":  "",
+	"permit-pty":              "",
+	"permit-user-rc":          "",
+}
+
+// Options describe the certificate to issue.
+type Options struct {
+	Type       CertType
+	KeyID      string
+	Principals []string
+
+	// AnyPrincipal allows user certificates without principals,
+	// which OpenSSH accepts for any user.
+	AnyPrincipal bool
+
+	// Serial is chosen randomly if zero.
+	Serial uint64
+
+	// ValidAfter defaults to the current time.
+	// ValidBefore defaults to ValidAfter plus DefaultValidity.
+	ValidAfter  time.Time
+	ValidBefore time.Time
+
+	// CriticalOptions such as "force-command" or "source-address".
+	CriticalOptions map[string]string
+
+	// Extensions default to DefaultUserExtensions for user certificates if nil.
+	Extensions map[string]string
+}
+
+// CA signs OpenSSH certificates.
+type CA struct {
+	Signer gossh.Signer
+
+	// Clock is the time source. Defaults to timesync.System.
+	Clock timesync.Clock
+}
+
+// NewCA creates a certificate authority for a signing key.
+func NewCA(signer crypto.Signer) (*CA, error) {
+	s, err := gossh.NewSignerFromSigner(signer)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsupportedKey, err)
+	}
+
+	return &CA{
+		Signer: s,
+	}, nil
+}
+
+// NewProviderCA creates a certificate authority for a key held by a provider.
+func NewProviderCA(key provider.PrivateKey) (*CA, error) {
+	sk, ok := key.(provider.PrivateKeySigner)
+	if !ok {
+		return nil, fmt.Errorf("%w: key does not support signing", ErrUnsupportedKey)
+	}
+
+	signer, err := sk.Signer()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCA(signer)
+}
+
+// PublicKey returns the CA public key in the authorized_keys format
+// as used by the TrustedUserCAKeys option and @cert-authority entries.
+func (c *CA) PublicKey() []byte {
+	return gossh.MarshalAuthorizedKey(c.Signer.PublicKey())
+}
+
+// Sign issues a certificate for the public key.
+func (c *CA) Sign(pub gossh.PublicKey, opts Options) (*gossh.Certificate, error) {
+	if opts.Type == 0 {
+		opts.Type = UserCert
+	}
+
+	if len(opts.Principals) == 0 && (opts.Type == HostCert || !opts.AnyPrincipal) {
+		return nil, ErrNoPrincipals
+	}
+
+	if opts.ValidAfter.IsZero() {
+		opts.ValidAfter = timesync.Now(c.Clock).Add(-backdate)
+	}
+
+	if opts.ValidBefore.IsZero() {
+		opts.ValidBefore = opts.ValidAfter.Add(DefaultValidity + backdate)
+	}
+
+	if !opts.ValidBefore.After(opts.ValidAfter) {
+		return nil, ErrInvalidValidity
+	}
+
+	if opts.Serial == 0 {
+		var buf [8]byte
+		if _, err := rand.Read(buf[:]); err != nil {
+			return nil, err
+		}
+
+		opts.Serial = binary.BigEndian.Uint64(buf[:])
+	}
+
+	if opts.Extensions == nil && opts.Type == UserCert {
+		opts.Extensions = DefaultUserExtensions
+	}
+
+	cert := &gossh.Certificate{
+		Key:             pub,
+		Serial:          opts.Serial,
+		CertType:        uint32(opts.Type),
+		KeyId:           opts.KeyID,
+		ValidPrincipals: opts.Principals,
+		ValidAfter:      uint64(opts.ValidAfter.Unix()),  //nolint:gosec
+		ValidBefore:     uint64(opts.ValidBefore.Unix()), //nolint:gosec
+		Permissions: gossh.Permissions{
+			CriticalOptions: maps.Clone(opts.CriticalOptions),
+			Extensions:      maps.Clone(opts.Extensions),
+		},
+	}
+
+	if err := cert.SignCert(rand.Reader, c.Signer); err != nil {
+		return nil, fmt.Errorf("failed to sign certificate: %w", err)
+	}
+
+	return cert, nil
+}
+
+// SignAuthorizedKey issues a certificate for a public key in the authorized_keys format
+// and returns the certificate in the same format, suitable for an id_*-cert.pub file.
+func (c *CA) SignAuthorizedKey(in []byte, opts Options) ([]byte, error) {
+	pub, _, _, _, err := gossh.ParseAuthorizedKey(in) //nolint:dogsled
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	cert, err := c.Sign(pub, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return gossh.MarshalAuthorizedKey(cert), nil
+}
@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package ssh_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	gossh "golang.org/x/crypto/ssh"
+
+	"cunicu.li/hawkes/ssh"
+)
+
+func TestSignUserCert(t *testing.T) {
+	require := require.New(t)
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	ca, err := ssh.NewCA(caKey)
+	require.NoError(err)
+
+	userPub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(err)
+
+	sshPub, err := gossh.NewPublicKey(userPub)
+	require.NoError(err)
+
+	// Certificates valid for any user must be requested explicitly
+	_, err = ca.Sign(sshPub, ssh.Options{})
+	require.ErrorIs(err, ssh.ErrNoPrincipals)
+
+	anyCert, err := ca.Sign(sshPub, ssh.Options{AnyPrincipal: true})
+	require.NoError(err)
+	require.Empty(anyCert.ValidPrincipals)
+
+	out, err := ca.SignAuthorizedKey(gossh.MarshalAuthorizedKey(sshPub), ssh.Options{
+		KeyID:      "alice@example.com",
+		Principals: []string{"alice"},
+		CriticalOptions: map[string]string{
+			"source-address": "10.0.0.0/8",
+		},
+	})
+	require.NoError(err)
+
+	pub, _, _, _, err := gossh.ParseAuthorizedKey(out) //nolint:dogsled
+	require.NoError(err)
+
+	cert, ok := pub.(*gossh.Certificate)
+	require.True(ok)
+	require.Equal(uint32(gossh.UserCert), cert.CertType)
+	require.Equal("alice@example.com", cert.KeyId)
+	require.Contains(cert.Extensions, "permit-pty")
+
+	caPub, _, _, _, err := gossh.ParseAuthorizedKey(ca.PublicKey()) //nolint:dogsled
+	require.NoError(err)
+
+	checker := &gossh.CertChecker{
+		IsUserAuthority: func(auth gossh.PublicKey) bool {
+			return string(auth.Marshal()) == string(caPub.Marshal())
+		},
+	}
+
+	perms, err := checker.Authenticate(connMetadata("alice"), cert)
+	require.NoError(err)
+	require.Equal("10.0.0.0/8", perms.CriticalOptions["source-address"])
+
+	_, err = checker.Authenticate(connMetadata("bob"), cert)
+	require.Error(err)
+}
+
+func TestSignHostCert(t *testing.T) {
+	require := require.New(t)
+
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(err)
+
+	ca, err := ssh.NewCA(caKey)
+	require.NoError(err)
+
+	hostKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	hostPub, err := gossh.NewPublicKey(&hostKey.PublicKey)
+	require.NoError(err)
+
+	_, err = ca.Sign(hostPub, ssh.Options{Type: ssh.HostCert})
+	require.ErrorIs(err, ssh.ErrNoPrincipals)
+
+	now := time.Now()
+	cert, err := ca.Sign(hostPub, ssh.Options{
+		Type:        ssh.HostCert,
+		Principals:  []string{"host.example.com"},
+		ValidAfter:  now,
+		ValidBefore: now.Add(time.Hour),
+	})
+	require.NoError(err)
+	require.Nil(cert.Extensions)
+
+	checker := &gossh.CertChecker{
+		IsHostAuthority: func(auth gossh.PublicKey, _ string) bool {
+			return string(auth.Marshal()) == string(ca.Signer.PublicKey().Marshal())
+		},
+	}
+
+	require.NoError(checker.CheckHostKey("host.example.com:22", nil, cert))
+	require.Error(checker.CheckHostKey("other.example.com:22", nil, cert))
+}
+
+type connMetadata string
+
+func (c connMetadata) User() string { return string(c) }
+
+func (connMetadata) SessionID() []byte     { return nil }
+func (connMetadata) ClientVersion() []byte { return nil }
+func (connMetadata) ServerVersion() []byte { return nil }
+func (connMetadata) RemoteAddr() net.Addr  { return nil }
+func (connMetadata) LocalAddr() net.Addr   { return nil }
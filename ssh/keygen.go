@@ -0,0 +1,341 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+
+	"cunicu.li/hawkes/provider"
+	"cunicu.li/hawkes/timesync"
+)
+
+var ErrUsage = errors.New("usage: -Y (sign|verify|find-principals|check-novalidate) [options] [file ...]")
+
+// SignerFunc returns a signer for the public key read from the key file passed to "-Y sign".
+type SignerFunc func(pub gossh.PublicKey) (gossh.Signer, error)
+
+// Keygen implements the signature subcommands of ssh-keygen(1) which are used by git.
+// It can be configured as git's gpg.ssh.program by using a wrapper script
+// which invokes "hawkes ssh-keygen":
+//
+//	git config gpg.format ssh
+//	git config gpg.ssh.program hawkes-ssh-keygen
+//	git config user.signingKey "ecdsa-sha2-nistp256 AAAA..."
+type Keygen struct {
+	Signer SignerFunc
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Clock is the time source. Defaults to timesync.System.
+	Clock timesync.Clock
+}
+
+type keygenOptions struct {
+	operation  string
+	namespace  string
+	file       string
+	principal  string
+	signature  string
+	verifyTime time.Time
+	files      []string
+}
+
+// Run executes the command with the given arguments (excluding the program name).
+func (k *Keygen) Run(args []string) error {
+	opts, err := k.parse(args)
+	if err != nil {
+		return err
+	}
+
+	switch opts.operation {
+	case "sign":
+		return k.sign(opts)
+	case "verify":
+		return k.verify(opts)
+	case "find-principals":
+		return k.findPrincipals(opts)
+	case "check-novalidate":
+		return k.checkNoValidate(opts)
+	default:
+		return ErrUsage
+	}
+}
+
+// ProviderSigner returns a SignerFunc which looks up the key with the
+// matching public key among all keys of a provider.
+func ProviderSigner(p provider.Provider) SignerFunc {
+	return func(pub gossh.PublicKey) (gossh.Signer, error) {
+		ids, err := p.Keys()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list keys: %w", err)
+		}
+
+		for _, id := range ids {
+			key, err := p.OpenKey(id)
+			if err != nil {
+				continue
+			}
+
+			sk, ok := key.(provider.PrivateKeySigner)
+			if !ok {
+				continue
+			}
+
+			signer, err := sk.Signer()
+			if err != nil {
+				continue
+			}
+
+			s, err := gossh.NewSignerFromSigner(signer)
+			if err != nil {
+				continue
+			}
+
+			if bytes.Equal(s.PublicKey().Marshal(), pub.Marshal()) {
+				return s, nil
+			}
+		}
+
+		return nil, fmt.Errorf("%w: %s", provider.ErrKeyNotFound, gossh.FingerprintSHA256(pub))
+	}
+}
+
+func (k *Keygen) parse(args []string) (*keygenOptions, error) {
+	opts := &keygenOptions{
+		verifyTime: timesync.Now(k.Clock),
+	}
+
+	fs := flag.NewFlagSet("ssh-keygen", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.StringVar(&opts.operation, "Y", "", "operation")
+	fs.StringVar(&opts.namespace, "n", "", "namespace")
+	fs.StringVar(&opts.file, "f", "", "key or allowed signers file")
+	fs.StringVar(&opts.principal, "I", "", "signer identity")
+	fs.StringVar(&opts.signature, "s", "", "signature file")
+	fs.Bool("U", false, "key is held by an agent")
+	fs.Bool("q", false, "quiet")
+	fs.Func("O", "option", func(o string) (err error) {
+		if v, ok := strings.CutPrefix(o, "verify-time="); ok {
+			opts.verifyTime, err = parseTime(v)
+		}
+
+		return err
+	})
+
+	if err := fs.Parse(splitArgs(args)); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUsage, err)
+	}
+
+	opts.files = fs.Args()
+
+	return opts, nil
+}
+
+// splitArgs separates values from flags in the "-Ovalue" style used by ssh-keygen.
+func splitArgs(args []string) (split []string) {
+	for i, arg := range args {
+		if arg == "--" {
+			return append(split, args[i:]...)
+		}
+
+		if len(arg) > 2 && arg[0] == '-' && strings.ContainsRune("YnfIsO", rune(arg[1])) {
+			split = append(split, arg[:2], arg[2:])
+		} else {
+			split = append(split, arg)
+		}
+	}
+
+	return split
+}
+
+func (k *Keygen) sign(opts *keygenOptions) error {
+	if opts.file == "" || opts.namespace == "" {
+		return ErrUsage
+	}
+
+	buf, err := os.ReadFile(opts.file)
+	if err != nil {
+		return fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	pub, _, _, _, err := gossh.ParseAuthorizedKey(buf) //nolint:dogsled
+	if err != nil {
+		return fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	signer, err := k.Signer(pub)
+	if err != nil {
+		return err
+	}
+
+	sign := func(in io.Reader) ([]byte, error) {
+		sig, err := Sign(signer, opts.namespace, in)
+		if err != nil {
+			return nil, err
+		}
+
+		return sig.Armor(), nil
+	}
+
+	// Sign stdin if no files are given
+	if len(opts.files) == 0 || (len(opts.files) == 1 && opts.files[0] == "-") {
+		armored, err := sign(k.Stdin)
+		if err != nil {
+			return err
+		}
+
+		_, err = k.Stdout.Write(armored)
+
+		return err
+	}
+
+	for _, fn := range opts.files {
+		f, err := os.Open(fn)
+		if err != nil {
+			return err
+		}
+
+		armored, err := sign(f)
+		f.Close()
+
+		if err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(fn+".sig", armored, 0o644); err != nil { //nolint:gosec
+			return fmt.Errorf("failed to write signature: %w", err)
+		}
+
+		fmt.Fprintf(k.Stderr, "Signing file %s\nWrite signature to %s.sig\n", fn, fn)
+	}
+
+	return nil
+}
+
+func (k *Keygen) readSignature(opts *keygenOptions) (*Signature, error) {
+	if opts.signature == "" {
+		return nil, ErrUsage
+	}
+
+	buf, err := os.ReadFile(opts.signature)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signature: %w", err)
+	}
+
+	return ParseSignature(buf)
+}
+
+func (k *Keygen) readAllowedSigners(opts *keygenOptions) (AllowedSigners, error) {
+	f, err := os.Open(opts.file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open allowed signers: %w", err)
+	}
+	defer f.Close()
+
+	return ParseAllowedSigners(f)
+}
+
+func (k *Keygen) verify(opts *keygenOptions) error {
+	if opts.file == "" || opts.principal == "" || opts.namespace == "" {
+		return ErrUsage
+	}
+
+	sig, err := k.readSignature(opts)
+	if err != nil {
+		return err
+	}
+
+	signers, err := k.readAllowedSigners(opts)
+	if err != nil {
+		return err
+	}
+
+	if err := signers.Verify(sig, opts.principal, opts.namespace, k.Stdin, opts.verifyTime); err != nil {
+		fmt.Fprintf(k.Stderr, "Could not verify signature: %v\n", err)
+		return err
+	}
+
+	fmt.Fprintf(k.Stdout, "Good %q signature for %s with %s key %s\n",
+		opts.namespace, opts.principal, keyType(sig.PublicKey), gossh.FingerprintSHA256(sig.PublicKey))
+
+	return nil
+}
+
+func (k *Keygen) findPrincipals(opts *keygenOptions) error {
+	if opts.file == "" {
+		return ErrUsage
+	}
+
+	sig, err := k.readSignature(opts)
+	if err != nil {
+		return err
+	}
+
+	signers, err := k.readAllowedSigners(opts)
+	if err != nil {
+		return err
+	}
+
+	principals := signers.Principals(sig, opts.verifyTime)
+	if len(principals) == 0 {
+		return ErrNotAllowed
+	}
+
+	for _, p := range principals {
+		fmt.Fprintln(k.Stdout, p)
+	}
+
+	return nil
+}
+
+func (k *Keygen) checkNoValidate(opts *keygenOptions) error {
+	if opts.namespace == "" {
+		return ErrUsage
+	}
+
+	sig, err := k.readSignature(opts)
+	if err != nil {
+		return err
+	}
+
+	if err := sig.Verify(opts.namespace, k.Stdin); err != nil {
+		fmt.Fprintf(k.Stderr, "Could not verify signature: %v\n", err)
+		return err
+	}
+
+	fmt.Fprintf(k.Stdout, "Good %q signature with %s key %s\n",
+		opts.namespace, keyType(sig.PublicKey), gossh.FingerprintSHA256(sig.PublicKey))
+
+	return nil
+}
+
+// keyType returns the key type in the notation used by ssh-keygen (e.g. "ED25519" or "ECDSA").
+func keyType(pub gossh.PublicKey) string {
+	if cert, ok := pub.(*gossh.Certificate); ok {
+		pub = cert.Key
+	}
+
+	switch t := pub.Type(); {
+	case t == gossh.KeyAlgoED25519:
+		return "ED25519"
+	case t == gossh.KeyAlgoRSA:
+		return "RSA"
+	case strings.HasPrefix(t, "ecdsa-"):
+		return "ECDSA"
+	case strings.HasPrefix(t, "sk-"):
+		return strings.ToUpper(strings.TrimSuffix(strings.TrimPrefix(t, "sk-"), "@openssh.com")) + "-SK"
+	default:
+		return strings.ToUpper(t)
+	}
+}
@@ -0,0 +1,249 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package ssh
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// See: https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.sshsig
+
+var (
+	ErrInvalidSignature   = errors.New("invalid signature")
+	ErrNamespaceMismatch  = errors.New("namespace mismatch")
+	ErrUnsupportedHash    = errors.New("unsupported hash algorithm")
+	ErrMissingNamespace   = errors.New("missing namespace")
+	ErrUnsupportedVersion = errors.New("unsupported signature version")
+)
+
+const (
+	sigMagic    = "SSHSIG"
+	sigVersion  = 1
+	sigPEMType  = "SSH SIGNATURE"
+	sigLineSize = 70
+
+	// NamespaceGit is the namespace used by git for commit and tag signatures.
+	NamespaceGit = "git"
+
+	// NamespaceFile is the namespace used by ssh-keygen for files by default.
+	NamespaceFile = "file"
+)
+
+// HashAlgorithm is the algorithm used to hash the message before signing.
+type HashAlgorithm string
+
+const (
+	SHA256 HashAlgorithm = "sha256"
+	SHA512 HashAlgorithm = "sha512"
+)
+
+func (h HashAlgorithm) new() (hash.Hash, error) {
+	switch h {
+	case SHA256:
+		return sha256.New(), nil
+	case SHA512:
+		return sha512.New(), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedHash, h)
+	}
+}
+
+// Signature is an SSH signature as produced by "ssh-keygen -Y sign".
+type Signature struct {
+	PublicKey     gossh.PublicKey
+	Namespace     string
+	HashAlgorithm HashAlgorithm
+	Signature     *gossh.Signature
+}
+
+type wireSignature struct {
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      []byte
+	HashAlgorithm string
+	Signature     []byte
+}
+
+type signedData struct {
+	Namespace     string
+	Reserved      []byte
+	HashAlgorithm string
+	Hash          []byte
+}
+
+func messageHash(alg HashAlgorithm, message io.Reader) ([]byte, error) {
+	h, err := alg.new()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.Copy(h, message); err != nil {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+
+	return h.Sum(nil), nil
+}
+
+func signedBytes(namespace string, alg HashAlgorithm, digest []byte) []byte {
+	return append([]byte(sigMagic), gossh.Marshal(signedData{
+		Namespace:     namespace,
+		HashAlgorithm: string(alg),
+		Hash:          digest,
+	})...)
+}
+
+// Sign creates an SSH signature of the message using SHA-512.
+func Sign(signer gossh.Signer, namespace string, message io.Reader) (*Signature, error) {
+	if namespace == "" {
+		return nil, ErrMissingNamespace
+	}
+
+	digest, err := messageHash(SHA512, message)
+	if err != nil {
+		return nil, err
+	}
+
+	data := signedBytes(namespace, SHA512, digest)
+
+	var sig *gossh.Signature
+	if as, ok := signer.(gossh.AlgorithmSigner); ok && signer.PublicKey().Type() == gossh.KeyAlgoRSA {
+		// ssh-rsa (SHA-1) signatures are not accepted by OpenSSH
+		sig, err = as.SignWithAlgorithm(rand.Reader, data, gossh.KeyAlgoRSASHA512)
+	} else {
+		sig, err = signer.Sign(rand.Reader, data)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+
+	return &Signature{
+		PublicKey:     signer.PublicKey(),
+		Namespace:     namespace,
+		HashAlgorithm: SHA512,
+		Signature:     sig,
+	}, nil
+}
+
+// SignWith creates an SSH signature of the message using a crypto.Signer.
+func SignWith(signer crypto.Signer, namespace string, message io.Reader) (*Signature, error) {
+	s, err := gossh.NewSignerFromSigner(signer)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsupportedKey, err)
+	}
+
+	return Sign(s, namespace, message)
+}
+
+// Verify checks the signature of the message within the expected namespace.
+// It does not check whether the public key is trusted, see AllowedSigners for this.
+func (s *Signature) Verify(namespace string, message io.Reader) error {
+	if s.Namespace != namespace {
+		return fmt.Errorf("%w: expected %q, got %q", ErrNamespaceMismatch, namespace, s.Namespace)
+	}
+
+	digest, err := messageHash(s.HashAlgorithm, message)
+	if err != nil {
+		return err
+	}
+
+	if s.PublicKey.Type() == gossh.KeyAlgoRSA && s.Signature.Format == gossh.KeyAlgoRSA {
+		return fmt.Errorf("%w: SHA-1 RSA signatures are not accepted", ErrInvalidSignature)
+	}
+
+	if err := s.PublicKey.Verify(signedBytes(s.Namespace, s.HashAlgorithm, digest), s.Signature); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+
+	return nil
+}
+
+// Marshal returns the binary encoding of the signature.
+func (s *Signature) Marshal() []byte {
+	return append([]byte(sigMagic), gossh.Marshal(wireSignature{
+		Version:       sigVersion,
+		PublicKey:     s.PublicKey.Marshal(),
+		Namespace:     s.Namespace,
+		HashAlgorithm: string(s.HashAlgorithm),
+		Signature:     gossh.Marshal(s.Signature),
+	})...)
+}
+
+// Armor returns the signature in the armored format used by ssh-keygen and git.
+func (s *Signature) Armor() []byte {
+	enc := base64.StdEncoding.EncodeToString(s.Marshal())
+
+	var b bytes.Buffer
+
+	b.WriteString("-----BEGIN " + sigPEMType + "-----\n")
+
+	for len(enc) > 0 {
+		n := min(len(enc), sigLineSize)
+		b.WriteString(enc[:n] + "\n")
+		enc = enc[n:]
+	}
+
+	b.WriteString("-----END " + sigPEMType + "-----\n")
+
+	return b.Bytes()
+}
+
+// ParseSignature parses an armored or binary SSH signature.
+func ParseSignature(b []byte) (*Signature, error) {
+	if blk, _ := pem.Decode(b); blk != nil {
+		if blk.Type != sigPEMType {
+			return nil, fmt.Errorf("%w: unexpected PEM type %q", ErrInvalidSignature, blk.Type)
+		}
+
+		b = blk.Bytes
+	}
+
+	if !bytes.HasPrefix(b, []byte(sigMagic)) {
+		return nil, fmt.Errorf("%w: invalid magic", ErrInvalidSignature)
+	}
+
+	var ws wireSignature
+	if err := gossh.Unmarshal(b[len(sigMagic):], &ws); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+
+	if ws.Version != sigVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, ws.Version)
+	}
+
+	pub, err := gossh.ParsePublicKey(ws.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+
+	sig := &gossh.Signature{}
+	if err := gossh.Unmarshal(ws.Signature, sig); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+
+	alg := HashAlgorithm(ws.HashAlgorithm)
+	if _, err := alg.new(); err != nil {
+		return nil, err
+	}
+
+	return &Signature{
+		PublicKey:     pub,
+		Namespace:     ws.Namespace,
+		HashAlgorithm: alg,
+		Signature:     sig,
+	}, nil
+}
@@ -0,0 +1,149 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package ssh_test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	gossh "golang.org/x/crypto/ssh"
+
+	"cunicu.li/hawkes/ssh"
+)
+
+func TestSignVerify(t *testing.T) {
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	for name, signer := range map[string]crypto.Signer{
+		"ecdsa":   p256,
+		"rsa":     rsaKey,
+		"ed25519": edKey,
+	} {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+
+			msg := "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n"
+
+			sig, err := ssh.SignWith(signer, ssh.NamespaceGit, strings.NewReader(msg))
+			require.NoError(err)
+
+			armored := sig.Armor()
+			require.True(bytes.HasPrefix(armored, []byte("-----BEGIN SSH SIGNATURE-----\n")))
+
+			sig2, err := ssh.ParseSignature(armored)
+			require.NoError(err)
+			require.Equal(ssh.NamespaceGit, sig2.Namespace)
+			require.Equal(sig.Marshal(), sig2.Marshal())
+
+			require.NoError(sig2.Verify(ssh.NamespaceGit, strings.NewReader(msg)))
+			require.ErrorIs(sig2.Verify(ssh.NamespaceFile, strings.NewReader(msg)), ssh.ErrNamespaceMismatch)
+			require.ErrorIs(sig2.Verify(ssh.NamespaceGit, strings.NewReader(msg+"x")), ssh.ErrInvalidSignature)
+		})
+	}
+}
+
+func TestAllowedSigners(t *testing.T) {
+	require := require.New(t)
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(err)
+
+	pub, err := gossh.NewPublicKey(key.Public())
+	require.NoError(err)
+
+	authorized := strings.TrimSpace(string(gossh.MarshalAuthorizedKey(pub)))
+
+	signers, err := ssh.ParseAllowedSigners(strings.NewReader(
+		"# comment\n" +
+			"alice@example.com,*@hawkes.example " + authorized + "\n" +
+			`bob@example.com namespaces="file",valid-after="20200101Z" ` + authorized + " bob\n"))
+	require.NoError(err)
+	require.Len(signers, 2)
+	require.Equal([]string{"file"}, signers[1].Namespaces)
+	require.Equal(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), signers[1].ValidAfter)
+
+	msg := "hello"
+
+	sig, err := ssh.SignWith(key, ssh.NamespaceGit, strings.NewReader(msg))
+	require.NoError(err)
+
+	now := time.Now()
+
+	require.Equal([]string{"alice@example.com", "*@hawkes.example"}, signers.Principals(sig, now))
+	require.NoError(signers.Verify(sig, "alice@example.com", ssh.NamespaceGit, strings.NewReader(msg), now))
+	require.NoError(signers.Verify(sig, "carol@hawkes.example", ssh.NamespaceGit, strings.NewReader(msg), now))
+	require.ErrorIs(signers.Verify(sig, "bob@example.com", ssh.NamespaceGit, strings.NewReader(msg), now), ssh.ErrNotAllowed)
+
+	_, err = ssh.ParseAllowedSigners(strings.NewReader("alice@example.com unknown-option " + authorized))
+	require.ErrorIs(err, ssh.ErrInvalidAllowedSigners)
+}
+
+func TestKeygen(t *testing.T) {
+	require := require.New(t)
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(err)
+
+	signer, err := gossh.NewSignerFromSigner(key)
+	require.NoError(err)
+
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key.pub")
+	allowedFile := filepath.Join(dir, "allowed_signers")
+	bufferFile := filepath.Join(dir, "buffer")
+
+	authorized := gossh.MarshalAuthorizedKey(signer.PublicKey())
+
+	require.NoError(os.WriteFile(keyFile, authorized, 0o600))
+	require.NoError(os.WriteFile(allowedFile, append([]byte("alice@example.com "), authorized...), 0o600))
+	require.NoError(os.WriteFile(bufferFile, []byte("commit"), 0o600))
+
+	stdout := &bytes.Buffer{}
+	kg := &ssh.Keygen{
+		Signer: func(pub gossh.PublicKey) (gossh.Signer, error) {
+			require.Equal(signer.PublicKey().Marshal(), pub.Marshal())
+			return signer, nil
+		},
+		Stdout: stdout,
+		Stderr: &bytes.Buffer{},
+	}
+
+	// Invocations as performed by git
+	require.NoError(kg.Run([]string{"-Y", "sign", "-n", "git", "-f", keyFile, "-U", bufferFile}))
+	require.FileExists(bufferFile + ".sig")
+
+	require.NoError(kg.Run([]string{"-Y", "find-principals", "-f", allowedFile, "-s", bufferFile + ".sig", "-Overify-time=20240101120000"}))
+	require.Equal("alice@example.com\n", stdout.String())
+
+	stdout.Reset()
+	kg.Stdin = strings.NewReader("commit")
+	require.NoError(kg.Run([]string{"-Y", "verify", "-n", "git", "-f", allowedFile, "-I", "alice@example.com", "-s", bufferFile + ".sig"}))
+	require.True(strings.HasPrefix(stdout.String(), `Good "git" signature for alice@example.com with ED25519 key SHA256:`))
+
+	kg.Stdin = strings.NewReader("tampered")
+	require.ErrorIs(kg.Run([]string{"-Y", "verify", "-n", "git", "-f", allowedFile, "-I", "alice@example.com", "-s", bufferFile + ".sig"}), ssh.ErrInvalidSignature)
+
+	stdout.Reset()
+	kg.Stdin = strings.NewReader("commit")
+	require.NoError(kg.Run([]string{"-Y", "check-novalidate", "-n", "git", "-s", bufferFile + ".sig"}))
+	require.True(strings.HasPrefix(stdout.String(), `Good "git" signature with ED25519 key SHA256:`))
+}
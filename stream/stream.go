@@ -0,0 +1,140 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package stream signs and verifies large payloads by hashing them
+// incrementally before invoking the signer.
+//
+// Payloads are never buffered in memory. Ed25519 keys therefore
+// create Ed25519ph signatures (RFC 8032) over the SHA-512 digest
+// of the payload which are not compatible with pure Ed25519 signatures.
+package stream
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrUnsupportedKey   = errors.New("unsupported key")
+	ErrInvalidSignature = errors.New("invalid signature")
+)
+
+// Options returns the default signer options for a public key.
+// The hash matches the strength of the curve for ECDSA keys,
+// is SHA-256 with PKCS #1 v1.5 padding for RSA keys
+// and SHA-512 for Ed25519ph.
+func Options(pub crypto.PublicKey) (crypto.SignerOpts, error) {
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			return crypto.SHA256, nil
+		case elliptic.P384():
+			return crypto.SHA384, nil
+		case elliptic.P521():
+			return crypto.SHA512, nil
+		}
+
+	case *rsa.PublicKey:
+		return crypto.SHA256, nil
+
+	case ed25519.PublicKey:
+		return &ed25519.Options{Hash: crypto.SHA512}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, pub)
+}
+
+// Digest hashes the payload read from r with the hash of the options.
+func Digest(r io.Reader, opts crypto.SignerOpts) ([]byte, error) {
+	hf := opts.HashFunc()
+	if !hf.Available() {
+		return nil, fmt.Errorf("%w: hash %s", ErrUnsupportedKey, hf)
+	}
+
+	h := hf.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, fmt.Errorf("failed to read payload: %w", err)
+	}
+
+	return h.Sum(nil), nil
+}
+
+// Sign hashes the payload read from r and signs its digest.
+// The default options of the public key are used if opts is nil.
+func Sign(signer crypto.Signer, r io.Reader, opts crypto.SignerOpts) ([]byte, error) {
+	if opts == nil {
+		var err error
+		if opts, err = Options(signer.Public()); err != nil {
+			return nil, err
+		}
+	}
+
+	digest, err := Digest(r, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := signer.Sign(rand.Reader, digest, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+
+	return sig, nil
+}
+
+// Verify hashes the payload read from r and checks its signature.
+// The default options of the public key are used if opts is nil.
+func Verify(pub crypto.PublicKey, r io.Reader, sig []byte, opts crypto.SignerOpts) error {
+	if opts == nil {
+		var err error
+		if opts, err = Options(pub); err != nil {
+			return err
+		}
+	}
+
+	digest, err := Digest(r, opts)
+	if err != nil {
+		return err
+	}
+
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, digest, sig) {
+			return ErrInvalidSignature
+		}
+
+	case *rsa.PublicKey:
+		if pss, ok := opts.(*rsa.PSSOptions); ok {
+			err = rsa.VerifyPSS(pub, pss.Hash, digest, sig, pss)
+		} else {
+			err = rsa.VerifyPKCS1v15(pub, opts.HashFunc(), digest, sig)
+		}
+
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+		}
+
+	case ed25519.PublicKey:
+		eo, ok := opts.(*ed25519.Options)
+		if !ok {
+			eo = &ed25519.Options{Hash: opts.HashFunc()}
+		}
+
+		if err := ed25519.VerifyWithOptions(pub, digest, sig, eo); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+		}
+
+	default:
+		return fmt.Errorf("%w: %T", ErrUnsupportedKey, pub)
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package stream_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/stream"
+)
+
+// payload is a large deterministic payload which is never held in memory.
+func payload(n int64, b byte) io.Reader {
+	return io.LimitReader(&repeatReader{b}, n)
+}
+
+type repeatReader struct{ b byte }
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = r.b
+	}
+
+	return len(p), nil
+}
+
+func TestSignVerify(t *testing.T) {
+	require := require.New(t)
+
+	ek, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(err)
+
+	rk, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(err)
+
+	_, edk, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(err)
+
+	const size = 64 << 20
+
+	for _, tc := range []struct {
+		signer crypto.Signer
+		opts   crypto.SignerOpts
+	}{
+		{ek, nil},
+		{rk, nil},
+		{rk, &rsa.PSSOptions{Hash: crypto.SHA384}},
+		{edk, nil},
+	} {
+		sig, err := stream.Sign(tc.signer, payload(size, 'a'), tc.opts)
+		require.NoError(err)
+
+		err = stream.Verify(tc.signer.Public(), payload(size, 'a'), sig, tc.opts)
+		require.NoError(err)
+
+		err = stream.Verify(tc.signer.Public(), payload(size, 'b'), sig, tc.opts)
+		require.ErrorIs(err, stream.ErrInvalidSignature)
+	}
+
+	// Ed25519 keys create Ed25519ph signatures
+	sig, err := stream.Sign(edk, payload(1024, 'a'), nil)
+	require.NoError(err)
+
+	digest := sha512.New()
+	_, err = io.Copy(digest, payload(1024, 'a'))
+	require.NoError(err)
+
+	err = ed25519.VerifyWithOptions(edk.Public().(ed25519.PublicKey), digest.Sum(nil), sig, &ed25519.Options{Hash: crypto.SHA512}) //nolint:forcetypeassert
+	require.NoError(err)
+}
+
+func TestOptions(t *testing.T) {
+	require := require.New(t)
+
+	ek, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	require.NoError(err)
+
+	opts, err := stream.Options(ek.Public())
+	require.NoError(err)
+	require.Equal(crypto.SHA512, opts.HashFunc())
+
+	_, err = stream.Options("not a key")
+	require.ErrorIs(err, stream.ErrUnsupportedKey)
+}
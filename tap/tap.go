@@ -0,0 +1,118 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package tap classifies errors of operations which have been interrupted
+// by a card leaving the field of a contactless reader and retries them
+// with the next tap of the card.
+package tap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// DefaultAttempts is the number of attempts of an operation if Retry.Attempts is zero.
+const DefaultAttempts = 3
+
+var ErrRemoved = errors.New("card has been removed during the operation")
+
+// Error is an error of a card operation which carries a hint
+// whether the operation may succeed if it is repeated with the next tap.
+type Error struct {
+	Err   error
+	Retry bool
+}
+
+// Interrupted marks err as caused by a card which has left the field of the reader.
+// The returned error wraps ErrRemoved and err and is retryable.
+func Interrupted(err error) error {
+	return &Error{
+		Err:   fmt.Errorf("%w: %w", ErrRemoved, err),
+		Retry: true,
+	}
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Retryable returns true if the operation may succeed with the next tap.
+func (e *Error) Retryable() bool {
+	return e.Retry
+}
+
+// IsRetryable returns true if err or one of the errors it wraps
+// implements a Retryable method which returns true.
+func IsRetryable(err error) bool {
+	var r interface{ Retryable() bool }
+	if !errors.As(err, &r) {
+		return false
+	}
+
+	return r.Retryable()
+}
+
+// Prompt asks the user to tap the card again after an attempt has failed with err.
+type Prompt func(attempt int, err error)
+
+// Retry repeats operations which failed with a retryable error
+// after the user has been prompted and a card is back in the field.
+type Retry struct {
+	// Prompt is invoked before waiting for the next tap.
+	Prompt Prompt
+
+	// Wait blocks until a card is in the field of a reader.
+	// WaitForCard is used if nil.
+	Wait func(ctx context.Context) error
+
+	// Attempts is the maximum number of attempts including the first one.
+	// DefaultAttempts is used if zero.
+	Attempts int
+}
+
+// WithTapRetry runs fn and repeats it for each retryable error
+// after prompting for and waiting for the next tap of the card.
+//
+// fn must open the card itself as the connection to a removed card can not be reused.
+func WithTapRetry(ctx context.Context, fn func(ctx context.Context) error, prompt Prompt) error {
+	r := &Retry{
+		Prompt: prompt,
+	}
+
+	return r.Do(ctx, fn)
+}
+
+// Do runs fn and repeats it for each retryable error
+// until it succeeds, fails permanently or the attempts are exhausted.
+// The error of the last attempt is returned.
+func (r *Retry) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	attempts := r.Attempts
+	if attempts <= 0 {
+		attempts = DefaultAttempts
+	}
+
+	wait := r.Wait
+	if wait == nil {
+		wait = WaitForCard
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || !IsRetryable(err) || attempt >= attempts {
+			return err
+		}
+
+		if r.Prompt != nil {
+			r.Prompt(attempt, err)
+		}
+
+		if werr := wait(ctx); werr != nil {
+			return fmt.Errorf("failed to wait for card: %w", errors.Join(werr, err))
+		}
+	}
+}
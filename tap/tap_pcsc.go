@@ -0,0 +1,99 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build (cgo || windows) && !nopcsc
+
+package tap
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ebfe/scard"
+)
+
+// pollInterval is the interval in which readers are checked for a card by WaitForCard.
+const pollInterval = 250 * time.Millisecond
+
+// Classify marks PC/SC errors which are caused by a card leaving
+// the field of a reader as retryable. Other errors are returned unchanged.
+func Classify(err error) error {
+	if err == nil || IsRetryable(err) {
+		return err
+	}
+
+	for _, target := range []error{
+		scard.ErrRemovedCard,
+		scard.ErrUnpoweredCard,
+		scard.ErrUnresponsiveCard,
+		scard.ErrCommDataLost,
+		scard.ErrNoSmartcard,
+	} {
+		if errors.Is(err, target) {
+			return Interrupted(err)
+		}
+	}
+
+	return err
+}
+
+// WaitForCard blocks until a card is present in any of the PC/SC readers.
+func WaitForCard(ctx context.Context) error {
+	sc, err := scard.EstablishContext()
+	if err != nil {
+		return err
+	}
+	defer sc.Release() //nolint:errcheck
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if present, err := cardPresent(sc); err != nil {
+			return err
+		} else if present {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func cardPresent(sc *scard.Context) (bool, error) {
+	readers, err := sc.ListReaders()
+	if errors.Is(err, scard.ErrNoReadersAvailable) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	states := make([]scard.ReaderState, 0, len(readers))
+	for _, reader := range readers {
+		states = append(states, scard.ReaderState{
+			Reader:       reader,
+			CurrentState: scard.StateUnaware,
+		})
+	}
+
+	if err := sc.GetStatusChange(states, 0); err != nil {
+		// Readers might have been detached in the meantime
+		if errors.Is(err, scard.ErrUnknownReader) || errors.Is(err, scard.ErrTimeout) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	for _, state := range states {
+		if state.EventState&scard.StatePresent != 0 && state.EventState&scard.StateMute == 0 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
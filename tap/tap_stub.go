@@ -0,0 +1,22 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build (!cgo && !windows) || nopcsc
+
+package tap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Classify returns err unchanged as this build can not access PC/SC readers.
+func Classify(err error) error {
+	return err
+}
+
+// WaitForCard fails as this build can not access PC/SC readers.
+func WaitForCard(context.Context) error {
+	return fmt.Errorf("%w: built without PC/SC support", errors.ErrUnsupported)
+}
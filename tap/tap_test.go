@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package tap_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/tap"
+)
+
+var errTransmit = errors.New("transmit failed")
+
+func TestIsRetryable(t *testing.T) {
+	require := require.New(t)
+
+	err := fmt.Errorf("failed to sign: %w", tap.Interrupted(errTransmit))
+	require.True(tap.IsRetryable(err))
+	require.ErrorIs(err, tap.ErrRemoved)
+	require.ErrorIs(err, errTransmit)
+
+	require.False(tap.IsRetryable(errTransmit))
+	require.False(tap.IsRetryable(nil))
+	require.False(tap.IsRetryable(&tap.Error{Err: errTransmit}))
+
+	require.NoError(tap.Classify(nil))
+	require.Equal(errTransmit, tap.Classify(errTransmit))
+}
+
+func TestRetry(t *testing.T) {
+	require := require.New(t)
+
+	var prompts, waits, calls int
+
+	r := &tap.Retry{
+		Prompt: func(attempt int, err error) {
+			prompts++
+			require.Equal(calls, attempt)
+			require.ErrorIs(err, tap.ErrRemoved)
+		},
+		Wait: func(context.Context) error {
+			waits++
+			return nil
+		},
+	}
+
+	// The operation succeeds with the second tap
+	err := r.Do(context.Background(), func(context.Context) error {
+		if calls++; calls < 2 {
+			return tap.Interrupted(errTransmit)
+		}
+
+		return nil
+	})
+	require.NoError(err)
+	require.Equal(2, calls)
+	require.Equal(1, prompts)
+	require.Equal(1, waits)
+
+	// Permanent errors are not retried
+	calls = 0
+	err = r.Do(context.Background(), func(context.Context) error {
+		calls++
+		return errTransmit
+	})
+	require.ErrorIs(err, errTransmit)
+	require.Equal(1, calls)
+
+	// The error of the last attempt is returned
+	calls = 0
+	err = r.Do(context.Background(), func(context.Context) error {
+		calls++
+		return tap.Interrupted(errTransmit)
+	})
+	require.ErrorIs(err, tap.ErrRemoved)
+	require.Equal(tap.DefaultAttempts, calls)
+}
+
+func TestRetryCanceled(t *testing.T) {
+	require := require.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r := &tap.Retry{
+		Prompt: func(int, error) {
+			cancel()
+		},
+		Wait: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}
+
+	err := r.Do(ctx, func(context.Context) error {
+		return tap.Interrupted(errTransmit)
+	})
+	require.ErrorIs(err, context.Canceled)
+	require.ErrorIs(err, tap.ErrRemoved)
+}
@@ -0,0 +1,43 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package timesync
+
+import "time"
+
+var (
+	_ Clock = (*Sample)(nil)
+	_ Clock = ClockFunc(nil)
+)
+
+// Clock is a source of the current time.
+//
+// Components which depend on the time accept a Clock
+// and use the System clock if it is nil, e.g.:
+//
+//	verifier.Clock = sample
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc adapts a function like time.Now to a Clock.
+type ClockFunc func() time.Time
+
+// Now returns the time of the function.
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// System is the local clock of the host.
+//
+//nolint:gochecknoglobals
+var System Clock = ClockFunc(time.Now)
+
+// Now returns the current time of a clock or of the System clock if it is nil.
+func Now(c Clock) time.Time {
+	if c == nil {
+		return System.Now()
+	}
+
+	return c.Now()
+}
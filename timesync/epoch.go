@@ -0,0 +1,282 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package timesync
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"cunicu.li/hawkes/internal/atomicfile"
+)
+
+var (
+	ErrClockJump     = errors.New("clock jumped ahead too far")
+	ErrInvalidPeriod = errors.New("invalid epoch period")
+)
+
+// EpochStore persists the last epoch of counters.
+type EpochStore interface {
+	// LoadEpoch returns the last epoch of a counter or false if it has not been saved yet.
+	LoadEpoch(name string) (uint64, bool, error)
+
+	// SaveEpoch stores the last epoch of a counter.
+	SaveEpoch(name string, epoch uint64) error
+}
+
+// Epochs counts fixed-length epochs of a clock, e.g. for rolling
+// identifiers, ratchets or key rotations.
+//
+// Unlike epochs calculated directly from the time, the counter never goes backwards:
+// If the clock is set back, e.g. by an NTP step or by restoring a VM snapshot,
+// the last epoch is kept until the clock caught up again. The last epoch is
+// persisted before it is returned so that this also holds across restarts.
+// Advance visits every epoch exactly once, even if some have passed
+// unnoticed, e.g. while the host was suspended.
+type Epochs struct {
+	// Name identifies the counter in the store.
+	Name string
+
+	// Period is the length of an epoch.
+	Period time.Duration
+
+	// Clock is the time source. Defaults to System.
+	Clock Clock
+
+	// Store persists the last epoch. Without a store, it is only kept in memory.
+	Store EpochStore
+
+	// MaxSkip is the number of epochs the clock may jump ahead at once.
+	// Larger jumps are refused with ErrClockJump until confirmed by Resync
+	// so that a bogus time does not burn the epochs in between.
+	// A zero value does not limit the jumps.
+	MaxSkip uint64
+
+	mu     sync.Mutex
+	last   uint64
+	loaded bool
+}
+
+// NewEpochs creates a counter which persists its last epoch in the store.
+func NewEpochs(name string, period time.Duration, clock Clock, store EpochStore) *Epochs {
+	return &Epochs{
+		Name:   name,
+		Period: period,
+		Clock:  clock,
+		Store:  store,
+	}
+}
+
+// At returns the number of the epoch containing t.
+func (e *Epochs) At(t time.Time) uint64 {
+	return uint64(t.Unix() / int64(e.Period/time.Second)) //nolint:gosec
+}
+
+// Current returns the current epoch.
+// Epochs which have been skipped are not visited (see Advance).
+func (e *Epochs) Current() (uint64, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now, err := e.now()
+	if err != nil {
+		return 0, err
+	}
+
+	if now > e.last {
+		if err := e.save(now); err != nil {
+			return 0, err
+		}
+	}
+
+	return e.last, nil
+}
+
+// Advance calls fn for each epoch which began since the last call in ascending order
+// and returns the current epoch. On first use, fn is only called for the current epoch.
+//
+// An epoch is persisted after fn returned successfully, so that it is neither
+// visited again after a restart nor skipped if fn failed.
+func (e *Epochs) Advance(fn func(epoch uint64) error) (uint64, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now, err := e.now()
+	if err != nil {
+		return 0, err
+	}
+
+	first := e.last + 1
+	if e.last == 0 {
+		first = now
+	}
+
+	for epoch := first; epoch <= now; epoch++ {
+		if err := fn(epoch); err != nil {
+			return e.last, err
+		}
+
+		if err := e.save(epoch); err != nil {
+			return e.last, err
+		}
+	}
+
+	return e.last, nil
+}
+
+// Resync accepts a jump of the clock beyond MaxSkip and moves the counter
+// to the current epoch without visiting the epochs in between.
+// The counter is not moved backwards.
+func (e *Epochs) Resync() (uint64, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.load(); err != nil {
+		return 0, err
+	}
+
+	if now := e.At(e.clock().Now()); now > e.last {
+		if err := e.save(now); err != nil {
+			return 0, err
+		}
+	}
+
+	return e.last, nil
+}
+
+// now returns the current epoch which is at least the last one.
+func (e *Epochs) now() (uint64, error) {
+	if err := e.load(); err != nil {
+		return 0, err
+	}
+
+	now := e.At(e.clock().Now())
+
+	if now <= e.last {
+		return e.last, nil
+	}
+
+	if e.last > 0 && e.MaxSkip > 0 && now-e.last > e.MaxSkip {
+		return 0, fmt.Errorf("%w: %d epochs of %s", ErrClockJump, now-e.last, e.Period)
+	}
+
+	return now, nil
+}
+
+func (e *Epochs) clock() Clock {
+	if e.Clock == nil {
+		return System
+	}
+
+	return e.Clock
+}
+
+func (e *Epochs) load() (err error) {
+	if e.Period < time.Second {
+		return fmt.Errorf("%w: %s", ErrInvalidPeriod, e.Period)
+	}
+
+	if e.loaded || e.Store == nil {
+		return nil
+	}
+
+	if e.last, _, err = e.Store.LoadEpoch(e.Name); err != nil {
+		return fmt.Errorf("failed to load epoch: %w", err)
+	}
+
+	e.loaded = true
+
+	return nil
+}
+
+func (e *Epochs) save(epoch uint64) error {
+	if e.Store != nil {
+		if err := e.Store.SaveEpoch(e.Name, epoch); err != nil {
+			return fmt.Errorf("failed to save epoch: %w", err)
+		}
+	}
+
+	e.last = epoch
+
+	return nil
+}
+
+// EpochFileStore persists the last epochs of counters in a JSON file.
+type EpochFileStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// DefaultEpochPath returns the default location of the epoch file.
+func DefaultEpochPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find user config directory: %w", err)
+	}
+
+	return filepath.Join(dir, "hawkes", "epochs.json"), nil
+}
+
+// NewEpochFileStore creates a store which keeps the epochs in the file at path.
+func NewEpochFileStore(path string) *EpochFileStore {
+	return &EpochFileStore{
+		path: path,
+	}
+}
+
+// LoadEpoch implements EpochStore.
+func (s *EpochFileStore) LoadEpoch(name string) (uint64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, err := s.load()
+	if err != nil {
+		return 0, false, err
+	}
+
+	epoch, ok := st[name]
+
+	return epoch, ok, nil
+}
+
+// SaveEpoch implements EpochStore.
+func (s *EpochFileStore) SaveEpoch(name string, epoch uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	st[name] = epoch
+
+	if err := atomicfile.WriteJSON(s.path, st); err != nil {
+		return fmt.Errorf("failed to write epochs: %w", err)
+	}
+
+	return nil
+}
+
+func (s *EpochFileStore) load() (map[string]uint64, error) {
+	st := map[string]uint64{}
+
+	buf, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return st, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read epochs: %w", err)
+	}
+
+	if err := json.Unmarshal(buf, &st); err != nil {
+		return nil, fmt.Errorf("failed to parse epochs: %w", err)
+	}
+
+	return st, nil
+}
@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package timesync_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/timesync"
+)
+
+func TestEpochs(t *testing.T) {
+	require := require.New(t)
+
+	now := time.Unix(1700000000, 0)
+	clock := timesync.ClockFunc(func() time.Time { return now })
+	store := timesync.NewEpochFileStore(filepath.Join(t.TempDir(), "epochs.json"))
+
+	e := timesync.NewEpochs("test", time.Minute, clock, store)
+	start := e.At(now)
+
+	advance := func() (visited []uint64) {
+		_, err := e.Advance(func(epoch uint64) error {
+			visited = append(visited, epoch)
+			return nil
+		})
+		require.NoError(err)
+
+		return visited
+	}
+
+	// The first use only visits the current epoch
+	require.Equal([]uint64{start}, advance())
+	require.Empty(advance())
+
+	// Epochs passed during a suspend are all visited
+	now = now.Add(3 * time.Minute)
+	require.Equal([]uint64{start + 1, start + 2, start + 3}, advance())
+
+	// The counter does not go backwards if the clock is set back
+	now = now.Add(-10 * time.Minute)
+
+	epoch, err := e.Current()
+	require.NoError(err)
+	require.Equal(start+3, epoch)
+	require.Empty(advance())
+
+	// Failed epochs are visited again
+	now = now.Add(12 * time.Minute)
+
+	_, err = e.Advance(func(epoch uint64) error {
+		if epoch == start+5 {
+			return errors.New("failed") //nolint:err113
+		}
+
+		return nil
+	})
+	require.Error(err)
+	require.Equal([]uint64{start + 5}, advance())
+
+	// The last epoch is restored after a restart
+	e = timesync.NewEpochs("test", time.Minute, clock, store)
+	now = now.Add(-time.Hour)
+
+	epoch, err = e.Current()
+	require.NoError(err)
+	require.Equal(start+5, epoch)
+
+	// Large jumps are refused until confirmed
+	e.MaxSkip = 10
+	now = now.Add(24 * time.Hour)
+
+	_, err = e.Current()
+	require.ErrorIs(err, timesync.ErrClockJump)
+
+	epoch, err = e.Resync()
+	require.NoError(err)
+	require.Equal(e.At(now), epoch)
+	require.Empty(advance())
+
+	_, err = timesync.NewEpochs("test", 0, clock, nil).Current()
+	require.ErrorIs(err, timesync.ErrInvalidPeriod)
+}
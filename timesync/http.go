@@ -0,0 +1,63 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package timesync
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// HTTPDate is a time source which uses the Date header of HTTP responses.
+type HTTPDate struct {
+	URL string
+
+	// Client is used for the requests. http.DefaultClient is used if nil.
+	Client *http.Client
+}
+
+func (h *HTTPDate) String() string {
+	return h.URL
+}
+
+// Sample sends a HEAD request to the URL.
+func (h *HTTPDate) Sample(ctx context.Context) (*Sample, error) {
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, h.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	t1 := time.Now()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body.Close()
+
+	t4 := t1.Add(time.Since(t1))
+
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid Date header: %w", ErrInvalidResponse, err)
+	}
+
+	// The header is truncated to full seconds
+	date = date.Add(time.Second / 2)
+	rtt := t4.Sub(t1)
+
+	return &Sample{
+		Offset:   date.Sub(t1.Add(rtt / 2)),
+		RTT:      rtt,
+		Source:   h.String(),
+		Measured: t4.UTC(),
+	}, nil
+}
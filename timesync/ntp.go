@@ -0,0 +1,123 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package timesync
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	ntpPort        = "123"
+	ntpPacketSize  = 48
+	ntpVersion     = 4
+	ntpModeClient  = 3
+	ntpModeServer  = 4
+	ntpMaxStratum  = 16
+	ntpTimeout     = 5 * time.Second
+	ntpEpochOffset = 2208988800 // Seconds between 1900-01-01 and 1970-01-01
+)
+
+// NTP is a time source which is queried with the Simple Network Time Protocol (RFC 4330).
+type NTP struct {
+	// Server is the host and optional port of the NTP server.
+	Server string
+}
+
+func (n *NTP) String() string {
+	return "ntp://" + n.Server
+}
+
+// Sample queries the server once.
+func (n *NTP) Sample(ctx context.Context) (*Sample, error) {
+	addr := n.Server
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, ntpPort)
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ntpTimeout)
+		defer cancel()
+	}
+
+	var d net.Dialer
+
+	conn, err := d.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	req := make([]byte, ntpPacketSize)
+	req[0] = ntpVersion<<3 | ntpModeClient
+
+	t1 := time.Now()
+	xmt := ntpTime(t1)
+	binary.BigEndian.PutUint64(req[40:], xmt)
+
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+
+	resp := make([]byte, ntpPacketSize)
+
+	for {
+		m, err := conn.Read(resp)
+		if err != nil {
+			return nil, err
+		}
+
+		// Ignore stray packets which do not answer our request
+		if m >= ntpPacketSize && bytes.Equal(resp[24:32], req[40:48]) {
+			break
+		}
+	}
+
+	// Derive the receive time from the monotonic clock to be unaffected by clock steps
+	t4 := t1.Add(time.Since(t1))
+
+	if mode := resp[0] & 0x7; mode != ntpModeServer {
+		return nil, fmt.Errorf("%w: unexpected mode %d", ErrInvalidResponse, mode)
+	}
+
+	// Stratum 0 is a kiss-of-death packet which asks clients to back off
+	if stratum := resp[1]; stratum == 0 || stratum >= ntpMaxStratum {
+		return nil, fmt.Errorf("%w: server is unsynchronized (stratum %d)", ErrInvalidResponse, stratum)
+	}
+
+	t2 := fromNTPTime(binary.BigEndian.Uint64(resp[32:]))
+	t3 := fromNTPTime(binary.BigEndian.Uint64(resp[40:]))
+
+	return &Sample{
+		Offset:   (t2.Sub(t1) + t3.Sub(t4)) / 2,
+		RTT:      t4.Sub(t1) - t3.Sub(t2),
+		Source:   n.String(),
+		Measured: t4.UTC(),
+	}, nil
+}
+
+func ntpTime(t time.Time) uint64 {
+	secs := uint64(t.Unix() + ntpEpochOffset) //nolint:gosec
+	frac := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+
+	return secs<<32 | frac
+}
+
+func fromNTPTime(v uint64) time.Time {
+	secs := int64(v>>32) - ntpEpochOffset
+	nsecs := int64((v & 0xffffffff) * uint64(time.Second) >> 32) //nolint:gosec
+
+	return time.Unix(secs, nsecs)
+}
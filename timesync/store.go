@@ -0,0 +1,94 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package timesync
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"cunicu.li/hawkes/internal/atomicfile"
+)
+
+// FileStore persists the estimated clock offsets of devices in a JSON file
+// so that they are applied from the start after a restart.
+type FileStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// DefaultPath returns the default location of the offset file.
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find user config directory: %w", err)
+	}
+
+	return filepath.Join(dir, "hawkes", "clock.json"), nil
+}
+
+// NewFileStore creates a store which keeps its offsets in the file at path.
+func NewFileStore(path string) *FileStore {
+	return &FileStore{
+		path: path,
+	}
+}
+
+// Offset returns the last sample of a device or nil if the device has not been sampled yet.
+func (s *FileStore) Offset(device string) (*Sample, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return st[device], nil
+}
+
+// Put stores the sample of a device.
+func (s *FileStore) Put(device string, sample *Sample) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	st[device] = sample
+
+	if err := atomicfile.WriteJSON(s.path, st); err != nil {
+		return fmt.Errorf("failed to write offsets: %w", err)
+	}
+
+	return nil
+}
+
+// Path returns the path of the offset file.
+func (s *FileStore) Path() string {
+	return s.path
+}
+
+func (s *FileStore) load() (map[string]*Sample, error) {
+	st := map[string]*Sample{}
+
+	buf, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return st, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read offsets: %w", err)
+	}
+
+	if err := json.Unmarshal(buf, &st); err != nil {
+		return nil, fmt.Errorf("failed to parse offsets: %w", err)
+	}
+
+	return st, nil
+}
@@ -0,0 +1,126 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package timesync estimates the offset of the local clock against
+// a trusted time source and corrects the time used for TOTP calculations.
+//
+// TOTP codes are derived from the current time. Machines with a skewed
+// clock therefore calculate codes for the wrong time step which the peer
+// or validator rejects without any further diagnostics.
+package timesync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+var (
+	ErrInvalidSource   = errors.New("invalid time source")
+	ErrInvalidResponse = errors.New("invalid response from time source")
+	ErrSkewed          = errors.New("clock is skewed")
+)
+
+// DefaultSamples is the number of samples taken by Estimate if not specified.
+const DefaultSamples = 4
+
+// Sample is a measurement of the offset of the local clock.
+type Sample struct {
+	// Offset is added to the local time to get the time of the source.
+	Offset time.Duration `json:"offset"`
+
+	// RTT is the round-trip time to the source which bounds the error of the offset.
+	RTT time.Duration `json:"rtt"`
+
+	Source   string    `json:"source"`
+	Measured time.Time `json:"measured"`
+}
+
+// Now returns the current time corrected by the offset.
+// It returns the local time for a nil sample.
+func (s *Sample) Now() time.Time {
+	if s == nil {
+		return time.Now()
+	}
+
+	return time.Now().Add(s.Offset)
+}
+
+// Source is a trusted time source.
+type Source interface {
+	fmt.Stringer
+
+	// Sample measures the offset of the local clock against the source.
+	Sample(ctx context.Context) (*Sample, error)
+}
+
+// ParseSource parses a time source like "ntp://pool.ntp.org" or "https://example.com".
+// HTTPS sources use the Date header of the response which has a resolution of one second
+// but passes through proxies which block NTP.
+func ParseSource(s string) (Source, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSource, err)
+	} else if u.Host == "" {
+		return nil, fmt.Errorf("%w: missing host: %s", ErrInvalidSource, s)
+	}
+
+	switch u.Scheme {
+	case "ntp":
+		return &NTP{Server: u.Host}, nil
+	case "http", "https":
+		return &HTTPDate{URL: s}, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported scheme: %s", ErrInvalidSource, u.Scheme)
+	}
+}
+
+// Estimate takes multiple samples from the source and returns the one with
+// the lowest round-trip time as its offset is the least affected by network delays.
+// It fails only if no sample could be taken.
+func Estimate(ctx context.Context, src Source, samples int) (*Sample, error) {
+	if samples <= 0 {
+		samples = DefaultSamples
+	}
+
+	var (
+		best *Sample
+		errs []error
+	)
+
+	for range samples {
+		s, err := src.Sample(ctx)
+		if err != nil {
+			errs = append(errs, err)
+
+			if ctx.Err() != nil {
+				break
+			}
+
+			continue
+		}
+
+		if best == nil || s.RTT < best.RTT {
+			best = s
+		}
+	}
+
+	if best == nil {
+		return nil, fmt.Errorf("failed to sample %s: %w", src, errors.Join(errs...))
+	}
+
+	return best, nil
+}
+
+// Check returns an error wrapping ErrSkewed if the offset shifts TOTP calculations
+// with the given time step into another step most of the time.
+func Check(offset, timestep time.Duration) error {
+	if offset.Abs() >= timestep/2 {
+		return fmt.Errorf("%w: local clock is off by %s which is more than half of the TOTP time step of %s",
+			ErrSkewed, offset.Round(time.Millisecond), timestep)
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package timesync_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/timesync"
+)
+
+// ntpServer answers NTP requests with a clock which is ahead by the given offset.
+func ntpServer(t *testing.T, offset time.Duration, stratum byte) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 48)
+
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			now := time.Now().Add(offset)
+			secs := uint32(now.Unix() + 2208988800) //nolint:gosec
+
+			resp := make([]byte, 48)
+			resp[0] = 4<<3 | 4
+			resp[1] = stratum
+			copy(resp[24:32], buf[40:n])
+
+			for _, off := range []int{32, 40} {
+				resp[off], resp[off+1], resp[off+2], resp[off+3] = byte(secs>>24), byte(secs>>16), byte(secs>>8), byte(secs)
+			}
+
+			_, _ = conn.WriteTo(resp, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestNTP(t *testing.T) {
+	require := require.New(t)
+
+	src, err := timesync.ParseSource("ntp://" + ntpServer(t, 90*time.Second, 2))
+	require.NoError(err)
+
+	s, err := timesync.Estimate(context.Background(), src, 3)
+	require.NoError(err)
+	require.InDelta(90*time.Second, s.Offset, float64(2*time.Second))
+	require.Less(s.RTT, time.Second)
+
+	require.ErrorIs(timesync.Check(s.Offset, 30*time.Second), timesync.ErrSkewed)
+	require.NoError(timesync.Check(s.Offset, 5*time.Minute))
+
+	require.WithinDuration(time.Now().Add(90*time.Second), s.Now(), 2*time.Second)
+}
+
+func TestNTPUnsynchronized(t *testing.T) {
+	src := &timesync.NTP{Server: ntpServer(t, 0, 0)}
+
+	_, err := timesync.Estimate(context.Background(), src, 2)
+	require.ErrorIs(t, err, timesync.ErrInvalidResponse)
+}
+
+func TestHTTPDate(t *testing.T) {
+	require := require.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Date", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+	}))
+	defer srv.Close()
+
+	src, err := timesync.ParseSource(srv.URL)
+	require.NoError(err)
+
+	s, err := timesync.Estimate(context.Background(), src, 1)
+	require.NoError(err)
+	require.InDelta(-time.Hour, s.Offset, float64(2*time.Second))
+}
+
+func TestParseSource(t *testing.T) {
+	for _, s := range []string{"pool.ntp.org", "ftp://example.com", "ntp://"} {
+		_, err := timesync.ParseSource(s)
+		require.ErrorIs(t, err, timesync.ErrInvalidSource, s)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	require := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "hawkes", "clock.json")
+	st := timesync.NewFileStore(path)
+
+	s, err := st.Offset("laptop")
+	require.NoError(err)
+	require.Nil(s)
+
+	// The local clock is used without a sample
+	require.WithinDuration(time.Now(), s.Now(), time.Second)
+
+	sample := &timesync.Sample{
+		Offset:   -42 * time.Second,
+		RTT:      10 * time.Millisecond,
+		Source:   "ntp://pool.ntp.org",
+		Measured: time.Now().UTC().Round(0),
+	}
+
+	err = st.Put("laptop", sample)
+	require.NoError(err)
+
+	s, err = timesync.NewFileStore(path).Offset("laptop")
+	require.NoError(err)
+	require.Equal(sample, s)
+}
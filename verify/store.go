@@ -0,0 +1,47 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package verify
+
+import (
+	"sync"
+)
+
+// Store persists the verification state of credentials.
+type Store interface {
+	// Load returns the state of a credential or the zero state if it has not been verified before.
+	Load(id string) (State, error)
+
+	// Save stores the state of a credential.
+	Save(id string, state State) error
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// MemoryStore keeps the state in memory.
+type MemoryStore struct {
+	states map[string]State
+	mu     sync.Mutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		states: map[string]State{},
+	}
+}
+
+func (s *MemoryStore) Load(id string) (State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.states[id], nil
+}
+
+func (s *MemoryStore) Save(id string, state State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.states[id] = state
+
+	return nil
+}
@@ -0,0 +1,291 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package verify validates TOTP and HOTP codes submitted by users
+// against stored OATH credentials.
+//
+// Accepted codes are tracked per credential to prevent their replay,
+// and the clock drift of TOTP tokens as well as the counter of HOTP tokens
+// are followed across verifications.
+package verify
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sync"
+
+	"cunicu.li/hawkes/oath"
+	"cunicu.li/hawkes/timesync"
+)
+
+var (
+	ErrInvalidCode   = errors.New("invalid code")
+	ErrMalformedCode = errors.New("malformed code")
+	ErrReplayed      = errors.New("code has already been used")
+)
+
+const (
+	// DefaultWindow is the number of TOTP time steps accepted before and after the current one.
+	DefaultWindow = 1
+
+	// DefaultLookAhead is the number of HOTP counter values accepted ahead of the expected one.
+	DefaultLookAhead = 10
+
+	// DefaultMaxDrift is the maximum tracked clock drift in TOTP time steps.
+	DefaultMaxDrift = 10
+
+	// DefaultResyncWindow is the number of HOTP counter values searched during a resynchronization.
+	DefaultResyncWindow = 100
+)
+
+// State is the verification state of a credential which must be persisted between verifications.
+type State struct {
+	// Counter is the next acceptable HOTP counter or TOTP time step.
+	// Codes of lower values are rejected as replays.
+	Counter uint64
+
+	// Drift is the clock offset of a TOTP token in time steps as observed during the last verification.
+	Drift int64
+}
+
+// Verifier validates codes and updates the state of the credentials in its store.
+type Verifier struct {
+	Store Store
+
+	// Window is the number of TOTP time steps accepted around the expected one.
+	Window int
+
+	// LookAhead is the number of HOTP counter values accepted ahead of the expected one.
+	LookAhead int
+
+	// MaxDrift limits the tracked TOTP clock drift in time steps.
+	MaxDrift int64
+
+	// ResyncWindow is the number of HOTP counter values searched by Resync.
+	ResyncWindow int
+
+	// Clock is the time source for TOTP verification.
+	// Defaults to timesync.System.
+	Clock timesync.Clock
+
+	mu sync.Mutex
+}
+
+// New creates a verifier with default parameters.
+// A MemoryStore is used if the store is nil.
+func New(store Store) *Verifier {
+	if store == nil {
+		store = NewMemoryStore()
+	}
+
+	return &Verifier{
+		Store:        store,
+		Window:       DefaultWindow,
+		LookAhead:    DefaultLookAhead,
+		MaxDrift:     DefaultMaxDrift,
+		ResyncWindow: DefaultResyncWindow,
+	}
+}
+
+// Verify checks a code of the credential identified by id.
+// On success the state of the credential is updated so that the code can not be used again.
+func (v *Verifier) Verify(id string, cred *oath.Credential, code string) error {
+	if err := checkCode(cred, code); err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	state, err := v.Store.Load(id)
+	if err != nil {
+		return fmt.Errorf("failed to load state: %w", err)
+	}
+
+	switch cred.Type {
+	case oath.HOTP:
+		err = v.verifyHOTP(cred, &state, code)
+	case oath.TOTP, "":
+		err = v.verifyTOTP(cred, &state, code)
+	default:
+		return fmt.Errorf("%w: %s", oath.ErrUnsupportedType, cred.Type)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err := v.Store.Save(id, state); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+
+	return nil
+}
+
+// Resync re-aligns the counter of a HOTP credential whose token has drifted
+// beyond the look-ahead window. The user submits two consecutive codes which
+// are searched within the resynchronization window (RFC 4226 Section 7.4).
+func (v *Verifier) Resync(id string, cred *oath.Credential, code1, code2 string) error {
+	if cred.Type != oath.HOTP {
+		return fmt.Errorf("%w: resynchronization requires a HOTP credential", oath.ErrUnsupportedType)
+	}
+
+	for _, code := range []string{code1, code2} {
+		if err := checkCode(cred, code); err != nil {
+			return err
+		}
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	state, err := v.Store.Load(id)
+	if err != nil {
+		return fmt.Errorf("failed to load state: %w", err)
+	}
+
+	state.Counter, err = cred.FindCounter(max(state.Counter, cred.Counter), v.ResyncWindow, code1, code2)
+	if errors.Is(err, oath.ErrCounterNotFound) {
+		return ErrInvalidCode
+	} else if err != nil {
+		return err
+	}
+
+	if err := v.Store.Save(id, state); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+
+	return nil
+}
+
+func (v *Verifier) verifyHOTP(cred *oath.Credential, state *State, code string) error {
+	counter := max(state.Counter, cred.Counter)
+
+	for i := range uint64(v.LookAhead) + 1 { //nolint:gosec
+		expected, err := cred.HOTP(counter + i)
+		if err != nil {
+			return err
+		}
+
+		if equal(code, expected) {
+			state.Counter = counter + i + 1
+			return nil
+		}
+	}
+
+	return ErrInvalidCode
+}
+
+func (v *Verifier) verifyTOTP(cred *oath.Credential, state *State, code string) error {
+	step, err := v.timeStep(cred, state)
+	if err != nil {
+		return err
+	}
+
+	expected := step + state.Drift
+
+	// Check the expected step first and then alternate around it
+	for i := range 2*v.Window + 1 {
+		offset := int64((i + 1) / 2)
+		if i%2 == 1 {
+			offset = -offset
+		}
+
+		s := expected + offset
+		if s < 0 {
+			continue
+		}
+
+		otp, err := cred.HOTP(uint64(s))
+		if err != nil {
+			return err
+		}
+
+		if !equal(code, otp) {
+			continue
+		}
+
+		if uint64(s) < state.Counter {
+			return ErrReplayed
+		}
+
+		state.Counter = uint64(s) + 1
+		state.Drift = min(max(s-step, -v.MaxDrift), v.MaxDrift)
+
+		return nil
+	}
+
+	return ErrInvalidCode
+}
+
+// timeStep returns the current time step of the clock for a TOTP credential.
+// The steps are counted as epochs which continue from the one of the last
+// accepted code, so that a clock which has been set back since then does
+// not move the window to steps whose codes would be rejected as replays.
+func (v *Verifier) timeStep(cred *oath.Credential, state *State) (int64, error) {
+	period := cred.Period
+	if period <= 0 {
+		period = oath.DefaultPeriod
+	}
+
+	epochs := &timesync.Epochs{
+		Period: period,
+		Clock:  v.Clock,
+		Store:  lastStep{state},
+	}
+
+	step, err := epochs.Current()
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(step), nil //nolint:gosec
+}
+
+// lastStep provides the time step of the clock during the last accepted
+// TOTP code as epoch. It is not saved as the state is only saved
+// after successful verifications.
+type lastStep struct {
+	state *State
+}
+
+func (s lastStep) LoadEpoch(string) (uint64, bool, error) {
+	// The accepted step includes the drift of the token
+	step := int64(s.state.Counter) - 1 - s.state.Drift //nolint:gosec
+	if s.state.Counter == 0 || step < 0 {
+		return 0, false, nil
+	}
+
+	return uint64(step), true, nil
+}
+
+func (s lastStep) SaveEpoch(string, uint64) error {
+	return nil
+}
+
+func equal(code, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(code), []byte(expected)) == 1
+}
+
+func checkCode(cred *oath.Credential, code string) error {
+	if len(code) != digits(cred) {
+		return fmt.Errorf("%w: expected %d digits", ErrMalformedCode, digits(cred))
+	}
+
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return fmt.Errorf("%w: non-digit character", ErrMalformedCode)
+		}
+	}
+
+	return nil
+}
+
+func digits(cred *oath.Credential) int {
+	if cred.Digits == 0 {
+		return oath.DefaultDigits
+	}
+
+	return cred.Digits
+}
@@ -0,0 +1,132 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package verify_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/oath"
+	"cunicu.li/hawkes/timesync"
+	"cunicu.li/hawkes/verify"
+)
+
+func TestTOTP(t *testing.T) {
+	require := require.New(t)
+
+	cred := &oath.Credential{
+		Type:   oath.TOTP,
+		Secret: []byte("12345678901234567890"),
+	}
+
+	now := time.Unix(1700000000, 0)
+	store := verify.NewMemoryStore()
+	v := verify.New(store)
+	v.Clock = timesync.ClockFunc(func() time.Time { return now })
+
+	code := func(offset time.Duration) string {
+		c, err := cred.TOTP(now.Add(offset))
+		require.NoError(err)
+
+		return c
+	}
+
+	require.ErrorIs(v.Verify("alice", cred, "12345"), verify.ErrMalformedCode)
+	require.ErrorIs(v.Verify("alice", cred, "12345a"), verify.ErrMalformedCode)
+
+	require.NoError(v.Verify("alice", cred, code(0)))
+	require.ErrorIs(v.Verify("alice", cred, code(0)), verify.ErrReplayed)
+
+	// Codes older than the last accepted one are replays as well
+	require.ErrorIs(v.Verify("alice", cred, code(-30*time.Second)), verify.ErrReplayed)
+
+	// Outside of the window
+	require.ErrorIs(v.Verify("alice", cred, code(90*time.Second)), verify.ErrInvalidCode)
+
+	// Within the window, the drift of the token is tracked
+	require.NoError(v.Verify("alice", cred, code(30*time.Second)))
+
+	state, err := store.Load("alice")
+	require.NoError(err)
+	require.EqualValues(1, state.Drift)
+
+	// A token running two steps ahead is accepted thanks to the tracked drift
+	now = now.Add(time.Minute)
+	require.NoError(v.Verify("alice", cred, code(60*time.Second)))
+
+	state, err = store.Load("alice")
+	require.NoError(err)
+	require.EqualValues(2, state.Drift)
+
+	// Other credentials have their own state
+	require.NoError(v.Verify("bob", cred, code(0)))
+
+	// The time steps continue from the last accepted code if the clock is set back
+	actual := now.Add(30 * time.Second)
+	now = now.Add(-10 * time.Minute)
+
+	c, err := cred.TOTP(actual)
+	require.NoError(err)
+	require.NoError(v.Verify("bob", cred, c))
+}
+
+func TestHOTP(t *testing.T) {
+	require := require.New(t)
+
+	cred := &oath.Credential{
+		Type:    oath.HOTP,
+		Secret:  []byte("12345678901234567890"),
+		Counter: 2,
+	}
+
+	v := verify.New(nil)
+
+	// RFC 4226 Appendix D: counter 3
+	require.NoError(v.Verify("alice", cred, "969429"))
+	require.ErrorIs(v.Verify("alice", cred, "969429"), verify.ErrInvalidCode)
+
+	// Counters below the initial one are not accepted
+	require.ErrorIs(v.Verify("bob", cred, "287082"), verify.ErrInvalidCode)
+
+	// Within the look-ahead window: counter 9
+	require.NoError(v.Verify("alice", cred, "520489"))
+
+	// Counter 5 is behind now
+	require.ErrorIs(v.Verify("alice", cred, "254676"), verify.ErrInvalidCode)
+
+	v.LookAhead = 0
+	require.ErrorIs(v.Verify("bob", cred, "338314"), verify.ErrInvalidCode)
+	require.NoError(v.Verify("bob", cred, "359152"))
+}
+
+func TestResync(t *testing.T) {
+	require := require.New(t)
+
+	cred := &oath.Credential{
+		Type:   oath.HOTP,
+		Secret: []byte("12345678901234567890"),
+	}
+
+	v := verify.New(nil)
+	v.LookAhead = 2
+
+	// RFC 4226 Appendix D: counter 7 is beyond the look-ahead window
+	require.ErrorIs(v.Verify("alice", cred, "162583"), verify.ErrInvalidCode)
+
+	// Codes must be consecutive
+	require.ErrorIs(v.Resync("alice", cred, "162583", "520489"), verify.ErrInvalidCode)
+
+	// Counters 7 and 8
+	require.NoError(v.Resync("alice", cred, "162583", "399871"))
+	require.ErrorIs(v.Verify("alice", cred, "399871"), verify.ErrInvalidCode)
+	require.NoError(v.Verify("alice", cred, "520489"))
+
+	v.ResyncWindow = 3
+	require.ErrorIs(v.Resync("bob", cred, "162583", "399871"), verify.ErrInvalidCode)
+
+	cred.Type = oath.TOTP
+	require.ErrorIs(v.Resync("alice", cred, "162583", "399871"), oath.ErrUnsupportedType)
+}
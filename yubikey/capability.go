@@ -0,0 +1,153 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package yubikey
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Capability is a set of applications of a YubiKey.
+type Capability uint16
+
+const (
+	CapOTP     Capability = 0x0001
+	CapU2F     Capability = 0x0002
+	CapOpenPGP Capability = 0x0008
+	CapPIV     Capability = 0x0010
+	CapOATH    Capability = 0x0020
+	CapHSMAuth Capability = 0x0100
+	CapFIDO2   Capability = 0x0200
+)
+
+//nolint:gochecknoglobals
+var capabilities = []struct {
+	cap  Capability
+	name string
+}{
+	{CapOTP, "otp"},
+	{CapU2F, "u2f"},
+	{CapOpenPGP, "openpgp"},
+	{CapPIV, "piv"},
+	{CapOATH, "oath"},
+	{CapHSMAuth, "hsmauth"},
+	{CapFIDO2, "fido2"},
+}
+
+// ParseCapability parses a comma-separated list of application names like "piv,oath".
+func ParseCapability(s string) (c Capability, err error) {
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		found := false
+
+		for _, cp := range capabilities {
+			if cp.name == name {
+				c |= cp.cap
+				found = true
+			}
+		}
+
+		if !found {
+			return 0, fmt.Errorf("%w: %s", ErrUnknownApplication, name)
+		}
+	}
+
+	return c, nil
+}
+
+// Names returns the names of the applications in the set.
+func (c Capability) Names() (names []string) {
+	for _, cp := range capabilities {
+		if c&cp.cap != 0 {
+			names = append(names, cp.name)
+		}
+	}
+
+	return names
+}
+
+func (c Capability) String() string {
+	names := c.Names()
+
+	known := Capability(0)
+	for _, cp := range capabilities {
+		known |= cp.cap
+	}
+
+	if unknown := c &^ known; unknown != 0 {
+		names = append(names, fmt.Sprintf("0x%04x", uint16(unknown)))
+	}
+
+	return strings.Join(names, ",")
+}
+
+// Interface is a transport via which the applications of a YubiKey are accessible.
+type Interface int
+
+const (
+	InterfaceUSB Interface = iota
+	InterfaceNFC
+)
+
+// ParseInterface parses the name of an interface.
+func ParseInterface(s string) (Interface, error) {
+	switch strings.ToLower(s) {
+	case "usb":
+		return InterfaceUSB, nil
+	case "nfc":
+		return InterfaceNFC, nil
+	default:
+		return 0, fmt.Errorf("%w: %s", ErrUnknownInterface, s)
+	}
+}
+
+func (i Interface) String() string {
+	switch i {
+	case InterfaceUSB:
+		return "usb"
+	case InterfaceNFC:
+		return "nfc"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(i))
+	}
+}
+
+// FormFactor is the physical form of a YubiKey.
+type FormFactor byte
+
+const (
+	FormFactorUnknown       FormFactor = 0x00
+	FormFactorUSBAKeychain  FormFactor = 0x01
+	FormFactorUSBANano      FormFactor = 0x02
+	FormFactorUSBCKeychain  FormFactor = 0x03
+	FormFactorUSBCNano      FormFactor = 0x04
+	FormFactorUSBCLightning FormFactor = 0x05
+	FormFactorUSBABio       FormFactor = 0x06
+	FormFactorUSBCBio       FormFactor = 0x07
+)
+
+func (f FormFactor) String() string {
+	switch f {
+	case FormFactorUSBAKeychain:
+		return "USB-A Keychain"
+	case FormFactorUSBANano:
+		return "USB-A Nano"
+	case FormFactorUSBCKeychain:
+		return "USB-C Keychain"
+	case FormFactorUSBCNano:
+		return "USB-C Nano"
+	case FormFactorUSBCLightning:
+		return "USB-C Lightning"
+	case FormFactorUSBABio:
+		return "USB-A Bio"
+	case FormFactorUSBCBio:
+		return "USB-C Bio"
+	default:
+		return "Unknown"
+	}
+}
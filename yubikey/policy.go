@@ -0,0 +1,98 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package yubikey
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrPolicyViolation = errors.New("policy violation")
+
+// Policy requires applications of a YubiKey to be enabled or disabled,
+// e.g. "OTP disabled, PIV enabled" for issued tokens.
+type Policy struct {
+	// Require are the applications which must be enabled per interface.
+	Require map[Interface]Capability
+
+	// Forbid are the applications which must be disabled per interface.
+	Forbid map[Interface]Capability
+}
+
+// Check returns an error wrapping ErrPolicyViolation for each violated rule.
+// Rules for interfaces which the device does not have are satisfied.
+func (p *Policy) Check(info *DeviceInfo) error {
+	var errs []error
+
+	for _, iface := range []Interface{InterfaceUSB, InterfaceNFC} {
+		enabled, ok := info.Enabled[iface]
+		if !ok {
+			continue
+		}
+
+		if missing := p.Require[iface] &^ enabled; missing != 0 {
+			errs = append(errs, fmt.Errorf("%w: %s must be enabled via %s", ErrPolicyViolation, missing, iface))
+		}
+
+		if forbidden := p.Forbid[iface] & enabled; forbidden != 0 {
+			errs = append(errs, fmt.Errorf("%w: %s must be disabled via %s", ErrPolicyViolation, forbidden, iface))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Enforce changes the configuration of the YubiKey to satisfy the policy.
+// The YubiKey reboots if the USB interface is changed.
+func (p *Policy) Enforce(c *Card, lockCode []byte) (*DeviceInfo, error) {
+	info, err := c.DeviceInfo()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read device information: %w", err)
+	}
+
+	cfg := &Config{
+		Enabled:  map[Interface]Capability{},
+		LockCode: lockCode,
+	}
+
+	for _, iface := range []Interface{InterfaceUSB, InterfaceNFC} {
+		enabled, ok := info.Enabled[iface]
+		if !ok {
+			continue
+		}
+
+		if unsupported := p.Require[iface] &^ info.Supported[iface]; unsupported != 0 {
+			return nil, fmt.Errorf("%w: %s via %s", ErrUnsupportedApplication, unsupported, iface)
+		}
+
+		want := (enabled | p.Require[iface]) &^ p.Forbid[iface]
+		if want == enabled {
+			continue
+		}
+
+		if iface == InterfaceUSB {
+			if want == 0 {
+				return nil, ErrNoApplications
+			}
+
+			cfg.Reboot = true
+		}
+
+		cfg.Enabled[iface] = want
+	}
+
+	if len(cfg.Enabled) == 0 {
+		return info, nil
+	}
+
+	if err := c.WriteConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	for iface, caps := range cfg.Enabled {
+		info.Enabled[iface] = caps
+	}
+
+	return info, nil
+}
@@ -0,0 +1,306 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package yubikey accesses the management applet of YubiKeys
+// for reading the device information like serial number, form factor
+// and enabled applications and for enabling or disabling applications
+// per interface.
+package yubikey
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"cunicu.li/go-iso7816"
+	"cunicu.li/go-iso7816/encoding/tlv"
+)
+
+var (
+	ErrInvalidResponse        = errors.New("invalid response")
+	ErrUnknownApplication     = errors.New("unknown application")
+	ErrUnknownInterface       = errors.New("unknown interface")
+	ErrUnsupportedFirmware    = errors.New("unsupported firmware")
+	ErrUnsupportedApplication = errors.New("application not supported by device")
+	ErrNoApplications         = errors.New("at least one application must remain enabled via USB")
+	ErrLocked                 = errors.New("configuration is locked")
+)
+
+const (
+	insWriteConfig iso7816.Instruction = 0x1c
+	insReadConfig  iso7816.Instruction = 0x1d
+
+	tagSupportedUSB tlv.Tag = 0x01
+	tagSerial       tlv.Tag = 0x02
+	tagEnabledUSB   tlv.Tag = 0x03
+	tagFormFactor   tlv.Tag = 0x04
+	tagFirmware     tlv.Tag = 0x05
+	tagConfigLock   tlv.Tag = 0x0a
+	tagUnlock       tlv.Tag = 0x0b
+	tagReboot       tlv.Tag = 0x0c
+	tagSupportedNFC tlv.Tag = 0x0d
+	tagEnabledNFC   tlv.Tag = 0x0e
+	tagMoreData     tlv.Tag = 0x10
+
+	formFactorMask byte = 0x0f
+	formFactorSky  byte = 0x40
+	formFactorFIPS byte = 0x80
+
+	// lockCodeSize is the size of the configuration lock code.
+	lockCodeSize = 16
+)
+
+//nolint:gochecknoglobals
+var (
+	versionPattern = regexp.MustCompile(`(\d+)\.(\d+)\.(\d+)`)
+
+	// firmwareReadConfig is the first firmware which supports reading the device information.
+	firmwareReadConfig = iso7816.Version{Major: 4, Minor: 1, Patch: 0}
+
+	// firmwareWriteConfig is the first firmware which supports writing the configuration.
+	firmwareWriteConfig = iso7816.Version{Major: 5, Minor: 0, Patch: 0}
+)
+
+// DeviceInfo describes a YubiKey.
+type DeviceInfo struct {
+	// Serial is the serial number or zero if it is not visible.
+	Serial     uint32
+	Firmware   iso7816.Version
+	FormFactor FormFactor
+	FIPS       bool
+
+	// Sky is set for Security Keys which support only FIDO.
+	Sky bool
+
+	// Locked is set if changing the configuration requires a lock code.
+	Locked bool
+
+	// Supported and Enabled are the applications per interface.
+	// Interfaces which the device does not have are missing.
+	Supported map[Interface]Capability
+	Enabled   map[Interface]Capability
+}
+
+// Config changes the configuration of a YubiKey.
+type Config struct {
+	// Enabled are the applications to enable per interface.
+	// All other applications of the interface are disabled.
+	// Interfaces which are missing remain unchanged.
+	Enabled map[Interface]Capability
+
+	// Reboot reboots the YubiKey after the configuration has been written
+	// which is required for changes of the USB interfaces to take effect.
+	Reboot bool
+
+	// LockCode is the current lock code of a locked configuration.
+	LockCode []byte
+}
+
+// Card is a YubiKey with a selected management applet.
+type Card struct {
+	*iso7816.Card
+
+	// Firmware is the firmware version reported by the management applet.
+	Firmware iso7816.Version
+}
+
+// NewCard selects the management applet of a YubiKey.
+func NewCard(card iso7816.PCSCCard) (*Card, error) {
+	c := &Card{
+		Card: iso7816.NewCard(card),
+	}
+
+	resp, err := c.Select(iso7816.AidYubicoManagement)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select applet: %w", err)
+	}
+
+	// The applet responds with a string like "Virtual mgr - FW version 5.7.1"
+	if m := versionPattern.FindStringSubmatch(string(resp)); m != nil {
+		c.Firmware.Major, _ = strconv.Atoi(m[1])
+		c.Firmware.Minor, _ = strconv.Atoi(m[2])
+		c.Firmware.Patch, _ = strconv.Atoi(m[3])
+	}
+
+	return c, nil
+}
+
+// DeviceInfo reads the device information.
+func (c *Card) DeviceInfo() (*DeviceInfo, error) {
+	if !atLeast(c.Firmware, firmwareReadConfig) {
+		return nil, fmt.Errorf("%w: reading the device information requires firmware %s", ErrUnsupportedFirmware, firmwareReadConfig)
+	}
+
+	var tvs tlv.TagValues
+
+	// Firmware 5.6 and later split the information into pages
+	for page := byte(0); ; page++ {
+		resp, err := c.Send(&iso7816.CAPDU{
+			Ins: insReadConfig,
+			P1:  page,
+			Ne:  iso7816.MaxLenRespDataStandard,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		ptvs, err := decodeConfig(resp)
+		if err != nil {
+			return nil, err
+		}
+
+		tvs = append(tvs, ptvs...)
+
+		if more, _, ok := ptvs.Get(tagMoreData); !ok || len(more) != 1 || more[0] != 1 {
+			break
+		}
+	}
+
+	return parseDeviceInfo(tvs, c.Firmware)
+}
+
+// WriteConfig changes the configuration of the YubiKey.
+func (c *Card) WriteConfig(cfg *Config) error {
+	if !atLeast(c.Firmware, firmwareWriteConfig) {
+		return fmt.Errorf("%w: writing the configuration requires firmware %s", ErrUnsupportedFirmware, firmwareWriteConfig)
+	}
+
+	var tvs []tlv.TagValue
+
+	if cfg.Reboot {
+		tvs = append(tvs, tlv.New(tagReboot))
+	}
+
+	if cfg.LockCode != nil {
+		if len(cfg.LockCode) != lockCodeSize {
+			return fmt.Errorf("%w: lock code must be %d bytes", ErrLocked, lockCodeSize)
+		}
+
+		tvs = append(tvs, tlv.New(tagUnlock, cfg.LockCode))
+	}
+
+	if caps, ok := cfg.Enabled[InterfaceUSB]; ok {
+		tvs = append(tvs, tlv.New(tagEnabledUSB, binary.BigEndian.AppendUint16(nil, uint16(caps))))
+	}
+
+	if caps, ok := cfg.Enabled[InterfaceNFC]; ok {
+		tvs = append(tvs, tlv.New(tagEnabledNFC, binary.BigEndian.AppendUint16(nil, uint16(caps))))
+	}
+
+	data, err := tlv.EncodeSimple(tvs...)
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.Send(&iso7816.CAPDU{
+		Ins:  insWriteConfig,
+		Data: append([]byte{byte(len(data))}, data...),
+	}); err != nil {
+		if errors.Is(err, iso7816.ErrSecurityStatusNotSatisfied) {
+			return fmt.Errorf("%w: %w", ErrLocked, err)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// decodeConfig decodes the configuration which is prefixed by its length.
+func decodeConfig(resp []byte) (tlv.TagValues, error) {
+	if len(resp) < 1 || int(resp[0]) != len(resp)-1 {
+		return nil, fmt.Errorf("%w: invalid length", ErrInvalidResponse)
+	}
+
+	tvs, err := tlv.DecodeSimple(resp[1:])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+	}
+
+	return tvs, nil
+}
+
+func parseDeviceInfo(tvs tlv.TagValues, fw iso7816.Version) (*DeviceInfo, error) {
+	info := &DeviceInfo{
+		Firmware:  fw,
+		Supported: map[Interface]Capability{},
+		Enabled:   map[Interface]Capability{},
+	}
+
+	for _, tv := range tvs {
+		v := tv.Value
+
+		switch tv.Tag {
+		case tagSupportedUSB, tagEnabledUSB, tagSupportedNFC, tagEnabledNFC:
+			var caps Capability
+
+			switch len(v) {
+			case 1: // YubiKey 4
+				caps = Capability(v[0])
+			case 2:
+				caps = Capability(binary.BigEndian.Uint16(v))
+			default:
+				return nil, fmt.Errorf("%w: invalid capabilities", ErrInvalidResponse)
+			}
+
+			switch tv.Tag {
+			case tagSupportedUSB:
+				info.Supported[InterfaceUSB] = caps
+			case tagEnabledUSB:
+				info.Enabled[InterfaceUSB] = caps
+			case tagSupportedNFC:
+				info.Supported[InterfaceNFC] = caps
+			case tagEnabledNFC:
+				info.Enabled[InterfaceNFC] = caps
+			}
+
+		case tagSerial:
+			if len(v) != 4 {
+				return nil, fmt.Errorf("%w: invalid serial number", ErrInvalidResponse)
+			}
+
+			info.Serial = binary.BigEndian.Uint32(v)
+
+		case tagFormFactor:
+			if len(v) != 1 {
+				return nil, fmt.Errorf("%w: invalid form factor", ErrInvalidResponse)
+			}
+
+			info.FormFactor = FormFactor(v[0] & formFactorMask)
+			info.Sky = v[0]&formFactorSky != 0
+			info.FIPS = v[0]&formFactorFIPS != 0
+
+		case tagFirmware:
+			if len(v) != 3 {
+				return nil, fmt.Errorf("%w: invalid firmware version", ErrInvalidResponse)
+			}
+
+			info.Firmware = iso7816.Version{Major: int(v[0]), Minor: int(v[1]), Patch: int(v[2])}
+
+		case tagConfigLock:
+			info.Locked = len(v) == 1 && v[0] != 0
+		}
+	}
+
+	// Older firmware does not report the enabled applications if all supported are enabled
+	for iface, caps := range info.Supported {
+		if _, ok := info.Enabled[iface]; !ok {
+			info.Enabled[iface] = caps
+		}
+	}
+
+	return info, nil
+}
+
+func atLeast(v, w iso7816.Version) bool {
+	switch {
+	case v.Major != w.Major:
+		return v.Major > w.Major
+	case v.Minor != w.Minor:
+		return v.Minor > w.Minor
+	default:
+		return v.Patch >= w.Patch
+	}
+}
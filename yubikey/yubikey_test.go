@@ -0,0 +1,212 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package yubikey_test
+
+import (
+	"bytes"
+	"testing"
+
+	"cunicu.li/go-iso7816"
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/yubikey"
+)
+
+// managementCard emulates the management applet of a YubiKey 5C NFC
+// with firmware 5.7.1 which returns its device information in two pages.
+type managementCard struct {
+	enabledUSB []byte
+	enabledNFC []byte
+	lockCode   []byte
+	writes     [][]byte
+}
+
+func (c *managementCard) Transmit(cmd []byte) ([]byte, error) {
+	switch {
+	case bytes.Equal(cmd[:4], []byte{0x00, 0xa4, 0x04, 0x00}):
+		if !bytes.Equal(cmd[5:5+cmd[4]], iso7816.AidYubicoManagement) {
+			return []byte{0x6a, 0x82}, nil
+		}
+
+		return append([]byte("Virtual mgr - FW version 5.7.1"), 0x90, 0x00), nil
+
+	case cmd[1] == 0x1d && cmd[2] == 0x00:
+		return page(
+			0x01, 0x02, 0x02, 0x3b, // Supported via USB
+			0x03, 0x02, c.enabledUSB[0], c.enabledUSB[1],
+			0x04, 0x01, 0x83, // USB-C Keychain, FIPS
+			0x05, 0x03, 0x05, 0x07, 0x01,
+			0x0a, 0x01, boolByte(c.lockCode != nil),
+			0x10, 0x01, 0x01, // More data
+		), nil
+
+	case cmd[1] == 0x1d && cmd[2] == 0x01:
+		return page(
+			0x02, 0x04, 0x00, 0xbc, 0x61, 0x4e,
+			0x0d, 0x02, 0x02, 0x3b, // Supported via NFC
+			0x0e, 0x02, c.enabledNFC[0], c.enabledNFC[1],
+		), nil
+
+	case cmd[1] == 0x1c:
+		data := cmd[6 : 5+cmd[4]]
+		c.writes = append(c.writes, data)
+
+		if c.lockCode != nil && !bytes.Contains(data, append([]byte{0x0b, 0x10}, c.lockCode...)) {
+			return []byte{0x69, 0x82}, nil // Security status not satisfied
+		}
+
+		for i := 0; i+1 < len(data); i += 2 + int(data[i+1]) {
+			switch data[i] {
+			case 0x03:
+				c.enabledUSB = data[i+2 : i+4]
+			case 0x0e:
+				c.enabledNFC = data[i+2 : i+4]
+			}
+		}
+
+		return []byte{0x90, 0x00}, nil
+	}
+
+	return []byte{0x6d, 0x00}, nil // Instruction not supported
+}
+
+func (c *managementCard) BeginTransaction() error { return nil }
+func (c *managementCard) EndTransaction() error   { return nil }
+func (c *managementCard) Close() error            { return nil }
+func (c *managementCard) Base() iso7816.PCSCCard  { return c }
+
+func page(tvs ...byte) []byte {
+	return append(append([]byte{byte(len(tvs))}, tvs...), 0x90, 0x00)
+}
+
+func boolByte(b bool) byte {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+func TestDeviceInfo(t *testing.T) {
+	require := require.New(t)
+
+	c, err := yubikey.NewCard(&managementCard{
+		enabledUSB: []byte{0x02, 0x3b},
+		enabledNFC: []byte{0x00, 0x20},
+	})
+	require.NoError(err)
+	require.Equal(iso7816.Version{Major: 5, Minor: 7, Patch: 1}, c.Firmware)
+
+	info, err := c.DeviceInfo()
+	require.NoError(err)
+	require.Equal(&yubikey.DeviceInfo{
+		Serial:     12345678,
+		Firmware:   iso7816.Version{Major: 5, Minor: 7, Patch: 1},
+		FormFactor: yubikey.FormFactorUSBCKeychain,
+		FIPS:       true,
+		Supported: map[yubikey.Interface]yubikey.Capability{
+			yubikey.InterfaceUSB: 0x023b,
+			yubikey.InterfaceNFC: 0x023b,
+		},
+		Enabled: map[yubikey.Interface]yubikey.Capability{
+			yubikey.InterfaceUSB: 0x023b,
+			yubikey.InterfaceNFC: yubikey.CapOATH,
+		},
+	}, info)
+
+	require.Equal("otp,u2f,openpgp,piv,oath,fido2", info.Supported[yubikey.InterfaceUSB].String())
+	require.Equal("USB-C Keychain", info.FormFactor.String())
+}
+
+func TestParseCapability(t *testing.T) {
+	require := require.New(t)
+
+	c, err := yubikey.ParseCapability("PIV, oath")
+	require.NoError(err)
+	require.Equal(yubikey.CapPIV|yubikey.CapOATH, c)
+
+	_, err = yubikey.ParseCapability("piv,smtp")
+	require.ErrorIs(err, yubikey.ErrUnknownApplication)
+
+	require.Equal("otp,0x8000", (yubikey.CapOTP | 0x8000).String())
+}
+
+func TestPolicy(t *testing.T) {
+	require := require.New(t)
+
+	mc := &managementCard{
+		enabledUSB: []byte{0x00, 0x21}, // OTP, OATH
+		enabledNFC: []byte{0x00, 0x20}, // OATH
+	}
+
+	c, err := yubikey.NewCard(mc)
+	require.NoError(err)
+
+	p := &yubikey.Policy{
+		Require: map[yubikey.Interface]yubikey.Capability{
+			yubikey.InterfaceUSB: yubikey.CapPIV,
+		},
+		Forbid: map[yubikey.Interface]yubikey.Capability{
+			yubikey.InterfaceUSB: yubikey.CapOTP,
+			yubikey.InterfaceNFC: yubikey.CapOTP,
+		},
+	}
+
+	info, err := c.DeviceInfo()
+	require.NoError(err)
+
+	err = p.Check(info)
+	require.ErrorIs(err, yubikey.ErrPolicyViolation)
+	require.ErrorContains(err, "piv must be enabled via usb")
+	require.ErrorContains(err, "otp must be disabled via usb")
+
+	info, err = p.Enforce(c, nil)
+	require.NoError(err)
+	require.NoError(p.Check(info))
+
+	// Only the USB interface is changed and the YubiKey reboots
+	require.Equal([][]byte{{0x0c, 0x00, 0x03, 0x02, 0x00, 0x30}}, mc.writes)
+
+	info, err = c.DeviceInfo()
+	require.NoError(err)
+	require.NoError(p.Check(info))
+
+	// Nothing is written if the policy is satisfied
+	_, err = p.Enforce(c, nil)
+	require.NoError(err)
+	require.Len(mc.writes, 1)
+}
+
+func TestPolicyLocked(t *testing.T) {
+	require := require.New(t)
+
+	lockCode := bytes.Repeat([]byte{0x11}, 16)
+
+	c, err := yubikey.NewCard(&managementCard{
+		enabledUSB: []byte{0x00, 0x01},
+		enabledNFC: []byte{0x00, 0x01},
+		lockCode:   lockCode,
+	})
+	require.NoError(err)
+
+	p := &yubikey.Policy{
+		Require: map[yubikey.Interface]yubikey.Capability{
+			yubikey.InterfaceNFC: yubikey.CapPIV,
+		},
+	}
+
+	_, err = p.Enforce(c, nil)
+	require.ErrorIs(err, yubikey.ErrLocked)
+
+	info, err := p.Enforce(c, lockCode)
+	require.NoError(err)
+	require.True(info.Locked)
+	require.Equal(yubikey.CapOTP|yubikey.CapPIV, info.Enabled[yubikey.InterfaceNFC])
+
+	// Applications which the device does not support can not be required
+	p.Require[yubikey.InterfaceUSB] = yubikey.CapHSMAuth
+
+	_, err = p.Enforce(c, lockCode)
+	require.ErrorIs(err, yubikey.ErrUnsupportedApplication)
+}
@@ -0,0 +1,245 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package yubiotp
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DefaultURL is the endpoint of the YubiCloud validation service.
+const DefaultURL = "https://api.yubico.com/wsapi/2.0/verify"
+
+// Status is the result of a validation request.
+type Status string
+
+const (
+	StatusOK                  Status = "OK"
+	StatusBadOTP              Status = "BAD_OTP"
+	StatusReplayedOTP         Status = "REPLAYED_OTP"
+	StatusBadSignature        Status = "BAD_SIGNATURE"
+	StatusMissingParameter    Status = "MISSING_PARAMETER"
+	StatusNoSuchClient        Status = "NO_SUCH_CLIENT"
+	StatusOperationNotAllowed Status = "OPERATION_NOT_ALLOWED"
+	StatusBackendError        Status = "BACKEND_ERROR"
+	StatusNotEnoughAnswers    Status = "NOT_ENOUGH_ANSWERS"
+	StatusReplayedRequest     Status = "REPLAYED_REQUEST"
+)
+
+// Temporary reports whether another validation server might succeed.
+func (s Status) Temporary() bool {
+	return s == StatusBackendError || s == StatusNotEnoughAnswers
+}
+
+// Response is the answer of a validation server.
+type Response struct {
+	OTP    string
+	Nonce  string
+	Status Status
+
+	// SyncLevel is the percentage of servers which synchronized the counter.
+	SyncLevel int
+
+	// The internal counters and timestamp of the OTP.
+	// They are only returned for successful validations.
+	Timestamp      uint32
+	SessionCounter uint8
+	UseCounter     uint16
+}
+
+// Client validates OTPs against YubiCloud or a self-hosted yubikey-val server.
+type Client struct {
+	// ID is the client ID issued with the API key.
+	ID string
+
+	// Key is the decoded API key which signs requests and responses.
+	// Signatures are neither created nor checked if it is empty.
+	Key []byte
+
+	// URLs are the validation servers which are tried in order.
+	URLs []string
+
+	HTTPClient *http.Client
+}
+
+// NewClient creates a client for YubiCloud.
+func NewClient(id string, key []byte) *Client {
+	return &Client{
+		ID:         id,
+		Key:        key,
+		URLs:       []string{DefaultURL},
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+// Verify validates an OTP.
+// Servers are tried in order until one of them gives a definite answer.
+// The returned error wraps ErrRejected if the OTP is not valid.
+func (c *Client) Verify(ctx context.Context, otp string) (*Response, error) {
+	if _, _, err := SplitOTP(otp); err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	params := url.Values{
+		"id":        {c.ID},
+		"otp":       {otp},
+		"nonce":     {hex.EncodeToString(nonce)},
+		"timestamp": {"1"},
+	}
+
+	if len(c.Key) > 0 {
+		params.Set("h", c.sign(params))
+	}
+
+	var errs []error
+
+	for _, u := range c.URLs {
+		resp, err := c.request(ctx, u, params)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", u, err))
+			continue
+		}
+
+		if resp.Status.Temporary() {
+			errs = append(errs, fmt.Errorf("%s: %s", u, resp.Status))
+			continue
+		}
+
+		if resp.Status != StatusOK {
+			return resp, fmt.Errorf("%w: %s", ErrRejected, resp.Status)
+		}
+
+		return resp, nil
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+func (c *Client) request(ctx context.Context, u string, params url.Values) (*Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status: %s", resp.Status)
+	}
+
+	values, err := parseResponse(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(c.Key) > 0 && !hmac.Equal([]byte(values.Get("h")), []byte(c.sign(values))) {
+		return nil, ErrInvalidSignature
+	}
+
+	r := &Response{
+		OTP:    values.Get("otp"),
+		Nonce:  values.Get("nonce"),
+		Status: Status(values.Get("status")),
+	}
+
+	// Responses which do not echo our request are not related to the OTP
+	if r.Status != StatusBadSignature && r.Status != StatusMissingParameter && r.Status != StatusNoSuchClient {
+		if r.OTP != params.Get("otp") || r.Nonce != params.Get("nonce") {
+			return nil, fmt.Errorf("%w: OTP or nonce mismatch", ErrInvalidSignature)
+		}
+	}
+
+	if sl := values.Get("sl"); sl != "" {
+		if r.SyncLevel, err = strconv.Atoi(sl); err != nil {
+			return nil, fmt.Errorf("invalid sync level: %w", err)
+		}
+	}
+
+	for _, f := range []struct {
+		name string
+		bits int
+		set  func(uint64)
+	}{
+		{"timestamp", 24, func(v uint64) { r.Timestamp = uint32(v) }},
+		{"sessioncounter", 8, func(v uint64) { r.SessionCounter = uint8(v) }},
+		{"sessionuse", 16, func(v uint64) { r.UseCounter = uint16(v) }},
+	} {
+		if s := values.Get(f.name); s != "" {
+			v, err := strconv.ParseUint(s, 10, f.bits)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", f.name, err)
+			}
+
+			f.set(v)
+		}
+	}
+
+	return r, nil
+}
+
+// sign calculates the signature over the alphabetically sorted parameters except h.
+func (c *Client) sign(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		if k != "h" {
+			keys = append(keys, k)
+		}
+	}
+
+	slices.Sort(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+values.Get(k))
+	}
+
+	mac := hmac.New(sha1.New, c.Key)
+	mac.Write([]byte(strings.Join(pairs, "&")))
+
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parseResponse parses the key=value lines of a validation response.
+func parseResponse(r io.Reader) (url.Values, error) {
+	values := url.Values{}
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("malformed response line: %q", line)
+		}
+
+		values.Set(k, v)
+	}
+
+	return values, scanner.Err()
+}
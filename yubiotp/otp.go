@@ -0,0 +1,239 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package yubiotp verifies classic Yubico OTPs either locally with the
+// AES key of a token or remotely via the YubiCloud validation protocol.
+//
+// See: https://developers.yubico.com/OTP/OTPs_Explained.html
+// See: https://developers.yubico.com/yubikey-val/Validation_Protocol_V2.0.html
+package yubiotp
+
+import (
+	"crypto/aes"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"cunicu.li/hawkes/verify"
+)
+
+var (
+	ErrMalformedOTP     = errors.New("malformed OTP")
+	ErrInvalidOTP       = errors.New("invalid OTP")
+	ErrInvalidKey       = errors.New("invalid AES key")
+	ErrInvalidSignature = errors.New("invalid response signature")
+	ErrRejected         = errors.New("OTP rejected")
+)
+
+const (
+	// TokenLength is the length of the encrypted part of an OTP in ModHex characters.
+	TokenLength = 32
+
+	// MaxPublicIDLength is the maximum length of the public ID prefix in ModHex characters.
+	MaxPublicIDLength = 16
+
+	crcResidual = 0xf0b8
+)
+
+const modHexAlphabet = "cbdefghijklnrtuv"
+
+// ModHexEncode encodes bytes in the keyboard layout independent ModHex alphabet.
+func ModHexEncode(b []byte) string {
+	var sb strings.Builder
+
+	for _, c := range b {
+		sb.WriteByte(modHexAlphabet[c>>4])
+		sb.WriteByte(modHexAlphabet[c&0xf])
+	}
+
+	return sb.String()
+}
+
+// ModHexDecode decodes a ModHex string.
+func ModHexDecode(s string) ([]byte, error) {
+	if len(s)%2 != 0 {
+		return nil, fmt.Errorf("%w: odd length", ErrMalformedOTP)
+	}
+
+	b := make([]byte, len(s)/2)
+
+	for i := range len(s) {
+		n := strings.IndexByte(modHexAlphabet, s[i])
+		if n < 0 {
+			return nil, fmt.Errorf("%w: invalid character %q", ErrMalformedOTP, s[i])
+		}
+
+		b[i/2] = b[i/2]<<4 | byte(n)
+	}
+
+	return b, nil
+}
+
+// Token is the decrypted content of a Yubico OTP.
+type Token struct {
+	PublicID       string
+	PrivateID      [6]byte
+	UseCounter     uint16
+	Timestamp      uint32
+	SessionCounter uint8
+	Random         uint16
+}
+
+// Counter combines the use and session counters to a value which increases with every OTP.
+func (t *Token) Counter() uint32 {
+	return uint32(t.UseCounter)<<8 | uint32(t.SessionCounter)
+}
+
+// SplitOTP separates an OTP into its public ID and encrypted token.
+func SplitOTP(otp string) (publicID, token string, err error) {
+	otp = strings.ToLower(strings.TrimSpace(otp))
+
+	if len(otp) < TokenLength || len(otp) > TokenLength+MaxPublicIDLength {
+		return "", "", fmt.Errorf("%w: invalid length %d", ErrMalformedOTP, len(otp))
+	} else if !isModHex(otp) {
+		return "", "", fmt.Errorf("%w: invalid character", ErrMalformedOTP)
+	}
+
+	idx := len(otp) - TokenLength
+
+	return otp[:idx], otp[idx:], nil
+}
+
+// Decode decrypts an OTP with the AES-128 key of the token and checks its CRC.
+func Decode(otp string, key []byte) (*Token, error) {
+	publicID, token, err := SplitOTP(otp)
+	if err != nil {
+		return nil, err
+	}
+
+	ct, err := ModHexDecode(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(key) != aes.BlockSize {
+		return nil, fmt.Errorf("%w: expected %d bytes", ErrInvalidKey, aes.BlockSize)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidKey, err)
+	}
+
+	pt := make([]byte, aes.BlockSize)
+	block.Decrypt(pt, ct)
+
+	if crc16(pt) != crcResidual {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidOTP)
+	}
+
+	t := &Token{
+		PublicID:       publicID,
+		UseCounter:     binary.LittleEndian.Uint16(pt[6:]),
+		Timestamp:      uint32(pt[8]) | uint32(pt[9])<<8 | uint32(pt[10])<<16,
+		SessionCounter: pt[11],
+		Random:         binary.LittleEndian.Uint16(pt[12:]),
+	}
+
+	copy(t.PrivateID[:], pt[:6])
+
+	return t, nil
+}
+
+// Encode encrypts the token with the AES-128 key to an OTP as emitted by a YubiKey.
+func (t *Token) Encode(key []byte) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidKey, err)
+	}
+
+	pt := make([]byte, 0, aes.BlockSize)
+	pt = append(pt, t.PrivateID[:]...)
+	pt = binary.LittleEndian.AppendUint16(pt, t.UseCounter)
+	pt = append(pt, byte(t.Timestamp), byte(t.Timestamp>>8), byte(t.Timestamp>>16))
+	pt = append(pt, t.SessionCounter)
+	pt = binary.LittleEndian.AppendUint16(pt, t.Random)
+	pt = binary.LittleEndian.AppendUint16(pt, ^crc16(pt))
+
+	ct := make([]byte, aes.BlockSize)
+	block.Encrypt(ct, pt)
+
+	return t.PublicID + ModHexEncode(ct), nil
+}
+
+// crc16 is the CRC of ISO 13239 used by YubiKeys.
+func crc16(b []byte) uint16 {
+	crc := uint16(0xffff)
+
+	for _, c := range b {
+		crc ^= uint16(c)
+
+		for range 8 {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0x8408
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+
+	return crc
+}
+
+// Validator verifies OTPs locally and tracks the counters of tokens by their public ID to reject replays.
+type Validator struct {
+	Store verify.Store
+
+	mu sync.Mutex
+}
+
+// NewValidator creates a validator.
+// A verify.MemoryStore is used if the store is nil.
+func NewValidator(store verify.Store) *Validator {
+	if store == nil {
+		store = verify.NewMemoryStore()
+	}
+
+	return &Validator{
+		Store: store,
+	}
+}
+
+// Validate decodes the OTP with the AES key of the token and checks its private ID and counter.
+func (v *Validator) Validate(otp string, key []byte, privateID []byte) (*Token, error) {
+	t, err := Decode(otp, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare(t.PrivateID[:], privateID) != 1 {
+		return nil, fmt.Errorf("%w: private ID mismatch", ErrInvalidOTP)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	state, err := v.Store.Load(t.PublicID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state: %w", err)
+	}
+
+	if uint64(t.Counter()) < state.Counter {
+		return nil, verify.ErrReplayed
+	}
+
+	state.Counter = uint64(t.Counter()) + 1
+
+	if err := v.Store.Save(t.PublicID, state); err != nil {
+		return nil, fmt.Errorf("failed to save state: %w", err)
+	}
+
+	return t, nil
+}
+
+func isModHex(s string) bool {
+	return strings.Trim(s, modHexAlphabet) == ""
+}
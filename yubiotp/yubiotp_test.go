@@ -0,0 +1,205 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package yubiotp_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"cunicu.li/hawkes/verify"
+	"cunicu.li/hawkes/yubiotp"
+)
+
+func TestModHex(t *testing.T) {
+	require := require.New(t)
+
+	b, err := hex.DecodeString("0123456789abcdef")
+	require.NoError(err)
+	require.Equal("cbdefghijklnrtuv", yubiotp.ModHexEncode(b))
+
+	d, err := yubiotp.ModHexDecode("cbdefghijklnrtuv")
+	require.NoError(err)
+	require.Equal(b, d)
+
+	_, err = yubiotp.ModHexDecode("cba")
+	require.ErrorIs(err, yubiotp.ErrMalformedOTP)
+
+	_, err = yubiotp.ModHexDecode("cbax")
+	require.ErrorIs(err, yubiotp.ErrMalformedOTP)
+}
+
+// See: https://developers.yubico.com/OTP/OTPs_Explained.html
+func TestDecode(t *testing.T) {
+	require := require.New(t)
+
+	key, err := hex.DecodeString("ecde18dbe76fbd0c33330f1c354871db")
+	require.NoError(err)
+
+	otp := "dteffujehknhfjbrjnlnldnhcujvddbikngjrtgh"
+
+	tok, err := yubiotp.Decode(otp, key)
+	require.NoError(err)
+	require.Equal("dteffuje", tok.PublicID)
+	require.Equal("8792ebfe26cc", hex.EncodeToString(tok.PrivateID[:]))
+	require.EqualValues(19, tok.UseCounter)
+	require.EqualValues(17, tok.SessionCounter)
+
+	enc, err := tok.Encode(key)
+	require.NoError(err)
+	require.Equal(otp, enc)
+
+	key[0] ^= 1
+	_, err = yubiotp.Decode(otp, key)
+	require.ErrorIs(err, yubiotp.ErrInvalidOTP)
+
+	_, err = yubiotp.Decode("dteffujeabc", key)
+	require.ErrorIs(err, yubiotp.ErrMalformedOTP)
+}
+
+func TestValidator(t *testing.T) {
+	require := require.New(t)
+
+	key := []byte("0123456789abcdef")
+	tok := &yubiotp.Token{
+		PublicID:   "vvccccccbbbb",
+		PrivateID:  [6]byte{1, 2, 3, 4, 5, 6},
+		UseCounter: 5,
+	}
+
+	v := yubiotp.NewValidator(nil)
+
+	otp1, err := tok.Encode(key)
+	require.NoError(err)
+
+	tok.SessionCounter++
+	otp2, err := tok.Encode(key)
+	require.NoError(err)
+
+	_, err = v.Validate(otp2, key, tok.PrivateID[:])
+	require.NoError(err)
+
+	// Older OTPs are rejected after a newer one has been used
+	_, err = v.Validate(otp1, key, tok.PrivateID[:])
+	require.ErrorIs(err, verify.ErrReplayed)
+
+	_, err = v.Validate(otp2, key, tok.PrivateID[:])
+	require.ErrorIs(err, verify.ErrReplayed)
+
+	_, err = v.Validate(otp2, key, []byte{0, 0, 0, 0, 0, 0})
+	require.ErrorIs(err, yubiotp.ErrInvalidOTP)
+}
+
+func sign(key []byte, params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+
+	slices.Sort(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+params[k])
+	}
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write([]byte(strings.Join(pairs, "&")))
+
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func validationServer(t *testing.T, key []byte, status yubiotp.Status) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+
+		req := map[string]string{}
+		for k := range q {
+			if k != "h" {
+				req[k] = q.Get(k)
+			}
+		}
+
+		status := status
+		if sign(key, req) != q.Get("h") {
+			status = yubiotp.StatusBadSignature
+		}
+
+		resp := map[string]string{
+			"t":      "2024-01-01T00:00:00Z0000",
+			"otp":    q.Get("otp"),
+			"nonce":  q.Get("nonce"),
+			"sl":     "100",
+			"status": string(status),
+		}
+
+		if status == yubiotp.StatusOK {
+			resp["timestamp"] = "49712"
+			resp["sessioncounter"] = "17"
+			resp["sessionuse"] = "19"
+		}
+
+		resp["h"] = sign(key, resp)
+
+		for k, v := range resp {
+			fmt.Fprintf(w, "%s=%s\r\n", k, v)
+		}
+
+		t.Logf("Answered with %s", status)
+	}))
+}
+
+func TestClient(t *testing.T) {
+	require := require.New(t)
+
+	key := []byte("api-key")
+	otp := "dteffujehknhfjbrjnlnldnhcujvddbikngjrtgh"
+
+	down := validationServer(t, key, yubiotp.StatusBackendError)
+	defer down.Close()
+
+	ok := validationServer(t, key, yubiotp.StatusOK)
+	defer ok.Close()
+
+	replayed := validationServer(t, key, yubiotp.StatusReplayedOTP)
+	defer replayed.Close()
+
+	c := yubiotp.NewClient("1", key)
+
+	// Temporary failures fall over to the next server
+	c.URLs = []string{down.URL, ok.URL}
+
+	resp, err := c.Verify(context.Background(), otp)
+	require.NoError(err)
+	require.Equal(yubiotp.StatusOK, resp.Status)
+	require.Equal(100, resp.SyncLevel)
+	require.EqualValues(19, resp.UseCounter)
+	require.EqualValues(17, resp.SessionCounter)
+
+	c.URLs = []string{replayed.URL, ok.URL}
+
+	resp, err = c.Verify(context.Background(), otp)
+	require.ErrorIs(err, yubiotp.ErrRejected)
+	require.Equal(yubiotp.StatusReplayedOTP, resp.Status)
+
+	// Responses with a wrong signature are ignored
+	c.Key = []byte("other-key")
+	c.URLs = []string{ok.URL}
+
+	_, err = c.Verify(context.Background(), otp)
+	require.ErrorIs(err, yubiotp.ErrInvalidSignature)
+
+	_, err = c.Verify(context.Background(), "invalid")
+	require.ErrorIs(err, yubiotp.ErrMalformedOTP)
+}